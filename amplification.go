@@ -0,0 +1,98 @@
+package zgrab2
+
+import "net"
+
+// ethernetMTU is the largest IP packet that can cross a typical path without
+// being fragmented.
+const ethernetMTU = 1500
+
+// udpHeaderLen is the length of a UDP header.
+const udpHeaderLen = 8
+
+// Amplification describes the traffic of a UDP scan, for estimating how
+// useful the service would be in a reflection attack. Byte counts are of UDP
+// payloads, not including IP or UDP headers.
+type Amplification struct {
+	// RequestBytes is the number of bytes sent to the target.
+	RequestBytes int `json:"request_bytes"`
+
+	// RequestPackets is the number of datagrams sent to the target.
+	RequestPackets int `json:"request_packets"`
+
+	// ResponseBytes is the number of bytes received from the target.
+	ResponseBytes int `json:"response_bytes"`
+
+	// ResponsePackets is the number of datagrams received from the target.
+	ResponsePackets int `json:"response_packets"`
+
+	// Ratio is ResponseBytes / RequestBytes, the bandwidth amplification
+	// factor.
+	Ratio float64 `json:"ratio"`
+
+	// Fragmented is true if a response datagram was too large to fit in a
+	// 1500-byte IP packet, so it must have been fragmented in transit.
+	Fragmented bool `json:"fragmented,omitempty"`
+}
+
+// datagramCounts accumulates the datagrams sent and received by the UDP
+// connections of a single scan.
+type datagramCounts struct {
+	sent          int
+	sentBytes     int
+	received      int
+	receivedBytes int
+	fragmented    bool
+}
+
+// recordSent records a datagram of n bytes sent to the target.
+func (d *datagramCounts) recordSent(n int) {
+	d.sent++
+	d.sentBytes += n
+}
+
+// recordReceived records a datagram of n bytes received from remote.
+func (d *datagramCounts) recordReceived(remote net.Addr, n int) {
+	d.received++
+	d.receivedBytes += n
+	ipHeaderLen := 20
+	if addressFamily(remote) == "ipv6" {
+		ipHeaderLen = 40
+	}
+	if ipHeaderLen+udpHeaderLen+n > ethernetMTU {
+		d.fragmented = true
+	}
+}
+
+// amplification returns the Amplification for the datagrams counted, or nil
+// if none were sent.
+func (d *datagramCounts) amplification() *Amplification {
+	if d.sent == 0 {
+		return nil
+	}
+	ret := &Amplification{
+		RequestBytes:    d.sentBytes,
+		RequestPackets:  d.sent,
+		ResponseBytes:   d.receivedBytes,
+		ResponsePackets: d.received,
+		Fragmented:      d.fragmented,
+	}
+	if d.sentBytes > 0 {
+		ret.Ratio = float64(d.receivedBytes) / float64(d.sentBytes)
+	}
+	return ret
+}
+
+// recordDatagram counts a datagram of n bytes sent or received on the UDP
+// connection c, if it was opened through the framework.
+func (c *TimeoutConnection) recordDatagram(sent bool, n int) {
+	if c.log == nil || !c.datagram || n < 0 {
+		return
+	}
+	c.log.mutex.Lock()
+	defer c.log.mutex.Unlock()
+	if sent {
+		c.log.datagrams.recordSent(n)
+	} else {
+		c.log.datagrams.recordReceived(c.RemoteAddr(), n)
+	}
+}
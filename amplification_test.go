@@ -0,0 +1,62 @@
+package zgrab2
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestDatagramAmplification(t *testing.T) {
+	server, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	go func() {
+		buf := make([]byte, 64)
+		_, from, err := server.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		server.WriteToUDP(make([]byte, 600), from)
+		server.WriteToUDP(make([]byte, 1600), from)
+	}()
+
+	udp, err := net.DialUDP("udp4", nil, server.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := NewTimeoutConnection(nil, udp, time.Second, 0, 0, 64*1024)
+	defer conn.Close()
+	target := ScanTarget{IP: net.IPv4(127, 0, 0, 1), log: new(scanLog)}
+	target.RecordConnection(conn)
+
+	if _, err := conn.Write(make([]byte, 50)); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 2048)
+	for i := 0; i < 2; i++ {
+		if _, err := conn.Read(buf); err != nil {
+			t.Fatal(err)
+		}
+	}
+	amp := target.log.datagrams.amplification()
+	expected := Amplification{RequestBytes: 50, RequestPackets: 1, ResponseBytes: 2200, ResponsePackets: 2, Ratio: 44, Fragmented: true}
+	if amp == nil || *amp != expected {
+		t.Errorf("expected %+v, got %+v", expected, amp)
+	}
+}
+
+func TestNoAmplificationForTCP(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	conn := NewTimeoutConnection(nil, client, time.Second, 0, 0, 1024)
+	target := ScanTarget{log: new(scanLog)}
+	target.RecordConnection(conn)
+	go server.Read(make([]byte, 16))
+	conn.Write([]byte("hello"))
+	if amp := target.log.datagrams.amplification(); amp != nil {
+		t.Errorf("expected no amplification for a stream connection, got %+v", amp)
+	}
+}
@@ -0,0 +1,274 @@
+package zgrab2
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BenchCommand contains the command line options for benchmarking modules
+// against local simulators (zgrab2 bench).
+type BenchCommand struct {
+	Modules     string        `long:"modules" default:"http,ftp,smtp,pop3,imap" description:"Comma-separated modules to benchmark, among those with a built-in simulator (http, ftp, smtp, pop3, imap)"`
+	Concurrency string        `long:"concurrency" default:"1,10,100" description:"Comma-separated numbers of senders to benchmark each module at"`
+	Scans       int           `long:"scans" default:"5000" description:"Number of scans to measure at each concurrency"`
+	Warmup      int           `long:"warmup" default:"500" description:"Number of scans run before measuring, to warm up the scanner, connection handling and the runtime"`
+	Timeout     time.Duration `long:"timeout" default:"5s" description:"Timeout of each scan"`
+
+	concurrency []int
+}
+
+// Validate checks the benchmark options.
+func (x *BenchCommand) Validate(args []string) error {
+	for _, name := range splitList(x.Modules) {
+		if benchSimulators[name] == nil {
+			return fmt.Errorf("no simulator for module %s", name)
+		}
+		if GetModule(name) == nil {
+			return fmt.Errorf("unknown module %s", name)
+		}
+	}
+	x.concurrency = nil
+	for _, level := range splitList(x.Concurrency) {
+		n, err := strconv.Atoi(level)
+		if err != nil || n <= 0 {
+			return fmt.Errorf("invalid concurrency %q", level)
+		}
+		x.concurrency = append(x.concurrency, n)
+	}
+	if len(x.concurrency) == 0 {
+		return errors.New("no concurrency given")
+	}
+	if x.Scans <= 0 {
+		return fmt.Errorf("scans must be positive, given %d", x.Scans)
+	}
+	if x.Warmup < 0 {
+		return fmt.Errorf("warmup must be non-negative, given %d", x.Warmup)
+	}
+	return nil
+}
+
+// Help returns a usage string that will be output at the command line
+func (x *BenchCommand) Help() string {
+	return "Runs each module against a built-in local simulator of its protocol at each concurrency, and prints a line of JSON giving its throughput, allocations and latency percentiles."
+}
+
+// splitList splits a comma-separated list, dropping empty entries.
+func splitList(s string) []string {
+	var ret []string
+	for _, entry := range strings.Split(s, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			ret = append(ret, entry)
+		}
+	}
+	return ret
+}
+
+// BenchLatency gives percentiles of the total time of the scans measured, in
+// microseconds.
+type BenchLatency struct {
+	P50 int64 `json:"p50_us"`
+	P90 int64 `json:"p90_us"`
+	P99 int64 `json:"p99_us"`
+	Max int64 `json:"max_us"`
+}
+
+// BenchResult is the outcome of benchmarking a module at a concurrency.
+type BenchResult struct {
+	Module      string `json:"module"`
+	Concurrency int    `json:"concurrency"`
+	Scans       int    `json:"scans"`
+
+	// Statuses counts the scans measured by status; against the simulator,
+	// all should succeed.
+	Statuses map[ScanStatus]int `json:"statuses"`
+
+	Duration       float64 `json:"duration_s"`
+	ScansPerSecond float64 `json:"scans_per_second"`
+
+	// AllocsPerScan and BytesPerScan are the heap allocations made while
+	// measuring, divided by the number of scans. They include those of the
+	// simulator, which runs in the same process.
+	AllocsPerScan float64 `json:"allocs_per_scan"`
+	BytesPerScan  float64 `json:"bytes_per_scan"`
+
+	Latency BenchLatency `json:"latency"`
+}
+
+// benchSimulators maps the modules that can be benchmarked to a function
+// serving their protocol on a listener until it is closed.
+var benchSimulators = map[string]func(net.Listener){
+	"http": simulateHTTP,
+	"ftp":  simulateLines("220 zgrab2 bench FTP ready", "500 Unknown command", "QUIT"),
+	"smtp": simulateLines("220 bench.invalid ESMTP zgrab2 bench", "250 OK", "QUIT"),
+	"pop3": simulateLines("+OK zgrab2 bench POP3 ready", "+OK", "QUIT"),
+	"imap": simulateLines("* OK zgrab2 bench IMAP ready", "OK", "LOGOUT"),
+}
+
+// simulateHTTP answers every request with a small HTML page.
+func simulateHTTP(listener net.Listener) {
+	page := []byte("<html><head><title>zgrab2 bench</title></head><body>It works!</body></html>")
+	http.Serve(listener, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Header().Set("Server", "zgrab2-bench")
+		w.Write(page)
+	}))
+}
+
+// simulateLines serves a line-based protocol: it sends the greeting, answers
+// each line received with the reply, and closes the connection after the
+// quit command.
+func simulateLines(greeting, reply, quit string) func(net.Listener) {
+	return func(listener net.Listener) {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				io.WriteString(conn, greeting+"\r\n")
+				reader := bufio.NewReader(conn)
+				for {
+					line, err := reader.ReadString('\n')
+					if err != nil {
+						return
+					}
+					fields := strings.Fields(line)
+					answer := reply
+					if len(fields) > 0 && reply == "OK" {
+						// IMAP replies are tagged.
+						answer = fields[0] + " OK"
+					}
+					io.WriteString(conn, answer+"\r\n")
+					if len(fields) > 0 && strings.EqualFold(fields[len(fields)-1], quit) {
+						return
+					}
+				}
+			}(conn)
+		}
+	}
+}
+
+// percentile returns the p-th percentile of the sorted values.
+func percentile(sorted []int64, p float64) int64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(float64(len(sorted))*p+0.5) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(sorted) {
+		i = len(sorted) - 1
+	}
+	return sorted[i]
+}
+
+// runScans scans the local address n times with the runner, and returns the
+// statuses and total times of the scans.
+func runScans(runner *Runner, n int) (map[ScanStatus]int, []int64) {
+	targets := make(chan ScanTarget)
+	go func() {
+		for i := 0; i < n; i++ {
+			targets <- ScanTarget{IP: net.ParseIP("127.0.0.1")}
+		}
+		close(targets)
+	}()
+	statuses := make(map[ScanStatus]int)
+	var latencies []int64
+	for grab := range runner.Run(targets) {
+		for _, res := range grab.Data {
+			statuses[res.Status]++
+			if res.Timing != nil {
+				latencies = append(latencies, res.Timing.Total)
+			}
+		}
+	}
+	return statuses, latencies
+}
+
+// benchModule benchmarks the module against the local simulator listening on
+// the port, at the given concurrency.
+func (x *BenchCommand) benchModule(name string, port uint, concurrency int) (*BenchResult, error) {
+	flags, err := NewModuleFlags(name)
+	if err != nil {
+		return nil, err
+	}
+	base := GetBaseFlags(flags)
+	if base == nil {
+		return nil, fmt.Errorf("%s: no base flags", name)
+	}
+	base.Port = port
+	base.AllDefaultPorts = false
+	base.Timeout = x.Timeout
+	runner := NewRunner(concurrency)
+	if _, err := runner.AddModule(name, flags); err != nil {
+		return nil, err
+	}
+	if x.Warmup > 0 {
+		runScans(runner, x.Warmup)
+	}
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+	statuses, latencies := runScans(runner, x.Scans)
+	duration := time.Since(start)
+	runtime.ReadMemStats(&after)
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	return &BenchResult{
+		Module:         name,
+		Concurrency:    concurrency,
+		Scans:          x.Scans,
+		Statuses:       statuses,
+		Duration:       duration.Seconds(),
+		ScansPerSecond: float64(x.Scans) / duration.Seconds(),
+		AllocsPerScan:  float64(after.Mallocs-before.Mallocs) / float64(x.Scans),
+		BytesPerScan:   float64(after.TotalAlloc-before.TotalAlloc) / float64(x.Scans),
+		Latency: BenchLatency{
+			P50: percentile(latencies, 0.5),
+			P90: percentile(latencies, 0.9),
+			P99: percentile(latencies, 0.99),
+			Max: percentile(latencies, 1),
+		},
+	}, nil
+}
+
+// Bench benchmarks each module at each concurrency, writing each result as a
+// line of JSON.
+func (x *BenchCommand) Bench(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	for _, name := range splitList(x.Modules) {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return err
+		}
+		go benchSimulators[name](listener)
+		port := uint(listener.Addr().(*net.TCPAddr).Port)
+		for _, concurrency := range x.concurrency {
+			result, err := x.benchModule(name, port, concurrency)
+			if err != nil {
+				listener.Close()
+				return err
+			}
+			if err := enc.Encode(result); err != nil {
+				listener.Close()
+				return err
+			}
+		}
+		listener.Close()
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package zgrab2
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"testing"
+	"time"
+)
+
+type benchedFlags struct {
+	BaseFlags
+}
+
+func (f *benchedFlags) Validate(args []string) error { return nil }
+func (f *benchedFlags) Help() string                 { return "" }
+
+// benchedScanner reads the greeting of a line-based simulator, then quits.
+type benchedScanner struct {
+	echoScanner
+	flags *benchedFlags
+}
+
+func (s *benchedScanner) Init(flags ScanFlags) error {
+	s.flags = flags.(*benchedFlags)
+	return nil
+}
+func (s *benchedScanner) GetName() string { return s.flags.Name }
+func (s *benchedScanner) Scan(t ScanTarget) (ScanStatus, interface{}, error) {
+	conn, err := t.Open(&s.flags.BaseFlags)
+	if err != nil {
+		return TryGetScanStatus(err), nil, err
+	}
+	defer conn.Close()
+	greeting, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return TryGetScanStatus(err), nil, err
+	}
+	if _, err := io.WriteString(conn, "QUIT\r\n"); err != nil {
+		return TryGetScanStatus(err), nil, err
+	}
+	return SCAN_SUCCESS, greeting, nil
+}
+
+type benchedModule struct{}
+
+func (m *benchedModule) NewFlags() interface{} { return new(benchedFlags) }
+func (m *benchedModule) NewScanner() Scanner   { return new(benchedScanner) }
+
+func TestBench(t *testing.T) {
+	if _, err := AddCommand("benched", "Benched", "A module to benchmark", 21, new(benchedModule)); err != nil {
+		t.Fatal(err)
+	}
+	defer delete(modules, "benched")
+	benchSimulators["benched"] = simulateLines("220 ready", "500 Unknown command", "QUIT")
+	defer delete(benchSimulators, "benched")
+
+	bench := &BenchCommand{Modules: "benched", Concurrency: "1,4", Scans: 50, Warmup: 5, Timeout: 5 * time.Second}
+	if err := bench.Validate(nil); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := bench.Bench(&out); err != nil {
+		t.Fatal(err)
+	}
+	dec := json.NewDecoder(&out)
+	for _, concurrency := range []int{1, 4} {
+		var result BenchResult
+		if err := dec.Decode(&result); err != nil {
+			t.Fatal(err)
+		}
+		if result.Module != "benched" || result.Concurrency != concurrency || result.Scans != 50 {
+			t.Errorf("unexpected result %+v", result)
+		}
+		if result.Statuses[SCAN_SUCCESS] != 50 {
+			t.Errorf("expected 50 successes at concurrency %d, got %v", concurrency, result.Statuses)
+		}
+		if result.ScansPerSecond <= 0 || result.AllocsPerScan <= 0 || result.BytesPerScan <= 0 {
+			t.Errorf("expected positive rates, got %+v", result)
+		}
+		latency := result.Latency
+		if latency.P50 > latency.P90 || latency.P90 > latency.P99 || latency.P99 > latency.Max || latency.Max <= 0 {
+			t.Errorf("unexpected latency percentiles %+v", latency)
+		}
+	}
+	if dec.More() {
+		t.Error("expected a result per concurrency")
+	}
+
+	for _, invalid := range []*BenchCommand{
+		{Modules: "nope", Concurrency: "1", Scans: 1},
+		{Modules: "benched", Concurrency: "0", Scans: 1},
+		{Modules: "benched", Concurrency: "1", Scans: 0},
+	} {
+		if err := invalid.Validate(nil); err == nil {
+			t.Errorf("expected an error validating %+v", invalid)
+		}
+	}
+}
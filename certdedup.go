@@ -0,0 +1,203 @@
+package zgrab2
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+// serverCertificatesKey is the key of the certificates the server sent, in
+// zcrypto's TLS handshake log.
+const serverCertificatesKey = "server_certificates"
+
+// certDeduplicator implements --certificates-file, writing each certificate
+// in the results to the file once, and replacing the certificates in the
+// results with references to them by SHA-256 fingerprint. When many targets
+// share certificates (e.g. behind a CDN), this shrinks the output
+// dramatically.
+type certDeduplicator struct {
+	mutex sync.Mutex
+	file  *os.File
+	out   *bufio.Writer
+	seen  map[[sha256.Size]byte]struct{}
+
+	// unique counts the certificates written.
+	unique uint64
+}
+
+var certDedup *certDeduplicator
+
+// newCertDeduplicator returns a certDeduplicator writing to the given file.
+func newCertDeduplicator(path string) (*certDeduplicator, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &certDeduplicator{
+		file: file,
+		out:  bufio.NewWriter(file),
+		seen: make(map[[sha256.Size]byte]struct{}),
+	}, nil
+}
+
+// dedup returns the encoded result (one or more lines) with the
+// certificates replaced by references, writing the ones not seen before to
+// the certificates file. The result is released if a new one is returned.
+func (d *certDeduplicator) dedup(result []byte) ([]byte, error) {
+	if !bytes.Contains(result, []byte(serverCertificatesKey)) {
+		return result, nil
+	}
+	var ret bytes.Buffer
+	for i, line := range bytes.Split(result, []byte("\n")) {
+		if i > 0 {
+			ret.WriteByte('\n')
+		}
+		if err := d.rewrite(&ret, line); err != nil {
+			return nil, err
+		}
+	}
+	ReleaseResult(result)
+	return ret.Bytes(), nil
+}
+
+// rewrite writes the JSON value to out, with the certificates in any
+// server_certificates objects within it replaced by references. Values not
+// containing certificates are copied as they are.
+func (d *certDeduplicator) rewrite(out *bytes.Buffer, value []byte) error {
+	if !bytes.Contains(value, []byte(serverCertificatesKey)) {
+		out.Write(value)
+		return nil
+	}
+	return forEachJSONElement(out, value, func(key string, element json.RawMessage) error {
+		if key == serverCertificatesKey {
+			return forEachJSONElement(out, element, func(key string, element json.RawMessage) error {
+				switch key {
+				case "certificate":
+					return d.reference(out, element)
+				case "chain":
+					return forEachJSONElement(out, element, func(_ string, cert json.RawMessage) error {
+						return d.reference(out, cert)
+					})
+				}
+				out.Write(element)
+				return nil
+			})
+		}
+		return d.rewrite(out, element)
+	})
+}
+
+// forEachJSONElement writes the JSON object or array to out, calling f to
+// write each of its values, with its key if it is an object. Values of other
+// types are copied as they are.
+func forEachJSONElement(out *bytes.Buffer, value []byte, f func(key string, element json.RawMessage) error) error {
+	decoder := json.NewDecoder(bytes.NewReader(value))
+	open, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+	delim, ok := open.(json.Delim)
+	if !ok {
+		out.Write(value)
+		return nil
+	}
+	out.WriteByte(byte(delim))
+	for i := 0; decoder.More(); i++ {
+		if i > 0 {
+			out.WriteByte(',')
+		}
+		var key string
+		if delim == '{' {
+			token, err := decoder.Token()
+			if err != nil {
+				return err
+			}
+			key, _ = token.(string)
+			encoded, _ := json.Marshal(key)
+			out.Write(encoded)
+			out.WriteByte(':')
+		}
+		var element json.RawMessage
+		if err := decoder.Decode(&element); err != nil {
+			return err
+		}
+		if err := f(key, element); err != nil {
+			return err
+		}
+	}
+	if delim == '{' {
+		out.WriteByte('}')
+	} else {
+		out.WriteByte(']')
+	}
+	return nil
+}
+
+// reference writes a reference to the certificate to out, writing the
+// certificate to the certificates file if it has not been seen before. A
+// certificate without its raw bytes or fingerprint is left as it is.
+func (d *certDeduplicator) reference(out *bytes.Buffer, cert json.RawMessage) error {
+	var fields struct {
+		Raw    []byte `json:"raw"`
+		Parsed *struct {
+			FingerprintSHA256 string `json:"fingerprint_sha256"`
+		} `json:"parsed"`
+	}
+	if err := json.Unmarshal(cert, &fields); err != nil {
+		return err
+	}
+	var fingerprint [sha256.Size]byte
+	if len(fields.Raw) > 0 {
+		fingerprint = sha256.Sum256(fields.Raw)
+	} else if fields.Parsed == nil || hex.DecodedLen(len(fields.Parsed.FingerprintSHA256)) != sha256.Size {
+		out.Write(cert)
+		return nil
+	} else if _, err := hex.Decode(fingerprint[:], []byte(fields.Parsed.FingerprintSHA256)); err != nil {
+		out.Write(cert)
+		return nil
+	}
+	ref := fmt.Sprintf(`{"fingerprint_sha256":"%x"`, fingerprint)
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if _, ok := d.seen[fingerprint]; !ok {
+		// The certificate's own fields follow the fingerprint.
+		line := append([]byte(ref), '}')
+		if body := bytes.TrimSpace(cert[1:]); len(body) > 1 {
+			line = append(append([]byte(ref), ','), body...)
+		}
+		if _, err := d.out.Write(append(line, '\n')); err != nil {
+			return err
+		}
+		d.seen[fingerprint] = struct{}{}
+		atomic.AddUint64(&d.unique, 1)
+	}
+	out.WriteString(ref)
+	out.WriteByte('}')
+	return nil
+}
+
+// close flushes and closes the certificates file.
+func (d *certDeduplicator) close() error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if err := d.out.Flush(); err != nil {
+		return err
+	}
+	return d.file.Close()
+}
+
+// GetUniqueCertificates returns the number of unique certificates written to
+// the --certificates-file.
+func GetUniqueCertificates() uint64 {
+	if certDedup == nil {
+		return 0
+	}
+	return atomic.LoadUint64(&certDedup.unique)
+}
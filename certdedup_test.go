@@ -0,0 +1,77 @@
+package zgrab2
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCertDeduplicator(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zgrab2-certs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "certs.json")
+	d, err := newCertDeduplicator(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	leaf := fmt.Sprintf("%x", sha256.Sum256([]byte("leaf")))
+	ca := fmt.Sprintf("%x", sha256.Sum256([]byte("ca")))
+	// "bGVhZg==" and "Y2E=" are the base64 encodings of "leaf" and "ca".
+	first := `{"ip":"192.0.2.1","data":{"https":{"result":{"tls":{"handshake_log":{"server_hello":{"version":771},"server_certificates":{"certificate":{"raw":"bGVhZg==","parsed":{"subject":"leaf"}},"chain":[{"raw":"Y2E=","parsed":{"subject":"ca"}}],"validation":{"browser_trusted":true}}}}}}}}`
+	second := `{"ip":"192.0.2.2","data":{"https":{"result":{"tls":{"handshake_log":{"server_certificates":{"certificate":{"raw":"bGVhZg=="},"chain":[]}}}},"body":"server_certificates"}}}`
+	plain := `{"ip":"192.0.2.3","data":{"http":{"status":"success"}}}`
+
+	got, err := d.dedup([]byte(first + "\n" + plain))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"ip":"192.0.2.1","data":{"https":{"result":{"tls":{"handshake_log":{"server_hello":{"version":771},"server_certificates":{"certificate":{"fingerprint_sha256":"` + leaf + `"},"chain":[{"fingerprint_sha256":"` + ca + `"}],"validation":{"browser_trusted":true}}}}}}}}` + "\n" + plain
+	if string(got) != expected {
+		t.Errorf("wrong result\n%s\nexpected\n%s", got, expected)
+	}
+	got, err = d.dedup([]byte(second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = `{"ip":"192.0.2.2","data":{"https":{"result":{"tls":{"handshake_log":{"server_certificates":{"certificate":{"fingerprint_sha256":"` + leaf + `"},"chain":[]}}}},"body":"server_certificates"}}}`
+	if string(got) != expected {
+		t.Errorf("wrong result\n%s\nexpected\n%s", got, expected)
+	}
+	if got, _ := d.dedup([]byte(plain)); string(got) != plain {
+		t.Errorf("result without certificates changed to %s", got)
+	}
+
+	if err := d.close(); err != nil {
+		t.Fatal(err)
+	}
+	written, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(written)), "\n")
+	expectedLines := []string{
+		`{"fingerprint_sha256":"` + leaf + `","raw":"bGVhZg==","parsed":{"subject":"leaf"}}`,
+		`{"fingerprint_sha256":"` + ca + `","raw":"Y2E=","parsed":{"subject":"ca"}}`,
+	}
+	if len(lines) != 2 || lines[0] != expectedLines[0] || lines[1] != expectedLines[1] {
+		t.Errorf("wrong certificates file:\n%s", written)
+	}
+	if d.unique != 2 {
+		t.Errorf("wrong unique count %d", d.unique)
+	}
+
+	// A certificate with neither its raw bytes nor a fingerprint is kept.
+	var out bytes.Buffer
+	if err := d.reference(&out, []byte(`{"parsed":{"subject":"x"}}`)); err != nil || out.String() != `{"parsed":{"subject":"x"}}` {
+		t.Errorf("unidentifiable certificate replaced with %s (%v)", out.String(), err)
+	}
+}
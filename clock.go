@@ -0,0 +1,89 @@
+package zgrab2
+
+import "time"
+
+// ServerTime is the time on the target's clock, as reported in a protocol
+// field.
+type ServerTime struct {
+	// Time is the time reported.
+	Time time.Time
+
+	// Source names the field it came from, e.g. "http_date".
+	Source string
+
+	// Resolution is the precision of the field, which truncates the time
+	// (e.g. a second for the HTTP Date header).
+	Resolution time.Duration
+}
+
+// ClockSkew compares the target's clock with the scanner's, in the same form
+// for every module, for clustering devices and spotting anomalies.
+type ClockSkew struct {
+	// Source names the protocol field the target's time came from.
+	Source string `json:"source"`
+
+	// ServerTime is the time reported by the target.
+	ServerTime string `json:"server_time"`
+
+	// Skew is the target's clock minus the scanner's, in milliseconds,
+	// taking the target's time to have been read halfway through the scan.
+	Skew int64 `json:"skew_ms"`
+
+	// Uncertainty bounds the error in Skew, in milliseconds: half the
+	// scan's duration, plus the resolution of the field.
+	Uncertainty int64 `json:"uncertainty_ms"`
+}
+
+// ClockScanner is implemented by scanners whose results include the time on
+// the target's clock. The framework compares it with the scanner's time, and
+// records the result in the scan response's clock_skew field.
+type ClockScanner interface {
+	Scanner
+
+	// ServerTime returns the target's time from the result of a scan (which
+	// may have failed part way), or nil if the result does not include it.
+	ServerTime(result interface{}) *ServerTime
+}
+
+// measureClockSkew returns the skew of the target's clock from the result,
+// if the scanner is a ClockScanner, for a scan that ran from start to end.
+func measureClockSkew(s Scanner, result interface{}, start, end time.Time) *ClockSkew {
+	c, ok := s.(ClockScanner)
+	if !ok || result == nil {
+		return nil
+	}
+	t := c.ServerTime(result)
+	if t == nil || t.Time.IsZero() {
+		return nil
+	}
+	// The field truncates the time, so on average the target's clock was
+	// half its resolution later.
+	middle := start.Add(end.Sub(start) / 2)
+	skew := t.Time.Add(t.Resolution / 2).Sub(middle)
+	uncertainty := end.Sub(start)/2 + t.Resolution/2
+	return &ClockSkew{
+		Source:      t.Source,
+		ServerTime:  t.Time.UTC().Format(time.RFC3339Nano),
+		Skew:        int64(skew / time.Millisecond),
+		Uncertainty: int64(uncertainty / time.Millisecond),
+	}
+}
+
+// TLSServerTime returns the time in the gmt_unix_time field of the
+// ServerHello random in the TLS log, which TLS 1.2 and older servers
+// traditionally filled in. Most servers now send random bytes instead, so
+// the time is only returned if it is within a day of the scanner's clock.
+func TLSServerTime(log *TLSLog) *ServerTime {
+	if log == nil || log.HandshakeLog == nil || log.HandshakeLog.ServerHello == nil {
+		return nil
+	}
+	random := log.HandshakeLog.ServerHello.Random
+	if len(random) < 4 {
+		return nil
+	}
+	t := time.Unix(int64(uint32(random[0])<<24|uint32(random[1])<<16|uint32(random[2])<<8|uint32(random[3])), 0)
+	if d := time.Since(t); d > 24*time.Hour || d < -24*time.Hour {
+		return nil
+	}
+	return &ServerTime{Time: t, Source: "tls_server_random", Resolution: time.Second}
+}
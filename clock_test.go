@@ -0,0 +1,39 @@
+package zgrab2
+
+import (
+	"testing"
+	"time"
+)
+
+// clockScanner reports time.Time results as the target's time, with a
+// resolution of a second.
+type clockScanner struct {
+	echoScanner
+}
+
+func (s *clockScanner) ServerTime(result interface{}) *ServerTime {
+	if t, ok := result.(time.Time); ok {
+		return &ServerTime{Time: t, Source: "test", Resolution: time.Second}
+	}
+	return nil
+}
+
+func TestMeasureClockSkew(t *testing.T) {
+	start := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	end := start.Add(200 * time.Millisecond)
+	skew := measureClockSkew(&clockScanner{}, start.Add(-time.Minute), start, end)
+	if skew == nil || skew.Source != "test" || skew.ServerTime != "2020-01-01T11:59:00Z" {
+		t.Fatalf("wrong clock skew %+v", skew)
+	}
+	// The server time is taken to be half a second on, and the scanner's at
+	// the middle of the scan.
+	if skew.Skew != -59600 || skew.Uncertainty != 600 {
+		t.Errorf("wrong skew %d±%d", skew.Skew, skew.Uncertainty)
+	}
+	if skew := measureClockSkew(&clockScanner{}, "not a time", start, end); skew != nil {
+		t.Errorf("expected no skew without a server time, got %+v", skew)
+	}
+	if skew := measureClockSkew(&echoScanner{}, start, start, end); skew != nil {
+		t.Errorf("expected no skew from a scanner without a clock, got %+v", skew)
+	}
+}
@@ -0,0 +1,66 @@
+// zgrab2-export converts the findings selected by a mapping config from zgrab2
+// output into a STIX 2.1 bundle or a MISP event.
+package main
+
+import (
+	"io"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+	flags "github.com/zmap/zflags"
+	"github.com/zmap/zgrab2/lib/export"
+)
+
+type options struct {
+	MappingFileName string `short:"m" long:"mapping" required:"true" description:"YAML file of rules selecting the findings to export"`
+	Format          string `short:"f" long:"format" choice:"stix" choice:"misp" description:"Output format (default: the mapping's format, or stix)"`
+	InputFileName   string `short:"i" long:"input-file" default:"-" description:"zgrab2 output to read, use - for stdin"`
+	OutputFileName  string `short:"o" long:"output-file" default:"-" description:"Output filename, use - for stdout"`
+}
+
+func main() {
+	var opts options
+	if _, err := flags.NewParser(&opts, flags.Default).ParseArgs(os.Args[1:]); err != nil {
+		// Outputting help is returned as an error. Exit successfuly on help output.
+		if flagsErr, ok := err.(*flags.Error); ok && flagsErr.Type == flags.ErrHelp {
+			return
+		}
+		log.Fatalf("could not parse flags: %s", err)
+	}
+	mapping, err := export.LoadMapping(opts.MappingFileName)
+	if err != nil {
+		log.Fatalf("could not load mapping: %s", err)
+	}
+	format := opts.Format
+	if format == "" {
+		format = mapping.Format
+	}
+
+	var input io.Reader = os.Stdin
+	if opts.InputFileName != "-" {
+		file, err := os.Open(opts.InputFileName)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer file.Close()
+		input = file
+	}
+	findings, err := export.ReadFindings(input, mapping)
+	if err != nil {
+		log.Fatalf("could not read %s: %s", opts.InputFileName, err)
+	}
+
+	var output io.Writer = os.Stdout
+	if opts.OutputFileName != "-" {
+		file, err := os.Create(opts.OutputFileName)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer file.Close()
+		output = file
+	}
+	if err := export.Export(output, format, mapping, findings); err != nil {
+		log.Fatalf("could not write export: %s", err)
+	}
+	log.Infof("exported %d findings", len(findings))
+}
@@ -0,0 +1,72 @@
+// zgrab2-keyreuse reports the targets in zgrab2 output that share TLS public
+// keys or SSH host keys with other targets, with an identifier for each
+// cluster of targets sharing a key.
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+	flags "github.com/zmap/zflags"
+	"github.com/zmap/zgrab2/lib/keyreuse"
+)
+
+type options struct {
+	InputFileName  string `short:"i" long:"input-file" default:"-" description:"zgrab2 output to read, use - for stdin (which is copied to a temporary file, as the output is read twice)"`
+	OutputFileName string `short:"o" long:"output-file" default:"-" description:"Output filename, use - for stdout"`
+	MinClusterSize uint32 `long:"min-cluster-size" default:"2" description:"Only report keys presented by at least this many targets"`
+}
+
+func main() {
+	var opts options
+	if _, err := flags.NewParser(&opts, flags.Default).ParseArgs(os.Args[1:]); err != nil {
+		// Outputting help is returned as an error. Exit successfuly on help output.
+		if flagsErr, ok := err.(*flags.Error); ok && flagsErr.Type == flags.ErrHelp {
+			return
+		}
+		log.Fatalf("could not parse flags: %s", err)
+	}
+
+	var input *os.File
+	var counted io.Reader
+	if opts.InputFileName == "-" {
+		spool, err := ioutil.TempFile("", "zgrab2-keyreuse")
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer os.Remove(spool.Name())
+		defer spool.Close()
+		input, counted = spool, io.TeeReader(os.Stdin, spool)
+	} else {
+		file, err := os.Open(opts.InputFileName)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer file.Close()
+		input, counted = file, file
+	}
+	counter, err := keyreuse.Count(counted)
+	if err != nil {
+		log.Fatalf("could not read %s: %s", opts.InputFileName, err)
+	}
+	if _, err := input.Seek(0, io.SeekStart); err != nil {
+		log.Fatal(err)
+	}
+
+	var output io.Writer = os.Stdout
+	if opts.OutputFileName != "-" {
+		file, err := os.Create(opts.OutputFileName)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer file.Close()
+		output = file
+	}
+	written, err := keyreuse.Flag(input, output, counter, opts.MinClusterSize)
+	if err != nil {
+		log.Fatalf("could not write results: %s", err)
+	}
+	log.Infof("found %d keys, %d of them presented by at least %d targets; wrote %d lines", counter.Keys(), counter.Clusters(opts.MinClusterSize), opts.MinClusterSize, written)
+}
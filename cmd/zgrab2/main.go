@@ -10,6 +10,7 @@ import (
 	log "github.com/sirupsen/logrus"
 	"github.com/zmap/zgrab2"
 	_ "github.com/zmap/zgrab2/modules"
+	"github.com/zmap/zgrab2/modules/plugin"
 	"fmt"
 	"runtime"
 	"strings"
@@ -81,10 +82,49 @@ func stopCPUProfile() {
 		pprof.StopCPUProfile()
 	}
 }
+// validateBaseFlags checks the options common to all modules that the
+// modules themselves do not validate.
+func validateBaseFlags(f zgrab2.ScanFlags) {
+	base := zgrab2.GetBaseFlags(f)
+	if base == nil {
+		return
+	}
+	if _, err := base.GetPreludes(); err != nil {
+		log.Fatalf("invalid prelude for %s: %v", base.Name, err)
+	}
+	if _, err := base.GetProxies(); err != nil {
+		log.Fatalf("invalid proxy for %s: %v", base.Name, err)
+	}
+	if base.MaxSuccesses < 0 {
+		log.Fatalf("max successes for %s must be non-negative, given %d", base.Name, base.MaxSuccesses)
+	}
+	if base.Senders < 0 {
+		log.Fatalf("senders for %s must be non-negative, given %d", base.Name, base.Senders)
+	}
+}
+
+// registerScan registers the scanner, applying its --max-successes and
+// --senders.
+func registerScan(name string, s zgrab2.Scanner, flag zgrab2.ScanFlags) {
+	zgrab2.RegisterScan(name, s)
+	if base := zgrab2.GetBaseFlags(flag); base != nil {
+		zgrab2.LimitSuccesses(s, base.MaxSuccesses)
+		zgrab2.LimitConcurrency(s, base.Senders)
+	}
+}
+
 func main() {
 	startCPUProfile()
 	defer stopCPUProfile()
 	defer dumpHeapProfile()
+	// Plugins are registered as commands, so this must happen before the
+	// command line is parsed.
+	pluginDir, err := zgrab2.PluginDir(os.Args[1:])
+	if err != nil {
+		log.Fatalf("could not parse flags: %s", err)
+	}
+	plugin.RegisterPlugins(pluginDir)
+
 	_, moduleType, flag, err := zgrab2.ParseCommandLine(os.Args[1:])
 
 	// Blanked arg is positional arguments
@@ -99,15 +139,22 @@ func main() {
 		log.Fatalf("could not parse flags: %s", err)
 	}
 
-	if m, ok := flag.(*zgrab2.MultipleCommand); ok {
-		iniParser := zgrab2.NewIniParser()
-		var modTypes []string
-		var flagsReturned []interface{}
-		if m.ConfigFileName == "-" {
-			modTypes, flagsReturned, err = iniParser.Parse(os.Stdin)
-		} else {
-			modTypes, flagsReturned, err = iniParser.ParseFile(m.ConfigFileName)
+	if d, ok := flag.(*zgrab2.DescribeCommand); ok {
+		if err := d.Describe(os.Stdout); err != nil {
+			log.Fatalf("could not describe modules: %s", err)
+		}
+		return
+	}
+
+	if b, ok := flag.(*zgrab2.BenchCommand); ok {
+		if err := b.Bench(os.Stdout); err != nil {
+			log.Fatalf("could not benchmark modules: %s", err)
 		}
+		return
+	}
+
+	if m, ok := flag.(*zgrab2.MultipleCommand); ok {
+		modTypes, flagsReturned, err := zgrab2.ParseMultipleConfig(m)
 		if err != nil {
 			log.Fatalf("could not parse multiple: %s", err)
 		}
@@ -116,16 +163,18 @@ func main() {
 		}
 		for i, fl := range flagsReturned {
 			f, _ := fl.(zgrab2.ScanFlags)
+			validateBaseFlags(f)
 			mod := zgrab2.GetModule(modTypes[i])
 			s := mod.NewScanner()
 			s.Init(f)
-			zgrab2.RegisterScan(s.GetName(), s)
+			registerScan(s.GetName(), s, f)
 		}
 	} else {
+		validateBaseFlags(flag)
 		mod := zgrab2.GetModule(moduleType)
 		s := mod.NewScanner()
 		s.Init(flag)
-		zgrab2.RegisterScan(moduleType, s)
+		registerScan(moduleType, s, flag)
 	}
 	monitor := zgrab2.MakeMonitor()
 	monitor.Callback = func(_ string) {
@@ -141,6 +190,13 @@ func main() {
 		StartTime:         start.Format(time.RFC3339),
 		EndTime:           end.Format(time.RFC3339),
 		Duration:          end.Sub(start).String(),
+		Skipped:           zgrab2.GetSkippedTargets(),
+		Duplicates:        zgrab2.GetDuplicateTargets(),
+		OtherShards:       zgrab2.GetOtherShardTargets(),
+		Sampling:          zgrab2.GetSampling(),
+		UniqueCerts:       zgrab2.GetUniqueCertificates(),
+		StoppedEarly:      zgrab2.MaxSuccessesReached(),
+		Interrupted:       zgrab2.GetInterruption(),
 	}
 	enc := json.NewEncoder(zgrab2.GetMetaFile())
 	if err := enc.Encode(&s); err != nil {
@@ -7,4 +7,11 @@ type Summary struct {
 	StartTime         string                   `json:"start"`
 	EndTime           string                   `json:"end"`
 	Duration          string                   `json:"duration"`
+	Skipped           *zgrab2.SkippedTargets   `json:"skipped,omitempty"`
+	Duplicates        uint64                   `json:"duplicates_skipped,omitempty"`
+	OtherShards       uint64                   `json:"other_shards_skipped,omitempty"`
+	Sampling          *zgrab2.Sampling         `json:"sampling,omitempty"`
+	UniqueCerts       uint64                   `json:"unique_certificates,omitempty"`
+	StoppedEarly      bool                     `json:"max_successes_reached,omitempty"`
+	Interrupted       *zgrab2.Interruption     `json:"interrupted,omitempty"`
 }
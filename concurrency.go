@@ -0,0 +1,60 @@
+package zgrab2
+
+import (
+	"sync"
+)
+
+// moduleConcurrency limits the number of senders running each module at
+// once, for the modules' --senders flags, so that in a multiple-module scan a
+// slow module (e.g. http fetching bodies) only ties up its share of the
+// senders, leaving the rest for the cheap ones.
+type moduleConcurrency struct {
+	mutex sync.Mutex
+
+	// slots maps a scanner name to a semaphore holding a token for each of
+	// its running scans, if it has a limit.
+	slots map[string]chan struct{}
+}
+
+var concurrency = newModuleConcurrency()
+
+func newModuleConcurrency() *moduleConcurrency {
+	return &moduleConcurrency{slots: make(map[string]chan struct{})}
+}
+
+// LimitConcurrency limits the number of senders running the scanner at once
+// to max (if max is positive). Senders reaching the scanner while max are
+// already running it wait for one of them to finish.
+func LimitConcurrency(s Scanner, max int) {
+	concurrency.mutex.Lock()
+	defer concurrency.mutex.Unlock()
+	if max > 0 {
+		concurrency.slots[s.GetName()] = make(chan struct{}, max)
+	} else {
+		delete(concurrency.slots, s.GetName())
+	}
+}
+
+// acquire waits until the named scanner may be run, and returns a function
+// to call once it has finished.
+func (c *moduleConcurrency) acquire(name string) func() {
+	c.mutex.Lock()
+	slots := c.slots[name]
+	c.mutex.Unlock()
+	if slots == nil {
+		return func() {}
+	}
+	slots <- struct{}{}
+	return func() { <-slots }
+}
+
+// running returns the number of senders running each limited scanner.
+func (c *moduleConcurrency) running() map[string]int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	ret := make(map[string]int, len(c.slots))
+	for name, slots := range c.slots {
+		ret[name] = len(slots)
+	}
+	return ret
+}
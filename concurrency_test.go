@@ -0,0 +1,40 @@
+package zgrab2
+
+import (
+	"testing"
+	"time"
+)
+
+func TestModuleConcurrency(t *testing.T) {
+	defer func(old *moduleConcurrency) { concurrency = old }(concurrency)
+	concurrency = newModuleConcurrency()
+	LimitConcurrency(&echoScanner{name: "slow"}, 2)
+	LimitConcurrency(&echoScanner{name: "cheap"}, 0)
+
+	// Unlimited scanners never wait.
+	for i := 0; i < 10; i++ {
+		concurrency.acquire("cheap")
+	}
+	first := concurrency.acquire("slow")
+	concurrency.acquire("slow")
+	if running := concurrency.running(); len(running) != 1 || running["slow"] != 2 {
+		t.Errorf("wrong running counts %v", running)
+	}
+
+	acquired := make(chan struct{})
+	go func() {
+		concurrency.acquire("slow")
+		close(acquired)
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("acquired a third slot with a limit of 2")
+	case <-time.After(50 * time.Millisecond):
+	}
+	first()
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatal("slot not acquired after one was released")
+	}
+}
@@ -4,32 +4,92 @@ import (
 	"net/http"
 	"os"
 	"runtime"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	log "github.com/sirupsen/logrus"
+	"github.com/zmap/zflags"
 )
 
 // Config is the high level framework options that will be parsed
 // from the command line
 type Config struct {
-	OutputFileName     string          `short:"o" long:"output-file" default:"-" description:"Output filename, use - for stdout"`
-	InputFileName      string          `short:"f" long:"input-file" default:"-" description:"Input filename, use - for stdin"`
-	MetaFileName       string          `short:"m" long:"metadata-file" default:"-" description:"Metadata filename, use - for stderr"`
-	LogFileName        string          `short:"l" long:"log-file" default:"-" description:"Log filename, use - for stderr"`
-	Interface          string          `short:"i" long:"interface" description:"Network interface to send on"`
-	Senders            int             `short:"s" long:"senders" default:"1000" description:"Number of send goroutines to use"`
-	Debug              bool            `long:"debug" description:"Include debug fields in the output."`
-	GOMAXPROCS         int             `long:"gomaxprocs" default:"0" description:"Set GOMAXPROCS"`
-	ConnectionsPerHost int             `long:"connections-per-host" default:"1" description:"Number of times to connect to each host (results in more output)"`
-	ReadLimitPerHost   int             `long:"read-limit-per-host" default:"96" description:"Maximum total kilobytes to read for a single host (default 96kb)"`
-	Prometheus         string          `long:"prometheus" description:"Address to use for Prometheus server (e.g. localhost:8080). If empty, Prometheus is disabled."`
-	Multiple           MultipleCommand `command:"multiple" description:"Multiple module actions"`
-	inputFile          *os.File
-	outputFile         *os.File
-	metaFile           *os.File
-	logFile            *os.File
-	inputTargets       InputTargetsFunc
-	outputResults      OutputResultsFunc
+	OutputFileName          string          `short:"o" long:"output-file" default:"-" description:"Output filename, use - for stdout"`
+	InputFileName           string          `short:"f" long:"input-file" default:"-" description:"Input filename, use - for stdin"`
+	MetaFileName            string          `short:"m" long:"metadata-file" default:"-" description:"Metadata filename, use - for stderr"`
+	LogFileName             string          `short:"l" long:"log-file" default:"-" description:"Log filename, use - for stderr"`
+	Interface               string          `short:"i" long:"interface" description:"Network interface to send on"`
+	Senders                 int             `short:"s" long:"senders" default:"1000" description:"Number of send goroutines to use"`
+	QueueSize               int             `long:"queue-size" description:"Maximum number of targets waiting for a sender, and of results waiting to be written; when they fill up, reading input waits (default: 4 per sender)"`
+	Debug                   bool            `long:"debug" description:"Include debug fields in the output."`
+	OmitDebugFields         bool            `long:"omit-debug-fields" description:"Never include debug fields in the output, even with --debug or a module's verbose flag"`
+	OmitRaw                 bool            `long:"omit-raw" description:"Omit fields holding raw dumps of protocol data from the output"`
+	CertificatesFile        string          `long:"certificates-file" description:"Write each unique TLS certificate in the results once to this file, as a JSON line with its fingerprint_sha256, and replace the certificates in the results with references holding only their fingerprint_sha256"`
+	ManifestFile            string          `long:"manifest-file" description:"Write a manifest of the output to this file as results are written: a JSON line with the SHA-256 digest of each chunk of --manifest-chunk-lines lines, then one with the Merkle root of the chunks, signed with --manifest-key if given"`
+	ManifestChunkLines      int             `long:"manifest-chunk-lines" default:"100000" description:"Number of output lines hashed together in each chunk of the --manifest-file (chunks end with a whole result)"`
+	ManifestKey             string          `long:"manifest-key" description:"PEM PKCS #8 Ed25519, ECDSA or RSA private key to sign the Merkle root in the --manifest-file with"`
+	OutputSchema            string          `long:"output-schema" default:"zgrab2" choice:"zgrab2" choice:"censys" choice:"shodan" description:"Shape of the output: zgrab2, censys (a host per line, with a services array) or shodan (a banner per line for each identified service)"`
+	GOMAXPROCS              int             `long:"gomaxprocs" default:"0" description:"Set GOMAXPROCS"`
+	ConnectionsPerHost      int             `long:"connections-per-host" default:"1" description:"Number of times to connect to each host (results in more output)"`
+	ReadLimitPerHost        int             `long:"read-limit-per-host" default:"96" description:"Maximum total kilobytes to read for a single host (default 96kb)"`
+	Prometheus              string          `long:"prometheus" description:"Address to use for Prometheus server (e.g. localhost:8080). If empty, Prometheus is disabled."`
+	StatusAddr              string          `long:"status-addr" description:"Address to serve live scan status on (e.g. localhost:8081). If empty, the status endpoint is disabled."`
+	StatusControls          bool            `long:"status-controls" description:"Also serve the scan controls (POST /pause, /resume, /drain and /senders) on --status-addr. They are unauthenticated, so only bind it to a trusted address."`
+	Rate                    int             `long:"rate" description:"Maximum number of targets to start per second (0 = no limit)"`
+	SubnetRate              int             `long:"subnet-rate" description:"Maximum number of targets to start per second in any one subnet (0 = no limit)"`
+	SubnetPrefix            int             `long:"subnet-prefix" default:"24" description:"Prefix length defining an IPv4 subnet for --subnet-rate"`
+	SubnetPrefixV6          int             `long:"subnet-prefix-v6" default:"48" description:"Prefix length defining an IPv6 subnet for --subnet-rate"`
+	LimitsFile              string          `long:"limits-file" description:"File of 'name = value' limits (rate, senders, subnet-rate, subnet-prefix, subnet-prefix-v6), re-read on SIGHUP"`
+	ControlSocket           string          `long:"control-socket" description:"Unix socket accepting pause, resume, drain and status commands, and 'name = value' limit changes, while the scan is running"`
+	TargetTimeout           time.Duration   `long:"target-timeout" description:"Total time budget for all modules on a single target (0 = no limit)"`
+	DrainTimeout            time.Duration   `long:"drain-timeout" default:"30s" description:"On SIGINT or SIGTERM, how long to let in-flight scans finish before writing the output and summary"`
+	MaxSuccesses            int             `long:"max-successes" description:"Stop dispatching new targets once this many have had a successful grab from any module (0 = no limit)"`
+	AdaptiveTimeout         int             `long:"adaptive-timeout" description:"If non-zero, set per-connection read/write timeouts to this multiple of the measured connect RTT, bounded by the module timeout"`
+	AdaptiveTimeoutMin      time.Duration   `long:"adaptive-timeout-min" default:"1s" description:"Minimum read/write timeout used with --adaptive-timeout"`
+	WatchdogMaxHeap         int             `long:"watchdog-max-heap" description:"Heap size in megabytes above which intake is throttled and load is shed (0 = no limit)"`
+	WatchdogMaxGoroutines   int             `long:"watchdog-max-goroutines" description:"Goroutine count above which intake is throttled and load is shed (0 = no limit)"`
+	WatchdogInterval        time.Duration   `long:"watchdog-interval" default:"5s" description:"How often the watchdog checks heap size and goroutine count"`
+	NotifyURL               string          `long:"notify-url" description:"Webhook (e.g. Slack incoming webhook) URL to post scan start, progress milestones, error rate alarms and completion to"`
+	NotifyFormat            string          `long:"notify-format" default:"auto" choice:"auto" choice:"json" choice:"slack" description:"Format of notifications: json, slack, or auto (slack for slack.com URLs)"`
+	NotifyMilestones        string          `long:"notify-milestones" default:"25,50,75" description:"Comma-separated percentages of the input file read to notify at (only when the input is a regular file)"`
+	NotifyErrorRate         float64         `long:"notify-error-rate" description:"Notify when more than this fraction (e.g. 0.5) of the scans in a --notify-interval fail with one of --notify-error-statuses (0 = never)"`
+	NotifyErrorStatuses     string          `long:"notify-error-statuses" default:"unknown-error,internal-error,target-timeout" description:"Comma-separated scan statuses counted as failures by --notify-error-rate"`
+	NotifyInterval          time.Duration   `long:"notify-interval" default:"30s" description:"How often to check for progress milestones and error rate alarms"`
+	WorkerQueue             string          `long:"worker-queue" description:"Run as a long-lived worker taking batches of targets from this job queue until SIGINT or SIGTERM: redis://[:password@]host[:port][/db][?key=zgrab2:jobs] or the https:// URL of an SQS queue"`
+	WorkerResults           string          `long:"worker-results" description:"Queue to push results to in worker mode, as for --worker-queue (default key zgrab2:results); if empty, results are written to the output file"`
+	WorkerVisibilityTimeout time.Duration   `long:"worker-visibility-timeout" default:"5m" description:"How long a job received in worker mode is hidden from other workers if this one stops without completing it; extended while the job is in progress"`
+	WorkerWait              time.Duration   `long:"worker-wait" default:"20s" description:"How long to wait for a job in each request to the job queue in worker mode (at most 20s for SQS)"`
+	BlocklistFile           string          `long:"blocklist-file" description:"File of CIDR blocks / addresses (ZMap format) that must never be scanned"`
+	AllowlistFile           string          `long:"allowlist-file" description:"File of CIDR blocks / addresses (ZMap format); if given, only targets within them are scanned"`
+	Dedup                   bool            `long:"dedup" description:"Skip targets that repeat an earlier target's address, domain, port and tag"`
+	DedupFilter             string          `long:"dedup-filter" default:"exact" choice:"exact" choice:"bloom" description:"Set used by --dedup: exact (switching to bloom if it outgrows --dedup-memory) or bloom (may skip some unique targets)"`
+	DedupMemory             int             `long:"dedup-memory" default:"256" description:"Memory budget in megabytes for --dedup"`
+	Shard                   string          `long:"shard" description:"Only scan shard i of n (given as i/n, from 0/n to (n-1)/n), selected by a consistent hash of each target's address and port, so that n instances can split one input without overlap"`
+	SampleRate              float64         `long:"sample-rate" description:"Only scan this fraction of the targets (e.g. 0.01), chosen by a hash of each target's address and port keyed with --sample-seed, for pilot runs over large inputs"`
+	SampleSeed              uint64          `long:"sample-seed" description:"Seed of --sample-rate: the same seed chooses the same targets"`
+	LookupDomain            string          `long:"lookup-domain" description:"Treat the domain of each input record as a domain to look up service records for, and scan the hosts they name, by name: mx, dc (Active Directory domain controllers), sip, sips, sip-udp, xmpp-client, xmpp-server, or srv:_service._proto; SRV ports are scanned, except for dc"`
+	DNSResolvers            string          `long:"dns-resolvers" description:"Comma-separated nameservers (address or address:port) to resolve target names with, instead of the system resolver"`
+	DNSTransports           string          `long:"dns-transports" default:"udp" description:"Comma-separated transports (udp, tcp, tls) to query --dns-resolvers over, tried in order until one gets an answer"`
+	DNSCacheTTL             time.Duration   `long:"dns-cache-ttl" default:"5m" description:"How long to cache resolved names (0 = no caching)"`
+	DNSNegativeCacheTTL     time.Duration   `long:"dns-negative-cache-ttl" default:"1m" description:"How long to cache names that do not exist (0 = no caching)"`
+	DNSCacheSize            int             `long:"dns-cache-size" default:"100000" description:"Maximum number of names in the DNS cache"`
+	NAT64Prefix             string          `long:"nat64-prefix" description:"IPv6 prefix (e.g. 64:ff9b::/96) of a NAT64 gateway: IPv4 targets, and names resolving only to IPv4 addresses, are dialed at the IPv6 address embedding the IPv4 address in the prefix (RFC 6052)"`
+	SourcePortRange         string          `long:"source-port-range" description:"Range of local ports (e.g. 40000-40999) to bind outgoing connections to, shared by all senders"`
+	PolicyFile              string          `long:"policy-file" description:"Measurement policy file of 'allow = <probe classes>', 'allow.<protocol> = <probe classes>' and 'rate.<protocol> = <scans per second>' lines; authenticating and state-changing probes are disabled unless allowed"`
+	ICSSafe                 bool            `long:"ics-safe" description:"Only let industrial control system modules (modbus, dnp3, siemens, bacnet, fox) send identification requests: every message they write is checked, and any other is blocked and fails the scan; results are marked ics_safe"`
+	CVEFile                 string          `long:"cve-file" description:"Local NVD snapshot (a CVE API 2.0 response, optionally gzipped) used to list the CVEs affecting each identified product"`
+	GeoIPDB                 string          `long:"geoip-db" description:"MaxMind GeoLite2 / GeoIP2 Country or City database (.mmdb) used to record the country of each target's address"`
+	ASNDB                   string          `long:"asn-db" description:"IP-to-ASN database in MaxMind DB format (e.g. GeoLite2 ASN) used to record the autonomous system announcing each target's address"`
+	PluginDir               string          `long:"plugin-dir" env:"ZGRAB2_PLUGIN_DIR" description:"Directory of external scanner executables to register as modules (see modules/plugin)"`
+	Multiple                MultipleCommand `command:"multiple" description:"Multiple module actions"`
+	Describe                DescribeCommand `command:"describe" description:"Print machine-readable metadata of modules"`
+	Bench                   BenchCommand    `command:"bench" description:"Benchmark modules against local simulators"`
+	inputFile               *os.File
+	outputFile              *os.File
+	metaFile                *os.File
+	logFile                 *os.File
+	inputTargets            InputTargetsFunc
+	outputResults           OutputResultsFunc
 }
 
 // SetInputFunc sets the target input function to the provided function.
@@ -48,6 +108,20 @@ func init() {
 
 var config Config
 
+// PluginDir returns the --plugin-dir given in args, or the ZGRAB2_PLUGIN_DIR
+// environment variable if it is absent. Plugins must be registered as
+// commands before the command line is parsed, so this parses args for that
+// option alone, ignoring all others.
+func PluginDir(args []string) (string, error) {
+	var options struct {
+		PluginDir string `long:"plugin-dir" env:"ZGRAB2_PLUGIN_DIR"`
+	}
+	if _, err := flags.NewParser(&options, flags.IgnoreUnknown).ParseArgs(args); err != nil {
+		return "", err
+	}
+	return options.PluginDir, nil
+}
+
 func validateFrameworkConfiguration() {
 	// validate files
 	if config.LogFileName == "-" {
@@ -68,6 +142,9 @@ func validateFrameworkConfiguration() {
 		if config.inputFile, err = os.Open(config.InputFileName); err != nil {
 			log.Fatal(err)
 		}
+		if info, err := config.inputFile.Stat(); err == nil && info.Mode().IsRegular() {
+			progress.inputSize = info.Size()
+		}
 	}
 
 	if config.OutputFileName == "-" {
@@ -105,10 +182,211 @@ func validateFrameworkConfiguration() {
 		}()
 	}
 
+	// validate/start status endpoint
+	if config.StatusAddr != "" {
+		go func() {
+			if err := http.ListenAndServe(config.StatusAddr, newStatusHandler(config.StatusControls)); err != nil {
+				log.Fatalf("could not run status server: %s", err.Error())
+			}
+		}()
+	}
+
 	//validate senders
 	if config.Senders <= 0 {
 		log.Fatalf("need at least one sender, given %d", config.Senders)
 	}
+	if config.QueueSize < 0 {
+		log.Fatalf("invalid queue size %d", config.QueueSize)
+	}
+
+	// load blocklist / allowlist
+	if config.BlocklistFile != "" {
+		var err error
+		if filter.blocklist, err = loadPrefixFile(config.BlocklistFile); err != nil {
+			log.Fatalf("could not load blocklist: %v", err)
+		}
+	}
+	if config.AllowlistFile != "" {
+		var err error
+		if filter.allowlist, err = loadPrefixFile(config.AllowlistFile); err != nil {
+			log.Fatalf("could not load allowlist: %v", err)
+		}
+	}
+
+	// set up certificate deduplication
+	if config.CertificatesFile != "" {
+		var err error
+		if certDedup, err = newCertDeduplicator(config.CertificatesFile); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// set up the output manifest
+	if config.ManifestFile != "" {
+		var err error
+		if manifest, err = newManifestWriter(config.ManifestFile, config.ManifestChunkLines, config.ManifestKey); err != nil {
+			log.Fatalf("could not set up manifest: %v", err)
+		}
+	} else if config.ManifestKey != "" {
+		log.Fatal("--manifest-key requires --manifest-file")
+	}
+
+	// set up target deduplication
+	if config.Dedup {
+		var err error
+		if dedup, err = newTargetDeduper(config.DedupFilter, config.DedupMemory); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// set up sharding
+	if config.Shard != "" {
+		var err error
+		if sharder, err = parseShard(config.Shard); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// set up sampling
+	if config.SampleRate != 0 {
+		var err error
+		if sampleFilter, err = newTargetSampleFilter(config.SampleRate, config.SampleSeed); err != nil {
+			log.Fatal(err)
+		}
+	} else if config.SampleSeed != 0 {
+		log.Fatal("--sample-seed requires --sample-rate")
+	}
+
+	// set up name resolution
+	if config.DNSResolvers != "" {
+		var err error
+		if resolver.servers, err = parseDNSServers(config.DNSResolvers); err != nil {
+			log.Fatal(err)
+		}
+		if resolver.transports, err = parseDNSTransports(config.DNSTransports); err != nil {
+			log.Fatal(err)
+		}
+	}
+	resolver.ttl = config.DNSCacheTTL
+	resolver.negativeTTL = config.DNSNegativeCacheTTL
+	resolver.maxEntries = config.DNSCacheSize
+
+	// load the measurement policy
+	if config.PolicyFile != "" {
+		var err error
+		if policy, err = loadPolicyFile(config.PolicyFile); err != nil {
+			log.Fatalf("could not load policy: %v", err)
+		}
+	}
+
+	// load the NVD snapshot
+	if config.CVEFile != "" {
+		var err error
+		if cves, err = loadCVEFile(config.CVEFile); err != nil {
+			log.Fatalf("could not load CVE file: %v", err)
+		}
+	}
+
+	// open the GeoIP and ASN databases
+	if config.GeoIPDB != "" {
+		var err error
+		if geoDatabases.geoip, err = openIPDatabase(config.GeoIPDB); err != nil {
+			log.Fatalf("could not open GeoIP database: %v", err)
+		}
+	}
+	if config.ASNDB != "" {
+		var err error
+		if geoDatabases.asn, err = openIPDatabase(config.ASNDB); err != nil {
+			log.Fatalf("could not open ASN database: %v", err)
+		}
+	}
+
+	// set up NAT64 address synthesis
+	if config.NAT64Prefix != "" {
+		var err error
+		if nat64Prefix, err = parseNAT64Prefix(config.NAT64Prefix); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// set up source port binding
+	if config.SourcePortRange != "" {
+		var err error
+		if sourcePorts, err = parseSourcePortRange(config.SourcePortRange); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// validate rate limits
+	for name, value := range map[string]int{
+		"rate":             config.Rate,
+		"subnet-rate":      config.SubnetRate,
+		"subnet-prefix":    config.SubnetPrefix,
+		"subnet-prefix-v6": config.SubnetPrefixV6,
+	} {
+		if err := limits.set(name, value); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if config.LimitsFile != "" {
+		if err := LoadLimitsFile(config.LimitsFile); err != nil {
+			log.Fatalf("could not load limits file: %s", err.Error())
+		}
+		go reloadLimitsOnHangup(config.LimitsFile)
+	}
+	if config.ControlSocket != "" {
+		if err := serveControlSocket(config.ControlSocket); err != nil {
+			log.Fatalf("could not open control socket: %s", err.Error())
+		}
+	}
+	go pauseOnSignal()
+
+	// validate adaptive timeouts
+	if config.AdaptiveTimeout < 0 {
+		log.Fatalf("adaptive timeout multiplier must be non-negative, given %d", config.AdaptiveTimeout)
+	}
+	AdaptiveTimeoutMultiplier = config.AdaptiveTimeout
+	if config.AdaptiveTimeoutMin > 0 {
+		AdaptiveTimeoutMinimum = config.AdaptiveTimeoutMin
+	}
+
+	// validate watchdog
+	if (config.WatchdogMaxHeap > 0 || config.WatchdogMaxGoroutines > 0) && config.WatchdogInterval <= 0 {
+		log.Fatalf("watchdog interval must be positive, given %s", config.WatchdogInterval)
+	}
+
+	// set up notifications
+	if config.NotifyURL != "" {
+		var err error
+		if notifications, err = newNotifier(); err != nil {
+			log.Fatalf("could not set up notifications: %v", err)
+		}
+	}
+
+	// set up worker mode
+	if config.WorkerQueue != "" {
+		var err error
+		if jobWorker, err = newWorker(); err != nil {
+			log.Fatalf("could not set up worker: %v", err)
+		}
+		SetInputFunc(jobWorker.inputTargets)
+		SetOutputFunc(jobWorker.outputResults)
+	}
+
+	// set up domain lookups
+	if config.LookupDomain != "" {
+		lookup, err := parseDomainLookup(config.LookupDomain)
+		if err != nil {
+			log.Fatal(err)
+		}
+		SetInputFunc(lookup.inputTargets(config.inputTargets))
+	}
+
+	// validate early exit
+	if config.MaxSuccesses < 0 {
+		log.Fatalf("max successes must be non-negative, given %d", config.MaxSuccesses)
+	}
+	sampler.max = config.MaxSuccesses
 
 	// validate connections per host
 	if config.ConnectionsPerHost <= 0 {
@@ -132,5 +410,5 @@ func GetMetaFile() *os.File {
 }
 
 func includeDebugOutput() bool {
-	return config.Debug
+	return config.Debug && !config.OmitDebugFields
 }
@@ -0,0 +1,36 @@
+package zgrab2
+
+import (
+	"os"
+	"testing"
+)
+
+func TestPluginDir(t *testing.T) {
+	defer os.Setenv("ZGRAB2_PLUGIN_DIR", os.Getenv("ZGRAB2_PLUGIN_DIR"))
+	os.Unsetenv("ZGRAB2_PLUGIN_DIR")
+	tests := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"--senders", "5", "http", "--port", "8080"}, ""},
+		{[]string{"--plugin-dir", "/opt/plugins", "gopher"}, "/opt/plugins"},
+		{[]string{"--senders=5", "--plugin-dir=/opt/plugins", "gopher", "--plugin-args", "-v"}, "/opt/plugins"},
+		{[]string{"-o", "out.json", "--plugin-dir", "/opt/plugins"}, "/opt/plugins"},
+	}
+	for _, test := range tests {
+		dir, err := PluginDir(test.args)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", test.args, err)
+		} else if dir != test.expected {
+			t.Errorf("%q: expected %q, got %q", test.args, test.expected, dir)
+		}
+	}
+
+	os.Setenv("ZGRAB2_PLUGIN_DIR", "/env/plugins")
+	if dir, err := PluginDir([]string{"gopher"}); err != nil || dir != "/env/plugins" {
+		t.Errorf("expected the environment variable, got %q (%v)", dir, err)
+	}
+	if dir, err := PluginDir([]string{"--plugin-dir", "/opt/plugins", "gopher"}); err != nil || dir != "/opt/plugins" {
+		t.Errorf("expected --plugin-dir to take precedence, got %q (%v)", dir, err)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"io"
 	"net"
+	"strings"
 	"time"
 
 	"github.com/sirupsen/logrus"
@@ -38,6 +39,13 @@ var (
 
 	// DefaultSessionTimeout is the default maximum time a connection may be used when no explicit value is provided.
 	DefaultSessionTimeout = 1 * time.Minute
+
+	// AdaptiveTimeoutMultiplier, if non-zero, enables adaptive timeouts: the read and write timeouts of each
+	// connection are set to this multiple of the observed connect RTT (bounded by the configured timeouts).
+	AdaptiveTimeoutMultiplier = 0
+
+	// AdaptiveTimeoutMinimum is the smallest read / write timeout that adaptive timeouts will use.
+	AdaptiveTimeoutMinimum = 1 * time.Second
 )
 
 // ErrReadLimitExceeded is returned / panic'd from Read if the read limit is exceeded when the
@@ -60,6 +68,21 @@ type TimeoutConnection struct {
 	explicitReadDeadline    bool
 	explicitWriteDeadline   bool
 	explicitDeadline        bool
+
+	// ConnectRTT is the time taken to establish the connection, if known.
+	ConnectRTT time.Duration
+
+	// resolution records how the host's name was resolved, if it was dialed
+	// by name.
+	resolution *Resolution
+
+	// log is the log of the scan that opened the connection, if it was
+	// recorded with RecordConnection.
+	log *scanLog
+
+	// datagram is true if the underlying connection is UDP, so that each
+	// read and write is a datagram.
+	datagram bool
 }
 
 // TimeoutConnection.Read calls Read() on the underlying connection, using any configured deadlines
@@ -81,6 +104,9 @@ func (c *TimeoutConnection) Read(b []byte) (n int, err error) {
 	}
 	n, err = c.Conn.Read(b)
 	c.BytesRead += n
+	if err == nil {
+		c.recordDatagram(false, n)
+	}
 	if err == nil && origSize != len(b) && n == len(b) {
 		// we had to shrink the output buffer AND we used up the whole shrunk size, AND we're not at EOF
 		switch c.ReadLimitExceededAction {
@@ -113,6 +139,9 @@ func (c *TimeoutConnection) Write(b []byte) (n int, err error) {
 	}
 	n, err = c.Conn.Write(b)
 	c.BytesWritten += n
+	if err == nil {
+		c.recordDatagram(true, n)
+	}
 	return n, err
 }
 
@@ -232,10 +261,45 @@ func NewTimeoutConnection(ctx context.Context, conn net.Conn, timeout, readTimeo
 	return ret
 }
 
+// adaptTimeout returns the timeout to use for individual reads / writes on a
+// connection whose connect RTT was rtt, given the configured timeout. If
+// adaptive timeouts are disabled, the configured timeout is returned as-is.
+func adaptTimeout(rtt, timeout time.Duration) time.Duration {
+	if AdaptiveTimeoutMultiplier <= 0 || rtt <= 0 {
+		return timeout
+	}
+	ret := rtt * time.Duration(AdaptiveTimeoutMultiplier)
+	if ret < AdaptiveTimeoutMinimum {
+		ret = AdaptiveTimeoutMinimum
+	}
+	if timeout > 0 && ret > timeout {
+		ret = timeout
+	}
+	return ret
+}
+
+// isPacketNetwork returns true for connectionless networks, for which there is
+// no connect RTT to measure.
+func isPacketNetwork(network string) bool {
+	return strings.HasPrefix(network, "udp") || strings.HasPrefix(network, "ip") || network == "unixgram"
+}
+
+// applyRTT records the connect RTT on the connection and, if adaptive timeouts
+// are enabled, scales the read and write timeouts accordingly.
+func (c *TimeoutConnection) applyRTT(rtt time.Duration) {
+	c.ConnectRTT = rtt
+	if AdaptiveTimeoutMultiplier <= 0 {
+		return
+	}
+	c.ReadTimeout = adaptTimeout(rtt, c.getTimeout(c.ReadTimeout))
+	c.WriteTimeout = adaptTimeout(rtt, c.getTimeout(c.WriteTimeout))
+}
+
 // DialTimeoutConnectionEx dials the target and returns a net.Conn that uses the configured timeouts for Read/Write operations.
 func DialTimeoutConnectionEx(proto string, target string, dialTimeout, sessionTimeout, readTimeout, writeTimeout time.Duration, bytesReadLimit int) (net.Conn, error) {
 	var conn net.Conn
 	var err error
+	start := time.Now()
 	if dialTimeout > 0 {
 		conn, err = net.DialTimeout(proto, target, dialTimeout)
 	} else {
@@ -247,7 +311,11 @@ func DialTimeoutConnectionEx(proto string, target string, dialTimeout, sessionTi
 		}
 		return nil, err
 	}
-	return NewTimeoutConnection(context.Background(), conn, sessionTimeout, readTimeout, writeTimeout, bytesReadLimit), nil
+	ret := NewTimeoutConnection(context.Background(), conn, sessionTimeout, readTimeout, writeTimeout, bytesReadLimit)
+	if !isPacketNetwork(proto) {
+		ret.applyRTT(time.Since(start))
+	}
+	return ret, nil
 }
 
 // DialTimeoutConnection dials the target and returns a net.Conn that uses the configured single timeout for all operations.
@@ -281,6 +349,20 @@ type Dialer struct {
 	// ReadLimitExceededAction describes how connections dialed with this dialer deal with exceeding
 	// the BytesReadLimit.
 	ReadLimitExceededAction ReadLimitExceededAction
+
+	// AddressFamily restricts connections to AddressFamilyIPv4 or AddressFamilyIPv6. If empty or
+	// AddressFamilyAny, names resolving to both families are dialed using Happy Eyeballs.
+	AddressFamily string
+
+	// Preludes are sent, in order, on each new stream connection before it is returned.
+	Preludes []Prelude
+
+	// SocketOptions, if set, are set on each connection's socket.
+	SocketOptions *SocketOptions
+
+	// Proxies, if set, are the chain of proxies through which stream
+	// connections are made.
+	Proxies []*Proxy
 }
 
 func (d *Dialer) getTimeout(field time.Duration) time.Duration {
@@ -298,15 +380,55 @@ func (d *Dialer) DialContext(ctx context.Context, network, address string) (net.
 	// ensure that our aux dialer is up-to-date; copied from http/transport.go
 	d.Dialer.Timeout = d.getTimeout(d.ConnectTimeout)
 	d.Dialer.KeepAlive = d.Timeout
+	if d.SocketOptions != nil {
+		if d.SocketOptions.KeepAlive != 0 {
+			d.Dialer.KeepAlive = d.SocketOptions.KeepAlive
+		}
+		setDialerSocketOptions(d.Dialer, d.SocketOptions)
+	}
 	dialContext, cancelDial := context.WithTimeout(ctx, d.Dialer.Timeout)
 	defer cancelDial()
-	conn, err := d.Dialer.DialContext(dialContext, network, address)
+	network, err := familyNetwork(network, d.AddressFamily)
 	if err != nil {
 		return nil, err
 	}
+	start := time.Now()
+	var conn net.Conn
+	var resolution *Resolution
+	if len(d.Proxies) > 0 && !isPacketNetwork(network) {
+		conn, resolution, err = dialProxyChain(dialContext, d.Dialer, network, d.Proxies, address)
+	} else if network == "tcp" {
+		// dialHappyEyeballs resolves and checks the addresses itself.
+		conn, resolution, err = dialHappyEyeballs(dialContext, d.Dialer, network, address)
+	} else if address, resolution, err = filter.checkAddress(dialContext, network, address); err == nil {
+		conn, err = dialFromSourcePort(dialContext, d.Dialer, network, address)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if d.SocketOptions != nil && !socketOptionsBeforeConnect {
+		if err := d.SocketOptions.applyToConn(conn); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
 	ret := NewTimeoutConnection(ctx, conn, d.Timeout, d.ReadTimeout, d.WriteTimeout, d.BytesReadLimit)
 	ret.BytesReadLimit = d.BytesReadLimit
 	ret.ReadLimitExceededAction = d.ReadLimitExceededAction
+	ret.resolution = resolution
+	if !isPacketNetwork(network) {
+		rtt := time.Since(start)
+		if resolution != nil {
+			rtt -= resolution.duration
+		}
+		ret.applyRTT(rtt)
+		for _, prelude := range d.Preludes {
+			if err := prelude.Send(ret); err != nil {
+				ret.Close()
+				return nil, err
+			}
+		}
+	}
 	return ret, nil
 }
 
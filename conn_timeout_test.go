@@ -420,3 +420,30 @@ func TestTimeoutConnectionTimeouts(t *testing.T) {
 		cfg.run(t)
 	}
 }
+
+func TestAdaptTimeout(t *testing.T) {
+	defer func(multiplier int, minimum time.Duration) {
+		AdaptiveTimeoutMultiplier = multiplier
+		AdaptiveTimeoutMinimum = minimum
+	}(AdaptiveTimeoutMultiplier, AdaptiveTimeoutMinimum)
+	AdaptiveTimeoutMinimum = 100 * time.Millisecond
+
+	tests := []struct {
+		multiplier int
+		rtt        time.Duration
+		timeout    time.Duration
+		expected   time.Duration
+	}{
+		{multiplier: 0, rtt: 10 * time.Millisecond, timeout: 5 * time.Second, expected: 5 * time.Second},
+		{multiplier: 20, rtt: 0, timeout: 5 * time.Second, expected: 5 * time.Second},
+		{multiplier: 20, rtt: 50 * time.Millisecond, timeout: 5 * time.Second, expected: time.Second},
+		{multiplier: 20, rtt: time.Millisecond, timeout: 5 * time.Second, expected: 100 * time.Millisecond},
+		{multiplier: 20, rtt: time.Second, timeout: 5 * time.Second, expected: 5 * time.Second},
+	}
+	for _, test := range tests {
+		AdaptiveTimeoutMultiplier = test.multiplier
+		if actual := adaptTimeout(test.rtt, test.timeout); actual != test.expected {
+			t.Errorf("adaptTimeout(%s, %s) with multiplier %d: expected %s, got %s", test.rtt, test.timeout, test.multiplier, test.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package zgrab2
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// controlCommand handles a single line received on the control socket,
+// returning the response to send back.
+func controlCommand(line string) (string, error) {
+	switch strings.TrimSpace(line) {
+	case "pause":
+		PauseIntake()
+		return "ok", nil
+	case "resume":
+		ResumeIntake()
+		return "ok", nil
+	case "drain":
+		DrainIntake()
+		return "ok", nil
+	case "status":
+		status, err := json.Marshal(GetProgress())
+		return string(status), err
+	}
+	if err := applyLimitLine(line); err != nil {
+		return "", err
+	}
+	return "ok", nil
+}
+
+// serveControlSocket listens on the given unix socket. Each line received is
+// either a command (pause, resume, drain, status) or a 'name = value' limit
+// setting, and is answered with a single line: "ok", the status JSON, or
+// "error: ...". A socket left at the path by an earlier run is replaced, but
+// any other file there is an error.
+func serveControlSocket(path string) error {
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				log.Errorf("control socket: %v", err)
+				return
+			}
+			go handleControlConnection(conn)
+		}
+	}()
+	return nil
+}
+
+func handleControlConnection(conn net.Conn) {
+	defer conn.Close()
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		if response, err := controlCommand(scanner.Text()); err != nil {
+			fmt.Fprintf(conn, "error: %v\n", err)
+		} else {
+			fmt.Fprintln(conn, response)
+		}
+	}
+}
+
+// pauseOnSignal pauses intake on SIGUSR1 and resumes it on SIGUSR2.
+func pauseOnSignal() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1, syscall.SIGUSR2)
+	handlePauseSignals(signals)
+}
+
+// handlePauseSignals pauses or resumes intake for each SIGUSR1 or SIGUSR2
+// received, until signals is closed.
+func handlePauseSignals(signals <-chan os.Signal) {
+	for sig := range signals {
+		switch sig {
+		case syscall.SIGUSR1:
+			log.Infof("received %s, pausing intake", sig)
+			PauseIntake()
+		case syscall.SIGUSR2:
+			log.Infof("received %s, resuming intake", sig)
+			ResumeIntake()
+		}
+	}
+}
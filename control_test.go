@@ -0,0 +1,155 @@
+package zgrab2
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestServeControlSocketReplacesOnlySockets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zgrab2-control")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(file, []byte("keep"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := serveControlSocket(file); err == nil {
+		t.Error("listened on a path holding a regular file")
+	}
+	if data, err := ioutil.ReadFile(file); err != nil || string(data) != "keep" {
+		t.Errorf("regular file was removed or changed: %q, %v", data, err)
+	}
+
+	stale := filepath.Join(dir, "stale.sock")
+	listener, err := net.Listen("unix", stale)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener.(*net.UnixListener).SetUnlinkOnClose(false)
+	listener.Close()
+	if err := serveControlSocket(stale); err != nil {
+		t.Fatalf("did not replace a stale socket: %v", err)
+	}
+	conn, err := net.Dial("unix", stale)
+	if err != nil {
+		t.Fatalf("could not connect to the control socket: %v", err)
+	}
+	conn.Close()
+}
+
+// withControlState replaces the progress tracker, limits and initial sender
+// count for the duration of a test, returning a function to restore them.
+func withControlState() func() {
+	savedProgress, savedLimits, savedSenders := progress, limits, config.Senders
+	progress = newProgressTracker()
+	limits = &rateLimiter{
+		subnetPrefix:   24,
+		subnetPrefixV6: 48,
+		subnetNext:     make(map[string]time.Time),
+	}
+	config.Senders = 1
+	return func() {
+		progress, limits, config.Senders = savedProgress, savedLimits, savedSenders
+	}
+}
+
+// waitUntil polls condition until it holds, failing the test if it does not
+// within a few seconds.
+func waitUntil(t *testing.T, condition func() bool, description string) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting until %s", description)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestControlSocketCommands(t *testing.T) {
+	defer withControlState()()
+	dir, err := ioutil.TempDir("", "zgrab2-control")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "control.sock")
+	if err := serveControlSocket(path); err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	command := func(line string) string {
+		fmt.Fprintln(conn, line)
+		response, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("%s: %v", line, err)
+		}
+		return strings.TrimSuffix(response, "\n")
+	}
+
+	if response := command("pause"); response != "ok" || !progress.snapshot().Paused {
+		t.Errorf("pause: expected intake to be paused, got %q", response)
+	}
+	var status Progress
+	if err := json.Unmarshal([]byte(command("status")), &status); err != nil || !status.Paused {
+		t.Errorf("status: expected the paused progress, got %+v (%v)", status, err)
+	}
+	if response := command("resume"); response != "ok" || progress.snapshot().Paused {
+		t.Errorf("resume: expected intake to be resumed, got %q", response)
+	}
+	if response := command("drain"); response != "ok" || !progress.snapshot().Paused {
+		t.Errorf("drain: expected intake to be paused, got %q", response)
+	}
+	if response := command("rate = 50"); response != "ok" || limits.rate != 50 {
+		t.Errorf("rate: expected a rate of 50, got %q and %d", response, limits.rate)
+	}
+	if response := command("senders 4"); response != "ok" || config.Senders != 4 {
+		t.Errorf("senders: expected 4 senders, got %q and %d", response, config.Senders)
+	}
+	for _, line := range []string{"bogus", "subnet-prefix = 40", "senders = 0"} {
+		if response := command(line); !strings.HasPrefix(response, "error: ") {
+			t.Errorf("%s: expected an error, got %q", line, response)
+		}
+	}
+	if limits.subnetPrefix != 24 || config.Senders != 4 {
+		t.Errorf("expected invalid settings not to be applied")
+	}
+}
+
+func TestPauseOnSignals(t *testing.T) {
+	defer withControlState()()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1, syscall.SIGUSR2)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		handlePauseSignals(signals)
+	}()
+	defer func() {
+		signal.Stop(signals)
+		close(signals)
+		<-done
+	}()
+
+	syscall.Kill(os.Getpid(), syscall.SIGUSR1)
+	waitUntil(t, func() bool { return progress.snapshot().Paused }, "SIGUSR1 pauses intake")
+	syscall.Kill(os.Getpid(), syscall.SIGUSR2)
+	waitUntil(t, func() bool { return !progress.snapshot().Paused }, "SIGUSR2 resumes intake")
+}
@@ -0,0 +1,271 @@
+package zgrab2
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// nvdSnapshot is the part of an NVD CVE API 2.0 response (as saved with
+// e.g. `curl https://services.nvd.nist.gov/rest/json/cves/2.0`) that is used
+// to look up CVEs.
+type nvdSnapshot struct {
+	Vulnerabilities []struct {
+		CVE struct {
+			ID             string `json:"id"`
+			Configurations []struct {
+				Nodes []struct {
+					CPEMatch []nvdCPEMatch `json:"cpeMatch"`
+				} `json:"nodes"`
+			} `json:"configurations"`
+		} `json:"cve"`
+	} `json:"vulnerabilities"`
+}
+
+// nvdCPEMatch is a CPE match criterion: a CPE, which may give a version, and
+// optionally a range of versions.
+type nvdCPEMatch struct {
+	Vulnerable            bool   `json:"vulnerable"`
+	Criteria              string `json:"criteria"`
+	VersionStartIncluding string `json:"versionStartIncluding"`
+	VersionStartExcluding string `json:"versionStartExcluding"`
+	VersionEndIncluding   string `json:"versionEndIncluding"`
+	VersionEndExcluding   string `json:"versionEndExcluding"`
+}
+
+// cveMatch is a vulnerable version (or range of versions) of a product.
+type cveMatch struct {
+	id string
+
+	// version is the exact version matched, or "" to match the range.
+	version string
+
+	startIncluding, startExcluding string
+	endIncluding, endExcluding     string
+}
+
+// matches returns true if the version is affected.
+func (m *cveMatch) matches(version string) bool {
+	if m.version != "" {
+		return strings.EqualFold(m.version, version)
+	}
+	if m.startIncluding == "" && m.startExcluding == "" && m.endIncluding == "" && m.endExcluding == "" {
+		// All versions are affected.
+		return true
+	}
+	return (m.startIncluding == "" || compareVersions(version, m.startIncluding) >= 0) &&
+		(m.startExcluding == "" || compareVersions(version, m.startExcluding) > 0) &&
+		(m.endIncluding == "" || compareVersions(version, m.endIncluding) <= 0) &&
+		(m.endExcluding == "" || compareVersions(version, m.endExcluding) < 0)
+}
+
+// cveIndex maps the vendor and name of a product to the CVEs affecting it.
+type cveIndex struct {
+	products map[string][]cveMatch
+}
+
+// cves is the index loaded from --cve-file, if any.
+var cves *cveIndex
+
+// loadCVEFile reads an NVD CVE API 2.0 response, which may be gzipped, and
+// indexes the vulnerable CPE matches in it.
+func loadCVEFile(fileName string) (*cveIndex, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var reader io.Reader = file
+	if strings.HasSuffix(fileName, ".gz") {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		reader = gz
+	}
+	return readCVEs(reader)
+}
+
+// readCVEs indexes the vulnerable CPE matches in an NVD CVE API 2.0 response.
+func readCVEs(reader io.Reader) (*cveIndex, error) {
+	var snapshot nvdSnapshot
+	if err := json.NewDecoder(reader).Decode(&snapshot); err != nil {
+		return nil, fmt.Errorf("invalid NVD snapshot: %v", err)
+	}
+	ret := &cveIndex{products: make(map[string][]cveMatch)}
+	for _, vuln := range snapshot.Vulnerabilities {
+		for _, config := range vuln.CVE.Configurations {
+			for _, node := range config.Nodes {
+				for _, match := range node.CPEMatch {
+					if !match.Vulnerable {
+						continue
+					}
+					fields := splitCPE(match.Criteria)
+					if len(fields) < 7 || fields[0] != "cpe" || fields[1] != "2.3" {
+						return nil, fmt.Errorf("%s: invalid CPE %q", vuln.CVE.ID, match.Criteria)
+					}
+					key := fields[3] + ":" + fields[4]
+					m := cveMatch{
+						id:             vuln.CVE.ID,
+						startIncluding: match.VersionStartIncluding,
+						startExcluding: match.VersionStartExcluding,
+						endIncluding:   match.VersionEndIncluding,
+						endExcluding:   match.VersionEndExcluding,
+					}
+					if version := fields[5]; version != "*" && version != "-" {
+						m.version = version
+						if update := fields[6]; update != "*" && update != "-" {
+							// e.g. OpenSSH 8.2p1 is version 8.2, update p1.
+							m.version += update
+						}
+					}
+					ret.products[key] = append(ret.products[key], m)
+				}
+			}
+		}
+	}
+	return ret, nil
+}
+
+// splitCPE splits a CPE 2.3 formatted string into its unescaped attributes.
+func splitCPE(cpe string) []string {
+	var ret []string
+	var field bytes.Buffer
+	for i := 0; i < len(cpe); i++ {
+		switch c := cpe[i]; {
+		case c == '\\' && i+1 < len(cpe):
+			i++
+			field.WriteByte(cpe[i])
+		case c == ':':
+			ret = append(ret, field.String())
+			field.Reset()
+		default:
+			field.WriteByte(c)
+		}
+	}
+	return append(ret, field.String())
+}
+
+// lookup returns the IDs of the CVEs affecting the product, sorted. Products
+// without a vendor or version are not looked up.
+func (index *cveIndex) lookup(product *Product) []string {
+	if index == nil || product == nil || product.Vendor == "" || product.Version == "" {
+		return nil
+	}
+	key := strings.ToLower(product.Vendor) + ":" + strings.ToLower(product.Name)
+	seen := make(map[string]bool)
+	var ret []string
+	for _, m := range index.products[key] {
+		if !seen[m.id] && m.matches(product.Version) {
+			seen[m.id] = true
+			ret = append(ret, m.id)
+		}
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// compareVersions compares two version strings, returning -1, 0 or 1. They
+// are split into runs of digits and of other characters, ignoring '.', '-'
+// and '_'; runs of digits compare numerically, and others compare
+// alphabetically. A version extended by a pre-release tag (e.g. 1.0rc1) is
+// before the version itself, and one extended otherwise (e.g. 8.2p1, 1.0.2a)
+// is after it.
+func compareVersions(a, b string) int {
+	as, bs := versionParts(a), versionParts(b)
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		if c := compareVersionParts(as[i], bs[i]); c != 0 {
+			return c
+		}
+	}
+	switch {
+	case len(as) > len(bs):
+		if isPreRelease(as[len(bs)]) {
+			return -1
+		}
+		return 1
+	case len(as) < len(bs):
+		if isPreRelease(bs[len(as)]) {
+			return 1
+		}
+		return -1
+	}
+	return 0
+}
+
+// preReleaseTags are the version parts that mark pre-releases.
+var preReleaseTags = []string{"alpha", "beta", "rc", "pre", "dev", "snapshot"}
+
+func isPreRelease(part string) bool {
+	part = strings.ToLower(part)
+	for _, tag := range preReleaseTags {
+		if part == tag {
+			return true
+		}
+	}
+	return false
+}
+
+func compareVersionParts(a, b string) int {
+	aNum, bNum := isDigit(a[0]), isDigit(b[0])
+	switch {
+	case aNum && bNum:
+		a, b = strings.TrimLeft(a, "0"), strings.TrimLeft(b, "0")
+		if len(a) != len(b) {
+			return compareInts(len(a), len(b))
+		}
+		return strings.Compare(a, b)
+	case aNum:
+		return 1
+	case bNum:
+		return -1
+	}
+	return strings.Compare(strings.ToLower(a), strings.ToLower(b))
+}
+
+// versionParts splits a version into runs of digits and of other characters.
+func versionParts(version string) []string {
+	var ret []string
+	start := -1
+	flush := func(end int) {
+		if start >= 0 {
+			ret = append(ret, version[start:end])
+		}
+		start = -1
+	}
+	for i := 0; i < len(version); i++ {
+		c := version[i]
+		if c == '.' || c == '-' || c == '_' {
+			flush(i)
+			continue
+		}
+		if start >= 0 && isDigit(c) != isDigit(version[start]) {
+			flush(i)
+		}
+		if start < 0 {
+			start = i
+		}
+	}
+	flush(len(version))
+	return ret
+}
+
+func compareInts(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
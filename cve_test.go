@@ -0,0 +1,68 @@
+package zgrab2
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testNVDSnapshot = `{
+  "vulnerabilities": [
+    {"cve": {"id": "CVE-2020-0001", "configurations": [{"nodes": [{"cpeMatch": [
+      {"vulnerable": true, "criteria": "cpe:2.3:a:openbsd:openssh:*:*:*:*:*:*:*:*", "versionEndExcluding": "8.3"}
+    ]}]}]}},
+    {"cve": {"id": "CVE-2020-0002", "configurations": [{"nodes": [{"cpeMatch": [
+      {"vulnerable": true, "criteria": "cpe:2.3:a:openbsd:openssh:8.2:p1:*:*:*:*:*:*"},
+      {"vulnerable": false, "criteria": "cpe:2.3:o:linux:linux_kernel:-:*:*:*:*:*:*:*"}
+    ]}]}]}},
+    {"cve": {"id": "CVE-2021-0003", "configurations": [{"nodes": [{"cpeMatch": [
+      {"vulnerable": true, "criteria": "cpe:2.3:a:apache:http_server:*:*:*:*:*:*:*:*", "versionStartIncluding": "2.4.0", "versionEndIncluding": "2.4.49"}
+    ]}]}]}}
+  ]
+}`
+
+func TestCVELookup(t *testing.T) {
+	index, err := readCVEs(strings.NewReader(testNVDSnapshot))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		product *Product
+		cves    []string
+	}{
+		{NewProduct(CPEApplication, "openbsd", "openssh", "8.2p1"), []string{"CVE-2020-0001", "CVE-2020-0002"}},
+		{NewProduct(CPEApplication, "openbsd", "openssh", "8.3p1"), nil},
+		{NewProduct(CPEApplication, "openbsd", "openssh", "7.4"), []string{"CVE-2020-0001"}},
+		{NewProduct(CPEApplication, "apache", "http_server", "2.4.49"), []string{"CVE-2021-0003"}},
+		{NewProduct(CPEApplication, "apache", "http_server", "2.4.50"), nil},
+		{NewProduct(CPEApplication, "apache", "http_server", ""), nil},
+		{NewProduct(CPEOperatingSystem, "linux", "linux_kernel", "5.4"), nil},
+	}
+	for _, test := range tests {
+		if cves := index.lookup(test.product); !reflect.DeepEqual(cves, test.cves) {
+			t.Errorf("%s: expected %v, got %v", test.product.CPE, test.cves, cves)
+		}
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		expected int
+	}{
+		{"2.4.9", "2.4.10", -1},
+		{"1.0", "1.0", 0},
+		{"1.0.1", "1.0", 1},
+		{"1.0rc1", "1.0", -1},
+		{"8.2p1", "8.2", 1},
+		{"010", "10", 0},
+		{"1.a", "1.b", -1},
+		{"1.0.2a", "1.0.2", 1},
+		{"2.0-beta", "2.0", -1},
+	}
+	for _, test := range tests {
+		if c := compareVersions(test.a, test.b); c != test.expected {
+			t.Errorf("compareVersions(%q, %q): expected %d, got %d", test.a, test.b, test.expected, c)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package zgrab2
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// exactEntryOverhead is the approximate memory used by an entry in the exact
+// dedup set, in addition to the length of its key.
+const exactEntryOverhead = 64
+
+// bloomHashes is the number of hash functions used by the bloom filter.
+const bloomHashes = 4
+
+// bloomFilter is a fixed-size probabilistic set. It may report that a key is
+// present when it is not, but never the reverse.
+type bloomFilter struct {
+	bits []uint64
+}
+
+func newBloomFilter(budget int64) *bloomFilter {
+	words := budget / 8
+	if words < 1 {
+		words = 1
+	}
+	return &bloomFilter{bits: make([]uint64, words)}
+}
+
+// add adds the key to the filter, and returns true if it was (probably)
+// already present.
+func (f *bloomFilter) add(key string) bool {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	sum := h.Sum64()
+	h1, h2 := sum&0xffffffff, (sum>>32)|1
+	size := uint64(len(f.bits)) * 64
+	present := true
+	for i := uint64(0); i < bloomHashes; i++ {
+		bit := (h1 + i*h2) % size
+		word, mask := bit/64, uint64(1)<<(bit%64)
+		if f.bits[word]&mask == 0 {
+			present = false
+			f.bits[word] |= mask
+		}
+	}
+	return present
+}
+
+// targetDeduper implements --dedup. It keeps an exact set of the targets seen
+// until the set outgrows the memory budget (or from the start, for
+// --dedup-filter=bloom), after which it uses a bloom filter of the budget's
+// size.
+type targetDeduper struct {
+	mutex      sync.Mutex
+	budget     int64
+	used       int64
+	exact      map[string]struct{}
+	bloom      *bloomFilter
+	duplicates uint64
+}
+
+var dedup *targetDeduper
+
+func newTargetDeduper(filter string, budgetMB int) (*targetDeduper, error) {
+	if budgetMB <= 0 {
+		return nil, fmt.Errorf("dedup memory budget must be positive, given %d", budgetMB)
+	}
+	d := &targetDeduper{budget: int64(budgetMB) << 20}
+	switch filter {
+	case "exact":
+		d.exact = make(map[string]struct{})
+	case "bloom":
+		d.bloom = newBloomFilter(d.budget)
+	default:
+		return nil, fmt.Errorf("unknown dedup filter %q", filter)
+	}
+	return d, nil
+}
+
+// dedupKey identifies a target for deduplication. The target's tag determines
+// which modules are run on it, so targets with the same address, port and tag
+// result in identical probes.
+func dedupKey(target *ScanTarget) string {
+	port := "-"
+	if target.Port != nil {
+		port = fmt.Sprintf("%d", *target.Port)
+	}
+	return fmt.Sprintf("%s|%s|%s|%s", target.IP, target.Domain, port, target.Tag)
+}
+
+// duplicate records the target, and returns true if it has been seen before.
+func (d *targetDeduper) duplicate(target *ScanTarget) bool {
+	key := dedupKey(target)
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	var seen bool
+	if d.exact != nil {
+		_, seen = d.exact[key]
+		if !seen {
+			d.exact[key] = struct{}{}
+			d.used += int64(len(key)) + exactEntryOverhead
+			if d.used > d.budget {
+				d.switchToBloom()
+			}
+		}
+	} else {
+		seen = d.bloom.add(key)
+	}
+	if seen {
+		d.duplicates++
+	}
+	return seen
+}
+
+// switchToBloom replaces the exact set with a bloom filter containing the
+// same keys.
+func (d *targetDeduper) switchToBloom() {
+	log.Warnf("dedup: %d targets exceed the memory budget of %d MB; switching to a bloom filter, which may skip some unique targets", len(d.exact), d.budget>>20)
+	d.bloom = newBloomFilter(d.budget)
+	for key := range d.exact {
+		d.bloom.add(key)
+	}
+	d.exact = nil
+}
+
+// GetDuplicateTargets returns the number of targets skipped by --dedup.
+func GetDuplicateTargets() uint64 {
+	if dedup == nil {
+		return 0
+	}
+	dedup.mutex.Lock()
+	defer dedup.mutex.Unlock()
+	return dedup.duplicates
+}
@@ -0,0 +1,54 @@
+package zgrab2
+
+import (
+	"fmt"
+	"net"
+	"testing"
+)
+
+func TestTargetDeduper(t *testing.T) {
+	for _, filter := range []string{"exact", "bloom"} {
+		d, err := newTargetDeduper(filter, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		port := uint(443)
+		targets := []ScanTarget{
+			{IP: net.ParseIP("192.0.2.1")},
+			{IP: net.ParseIP("192.0.2.1"), Tag: "tls"},
+			{IP: net.ParseIP("192.0.2.1"), Port: &port},
+			{IP: net.ParseIP("192.0.2.1"), Domain: "example.com"},
+			{IP: net.ParseIP("192.0.2.2")},
+		}
+		for i := range targets {
+			if d.duplicate(&targets[i]) {
+				t.Errorf("%s: target %s wrongly reported as a duplicate", filter, targets[i].String())
+			}
+		}
+		for i := range targets {
+			if !d.duplicate(&targets[i]) {
+				t.Errorf("%s: duplicate of target %s not detected", filter, targets[i].String())
+			}
+		}
+		if d.duplicates != uint64(len(targets)) {
+			t.Errorf("%s: expected %d duplicates, got %d", filter, len(targets), d.duplicates)
+		}
+	}
+}
+
+func TestTargetDeduperOverBudget(t *testing.T) {
+	d, err := newTargetDeduper("exact", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := (1<<20)/exactEntryOverhead + 1
+	for i := 0; i < n; i++ {
+		d.duplicate(&ScanTarget{Domain: fmt.Sprintf("host%d.example.com", i)})
+	}
+	if d.exact != nil || d.bloom == nil {
+		t.Fatalf("expected the exact set to be replaced by a bloom filter")
+	}
+	if !d.duplicate(&ScanTarget{Domain: "host0.example.com"}) {
+		t.Errorf("target seen before the switch was not detected as a duplicate")
+	}
+}
@@ -0,0 +1,230 @@
+package zgrab2
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+)
+
+// DescribeCommand contains the command line options for describing modules
+// (zgrab2 describe [module...]).
+type DescribeCommand struct {
+	// modules are the names given, or every registered module.
+	modules []string
+}
+
+// Validate checks that each module named exists.
+func (x *DescribeCommand) Validate(args []string) error {
+	for _, name := range args {
+		if GetModule(name) == nil {
+			return fmt.Errorf("unknown module %s", name)
+		}
+	}
+	x.modules = args
+	return nil
+}
+
+// Help returns a usage string that will be output at the command line
+func (x *DescribeCommand) Help() string {
+	return "Prints a JSON description of each module given, or of every module."
+}
+
+// Intrusiveness levels of a module as configured by default, from least to
+// most intrusive.
+const (
+	// IntrusivenessIdentification modules only send what is needed to
+	// identify the service and read what it offers anonymously.
+	IntrusivenessIdentification = "identification"
+
+	// IntrusivenessAuthenticating modules present credentials or attempt to
+	// authenticate (ProbeAuthenticating).
+	IntrusivenessAuthenticating = ProbeAuthenticating
+
+	// IntrusivenessStateChanging modules may change state on the target
+	// (ProbeStateChanging).
+	IntrusivenessStateChanging = ProbeStateChanging
+)
+
+// ModulePort is a default port of a module.
+type ModulePort struct {
+	Port uint `json:"port"`
+	TLS  bool `json:"tls,omitempty"`
+}
+
+// FlagDescription describes a flag of a module.
+type FlagDescription struct {
+	Name  string `json:"name"`
+	Short string `json:"short,omitempty"`
+
+	// Type is bool, int, uint, float, string, duration, or list of one of
+	// these, e.g. "list of string".
+	Type        string   `json:"type"`
+	Default     []string `json:"default,omitempty"`
+	Choices     []string `json:"choices,omitempty"`
+	Description string   `json:"description,omitempty"`
+}
+
+// ModuleDescription is the machine-readable metadata of a module, printed by
+// zgrab2 describe, from which UIs and orchestration layers can build scans
+// without hardcoding the modules.
+type ModuleDescription struct {
+	Name             string `json:"name"`
+	Protocol         string `json:"protocol"`
+	ShortDescription string `json:"short_description,omitempty"`
+	LongDescription  string `json:"long_description,omitempty"`
+
+	DefaultPorts []ModulePort `json:"default_ports"`
+
+	// Transports are those the module scans over, in order of preference.
+	Transports []string `json:"transports"`
+
+	// TLS is true if the module can speak TLS (it takes the TLS flags).
+	TLS bool `json:"tls"`
+
+	Flags []*FlagDescription `json:"flags"`
+
+	// ResultSchema is the name under which zgrab2_schemas registers the
+	// zschema of the module's scan response.
+	ResultSchema string `json:"result_schema"`
+
+	// Intrusiveness is that of the module as configured by default, and
+	// ProbeClasses the classes of the probes it then sends (see
+	// --policy-file).
+	Intrusiveness string   `json:"intrusiveness"`
+	ProbeClasses  []string `json:"probe_classes,omitempty"`
+
+	// ICS is true for modules of industrial control system protocols, and
+	// ICSSafe if they can be run with --ics-safe.
+	ICS     bool `json:"ics,omitempty"`
+	ICSSafe bool `json:"ics_safe,omitempty"`
+
+	// InitError is the error initializing the module with its defaults, if
+	// it could not be, in which case its transports and intrusiveness are
+	// those of its flags alone.
+	InitError string `json:"init_error,omitempty"`
+}
+
+// flagType returns the FlagDescription type of a flag's field.
+func flagType(t reflect.Type) string {
+	if t == durationType {
+		return "duration"
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return "bool"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return "int"
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "uint"
+	case reflect.Float32, reflect.Float64:
+		return "float"
+	case reflect.String:
+		return "string"
+	case reflect.Slice:
+		return "list of " + flagType(t.Elem())
+	}
+	return t.String()
+}
+
+// DescribeModule returns the description of the named module.
+func DescribeModule(name string) (*ModuleDescription, error) {
+	mod := GetModule(name)
+	cmd := parser.Find(name)
+	if mod == nil || cmd == nil {
+		return nil, fmt.Errorf("unknown module %s", name)
+	}
+	ret := &ModuleDescription{
+		Name:             name,
+		ShortDescription: cmd.ShortDescription,
+		LongDescription:  cmd.LongDescription,
+		ResultSchema:     "zgrab2-" + name,
+		Intrusiveness:    IntrusivenessIdentification,
+	}
+	if ports, ok := moduleDefaultPortLists[name]; ok {
+		for _, port := range ports {
+			ret.DefaultPorts = append(ret.DefaultPorts, ModulePort{Port: port.Port, TLS: port.TLS})
+		}
+	} else {
+		ret.DefaultPorts = []ModulePort{{Port: moduleDefaultPorts[name]}}
+	}
+	for _, option := range cmd.Options() {
+		if option.Hidden || option.LongName == "" {
+			continue
+		}
+		flag := &FlagDescription{
+			Name:        option.LongName,
+			Type:        flagType(option.Field().Type),
+			Default:     option.Default,
+			Choices:     option.Choices,
+			Description: option.Description,
+		}
+		if option.ShortName != 0 {
+			flag.Short = string(option.ShortName)
+		}
+		ret.Flags = append(ret.Flags, flag)
+	}
+	sort.Slice(ret.Flags, func(i, j int) bool { return ret.Flags[i].Name < ret.Flags[j].Name })
+
+	flags, err := NewModuleFlags(name)
+	if err != nil {
+		return nil, err
+	}
+	fields := reflect.ValueOf(flags).Elem()
+	ret.TLS = fields.FieldByName("TLSFlags").IsValid()
+	ret.Transports = []string{TransportTCP}
+	if transports := fields.FieldByName("TransportFlags"); transports.IsValid() {
+		if ret.Transports, err = ParseTransports(transports.Interface().(TransportFlags).Transports); err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+	} else if fields.FieldByName("UDPFlags").IsValid() {
+		ret.Transports = []string{TransportUDP}
+	}
+	s := mod.NewScanner()
+	ret.Protocol = s.Protocol()
+	ret.ICS = icsProtocols[ret.Protocol]
+	_, ret.ICSSafe = s.(ICSScanner)
+	// The scanner is initialized with the defaults to find how it behaves
+	// when run without flags. Some need flags or files to be initialized,
+	// and are described without.
+	if err := s.Init(flags); err != nil {
+		ret.InitError = err.Error()
+		return ret, nil
+	}
+	if multi, ok := s.(MultiTransportScanner); ok {
+		ret.Transports = multi.GetTransports()
+	}
+	if classified, ok := s.(ClassifiedScanner); ok {
+		ret.ProbeClasses = classified.ProbeClasses()
+		for _, class := range ret.ProbeClasses {
+			if class == ProbeStateChanging || ret.Intrusiveness == IntrusivenessIdentification {
+				ret.Intrusiveness = class
+			}
+		}
+	}
+	return ret, nil
+}
+
+// Describe writes the description of each module given to the describe
+// command, or of every registered module, as a line of JSON.
+func (x *DescribeCommand) Describe(w io.Writer) error {
+	names := x.modules
+	if len(names) == 0 {
+		for name := range modules {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+	enc := json.NewEncoder(w)
+	for _, name := range names {
+		description, err := DescribeModule(name)
+		if err != nil {
+			return err
+		}
+		if err := enc.Encode(description); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package zgrab2
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type describedFlags struct {
+	BaseFlags
+	TLSFlags
+	TransportFlags
+	Method  string        `long:"method" default:"GET" choice:"GET" choice:"POST" description:"Method"`
+	Headers []string      `long:"header" description:"Headers"`
+	Wait    time.Duration `long:"wait" default:"1s" description:"Wait"`
+}
+
+func (f *describedFlags) Validate(args []string) error { return nil }
+func (f *describedFlags) Help() string                 { return "" }
+
+// describedScanner is an echoScanner that authenticates, over the transports
+// given.
+type describedScanner struct {
+	echoScanner
+	transports []string
+}
+
+func (s *describedScanner) Init(flags ScanFlags) error {
+	var err error
+	s.transports, err = ParseTransports(flags.(*describedFlags).Transports)
+	return err
+}
+func (s *describedScanner) Protocol() string        { return "described" }
+func (s *describedScanner) GetTransports() []string { return s.transports }
+func (s *describedScanner) ProbeClasses() []string  { return []string{ProbeAuthenticating} }
+
+type describedModule struct{}
+
+func (m *describedModule) NewFlags() interface{} { return new(describedFlags) }
+func (m *describedModule) NewScanner() Scanner   { return new(describedScanner) }
+
+func TestDescribeModule(t *testing.T) {
+	cmd, err := AddCommandWithPorts("described", "Described", "A module to describe", []DefaultPort{{Port: 80}, {Port: 443, TLS: true}}, new(describedModule))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd.FindOptionByLongName("transports").Default = []string{"udp,tcp"}
+	defer delete(modules, "described")
+
+	describe := new(DescribeCommand)
+	if err := describe.Validate([]string{"nope"}); err == nil {
+		t.Error("expected an error for an unknown module")
+	}
+	if err := describe.Validate([]string{"described"}); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := describe.Describe(&out); err != nil {
+		t.Fatal(err)
+	}
+	var description ModuleDescription
+	if err := json.Unmarshal(out.Bytes(), &description); err != nil {
+		t.Fatal(err)
+	}
+	expected := ModuleDescription{
+		Name:             "described",
+		Protocol:         "described",
+		ShortDescription: "Described",
+		LongDescription:  "A module to describe",
+		DefaultPorts:     []ModulePort{{Port: 80}, {Port: 443, TLS: true}},
+		Transports:       []string{TransportUDP, TransportTCP},
+		TLS:              true,
+		ResultSchema:     "zgrab2-described",
+		Intrusiveness:    IntrusivenessAuthenticating,
+		ProbeClasses:     []string{ProbeAuthenticating},
+	}
+	flags := make(map[string]*FlagDescription)
+	for _, flag := range description.Flags {
+		flags[flag.Name] = flag
+	}
+	description.Flags = nil
+	if !reflect.DeepEqual(description, expected) {
+		t.Errorf("expected %+v, got %+v", expected, description)
+	}
+	for _, test := range []FlagDescription{
+		{Name: "method", Type: "string", Default: []string{"GET"}, Choices: []string{"GET", "POST"}, Description: "Method"},
+		{Name: "header", Type: "list of string", Description: "Headers"},
+		{Name: "wait", Type: "duration", Default: []string{"1s"}, Description: "Wait"},
+		{Name: "timeout", Short: "t", Type: "duration", Default: []string{"10s"}, Description: "Set connection timeout (0 = no timeout)"},
+	} {
+		if got := flags[test.Name]; got == nil || !reflect.DeepEqual(*got, test) {
+			t.Errorf("%s: expected %+v, got %+v", test.Name, test, got)
+		}
+	}
+	if flags["default-ports"] == nil || !reflect.DeepEqual(flags["default-ports"].Default, []string{"80,443/tls"}) {
+		t.Errorf("default-ports: unexpected %+v", flags["default-ports"])
+	}
+	if flags["port"] == nil || !reflect.DeepEqual(flags["port"].Default, []string{"80"}) {
+		t.Errorf("port: expected the first default port, got %+v", flags["port"])
+	}
+}
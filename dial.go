@@ -0,0 +1,240 @@
+package zgrab2
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// AddressFamilyAny allows connections over either IPv4 or IPv6; if a name
+	// resolves to both, connection attempts are raced (see RFC 8305).
+	AddressFamilyAny = "any"
+
+	// AddressFamilyIPv4 restricts connections to IPv4.
+	AddressFamilyIPv4 = "ipv4"
+
+	// AddressFamilyIPv6 restricts connections to IPv6.
+	AddressFamilyIPv6 = "ipv6"
+)
+
+// HappyEyeballsDelay is the time to wait for a connection attempt to succeed
+// before starting the next one in parallel (the RFC 8305 "Connection Attempt
+// Delay").
+var HappyEyeballsDelay = 250 * time.Millisecond
+
+// familyNetwork returns the network to dial to restrict network (e.g. "tcp")
+// to the given address family.
+func familyNetwork(network string, family string) (string, error) {
+	switch family {
+	case "", AddressFamilyAny:
+		return network, nil
+	case AddressFamilyIPv4:
+		switch network {
+		case "tcp", "tcp4":
+			return "tcp4", nil
+		case "udp", "udp4":
+			return "udp4", nil
+		}
+	case AddressFamilyIPv6:
+		switch network {
+		case "tcp", "tcp6":
+			return "tcp6", nil
+		case "udp", "udp6":
+			return "udp6", nil
+		}
+	default:
+		return "", fmt.Errorf("unknown address family %q", family)
+	}
+	return "", fmt.Errorf("cannot restrict network %s to address family %s", network, family)
+}
+
+// addrIP returns the IP address of the given address, or nil if it does not
+// have one.
+func addrIP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.IP
+	case *net.UDPAddr:
+		return a.IP
+	case *net.IPAddr:
+		return a.IP
+	}
+	return nil
+}
+
+// addressFamily returns the family of the given address ("ipv4" or "ipv6"),
+// or "" if it is not an IP address.
+func addressFamily(addr net.Addr) string {
+	ip := addrIP(addr)
+	if ip == nil {
+		return ""
+	}
+	if ip.To4() != nil {
+		return AddressFamilyIPv4
+	}
+	return AddressFamilyIPv6
+}
+
+// interleaveFamilies orders addresses per RFC 8305 section 4: starting with
+// the family of the first address, alternate between IPv6 and IPv4.
+func interleaveFamilies(addrs []net.IPAddr) []net.IP {
+	var first, second []net.IP
+	firstIsV4 := len(addrs) > 0 && addrs[0].IP.To4() != nil
+	for _, addr := range addrs {
+		if (addr.IP.To4() != nil) == firstIsV4 {
+			first = append(first, addr.IP)
+		} else {
+			second = append(second, addr.IP)
+		}
+	}
+	ret := make([]net.IP, 0, len(addrs))
+	for i := 0; i < len(first) || i < len(second); i++ {
+		if i < len(first) {
+			ret = append(ret, first[i])
+		}
+		if i < len(second) {
+			ret = append(ret, second[i])
+		}
+	}
+	return ret
+}
+
+// dialHappyEyeballs resolves the host in address and races connection
+// attempts to its addresses, starting a new attempt every HappyEyeballsDelay
+// (or immediately when an attempt fails). The first connection to succeed is
+// returned, and the others are cancelled. If the host is a name, the
+// returned Resolution records how it was resolved. With --nat64-prefix,
+// IPv4 addresses are dialed at their NAT64 addresses.
+func dialHappyEyeballs(ctx context.Context, dialer *net.Dialer, network, address string) (net.Conn, *Resolution, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, nil, err
+	}
+	if ip := parseHostIP(host); ip != nil {
+		if err := filter.check(ip); err != nil {
+			return nil, nil, err
+		}
+		if dial := nat64IP(ip); !dial.Equal(ip) {
+			address = net.JoinHostPort(dial.String(), port)
+		}
+		conn, err := dialFromSourcePort(ctx, dialer, network, address)
+		return conn, nil, err
+	}
+	resolved, resolution, err := resolver.lookup(ctx, host)
+	if err != nil {
+		return nil, nil, err
+	}
+	if resolved, err = filter.filterAddrs(resolved); err != nil {
+		return nil, resolution, err
+	}
+	addrs := interleaveFamilies(nat64Addrs(resolved))
+	if len(addrs) == 1 {
+		conn, err := dialFromSourcePort(ctx, dialer, network, net.JoinHostPort(addrs[0].String(), port))
+		return conn, resolution, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	type attempt struct {
+		conn net.Conn
+		err  error
+	}
+	results := make(chan attempt, len(addrs))
+	next, pending := 0, 0
+	var delay <-chan time.Time
+	start := func() {
+		addr := net.JoinHostPort(addrs[next].String(), port)
+		next++
+		pending++
+		go func() {
+			conn, err := dialFromSourcePort(ctx, dialer, network, addr)
+			results <- attempt{conn: conn, err: err}
+		}()
+		delay = nil
+		if next < len(addrs) {
+			delay = time.After(HappyEyeballsDelay)
+		}
+	}
+
+	var firstErr error
+	start()
+	for pending > 0 {
+		select {
+		case res := <-results:
+			pending--
+			if res.err == nil {
+				// Close any losing attempts that connect before they notice
+				// the cancellation.
+				go func(n int) {
+					for ; n > 0; n-- {
+						if late := <-results; late.conn != nil {
+							late.conn.Close()
+						}
+					}
+				}(pending)
+				return res.conn, resolution, nil
+			}
+			if firstErr == nil {
+				firstErr = res.err
+			}
+			if next < len(addrs) {
+				start()
+			}
+		case <-delay:
+			start()
+		}
+	}
+	return nil, resolution, firstErr
+}
+
+// scanLog records framework-level details of the connections made during a
+// single scan, for inclusion in the ScanResponse.
+type scanLog struct {
+	mutex         sync.Mutex
+	addressFamily string
+	nat64Address  string
+	resolution    *Resolution
+	phases        phaseTimes
+	datagrams     datagramCounts
+}
+
+// RecordConnection notes details of a connection opened for the current scan
+// of the target (e.g. the address family used). Connections opened via
+// Open / OpenTLS / OpenUDP are recorded automatically.
+func (target *ScanTarget) RecordConnection(conn net.Conn) {
+	if target.log == nil || conn == nil {
+		return
+	}
+	target.log.mutex.Lock()
+	defer target.log.mutex.Unlock()
+	if family := addressFamily(conn.RemoteAddr()); family != "" {
+		target.log.addressFamily = family
+	}
+	if ip := addrIP(conn.RemoteAddr()); ip != nil && isNAT64Address(ip) {
+		target.log.nat64Address = ip.String()
+	}
+	if tc, ok := conn.(*TimeoutConnection); ok {
+		tc.log = target.log
+		_, tc.datagram = tc.Conn.(*net.UDPConn)
+		target.log.phases.connect += tc.ConnectRTT
+		if tc.resolution != nil {
+			target.log.resolution = tc.resolution
+			target.log.phases.resolve += tc.resolution.duration
+		}
+	}
+}
+
+// recordResolution notes how the target's name was resolved for the current
+// scan.
+func (target *ScanTarget) recordResolution(resolution *Resolution) {
+	if target.log == nil || resolution == nil {
+		return
+	}
+	target.log.mutex.Lock()
+	defer target.log.mutex.Unlock()
+	target.log.resolution = resolution
+	target.log.phases.resolve += resolution.duration
+}
@@ -0,0 +1,47 @@
+package zgrab2
+
+import (
+	"net"
+	"testing"
+)
+
+func TestInterleaveFamilies(t *testing.T) {
+	var addrs []net.IPAddr
+	for _, s := range []string{"2001:db8::1", "2001:db8::2", "2001:db8::3", "192.0.2.1", "192.0.2.2"} {
+		addrs = append(addrs, net.IPAddr{IP: net.ParseIP(s)})
+	}
+	expected := []string{"2001:db8::1", "192.0.2.1", "2001:db8::2", "192.0.2.2", "2001:db8::3"}
+	actual := interleaveFamilies(addrs)
+	if len(actual) != len(expected) {
+		t.Fatalf("expected %d addresses, got %d", len(expected), len(actual))
+	}
+	for i, ip := range actual {
+		if ip.String() != expected[i] {
+			t.Errorf("address %d: expected %s, got %s", i, expected[i], ip)
+		}
+	}
+}
+
+func TestFamilyNetwork(t *testing.T) {
+	tests := []struct {
+		network  string
+		family   string
+		expected string
+		success  bool
+	}{
+		{network: "tcp", family: "", expected: "tcp", success: true},
+		{network: "tcp", family: AddressFamilyAny, expected: "tcp", success: true},
+		{network: "tcp", family: AddressFamilyIPv4, expected: "tcp4", success: true},
+		{network: "udp", family: AddressFamilyIPv6, expected: "udp6", success: true},
+		{network: "tcp4", family: AddressFamilyIPv6, success: false},
+		{network: "tcp", family: "ipx", success: false},
+	}
+	for _, test := range tests {
+		actual, err := familyNetwork(test.network, test.family)
+		if (err == nil) != test.success {
+			t.Errorf("familyNetwork(%s, %s): expected success=%v, got error %v", test.network, test.family, test.success, err)
+		} else if actual != test.expected {
+			t.Errorf("familyNetwork(%s, %s): expected %s, got %s", test.network, test.family, test.expected, actual)
+		}
+	}
+}
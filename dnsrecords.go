@@ -0,0 +1,252 @@
+package zgrab2
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+)
+
+// DNS record types queried directly, for records the Go resolver cannot
+// look up.
+const (
+	dnsTypeOPT   = 41
+	dnsTypeHTTPS = 65
+)
+
+// dnsParamECH is the HTTPS record SvcParamKey holding an ECHConfigList.
+const dnsParamECH = 5
+
+// errDNSTruncated is returned for a UDP response that was truncated.
+var errDNSTruncated = errors.New("DNS response truncated")
+
+// systemNameserver returns the address of the first nameserver in
+// /etc/resolv.conf, or of a local nameserver if there is none.
+func systemNameserver() string {
+	if f, err := os.Open("/etc/resolv.conf"); err == nil {
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) >= 2 && fields[0] == "nameserver" && net.ParseIP(fields[1]) != nil {
+				return net.JoinHostPort(fields[1], "53")
+			}
+		}
+	}
+	return "127.0.0.1:53"
+}
+
+// dnsQuery returns a recursive query for the records of the given type for
+// the name, with its ID.
+func dnsQuery(name string, qtype uint16) ([]byte, uint16, error) {
+	id := make([]byte, 2)
+	if _, err := rand.Read(id); err != nil {
+		return nil, 0, err
+	}
+	// One question and, for an EDNS0 OPT record advertising a larger UDP
+	// payload size, one additional record.
+	query := append(id, 0x01, 0x00, 0, 1, 0, 0, 0, 0, 0, 1)
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		if len(label) == 0 || len(label) > 63 {
+			return nil, 0, fmt.Errorf("invalid name %q", name)
+		}
+		query = append(query, byte(len(label)))
+		query = append(query, label...)
+	}
+	query = append(query, 0)
+	query = append(query, TLSUint16s(qtype, 1)...)
+	query = append(query, 0)
+	query = append(query, TLSUint16s(dnsTypeOPT, 1232, 0, 0, 0)...)
+	return query, binary.BigEndian.Uint16(id), nil
+}
+
+// skipDNSName returns the offset after the (possibly compressed) name at
+// offset i in msg, or -1 if it is malformed.
+func skipDNSName(msg []byte, i int) int {
+	for i >= 0 && i < len(msg) {
+		n := int(msg[i])
+		switch {
+		case n == 0:
+			return i + 1
+		case n&0xc0 == 0xc0:
+			if i+2 > len(msg) {
+				return -1
+			}
+			return i + 2
+		}
+		i += 1 + n
+	}
+	return -1
+}
+
+// dnsAnswers returns the RDATA of the answers of the given type in the
+// response to the query with the given ID. A response saying the name does
+// not exist has no answers.
+func dnsAnswers(msg []byte, id, qtype uint16) ([][]byte, error) {
+	if len(msg) < 12 || binary.BigEndian.Uint16(msg) != id || msg[2]&0x80 == 0 {
+		return nil, errors.New("invalid DNS response")
+	}
+	if msg[2]&0x02 != 0 {
+		return nil, errDNSTruncated
+	}
+	switch rcode := msg[3] & 0x0f; rcode {
+	case 0, 3:
+	default:
+		return nil, fmt.Errorf("DNS response code %d", rcode)
+	}
+	questions := int(binary.BigEndian.Uint16(msg[4:]))
+	answers := int(binary.BigEndian.Uint16(msg[6:]))
+	i := 12
+	for ; questions > 0 && i >= 0; questions-- {
+		if i = skipDNSName(msg, i); i >= 0 {
+			i += 4
+		}
+	}
+	var ret [][]byte
+	for ; answers > 0; answers-- {
+		if i = skipDNSName(msg, i); i < 0 || i+10 > len(msg) {
+			return nil, errors.New("invalid DNS response")
+		}
+		typ := binary.BigEndian.Uint16(msg[i:])
+		length := int(binary.BigEndian.Uint16(msg[i+8:]))
+		i += 10
+		if i+length > len(msg) {
+			return nil, errors.New("invalid DNS response")
+		}
+		if typ == qtype {
+			ret = append(ret, msg[i:i+length])
+		}
+		i += length
+	}
+	return ret, nil
+}
+
+// dnsExchange sends the query over conn and returns the response. Stream
+// transports (TCP and TLS) prefix messages with their length.
+func dnsExchange(ctx context.Context, conn net.Conn, query []byte, stream bool) ([]byte, error) {
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if !stream {
+		if _, err := conn.Write(query); err != nil {
+			return nil, err
+		}
+		buf := make([]byte, 65535)
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	}
+	if _, err := conn.Write(append(TLSUint16s(uint16(len(query))), query...)); err != nil {
+		return nil, err
+	}
+	length := make([]byte, 2)
+	if _, err := io.ReadFull(conn, length); err != nil {
+		return nil, err
+	}
+	msg := make([]byte, binary.BigEndian.Uint16(length))
+	if _, err := io.ReadFull(conn, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// lookupRecords returns the RDATA of the records of the given type for the
+// name, from the configured nameservers over each of their transports in
+// turn, or else from the system's nameserver over UDP (and TCP, if the
+// response is truncated).
+func (r *dnsResolver) lookupRecords(ctx context.Context, name string, qtype uint16) ([][]byte, error) {
+	query, id, err := dnsQuery(name, qtype)
+	if err != nil {
+		return nil, err
+	}
+	dial := func(ctx context.Context, network, _ string) (net.Conn, error) {
+		var dialer net.Dialer
+		return dialer.DialContext(ctx, network, systemNameserver())
+	}
+	transports := []string{dnsTransportUDP}
+	if len(r.servers) > 0 {
+		transports = r.transports
+	}
+	for _, transport := range transports {
+		if len(r.servers) > 0 {
+			dial = r.dialer(transport, new(dnsServerRecord))
+		}
+		network := "udp"
+		if transport != dnsTransportUDP {
+			network = "tcp"
+		}
+		var answers [][]byte
+		answers, err = queryDNS(ctx, dial, network, query, id, qtype)
+		if err == errDNSTruncated {
+			answers, err = queryDNS(ctx, dial, "tcp", query, id, qtype)
+		}
+		if err == nil {
+			return answers, nil
+		}
+	}
+	return nil, err
+}
+
+// queryDNS sends the query to a nameserver dialed with dial, returning the
+// answers of the given type.
+func queryDNS(ctx context.Context, dial func(ctx context.Context, network, address string) (net.Conn, error), network string, query []byte, id, qtype uint16) ([][]byte, error) {
+	conn, err := dial(ctx, network, "")
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	_, isPacket := conn.(net.PacketConn)
+	msg, err := dnsExchange(ctx, conn, query, !isPacket)
+	if err != nil {
+		return nil, err
+	}
+	return dnsAnswers(msg, id, qtype)
+}
+
+// lookupECHConfigList returns the ECHConfigList in the HTTPS record (RFC
+// 9460) for the name, from the ServiceMode record with the lowest priority
+// that has one, or nil if there is none.
+func (r *dnsResolver) lookupECHConfigList(ctx context.Context, name string) ([]byte, error) {
+	records, err := r.lookupRecords(ctx, name, dnsTypeHTTPS)
+	if err != nil {
+		return nil, err
+	}
+	var ret []byte
+	var best uint16
+	for _, record := range records {
+		priority, configs := httpsRecordECH(record)
+		// Priority 0 is an AliasMode record, which has no parameters.
+		if priority == 0 || configs == nil || (ret != nil && priority >= best) {
+			continue
+		}
+		ret, best = configs, priority
+	}
+	return ret, nil
+}
+
+// httpsRecordECH returns the priority of the HTTPS record and its ech
+// parameter, if it has one.
+func httpsRecordECH(rdata []byte) (uint16, []byte) {
+	r := &helloReader{data: rdata, ok: true}
+	priority := r.uint16()
+	// The target name is never compressed.
+	for n := r.uint8(); r.ok && n != 0; n = r.uint8() {
+		r.bytes(n)
+	}
+	for r.ok && len(r.data) >= 4 {
+		key := r.uint16()
+		value := r.vector(2)
+		if key == dnsParamECH && value.ok {
+			return priority, value.data
+		}
+	}
+	return priority, nil
+}
@@ -0,0 +1,210 @@
+package zgrab2
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// domainLookupTimeout bounds the DNS lookups for each input record with
+// --lookup-domain.
+const domainLookupTimeout = 10 * time.Second
+
+// domainLookupConcurrency is the number of input records looked up at once
+// with --lookup-domain.
+const domainLookupConcurrency = 64
+
+// DomainLookup records the DNS record that a target was found in, with
+// --lookup-domain.
+type DomainLookup struct {
+	// Domain is the domain from the input record.
+	Domain string `json:"domain"`
+
+	// Type is the type of the record, MX or SRV.
+	Type string `json:"type"`
+
+	// Name is the name queried, e.g. _sip._tcp.example.com for SRV records.
+	Name string `json:"name"`
+
+	// Priority is the MX preference, or SRV priority, of the record.
+	Priority uint16 `json:"priority"`
+
+	// Weight is the SRV weight of the record.
+	Weight uint16 `json:"weight,omitempty"`
+
+	// Port is the port given by an SRV record.
+	Port uint16 `json:"port,omitempty"`
+}
+
+// domainLookup is a kind of record looked up by --lookup-domain.
+type domainLookup struct {
+	// service is the SRV service and protocol labels, e.g. _sip._tcp, or
+	// empty to look up MX records.
+	service string
+
+	// usePort is set to scan the port in the SRV records, rather than the
+	// module's port.
+	usePort bool
+}
+
+// domainLookups are the kinds of record --lookup-domain accepts by name.
+// SRV records of other services can be given as srv:_service._proto.
+var domainLookups = map[string]*domainLookup{
+	// Mail servers, for smtp.
+	"mx":          {},
+	"sip":         {service: "_sip._tcp", usePort: true},
+	"sips":        {service: "_sips._tcp", usePort: true},
+	"sip-udp":     {service: "_sip._udp", usePort: true},
+	"xmpp-client": {service: "_xmpp-client._tcp", usePort: true},
+	"xmpp-server": {service: "_xmpp-server._tcp", usePort: true},
+	// Active Directory domain controllers, for smb and ldap. The port is
+	// LDAP's, so the module's port is scanned.
+	"dc": {service: "_ldap._tcp.dc._msdcs"},
+}
+
+// parseDomainLookup parses the value of --lookup-domain.
+func parseDomainLookup(s string) (*domainLookup, error) {
+	s = strings.TrimSpace(s)
+	if lookup, ok := domainLookups[strings.ToLower(s)]; ok {
+		return lookup, nil
+	}
+	if strings.HasPrefix(s, "srv:") {
+		service := strings.Trim(strings.TrimPrefix(s, "srv:"), ".")
+		labels := strings.Split(service, ".")
+		if len(labels) >= 2 && strings.HasPrefix(labels[0], "_") && strings.HasPrefix(labels[1], "_") {
+			return &domainLookup{service: service, usePort: true}, nil
+		}
+		return nil, fmt.Errorf("SRV lookup %q must name the service and protocol, e.g. srv:_ldap._tcp", s)
+	}
+	var names []string
+	for name := range domainLookups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return nil, fmt.Errorf("unknown domain lookup %q (must be one of %s, or srv:_service._proto)", s, strings.Join(names, ", "))
+}
+
+// query looks up the records for the domain, returning them with the hosts
+// they name, in order of priority.
+func (l *domainLookup) query(ctx context.Context, domain string) ([]*DomainLookup, []string, error) {
+	domain = strings.TrimSuffix(domain, ".")
+	var records []*DomainLookup
+	var hosts []string
+	if l.service == "" {
+		var mxs []*net.MX
+		_, _, err := resolver.resolve(ctx, func(res *net.Resolver) error {
+			var err error
+			mxs, err = res.LookupMX(ctx, domain)
+			return err
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, mx := range mxs {
+			records = append(records, &DomainLookup{Domain: domain, Type: "MX", Name: domain, Priority: mx.Pref})
+			hosts = append(hosts, mx.Host)
+		}
+		return records, hosts, nil
+	}
+	name := l.service + "." + domain
+	var srvs []*net.SRV
+	_, _, err := resolver.resolve(ctx, func(res *net.Resolver) error {
+		var err error
+		_, srvs, err = res.LookupSRV(ctx, "", "", name)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, srv := range srvs {
+		records = append(records, &DomainLookup{Domain: domain, Type: "SRV", Name: name, Priority: srv.Priority, Weight: srv.Weight, Port: srv.Port})
+		hosts = append(hosts, srv.Target)
+	}
+	return records, hosts, nil
+}
+
+// transport returns the transport named by the protocol label of an SRV
+// lookup, for modules that scan over more than one, or "" to try each.
+func (l *domainLookup) transport() string {
+	labels := strings.Split(l.service, ".")
+	if len(labels) < 2 {
+		return ""
+	}
+	switch strings.ToLower(labels[1]) {
+	case "_tcp":
+		return TransportTCP
+	case "_udp":
+		return TransportUDP
+	}
+	return ""
+}
+
+// targets returns the targets to scan for the hosts found for the input
+// record: one for each host, named by it, so that it is resolved when
+// scanned and sent as the TLS server name. Null MX and SRV records (whose
+// host is ".") are skipped.
+func (l *domainLookup) targets(record ScanTarget, records []*DomainLookup, hosts []string) []ScanTarget {
+	var ret []ScanTarget
+	transport := l.transport()
+	for i, host := range hosts {
+		host = strings.TrimSuffix(host, ".")
+		if host == "" {
+			continue
+		}
+		target := ScanTarget{Domain: host, Tag: record.Tag, Metadata: record.Metadata, Transport: transport, inputRecord: record.inputRecord, lookup: records[i]}
+		if l.usePort {
+			port := uint(records[i].Port)
+			target.Port = &port
+		}
+		ret = append(ret, target)
+	}
+	return ret
+}
+
+// inputTargets returns an InputTargetsFunc that looks up the domain of each
+// record read by read, and generates targets for the hosts found. Records
+// are looked up concurrently, so the targets may be out of order.
+func (l *domainLookup) inputTargets(read InputTargetsFunc) InputTargetsFunc {
+	return func(ch chan<- ScanTarget) error {
+		records := make(chan ScanTarget)
+		done := make(chan error, 1)
+		go func() {
+			done <- read(records)
+			close(records)
+		}()
+		var wg sync.WaitGroup
+		semaphore := make(chan struct{}, domainLookupConcurrency)
+		for record := range records {
+			if record.Domain == "" {
+				log.Errorf("skipping record without a domain to look up: %s", record.String())
+				continue
+			}
+			semaphore <- struct{}{}
+			wg.Add(1)
+			go func(record ScanTarget) {
+				defer func() {
+					<-semaphore
+					wg.Done()
+				}()
+				ctx, cancel := context.WithTimeout(context.Background(), domainLookupTimeout)
+				defer cancel()
+				found, hosts, err := l.query(ctx, record.Domain)
+				if err != nil {
+					log.Warnf("could not look up %s: %v", record.Domain, err)
+					return
+				}
+				for _, target := range l.targets(record, found, hosts) {
+					ch <- target
+				}
+			}(record)
+		}
+		wg.Wait()
+		return <-done
+	}
+}
@@ -0,0 +1,59 @@
+package zgrab2
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParseDomainLookup(t *testing.T) {
+	for _, test := range []struct {
+		value   string
+		service string
+		usePort bool
+	}{
+		{"mx", "", false},
+		{"XMPP-Server", "_xmpp-server._tcp", true},
+		{"dc", "_ldap._tcp.dc._msdcs", false},
+		{"srv:_imaps._tcp.", "_imaps._tcp", true},
+	} {
+		lookup, err := parseDomainLookup(test.value)
+		if err != nil {
+			t.Errorf("%s: %v", test.value, err)
+			continue
+		}
+		if lookup.service != test.service || lookup.usePort != test.usePort {
+			t.Errorf("%s: got %+v", test.value, lookup)
+		}
+	}
+	for _, value := range []string{"a", "srv:imaps", "srv:_imaps"} {
+		if _, err := parseDomainLookup(value); err == nil {
+			t.Errorf("%s: expected an error", value)
+		}
+	}
+}
+
+func TestDomainLookupTargets(t *testing.T) {
+	record := ScanTarget{Domain: "example.com", Tag: "sip", Metadata: json.RawMessage(`{"id":1}`), inputRecord: 3}
+	records := []*DomainLookup{
+		{Domain: "example.com", Type: "SRV", Name: "_sip._udp.example.com", Priority: 10, Weight: 5, Port: 5060},
+		{Domain: "example.com", Type: "SRV", Name: "_sip._udp.example.com", Priority: 20, Port: 5080},
+		{Domain: "example.com", Type: "SRV", Name: "_sip._udp.example.com"},
+	}
+	targets := domainLookups["sip-udp"].targets(record, records, []string{"sip1.example.com.", "sip2.example.com.", "."})
+	if len(targets) != 2 {
+		t.Fatalf("expected 2 targets, got %d", len(targets))
+	}
+	for i, target := range targets {
+		if target.IP != nil || target.Domain != []string{"sip1.example.com", "sip2.example.com"}[i] || target.Tag != "sip" || target.Transport != TransportUDP || target.inputRecord != 3 || string(target.Metadata) != `{"id":1}` {
+			t.Errorf("wrong target %+v", target)
+		}
+		if target.Port == nil || *target.Port != uint(records[i].Port) || target.lookup != records[i] {
+			t.Errorf("wrong port or lookup for %s", target.Domain)
+		}
+	}
+
+	targets = domainLookups["mx"].targets(record, []*DomainLookup{{Domain: "example.com", Type: "MX", Name: "example.com"}}, []string{"mx.example.com."})
+	if len(targets) != 1 || targets[0].Port != nil || targets[0].Transport != "" || targets[0].Domain != "mx.example.com" {
+		t.Errorf("wrong MX targets %+v", targets)
+	}
+}
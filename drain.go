@@ -0,0 +1,124 @@
+package zgrab2
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Interruption describes how a scan was cut short by SIGINT or SIGTERM.
+type Interruption struct {
+	// Signal is the signal received.
+	Signal string `json:"signal"`
+
+	// TargetsDispatched is the number of targets sent for scanning before
+	// intake stopped.
+	TargetsDispatched uint64 `json:"targets_dispatched"`
+
+	// LastInputRecord is the number of the input record (counting from 1,
+	// and skipping comments and blank lines) of the last target sent for
+	// scanning, if the input is CSV. A record giving a CIDR block may have
+	// been scanned only in part, and targets from earlier records held back
+	// by --subnet-rate may not have been sent.
+	LastInputRecord uint64 `json:"last_input_record,omitempty"`
+
+	// DrainTimedOut is true if scans still running after --drain-timeout
+	// were abandoned, without their results being written.
+	DrainTimedOut bool `json:"drain_timed_out,omitempty"`
+}
+
+// interruptState tracks whether the scan has been interrupted, and how far
+// it got.
+type interruptState struct {
+	mutex      sync.Mutex
+	stop       chan struct{}
+	info       *Interruption
+	dispatched uint64
+	lastRecord uint64
+}
+
+var interrupt = newInterruptState()
+
+func newInterruptState() *interruptState {
+	return &interruptState{stop: make(chan struct{})}
+}
+
+// trigger marks the scan as interrupted by the signal, returning false if it
+// already was.
+func (s *interruptState) trigger(sig os.Signal) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.info != nil {
+		return false
+	}
+	s.info = &Interruption{Signal: sig.String()}
+	close(s.stop)
+	return true
+}
+
+// stopped returns true if the scan has been interrupted.
+func (s *interruptState) stopped() bool {
+	select {
+	case <-s.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// targetDispatched records that the target was sent for scanning.
+func (s *interruptState) targetDispatched(target *ScanTarget) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.dispatched++
+	if target.inputRecord > s.lastRecord {
+		s.lastRecord = target.inputRecord
+	}
+}
+
+// drainTimedOut records that in-flight scans were abandoned.
+func (s *interruptState) drainTimedOut() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.info != nil {
+		s.info.DrainTimedOut = true
+	}
+}
+
+// GetInterruption returns how the scan was interrupted, or nil if it ran to
+// completion.
+func GetInterruption() *Interruption {
+	interrupt.mutex.Lock()
+	defer interrupt.mutex.Unlock()
+	if interrupt.info == nil {
+		return nil
+	}
+	ret := *interrupt.info
+	ret.TargetsDispatched = interrupt.dispatched
+	ret.LastInputRecord = interrupt.lastRecord
+	return &ret
+}
+
+// drainOnSignal stops intake on the first SIGINT or SIGTERM, so that the scan
+// finishes the targets in flight and exits cleanly, and exits immediately on
+// the second. It returns when done is closed.
+func drainOnSignal(done <-chan struct{}) {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	for {
+		select {
+		case sig := <-signals:
+			if !interrupt.trigger(sig) {
+				log.Fatalf("received %s again, exiting without draining", sig)
+			}
+			log.Infof("received %s, finishing in-flight scans for up to %s (send it again to exit now)", sig, config.DrainTimeout)
+			progress.stop()
+		case <-done:
+			return
+		}
+	}
+}
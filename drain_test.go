@@ -0,0 +1,53 @@
+package zgrab2
+
+import (
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestInterruptState(t *testing.T) {
+	defer func(old *interruptState) { interrupt = old }(interrupt)
+	interrupt = newInterruptState()
+	if GetInterruption() != nil || interrupt.stopped() {
+		t.Fatal("interrupted before any signal")
+	}
+	interrupt.targetDispatched(&ScanTarget{inputRecord: 1})
+	interrupt.targetDispatched(&ScanTarget{inputRecord: 3})
+	interrupt.targetDispatched(&ScanTarget{inputRecord: 2})
+	if !interrupt.trigger(syscall.SIGTERM) {
+		t.Fatal("first signal not recorded")
+	}
+	if interrupt.trigger(syscall.SIGINT) {
+		t.Error("second signal recorded")
+	}
+	if !interrupt.stopped() {
+		t.Error("not stopped after a signal")
+	}
+	interrupt.drainTimedOut()
+	info := GetInterruption()
+	if info == nil {
+		t.Fatal("no interruption reported")
+	}
+	if info.Signal != syscall.SIGTERM.String() || info.TargetsDispatched != 3 || info.LastInputRecord != 3 || !info.DrainTimedOut {
+		t.Errorf("bad interruption %+v", info)
+	}
+}
+
+func TestProgressStopWakesIntake(t *testing.T) {
+	p := newProgressTracker()
+	p.setPaused(true)
+	read := make(chan bool, 1)
+	go func() {
+		read <- p.targetRead()
+	}()
+	p.stop()
+	select {
+	case ok := <-read:
+		if ok {
+			t.Error("target read after intake was stopped")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("paused intake not woken by stop")
+	}
+}
@@ -0,0 +1,558 @@
+package zgrab2
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zmap/zcrypto/tls"
+	"github.com/zmap/zcrypto/x509"
+)
+
+// DTLSFlags configures DTLS handshakes. Include it, with UDPFlags, in the
+// flags of modules that speak DTLS.
+type DTLSFlags struct {
+	ServerName  string `long:"server-name" description:"Server name to send with SNI, instead of the target's domain name"`
+	NoSNI       bool   `long:"no-sni" description:"Do not send the target's domain name with SNI"`
+	NextProtos  string `long:"next-protos" description:"Comma-separated application protocols to offer with ALPN, e.g. webrtc or coap"`
+	CipherSuite string `long:"cipher-suite" description:"Comma-separated hex cipher suites to offer, instead of the default"`
+
+	MaxVersion string        `long:"dtls-max-version" default:"1.3" choice:"1.2" choice:"1.3" description:"Highest DTLS version to offer"`
+	Retransmit time.Duration `long:"dtls-retransmit" default:"1s" description:"Time to wait for the server's flight before retransmitting the ClientHello, doubled after each retransmission"`
+	Retries    int           `long:"dtls-retries" default:"2" description:"Number of times to retransmit the ClientHello"`
+}
+
+// DTLSLog records the parts of a DTLS handshake that TLS does not have.
+// Only the server's first flight is probed, so it also says what the
+// handshake log is missing.
+type DTLSLog struct {
+	// Version is the version the server selected, e.g. DTLSv1.2.
+	Version string `json:"version"`
+
+	// HelloVerifyRequest is true if the server sent a DTLS 1.2
+	// HelloVerifyRequest, and HelloRetryRequest if it sent a DTLS 1.3
+	// HelloRetryRequest, before its ServerHello. Cookie is the cookie the
+	// client had to return in either case.
+	HelloVerifyRequest bool   `json:"hello_verify_request,omitempty"`
+	HelloRetryRequest  bool   `json:"hello_retry_request,omitempty"`
+	Cookie             []byte `json:"cookie,omitempty"`
+
+	// Retransmissions is the number of times a ClientHello was sent again
+	// because the server's flight did not arrive in time.
+	Retransmissions int `json:"retransmissions,omitempty"`
+
+	// Fragmented is true if the server split a handshake message across
+	// records.
+	Fragmented bool `json:"fragmented,omitempty"`
+
+	// EncryptedFlight is true if the rest of the server's first flight,
+	// after its ServerHello, was encrypted (as in DTLS 1.3), so that its
+	// certificates are not recorded.
+	EncryptedFlight bool `json:"encrypted_flight,omitempty"`
+}
+
+// errDTLSFirstFlight is the error for reading or writing application data
+// over a DTLSConnection, whose handshake is never completed.
+var errDTLSFirstFlight = errors.New("DTLS handshake not completed: only the server's first flight is probed")
+
+// DTLS versions, handshake message types and extensions.
+const (
+	dtls12 = 0xfefd
+	dtls13 = 0xfefc
+
+	dtlsHelloVerifyRequest = 3
+
+	tlsExtensionCookie = 0x002c
+)
+
+var dtlsVersionNames = map[uint16]string{
+	0xfeff: "DTLSv1.0",
+	dtls12: "DTLSv1.2",
+	dtls13: "DTLSv1.3",
+}
+
+// dtlsCiphers are the cipher suites offered by default: those of TLS 1.3,
+// and the AEAD and CBC suites of TLS 1.2, including the CCM suites used by
+// CoAP. Stream ciphers cannot be used with DTLS.
+var dtlsCiphers = []uint16{
+	0x1301, 0x1302, 0x1303,
+	0xc02b, 0xc02f, 0xc02c, 0xc030, 0xcca9, 0xcca8, 0xc0ae, 0xc0ac,
+	0xc023, 0xc027, 0xc009, 0xc013, 0xc00a, 0xc014,
+	0x009c, 0x009d, 0x002f, 0x0035, 0xc0a8,
+}
+
+var dtlsGroups = []uint16{0x001d, 0x0017, 0x0018}
+
+var dtlsSignatureAlgorithms = []uint16{0x0403, 0x0804, 0x0401, 0x0503, 0x0805, 0x0501, 0x0806, 0x0601, 0x0201}
+
+// dtlsMaxMessage is the longest handshake message reassembled, enough for
+// any certificate chain.
+const dtlsMaxMessage = 64 * 1024
+
+// dtlsMaxDatagram is the size of the buffer datagrams are read into.
+const dtlsMaxDatagram = 64 * 1024
+
+func uint24(b []byte) int {
+	return int(b[0])<<16 | int(b[1])<<8 | int(b[2])
+}
+
+// dtlsMessage is a handshake message being reassembled.
+type dtlsMessage struct {
+	typ     byte
+	body    []byte
+	have    []bool
+	missing int
+}
+
+// dtlsReassembler reassembles the server's handshake messages from their
+// fragments, which may arrive out of order, more than once, or (when a
+// flight is retransmitted) split differently.
+type dtlsReassembler struct {
+	started bool
+	next    uint16
+	pending map[uint16]*dtlsMessage
+
+	// msgs are the messages reassembled, in order, in the TLS format:
+	// without DTLS's sequence number and fragment fields.
+	msgs [][]byte
+
+	fragmented bool
+}
+
+func newDTLSReassembler() *dtlsReassembler {
+	return &dtlsReassembler{pending: make(map[uint16]*dtlsMessage)}
+}
+
+// add adds a fragment, with its header, to the messages. The server's
+// messages start with the first HelloVerifyRequest or ServerHello, whose
+// sequence number depends on the messages exchanged before it; fragments of
+// later messages that arrive before it are kept until it does.
+func (r *dtlsReassembler) add(fragment []byte) {
+	typ, length := fragment[0], uint24(fragment[1:4])
+	seq := binary.BigEndian.Uint16(fragment[4:6])
+	offset, data := uint24(fragment[6:9]), fragment[12:]
+	if length > dtlsMaxMessage || offset+len(data) > length {
+		return
+	}
+	if r.started && seq < r.next {
+		return
+	}
+	m := r.pending[seq]
+	if m == nil {
+		m = &dtlsMessage{typ: typ, body: make([]byte, length), have: make([]bool, length), missing: length}
+		r.pending[seq] = m
+	} else if m.typ != typ || len(m.body) != length {
+		return
+	}
+	if len(data) != length {
+		r.fragmented = true
+	}
+	copy(m.body[offset:], data)
+	for i := offset; i < offset+len(data); i++ {
+		if !m.have[i] {
+			m.have[i] = true
+			m.missing--
+		}
+	}
+	if !r.started {
+		if typ != dtlsHelloVerifyRequest && typ != tlsServerHello {
+			return
+		}
+		r.started, r.next = true, seq
+	}
+	for {
+		m := r.pending[r.next]
+		if m == nil || m.missing > 0 {
+			return
+		}
+		r.msgs = append(r.msgs, append([]byte{m.typ}, TLSVector(3, m.body)...))
+		delete(r.pending, r.next)
+		r.next++
+	}
+}
+
+// addDatagram adds the handshake fragments in the plaintext records of a
+// datagram, returning an error if it holds an alert. Encrypted records are
+// skipped.
+func (r *dtlsReassembler) addDatagram(datagram []byte) error {
+	for len(datagram) >= 13 {
+		// DTLS 1.3 encrypted records have a shorter header, which may
+		// leave out the length; they are last if so.
+		if datagram[0]&0xe0 == 0x20 {
+			return nil
+		}
+		typ, epoch := datagram[0], binary.BigEndian.Uint16(datagram[3:5])
+		length := int(binary.BigEndian.Uint16(datagram[11:13]))
+		if len(datagram) < 13+length {
+			return nil
+		}
+		body := datagram[13 : 13+length]
+		datagram = datagram[13+length:]
+		if epoch != 0 {
+			continue
+		}
+		switch typ {
+		case tlsRecordAlert:
+			if len(body) >= 2 {
+				return fmt.Errorf("server sent alert %d", body[1])
+			}
+		case tlsRecordHandshake:
+			for len(body) >= 12 {
+				n := 12 + uint24(body[9:12])
+				if len(body) < n {
+					break
+				}
+				r.add(body[:n])
+				body = body[n:]
+			}
+		}
+	}
+	return nil
+}
+
+// DTLSConnection is a first-flight probe: it starts a DTLS handshake over a
+// UDP connection and reads only the server's first flight, its ServerHello
+// and, for DTLS 1.2, its certificates. The handshake is not completed, so
+// Read and Write return an error, and for DTLS 1.3 no certificates are
+// recorded.
+type DTLSConnection struct {
+	net.Conn
+	flags *DTLSFlags
+	log   *TLSLog
+
+	serverName string
+	nextProtos []string
+	ciphers    []uint16
+
+	random    []byte
+	recordSeq uint64
+	msgSeq    uint16
+
+	// cookie is the cookie of a HelloVerifyRequest, retryCookie that of a
+	// HelloRetryRequest, and group the group to send a key share for.
+	cookie      []byte
+	retryCookie []byte
+	group       uint16
+}
+
+// OpenDTLS connects to the ScanTarget over UDP, then probes the server's
+// first flight of a DTLS handshake. As with OpenTLS, the connection can be non-nil even if
+// there is an error, so that the handshake log can be read.
+func (target *ScanTarget) OpenDTLS(flags *BaseFlags, udp *UDPFlags, dtlsFlags *DTLSFlags) (*DTLSConnection, error) {
+	udpConn, err := target.OpenUDP(flags, udp)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := dtlsFlags.GetDTLSConnectionForTarget(udpConn, target)
+	if err != nil {
+		udpConn.Close()
+		return nil, err
+	}
+	return conn, conn.Handshake()
+}
+
+// GetDTLSConnectionForTarget returns a DTLSConnection over conn. The caller
+// must still call Handshake().
+func (f *DTLSFlags) GetDTLSConnectionForTarget(conn net.Conn, target *ScanTarget) (*DTLSConnection, error) {
+	ret := &DTLSConnection{Conn: conn, flags: f, ciphers: dtlsCiphers, group: dtlsGroups[0]}
+	if f.ServerName != "" {
+		ret.serverName = f.ServerName
+	} else if !f.NoSNI && target != nil {
+		ret.serverName = target.Domain
+	}
+	if f.NextProtos != "" {
+		ret.nextProtos = getCSV(f.NextProtos)
+	}
+	if f.CipherSuite != "" {
+		ret.ciphers = nil
+		for _, s := range getCSV(f.CipherSuite) {
+			v, err := strconv.ParseUint(strings.TrimPrefix(s, "0x"), 16, 16)
+			if err != nil {
+				return nil, fmt.Errorf("invalid --cipher-suite %s: %s", s, err)
+			}
+			ret.ciphers = append(ret.ciphers, uint16(v))
+		}
+	}
+	if f.MaxVersion == "1.2" {
+		ret.ciphers = withoutTLS13Ciphers(ret.ciphers)
+	}
+	ret.random = make([]byte, 32)
+	if _, err := rand.Read(ret.random); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+func withoutTLS13Ciphers(ciphers []uint16) []uint16 {
+	var ret []uint16
+	for _, cipher := range ciphers {
+		if !containsUint16(tls13Ciphers, cipher) {
+			ret = append(ret, cipher)
+		}
+	}
+	return ret
+}
+
+// Read returns an error: the handshake is not completed, so there is no
+// application data to read.
+func (z *DTLSConnection) Read(b []byte) (int, error) {
+	return 0, errDTLSFirstFlight
+}
+
+// Write returns an error: the handshake is not completed, so no application
+// data can be sent.
+func (z *DTLSConnection) Write(b []byte) (int, error) {
+	return 0, errDTLSFirstFlight
+}
+
+// GetLog returns the handshake log, whose DTLS field is set once the
+// handshake has started.
+func (z *DTLSConnection) GetLog() *TLSLog {
+	if z.log == nil {
+		z.log = &TLSLog{}
+	}
+	return z.log
+}
+
+// clientHello returns the ClientHello message, with the DTLS header.
+func (z *DTLSConnection) clientHello() ([]byte, error) {
+	var extensions [][]byte
+	if z.serverName != "" {
+		extensions = append(extensions, TLSExtension(tlsExtensionServerName, TLSVector(2, []byte{0}, TLSVector(2, []byte(z.serverName)))))
+	}
+	extensions = append(extensions,
+		TLSExtension(tlsExtensionSupportedGroups, TLSVector(2, TLSUint16s(dtlsGroups...))),
+		// ec_point_formats: uncompressed.
+		TLSExtension(0x000b, []byte{1, 0}),
+		TLSExtension(tlsExtensionSignatureAlgorithms, TLSVector(2, TLSUint16s(dtlsSignatureAlgorithms...))),
+		// extended_master_secret and renegotiation_info.
+		TLSExtension(0x0017),
+		TLSExtension(0xff01, []byte{0}),
+	)
+	if len(z.nextProtos) > 0 {
+		var protocols [][]byte
+		for _, protocol := range z.nextProtos {
+			protocols = append(protocols, TLSVector(1, []byte(protocol)))
+		}
+		extensions = append(extensions, TLSExtension(tlsExtensionALPN, TLSVector(2, protocols...)))
+	}
+	if z.flags.MaxVersion != "1.2" {
+		share, err := tlsKeyShare(z.group)
+		if err != nil {
+			return nil, err
+		}
+		extensions = append(extensions,
+			TLSExtension(tlsExtensionSupportedVersions, TLSVector(1, TLSUint16s(dtls13, dtls12))),
+			TLSExtension(tlsExtensionKeyShare, TLSVector(2, TLSUint16s(z.group), TLSVector(2, share))),
+		)
+		if z.retryCookie != nil {
+			extensions = append(extensions, TLSExtension(tlsExtensionCookie, TLSVector(2, z.retryCookie)))
+		}
+	}
+	body := bytes.Join([][]byte{
+		TLSUint16s(dtls12),
+		z.random,
+		// An empty session ID.
+		{0},
+		TLSVector(1, z.cookie),
+		TLSVector(2, TLSUint16s(z.ciphers...)),
+		TLSVector(1, []byte{0}),
+		TLSVector(2, extensions...),
+	}, nil)
+	// The message is sent in one fragment, at offset 0, whose length is
+	// the message's.
+	fragment := TLSVector(3, body)
+	return bytes.Join([][]byte{{tlsClientHello}, fragment[:3], TLSUint16s(z.msgSeq), {0, 0, 0}, fragment}, nil), nil
+}
+
+// record returns a plaintext handshake record holding msg.
+func (z *DTLSConnection) record(msg []byte) []byte {
+	header := make([]byte, 11)
+	header[0] = tlsRecordHandshake
+	binary.BigEndian.PutUint16(header[1:3], dtls12)
+	// Epoch 0, and a 48-bit sequence number.
+	binary.BigEndian.PutUint64(header[3:11], z.recordSeq)
+	z.recordSeq++
+	return append(header, TLSVector(2, msg)...)
+}
+
+// exchange sends the ClientHello, retransmitting it while the server's
+// flight does not arrive, and returns the server's messages once done
+// returns true for them.
+func (z *DTLSConnection) exchange(done func(msgs [][]byte) bool) ([][]byte, error) {
+	hello, err := z.clientHello()
+	if err != nil {
+		return nil, err
+	}
+	r := newDTLSReassembler()
+	defer func() {
+		z.log.DTLS.Fragmented = z.log.DTLS.Fragmented || r.fragmented
+	}()
+	buf := make([]byte, dtlsMaxDatagram)
+	wait := z.flags.Retransmit
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			z.log.DTLS.Retransmissions++
+		}
+		if _, err := z.Conn.Write(z.record(hello)); err != nil {
+			return nil, err
+		}
+		deadline := time.Now().Add(wait)
+		for {
+			if err := z.Conn.SetReadDeadline(deadline); err != nil {
+				return nil, err
+			}
+			n, err := z.Conn.Read(buf)
+			if err != nil {
+				if netErr, ok := err.(net.Error); ok && netErr.Timeout() && attempt < z.flags.Retries {
+					break
+				}
+				return nil, err
+			}
+			if err := r.addDatagram(buf[:n]); err != nil {
+				return nil, err
+			}
+			if len(r.msgs) > 0 && done(r.msgs) {
+				return r.msgs, nil
+			}
+		}
+		wait *= 2
+	}
+}
+
+// serverHelloExtensions returns the extensions of a ServerHello.
+func serverHelloExtensions(msg []byte) *helloReader {
+	r := &helloReader{data: msg[4:], ok: true}
+	r.bytes(2 + 32)
+	r.vector(1)
+	r.bytes(2 + 1)
+	return r.vector(2)
+}
+
+// firstFlightDone returns true once msgs hold the end of the server's first
+// flight, or a message that asks for the ClientHello again.
+func firstFlightDone(msgs [][]byte) bool {
+	if msgs[0][0] == dtlsHelloVerifyRequest || isHelloRetryRequest(msgs[0]) {
+		return true
+	}
+	if hello := parseHello(msgs[0]); hello != nil && len(hello.versions) > 0 && hello.versions[0] == dtls13 {
+		// The rest of the flight is encrypted.
+		return true
+	}
+	for _, msg := range msgs {
+		if msg[0] == tlsServerHelloDone {
+			return true
+		}
+	}
+	return false
+}
+
+// Handshake sends the ClientHello, answering a HelloVerifyRequest or
+// HelloRetryRequest if the server sends one, and reads the server's first
+// flight into the log.
+func (z *DTLSConnection) Handshake() error {
+	log := z.GetLog()
+	log.DTLS = new(DTLSLog)
+	// The server may ask for the ClientHello again once for a cookie, and
+	// once more for a key share.
+	for i := 0; i < 3; i++ {
+		msgs, err := z.exchange(firstFlightDone)
+		if err != nil {
+			return err
+		}
+		z.msgSeq++
+		msg := msgs[0]
+		switch {
+		case msg[0] == dtlsHelloVerifyRequest:
+			r := &helloReader{data: msg[4:], ok: true}
+			r.uint16()
+			cookie := r.vector(1)
+			if !r.ok {
+				return errors.New("malformed HelloVerifyRequest")
+			}
+			log.DTLS.HelloVerifyRequest = true
+			z.cookie = cookie.data
+			log.DTLS.Cookie = z.cookie
+		case isHelloRetryRequest(msg):
+			hello := parseHello(msg)
+			if hello == nil {
+				return errors.New("malformed HelloRetryRequest")
+			}
+			log.DTLS.HelloRetryRequest = true
+			if len(hello.groups) > 0 {
+				z.group = hello.groups[0]
+			}
+			if cookie := extensionBody(serverHelloExtensions(msg), tlsExtensionCookie); cookie != nil {
+				z.retryCookie = (&helloReader{data: cookie, ok: true}).vector(2).data
+				log.DTLS.Cookie = z.retryCookie
+			}
+		default:
+			return z.readFlight(msgs)
+		}
+	}
+	return errors.New("server asked for the ClientHello too many times")
+}
+
+// readFlight records the server's ServerHello and certificates.
+func (z *DTLSConnection) readFlight(msgs [][]byte) error {
+	hello := parseHello(msgs[0])
+	if hello == nil || len(hello.ciphers) == 0 {
+		return errors.New("malformed ServerHello")
+	}
+	version := hello.version
+	if len(hello.versions) > 0 {
+		version = hello.versions[0]
+	}
+	z.log.DTLS.Version = dtlsVersionNames[version]
+	if z.log.DTLS.Version == "" {
+		z.log.DTLS.Version = fmt.Sprintf("0x%04x", version)
+	}
+	// The rest of a DTLS 1.3 flight is encrypted.
+	z.log.DTLS.EncryptedFlight = version == dtls13
+	z.log.HandshakeLog = &tls.ServerHandshake{
+		ServerHello: &tls.ServerHello{
+			Version:      tls.TLSVersion(version),
+			Random:       msgs[0][6:38],
+			SessionID:    hello.sessionID,
+			CipherSuite:  tls.CipherSuite(hello.ciphers[0]),
+			AlpnProtocol: hello.alpn,
+		},
+	}
+	for _, msg := range msgs[1:] {
+		if msg[0] == tlsCertificate {
+			z.log.HandshakeLog.ServerCertificates = parseCertificates(msg)
+		}
+	}
+	return nil
+}
+
+// parseCertificates parses a TLS 1.2 Certificate message, returning nil if
+// it has no certificates.
+func parseCertificates(msg []byte) *tls.Certificates {
+	// The list and each certificate have 3-byte length prefixes.
+	list := msg[4:]
+	if len(list) < 3 || len(list) < 3+uint24(list) {
+		return nil
+	}
+	list = list[3 : 3+uint24(list)]
+	var certs []tls.SimpleCertificate
+	for len(list) >= 3 && len(list) >= 3+uint24(list) {
+		raw := list[3 : 3+uint24(list)]
+		list = list[3+len(raw):]
+		cert := tls.SimpleCertificate{Raw: raw}
+		if parsed, err := x509.ParseCertificate(raw); err == nil {
+			cert.Parsed = parsed
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil
+	}
+	return &tls.Certificates{Certificate: certs[0], Chain: certs[1:]}
+}
@@ -0,0 +1,155 @@
+package zgrab2
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+// dtlsFragment returns a fragment of a handshake message with the given body.
+func dtlsFragment(typ byte, seq uint16, body []byte, offset, length int) []byte {
+	return bytes.Join([][]byte{
+		{typ},
+		TLSVector(3, body)[:3],
+		TLSUint16s(seq),
+		{byte(offset >> 16), byte(offset >> 8), byte(offset)},
+		TLSVector(3, body[offset:offset+length]),
+	}, nil)
+}
+
+// dtlsRecord returns a record holding the fragments.
+func dtlsRecord(typ byte, epoch uint16, fragments ...[]byte) []byte {
+	header := []byte{typ, 0xfe, 0xfd}
+	header = append(header, TLSUint16s(epoch)...)
+	header = append(header, 0, 0, 0, 0, 0, 0)
+	return append(header, TLSVector(2, fragments...)...)
+}
+
+func dtlsServerHello(random []byte, cipher uint16, extensions ...[]byte) []byte {
+	return bytes.Join([][]byte{TLSUint16s(dtls12), random, {0}, TLSUint16s(cipher), {0}, TLSVector(2, extensions...)}, nil)
+}
+
+func TestDTLSReassembler(t *testing.T) {
+	hello := dtlsServerHello(make([]byte, 32), 0xc02b)
+	certificate := []byte("0123456789")
+	r := newDTLSReassembler()
+	datagrams := [][]byte{
+		// A fragment that arrives before the ServerHello.
+		dtlsRecord(tlsRecordHandshake, 0, dtlsFragment(tlsCertificate, 2, certificate, 5, 5)),
+		bytes.Join([][]byte{
+			dtlsRecord(tlsRecordHandshake, 0, dtlsFragment(tlsServerHello, 1, hello, 0, len(hello))),
+			dtlsRecord(tlsRecordHandshake, 1, dtlsFragment(tlsServerHelloDone, 4, nil, 0, 0)),
+		}, nil),
+		// A message from an earlier flight.
+		dtlsRecord(tlsRecordHandshake, 0, dtlsFragment(dtlsHelloVerifyRequest, 0, []byte{0xfe, 0xfd, 0}, 0, 3)),
+		dtlsRecord(tlsRecordHandshake, 0, dtlsFragment(tlsServerHello, 1, hello, 0, len(hello))),
+		dtlsRecord(tlsRecordHandshake, 0, dtlsFragment(tlsCertificate, 2, certificate, 0, 6)),
+	}
+	for _, datagram := range datagrams {
+		if err := r.addDatagram(datagram); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(r.msgs) != 2 || !r.fragmented {
+		t.Fatalf("got %d messages, fragmented %v", len(r.msgs), r.fragmented)
+	}
+	if !bytes.Equal(r.msgs[0][4:], hello) || !bytes.Equal(r.msgs[1], append([]byte{tlsCertificate, 0, 0, 10}, certificate...)) {
+		t.Errorf("wrong messages %x", r.msgs)
+	}
+	if err := r.addDatagram(dtlsRecord(tlsRecordAlert, 0, []byte{2, 40})); err == nil || err.Error() != "server sent alert 40" {
+		t.Errorf("got %v for an alert", err)
+	}
+}
+
+// dtls13Server answers the first ClientHello with a HelloRetryRequest for a
+// P-256 key share with a cookie, and the second, if it returns them, with a
+// DTLS 1.3 ServerHello and an encrypted record.
+func dtls13Server(t *testing.T) *net.UDPConn {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		buf := make([]byte, 2048)
+		for {
+			n, addr, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			if n < 25 {
+				continue
+			}
+			seq := binary.BigEndian.Uint16(buf[17:19])
+			r := &helloReader{data: buf[25:n], ok: true}
+			r.bytes(2 + 32)
+			r.vector(1)
+			r.vector(1)
+			r.vector(2)
+			r.vector(1)
+			extensions := r.vector(2)
+			var keyShare, cookie []byte
+			for extensions.ok && len(extensions.data) >= 4 {
+				typ, body := extensions.uint16(), extensions.vector(2)
+				switch typ {
+				case tlsExtensionKeyShare:
+					keyShare = body.data
+				case tlsExtensionCookie:
+					cookie = body.data
+				}
+			}
+			versions := TLSExtension(tlsExtensionSupportedVersions, TLSUint16s(dtls13))
+			var reply []byte
+			switch {
+			case seq == 0:
+				retry := dtlsServerHello(helloRetryRequestRandom[:], 0x1301, versions,
+					TLSExtension(tlsExtensionKeyShare, TLSUint16s(0x0017)),
+					TLSExtension(tlsExtensionCookie, TLSVector(2, []byte("cookie"))))
+				reply = dtlsRecord(tlsRecordHandshake, 0, dtlsFragment(tlsServerHello, 0, retry, 0, len(retry)))
+			case seq == 1 && bytes.Equal(cookie, TLSVector(2, []byte("cookie"))) && binary.BigEndian.Uint16(keyShare[2:]) == 0x0017:
+				random := bytes.Repeat([]byte{1}, 32)
+				hello := dtlsServerHello(random, 0x1301, versions,
+					TLSExtension(tlsExtensionKeyShare, TLSUint16s(0x0017), TLSVector(2, make([]byte, 65))))
+				reply = dtlsRecord(tlsRecordHandshake, 0, dtlsFragment(tlsServerHello, 1, hello, 0, len(hello)))
+				reply = append(reply, 0x2c, 0, 1, 0xff)
+			default:
+				continue
+			}
+			conn.WriteToUDP(reply, addr)
+		}
+	}()
+	return conn
+}
+
+func TestDTLSHelloRetryRequest(t *testing.T) {
+	server := dtls13Server(t)
+	defer server.Close()
+	client, err := net.Dial("udp", server.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	flags := &DTLSFlags{MaxVersion: "1.3", Retransmit: time.Second}
+	conn, err := flags.GetDTLSConnectionForTarget(client, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := conn.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	log := conn.GetLog()
+	if log.DTLS.Version != "DTLSv1.3" || !log.DTLS.HelloRetryRequest || string(log.DTLS.Cookie) != "cookie" || log.DTLS.Retransmissions != 0 || !log.DTLS.EncryptedFlight {
+		t.Errorf("wrong DTLS log %+v", log.DTLS)
+	}
+	hello := log.HandshakeLog.ServerHello
+	if hello.CipherSuite != 0x1301 || hello.Version != dtls13 || !bytes.Equal(hello.Random, bytes.Repeat([]byte{1}, 32)) {
+		t.Errorf("wrong ServerHello %+v", hello)
+	}
+	if log.HandshakeLog.ServerCertificates != nil {
+		t.Errorf("certificates read from an encrypted flight")
+	}
+	if _, err := conn.Write([]byte("data")); err != errDTLSFirstFlight {
+		t.Errorf("wrote application data without completing the handshake: %v", err)
+	}
+}
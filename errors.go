@@ -14,3 +14,9 @@ var ErrInvalidResponse = errors.New("invalid response")
 
 // ErrUnexpectedResponse is returned when the server returns a syntactically-valid but unexpected response.
 var ErrUnexpectedResponse = errors.New("unexpected response")
+
+// ErrBlocklisted is returned when a connection is attempted to an address excluded by the blocklist or allowlist.
+var ErrBlocklisted = errors.New("address is excluded by the blocklist / allowlist")
+
+// ErrTargetTimeout is returned for scans that were not run because the per-target time budget was exhausted.
+var ErrTargetTimeout = errors.New("target time budget exhausted")
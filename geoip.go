@@ -0,0 +1,102 @@
+package zgrab2
+
+import (
+	"net"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// Location gives where a target's address is registered and the autonomous
+// system announcing it, from the databases given with --geoip-db and
+// --asn-db.
+type Location struct {
+	// Country is the ISO 3166-1 code of the country the address is located
+	// in.
+	Country string `json:"country,omitempty"`
+
+	// ASN is the number of the autonomous system announcing the address.
+	ASN uint `json:"asn,omitempty"`
+
+	// ASName is the name of the organization owning the autonomous system.
+	ASName string `json:"as_name,omitempty"`
+}
+
+// ipDatabase is an IP address database, such as a maxminddb.Reader.
+type ipDatabase interface {
+	Lookup(ip net.IP, result interface{}) error
+}
+
+// geoRecord is the part of a GeoLite2 / GeoIP2 Country or City record that is
+// used.
+type geoRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	RegisteredCountry struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"registered_country"`
+}
+
+// asnRecord is a GeoLite2 ASN record (also used by other IP-to-ASN databases
+// in MMDB form, such as DB-IP's).
+type asnRecord struct {
+	Number       uint   `maxminddb:"autonomous_system_number"`
+	Organization string `maxminddb:"autonomous_system_organization"`
+}
+
+// geoDatabases are the databases loaded from --geoip-db and --asn-db. Readers
+// memory-map their file, and are safe to share between senders.
+var geoDatabases struct {
+	geoip ipDatabase
+	asn   ipDatabase
+}
+
+// openIPDatabase memory-maps a MaxMind DB (MMDB) file.
+func openIPDatabase(fileName string) (ipDatabase, error) {
+	return maxminddb.Open(fileName)
+}
+
+// locate returns the Location of ip, or nil if it is not in the databases or
+// none were loaded. Lookup errors (e.g. an IPv6 address in an IPv4-only
+// database) are treated as the address not being found.
+func locate(ip net.IP) *Location {
+	if ip == nil || (geoDatabases.geoip == nil && geoDatabases.asn == nil) {
+		return nil
+	}
+	ret := new(Location)
+	if geoDatabases.geoip != nil {
+		var record geoRecord
+		if err := geoDatabases.geoip.Lookup(ip, &record); err == nil {
+			ret.Country = record.Country.ISOCode
+			if ret.Country == "" {
+				// e.g. anycast addresses have no location, only the
+				// country they are registered to.
+				ret.Country = record.RegisteredCountry.ISOCode
+			}
+		}
+	}
+	if geoDatabases.asn != nil {
+		var record asnRecord
+		if err := geoDatabases.asn.Lookup(ip, &record); err == nil {
+			ret.ASN, ret.ASName = record.Number, record.Organization
+		}
+	}
+	if *ret == (Location{}) {
+		return nil
+	}
+	return ret
+}
+
+// grabAddress returns the address scanned for a grab: the target's IP, or if
+// it was given by name, the first address it resolved to.
+func grabAddress(grab *Grab) net.IP {
+	if grab.IP != "" {
+		return net.ParseIP(grab.IP)
+	}
+	for _, name := range sortedScanNames(grab) {
+		if resolution := grab.Data[name].Resolution; resolution != nil && len(resolution.Addresses) > 0 {
+			return net.ParseIP(resolution.Addresses[0])
+		}
+	}
+	return nil
+}
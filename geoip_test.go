@@ -0,0 +1,69 @@
+package zgrab2
+
+import (
+	"errors"
+	"net"
+	"testing"
+)
+
+// fakeIPDatabase answers lookups from maps keyed by address.
+type fakeIPDatabase struct {
+	countries map[string]string
+	asns      map[string]asnRecord
+}
+
+func (db *fakeIPDatabase) Lookup(ip net.IP, result interface{}) error {
+	switch record := result.(type) {
+	case *geoRecord:
+		record.Country.ISOCode = db.countries[ip.String()]
+	case *asnRecord:
+		*record = db.asns[ip.String()]
+	default:
+		return errors.New("unexpected record type")
+	}
+	return nil
+}
+
+func TestLocate(t *testing.T) {
+	defer func(geoip, asn ipDatabase) {
+		geoDatabases.geoip, geoDatabases.asn = geoip, asn
+	}(geoDatabases.geoip, geoDatabases.asn)
+	if locate(net.ParseIP("192.0.2.1")) != nil {
+		t.Error("expected no location without databases")
+	}
+	db := &fakeIPDatabase{
+		countries: map[string]string{"192.0.2.1": "NL"},
+		asns:      map[string]asnRecord{"192.0.2.1": {Number: 64496, Organization: "Example Networks"}, "2001:db8::1": {Number: 64497}},
+	}
+	geoDatabases.geoip, geoDatabases.asn = db, db
+	tests := []struct {
+		ip       string
+		expected *Location
+	}{
+		{"192.0.2.1", &Location{Country: "NL", ASN: 64496, ASName: "Example Networks"}},
+		{"2001:db8::1", &Location{ASN: 64497}},
+		{"198.51.100.1", nil},
+	}
+	for _, test := range tests {
+		location := locate(net.ParseIP(test.ip))
+		if (location == nil) != (test.expected == nil) || (location != nil && *location != *test.expected) {
+			t.Errorf("%s: expected %+v, got %+v", test.ip, test.expected, location)
+		}
+	}
+}
+
+func TestGrabAddress(t *testing.T) {
+	grab := &Grab{Domain: "example.com", Data: map[string]ScanResponse{
+		"http": {Port: 80, Resolution: &Resolution{Name: "example.com", Addresses: []string{"192.0.2.1", "192.0.2.2"}}},
+	}}
+	if ip := grabAddress(grab); !ip.Equal(net.ParseIP("192.0.2.1")) {
+		t.Errorf("expected the first resolved address, got %v", ip)
+	}
+	grab.IP = "198.51.100.1"
+	if ip := grabAddress(grab); !ip.Equal(net.ParseIP("198.51.100.1")) {
+		t.Errorf("expected the target's IP, got %v", ip)
+	}
+	if ip := grabAddress(&Grab{Domain: "example.com"}); ip != nil {
+		t.Errorf("expected no address, got %v", ip)
+	}
+}
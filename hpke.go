@@ -0,0 +1,125 @@
+package zgrab2
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+)
+
+// HPKE (RFC 9180) identifiers. Only DHKEM(X25519, HKDF-SHA256) and
+// HKDF-SHA256 are implemented, as used by ECH configurations in practice.
+const (
+	hpkeKEMX25519     = 0x0020
+	hpkeKDFSHA256     = 0x0001
+	hpkeAEADAES128GCM = 0x0001
+	hpkeAEADAES256GCM = 0x0002
+)
+
+// hkdfExpand is HKDF-Expand (RFC 5869) with SHA-256.
+func hkdfExpand(prk, info []byte, length int) []byte {
+	ret := make([]byte, length)
+	io.ReadFull(hkdf.Expand(sha256.New, prk, info), ret)
+	return ret
+}
+
+func hpkeLabeledExtract(suiteID, salt []byte, label string, ikm []byte) []byte {
+	return hkdf.Extract(sha256.New, bytes.Join([][]byte{[]byte("HPKE-v1"), suiteID, []byte(label), ikm}, nil), salt)
+}
+
+func hpkeLabeledExpand(suiteID, prk []byte, label string, info []byte, length int) []byte {
+	labeled := bytes.Join([][]byte{TLSUint16s(uint16(length)), []byte("HPKE-v1"), suiteID, []byte(label), info}, nil)
+	return hkdfExpand(prk, labeled, length)
+}
+
+// x25519 returns the X25519 shared secret of the private and public keys,
+// or an error if the public key is not a valid point.
+func x25519(private, public []byte) ([]byte, error) {
+	if len(private) != 32 || len(public) != 32 {
+		return nil, errors.New("invalid X25519 key length")
+	}
+	var priv, pub, shared [32]byte
+	copy(priv[:], private)
+	copy(pub[:], public)
+	curve25519.ScalarMult(&shared, &priv, &pub)
+	if shared == [32]byte{} {
+		return nil, errors.New("invalid X25519 public key")
+	}
+	return shared[:], nil
+}
+
+// x25519KeyPair returns a new X25519 private and public key.
+func x25519KeyPair() (private, public []byte, err error) {
+	var priv, pub [32]byte
+	if _, err := rand.Read(priv[:]); err != nil {
+		return nil, nil, err
+	}
+	curve25519.ScalarBaseMult(&pub, &priv)
+	return priv[:], pub[:], nil
+}
+
+// hpkeSharedSecret derives the KEM shared secret from the X25519 shared
+// secret, the encapsulated key and the recipient's public key.
+func hpkeSharedSecret(dh, enc, recipient []byte) []byte {
+	suiteID := append([]byte("KEM"), TLSUint16s(hpkeKEMX25519)...)
+	prk := hpkeLabeledExtract(suiteID, nil, "eae_prk", dh)
+	kemContext := append(append([]byte(nil), enc...), recipient...)
+	return hpkeLabeledExpand(suiteID, prk, "shared_secret", kemContext, 32)
+}
+
+// hpkeKeySchedule returns the AEAD and base nonce of a base mode context
+// for the shared secret and info. The first message is sealed with the base
+// nonce itself.
+func hpkeKeySchedule(aeadID uint16, sharedSecret, info []byte) (cipher.AEAD, []byte, error) {
+	var keyLength int
+	switch aeadID {
+	case hpkeAEADAES128GCM:
+		keyLength = 16
+	case hpkeAEADAES256GCM:
+		keyLength = 32
+	default:
+		return nil, nil, fmt.Errorf("unsupported HPKE AEAD 0x%04x", aeadID)
+	}
+	suiteID := append([]byte("HPKE"), TLSUint16s(hpkeKEMX25519, hpkeKDFSHA256, aeadID)...)
+	pskIDHash := hpkeLabeledExtract(suiteID, nil, "psk_id_hash", nil)
+	infoHash := hpkeLabeledExtract(suiteID, nil, "info_hash", info)
+	context := bytes.Join([][]byte{{0}, pskIDHash, infoHash}, nil)
+	secret := hpkeLabeledExtract(suiteID, sharedSecret, "secret", nil)
+	key := hpkeLabeledExpand(suiteID, secret, "key", context, keyLength)
+	nonce := hpkeLabeledExpand(suiteID, secret, "base_nonce", context, 12)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, nil, err
+	}
+	return aead, nonce, nil
+}
+
+// hpkeSetupSender encapsulates a new key to the recipient's X25519 public
+// key, returning the encapsulated key, and the AEAD and nonce to seal the
+// first message with.
+func hpkeSetupSender(recipient []byte, aeadID uint16, info []byte) (enc []byte, aead cipher.AEAD, nonce []byte, err error) {
+	ephemeral, enc, err := x25519KeyPair()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	dh, err := x25519(ephemeral, recipient)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	aead, nonce, err = hpkeKeySchedule(aeadID, hpkeSharedSecret(dh, enc, recipient), info)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return enc, aead, nonce, nil
+}
@@ -0,0 +1,92 @@
+package zgrab2
+
+import (
+	"fmt"
+	"net"
+)
+
+// icsProtocols are the protocols of industrial control systems, whose
+// scanners must implement ICSScanner to be run with --ics-safe.
+var icsProtocols = map[string]bool{
+	"bacnet":  true,
+	"dnp3":    true,
+	"enip":    true,
+	"fox":     true,
+	"iec104":  true,
+	"modbus":  true,
+	"opcua":   true,
+	"s7":      true,
+	"siemens": true,
+}
+
+// ICSScanner is implemented by scanners of industrial control system
+// protocols. With --ics-safe, every message the scanner writes to a
+// connection opened with ScanTarget.Open or OpenUDP is checked with
+// CheckICSRequest before it is sent, so that only identification requests
+// (e.g. Modbus Read Device Identification) ever reach the device, however
+// the module is written or configured.
+type ICSScanner interface {
+	Scanner
+
+	// CheckICSRequest returns nil if the message is made up only of
+	// identification requests, and an error naming the first request that
+	// is not one otherwise. Messages that cannot be parsed are not
+	// identification requests.
+	CheckICSRequest(message []byte) error
+}
+
+// ICSUnsafeRequestError is the error returned by writes that --ics-safe
+// blocks.
+type ICSUnsafeRequestError struct {
+	Protocol string
+	Err      error
+}
+
+func (e *ICSUnsafeRequestError) Error() string {
+	return fmt.Sprintf("%s request blocked by --ics-safe: %v", e.Protocol, e.Err)
+}
+
+// checkICSSafe returns an error if --ics-safe is given and the scanner is of
+// an industrial control system protocol, but cannot check its requests.
+func checkICSSafe(s Scanner) error {
+	if !config.ICSSafe || !icsProtocols[s.Protocol()] {
+		return nil
+	}
+	if _, ok := s.(ICSScanner); !ok {
+		return fmt.Errorf("%s: the %s module does not support --ics-safe", s.GetName(), s.Protocol())
+	}
+	return nil
+}
+
+// icsGuard returns the check applied to the scanner's writes, or nil if
+// there is none.
+func icsGuard(s Scanner) ICSScanner {
+	if !config.ICSSafe {
+		return nil
+	}
+	ics, _ := s.(ICSScanner)
+	return ics
+}
+
+// icsSafeConn checks each message written to the connection with the
+// scanner's CheckICSRequest.
+type icsSafeConn struct {
+	net.Conn
+	scanner ICSScanner
+}
+
+func (c *icsSafeConn) Write(b []byte) (int, error) {
+	if err := c.scanner.CheckICSRequest(b); err != nil {
+		return 0, &ICSUnsafeRequestError{Protocol: c.scanner.Protocol(), Err: err}
+	}
+	return c.Conn.Write(b)
+}
+
+// guardICS wraps a connection opened for the current scanner, if its
+// writes are checked.
+func (target *ScanTarget) guardICS(conn net.Conn) net.Conn {
+	if target.ics == nil {
+		return conn
+	}
+	return &icsSafeConn{Conn: conn, scanner: target.ics}
+}
@@ -0,0 +1,93 @@
+package zgrab2
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// icsLineScanner is a lineScanner of an ICS protocol, whose identification
+// requests are lines starting with "id".
+type icsLineScanner struct {
+	lineScanner
+}
+
+func (s *icsLineScanner) Protocol() string { return "modbus" }
+func (s *icsLineScanner) CheckICSRequest(message []byte) error {
+	if !strings.HasPrefix(string(message), "id") {
+		return errors.New("not an identification request")
+	}
+	return nil
+}
+
+// uncheckedICSScanner is a lineScanner of an ICS protocol that cannot check
+// its requests.
+type uncheckedICSScanner struct {
+	lineScanner
+}
+
+func (s *uncheckedICSScanner) Protocol() string { return "dnp3" }
+
+func TestICSSafe(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	received := make(chan string, 4)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				line, err := bufio.NewReader(conn).ReadString('\n')
+				if err != nil {
+					return
+				}
+				received <- line
+				conn.Write([]byte(line))
+			}(conn)
+		}
+	}()
+	saved := config
+	defer func() { config = saved }()
+	config.ICSSafe = true
+
+	flags := BaseFlags{Port: uint(listener.Addr().(*net.TCPAddr).Port), Timeout: time.Second}
+	target := ScanTarget{IP: net.ParseIP("127.0.0.1")}
+	_, res := RunScanner(&icsLineScanner{lineScanner{name: "identify", flags: flags}}, nil, target)
+	if res.Status != SCAN_SUCCESS || !res.ICSSafe {
+		t.Errorf("identify: unexpected response %+v", res)
+	}
+	if line := <-received; line != "identify\n" {
+		t.Errorf("identify: server received %q", line)
+	}
+
+	_, res = RunScanner(&icsLineScanner{lineScanner{name: "write", flags: flags}}, nil, target)
+	if res.Status == SCAN_SUCCESS || res.Error == nil || !strings.Contains(*res.Error, "blocked by --ics-safe") {
+		t.Errorf("write: unexpected response %+v", res)
+	}
+	select {
+	case line := <-received:
+		t.Errorf("write: server received %q", line)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if err := checkICSSafe(&uncheckedICSScanner{lineScanner{name: "dnp3"}}); err == nil {
+		t.Error("expected an error for an ICS scanner without CheckICSRequest")
+	}
+	if err := checkICSSafe(&lineScanner{name: "line"}); err != nil {
+		t.Errorf("unexpected error for a non-ICS scanner: %v", err)
+	}
+	config.ICSSafe = false
+	_, res = RunScanner(&icsLineScanner{lineScanner{name: "write", flags: flags}}, nil, target)
+	if res.Status != SCAN_SUCCESS || res.ICSSafe {
+		t.Errorf("write without --ics-safe: unexpected response %+v", res)
+	}
+}
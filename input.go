@@ -1,7 +1,10 @@
 package zgrab2
 
 import (
+	"bufio"
+	"bytes"
 	"encoding/csv"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net"
@@ -22,7 +25,8 @@ import (
 //
 // A CIDR block may be provided in the IP field, in which case the
 // framework expands the record into targets for every address in the
-// block.
+// block. An IPv6 address may be followed by a zone, as in fe80::1%eth0;
+// GetTargetsCSV splits it off before calling ParseCSVTarget.
 //
 // Trailing empty fields may be omitted.
 // Comment lines begin with #, and empty lines are ignored.
@@ -81,27 +85,50 @@ func duplicateIP(ip net.IP) net.IP {
 }
 
 // InputTargetsCSV is an InputTargetsFunc that calls GetTargetsCSV with
-// the CSV file provided on the command line.
+// the CSV file provided on the command line. Large regular files are
+// memory-mapped rather than read.
 func InputTargetsCSV(ch chan<- ScanTarget) error {
-	return GetTargetsCSV(config.inputFile, ch)
+	if data, err := mapInputFile(config.inputFile); err == nil && data != nil {
+		defer unmapInputFile(data)
+		lines := &mappedLines{data: data, tracker: progress}
+		defer lines.flushProgress()
+		return getTargets(lines, ch)
+	}
+	return GetTargetsCSV(&progressReader{Reader: config.inputFile, tracker: progress}, ch)
 }
 
 // GetTargetsCSV reads targets from a CSV source, generates ScanTargets,
 // and delivers them to the provided channel.
+//
+// In addition to the fields read by ParseCSVTarget, each record may have a
+// fourth METADATA field, which is copied into the output for the target
+// without being interpreted (see parseMetadata).
 func GetTargetsCSV(source io.Reader, ch chan<- ScanTarget) error {
-	csvreader := csv.NewReader(source)
-	csvreader.Comment = '#'
-	csvreader.FieldsPerRecord = -1 // variable
+	return getTargets(&bufferedLines{reader: bufio.NewReaderSize(source, inputBufferSize)}, ch)
+}
+
+func getTargets(lines lineSource, ch chan<- ScanTarget) error {
+	records := targetReader{lines: lines}
+	var record uint64
 	for {
-		fields, err := csvreader.Read()
+		fields, err := records.read()
 		if err == io.EOF {
 			break
 		} else if err != nil {
 			return err
 		}
-		if len(fields) == 0 {
-			continue
+		record++
+		var metadata json.RawMessage
+		if len(fields) > 3 {
+			if len(fields) > 4 {
+				log.Errorf("parse error, skipping: too many fields: %q", fields)
+				continue
+			}
+			metadata = parseMetadata(fields[3])
+			fields = fields[:3]
 		}
+		var zone string
+		fields[0], zone = splitZone(strings.TrimSpace(fields[0]))
 		ipnet, domain, tag, err := ParseCSVTarget(fields)
 		if err != nil {
 			log.Errorf("parse error, skipping: %v", err)
@@ -112,18 +139,187 @@ func GetTargetsCSV(source io.Reader, ch chan<- ScanTarget) error {
 			if ipnet.Mask != nil {
 				// expand CIDR block into one target for each IP
 				for ip = ipnet.IP.Mask(ipnet.Mask); ipnet.Contains(ip); incrementIP(ip) {
-					ch <- ScanTarget{IP: duplicateIP(ip), Domain: domain, Tag: tag}
+					ch <- ScanTarget{IP: duplicateIP(ip), Domain: domain, Tag: tag, Metadata: metadata, inputRecord: record}
 				}
 				continue
 			} else {
 				ip = ipnet.IP
 			}
 		}
-		ch <- ScanTarget{IP: ip, Domain: domain, Tag: tag}
+		ch <- ScanTarget{IP: ip, Zone: zone, Domain: domain, Tag: tag, Metadata: metadata, inputRecord: record}
 	}
 	return nil
 }
 
+// inputBufferSize is the size of the buffer input is read through, when it
+// is not memory-mapped.
+const inputBufferSize = 1 << 20
+
+// A lineSource gives the lines of the input.
+type lineSource interface {
+	// readLine returns the next line, without its newline, or io.EOF. The
+	// line is only valid until the next call.
+	readLine() ([]byte, error)
+}
+
+// bufferedLines reads lines through a bufio.Reader, without copying those
+// that fit in its buffer.
+type bufferedLines struct {
+	reader *bufio.Reader
+
+	// long holds lines longer than the buffer.
+	long []byte
+}
+
+func (b *bufferedLines) readLine() ([]byte, error) {
+	line, err := b.reader.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		b.long = append(b.long[:0], line...)
+		for err == bufio.ErrBufferFull {
+			line, err = b.reader.ReadSlice('\n')
+			b.long = append(b.long, line...)
+		}
+		line = b.long
+	}
+	if err == io.EOF && len(line) > 0 {
+		// The last line has no newline.
+		err = nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(line, []byte("\n")), nil
+}
+
+// mappedLines gives the lines of a memory-mapped input file.
+type mappedLines struct {
+	data   []byte
+	offset int
+
+	// tracker, if set, is told of the bytes consumed, in batches of
+	// progressBatch bytes so that its lock is not taken for every line.
+	tracker *progressTracker
+	pending int
+}
+
+const progressBatch = 1 << 20
+
+func (m *mappedLines) readLine() ([]byte, error) {
+	if m.offset >= len(m.data) {
+		return nil, io.EOF
+	}
+	rest := m.data[m.offset:]
+	line := rest
+	n := len(rest)
+	if i := bytes.IndexByte(rest, '\n'); i >= 0 {
+		line, n = rest[:i], i+1
+	}
+	m.offset += n
+	if m.tracker != nil {
+		if m.pending += n; m.pending >= progressBatch {
+			m.flushProgress()
+		}
+	}
+	return line, nil
+}
+
+// flushProgress tells the tracker of the bytes consumed since it was last
+// told.
+func (m *mappedLines) flushProgress() {
+	if m.tracker != nil && m.pending > 0 {
+		m.tracker.inputConsumed(m.pending)
+		m.pending = 0
+	}
+}
+
+// targetReader reads the CSV records of the input. Lines without quotes,
+// which are almost all of them, are split in place, with a single
+// allocation for the line; the rest are handed to encoding/csv.
+type targetReader struct {
+	lines lineSource
+
+	// line is the number of lines read, for error messages.
+	line int
+
+	fields []string
+	quoted []byte
+}
+
+// read returns the fields of the next record, skipping empty lines and
+// comments (lines starting with #). The fields are only valid until the next
+// call.
+func (r *targetReader) read() ([]string, error) {
+	for {
+		line, err := r.lines.readLine()
+		if err != nil {
+			return nil, err
+		}
+		r.line++
+		line = bytes.TrimSuffix(line, []byte("\r"))
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		if bytes.IndexByte(line, '"') >= 0 {
+			return r.readQuoted(line)
+		}
+		text := string(line)
+		r.fields = r.fields[:0]
+		for {
+			i := strings.IndexByte(text, ',')
+			if i < 0 {
+				break
+			}
+			r.fields = append(r.fields, text[:i])
+			text = text[i+1:]
+		}
+		r.fields = append(r.fields, text)
+		return r.fields, nil
+	}
+}
+
+// readQuoted parses a record with quoted fields, which may go on over the
+// following lines.
+func (r *targetReader) readQuoted(line []byte) ([]string, error) {
+	start := r.line
+	r.quoted = append(r.quoted[:0], line...)
+	for bytes.Count(r.quoted, []byte{'"'})%2 != 0 {
+		next, err := r.lines.readLine()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		r.line++
+		r.quoted = append(append(r.quoted, '\n'), next...)
+	}
+	reader := csv.NewReader(bytes.NewReader(r.quoted))
+	reader.FieldsPerRecord = -1
+	fields, err := reader.Read()
+	if err != nil {
+		if parseError, ok := err.(*csv.ParseError); ok {
+			parseError.StartLine += start - 1
+			parseError.Line += start - 1
+		}
+		return nil, err
+	}
+	return fields, nil
+}
+
+// parseMetadata returns the value of an input record's METADATA field: the
+// field itself if it is valid JSON, or otherwise the field as a JSON string.
+// Empty fields have no metadata.
+func parseMetadata(field string) json.RawMessage {
+	field = strings.TrimSpace(field)
+	if field == "" {
+		return nil
+	}
+	if json.Valid([]byte(field)) {
+		return json.RawMessage(field)
+	}
+	encoded, _ := json.Marshal(field)
+	return encoded
+}
+
 // InputTargetsFunc is a function type for target input functions.
 //
 // A function of this type generates ScanTargets on the provided
@@ -0,0 +1,13 @@
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd,!dragonfly
+
+package zgrab2
+
+import "os"
+
+func mapInputFile(file *os.File) ([]byte, error) {
+	return nil, nil
+}
+
+func unmapInputFile(data []byte) error {
+	return nil
+}
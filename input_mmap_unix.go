@@ -0,0 +1,29 @@
+// +build linux darwin freebsd netbsd openbsd dragonfly
+
+package zgrab2
+
+import (
+	"os"
+	"syscall"
+)
+
+// mapInputThreshold is the size from which regular input files are
+// memory-mapped.
+const mapInputThreshold = 16 << 20
+
+// mapInputFile memory-maps the input file, if it is a regular file of at
+// least mapInputThreshold bytes, or else returns nil.
+func mapInputFile(file *os.File) ([]byte, error) {
+	if file == nil {
+		return nil, nil
+	}
+	info, err := file.Stat()
+	if err != nil || !info.Mode().IsRegular() || info.Size() < mapInputThreshold || int64(int(info.Size())) != info.Size() {
+		return nil, err
+	}
+	return syscall.Mmap(int(file.Fd()), 0, int(info.Size()), syscall.PROT_READ, syscall.MAP_SHARED)
+}
+
+func unmapInputFile(data []byte) error {
+	return syscall.Munmap(data)
+}
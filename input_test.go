@@ -1,7 +1,14 @@
 package zgrab2
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
 	"net"
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -150,7 +157,10 @@ func TestGetTargetsCSV(t *testing.T) {
 10.0.0.1
 ,example.com
 example.com
-2.2.2.2/30,, tag`
+2.2.2.2/30,, tag
+10.0.0.2,,,"{""asset"": 42}"
+10.0.0.3,,,owner-a
+fe80::1%eth0,,link-local`
 
 	expected := []ScanTarget{
 		ScanTarget{IP: net.ParseIP("10.0.0.1"), Domain: "example.com", Tag: "tag"},
@@ -162,6 +172,9 @@ example.com
 		ScanTarget{IP: net.ParseIP("2.2.2.1"), Tag: "tag"},
 		ScanTarget{IP: net.ParseIP("2.2.2.2"), Tag: "tag"},
 		ScanTarget{IP: net.ParseIP("2.2.2.3"), Tag: "tag"},
+		ScanTarget{IP: net.ParseIP("10.0.0.2"), Metadata: json.RawMessage(`{"asset": 42}`)},
+		ScanTarget{IP: net.ParseIP("10.0.0.3"), Metadata: json.RawMessage(`"owner-a"`)},
+		ScanTarget{IP: net.ParseIP("fe80::1"), Zone: "eth0", Tag: "link-local"},
 	}
 
 	ch := make(chan ScanTarget, 0)
@@ -184,8 +197,89 @@ example.com
 	for i := range expected {
 		if res[i].IP.String() != expected[i].IP.String() ||
 			res[i].Domain != expected[i].Domain ||
-			res[i].Tag != expected[i].Tag {
+			res[i].Tag != expected[i].Tag ||
+			res[i].Zone != expected[i].Zone ||
+			string(res[i].Metadata) != string(expected[i].Metadata) {
 			t.Errorf("wrong data in ScanTarget %d (got %v; expected %v)", i, res[i], expected[i])
 		}
 	}
 }
+
+func TestTargetReader(t *testing.T) {
+	input := "10.0.0.1,example.com\r\n#10.0.0.2\r\n\r\n10.0.0.3,\"multi\nline\",tag\n" + strings.Repeat("a", 100) + ".example.com\n10.0.0.4,,,x"
+	expected := [][]string{
+		{"10.0.0.1", "example.com"},
+		{"10.0.0.3", "multi\nline", "tag"},
+		{strings.Repeat("a", 100) + ".example.com"},
+		{"10.0.0.4", "", "", "x"},
+	}
+	sources := map[string]lineSource{
+		"buffered": &bufferedLines{reader: bufio.NewReaderSize(strings.NewReader(input), 16)},
+		"mapped":   &mappedLines{data: []byte(input)},
+	}
+	for name, lines := range sources {
+		reader := targetReader{lines: lines}
+		var records [][]string
+		for {
+			fields, err := reader.read()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			records = append(records, append([]string(nil), fields...))
+		}
+		if !reflect.DeepEqual(records, expected) {
+			t.Errorf("%s: got %q", name, records)
+		}
+	}
+
+	reader := targetReader{lines: &mappedLines{data: []byte("10.0.0.1\n10.0.0.2\n10.0.0.3,a\"b\n")}}
+	reader.read()
+	reader.read()
+	_, err := reader.read()
+	if parseError, ok := err.(*csv.ParseError); !ok || parseError.Line != 3 {
+		t.Errorf("got %v", err)
+	}
+}
+
+// benchmarkInput returns lines of CSV input like those of a typical scan.
+func benchmarkInput(lines int) []byte {
+	var b bytes.Buffer
+	for i := 0; i < lines; i++ {
+		fmt.Fprintf(&b, "10.%d.%d.%d,host%d.example.com,tag\n", i>>16&0xff, i>>8&0xff, i&0xff, i)
+	}
+	return b.Bytes()
+}
+
+func benchmarkGetTargets(b *testing.B, source func(data []byte) lineSource) {
+	data := benchmarkInput(100000)
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		ch := make(chan ScanTarget, 1024)
+		done := make(chan struct{})
+		go func() {
+			for range ch {
+			}
+			close(done)
+		}()
+		if err := getTargets(source(data), ch); err != nil {
+			b.Fatal(err)
+		}
+		close(ch)
+		<-done
+	}
+}
+
+func BenchmarkGetTargetsCSV(b *testing.B) {
+	benchmarkGetTargets(b, func(data []byte) lineSource {
+		return &bufferedLines{reader: bufio.NewReaderSize(bytes.NewReader(data), inputBufferSize)}
+	})
+}
+
+func BenchmarkGetTargetsMapped(b *testing.B) {
+	benchmarkGetTargets(b, func(data []byte) lineSource {
+		return &mappedLines{data: data}
+	})
+}
@@ -0,0 +1,303 @@
+// Package export converts selected zgrab2 results into documents for
+// threat-intel platforms: STIX 2.1 bundles and MISP events. Which results are
+// exported, and how they are labelled, is given by a Mapping.
+package export
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zmap/zgrab2/lib/output"
+	"gopkg.in/yaml.v2"
+)
+
+// Output formats.
+const (
+	FormatSTIX = "stix"
+	FormatMISP = "misp"
+)
+
+// Mapping is the export config: the rules selecting findings from the
+// results, and the defaults for the exported document. For example:
+//
+//	format: misp
+//	misp:
+//	  info: Exposed services
+//	findings:
+//	  - name: exposed-redis
+//	    description: Redis answering commands without authentication
+//	    module: redis
+//	    match:
+//	      result.info_response: .
+//	    tags: ["tlp:amber"]
+type Mapping struct {
+	// Format is the default output format, FormatSTIX if empty.
+	Format string `yaml:"format"`
+
+	// MISP gives the event fields for FormatMISP.
+	MISP MISPConfig `yaml:"misp"`
+
+	// Findings are the rules selecting the results to export. A scan
+	// response matching several rules is exported once for each.
+	Findings []*Rule `yaml:"findings"`
+}
+
+// MISPConfig gives the fields of the exported MISP event.
+type MISPConfig struct {
+	// Info is the event's description.
+	Info string `yaml:"info"`
+
+	// ThreatLevel is the event's threat level ID, from 1 (high) to 4
+	// (undefined, the default).
+	ThreatLevel int `yaml:"threat_level"`
+
+	// Tags are added to the event itself.
+	Tags []string `yaml:"tags"`
+}
+
+// Rule selects the scan responses reporting a finding.
+type Rule struct {
+	// Name identifies the finding in the exported objects.
+	Name string `yaml:"name"`
+
+	// Description explains the finding.
+	Description string `yaml:"description"`
+
+	// Module, if set, is the protocol the scan response must be from.
+	Module string `yaml:"module"`
+
+	// Scan, if set, is the name the scan must have been given (its key in
+	// the result's data, which differs from the module in multiple mode).
+	Scan string `yaml:"scan"`
+
+	// Status is the status the scan response must have; "success" if empty,
+	// or "any" for any status.
+	Status string `yaml:"status"`
+
+	// Match maps dot-separated paths in the scan response (e.g.
+	// "result.info_response" or "product.name") to regular expressions that
+	// their values must match. Numbers and booleans are matched in their JSON
+	// form, lists match if any element does, and objects match if present.
+	Match map[string]string `yaml:"match"`
+
+	// Tags are attached to the exported objects: as labels in STIX, and as
+	// tags in MISP.
+	Tags []string `yaml:"tags"`
+
+	paths []matchPath
+}
+
+type matchPath struct {
+	path  []string
+	value *regexp.Regexp
+}
+
+// ParseMapping reads a YAML mapping config and validates its rules.
+func ParseMapping(data []byte) (*Mapping, error) {
+	ret := new(Mapping)
+	if err := yaml.UnmarshalStrict(data, ret); err != nil {
+		return nil, fmt.Errorf("invalid mapping: %v", err)
+	}
+	switch ret.Format {
+	case "":
+		ret.Format = FormatSTIX
+	case FormatSTIX, FormatMISP:
+	default:
+		return nil, fmt.Errorf("invalid mapping: unknown format %q", ret.Format)
+	}
+	if ret.MISP.ThreatLevel == 0 {
+		ret.MISP.ThreatLevel = 4
+	} else if ret.MISP.ThreatLevel < 1 || ret.MISP.ThreatLevel > 4 {
+		return nil, fmt.Errorf("invalid mapping: threat_level must be from 1 to 4")
+	}
+	if len(ret.Findings) == 0 {
+		return nil, fmt.Errorf("invalid mapping: no findings")
+	}
+	for i, rule := range ret.Findings {
+		if rule == nil || rule.Name == "" {
+			return nil, fmt.Errorf("invalid mapping: finding %d has no name", i+1)
+		}
+		if rule.Status == "" {
+			rule.Status = "success"
+		}
+		for path, expr := range rule.Match {
+			value, err := regexp.Compile(expr)
+			if err != nil {
+				return nil, fmt.Errorf("invalid mapping: %s: %s: %v", rule.Name, path, err)
+			}
+			rule.paths = append(rule.paths, matchPath{path: strings.Split(path, "."), value: value})
+		}
+	}
+	return ret, nil
+}
+
+// LoadMapping reads a YAML mapping config from a file.
+func LoadMapping(fileName string) (*Mapping, error) {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	return ParseMapping(data)
+}
+
+// Finding is a scan response selected by a rule.
+type Finding struct {
+	Rule *Rule
+
+	// IP and Domain identify the target; at least one is set.
+	IP     string
+	Domain string
+
+	// Port is the port scanned, or 0 if it was not recorded.
+	Port uint
+
+	// Protocol is the module that produced the scan response.
+	Protocol string
+
+	// Transport is "tcp" or "udp".
+	Transport string
+
+	// Time is when the scan was made, or when it was read if the result has
+	// no timestamp.
+	Time time.Time
+}
+
+// result is the part of a zgrab2 output line used to select findings.
+type result struct {
+	IP     string                            `json:"ip"`
+	Domain string                            `json:"domain"`
+	Data   map[string]map[string]interface{} `json:"data"`
+}
+
+// matches returns true if the scan response, named scan, satisfies the rule.
+func (rule *Rule) matches(scan string, response map[string]interface{}) bool {
+	if rule.Scan != "" && rule.Scan != scan {
+		return false
+	}
+	if rule.Module != "" && response["protocol"] != rule.Module {
+		return false
+	}
+	if rule.Status != "any" && response["status"] != rule.Status {
+		return false
+	}
+	for _, m := range rule.paths {
+		if !matchValue(lookupPath(response, m.path), m.value) {
+			return false
+		}
+	}
+	return true
+}
+
+// lookupPath returns the value at the path in a decoded JSON object, or nil if
+// it is absent.
+func lookupPath(value interface{}, path []string) interface{} {
+	for _, field := range path {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = object[field]
+	}
+	return value
+}
+
+func matchValue(value interface{}, expr *regexp.Regexp) bool {
+	switch v := value.(type) {
+	case nil:
+		return false
+	case string:
+		return expr.MatchString(v)
+	case json.Number:
+		return expr.MatchString(v.String())
+	case bool:
+		return expr.MatchString(strconv.FormatBool(v))
+	case []interface{}:
+		for _, elem := range v {
+			if matchValue(elem, expr) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// ReadFindings reads zgrab2 output lines and returns the findings selected by
+// the mapping's rules, in input order.
+func ReadFindings(reader io.Reader, mapping *Mapping) ([]*Finding, error) {
+	var ret []*Finding
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(nil, 64*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		data := bytes.TrimSpace(scanner.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.UseNumber()
+		var res result
+		if err := decoder.Decode(&res); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		if res.IP == "" && res.Domain == "" {
+			continue
+		}
+		for _, scan := range output.SortedScans(res.Data) {
+			response := res.Data[scan]
+			for _, rule := range mapping.Findings {
+				if !rule.matches(scan, response) {
+					continue
+				}
+				finding := &Finding{Rule: rule, IP: res.IP, Domain: res.Domain, Transport: "tcp"}
+				finding.Protocol, _ = response["protocol"].(string)
+				if transport, ok := response["transport"].(string); ok && transport != "" {
+					finding.Transport = transport
+				}
+				if port, ok := response["port"].(json.Number); ok {
+					if n, err := strconv.ParseUint(port.String(), 10, 16); err == nil {
+						finding.Port = uint(n)
+					}
+				}
+				if timestamp, ok := response["timestamp"].(string); ok {
+					finding.Time, _ = time.Parse(time.RFC3339Nano, timestamp)
+				}
+				if finding.Time.IsZero() {
+					finding.Time = time.Now()
+				}
+				ret = append(ret, finding)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// Export writes the findings as a document in the format (FormatSTIX or
+// FormatMISP). Objects are given deterministic IDs, so exporting the same
+// findings again produces the same document.
+func Export(writer io.Writer, format string, mapping *Mapping, findings []*Finding) error {
+	var doc interface{}
+	switch format {
+	case FormatSTIX:
+		doc = stixBundle(findings)
+	case FormatMISP:
+		doc = mispEvent(&mapping.MISP, findings)
+	default:
+		return fmt.Errorf("unknown export format %q", format)
+	}
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(doc)
+}
@@ -0,0 +1,152 @@
+package export
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+const testMapping = `
+misp:
+  info: Exposed services
+  tags: ["tlp:amber"]
+findings:
+  - name: exposed-redis
+    description: Redis answering commands without authentication
+    module: redis
+    match:
+      result.info_response: redis_version
+    tags: ["exposure:database"]
+  - name: old-openssh
+    module: ssh
+    match:
+      product.name: ^openssh$
+      product.version: ^[1-6]\.
+`
+
+const testResults = `{"ip":"10.0.0.1","data":{"redis":{"status":"success","protocol":"redis","port":6379,"timestamp":"2026-10-16T12:00:00Z","result":{"info_response":"# Server\r\nredis_version:6.0.9"}}}}
+{"ip":"10.0.0.2","data":{"redis":{"status":"success","protocol":"redis","port":6379,"timestamp":"2026-10-16T12:00:01Z","result":{"ping_response":"NOAUTH"}}}}
+{"ip":"10.0.0.3","data":{"redis":{"status":"io-timeout","protocol":"redis","port":6379,"timestamp":"2026-10-16T12:00:02Z"}}}
+
+{"ip":"2001:db8::1","domain":"example.com","data":{"ssh":{"status":"success","protocol":"ssh","port":22,"timestamp":"2026-10-15T08:00:00Z","product":{"vendor":"openbsd","name":"openssh","version":"5.3p1"}}}}
+{"ip":"10.0.0.4","data":{"ssh":{"status":"success","protocol":"ssh","port":22,"timestamp":"2026-10-16T12:00:03Z","product":{"vendor":"openbsd","name":"openssh","version":"9.6p1"}}}}
+`
+
+func readTestFindings(t *testing.T) (*Mapping, []*Finding) {
+	mapping, err := ParseMapping([]byte(testMapping))
+	if err != nil {
+		t.Fatal(err)
+	}
+	findings, err := ReadFindings(strings.NewReader(testResults), mapping)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mapping, findings
+}
+
+func TestParseMapping(t *testing.T) {
+	mapping, err := ParseMapping([]byte(testMapping))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mapping.Format != FormatSTIX || mapping.MISP.ThreatLevel != 4 || mapping.Findings[0].Status != "success" {
+		t.Errorf("defaults not applied: %+v", mapping)
+	}
+	for _, bad := range []string{
+		"findings: []",
+		"format: csv\nfindings: [{name: a}]",
+		"findings: [{module: redis}]",
+		"findings: [{name: a, match: {result: '('}}]",
+		"findings: [{name: a, modul: redis}]",
+		"misp: {threat_level: 5}\nfindings: [{name: a}]",
+	} {
+		if _, err := ParseMapping([]byte(bad)); err == nil {
+			t.Errorf("%q: expected an error", bad)
+		}
+	}
+}
+
+func TestReadFindings(t *testing.T) {
+	_, findings := readTestFindings(t)
+	if len(findings) != 2 {
+		t.Fatalf("expected 2 findings, got %d", len(findings))
+	}
+	redis, ssh := findings[0], findings[1]
+	if redis.Rule.Name != "exposed-redis" || redis.IP != "10.0.0.1" || redis.Port != 6379 || redis.Protocol != "redis" || redis.Transport != "tcp" {
+		t.Errorf("wrong redis finding: %+v", redis)
+	}
+	if ssh.Rule.Name != "old-openssh" || ssh.Domain != "example.com" || ssh.Time.Day() != 15 {
+		t.Errorf("wrong ssh finding: %+v", ssh)
+	}
+}
+
+func TestExportSTIX(t *testing.T) {
+	mapping, findings := readTestFindings(t)
+	var first, second bytes.Buffer
+	if err := Export(&first, FormatSTIX, mapping, findings); err != nil {
+		t.Fatal(err)
+	}
+	Export(&second, FormatSTIX, mapping, findings)
+	if first.String() != second.String() {
+		t.Error("export is not deterministic")
+	}
+	var bundle struct {
+		Type    string                   `json:"type"`
+		Objects []map[string]interface{} `json:"objects"`
+	}
+	if err := json.Unmarshal(first.Bytes(), &bundle); err != nil {
+		t.Fatal(err)
+	}
+	types := make(map[string]int)
+	ids := make(map[string]bool)
+	for _, object := range bundle.Objects {
+		types[object["type"].(string)]++
+		ids[object["id"].(string)] = true
+	}
+	expected := map[string]int{"ipv4-addr": 1, "ipv6-addr": 1, "domain-name": 1, "network-traffic": 2, "observed-data": 2, "note": 1}
+	for objectType, n := range expected {
+		if types[objectType] != n {
+			t.Errorf("expected %d %s objects, got %d", n, objectType, types[objectType])
+		}
+	}
+	for _, object := range bundle.Objects {
+		refs, _ := object["object_refs"].([]interface{})
+		for _, ref := range refs {
+			if !ids[ref.(string)] {
+				t.Errorf("%s refers to missing object %s", object["id"], ref)
+			}
+		}
+	}
+	// SCO IDs are the UUIDv5 of their canonical ID-contributing properties.
+	if id := stixID("ipv4-addr", map[string]interface{}{"value": "198.51.100.3"}); id != "ipv4-addr--28bb3599-77cd-5a82-a950-b5bc3caf07c4" {
+		t.Errorf("wrong deterministic ID %s", id)
+	}
+}
+
+func TestExportMISP(t *testing.T) {
+	mapping, findings := readTestFindings(t)
+	var buf bytes.Buffer
+	if err := Export(&buf, FormatMISP, mapping, findings); err != nil {
+		t.Fatal(err)
+	}
+	var doc mispEventDocument
+	if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	event := doc.Event
+	if event.Info != "Exposed services" || event.Date != "2026-10-15" || event.ThreatLevelID != "4" || len(event.Tag) != 1 {
+		t.Errorf("wrong event: %+v", event)
+	}
+	var values []string
+	for _, attribute := range event.Attribute {
+		values = append(values, attribute.Type+"="+attribute.Value)
+	}
+	expected := "ip-dst|port=10.0.0.1|6379 ip-dst|port=2001:db8::1|22 hostname|port=example.com|22"
+	if got := strings.Join(values, " "); got != expected {
+		t.Errorf("expected attributes %s, got %s", expected, got)
+	}
+	if tags := event.Attribute[0].Tag; len(tags) != 1 || tags[0].Name != "exposure:database" {
+		t.Errorf("wrong attribute tags %v", tags)
+	}
+}
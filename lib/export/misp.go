@@ -0,0 +1,115 @@
+package export
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// mispNamespace is the UUIDv5 namespace for the IDs of exported MISP events
+// and attributes.
+var mispNamespace = [16]byte{0x6b, 0x1d, 0x3e, 0x0c, 0x4f, 0x5a, 0x4d, 0x2e, 0x9a, 0x77, 0x1c, 0x4b, 0x8e, 0x21, 0x3f, 0x90}
+
+type mispTag struct {
+	Name string `json:"name"`
+}
+
+type mispAttribute struct {
+	UUID      string    `json:"uuid"`
+	Type      string    `json:"type"`
+	Category  string    `json:"category"`
+	Value     string    `json:"value"`
+	Comment   string    `json:"comment,omitempty"`
+	ToIDS     bool      `json:"to_ids"`
+	Timestamp string    `json:"timestamp"`
+	Tag       []mispTag `json:"Tag,omitempty"`
+}
+
+type mispEventBody struct {
+	UUID          string          `json:"uuid"`
+	Info          string          `json:"info"`
+	Date          string          `json:"date"`
+	ThreatLevelID string          `json:"threat_level_id"`
+	Analysis      string          `json:"analysis"`
+	Distribution  string          `json:"distribution"`
+	Published     bool            `json:"published"`
+	Tag           []mispTag       `json:"Tag,omitempty"`
+	Attribute     []mispAttribute `json:"Attribute"`
+}
+
+type mispEventDocument struct {
+	Event mispEventBody `json:"Event"`
+}
+
+func mispTags(names []string) []mispTag {
+	var ret []mispTag
+	for _, name := range names {
+		ret = append(ret, mispTag{Name: name})
+	}
+	return ret
+}
+
+// mispEvent returns a MISP event (in the form accepted by the events/add API
+// and by file import) with a network activity attribute for each finding's
+// target: ip-dst|port (or ip-dst, without a port) for addresses, and
+// hostname|port (or domain) for names. Attributes are not marked for IDS
+// export, since they describe exposed services rather than attackers. The
+// event is dated by its earliest finding.
+func mispEvent(config *MISPConfig, findings []*Finding) *mispEventDocument {
+	event := mispEventBody{
+		Info:          config.Info,
+		ThreatLevelID: strconv.Itoa(config.ThreatLevel),
+		// Initial analysis, visible to this organization only.
+		Analysis:     "0",
+		Distribution: "0",
+		Tag:          mispTags(config.Tags),
+		Attribute:    []mispAttribute{},
+	}
+	if event.Info == "" {
+		event.Info = "zgrab2 findings"
+	}
+	earliest := time.Now()
+	for _, finding := range findings {
+		if finding.Time.Before(earliest) {
+			earliest = finding.Time
+		}
+		comment := finding.Rule.Name
+		if finding.Rule.Description != "" {
+			comment += ": " + finding.Rule.Description
+		}
+		timestamp := strconv.FormatInt(finding.Time.Unix(), 10)
+		add := func(attributeType, value string) {
+			event.Attribute = append(event.Attribute, mispAttribute{
+				UUID:      uuid5(mispNamespace, strings.Join([]string{finding.Rule.Name, attributeType, value, timestamp}, "|")),
+				Type:      attributeType,
+				Category:  "Network activity",
+				Value:     value,
+				Comment:   comment,
+				Timestamp: timestamp,
+				Tag:       mispTags(finding.Rule.Tags),
+			})
+		}
+		port := strconv.FormatUint(uint64(finding.Port), 10)
+		switch {
+		case finding.IP != "" && finding.Port != 0:
+			add("ip-dst|port", finding.IP+"|"+port)
+		case finding.IP != "":
+			add("ip-dst", finding.IP)
+		}
+		switch {
+		case finding.Domain != "" && finding.Port != 0:
+			add("hostname|port", finding.Domain+"|"+port)
+		case finding.Domain != "":
+			add("domain", finding.Domain)
+		}
+	}
+	event.Date = earliest.UTC().Format("2006-01-02")
+	uuids := make([]string, 0, len(event.Attribute))
+	for _, attribute := range event.Attribute {
+		uuids = append(uuids, attribute.UUID)
+	}
+	sort.Strings(uuids)
+	event.UUID = uuid5(mispNamespace, event.Info+"|"+strings.Join(uuids, "|"))
+	return &mispEventDocument{Event: event}
+}
@@ -0,0 +1,166 @@
+package export
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+// stixNamespace is the UUIDv5 namespace the STIX 2.1 spec gives for the
+// deterministic IDs of cyber-observable objects.
+var stixNamespace = [16]byte{0x00, 0xab, 0xed, 0xb4, 0xaa, 0x42, 0x46, 0x6c, 0x9c, 0x01, 0xfe, 0xd2, 0x33, 0x15, 0xa9, 0xb7}
+
+// uuid5 returns the version 5 UUID of name in namespace.
+func uuid5(namespace [16]byte, name string) string {
+	h := sha1.New()
+	h.Write(namespace[:])
+	h.Write([]byte(name))
+	u := h.Sum(nil)[:16]
+	u[6] = (u[6] & 0x0f) | 0x50
+	u[8] = (u[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:16])
+}
+
+// stixID returns the deterministic ID of an object of the given type with the
+// given ID-contributing properties, which are hashed in their canonical JSON
+// form (encoding/json sorts map keys, and the values used here need no
+// further canonicalization).
+func stixID(objectType string, properties map[string]interface{}) string {
+	data, _ := json.Marshal(properties)
+	return objectType + "--" + uuid5(stixNamespace, string(data))
+}
+
+// stixTime formats a timestamp as STIX requires: UTC, with milliseconds.
+func stixTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05.000Z")
+}
+
+// stixObject is a STIX object, with its properties in JSON form.
+type stixObject map[string]interface{}
+
+// stixBuilder collects the objects of a bundle, without duplicates.
+type stixBuilder struct {
+	objects []stixObject
+	seen    map[string]bool
+}
+
+func (b *stixBuilder) add(object stixObject) string {
+	id := object["id"].(string)
+	if !b.seen[id] {
+		b.seen[id] = true
+		b.objects = append(b.objects, object)
+	}
+	return id
+}
+
+// observable adds a cyber-observable object with the given ID-contributing
+// properties, and any others, and returns its ID.
+func (b *stixBuilder) observable(objectType string, properties, others map[string]interface{}) string {
+	object := stixObject{
+		"type":         objectType,
+		"spec_version": "2.1",
+		"id":           stixID(objectType, properties),
+	}
+	for k, v := range properties {
+		object[k] = v
+	}
+	for k, v := range others {
+		object[k] = v
+	}
+	return b.add(object)
+}
+
+// addressType returns the STIX object type for an address.
+func addressType(address string) string {
+	if ip := net.ParseIP(address); ip != nil && ip.To4() == nil {
+		return "ipv6-addr"
+	}
+	return "ipv4-addr"
+}
+
+// stixBundle returns a STIX 2.1 bundle with an observed-data object for each
+// finding, referring to the target's address or domain name and, when the
+// port is known, the network traffic to it. Findings with a description also
+// get a note.
+func stixBundle(findings []*Finding) stixObject {
+	b := &stixBuilder{seen: make(map[string]bool)}
+	for _, finding := range findings {
+		var refs []string
+		var target string
+		if finding.IP != "" {
+			target = b.observable(addressType(finding.IP), map[string]interface{}{"value": finding.IP}, nil)
+			refs = append(refs, target)
+		}
+		if finding.Domain != "" {
+			var resolution map[string]interface{}
+			if target != "" {
+				resolution = map[string]interface{}{"resolves_to_refs": []string{target}}
+			}
+			domainID := b.observable("domain-name", map[string]interface{}{"value": finding.Domain}, resolution)
+			refs = append(refs, domainID)
+			if target == "" {
+				target = domainID
+			}
+		}
+		if finding.Port != 0 {
+			protocols := []string{finding.Transport}
+			if finding.Protocol != "" {
+				protocols = append(protocols, strings.ToLower(finding.Protocol))
+			}
+			refs = append(refs, b.observable("network-traffic", map[string]interface{}{
+				"dst_ref":   target,
+				"dst_port":  finding.Port,
+				"protocols": protocols,
+			}, nil))
+		}
+		observed := stixTime(finding.Time)
+		dataID := "observed-data--" + uuid5(stixNamespace, strings.Join(append([]string{finding.Rule.Name, observed}, refs...), "|"))
+		data := stixObject{
+			"type":              "observed-data",
+			"spec_version":      "2.1",
+			"id":                dataID,
+			"created":           observed,
+			"modified":          observed,
+			"first_observed":    observed,
+			"last_observed":     observed,
+			"number_observed":   1,
+			"object_refs":       refs,
+			"x_zgrab2_finding":  finding.Rule.Name,
+			"x_zgrab2_protocol": finding.Protocol,
+		}
+		if len(finding.Rule.Tags) > 0 {
+			data["labels"] = finding.Rule.Tags
+		}
+		b.add(data)
+		if finding.Rule.Description != "" {
+			b.add(stixObject{
+				"type":         "note",
+				"spec_version": "2.1",
+				"id":           "note--" + uuid5(stixNamespace, "note|"+dataID),
+				"created":      observed,
+				"modified":     observed,
+				"abstract":     finding.Rule.Name,
+				"content":      finding.Rule.Description,
+				"object_refs":  []string{dataID},
+			})
+		}
+	}
+	ids := make([]string, 0, len(b.objects))
+	for _, object := range b.objects {
+		ids = append(ids, object["id"].(string))
+	}
+	sort.Strings(ids)
+	objects := b.objects
+	if objects == nil {
+		objects = []stixObject{}
+	}
+	return stixObject{
+		"type":    "bundle",
+		"id":      "bundle--" + uuid5(stixNamespace, strings.Join(ids, "|")),
+		"objects": objects,
+	}
+}
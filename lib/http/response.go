@@ -14,6 +14,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/textproto"
 	"net/url"
 	"strconv"
@@ -61,6 +62,23 @@ type Response struct {
 	BodyText   string          `json:"body,omitempty"`
 	BodySHA256 PageFingerprint `json:"body_sha256,omitempty"`
 
+	// BodyMMH3 is the MurmurHash3 of the body, as a signed integer: the
+	// hash Shodan's http.html_hash filter matches.
+	BodyMMH3 *int32 `json:"body_mmh3,omitempty"`
+
+	// BodyFile is the path of the file the body was written to, relative
+	// to the directory bodies are stored in, in which case BodyText is
+	// empty.
+	BodyFile string `json:"body_file,omitempty"`
+
+	// EncodedLength is the number of body bytes read as received, and
+	// DecodedLength the number they decoded to, if the body was decoded from
+	// its Content-Encoding. DecodeError is the error decoding it, in which
+	// case BodyText is the body as received.
+	EncodedLength int64  `json:"encoded_length,omitempty"`
+	DecodedLength int64  `json:"decoded_length,omitempty"`
+	DecodeError   string `json:"decode_error,omitempty"`
+
 	// ContentLength records the length of the associated content. The
 	// value -1 indicates that the length is unknown. Unless Request.Method
 	// is "HEAD", values >= 0 indicate that the given number of bytes may
@@ -109,6 +127,23 @@ type Response struct {
 	// The pointer is shared between responses and should not be
 	// modified.
 	TLS *tls.ConnectionState `json:"-"`
+
+	// RemoteAddr is the address of the connection the response was
+	// received on. It is only populated for Client requests.
+	RemoteAddr net.Addr `json:"-"`
+
+	// InterimResponses are the informational (1xx) responses, such as
+	// "103 Early Hints", received before this one. This is only
+	// populated for Client requests.
+	InterimResponses []*InterimResponse `json:"interim_responses,omitempty"`
+}
+
+// InterimResponse is an informational (1xx) response received before the
+// final response to a request.
+type InterimResponse struct {
+	Status     string `json:"status_line,omitempty"` // e.g. "103 Early Hints"
+	StatusCode int    `json:"status_code,omitempty"` // e.g. 103
+	Header     Header `json:"headers,omitempty"`
 }
 
 // Hex returns the given fingerprint encoded as a hex string.
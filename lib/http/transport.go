@@ -1117,6 +1117,8 @@ func (t *Transport) dialConn(ctx context.Context, cm connectMethod) (*persistCon
 		pconn.conn = tlsConn
 	}
 
+	pconn.remoteAddr = connRemoteAddr(pconn.conn)
+
 	if s := pconn.tlsState; s != nil && s.NegotiatedProtocolIsMutual && s.NegotiatedProtocol != "" {
 		if next, ok := t.TLSNextProto[s.NegotiatedProtocol]; ok {
 			return &persistConn{alt: next(cm.targetAddr, pconn.conn.(*tls.Conn))}, nil
@@ -1130,6 +1132,21 @@ func (t *Transport) dialConn(ctx context.Context, cm connectMethod) (*persistCon
 	return pconn, nil
 }
 
+// connRemoteAddr returns conn's remote address, or nil if it has none.
+// Custom Dial functions may return wrappers that do not implement
+// RemoteAddr, so a panic is treated as no address.
+func connRemoteAddr(conn net.Conn) (addr net.Addr) {
+	if conn == nil {
+		return nil
+	}
+	defer func() {
+		if recover() != nil {
+			addr = nil
+		}
+	}()
+	return conn.RemoteAddr()
+}
+
 // persistConnWriter is the io.Writer written to by pc.bw.
 // It accumulates the number of bytes written to the underlying conn,
 // so the retry logic can determine whether any bytes made it across
@@ -1273,6 +1290,9 @@ type persistConn struct {
 	// If it's non-nil, the rest of the fields are unused.
 	alt RoundTripper
 
+	// remoteAddr is conn's remote address, captured when it was dialed.
+	remoteAddr net.Addr
+
 	t         *Transport
 	cacheKey  connectMethodKey
 	conn      net.Conn
@@ -1646,8 +1666,14 @@ func (pc *persistConn) readLoopPeekFailLocked(peekErr error) {
 	}
 }
 
-// readResponse reads an HTTP response (or two, in the case of "Expect:
-// 100-continue") from the server. It returns the final non-100 one.
+// max1xxResponses bounds the number of informational (1xx) responses read
+// before the final one.
+const max1xxResponses = 5
+
+// readResponse reads an HTTP response, after any informational (1xx)
+// responses (e.g. "100 Continue" or "103 Early Hints"), from the server. It
+// returns the final one, with the informational responses in its
+// InterimResponses. A 101 Switching Protocols is final.
 // trace is optional.
 func (pc *persistConn) readResponse(rc requestAndChan, trace *httptrace.ClientTrace) (resp *Response, err error) {
 	if trace != nil && trace.GotFirstResponseByte != nil {
@@ -1655,28 +1681,37 @@ func (pc *persistConn) readResponse(rc requestAndChan, trace *httptrace.ClientTr
 			trace.GotFirstResponseByte()
 		}
 	}
-	resp, err = ReadResponse(pc.br, rc.req)
-	if err != nil {
-		return
-	}
-	if rc.continueCh != nil {
-		if resp.StatusCode == 100 {
-			if trace != nil && trace.Got100Continue != nil {
-				trace.Got100Continue()
-			}
-			rc.continueCh <- struct{}{}
-		} else {
-			close(rc.continueCh)
-		}
-	}
-	if resp.StatusCode == 100 {
-		pc.readLimit = pc.maxHeaderResponseSize() // reset the limit
+	var interim []*InterimResponse
+	continueCh := rc.continueCh
+	for {
 		resp, err = ReadResponse(pc.br, rc.req)
 		if err != nil {
 			return
 		}
+		if continueCh != nil {
+			if resp.StatusCode == 100 {
+				if trace != nil && trace.Got100Continue != nil {
+					trace.Got100Continue()
+				}
+				continueCh <- struct{}{}
+				continueCh = nil
+			} else if resp.StatusCode >= 200 {
+				close(continueCh)
+				continueCh = nil
+			}
+		}
+		if resp.StatusCode < 100 || resp.StatusCode > 199 || resp.StatusCode == StatusSwitchingProtocols {
+			break
+		}
+		if len(interim) == max1xxResponses {
+			return nil, errors.New("net/http: too many 1xx informational responses")
+		}
+		interim = append(interim, &InterimResponse{Status: resp.Status, StatusCode: resp.StatusCode, Header: resp.Header})
+		pc.readLimit = pc.maxHeaderResponseSize() // reset the limit
 	}
+	resp.InterimResponses = interim
 	resp.TLS = pc.tlsState
+	resp.RemoteAddr = pc.remoteAddr
 	return
 }
 
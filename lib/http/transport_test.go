@@ -2100,7 +2100,7 @@ Content-Length: %d
 	c := MakeNewClient()
 	c.Transport = tr
 
-	testResponse := func(req *Request, name string, wantCode int) {
+	testResponse := func(req *Request, name string, wantCode int, wantInterim int) {
 		res, err := c.Do(req)
 		if err != nil {
 			t.Fatalf("%s: Do: %v", name, err)
@@ -2108,6 +2108,9 @@ Content-Length: %d
 		if res.StatusCode != wantCode {
 			t.Fatalf("%s: Response Statuscode=%d; want %d", name, res.StatusCode, wantCode)
 		}
+		if len(res.InterimResponses) != 1 || res.InterimResponses[0].StatusCode != wantInterim {
+			t.Errorf("%s: interim responses %+v; want one %d", name, res.InterimResponses, wantInterim)
+		}
 		if id, idBack := req.Header.Get("Request-Id"), res.Header.Get("Echo-Request-Id"); id != "" && id != idBack {
 			t.Errorf("%s: response id %q != request id %q", name, idBack, id)
 		}
@@ -2121,15 +2124,15 @@ Content-Length: %d
 	for i := 1; i <= numReqs; i++ {
 		req, _ := NewRequest("POST", "http://dummy.tld/", strings.NewReader(reqBody(i)))
 		req.Header.Set("Request-Id", reqID(i))
-		testResponse(req, fmt.Sprintf("100, %d/%d", i, numReqs), 200)
+		testResponse(req, fmt.Sprintf("100, %d/%d", i, numReqs), 200, 100)
 	}
 
 	// And some other informational 1xx but non-100 responses, to test
-	// we return them but don't re-use the connection.
+	// we skip them, recording them with the final response.
 	for i := 1; i <= numReqs; i++ {
 		req, _ := NewRequest("POST", "http://other.tld/", strings.NewReader(reqBody(i)))
 		req.Header.Set("X-Want-Response-Code", "123 Sesame Street")
-		testResponse(req, fmt.Sprintf("123, %d/%d", i, numReqs), 123)
+		testResponse(req, fmt.Sprintf("123, %d/%d", i, numReqs), 200, 123)
 	}
 }
 
@@ -3004,6 +3007,56 @@ func TestTransportFlushesBodyChunks(t *testing.T) {
 	}
 }
 
+// noAddrConn is a net.Conn wrapper whose RemoteAddr reports no address.
+type noAddrConn struct{ net.Conn }
+
+func (noAddrConn) RemoteAddr() net.Addr { return nil }
+
+// Responses carry the remote address captured when a custom Dial's
+// conn was established, including wrappers that report none.
+func TestTransportRemoteAddrWrappedConn(t *testing.T) {
+	defer afterTest(t)
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		io.WriteString(w, "ok")
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		name string
+		wrap func(net.Conn) net.Conn
+		want string
+	}{
+		{"embedded", func(c net.Conn) net.Conn { return struct{ net.Conn }{c} }, ts.Listener.Addr().String()},
+		{"no address", func(c net.Conn) net.Conn { return noAddrConn{c} }, ""},
+	}
+	for _, tt := range tests {
+		tr := &Transport{
+			Dial: func(network, addr string) (net.Conn, error) {
+				c, err := net.Dial(network, addr)
+				if err != nil {
+					return nil, err
+				}
+				return tt.wrap(c), nil
+			},
+		}
+		c := MakeNewClient()
+		c.Transport = tr
+		res, err := c.Get(ts.URL)
+		if err != nil {
+			t.Fatalf("%s: Get: %v", tt.name, err)
+		}
+		res.Body.Close()
+		got := ""
+		if res.RemoteAddr != nil {
+			got = res.RemoteAddr.String()
+		}
+		if got != tt.want {
+			t.Errorf("%s: RemoteAddr = %q; want %q", tt.name, got, tt.want)
+		}
+		tr.CloseIdleConnections()
+	}
+}
+
 // Issue 11745.
 func TestTransportPrefersResponseOverWriteError(t *testing.T) {
 	if testing.Short() {
@@ -0,0 +1,91 @@
+// Package jobqueue implements the queues that zgrab2 workers pull batches of
+// targets from, and push results to, when running as part of a distributed
+// scanning fleet: Redis lists and Amazon SQS queues.
+//
+// A job is a batch of targets, in zgrab2's input format, that is hidden from
+// other workers while it is being scanned. The worker keeps extending its
+// visibility timeout until all of its results have been pushed, and then
+// deletes it; a job whose worker dies reappears for another worker once its
+// visibility timeout expires.
+package jobqueue
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// Job is a batch of targets received from a Source.
+type Job struct {
+	// ID identifies the job in logs.
+	ID string
+
+	// Body is the batch of targets, one input line per target.
+	Body []byte
+
+	// handle identifies the received job to the source.
+	handle string
+}
+
+// Source is a queue of jobs.
+type Source interface {
+	// Receive waits up to wait for a job, and returns it, or nil if none
+	// arrived. The job is hidden from other workers for the visibility
+	// timeout.
+	Receive(wait, visibility time.Duration) (*Job, error)
+
+	// Extend hides the job from other workers for the visibility timeout
+	// from now.
+	Extend(job *Job, visibility time.Duration) error
+
+	// Delete removes a job that has been completed.
+	Delete(job *Job) error
+
+	// Release makes a job that will not be completed visible to other
+	// workers again immediately.
+	Release(job *Job) error
+}
+
+// Sink is a queue that results are pushed to.
+type Sink interface {
+	// Push adds results, each a JSON-encoded zgrab2 output line, to the
+	// queue.
+	Push(results [][]byte) error
+
+	// MaxBatch is the largest number of results that can be pushed at once.
+	MaxBatch() int
+}
+
+// OpenSource opens the job queue with the given URL: redis://host[:port][/db]
+// with an optional ?key= (default zgrab2:jobs), or the https:// URL of an SQS
+// queue.
+func OpenSource(rawURL string) (Source, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "redis":
+		return newRedisSource(u)
+	case "https", "http":
+		return newSQSSource(u)
+	}
+	return nil, fmt.Errorf("unsupported job queue %q: must be a redis:// or SQS https:// URL", rawURL)
+}
+
+// OpenSink opens the result queue with the given URL: redis://host[:port][/db]
+// with an optional ?key= (default zgrab2:results), or the https:// URL of an
+// SQS queue.
+func OpenSink(rawURL string) (Sink, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "redis":
+		return newRedisSink(u)
+	case "https", "http":
+		return newSQSSink(u)
+	}
+	return nil, fmt.Errorf("unsupported result queue %q: must be a redis:// or SQS https:// URL", rawURL)
+}
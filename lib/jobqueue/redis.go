@@ -0,0 +1,416 @@
+package jobqueue
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// redisDialTimeout bounds connecting to Redis, and each command other than
+// the blocking receive.
+const redisDialTimeout = 10 * time.Second
+
+// errJobLost is returned when extending a job that is no longer held, because
+// its visibility timeout expired and it was handed back to the queue.
+var errJobLost = errors.New("job visibility timeout expired before it could be extended")
+
+// redisError is an error reply from Redis.
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+// redisConn sends commands to Redis.
+type redisConn interface {
+	// do sends a command and returns its reply: a string, int64, []byte
+	// (nil for a null reply), []interface{} or redisError.
+	do(timeout time.Duration, args ...string) (interface{}, error)
+}
+
+// redisClient is a single connection to a Redis server, reconnected after
+// errors. It is safe for concurrent use, but commands are serialized.
+type redisClient struct {
+	address  string
+	password string
+	db       int
+
+	mutex  sync.Mutex
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// newRedisClient returns a client for the server in a redis:// URL.
+func newRedisClient(u *url.URL) (*redisClient, error) {
+	ret := &redisClient{address: u.Host}
+	if u.Port() == "" {
+		ret.address = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		// redis://:password@host, as used by most clients; a user name
+		// alone is taken as the password.
+		if password, ok := u.User.Password(); ok {
+			ret.password = password
+		} else {
+			ret.password = u.User.Username()
+		}
+	}
+	if db := strings.Trim(u.Path, "/"); db != "" {
+		var err error
+		if ret.db, err = strconv.Atoi(db); err != nil {
+			return nil, fmt.Errorf("invalid Redis database %q", db)
+		}
+	}
+	return ret, nil
+}
+
+func (c *redisClient) do(timeout time.Duration, args ...string) (interface{}, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.conn == nil {
+		if err := c.connect(); err != nil {
+			return nil, err
+		}
+	}
+	reply, err := c.roundTrip(timeout, args)
+	if err != nil {
+		c.conn.Close()
+		c.conn = nil
+		return nil, err
+	}
+	return reply, nil
+}
+
+// connect opens the connection, and authenticates and selects the database
+// if required.
+func (c *redisClient) connect() error {
+	conn, err := net.DialTimeout("tcp", c.address, redisDialTimeout)
+	if err != nil {
+		return err
+	}
+	c.conn, c.reader = conn, bufio.NewReader(conn)
+	var setup [][]string
+	if c.password != "" {
+		setup = append(setup, []string{"AUTH", c.password})
+	}
+	if c.db != 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(c.db)})
+	}
+	for _, args := range setup {
+		reply, err := c.roundTrip(redisDialTimeout, args)
+		if err == nil {
+			if e, ok := reply.(redisError); ok {
+				err = e
+			}
+		}
+		if err != nil {
+			conn.Close()
+			c.conn = nil
+			return fmt.Errorf("could not %s: %v", strings.ToLower(args[0]), err)
+		}
+	}
+	return nil
+}
+
+// roundTrip sends a command and reads its reply.
+func (c *redisClient) roundTrip(timeout time.Duration, args []string) (interface{}, error) {
+	if err := c.conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, err
+	}
+	if _, err := c.conn.Write(encodeRedisCommand(args)); err != nil {
+		return nil, err
+	}
+	return readRedisReply(c.reader)
+}
+
+// encodeRedisCommand returns a command in the RESP protocol.
+func encodeRedisCommand(args []string) []byte {
+	buf := []byte("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, arg := range args {
+		buf = append(buf, '$')
+		buf = strconv.AppendInt(buf, int64(len(arg)), 10)
+		buf = append(buf, "\r\n"...)
+		buf = append(buf, arg...)
+		buf = append(buf, "\r\n"...)
+	}
+	return buf
+}
+
+// readRedisReply reads a single RESP reply.
+func readRedisReply(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || !strings.HasSuffix(line, "\r\n") {
+		return nil, fmt.Errorf("invalid Redis reply %q", line)
+	}
+	kind, line := line[0], line[1:len(line)-2]
+	switch kind {
+	case '+':
+		return line, nil
+	case '-':
+		return redisError(line), nil
+	case ':':
+		return strconv.ParseInt(line, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line)
+		if err != nil || n < 0 {
+			return []byte(nil), err
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	case '*':
+		n, err := strconv.Atoi(line)
+		if err != nil || n < 0 {
+			return []interface{}(nil), err
+		}
+		ret := make([]interface{}, n)
+		for i := range ret {
+			if ret[i], err = readRedisReply(r); err != nil {
+				return nil, err
+			}
+		}
+		return ret, nil
+	}
+	return nil, fmt.Errorf("invalid Redis reply type %q", kind)
+}
+
+// redisCommand sends a command and returns its reply, or the error replied.
+func redisCommand(conn redisConn, timeout time.Duration, args ...string) (interface{}, error) {
+	reply, err := conn.do(timeout, args...)
+	if err != nil {
+		return nil, err
+	}
+	if e, ok := reply.(redisError); ok {
+		return nil, e
+	}
+	return reply, nil
+}
+
+// redisInt sends a command with an integer reply.
+func redisInt(conn redisConn, args ...string) (int64, error) {
+	reply, err := redisCommand(conn, redisDialTimeout, args...)
+	if err != nil {
+		return 0, err
+	}
+	n, ok := reply.(int64)
+	if !ok {
+		return 0, fmt.Errorf("unexpected reply to %s: %v", args[0], reply)
+	}
+	return n, nil
+}
+
+// redisKey returns the ?key= of a redis:// URL, or def.
+func redisKey(u *url.URL, def string) string {
+	if key := u.Query().Get("key"); key != "" {
+		return key
+	}
+	return def
+}
+
+// redisSource is a job queue held in Redis. Producers LPUSH jobs onto the
+// list at key. A worker atomically moves each job it receives to the list at
+// key:processing with BRPOPLPUSH, and records when its visibility timeout
+// expires in the sorted set at key:deadlines. Jobs whose deadline has passed
+// are moved back onto the queue by whichever worker next receives.
+//
+// Jobs are identified by their content, so identical jobs queued at the same
+// time may be confused with each other; this is harmless, as any one of them
+// stands for all of them.
+type redisSource struct {
+	key          string
+	processing   string
+	deadlines    string
+	blocking     redisConn
+	conn         redisConn
+	now          func() time.Time
+	reapMutex    sync.Mutex
+	lastReaped   time.Time
+	lastOrphaned time.Time
+}
+
+func newRedisSource(u *url.URL) (*redisSource, error) {
+	blocking, err := newRedisClient(u)
+	if err != nil {
+		return nil, err
+	}
+	// The blocking receive has its own connection, so that it does not hold
+	// up extending the visibility of jobs in flight.
+	conn, err := newRedisClient(u)
+	if err != nil {
+		return nil, err
+	}
+	return newRedisSourceWith(redisKey(u, "zgrab2:jobs"), blocking, conn), nil
+}
+
+func newRedisSourceWith(key string, blocking, conn redisConn) *redisSource {
+	return &redisSource{
+		key:        key,
+		processing: key + ":processing",
+		deadlines:  key + ":deadlines",
+		blocking:   blocking,
+		conn:       conn,
+		now:        time.Now,
+	}
+}
+
+// deadline returns the deadline score of a job hidden for visibility from
+// now, in milliseconds since the epoch.
+func (s *redisSource) deadline(visibility time.Duration) string {
+	return strconv.FormatInt(s.now().Add(visibility).UnixNano()/int64(time.Millisecond), 10)
+}
+
+func (s *redisSource) Receive(wait, visibility time.Duration) (*Job, error) {
+	if err := s.reap(visibility); err != nil {
+		return nil, err
+	}
+	// BRPOPLPUSH takes whole seconds, and 0 waits forever.
+	seconds := int64((wait + time.Second - 1) / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	reply, err := redisCommand(s.blocking, wait+redisDialTimeout, "BRPOPLPUSH", s.key, s.processing, strconv.FormatInt(seconds, 10))
+	if err != nil {
+		return nil, err
+	}
+	body, ok := reply.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("unexpected reply to BRPOPLPUSH: %v", reply)
+	}
+	if body == nil {
+		return nil, nil
+	}
+	handle := string(body)
+	if _, err := redisInt(s.conn, "ZADD", s.deadlines, s.deadline(visibility), handle); err != nil {
+		// The job has no deadline, so it will be recovered as an orphan.
+		return nil, err
+	}
+	sum := sha1.Sum(body)
+	return &Job{ID: hex.EncodeToString(sum[:8]), Body: body, handle: handle}, nil
+}
+
+// reap moves jobs whose visibility timeout has expired back onto the queue,
+// at most once a second. Jobs being processed without a deadline, left by a
+// worker that stopped between receiving a job and recording its deadline,
+// are given one once per visibility timeout.
+func (s *redisSource) reap(visibility time.Duration) error {
+	s.reapMutex.Lock()
+	defer s.reapMutex.Unlock()
+	now := s.now()
+	if now.Sub(s.lastReaped) < time.Second {
+		return nil
+	}
+	s.lastReaped = now
+	if now.Sub(s.lastOrphaned) >= visibility {
+		reply, err := redisCommand(s.conn, redisDialTimeout, "LRANGE", s.processing, "0", "-1")
+		if err != nil {
+			return err
+		}
+		items, _ := reply.([]interface{})
+		for _, item := range items {
+			if handle, ok := item.([]byte); ok {
+				if _, err := redisInt(s.conn, "ZADD", s.deadlines, "NX", s.deadline(visibility), string(handle)); err != nil {
+					return err
+				}
+			}
+		}
+		s.lastOrphaned = now
+	}
+	reply, err := redisCommand(s.conn, redisDialTimeout, "ZRANGEBYSCORE", s.deadlines, "-inf", strconv.FormatInt(now.UnixNano()/int64(time.Millisecond), 10))
+	if err != nil {
+		return err
+	}
+	expired, _ := reply.([]interface{})
+	for _, item := range expired {
+		if handle, ok := item.([]byte); ok {
+			if err := s.requeue(string(handle)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// requeue moves a job being processed back onto the queue, to be received
+// next. Only the worker whose ZREM succeeds moves it, so that concurrent
+// reapers do not duplicate it.
+func (s *redisSource) requeue(handle string) error {
+	removed, err := redisInt(s.conn, "ZREM", s.deadlines, handle)
+	if err != nil || removed == 0 {
+		return err
+	}
+	removed, err = redisInt(s.conn, "LREM", s.processing, "1", handle)
+	if err != nil || removed == 0 {
+		return err
+	}
+	_, err = redisInt(s.conn, "RPUSH", s.key, handle)
+	return err
+}
+
+func (s *redisSource) Extend(job *Job, visibility time.Duration) error {
+	changed, err := redisInt(s.conn, "ZADD", s.deadlines, "XX", "CH", s.deadline(visibility), job.handle)
+	if err != nil {
+		return err
+	}
+	if changed == 0 {
+		return errJobLost
+	}
+	return nil
+}
+
+func (s *redisSource) Delete(job *Job) error {
+	if _, err := redisInt(s.conn, "ZREM", s.deadlines, job.handle); err != nil {
+		return err
+	}
+	_, err := redisInt(s.conn, "LREM", s.processing, "1", job.handle)
+	return err
+}
+
+func (s *redisSource) Release(job *Job) error {
+	return s.requeue(job.handle)
+}
+
+// redisSinkBatch is the number of results pushed with each RPUSH.
+const redisSinkBatch = 1000
+
+// redisSink pushes results onto the tail of the list at key, to be taken
+// from the head by consumers.
+type redisSink struct {
+	key  string
+	conn redisConn
+}
+
+func newRedisSink(u *url.URL) (*redisSink, error) {
+	conn, err := newRedisClient(u)
+	if err != nil {
+		return nil, err
+	}
+	return &redisSink{key: redisKey(u, "zgrab2:results"), conn: conn}, nil
+}
+
+func (s *redisSink) Push(results [][]byte) error {
+	args := make([]string, 0, len(results)+2)
+	args = append(args, "RPUSH", s.key)
+	for _, result := range results {
+		args = append(args, string(result))
+	}
+	_, err := redisInt(s.conn, args...)
+	return err
+}
+
+func (s *redisSink) MaxBatch() int {
+	return redisSinkBatch
+}
@@ -0,0 +1,236 @@
+package jobqueue
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"net/url"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// fakeRedis implements the commands used by the Redis queues in memory.
+type fakeRedis struct {
+	lists map[string][]string
+	zsets map[string]map[string]float64
+}
+
+func newFakeRedis() *fakeRedis {
+	return &fakeRedis{lists: make(map[string][]string), zsets: make(map[string]map[string]float64)}
+}
+
+func (r *fakeRedis) zset(key string) map[string]float64 {
+	if r.zsets[key] == nil {
+		r.zsets[key] = make(map[string]float64)
+	}
+	return r.zsets[key]
+}
+
+func (r *fakeRedis) do(timeout time.Duration, args ...string) (interface{}, error) {
+	switch args[0] {
+	case "LPUSH":
+		r.lists[args[1]] = append(append([]string(nil), args[2:]...), r.lists[args[1]]...)
+		return int64(len(r.lists[args[1]])), nil
+	case "RPUSH":
+		r.lists[args[1]] = append(r.lists[args[1]], args[2:]...)
+		return int64(len(r.lists[args[1]])), nil
+	case "BRPOPLPUSH":
+		list := r.lists[args[1]]
+		if len(list) == 0 {
+			return []byte(nil), nil
+		}
+		item := list[len(list)-1]
+		r.lists[args[1]] = list[:len(list)-1]
+		r.lists[args[2]] = append([]string{item}, r.lists[args[2]]...)
+		return []byte(item), nil
+	case "LRANGE":
+		var ret []interface{}
+		for _, item := range r.lists[args[1]] {
+			ret = append(ret, []byte(item))
+		}
+		return ret, nil
+	case "LREM":
+		list := r.lists[args[1]]
+		for i, item := range list {
+			if item == args[3] {
+				r.lists[args[1]] = append(list[:i:i], list[i+1:]...)
+				return int64(1), nil
+			}
+		}
+		return int64(0), nil
+	case "ZADD":
+		zset := r.zset(args[1])
+		var nx, xx, ch bool
+		i := 2
+		for ; args[i] == "NX" || args[i] == "XX" || args[i] == "CH"; i++ {
+			nx, xx, ch = nx || args[i] == "NX", xx || args[i] == "XX", ch || args[i] == "CH"
+		}
+		score, _ := strconv.ParseFloat(args[i], 64)
+		old, exists := zset[args[i+1]]
+		if exists && nx || !exists && xx {
+			return int64(0), nil
+		}
+		zset[args[i+1]] = score
+		if !exists || ch && old != score {
+			return int64(1), nil
+		}
+		return int64(0), nil
+	case "ZREM":
+		zset := r.zset(args[1])
+		if _, ok := zset[args[2]]; ok {
+			delete(zset, args[2])
+			return int64(1), nil
+		}
+		return int64(0), nil
+	case "ZRANGEBYSCORE":
+		max, _ := strconv.ParseFloat(args[3], 64)
+		var ret []interface{}
+		for member, score := range r.zset(args[1]) {
+			if score <= max {
+				ret = append(ret, []byte(member))
+			}
+		}
+		return ret, nil
+	}
+	return redisError("ERR unknown command " + args[0]), nil
+}
+
+func TestRedisSource(t *testing.T) {
+	redis := newFakeRedis()
+	now := time.Unix(1000000, 0)
+	source := newRedisSourceWith("jobs", redis, redis)
+	source.now = func() time.Time { return now }
+	redis.do(0, "LPUSH", "jobs", "10.0.0.1\n10.0.0.2\n")
+	redis.do(0, "LPUSH", "jobs", "10.0.0.3\n")
+	// Left by a worker that stopped before recording the deadline.
+	redis.do(0, "LPUSH", "jobs:processing", "orphan\n")
+
+	job, err := source.Receive(time.Second, time.Minute)
+	if err != nil || job == nil || string(job.Body) != "10.0.0.1\n10.0.0.2\n" {
+		t.Fatalf("expected the first job, got %+v (%v)", job, err)
+	}
+	if score := redis.zsets["jobs:deadlines"][job.handle]; score != float64(now.Add(time.Minute).Unix()*1000) {
+		t.Errorf("wrong deadline %v", score)
+	}
+	if _, ok := redis.zsets["jobs:deadlines"]["orphan\n"]; !ok {
+		t.Error("orphaned job not given a deadline")
+	}
+
+	// Extending pushes the deadline back, so that only the orphan expires.
+	now = now.Add(50 * time.Second)
+	if err := source.Extend(job, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(20 * time.Second)
+	second, err := source.Receive(time.Second, time.Minute)
+	if err != nil || second == nil || string(second.Body) != "orphan\n" {
+		t.Fatalf("expected the expired orphan, got %+v (%v)", second, err)
+	}
+	if err := source.Release(second); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(redis.lists["jobs"], []string{"10.0.0.3\n", "orphan\n"}) {
+		t.Errorf("released job not requeued: %q", redis.lists["jobs"])
+	}
+	if err := source.Delete(job); err != nil {
+		t.Fatal(err)
+	}
+	if len(redis.lists["jobs:processing"]) != 0 || len(redis.zsets["jobs:deadlines"]) != 0 {
+		t.Errorf("jobs left in flight: %q, %v", redis.lists["jobs:processing"], redis.zsets["jobs:deadlines"])
+	}
+	if err := source.Extend(job, time.Minute); err != errJobLost {
+		t.Errorf("expected a deleted job to be lost, got %v", err)
+	}
+
+	redis.lists["jobs"] = nil
+	if job, err := source.Receive(time.Second, time.Minute); job != nil || err != nil {
+		t.Errorf("expected no job, got %+v (%v)", job, err)
+	}
+}
+
+func TestRedisSink(t *testing.T) {
+	redis := newFakeRedis()
+	sink := &redisSink{key: "results", conn: redis}
+	if err := sink.Push([][]byte{[]byte(`{"ip":"10.0.0.1"}`), []byte(`{"ip":"10.0.0.2"}`)}); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(redis.lists["results"], []string{`{"ip":"10.0.0.1"}`, `{"ip":"10.0.0.2"}`}) {
+		t.Errorf("wrong results %q", redis.lists["results"])
+	}
+}
+
+func TestRedisProtocol(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	commands := make(chan []string, 4)
+	go func() {
+		server, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer server.Close()
+		r := bufio.NewReader(server)
+		for _, reply := range []string{"+OK\r\n", "+OK\r\n", "*2\r\n$1\r\na\r\n$-1\r\n", "-ERR wrong\r\n"} {
+			command, err := readRedisReply(r)
+			if err != nil {
+				return
+			}
+			var args []string
+			for _, arg := range command.([]interface{}) {
+				args = append(args, string(arg.([]byte)))
+			}
+			commands <- args
+			server.Write([]byte(reply))
+		}
+	}()
+	u, _ := url.Parse("redis://:secret@" + listener.Addr().String() + "/3")
+	c, err := newRedisClient(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply, err := c.do(time.Second, "LRANGE", "key", "0", "-1")
+	if err != nil || !reflect.DeepEqual(reply, []interface{}{[]byte("a"), []byte(nil)}) {
+		t.Errorf("wrong reply %#v (%v)", reply, err)
+	}
+	if _, err := redisCommand(c, time.Second, "PING"); err == nil || err.Error() != "redis: ERR wrong" {
+		t.Errorf("expected an error reply, got %v", err)
+	}
+	for _, expected := range [][]string{{"AUTH", "secret"}, {"SELECT", "3"}, {"LRANGE", "key", "0", "-1"}, {"PING"}} {
+		if args := <-commands; !reflect.DeepEqual(args, expected) {
+			t.Errorf("expected %q, got %q", expected, args)
+		}
+	}
+}
+
+func TestNewRedisClient(t *testing.T) {
+	for _, test := range []struct {
+		url, address, password string
+		db                     int
+	}{
+		{"redis://example.com", "example.com:6379", "", 0},
+		{"redis://:secret@example.com:7000/2?key=x", "example.com:7000", "secret", 2},
+		{"redis://secret@[::1]", "[::1]:6379", "secret", 0},
+	} {
+		u, _ := url.Parse(test.url)
+		c, err := newRedisClient(u)
+		if err != nil || c.address != test.address || c.password != test.password || c.db != test.db {
+			t.Errorf("%s: got %+v (%v)", test.url, c, err)
+		}
+	}
+	u, _ := url.Parse("redis://example.com/x")
+	if _, err := newRedisClient(u); err == nil {
+		t.Error("expected an error for an invalid database")
+	}
+}
+
+func TestEncodeRedisCommand(t *testing.T) {
+	expected := "*3\r\n$5\r\nRPUSH\r\n$1\r\nk\r\n$0\r\n\r\n"
+	if encoded := encodeRedisCommand([]string{"RPUSH", "k", ""}); !bytes.Equal(encoded, []byte(expected)) {
+		t.Errorf("expected %q, got %q", expected, encoded)
+	}
+}
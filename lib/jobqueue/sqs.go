@@ -0,0 +1,261 @@
+package jobqueue
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sqsMaxWait is the longest long poll SQS allows.
+const sqsMaxWait = 20 * time.Second
+
+// sqsMaxVisibility is the longest visibility timeout SQS allows.
+const sqsMaxVisibility = 12 * time.Hour
+
+// sqsMaxBatch and sqsMaxBatchSize are the most messages, and bytes of
+// messages, that SendMessageBatch takes.
+const (
+	sqsMaxBatch     = 10
+	sqsMaxBatchSize = 256 * 1024
+)
+
+// sqsQueue is an Amazon SQS queue, used through the query API with requests
+// posted to the queue URL.
+type sqsQueue struct {
+	url    string
+	region string
+	creds  *awsCredentials
+	client *http.Client
+	now    func() time.Time
+}
+
+func newSQSQueue(u *url.URL) (*sqsQueue, error) {
+	creds, err := awsCredentialsFromEnvironment()
+	if err != nil {
+		return nil, err
+	}
+	ret := &sqsQueue{
+		url:   u.String(),
+		creds: creds,
+		// Long polls take up to sqsMaxWait.
+		client: &http.Client{Timeout: sqsMaxWait + 30*time.Second},
+		now:    time.Now,
+	}
+	// Queue URLs look like https://sqs.<region>.amazonaws.com/<account>/<name>
+	// (or the legacy https://<region>.queue.amazonaws.com/...).
+	labels := strings.Split(u.Hostname(), ".")
+	switch {
+	case len(labels) > 2 && labels[0] == "sqs":
+		ret.region = labels[1]
+	case len(labels) > 2 && labels[1] == "queue":
+		ret.region = labels[0]
+	default:
+		ret.region = os.Getenv("AWS_REGION")
+		if ret.region == "" {
+			ret.region = os.Getenv("AWS_DEFAULT_REGION")
+		}
+	}
+	if ret.region == "" {
+		return nil, fmt.Errorf("cannot tell the region of SQS queue %s: set AWS_REGION", u)
+	}
+	return ret, nil
+}
+
+// sqsError is the error response to an SQS request.
+type sqsError struct {
+	Code    string `xml:"Error>Code"`
+	Message string `xml:"Error>Message"`
+}
+
+// call performs an SQS action with the given parameters, and decodes the
+// response into result, if not nil.
+func (q *sqsQueue) call(action string, params url.Values, result interface{}) error {
+	params.Set("Action", action)
+	params.Set("Version", "2012-11-05")
+	body := []byte(params.Encode())
+	req, err := http.NewRequest("POST", q.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	signV4(req, body, q.creds, q.region, "sqs", q.now())
+	resp, err := q.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var e sqsError
+		if xml.Unmarshal(data, &e) == nil && e.Code != "" {
+			return fmt.Errorf("SQS %s: %s: %s", action, e.Code, e.Message)
+		}
+		return fmt.Errorf("SQS %s: %s", action, resp.Status)
+	}
+	if result == nil {
+		return nil
+	}
+	return xml.Unmarshal(data, result)
+}
+
+// sqsSeconds returns d in whole seconds, rounded up, for SQS parameters.
+func sqsSeconds(d time.Duration) string {
+	return strconv.FormatInt(int64((d+time.Second-1)/time.Second), 10)
+}
+
+// sqsSource receives jobs from an SQS queue, whose own visibility timeout
+// hides them from other workers.
+type sqsSource struct {
+	*sqsQueue
+}
+
+func newSQSSource(u *url.URL) (*sqsSource, error) {
+	q, err := newSQSQueue(u)
+	if err != nil {
+		return nil, err
+	}
+	return &sqsSource{q}, nil
+}
+
+func (s *sqsSource) Receive(wait, visibility time.Duration) (*Job, error) {
+	if wait > sqsMaxWait {
+		wait = sqsMaxWait
+	}
+	if visibility > sqsMaxVisibility {
+		visibility = sqsMaxVisibility
+	}
+	params := url.Values{}
+	params.Set("MaxNumberOfMessages", "1")
+	params.Set("WaitTimeSeconds", sqsSeconds(wait))
+	params.Set("VisibilityTimeout", sqsSeconds(visibility))
+	var resp struct {
+		Messages []struct {
+			MessageID     string `xml:"MessageId"`
+			ReceiptHandle string `xml:"ReceiptHandle"`
+			Body          string `xml:"Body"`
+		} `xml:"ReceiveMessageResult>Message"`
+	}
+	if err := s.call("ReceiveMessage", params, &resp); err != nil {
+		return nil, err
+	}
+	if len(resp.Messages) == 0 {
+		return nil, nil
+	}
+	m := resp.Messages[0]
+	return &Job{ID: m.MessageID, Body: []byte(m.Body), handle: m.ReceiptHandle}, nil
+}
+
+func (s *sqsSource) Extend(job *Job, visibility time.Duration) error {
+	if visibility > sqsMaxVisibility {
+		visibility = sqsMaxVisibility
+	}
+	return s.changeVisibility(job, sqsSeconds(visibility))
+}
+
+func (s *sqsSource) changeVisibility(job *Job, seconds string) error {
+	params := url.Values{}
+	params.Set("ReceiptHandle", job.handle)
+	params.Set("VisibilityTimeout", seconds)
+	return s.call("ChangeMessageVisibility", params, nil)
+}
+
+func (s *sqsSource) Delete(job *Job) error {
+	params := url.Values{}
+	params.Set("ReceiptHandle", job.handle)
+	return s.call("DeleteMessage", params, nil)
+}
+
+func (s *sqsSource) Release(job *Job) error {
+	return s.changeVisibility(job, "0")
+}
+
+// sqsSink sends each result as a message to an SQS queue.
+type sqsSink struct {
+	*sqsQueue
+}
+
+func newSQSSink(u *url.URL) (*sqsSink, error) {
+	q, err := newSQSQueue(u)
+	if err != nil {
+		return nil, err
+	}
+	return &sqsSink{q}, nil
+}
+
+// sqsSendAttempts is the number of times results that SQS failed to queue
+// are sent.
+const sqsSendAttempts = 3
+
+// Push sends the results in as few batches as SQS's limits allow; a result
+// larger than a message can be is an error.
+func (s *sqsSink) Push(results [][]byte) error {
+	for len(results) > 0 {
+		n, size := 0, 0
+		for n < len(results) && n < sqsMaxBatch && size+len(results[n]) <= sqsMaxBatchSize {
+			size += len(results[n])
+			n++
+		}
+		if n == 0 {
+			return fmt.Errorf("result of %d bytes is too large for an SQS message", len(results[0]))
+		}
+		if err := s.sendBatch(results[:n]); err != nil {
+			return err
+		}
+		results = results[n:]
+	}
+	return nil
+}
+
+// sendBatch sends a batch, sending the results SQS failed to queue again, so
+// that the batch is not sent again as a whole, duplicating results.
+func (s *sqsSink) sendBatch(batch [][]byte) error {
+	var err error
+	for attempt := 0; attempt < sqsSendAttempts && len(batch) > 0; attempt++ {
+		if batch, err = s.send(batch); err != nil {
+			return err
+		}
+	}
+	if len(batch) > 0 {
+		return fmt.Errorf("SQS could not queue %d results after %d attempts", len(batch), sqsSendAttempts)
+	}
+	return nil
+}
+
+// send sends a batch of results, and returns those that SQS failed to queue.
+func (s *sqsSink) send(batch [][]byte) ([][]byte, error) {
+	params := url.Values{}
+	for i, result := range batch {
+		prefix := "SendMessageBatchRequestEntry." + strconv.Itoa(i+1) + "."
+		params.Set(prefix+"Id", strconv.Itoa(i))
+		params.Set(prefix+"MessageBody", string(result))
+	}
+	var resp struct {
+		Failed []struct {
+			ID string `xml:"Id"`
+		} `xml:"SendMessageBatchResult>BatchResultErrorEntry"`
+	}
+	if err := s.call("SendMessageBatch", params, &resp); err != nil {
+		return nil, err
+	}
+	var failed [][]byte
+	for _, f := range resp.Failed {
+		if i, err := strconv.Atoi(f.ID); err == nil && i >= 0 && i < len(batch) {
+			failed = append(failed, batch[i])
+		}
+	}
+	return failed, nil
+}
+
+func (s *sqsSink) MaxBatch() int {
+	return sqsMaxBatch
+}
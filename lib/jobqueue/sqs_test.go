@@ -0,0 +1,157 @@
+package jobqueue
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The get-vanilla case of the AWS Signature Version 4 test suite.
+func TestSignV4(t *testing.T) {
+	req, _ := http.NewRequest("GET", "https://example.amazonaws.com/", nil)
+	creds := &awsCredentials{accessKeyID: "AKIDEXAMPLE", secretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}
+	now, _ := time.Parse("20060102T150405Z", "20150830T123600Z")
+	signV4(req, nil, creds, "us-east-1", "service", now)
+	expected := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"
+	if auth := req.Header.Get("Authorization"); auth != expected {
+		t.Errorf("expected %s, got %s", expected, auth)
+	}
+}
+
+func TestCanonicalQuery(t *testing.T) {
+	query, _ := url.ParseQuery("b=2&a=x y&a=1&c=%2F~")
+	if q := canonicalQuery(query); q != "a=1&a=x%20y&b=2&c=%2F~" {
+		t.Errorf("wrong canonical query %s", q)
+	}
+}
+
+// sqsTestServer answers SQS actions with canned responses, recording the
+// requests.
+func sqsTestServer(t *testing.T, responses map[string]string) (*httptest.Server, *[]url.Values) {
+	var requests []url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/") || !strings.Contains(r.Header.Get("Authorization"), "/eu-west-1/sqs/") {
+			t.Errorf("request not signed: %v", r.Header)
+		}
+		if err := r.ParseForm(); err != nil {
+			t.Error(err)
+		}
+		requests = append(requests, r.PostForm)
+		response, ok := responses[r.PostForm.Get("Action")]
+		if !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `<ErrorResponse><Error><Code>InvalidAction</Code><Message>no</Message></Error></ErrorResponse>`)
+			return
+		}
+		fmt.Fprint(w, response)
+	}))
+	return server, &requests
+}
+
+func withSQSEnvironment() func() {
+	saved := map[string]string{}
+	for name, value := range map[string]string{"AWS_ACCESS_KEY_ID": "AKID", "AWS_SECRET_ACCESS_KEY": "secret", "AWS_SESSION_TOKEN": "", "AWS_REGION": "eu-west-1"} {
+		saved[name] = os.Getenv(name)
+		os.Setenv(name, value)
+	}
+	return func() {
+		for name, value := range saved {
+			os.Setenv(name, value)
+		}
+	}
+}
+
+func TestSQSSource(t *testing.T) {
+	defer withSQSEnvironment()()
+	server, requests := sqsTestServer(t, map[string]string{
+		"ReceiveMessage":          `<ReceiveMessageResponse><ReceiveMessageResult><Message><MessageId>m1</MessageId><ReceiptHandle>r1</ReceiptHandle><Body>10.0.0.1&#xA;</Body></Message></ReceiveMessageResult></ReceiveMessageResponse>`,
+		"ChangeMessageVisibility": `<ChangeMessageVisibilityResponse/>`,
+	})
+	defer server.Close()
+	source, err := OpenSource(server.URL + "/123456789012/jobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	job, err := source.Receive(time.Minute, 90*time.Second)
+	if err != nil || job == nil || job.ID != "m1" || string(job.Body) != "10.0.0.1\n" {
+		t.Fatalf("wrong job %+v (%v)", job, err)
+	}
+	if err := source.Extend(job, 1500*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if err := source.Release(job); err != nil {
+		t.Fatal(err)
+	}
+	if err := source.Delete(job); err == nil || !strings.Contains(err.Error(), "InvalidAction") {
+		t.Errorf("expected the error response, got %v", err)
+	}
+	for i, expected := range []map[string]string{
+		{"Action": "ReceiveMessage", "WaitTimeSeconds": "20", "VisibilityTimeout": "90"},
+		{"Action": "ChangeMessageVisibility", "ReceiptHandle": "r1", "VisibilityTimeout": "2"},
+		{"Action": "ChangeMessageVisibility", "ReceiptHandle": "r1", "VisibilityTimeout": "0"},
+		{"Action": "DeleteMessage", "ReceiptHandle": "r1"},
+	} {
+		for name, value := range expected {
+			if got := (*requests)[i].Get(name); got != value {
+				t.Errorf("request %d: expected %s=%s, got %s", i, name, value, got)
+			}
+		}
+	}
+}
+
+func TestSQSSink(t *testing.T) {
+	defer withSQSEnvironment()()
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		attempts++
+		if attempts == 1 {
+			// The second entry fails the first time.
+			fmt.Fprint(w, `<SendMessageBatchResponse><SendMessageBatchResult><BatchResultErrorEntry><Id>1</Id><Code>InternalError</Code></BatchResultErrorEntry></SendMessageBatchResult></SendMessageBatchResponse>`)
+			return
+		}
+		if attempts == 2 && (r.PostForm.Get("SendMessageBatchRequestEntry.1.MessageBody") != "b" || r.PostForm.Get("SendMessageBatchRequestEntry.2.Id") != "") {
+			t.Errorf("expected only the failed entry to be sent again, got %v", r.PostForm)
+		}
+		fmt.Fprint(w, `<SendMessageBatchResponse><SendMessageBatchResult/></SendMessageBatchResponse>`)
+	}))
+	defer server.Close()
+	sink, err := OpenSink(server.URL + "/123456789012/results")
+	if err != nil {
+		t.Fatal(err)
+	}
+	results := [][]byte{[]byte("a"), []byte("b")}
+	for i := 0; i < sqsMaxBatch; i++ {
+		results = append(results, []byte("c"))
+	}
+	if err := sink.Push(results); err != nil {
+		t.Fatal(err)
+	}
+	// One batch of ten, the failed entry, and the remaining two.
+	if attempts != 3 {
+		t.Errorf("expected 3 requests, got %d", attempts)
+	}
+	if err := sink.Push([][]byte{make([]byte, sqsMaxBatchSize+1)}); err == nil {
+		t.Error("expected an error for an oversized result")
+	}
+}
+
+func TestSQSRegion(t *testing.T) {
+	defer withSQSEnvironment()()
+	for rawURL, region := range map[string]string{
+		"https://sqs.us-east-2.amazonaws.com/123456789012/jobs":    "us-east-2",
+		"https://ap-south-1.queue.amazonaws.com/123456789012/jobs": "ap-south-1",
+		"http://localhost:9324/queue/jobs":                         "eu-west-1",
+	} {
+		u, _ := url.Parse(rawURL)
+		q, err := newSQSQueue(u)
+		if err != nil || q.region != region {
+			t.Errorf("%s: expected region %s, got %+v (%v)", rawURL, region, q, err)
+		}
+	}
+}
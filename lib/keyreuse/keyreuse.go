@@ -0,0 +1,267 @@
+// Package keyreuse finds the targets in zgrab2 output that share TLS public
+// keys or SSH host keys, such as devices shipped with the same default key.
+//
+// Output is read twice: once to count the targets presenting each key, and
+// again to report the targets whose keys were seen more than once. Only a
+// 64-bit hash and a count are kept for each unique key, so the memory used
+// does not depend on the number of targets.
+package keyreuse
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"sort"
+	"strconv"
+
+	"github.com/zmap/zgrab2/lib/output"
+)
+
+// Key types.
+const (
+	TypeTLS = "tls"
+	TypeSSH = "ssh"
+)
+
+// Key is a public key presented by a target.
+type Key struct {
+	// Type is TypeTLS for the public key of a TLS server's certificate, or
+	// TypeSSH for an SSH host key.
+	Type string
+
+	// Fingerprint is the hex SHA-256 fingerprint zgrab2 gives the key: of
+	// the certificate's SubjectPublicKeyInfo, or of the SSH wire encoding of
+	// the host key.
+	Fingerprint string
+}
+
+// Cluster returns the identifier of the cluster of targets sharing the key:
+// its type and the start of its fingerprint, so it is the same in every run.
+func (key Key) Cluster() string {
+	fingerprint := key.Fingerprint
+	if len(fingerprint) > 16 {
+		fingerprint = fingerprint[:16]
+	}
+	return key.Type + "-" + fingerprint
+}
+
+func (key Key) hash() uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key.Type))
+	h.Write([]byte{0})
+	h.Write([]byte(key.Fingerprint))
+	return h.Sum64()
+}
+
+// Counter counts the targets presenting each key.
+type Counter struct {
+	counts map[uint64]uint32
+}
+
+// NewCounter returns an empty Counter.
+func NewCounter() *Counter {
+	return &Counter{counts: make(map[uint64]uint32)}
+}
+
+// Add counts a target presenting the key.
+func (c *Counter) Add(key Key) {
+	h := key.hash()
+	if c.counts[h] < ^uint32(0) {
+		c.counts[h]++
+	}
+}
+
+// Count returns the number of targets that presented the key.
+func (c *Counter) Count(key Key) uint32 {
+	return c.counts[key.hash()]
+}
+
+// Keys returns the number of unique keys counted.
+func (c *Counter) Keys() int {
+	return len(c.counts)
+}
+
+// Clusters returns the number of keys presented by at least minSize
+// targets.
+func (c *Counter) Clusters(minSize uint32) int {
+	ret := 0
+	for _, count := range c.counts {
+		if count >= minSize {
+			ret++
+		}
+	}
+	return ret
+}
+
+// Target is a line of zgrab2 output.
+type Target struct {
+	IP     string `json:"ip,omitempty"`
+	Domain string `json:"domain,omitempty"`
+}
+
+// Presented is a key presented by a target, as found in a scan response.
+type Presented struct {
+	Key
+
+	// Scan is the name of the scan (its key in the output's data).
+	Scan string
+
+	// Port is the port scanned, if the response gives it.
+	Port uint
+}
+
+// ReadKeys reads zgrab2 output lines and calls f for each, with the keys
+// presented in its scan responses. A key presented more than once in a line
+// (by several scans, or several handshakes of one scan) is only given once,
+// for the first scan presenting it.
+func ReadKeys(reader io.Reader, f func(target *Target, keys []*Presented) error) error {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(nil, 64*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		data := bytes.TrimSpace(scanner.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.UseNumber()
+		var res struct {
+			Target
+			Data map[string]map[string]interface{} `json:"data"`
+		}
+		if err := decoder.Decode(&res); err != nil {
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+		var keys []*Presented
+		seen := make(map[Key]bool)
+		for _, scan := range output.SortedScans(res.Data) {
+			response := res.Data[scan]
+			var port uint
+			if n, ok := response["port"].(json.Number); ok {
+				if p, err := strconv.ParseUint(n.String(), 10, 16); err == nil {
+					port = uint(p)
+				}
+			}
+			findKeys(response["result"], func(key Key) {
+				if !seen[key] {
+					seen[key] = true
+					keys = append(keys, &Presented{Key: key, Scan: scan, Port: port})
+				}
+			})
+		}
+		if err := f(&res.Target, keys); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// findKeys calls f with the keys anywhere in the value: the public keys of
+// the certificates in server_certificates blocks, and server_host_key
+// blocks.
+func findKeys(value interface{}, f func(Key)) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for _, name := range sortedMapKeys(v) {
+			child := v[name]
+			switch name {
+			case "server_certificates":
+				if fingerprint := lookupString(child, "certificate", "parsed", "subject_key_info", "fingerprint_sha256"); fingerprint != "" {
+					f(Key{Type: TypeTLS, Fingerprint: fingerprint})
+				}
+			case "server_host_key":
+				if fingerprint := lookupString(child, "fingerprint_sha256"); fingerprint != "" {
+					f(Key{Type: TypeSSH, Fingerprint: fingerprint})
+				}
+			default:
+				findKeys(child, f)
+			}
+		}
+	case []interface{}:
+		for _, child := range v {
+			findKeys(child, f)
+		}
+	}
+}
+
+// lookupString returns the string at the path of object keys in value, or
+// "" if there is none.
+func lookupString(value interface{}, path ...string) string {
+	for _, name := range path {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return ""
+		}
+		value = object[name]
+	}
+	s, _ := value.(string)
+	return s
+}
+
+// Count reads zgrab2 output lines, counting the targets presenting each key.
+func Count(reader io.Reader) (*Counter, error) {
+	c := NewCounter()
+	err := ReadKeys(reader, func(_ *Target, keys []*Presented) error {
+		for _, key := range keys {
+			c.Add(key.Key)
+		}
+		return nil
+	})
+	return c, err
+}
+
+// Reuse is an output line of Flag: a key a target shares with others.
+type Reuse struct {
+	IP          string `json:"ip,omitempty"`
+	Domain      string `json:"domain,omitempty"`
+	Scan        string `json:"scan"`
+	Port        uint   `json:"port,omitempty"`
+	Type        string `json:"type"`
+	Fingerprint string `json:"fingerprint_sha256"`
+	Cluster     string `json:"cluster"`
+	ClusterSize uint32 `json:"cluster_size"`
+}
+
+// Flag reads the zgrab2 output lines counted by c again, writing a JSON line
+// to writer for each key presented by a target that at least minSize targets
+// presented. It returns the number of lines written.
+func Flag(reader io.Reader, writer io.Writer, c *Counter, minSize uint32) (int, error) {
+	encoder := json.NewEncoder(writer)
+	written := 0
+	err := ReadKeys(reader, func(target *Target, keys []*Presented) error {
+		for _, key := range keys {
+			size := c.Count(key.Key)
+			if size < minSize {
+				continue
+			}
+			written++
+			if err := encoder.Encode(&Reuse{
+				IP:          target.IP,
+				Domain:      target.Domain,
+				Scan:        key.Scan,
+				Port:        key.Port,
+				Type:        key.Type,
+				Fingerprint: key.Fingerprint,
+				Cluster:     key.Cluster(),
+				ClusterSize: size,
+			}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return written, err
+}
+
+func sortedMapKeys(data map[string]interface{}) []string {
+	ret := make([]string, 0, len(data))
+	for k := range data {
+		ret = append(ret, k)
+	}
+	sort.Strings(ret)
+	return ret
+}
@@ -0,0 +1,64 @@
+package keyreuse
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+const (
+	sharedTLS = "aaaa0000000000000000000000000000000000000000000000000000000000aa"
+	uniqueTLS = "bbbb0000000000000000000000000000000000000000000000000000000000bb"
+	sharedSSH = "cccc0000000000000000000000000000000000000000000000000000000000cc"
+)
+
+const testResults = `{"ip":"10.0.0.1","data":{"https":{"status":"success","port":443,"result":{"tls":{"handshake_log":{"server_certificates":{"certificate":{"parsed":{"subject_key_info":{"fingerprint_sha256":"` + sharedTLS + `"}}}}}}}},"ssh":{"status":"success","port":22,"result":{"key_exchange":{"server_host_key":{"fingerprint_sha256":"` + sharedSSH + `"}}}}}}
+{"ip":"10.0.0.2","data":{"https":{"status":"success","port":443,"result":{"requests":[{"tls_log":{"handshake_log":{"server_certificates":{"certificate":{"parsed":{"subject_key_info":{"fingerprint_sha256":"` + sharedTLS + `"}}}}}}},{"tls_log":{"handshake_log":{"server_certificates":{"certificate":{"parsed":{"subject_key_info":{"fingerprint_sha256":"` + sharedTLS + `"}}}}}}}]}}}}
+
+{"ip":"10.0.0.3","data":{"tls":{"status":"success","port":8443,"result":{"handshake_log":{"server_certificates":{"certificate":{"parsed":{"subject_key_info":{"fingerprint_sha256":"` + uniqueTLS + `"}}}}}}}}}
+{"domain":"example.com","data":{"ssh":{"status":"success","result":{"key_exchange":{"server_host_key":{"fingerprint_sha256":"` + sharedSSH + `"}}}}}}
+{"ip":"10.0.0.5","data":{"http":{"status":"success","port":80,"result":{"response":{"status_code":200}}}}}
+`
+
+func TestKeyReuse(t *testing.T) {
+	counter, err := Count(strings.NewReader(testResults))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The second target presents the shared key twice, but is counted once.
+	if n := counter.Count(Key{TypeTLS, sharedTLS}); n != 2 {
+		t.Errorf("shared TLS key counted %d times", n)
+	}
+	if counter.Keys() != 3 || counter.Clusters(2) != 2 || counter.Clusters(3) != 0 {
+		t.Errorf("wrong counts: %d keys, %d clusters", counter.Keys(), counter.Clusters(2))
+	}
+
+	var out bytes.Buffer
+	written, err := Flag(strings.NewReader(testResults), &out, counter, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if written != 4 || len(lines) != 4 {
+		t.Fatalf("wrote %d lines:\n%s", written, out.String())
+	}
+	var first Reuse
+	if err := json.Unmarshal([]byte(lines[0]), &first); err != nil {
+		t.Fatal(err)
+	}
+	expected := Reuse{IP: "10.0.0.1", Scan: "https", Port: 443, Type: TypeTLS, Fingerprint: sharedTLS, Cluster: "tls-aaaa000000000000", ClusterSize: 2}
+	if first != expected {
+		t.Errorf("wrong first line %+v", first)
+	}
+	if !strings.Contains(lines[3], `"domain":"example.com","scan":"ssh","type":"ssh"`) || !strings.Contains(lines[3], `"cluster":"ssh-cccc000000000000"`) {
+		t.Errorf("wrong last line %s", lines[3])
+	}
+	if strings.Contains(out.String(), uniqueTLS) {
+		t.Error("unshared key reported")
+	}
+
+	if _, err := Count(strings.NewReader("{not json\n")); err == nil || !strings.HasPrefix(err.Error(), "line 1:") {
+		t.Errorf("expected an error for a bad line, got %v", err)
+	}
+}
@@ -233,7 +233,7 @@ type ConnectionLogEntry struct {
 
 	// Raw is the raw packet body, base64-encoded. May be nil on a read
 	// error.
-	Raw string `zgrab:"debug" json:"raw"`
+	Raw string `zgrab:"debug,raw" json:"raw"`
 
 	// Parsed is the parsed packet body. May be nil on a decode error.
 	Parsed PacketInfo `json:"parsed,omitempty"`
@@ -513,6 +513,22 @@ func (e *ERRPacket) GetScanError() *zgrab2.ScanError {
 	return &zgrab2.ScanError{
 		Status: zgrab2.SCAN_APPLICATION_ERROR,
 		Err: e,
+		Reason: e.GetRejectionReason(),
+	}
+}
+
+// GetRejectionReason returns the reason the server refused the connection
+// with this error.
+func (e *ERRPacket) GetRejectionReason() zgrab2.RejectionReason {
+	switch e.GetErrorID() {
+	case "ER_HOST_NOT_PRIVILEGED", "ER_HOST_IS_BLOCKED", "ER_ACCESS_DENIED_ERROR":
+		return zgrab2.REJECTED_ACCESS_DENIED
+	case "ER_CON_COUNT_ERROR", "ER_TOO_MANY_USER_CONNECTIONS":
+		return zgrab2.REJECTED_TOO_MANY_CONNECTIONS
+	case "ER_SECURE_TRANSPORT_REQUIRED":
+		return zgrab2.REJECTED_ENCRYPTION_REQUIRED
+	default:
+		return zgrab2.REJECTED_UNSPECIFIED
 	}
 }
 
@@ -6,17 +6,22 @@ import (
 	"fmt"
 	"reflect"
 	"strings"
+	"sync"
 )
 
-// ZGrabTag holds the information from the `zgrab` tag. Currently only supports
-// the "debug" value.
+// ZGrabTag holds the information from the `zgrab` tag. Currently supports the
+// "debug" and "raw" values.
 type ZGrabTag struct {
 	// Debug means that the field should only be output when doing verbose output.
 	Debug bool
+
+	// Raw means that the field holds a raw dump of protocol data, which is
+	// omitted when OmitRaw is set.
+	Raw bool
 }
 
 // parseZGrabTag reads the `zgrab` tag and returns the corresponding parsed
-// ZGrabTag. Currently only "debug" is recognized; other options should be
+// ZGrabTag. Currently "debug" and "raw" are recognized; options should be
 // comma separated.
 func parseZGrabTag(value string) *ZGrabTag {
 	ret := ZGrabTag{Debug: false}
@@ -25,6 +30,8 @@ func parseZGrabTag(value string) *ZGrabTag {
 		switch strings.TrimSpace(field) {
 		case "debug":
 			ret.Debug = true
+		case "raw":
+			ret.Raw = true
 		}
 	}
 	return &ret
@@ -35,11 +42,35 @@ func parseZGrabTag(value string) *ZGrabTag {
 // that element will be skipped.
 type ProcessCallback func(*Processor, reflect.Value) *reflect.Value
 
+// pathEntry is a step in the path to the element being processed. It is
+// only formatted if the path is needed, to avoid allocating on every step.
 type pathEntry struct {
+	// field is the struct field name, or a fixed label (e.g. "*").
 	field string
+
+	// index is the struct field, array or slice index, or -1.
+	index int
+
+	// key, if valid, is the map key.
+	key reflect.Value
+
 	value reflect.Value
 }
 
+// String returns the representation of the step used by getPath.
+func (entry *pathEntry) String() string {
+	switch {
+	case entry.key.IsValid():
+		return fmt.Sprintf("[%v]", entry.key)
+	case entry.field == "":
+		return fmt.Sprintf("[%d]", entry.index)
+	case entry.index >= 0:
+		return fmt.Sprintf("%s(%d)", entry.field, entry.index)
+	default:
+		return entry.field
+	}
+}
+
 // Processor holds the state for a process run. A given processor should
 // only be used on a single thread.
 type Processor struct {
@@ -53,6 +84,10 @@ type Processor struct {
 	// included in the output.
 	Verbose bool
 
+	// OmitRaw determines whether `zgrab:"raw"` fields will be omitted from
+	// the output, even if Verbose is set.
+	OmitRaw bool
+
 	// Path is the current path being processed, from the root element.
 	// Used for debugging purposes only.
 	// If a panic occurs, the path will point to the element where the
@@ -68,8 +103,9 @@ func NewProcessor() *Processor {
 // getPath returns a string representation of the current path.
 func (processor *Processor) getPath() string {
 	ret := make([]string, len(processor.Path))
-	for i, v := range processor.Path {
-		ret[i] = v.field
+	for i := range processor.Path {
+		v := &processor.Path[i]
+		ret[i] = v.String()
 	}
 	return strings.Join(ret, "->")
 }
@@ -97,10 +133,13 @@ func (processor *Processor) duplicate(v reflect.Value) reflect.Value {
 	return ret
 }
 
-// Add a path with the given key and value to the stack.
-func (processor *Processor) pushPath(key string, value reflect.Value) {
+// Add a path with the given field, index (or -1), map key and value to the
+// stack.
+func (processor *Processor) pushPath(field string, index int, key reflect.Value, value reflect.Value) {
 	processor.Path = append(processor.Path, pathEntry{
-		field: key,
+		field: field,
+		index: index,
+		key:   key,
 		value: value,
 	})
 }
@@ -124,8 +163,8 @@ func isNil(v reflect.Value) bool {
 }
 
 // Check if a field should be copied over to the return value.
-// The only time a field should be wiped is if the field has the `zgrab:"debug"`
-// tag set, and if the verbose flag is off.
+// A field is wiped if it has the `zgrab:"debug"` tag set and the verbose flag
+// is off, or if it has the `zgrab:"raw"` tag set and the omit raw flag is on.
 // There is an additional caveat that, if the field is already nil, leave it
 // (so that we don't set it to a non-nil "zero" value).
 func (processor *Processor) shouldWipeField(parent reflect.Value, index int) bool {
@@ -138,8 +177,7 @@ func (processor *Processor) shouldWipeField(parent reflect.Value, index int) boo
 	}
 
 	tag := parseZGrabTag(tField.Tag.Get("zgrab"))
-	// The only time a field
-	return tag.Debug && !processor.Verbose
+	return (tag.Debug && !processor.Verbose) || (tag.Raw && processor.OmitRaw)
 }
 
 // Process the struct instance.
@@ -163,7 +201,7 @@ func (processor *Processor) processStruct(v reflect.Value) reflect.Value {
 			retField.Set(reflect.Zero(field.Type()))
 			continue
 		}
-		processor.pushPath(fmt.Sprintf("%s(%d)", tField.Name, i), field)
+		processor.pushPath(tField.Name, i, reflect.Value{}, field)
 		copy := processor.process(field)
 		processor.popPath()
 		retField.Set(copy)
@@ -178,7 +216,7 @@ func (processor *Processor) processPtr(v reflect.Value) reflect.Value {
 		//fmt.Println("Goodbye to ", processor.getPath())
 		return ret.Addr()
 	}
-	processor.pushPath("*", v.Elem())
+	processor.pushPath("*", -1, reflect.Value{}, v.Elem())
 	copy := processor.process(v.Elem())
 	processor.popPath()
 	ret.Set(copy)
@@ -193,7 +231,7 @@ func (processor *Processor) processInterface(v reflect.Value) reflect.Value {
 		return ret.Addr()
 	}
 
-	processor.pushPath("[interface:"+v.Type().Name()+")]", v.Elem())
+	processor.pushPath("[interface:"+v.Type().Name()+")]", -1, reflect.Value{}, v.Elem())
 	copy := processor.process(v.Elem())
 	processor.popPath()
 	ret.Set(copy)
@@ -214,7 +252,7 @@ func (processor *Processor) processMap(v reflect.Value) reflect.Value {
 
 	for _, key := range keys {
 		value := v.MapIndex(key)
-		processor.pushPath(fmt.Sprintf("[%v]", key), value)
+		processor.pushPath("", -1, key, value)
 		copy := processor.process(value)
 		processor.popPath()
 		ret.SetMapIndex(key, copy)
@@ -227,7 +265,7 @@ func (processor *Processor) processArray(v reflect.Value) reflect.Value {
 	ret := reflect.New(v.Type()).Elem()
 	for i := 0; i < v.Len(); i++ {
 		elt := v.Index(i)
-		processor.pushPath(fmt.Sprintf("[%d]", i), elt)
+		processor.pushPath("", i, reflect.Value{}, elt)
 		copy := processor.process(elt)
 		ret.Index(i).Set(copy)
 		processor.popPath()
@@ -258,7 +296,7 @@ func (processor *Processor) processSlice(v reflect.Value) reflect.Value {
 	ret.Set(reflect.MakeSlice(v.Type(), n, v.Cap()))
 	for i := 0; i < n; i++ {
 		elt := v.Index(i)
-		processor.pushPath(fmt.Sprintf("[%d]", i), elt)
+		processor.pushPath("", i, reflect.Value{}, elt)
 		copy := processor.process(elt)
 		ret.Index(i).Set(copy)
 		processor.popPath()
@@ -266,10 +304,66 @@ func (processor *Processor) processSlice(v reflect.Value) reflect.Value {
 	return ret
 }
 
+// taggedTypes caches whether values of each type may contain debug or raw
+// fields.
+var taggedTypes = struct {
+	sync.Mutex
+	m map[reflect.Type]bool
+}{m: make(map[reflect.Type]bool)}
+
+// mayContainTaggedFields returns true if values of type t may contain fields
+// with the `zgrab:"debug"` or `zgrab:"raw"` tags: either directly, or through
+// interfaces whose dynamic types are not known until the value is processed.
+func mayContainTaggedFields(t reflect.Type) bool {
+	taggedTypes.Lock()
+	defer taggedTypes.Unlock()
+	ret, ok := taggedTypes.m[t]
+	if !ok {
+		// Only the result for t is cached, since the results for the types
+		// it refers to may be incomplete when they are part of a cycle.
+		ret = typeMayContainTaggedFields(t, make(map[reflect.Type]bool))
+		taggedTypes.m[t] = ret
+	}
+	return ret
+}
+
+// typeMayContainTaggedFields implements mayContainTaggedFields, skipping the
+// types already visited.
+func typeMayContainTaggedFields(t reflect.Type, visited map[reflect.Type]bool) bool {
+	if visited[t] {
+		return false
+	}
+	visited[t] = true
+	switch t.Kind() {
+	case reflect.Interface:
+		return true
+	case reflect.Ptr, reflect.Slice, reflect.Array:
+		return typeMayContainTaggedFields(t.Elem(), visited)
+	case reflect.Map:
+		return typeMayContainTaggedFields(t.Key(), visited) || typeMayContainTaggedFields(t.Elem(), visited)
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			tag := parseZGrabTag(field.Tag.Get("zgrab"))
+			if tag.Debug || tag.Raw || typeMayContainTaggedFields(field.Type, visited) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 // Process an arbitrary value. Invokes the processor's callback; if it returns
 // a non-nil value, return that. Otherwise, continue recursively processing
 // the value.
+//
+// Without a callback, values that cannot contain debug or raw fields (or any
+// value, in verbose mode without OmitRaw) would be copied unchanged, so they
+// are returned as-is instead, sharing their referents with the original.
 func (processor *Processor) process(v reflect.Value) reflect.Value {
+	if processor.Callback == nil && ((processor.Verbose && !processor.OmitRaw) || (v.IsValid() && !mayContainTaggedFields(v.Type()))) {
+		return v
+	}
 	temp := processor.callback(v)
 	if temp != nil {
 		return *temp
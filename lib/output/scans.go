@@ -0,0 +1,14 @@
+package output
+
+import "sort"
+
+// SortedScans returns the names of the scans in the data of a zgrab2 output
+// line, in order, so that tools reading output handle them deterministically.
+func SortedScans(data map[string]map[string]interface{}) []string {
+	ret := make([]string, 0, len(data))
+	for k := range data {
+		ret = append(ret, k)
+	}
+	sort.Strings(ret)
+	return ret
+}
@@ -39,6 +39,10 @@ type Options struct {
 	User        string
 	Password    string
 	Hash        string
+
+	// NegotiateContexts offers SMB 3.1.1 with negotiate contexts in the
+	// negotiate request.
+	NegotiateContexts bool
 }
 
 func validateOptions(opt Options) error {
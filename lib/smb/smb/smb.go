@@ -1,6 +1,9 @@
 package smb
 
 import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
 	"errors"
 	"fmt"
 
@@ -33,6 +36,41 @@ const DialectSmb_3_0_2 = 0x0302
 const DialectSmb_3_1_1 = 0x0311
 const DialectSmb2_ALL = 0x02FF
 
+// Negotiate context types (SMB 3.1.1); see
+// https://msdn.microsoft.com/en-us/library/mt208834.aspx.
+const (
+	NegotiateContextPreauthIntegrity          uint16 = 0x0001
+	NegotiateContextEncryption                uint16 = 0x0002
+	NegotiateContextCompression               uint16 = 0x0003
+	NegotiateContextNetnameNegotiate          uint16 = 0x0005
+	NegotiateContextTransportCapabilities     uint16 = 0x0006
+	NegotiateContextRDMATransformCapabilities uint16 = 0x0007
+	NegotiateContextSigningCapabilities       uint16 = 0x0008
+)
+
+const HashAlgorithmSHA512 uint16 = 0x0001
+
+const (
+	CipherAES128CCM uint16 = 0x0001
+	CipherAES128GCM uint16 = 0x0002
+	CipherAES256CCM uint16 = 0x0003
+	CipherAES256GCM uint16 = 0x0004
+)
+
+const (
+	CompressionLZNT1       uint16 = 0x0001
+	CompressionLZ77        uint16 = 0x0002
+	CompressionLZ77Huffman uint16 = 0x0003
+)
+
+const (
+	SigningHMACSHA256 uint16 = 0x0000
+	SigningAESCMAC    uint16 = 0x0001
+	SigningAESGMAC    uint16 = 0x0002
+)
+
+const TransportCapabilityAcceptTransportLevelSecurity uint32 = 0x00000001
+
 const (
 	CommandNegotiate uint16 = iota
 	CommandSessionSetup
@@ -121,6 +159,14 @@ type NegotiateReq struct {
 	Dialects        []uint16
 }
 
+// NegotiateContextReq is a NegotiateReq offering SMB 3.1.1, followed by its
+// negotiate contexts. In an SMB 3.1.1 request, ClientStartTime holds the
+// NegotiateContextOffset and NegotiateContextCount.
+type NegotiateContextReq struct {
+	NegotiateReq
+	Contexts []byte
+}
+
 type NegotiateRes struct {
 	Header
 	StructureSize        uint16
@@ -255,6 +301,108 @@ func (s *Session) NewNegotiateReq() NegotiateReq {
 	}
 }
 
+// NewNegotiateContextReq returns a negotiate request offering all SMB 2 and 3
+// dialects, with negotiate contexts offering every preauth integrity hash,
+// cipher, compression and signing algorithm defined, so that the server's
+// choices show up in its response's negotiate contexts.
+func (s *Session) NewNegotiateContextReq() (NegotiateContextReq, error) {
+	req := s.NewNegotiateReq()
+	req.Dialects = []uint16{
+		uint16(DialectSmb_2_0_2),
+		uint16(DialectSmb_2_1),
+		uint16(DialectSmb_3_0),
+		uint16(DialectSmb_3_0_2),
+		uint16(DialectSmb_3_1_1),
+	}
+	req.DialectCount = uint16(len(req.Dialects))
+
+	salt := make([]byte, 32)
+	if _, err := rand.Read(salt); err != nil {
+		return NegotiateContextReq{}, err
+	}
+	preauth := new(bytes.Buffer)
+	binary.Write(preauth, binary.LittleEndian, []uint16{1, uint16(len(salt)), HashAlgorithmSHA512})
+	preauth.Write(salt)
+
+	encryption := new(bytes.Buffer)
+	binary.Write(encryption, binary.LittleEndian, []uint16{4, CipherAES128CCM, CipherAES128GCM, CipherAES256CCM, CipherAES256GCM})
+
+	compression := new(bytes.Buffer)
+	binary.Write(compression, binary.LittleEndian, []uint16{3, 0})
+	binary.Write(compression, binary.LittleEndian, uint32(0))
+	binary.Write(compression, binary.LittleEndian, []uint16{CompressionLZNT1, CompressionLZ77, CompressionLZ77Huffman})
+
+	transport := new(bytes.Buffer)
+	binary.Write(transport, binary.LittleEndian, TransportCapabilityAcceptTransportLevelSecurity)
+
+	signing := new(bytes.Buffer)
+	binary.Write(signing, binary.LittleEndian, []uint16{3, SigningHMACSHA256, SigningAESCMAC, SigningAESGMAC})
+
+	contexts := []negotiateContext{
+		{NegotiateContextPreauthIntegrity, preauth.Bytes()},
+		{NegotiateContextEncryption, encryption.Bytes()},
+		{NegotiateContextCompression, compression.Bytes()},
+		{NegotiateContextTransportCapabilities, transport.Bytes()},
+		{NegotiateContextSigningCapabilities, signing.Bytes()},
+	}
+
+	// The contexts start at the first 8-byte boundary after the dialects,
+	// counting from the start of the SMB2 header.
+	end := 64 + 36 + 2*len(req.Dialects)
+	offset := (end + 7) &^ 7
+	req.ClientStartTime = uint64(offset) | uint64(len(contexts))<<32
+	return NegotiateContextReq{
+		NegotiateReq: req,
+		Contexts:     append(make([]byte, offset-end), marshalNegotiateContexts(contexts)...),
+	}, nil
+}
+
+// negotiateContext is a single SMB2_NEGOTIATE_CONTEXT.
+type negotiateContext struct {
+	Type uint16
+	Data []byte
+}
+
+// marshalNegotiateContexts encodes the contexts, each aligned to 8 bytes.
+func marshalNegotiateContexts(contexts []negotiateContext) []byte {
+	buf := new(bytes.Buffer)
+	for i, context := range contexts {
+		if i > 0 {
+			buf.Write(make([]byte, (8-buf.Len()%8)%8))
+		}
+		binary.Write(buf, binary.LittleEndian, context.Type)
+		binary.Write(buf, binary.LittleEndian, uint16(len(context.Data)))
+		binary.Write(buf, binary.LittleEndian, uint32(0))
+		buf.Write(context.Data)
+	}
+	return buf.Bytes()
+}
+
+// unmarshalNegotiateContexts decodes count contexts, each aligned to 8
+// bytes, from buf. On error, the contexts decoded so far are returned.
+func unmarshalNegotiateContexts(buf []byte, count int) ([]negotiateContext, error) {
+	var ret []negotiateContext
+	pos := 0
+	for i := 0; i < count; i++ {
+		if i > 0 {
+			pos += (8 - pos%8) % 8
+		}
+		if pos+8 > len(buf) {
+			return ret, errors.New("negotiate context header truncated")
+		}
+		length := int(binary.LittleEndian.Uint16(buf[pos+2 : pos+4]))
+		if pos+8+length > len(buf) {
+			return ret, errors.New("negotiate context data truncated")
+		}
+		ret = append(ret, negotiateContext{
+			Type: binary.LittleEndian.Uint16(buf[pos : pos+2]),
+			Data: buf[pos+8 : pos+8+length],
+		})
+		pos += 8 + length
+	}
+	return ret, nil
+}
+
 func NewNegotiateRes() NegotiateRes {
 	return NegotiateRes{
 		Header:               newHeader(),
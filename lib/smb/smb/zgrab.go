@@ -2,6 +2,7 @@ package smb
 
 import (
 	"encoding/asn1"
+	"encoding/binary"
 	"encoding/hex"
 	"errors"
 	"fmt"
@@ -58,6 +59,64 @@ type NegotiationLog struct {
 	// AuthenticationTypes is a list of OBJECT IDENTIFIERs (in dotted-decimal format) identifying authentication modes
 	// // that the server supports.
 	AuthenticationTypes []string `json:"authentication_types,omitempty"`
+
+	// RawResponse is the raw NEGOTIATE response, starting with the SMB2 header.
+	RawResponse []byte `json:"raw_response,omitempty" zgrab:"debug,raw"`
+
+	// NegotiateContexts, if present, contains the SMB 3.1.1 negotiate contexts in the response.
+	NegotiateContexts *NegotiateContextsLog `json:"negotiate_contexts,omitempty" zgrab:"debug"`
+}
+
+// PreauthIntegrityLog contains the SMB2_PREAUTH_INTEGRITY_CAPABILITIES negotiate context.
+type PreauthIntegrityLog struct {
+	// HashAlgorithms lists the hash algorithm IDs (e.g. HashAlgorithmSHA512).
+	HashAlgorithms []uint16 `json:"hash_algorithms"`
+
+	// Salt is the server's salt.
+	Salt []byte `json:"salt"`
+}
+
+// CompressionLog contains the SMB2_COMPRESSION_CAPABILITIES negotiate context.
+type CompressionLog struct {
+	// Algorithms lists the compression algorithm IDs (e.g. CompressionLZ77).
+	Algorithms []uint16 `json:"algorithms"`
+
+	// Flags is the compression capability flags.
+	Flags uint32 `json:"flags"`
+}
+
+// NegotiateContextLog is a single negotiate context, as sent.
+type NegotiateContextLog struct {
+	// Type is the context type (e.g. NegotiateContextEncryption).
+	Type uint16 `json:"type"`
+
+	// Data is the context's data.
+	Data []byte `json:"data"`
+}
+
+// NegotiateContextsLog contains the negotiate contexts of a NEGOTIATE response.
+// See https://msdn.microsoft.com/en-us/library/mt208834.aspx.
+type NegotiateContextsLog struct {
+	// PreauthIntegrity, if present, contains the preauth integrity hash algorithms and salt.
+	PreauthIntegrity *PreauthIntegrityLog `json:"preauth_integrity,omitempty"`
+
+	// Ciphers lists the encryption cipher IDs (e.g. CipherAES128GCM).
+	Ciphers []uint16 `json:"ciphers,omitempty"`
+
+	// Compression, if present, contains the compression algorithms.
+	Compression *CompressionLog `json:"compression,omitempty"`
+
+	// SigningAlgorithms lists the signing algorithm IDs (e.g. SigningAESCMAC).
+	SigningAlgorithms []uint16 `json:"signing_algorithms,omitempty"`
+
+	// TransportCapabilities, if present, is the transport capability flags.
+	TransportCapabilities *uint32 `json:"transport_capabilities,omitempty"`
+
+	// Contexts lists all of the contexts in the response, in order.
+	Contexts []NegotiateContextLog `json:"contexts,omitempty"`
+
+	// Error describes why the contexts could not be fully parsed, if they could not.
+	Error string `json:"error,omitempty"`
 }
 
 // SessionSetupLog contains the relevant parts of the first session setup response packet.
@@ -73,6 +132,94 @@ type SessionSetupLog struct {
 
 	// NegotiateFlags are the flags from the challenge packet
 	NegotiateFlags uint32 `json:"negotiate_flags"`
+
+	// OSVersion, if present, is the operating system version from the challenge packet.
+	OSVersion *OSVersionLog `json:"os_version,omitempty"`
+
+	// TargetInfo, if present, holds the server's names from the challenge packet.
+	TargetInfo *TargetInfoLog `json:"target_info,omitempty"`
+}
+
+// TargetInfoLog holds the names in the AV pairs of an NTLM challenge's
+// TargetInfo field.
+// See https://msdn.microsoft.com/en-us/library/cc236646.aspx.
+type TargetInfoLog struct {
+	// NetBIOSComputerName is the server's NetBIOS computer name.
+	NetBIOSComputerName string `json:"netbios_computer_name,omitempty"`
+
+	// NetBIOSDomainName is the server's NetBIOS domain name.
+	NetBIOSDomainName string `json:"netbios_domain_name,omitempty"`
+
+	// DNSComputerName is the server's fully-qualified domain name.
+	DNSComputerName string `json:"dns_computer_name,omitempty"`
+
+	// DNSDomainName is the DNS name of the server's domain.
+	DNSDomainName string `json:"dns_domain_name,omitempty"`
+
+	// DNSTreeName is the DNS name of the server's forest.
+	DNSTreeName string `json:"dns_tree_name,omitempty"`
+}
+
+// OSVersionLog is the operating system version sent in an NTLM challenge.
+// See https://msdn.microsoft.com/en-us/library/cc236654.aspx.
+type OSVersionLog struct {
+	// MajorVersion is the major version number of the operating system.
+	MajorVersion uint8 `json:"major_version"`
+
+	// MinorVersion is the minor version number of the operating system.
+	MinorVersion uint8 `json:"minor_version"`
+
+	// Build is the build number of the operating system.
+	Build uint16 `json:"build"`
+
+	// NTLMRevision is the version of the NTLMSSP protocol in use.
+	NTLMRevision uint8 `json:"ntlm_revision"`
+}
+
+// String returns the version as MAJOR.MINOR.BUILD.
+func (v *OSVersionLog) String() string {
+	return fmt.Sprintf("%d.%d.%d", v.MajorVersion, v.MinorVersion, v.Build)
+}
+
+// getOSVersionLog decodes the Version field of an NTLM challenge, which is
+// only valid if the challenge's negotiate flags include FlgNegVersion.
+func getOSVersionLog(challenge *ntlmssp.Challenge) *OSVersionLog {
+	if challenge.NegotiateFlags&ntlmssp.FlgNegVersion == 0 {
+		return nil
+	}
+	return &OSVersionLog{
+		MajorVersion: uint8(challenge.Version),
+		MinorVersion: uint8(challenge.Version >> 8),
+		Build:        uint16(challenge.Version >> 16),
+		NTLMRevision: uint8(challenge.Version >> 56),
+	}
+}
+
+// getTargetInfoLog decodes the names in the TargetInfo field of an NTLM
+// challenge, or returns nil if there are none.
+func getTargetInfoLog(challenge *ntlmssp.Challenge) *TargetInfoLog {
+	if challenge.TargetInfo == nil {
+		return nil
+	}
+	ret := new(TargetInfoLog)
+	for _, pair := range *challenge.TargetInfo {
+		switch pair.AvID {
+		case ntlmssp.MsvAvNbComputerName:
+			ret.NetBIOSComputerName = wstring(pair.Value)
+		case ntlmssp.MsvAvNbDomainName:
+			ret.NetBIOSDomainName = wstring(pair.Value)
+		case ntlmssp.MsvAvDnsComputerName:
+			ret.DNSComputerName = wstring(pair.Value)
+		case ntlmssp.MsvAvDnsDomainName:
+			ret.DNSDomainName = wstring(pair.Value)
+		case ntlmssp.MsvAvDnsTreeName:
+			ret.DNSTreeName = wstring(pair.Value)
+		}
+	}
+	if *ret == (TargetInfoLog{}) {
+		return nil
+	}
+	return ret
 }
 
 // SMBLog logs the relevant information about the session.
@@ -120,11 +267,88 @@ func fillHeaderLog(src *Header, dest *HeaderLog) *HeaderLog {
 	return dest
 }
 
-// GetSMBLog attempts to negotiate a SMB session on the given connection.
-func GetSMBLog(conn net.Conn, debug bool) (*SMBLog, error) {
-	opt := Options{}
+// readUint16s reads count little-endian uint16s from the start of data,
+// returning nil if it is too short.
+func readUint16s(data []byte, count int) []uint16 {
+	if len(data) < 2*count {
+		return nil
+	}
+	ret := make([]uint16, count)
+	for i := range ret {
+		ret[i] = binary.LittleEndian.Uint16(data[2*i:])
+	}
+	return ret
+}
+
+// getNegotiateContextsLog parses the negotiate contexts of a NEGOTIATE
+// response. In SMB 3.1.1 responses, the Reserved fields hold the
+// NegotiateContextCount and NegotiateContextOffset.
+func getNegotiateContextsLog(buf []byte, negRes *NegotiateRes) *NegotiateContextsLog {
+	if negRes.DialectRevision != DialectSmb_3_1_1 || negRes.Reserved == 0 {
+		return nil
+	}
+	ret := new(NegotiateContextsLog)
+	offset := int(negRes.Reserved2)
+	if offset > len(buf) {
+		ret.Error = "negotiate context offset out of range"
+		return ret
+	}
+	contexts, err := unmarshalNegotiateContexts(buf[offset:], int(negRes.Reserved))
+	if err != nil {
+		ret.Error = err.Error()
+	}
+	for _, context := range contexts {
+		data := context.Data
+		ret.Contexts = append(ret.Contexts, NegotiateContextLog{
+			Type: context.Type,
+			Data: append(make([]byte, 0, len(data)), data...),
+		})
+		switch context.Type {
+		case NegotiateContextPreauthIntegrity:
+			if len(data) < 4 {
+				continue
+			}
+			count, saltLength := int(binary.LittleEndian.Uint16(data)), int(binary.LittleEndian.Uint16(data[2:]))
+			algorithms := readUint16s(data[4:], count)
+			if algorithms == nil || len(data) < 4+2*count+saltLength {
+				continue
+			}
+			salt := data[4+2*count : 4+2*count+saltLength]
+			ret.PreauthIntegrity = &PreauthIntegrityLog{
+				HashAlgorithms: algorithms,
+				Salt:           append(make([]byte, 0, len(salt)), salt...),
+			}
+		case NegotiateContextEncryption:
+			if len(data) >= 2 {
+				ret.Ciphers = readUint16s(data[2:], int(binary.LittleEndian.Uint16(data)))
+			}
+		case NegotiateContextCompression:
+			if len(data) < 8 {
+				continue
+			}
+			if algorithms := readUint16s(data[8:], int(binary.LittleEndian.Uint16(data))); algorithms != nil {
+				ret.Compression = &CompressionLog{
+					Algorithms: algorithms,
+					Flags:      binary.LittleEndian.Uint32(data[4:]),
+				}
+			}
+		case NegotiateContextSigningCapabilities:
+			if len(data) >= 2 {
+				ret.SigningAlgorithms = readUint16s(data[2:], int(binary.LittleEndian.Uint16(data)))
+			}
+		case NegotiateContextTransportCapabilities:
+			if len(data) >= 4 {
+				flags := binary.LittleEndian.Uint32(data)
+				ret.TransportCapabilities = &flags
+			}
+		}
+	}
+	return ret
+}
 
-	s := &LoggedSession{
+// NewLoggedSession returns a LoggedSession on the given connection.
+func NewLoggedSession(conn net.Conn, opt Options, debug bool) *LoggedSession {
+	return &LoggedSession{
 		Session: Session{
 			IsSigningRequired: false,
 			IsAuthenticated:   false,
@@ -138,30 +362,18 @@ func GetSMBLog(conn net.Conn, debug bool) (*SMBLog, error) {
 			trees:             make(map[string]uint32),
 		},
 	}
+}
 
+// GetSMBLog attempts to negotiate a SMB session on the given connection.
+func GetSMBLog(conn net.Conn, debug bool) (*SMBLog, error) {
+	s := NewLoggedSession(conn, Options{}, debug)
 	err := s.LoggedNegotiateProtocol(true)
 	return s.Log, err
 }
 
 // GetSMBBanner sends a single negotiate packet to the server to perform a scan equivalent to the original ZGrab.
 func GetSMBBanner(conn net.Conn, debug bool) (*SMBLog, error) {
-	opt := Options{}
-
-	s := &LoggedSession{
-		Session: Session{
-			IsSigningRequired: false,
-			IsAuthenticated:   false,
-			debug:             debug,
-			securityMode:      0,
-			messageID:         0,
-			sessionID:         0,
-			dialect:           0,
-			conn:              conn,
-			options:           opt,
-			trees:             make(map[string]uint32),
-		},
-	}
-
+	s := NewLoggedSession(conn, Options{}, debug)
 	err := s.LoggedNegotiateProtocol(false)
 	return s.Log, err
 }
@@ -182,7 +394,15 @@ func wstring(input []byte) string {
 // If setup is true, send a SessionSetup1 request.
 func (ls *LoggedSession) LoggedNegotiateProtocol(setup bool) error {
 	s := &ls.Session
-	negReq := s.NewNegotiateReq()
+	var negReq interface{} = s.NewNegotiateReq()
+	if s.options.NegotiateContexts {
+		req, err := s.NewNegotiateContextReq()
+		if err != nil {
+			s.Debug("", err)
+			return err
+		}
+		negReq = req
+	}
 	s.Debug("Sending LoggedNegotiateProtocol request", nil)
 	buf, err := s.send(negReq)
 	if err != nil {
@@ -208,7 +428,9 @@ func (ls *LoggedSession) LoggedNegotiateProtocol(setup bool) error {
 		Capabilities:    negRes.Capabilities,
 		SystemTime:      getTime(negRes.SystemTime),
 		ServerStartTime: getTime(negRes.ServerStartTime),
+		RawResponse:     append(make([]byte, 0, len(buf)), buf...),
 	}
+	logStruct.NegotiationLog.NegotiateContexts = getNegotiateContextsLog(buf, &negRes)
 	if negRes.Header.Status != StatusOk {
 		return errors.New(fmt.Sprintf("NT Status Error: %d\n", negRes.Header.Status))
 	}
@@ -305,6 +527,8 @@ func (ls *LoggedSession) LoggedNegotiateProtocol(setup bool) error {
 	}
 	logStruct.SessionSetupLog.TargetName = wstring(challenge.TargetName)
 	logStruct.SessionSetupLog.NegotiateFlags = challenge.NegotiateFlags
+	logStruct.SessionSetupLog.OSVersion = getOSVersionLog(&challenge)
+	logStruct.SessionSetupLog.TargetInfo = getTargetInfoLog(&challenge)
 
 	return nil
 }
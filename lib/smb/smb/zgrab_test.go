@@ -0,0 +1,130 @@
+package smb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/zmap/zgrab2/lib/smb/ntlmssp"
+	"github.com/zmap/zgrab2/lib/smb/smb/encoder"
+)
+
+func TestNegotiateContextReq(t *testing.T) {
+	s := &Session{}
+	req, err := s.NewNegotiateContextReq()
+	if err != nil {
+		t.Fatalf("could not create request: %v", err)
+	}
+	buf, err := encoder.Marshal(req)
+	if err != nil {
+		t.Fatalf("could not marshal request: %v", err)
+	}
+	// ClientStartTime holds the context offset and count, 8 bytes before the
+	// dialects.
+	pos := 64 + 36 - 8
+	offset := int(binary.LittleEndian.Uint32(buf[pos:]))
+	count := int(binary.LittleEndian.Uint16(buf[pos+4:]))
+	if offset%8 != 0 || offset < 64+36+2*len(req.Dialects) || offset > len(buf) {
+		t.Fatalf("bad negotiate context offset %d", offset)
+	}
+	contexts, err := unmarshalNegotiateContexts(buf[offset:], count)
+	if err != nil {
+		t.Fatalf("could not unmarshal contexts: %v", err)
+	}
+	if len(contexts) != 5 || contexts[0].Type != NegotiateContextPreauthIntegrity || contexts[4].Type != NegotiateContextSigningCapabilities {
+		t.Errorf("unexpected contexts %+v", contexts)
+	}
+}
+
+func TestGetNegotiateContextsLog(t *testing.T) {
+	salt := bytes.Repeat([]byte{0xab}, 32)
+	preauth := append([]byte{1, 0, 32, 0, 1, 0}, salt...)
+	contexts := marshalNegotiateContexts([]negotiateContext{
+		{NegotiateContextPreauthIntegrity, preauth},
+		{NegotiateContextEncryption, []byte{1, 0, 2, 0}},
+		{NegotiateContextSigningCapabilities, []byte{1, 0, 1, 0}},
+		{NegotiateContextTransportCapabilities, []byte{1, 0, 0, 0}},
+		{NegotiateContextNetnameNegotiate, []byte{0x41, 0}},
+	})
+	buf := append(make([]byte, 128), contexts...)
+	negRes := NewNegotiateRes()
+	negRes.DialectRevision = DialectSmb_3_1_1
+	negRes.Reserved = 5
+	negRes.Reserved2 = 128
+
+	log := getNegotiateContextsLog(buf, &negRes)
+	if log == nil || log.Error != "" {
+		t.Fatalf("expected contexts, got %+v", log)
+	}
+	if log.PreauthIntegrity == nil || !bytes.Equal(log.PreauthIntegrity.Salt, salt) || len(log.PreauthIntegrity.HashAlgorithms) != 1 {
+		t.Errorf("bad preauth integrity %+v", log.PreauthIntegrity)
+	}
+	if len(log.Ciphers) != 1 || log.Ciphers[0] != CipherAES128GCM {
+		t.Errorf("bad ciphers %v", log.Ciphers)
+	}
+	if len(log.SigningAlgorithms) != 1 || log.SigningAlgorithms[0] != SigningAESCMAC {
+		t.Errorf("bad signing algorithms %v", log.SigningAlgorithms)
+	}
+	if log.TransportCapabilities == nil || *log.TransportCapabilities != TransportCapabilityAcceptTransportLevelSecurity {
+		t.Errorf("bad transport capabilities %v", log.TransportCapabilities)
+	}
+	if len(log.Contexts) != 5 || log.Contexts[4].Type != NegotiateContextNetnameNegotiate {
+		t.Errorf("bad contexts %+v", log.Contexts)
+	}
+
+	negRes.Reserved = 6
+	if log := getNegotiateContextsLog(buf, &negRes); log == nil || log.Error == "" || len(log.Contexts) != 5 {
+		t.Errorf("expected an error after the contexts present, got %+v", log)
+	}
+
+	negRes.DialectRevision = DialectSmb_2_1
+	if log := getNegotiateContextsLog(buf, &negRes); log != nil {
+		t.Errorf("expected no contexts for SMB 2.1, got %+v", log)
+	}
+}
+
+func TestGetOSVersionLog(t *testing.T) {
+	challenge := ntlmssp.NewChallenge()
+	challenge.NegotiateFlags = ntlmssp.FlgNegNtLm
+	// Windows 10.0 build 19041, NTLMSSP revision 15, as sent on the wire.
+	challenge.Version = binary.LittleEndian.Uint64([]byte{10, 0, 0x61, 0x4a, 0, 0, 0, 15})
+	if v := getOSVersionLog(&challenge); v != nil {
+		t.Errorf("expected no version without FlgNegVersion, got %+v", v)
+	}
+	challenge.NegotiateFlags |= ntlmssp.FlgNegVersion
+	v := getOSVersionLog(&challenge)
+	if v == nil || v.String() != "10.0.19041" || v.NTLMRevision != 15 {
+		t.Errorf("bad version %+v", v)
+	}
+}
+
+func TestGetTargetInfoLog(t *testing.T) {
+	challenge := ntlmssp.NewChallenge()
+	if info := getTargetInfoLog(&challenge); info != nil {
+		t.Errorf("expected no target info, got %+v", info)
+	}
+	utf16le := func(s string) []byte {
+		var ret []byte
+		for _, c := range s {
+			ret = append(ret, byte(c), 0)
+		}
+		return ret
+	}
+	challenge.TargetInfo = &ntlmssp.AvPairSlice{
+		{AvID: ntlmssp.MsvAvNbDomainName, Value: utf16le("CORP")},
+		{AvID: ntlmssp.MsvAvNbComputerName, Value: utf16le("FS01")},
+		{AvID: ntlmssp.MsvAvDnsDomainName, Value: utf16le("corp.example.com")},
+		{AvID: ntlmssp.MsvAvDnsComputerName, Value: utf16le("fs01.corp.example.com")},
+		{AvID: ntlmssp.MsvAvTimestamp, Value: make([]byte, 8)},
+		{AvID: ntlmssp.MsvAvEOL},
+	}
+	expected := TargetInfoLog{
+		NetBIOSComputerName: "FS01",
+		NetBIOSDomainName:   "CORP",
+		DNSComputerName:     "fs01.corp.example.com",
+		DNSDomainName:       "corp.example.com",
+	}
+	if info := getTargetInfoLog(&challenge); info == nil || *info != expected {
+		t.Errorf("got %+v, expected %+v", info, expected)
+	}
+}
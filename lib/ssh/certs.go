@@ -78,14 +78,14 @@ type JsonCertType struct {
 
 type JsonPubKeyWrapper struct {
 	PublicKeyJsonLog
-	Raw         []byte `json:"raw,omitempty"`
+	Raw         []byte `json:"raw,omitempty" zgrab:"raw"`
 	Fingerprint string `json:"fingerprint_sha256"`
 	Algorithm   string `json:"algorithm"`
 }
 
 type JsonSignature struct {
 	Parsed *Signature `json:"parsed,omitempty"`
-	Raw    []byte     `json:"raw,omitempty"`
+	Raw    []byte     `json:"raw,omitempty" zgrab:"raw"`
 	H      []byte     `json:"h,omitempty"`
 }
 
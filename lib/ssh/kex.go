@@ -106,7 +106,7 @@ func (pkLog *PublicKeyJsonLog) AddPublicKey(pubKey PublicKey) bool {
 
 type ServerHostKeyJsonLog struct {
 	PublicKeyJsonLog
-	Raw          []byte `json:"raw"`
+	Raw          []byte `json:"raw" zgrab:"raw"`
 	Algorithm    string `json:"algorithm"`
 	Fingerprint  string `json:"fingerprint_sha256,omitempty"`
 	TrailingData []byte `json:"trailing_data,omitempty"`
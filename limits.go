@@ -0,0 +1,326 @@
+package zgrab2
+
+import (
+	"bufio"
+	"container/heap"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// maxSubnetEntries is the number of per-subnet entries the limiter tracks
+// before pruning entries whose next slot has already passed.
+const maxSubnetEntries = 64 * 1024
+
+// rateLimiter spaces out the intake of targets so that at most rate targets
+// are started per second overall, and at most subnetRate per second in any
+// single subnet.
+type rateLimiter struct {
+	mutex sync.Mutex
+
+	// rate is the maximum number of targets per second (0 = unlimited).
+	rate int
+
+	// subnetRate is the maximum number of targets per second in a single
+	// subnet (0 = unlimited).
+	subnetRate int
+
+	// subnetPrefix and subnetPrefixV6 are the prefix lengths defining a
+	// subnet for IPv4 and IPv6 targets respectively.
+	subnetPrefix   int
+	subnetPrefixV6 int
+
+	next       time.Time
+	subnetNext map[string]time.Time
+}
+
+var limits = &rateLimiter{
+	subnetPrefix:   24,
+	subnetPrefixV6: 48,
+	subnetNext:     make(map[string]time.Time),
+}
+
+// subnetKey returns the subnet containing the target, or "" if it has no IP.
+func (l *rateLimiter) subnetKey(target *ScanTarget) string {
+	if target.IP == nil {
+		return ""
+	}
+	if ip4 := target.IP.To4(); ip4 != nil {
+		return ip4.Mask(net.CIDRMask(l.subnetPrefix, 32)).String()
+	}
+	return target.IP.Mask(net.CIDRMask(l.subnetPrefixV6, 128)).String()
+}
+
+// subnetSlot returns the time at which the target may be started as far as
+// its subnet's rate is concerned, and reserves that slot in the subnet.
+func (l *rateLimiter) subnetSlot(target *ScanTarget) time.Time {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	now := time.Now()
+	if l.subnetRate <= 0 {
+		return now
+	}
+	key := l.subnetKey(target)
+	if key == "" {
+		return now
+	}
+	slot := now
+	if next, ok := l.subnetNext[key]; ok && next.After(slot) {
+		slot = next
+	}
+	if len(l.subnetNext) >= maxSubnetEntries {
+		for k, v := range l.subnetNext {
+			if v.Before(now) {
+				delete(l.subnetNext, k)
+			}
+		}
+	}
+	l.subnetNext[key] = slot.Add(time.Second / time.Duration(l.subnetRate))
+	return slot
+}
+
+// wait blocks until the overall rate allows another target to be started.
+func (l *rateLimiter) wait() {
+	l.mutex.Lock()
+	slot := time.Now()
+	if l.rate > 0 {
+		if l.next.After(slot) {
+			slot = l.next
+		}
+		l.next = slot.Add(time.Second / time.Duration(l.rate))
+	}
+	l.mutex.Unlock()
+	if delay := time.Until(slot); delay > 0 {
+		time.Sleep(delay)
+	}
+}
+
+// maxHeldTargets is the number of targets forward holds back for their
+// subnet's rate before it waits for the earliest of them.
+const maxHeldTargets = 64 * 1024
+
+// heldTarget is a target held back until its subnet's slot.
+type heldTarget struct {
+	target ScanTarget
+	slot   time.Time
+}
+
+// heldTargets is a heap of held targets, earliest slot first.
+type heldTargets []heldTarget
+
+func (h heldTargets) Len() int            { return len(h) }
+func (h heldTargets) Less(i, j int) bool  { return h[i].slot.Before(h[j].slot) }
+func (h heldTargets) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *heldTargets) Push(x interface{}) { *h = append(*h, x.(heldTarget)) }
+
+func (h *heldTargets) Pop() interface{} {
+	old := *h
+	ret := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return ret
+}
+
+// slotTimer fires at the earliest held target's slot. It reuses a single
+// timer, which is only reset when the earliest slot changes.
+type slotTimer struct {
+	timer *time.Timer
+
+	// slot is the slot the timer is set for, or zero if it is not set or its
+	// firing has been received.
+	slot time.Time
+}
+
+func newSlotTimer() *slotTimer {
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+	return &slotTimer{timer: timer}
+}
+
+// wake returns a channel that fires at the earliest held target's slot, or
+// nil if none are held. Call fired after receiving from it.
+func (t *slotTimer) wake(held heldTargets) <-chan time.Time {
+	if len(held) == 0 {
+		return nil
+	}
+	if slot := held[0].slot; !slot.Equal(t.slot) {
+		if !t.timer.Stop() {
+			// Discard a firing for an earlier slot that was not received.
+			select {
+			case <-t.timer.C:
+			default:
+			}
+		}
+		t.timer.Reset(time.Until(slot))
+		t.slot = slot
+	}
+	return t.timer.C
+}
+
+// fired records that the timer's firing was received.
+func (t *slotTimer) fired() {
+	t.slot = time.Time{}
+}
+
+// forward passes the targets received from in on to out until in is closed
+// and every held target has been sent, or stop is closed. Each target waits
+// for the overall rate; one whose subnet is over its own rate is held back
+// until its slot instead, so that it does not keep targets in other subnets
+// waiting. sent is called for each target passed on, and dropped for each
+// target received but never passed on because of stop.
+func (l *rateLimiter) forward(in <-chan ScanTarget, out chan<- ScanTarget, stop <-chan struct{}, sent, dropped func(*ScanTarget)) {
+	var held heldTargets
+	timer := newSlotTimer()
+	defer timer.timer.Stop()
+	defer func() {
+		for i := range held {
+			dropped(&held[i].target)
+		}
+	}()
+	send := func(target ScanTarget) bool {
+		l.wait()
+		select {
+		case out <- target:
+			sent(&target)
+			return true
+		case <-stop:
+			dropped(&target)
+			return false
+		}
+	}
+	for in != nil || len(held) > 0 {
+		select {
+		case target, ok := <-in:
+			if !ok {
+				in = nil
+				continue
+			}
+			if slot := l.subnetSlot(&target); slot.After(time.Now()) {
+				heap.Push(&held, heldTarget{target: target, slot: slot})
+				if len(held) <= maxHeldTargets {
+					continue
+				}
+				select {
+				case <-timer.wake(held):
+					timer.fired()
+				case <-stop:
+					return
+				}
+				target = heap.Pop(&held).(heldTarget).target
+			}
+			if !send(target) {
+				return
+			}
+		case <-timer.wake(held):
+			timer.fired()
+			if !send(heap.Pop(&held).(heldTarget).target) {
+				return
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// set updates a single limit by name.
+func (l *rateLimiter) set(name string, value int) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	switch name {
+	case "rate":
+		if value < 0 {
+			return fmt.Errorf("rate must be non-negative, given %d", value)
+		}
+		l.rate = value
+	case "subnet-rate":
+		if value < 0 {
+			return fmt.Errorf("subnet-rate must be non-negative, given %d", value)
+		}
+		l.subnetRate = value
+	case "subnet-prefix":
+		if value < 0 || value > 32 {
+			return fmt.Errorf("subnet-prefix must be in the range [0,32], given %d", value)
+		}
+		l.subnetPrefix = value
+		l.subnetNext = make(map[string]time.Time)
+	case "subnet-prefix-v6":
+		if value < 0 || value > 128 {
+			return fmt.Errorf("subnet-prefix-v6 must be in the range [0,128], given %d", value)
+		}
+		l.subnetPrefixV6 = value
+		l.subnetNext = make(map[string]time.Time)
+	default:
+		return fmt.Errorf("unknown limit %q", name)
+	}
+	return nil
+}
+
+// SetLimit changes one of the scan's limits while it is running. Recognized
+// names are rate, subnet-rate, subnet-prefix, subnet-prefix-v6 and senders.
+func SetLimit(name string, value int) error {
+	if name == "senders" {
+		return SetSenders(value)
+	}
+	return limits.set(name, value)
+}
+
+// applyLimitLine parses a line of the form "name = value" (or "name value")
+// and applies it with SetLimit. Empty lines and # comments are ignored.
+func applyLimitLine(line string) error {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return nil
+	}
+	fields := strings.Fields(strings.Replace(line, "=", " ", 1))
+	if len(fields) != 2 {
+		return fmt.Errorf("expected 'name = value', got %q", line)
+	}
+	value, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return fmt.Errorf("invalid value for %s: %v", fields[0], err)
+	}
+	return SetLimit(fields[0], value)
+}
+
+// LoadLimitsFile reads limits from the given file and applies them. Settings
+// that fail to apply are logged and skipped.
+func LoadLimitsFile(fileName string) error {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		if err := applyLimitLine(scanner.Text()); err != nil {
+			log.Errorf("%s:%d: %v", fileName, lineNo, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// reloadLimitsOnHangup re-reads the limits file every time the process
+// receives SIGHUP.
+func reloadLimitsOnHangup(fileName string) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	reloadLimits(fileName, hangup)
+}
+
+// reloadLimits re-reads the limits file for each signal received, until
+// signals is closed.
+func reloadLimits(fileName string, signals <-chan os.Signal) {
+	for sig := range signals {
+		log.Infof("received %s, reloading limits from %s", sig, fileName)
+		if err := LoadLimitsFile(fileName); err != nil {
+			log.Errorf("could not reload limits: %v", err)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package zgrab2
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterSubnetSpacing(t *testing.T) {
+	l := &rateLimiter{
+		subnetRate:     10,
+		subnetPrefix:   24,
+		subnetPrefixV6: 48,
+		subnetNext:     make(map[string]time.Time),
+	}
+	a := ScanTarget{IP: net.ParseIP("10.0.0.1")}
+	b := ScanTarget{IP: net.ParseIP("10.0.0.2")}
+	c := ScanTarget{IP: net.ParseIP("10.0.1.1")}
+
+	first := l.subnetSlot(&a)
+	second := l.subnetSlot(&b)
+	if gap := second.Sub(first); gap < 100*time.Millisecond {
+		t.Errorf("expected targets in the same subnet to be spaced by 100ms, got %s", gap)
+	}
+	if other := l.subnetSlot(&c); other.After(first.Add(50 * time.Millisecond)) {
+		t.Errorf("expected target in a different subnet not to be delayed, got %s", other.Sub(first))
+	}
+}
+
+func TestRateLimiterForwardMixedSubnets(t *testing.T) {
+	l := &rateLimiter{
+		rate:           1000,
+		subnetRate:     5,
+		subnetPrefix:   24,
+		subnetPrefixV6: 48,
+		subnetNext:     make(map[string]time.Time),
+	}
+	// Input sorted by subnet: the second subnet's targets must not wait
+	// behind the first's.
+	ips := []string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.1.1", "10.0.1.2"}
+	in := make(chan ScanTarget, len(ips))
+	for _, ip := range ips {
+		in <- ScanTarget{IP: net.ParseIP(ip)}
+	}
+	close(in)
+	out := make(chan ScanTarget)
+	done := make(chan struct{})
+	var sent, dropped int
+	go func() {
+		defer close(done)
+		l.forward(in, out, make(chan struct{}), func(*ScanTarget) { sent++ }, func(*ScanTarget) { dropped++ })
+		close(out)
+	}()
+
+	start := time.Now()
+	var order []string
+	arrived := make(map[string]time.Duration)
+	for target := range out {
+		order = append(order, target.IP.String())
+		arrived[target.IP.String()] = time.Since(start)
+	}
+	<-done
+	if sent != len(ips) || dropped != 0 {
+		t.Errorf("expected %d targets sent and none dropped, got %d and %d", len(ips), sent, dropped)
+	}
+	if len(order) != len(ips) {
+		t.Fatalf("expected %d targets, got %v", len(ips), order)
+	}
+	if d := arrived["10.0.1.1"]; d > 100*time.Millisecond {
+		t.Errorf("first target of the second subnet delayed by %s", d)
+	}
+	if arrived["10.0.1.2"] > arrived["10.0.0.3"] {
+		t.Errorf("second subnet waited behind the first: %v", order)
+	}
+	if d := arrived["10.0.0.3"]; d < 350*time.Millisecond {
+		t.Errorf("expected the first subnet's third target after 400ms, got %s", d)
+	}
+	for _, pair := range [][2]string{{"10.0.0.1", "10.0.0.2"}, {"10.0.0.2", "10.0.0.3"}, {"10.0.1.1", "10.0.1.2"}} {
+		if arrived[pair[0]] > arrived[pair[1]] {
+			t.Errorf("targets in a subnet reordered: %v", order)
+		}
+	}
+}
+
+func TestRateLimiterForwardStop(t *testing.T) {
+	l := &rateLimiter{
+		subnetRate:     1,
+		subnetPrefix:   24,
+		subnetPrefixV6: 48,
+		subnetNext:     make(map[string]time.Time),
+	}
+	in := make(chan ScanTarget, 2)
+	in <- ScanTarget{IP: net.ParseIP("10.0.0.1")}
+	in <- ScanTarget{IP: net.ParseIP("10.0.0.2")}
+	out := make(chan ScanTarget, 2)
+	stop := make(chan struct{})
+	var sent, dropped int
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		l.forward(in, out, stop, func(*ScanTarget) { sent++ }, func(*ScanTarget) { dropped++ })
+	}()
+	<-out
+	close(stop)
+	<-done
+	if sent != 1 || dropped != 1 {
+		t.Errorf("expected 1 target sent and the held one dropped, got %d and %d", sent, dropped)
+	}
+}
+
+func TestApplyLimitLine(t *testing.T) {
+	defer func(rate int) { limits.rate = rate }(limits.rate)
+	tests := []struct {
+		line    string
+		success bool
+	}{
+		{line: "rate = 100", success: true},
+		{line: "rate 200", success: true},
+		{line: "# comment", success: true},
+		{line: "", success: true},
+		{line: "rate = -1", success: false},
+		{line: "rate = fast", success: false},
+		{line: "unknown = 1", success: false},
+		{line: "rate", success: false},
+	}
+	for _, test := range tests {
+		err := applyLimitLine(test.line)
+		if (err == nil) != test.success {
+			t.Errorf("applyLimitLine(%q): expected success=%v, got error %v", test.line, test.success, err)
+		}
+	}
+	if limits.rate != 200 {
+		t.Errorf("expected rate 200, got %d", limits.rate)
+	}
+}
+
+func TestLoadLimitsFile(t *testing.T) {
+	defer withControlState()()
+	dir, err := ioutil.TempDir("", "zgrab2-limits")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "limits")
+	// Invalid lines are skipped, and the rest applied.
+	data := "# limits\nrate = 100\nsubnet-rate = fast\nsubnet-prefix 16\n\nsenders = 8\n"
+	if err := ioutil.WriteFile(file, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadLimitsFile(file); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if limits.rate != 100 || limits.subnetRate != 0 || limits.subnetPrefix != 16 || config.Senders != 8 {
+		t.Errorf("unexpected limits: rate %d, subnet-rate %d, subnet-prefix %d, senders %d",
+			limits.rate, limits.subnetRate, limits.subnetPrefix, config.Senders)
+	}
+	if err := LoadLimitsFile(filepath.Join(dir, "missing")); err == nil {
+		t.Error("expected an error for a missing file")
+	}
+}
+
+func TestReloadLimitsOnHangup(t *testing.T) {
+	defer withControlState()()
+	dir, err := ioutil.TempDir("", "zgrab2-limits")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "limits")
+	if err := ioutil.WriteFile(file, []byte("rate = 10\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		reloadLimits(file, hangup)
+	}()
+	defer func() {
+		signal.Stop(hangup)
+		close(hangup)
+		<-done
+	}()
+
+	rate := func() int {
+		limits.mutex.Lock()
+		defer limits.mutex.Unlock()
+		return limits.rate
+	}
+	syscall.Kill(os.Getpid(), syscall.SIGHUP)
+	waitUntil(t, func() bool { return rate() == 10 }, "SIGHUP loads the limits")
+	if err := ioutil.WriteFile(file, []byte("rate = 20\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	syscall.Kill(os.Getpid(), syscall.SIGHUP)
+	waitUntil(t, func() bool { return rate() == 20 }, "SIGHUP reloads the changed limits")
+}
+
+func TestSlotTimer(t *testing.T) {
+	timer := newSlotTimer()
+	defer timer.timer.Stop()
+	if timer.wake(nil) != nil {
+		t.Error("expected no channel with no held targets")
+	}
+	now := time.Now()
+	held := heldTargets{{slot: now.Add(time.Hour)}}
+	c := timer.wake(held)
+	// An earlier slot resets the same timer.
+	held = heldTargets{{slot: now.Add(20 * time.Millisecond)}, held[0]}
+	if timer.wake(held) != c {
+		t.Error("expected the timer to be reused")
+	}
+	select {
+	case <-c:
+		timer.fired()
+	case <-time.After(5 * time.Second):
+		t.Fatal("timer did not fire at the earlier slot")
+	}
+	// The timer is set again for the next slot, rather than firing at once.
+	held = held[1:]
+	select {
+	case <-timer.wake(held):
+		t.Error("timer fired before the next slot")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
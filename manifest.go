@@ -0,0 +1,248 @@
+package zgrab2
+
+import (
+	"bufio"
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"os"
+)
+
+// ManifestChunk is a line of the --manifest-file describing a chunk of the
+// output: a run of consecutive results.
+type ManifestChunk struct {
+	// Chunk is the chunk's index, from 0.
+	Chunk int `json:"chunk"`
+
+	// Offset is the position in the output of the chunk's first byte.
+	Offset uint64 `json:"offset"`
+
+	// Lines and Bytes are the length of the chunk, including the newline
+	// ending each line.
+	Lines uint64 `json:"lines"`
+	Bytes uint64 `json:"bytes"`
+
+	// SHA256 is the hex SHA-256 digest of the chunk.
+	SHA256 string `json:"sha256"`
+}
+
+// ManifestSummary is the last line of the --manifest-file, written once all
+// results have been.
+type ManifestSummary struct {
+	// Output is the --output-file the manifest describes.
+	Output string `json:"output"`
+
+	Chunks int    `json:"chunks"`
+	Lines  uint64 `json:"lines"`
+	Bytes  uint64 `json:"bytes"`
+
+	// MerkleRoot is the hex root of a Merkle tree over the chunks' digests,
+	// built as in RFC 6962, section 2.1: leaves are hashed as SHA-256(0x00
+	// || digest), and nodes as SHA-256(0x01 || left || right).
+	MerkleRoot string `json:"merkle_root"`
+
+	// Signature is the base64 signature of the Merkle root by the
+	// --manifest-key, and PublicKey the base64 DER (PKIX) public key to
+	// check it with. Ed25519 keys sign the root itself; RSA (PKCS #1 v1.5)
+	// and ECDSA (ASN.1) keys sign it as a SHA-256 digest.
+	SignatureAlgorithm string `json:"signature_algorithm,omitempty"`
+	Signature          string `json:"signature,omitempty"`
+	PublicKey          string `json:"public_key,omitempty"`
+}
+
+// manifestWriter implements --manifest-file, hashing the results in chunks
+// of --manifest-chunk-lines lines as they are written, so that output
+// carried away from the scanning host can be checked piece by piece.
+type manifestWriter struct {
+	file *os.File
+	out  *bufio.Writer
+
+	// chunkLines is the number of lines after which a chunk is ended; a
+	// chunk always ends with a whole result.
+	chunkLines uint64
+	signer     crypto.Signer
+
+	chunk   hash.Hash
+	current ManifestChunk
+	digests [][]byte
+	lines   uint64
+	bytes   uint64
+}
+
+var manifest *manifestWriter
+
+// newManifestWriter returns a manifestWriter writing to the given file, and
+// signing with the PEM PKCS #8 private key in keyFile, if one is given.
+func newManifestWriter(path string, chunkLines int, keyFile string) (*manifestWriter, error) {
+	if chunkLines <= 0 {
+		return nil, fmt.Errorf("manifest chunk lines must be positive, given %d", chunkLines)
+	}
+	ret := &manifestWriter{chunkLines: uint64(chunkLines), chunk: sha256.New()}
+	if keyFile != "" {
+		var err error
+		if ret.signer, err = loadManifestKey(keyFile); err != nil {
+			return nil, err
+		}
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	ret.file, ret.out = file, bufio.NewWriter(file)
+	return ret, nil
+}
+
+// loadManifestKey reads a PEM PKCS #8 private key.
+func loadManifestKey(path string) (crypto.Signer, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM block in %s", path)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("%T keys cannot sign", key)
+	}
+	return signer, nil
+}
+
+// add hashes a result (one or more lines) written to the output, ending the
+// chunk if it has reached --manifest-chunk-lines.
+func (m *manifestWriter) add(result []byte) error {
+	lines := uint64(bytes.Count(result, []byte("\n"))) + 1
+	m.chunk.Write(result)
+	m.chunk.Write([]byte("\n"))
+	m.current.Lines += lines
+	m.current.Bytes += uint64(len(result)) + 1
+	if m.current.Lines >= m.chunkLines {
+		return m.endChunk()
+	}
+	return nil
+}
+
+// endChunk writes the line describing the current chunk, if it is not
+// empty, and starts a new one.
+func (m *manifestWriter) endChunk() error {
+	if m.current.Lines == 0 {
+		return nil
+	}
+	digest := m.chunk.Sum(nil)
+	m.current.Chunk = len(m.digests)
+	m.current.Offset = m.bytes
+	m.current.SHA256 = hex.EncodeToString(digest)
+	if err := m.writeLine(&m.current); err != nil {
+		return err
+	}
+	m.digests = append(m.digests, digest)
+	m.lines += m.current.Lines
+	m.bytes += m.current.Bytes
+	m.current = ManifestChunk{}
+	m.chunk.Reset()
+	return nil
+}
+
+func (m *manifestWriter) writeLine(v interface{}) error {
+	line, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	m.out.Write(line)
+	m.out.WriteByte('\n')
+	// Flush each line, so that the manifest covers what was written if the
+	// scan is cut short.
+	return m.out.Flush()
+}
+
+// close ends the last chunk, writes the summary with the Merkle root and its
+// signature, and closes the manifest file.
+func (m *manifestWriter) close(output string) error {
+	if err := m.endChunk(); err != nil {
+		return err
+	}
+	root := merkleRoot(m.digests)
+	summary := ManifestSummary{
+		Output:     output,
+		Chunks:     len(m.digests),
+		Lines:      m.lines,
+		Bytes:      m.bytes,
+		MerkleRoot: hex.EncodeToString(root),
+	}
+	if m.signer != nil {
+		if err := signManifest(&summary, m.signer, root); err != nil {
+			return err
+		}
+	}
+	if err := m.writeLine(&summary); err != nil {
+		return err
+	}
+	return m.file.Close()
+}
+
+// signManifest signs the Merkle root.
+func signManifest(summary *ManifestSummary, signer crypto.Signer, root []byte) error {
+	var opts crypto.SignerOpts = crypto.SHA256
+	switch signer.Public().(type) {
+	case ed25519.PublicKey:
+		opts = crypto.Hash(0)
+		summary.SignatureAlgorithm = "ed25519"
+	case *rsa.PublicKey:
+		summary.SignatureAlgorithm = "rsa-pkcs1-sha256"
+	case *ecdsa.PublicKey:
+		summary.SignatureAlgorithm = "ecdsa-sha256"
+	default:
+		return fmt.Errorf("%T keys are not supported", signer.Public())
+	}
+	signature, err := signer.Sign(rand.Reader, root, opts)
+	if err != nil {
+		return err
+	}
+	public, err := x509.MarshalPKIXPublicKey(signer.Public())
+	if err != nil {
+		return err
+	}
+	summary.Signature = base64.StdEncoding.EncodeToString(signature)
+	summary.PublicKey = base64.StdEncoding.EncodeToString(public)
+	return nil
+}
+
+// merkleRoot returns the root of the Merkle tree with the given leaves
+// (RFC 6962, section 2.1).
+func merkleRoot(leaves [][]byte) []byte {
+	switch len(leaves) {
+	case 0:
+		sum := sha256.Sum256(nil)
+		return sum[:]
+	case 1:
+		sum := sha256.Sum256(append([]byte{0}, leaves[0]...))
+		return sum[:]
+	}
+	// Split at the largest power of two smaller than the number of leaves.
+	k := 1
+	for k*2 < len(leaves) {
+		k *= 2
+	}
+	h := sha256.New()
+	h.Write([]byte{1})
+	h.Write(merkleRoot(leaves[:k]))
+	h.Write(merkleRoot(leaves[k:]))
+	return h.Sum(nil)
+}
@@ -0,0 +1,100 @@
+package zgrab2
+
+import (
+	"bufio"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMerkleRoot(t *testing.T) {
+	leaf := func(i byte) []byte {
+		sum := sha256.Sum256([]byte{i})
+		return sum[:]
+	}
+	hashNode := func(prefix byte, parts ...[]byte) []byte {
+		h := sha256.New()
+		h.Write([]byte{prefix})
+		for _, part := range parts {
+			h.Write(part)
+		}
+		return h.Sum(nil)
+	}
+	a, b, c := hashNode(0, leaf(0)), hashNode(0, leaf(1)), hashNode(0, leaf(2))
+	expected := hashNode(1, hashNode(1, a, b), c)
+	if root := merkleRoot([][]byte{leaf(0), leaf(1), leaf(2)}); hex.EncodeToString(root) != hex.EncodeToString(expected) {
+		t.Errorf("wrong root %x, expected %x", root, expected)
+	}
+}
+
+func TestManifestWriter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "manifest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(dir, "key.pem")
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "manifest.jsonl")
+	m, err := newManifestWriter(path, 2, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The second result has two lines, so the first chunk ends after it.
+	for _, result := range []string{`{"ip":"1"}`, "{\"ip\":\"2\"}\n{\"ip\":\"3\"}", `{"ip":"4"}`} {
+		if err := m.add([]byte(result)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m.close("out.json"); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	var chunks []ManifestChunk
+	var summary ManifestSummary
+	for scanner.Scan() {
+		var chunk ManifestChunk
+		if json.Unmarshal(scanner.Bytes(), &chunk); chunk.SHA256 != "" {
+			chunks = append(chunks, chunk)
+		} else if err := json.Unmarshal(scanner.Bytes(), &summary); err != nil {
+			t.Fatal(err)
+		}
+	}
+	first := sha256.Sum256([]byte("{\"ip\":\"1\"}\n{\"ip\":\"2\"}\n{\"ip\":\"3\"}\n"))
+	if len(chunks) != 2 || chunks[0].Lines != 3 || chunks[0].SHA256 != hex.EncodeToString(first[:]) || chunks[1].Offset != chunks[0].Bytes || chunks[1].Lines != 1 {
+		t.Fatalf("wrong chunks %+v", chunks)
+	}
+	if summary.Output != "out.json" || summary.Chunks != 2 || summary.Lines != 4 || summary.SignatureAlgorithm != "ed25519" {
+		t.Fatalf("wrong summary %+v", summary)
+	}
+	root, _ := hex.DecodeString(summary.MerkleRoot)
+	signature, _ := base64.StdEncoding.DecodeString(summary.Signature)
+	if !ed25519.Verify(public, root, signature) {
+		t.Error("signature does not verify")
+	}
+}
@@ -0,0 +1,397 @@
+// Package acme provides a zgrab2 module that probes the responders servers
+// run for ACME (RFC 8555) certificate issuance, to study how exposed and how
+// well configured issuance automation is.
+// Default Port: 443 (TCP)
+//
+// The module makes a TLS handshake offering only the acme-tls/1 ALPN
+// protocol, as a CA validating a tls-alpn-01 challenge (RFC 8737) does, and
+// checks the certificate of a server that selects it. It then fetches paths
+// under /.well-known/acme-challenge/ over HTTP on --http-port, as a CA
+// validating an http-01 challenge does, starting with a made-up token. A
+// server that answers the made-up token with a key authorization for it is
+// a stateless responder, which reveals the thumbprint of its ACME account
+// key to anyone.
+package acme
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/zmap/zgrab2"
+)
+
+// acmeTLSProtocol is the ALPN protocol of tls-alpn-01 challenges.
+const acmeTLSProtocol = "acme-tls/1"
+
+// challengeDirectory is where http-01 challenge responses are served.
+const challengeDirectory = "/.well-known/acme-challenge/"
+
+// oidACMEIdentifier is the acmeIdentifier certificate extension, holding the
+// SHA-256 digest of the key authorization in a tls-alpn-01 challenge
+// response.
+var oidACMEIdentifier = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 31}
+
+// Flags holds the command-line configuration for the acme module.
+type Flags struct {
+	zgrab2.BaseFlags
+	zgrab2.TLSFlags
+
+	HTTPPort    uint   `long:"http-port" default:"80" description:"Port to fetch the http-01 challenge paths from, 0 to skip them"`
+	Paths       string `long:"paths" description:"Comma-separated paths to also fetch, relative to /.well-known/acme-challenge/"`
+	SkipTLSALPN bool   `long:"skip-tls-alpn" description:"Do not make the tls-alpn-01 handshake"`
+	UserAgent   string `long:"user-agent" default:"Mozilla/5.0 zgrab/0.x" description:"Set a custom user agent"`
+	MaxBodySize int    `long:"max-body-size" default:"1024" description:"Maximum number of bytes of each response body recorded"`
+	Verbose     bool   `long:"verbose" description:"More verbose logging, include debug fields in the scan results"`
+}
+
+// Module implements the zgrab2.Module interface.
+type Module struct {
+}
+
+// Scanner implements the zgrab2.Scanner interface.
+type Scanner struct {
+	config *Flags
+	paths  []string
+}
+
+// TLSALPN is the result of the tls-alpn-01 handshake.
+type TLSALPN struct {
+	// Protocol is the ALPN protocol the server selected, if any.
+	Protocol string `json:"protocol,omitempty"`
+
+	// Negotiated is true if the server selected acme-tls/1, i.e. it is
+	// answering a tls-alpn-01 challenge, or is ready to.
+	Negotiated bool `json:"negotiated"`
+
+	// ACMEIdentifier is the key authorization digest in the certificate's
+	// acmeIdentifier extension, if it has one.
+	ACMEIdentifier []byte `json:"acme_identifier,omitempty"`
+
+	// Names are the DNS names in the certificate.
+	Names []string `json:"names,omitempty"`
+
+	// SelfSigned is true if the certificate is signed by its own key, as
+	// challenge certificates are.
+	SelfSigned bool `json:"self_signed,omitempty"`
+
+	// Issues are the ways the server's answer differs from what RFC 8737
+	// requires of a challenge response.
+	Issues []string `json:"issues,omitempty"`
+
+	// TLS is the log of the handshake.
+	TLS *zgrab2.TLSLog `json:"tls,omitempty"`
+
+	// Error is the reason the handshake failed, if it did.
+	Error string `json:"error,omitempty"`
+}
+
+// Challenge is the response to a request for an http-01 challenge path.
+type Challenge struct {
+	Path        string `json:"path"`
+	StatusCode  int    `json:"status_code,omitempty"`
+	Location    string `json:"location,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+	Body        string `json:"body,omitempty"`
+
+	// Error is the reason the request failed, if it did.
+	Error string `json:"error,omitempty"`
+}
+
+// Results is the output of the acme module.
+type Results struct {
+	// TLSALPN is the result of the tls-alpn-01 handshake.
+	TLSALPN *TLSALPN `json:"tls_alpn,omitempty"`
+
+	// Challenges are the responses to the http-01 challenge paths, the
+	// first being the made-up token.
+	Challenges []*Challenge `json:"challenges,omitempty"`
+
+	// KeyAuthorizationReflected is true if the server answered the made-up
+	// token with a key authorization for it.
+	KeyAuthorizationReflected bool `json:"key_authorization_reflected,omitempty"`
+
+	// AccountThumbprint is the ACME account key thumbprint in the reflected
+	// key authorization.
+	AccountThumbprint string `json:"account_thumbprint,omitempty"`
+}
+
+// RegisterModule registers the zgrab2 module.
+func RegisterModule() {
+	var module Module
+	_, err := zgrab2.AddCommand("acme", "ACME challenge responders", "Probe tls-alpn-01 and http-01 ACME challenge responders", 443, &module)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// NewFlags returns a default Flags object.
+func (module *Module) NewFlags() interface{} {
+	return new(Flags)
+}
+
+// NewScanner returns a new Scanner instance.
+func (module *Module) NewScanner() zgrab2.Scanner {
+	return new(Scanner)
+}
+
+// Validate checks that the flags are valid.
+// On success, returns nil.
+// On failure, returns an error instance describing the error.
+func (flags *Flags) Validate(args []string) error {
+	if flags.SkipTLSALPN && flags.HTTPPort == 0 {
+		return errors.New("--skip-tls-alpn with --http-port=0 leaves nothing to probe")
+	}
+	if flags.HTTPPort > 0xffff {
+		return fmt.Errorf("invalid --http-port %d", flags.HTTPPort)
+	}
+	if flags.MaxBodySize < 0 {
+		return errors.New("--max-body-size must not be negative")
+	}
+	return nil
+}
+
+// Help returns the module's help string.
+func (flags *Flags) Help() string {
+	return ""
+}
+
+// Init initializes the Scanner.
+func (scanner *Scanner) Init(flags zgrab2.ScanFlags) error {
+	f, ok := flags.(*Flags)
+	if !ok {
+		return zgrab2.ErrMismatchedFlags
+	}
+	scanner.config = f
+	if f.Verbose {
+		log.SetLevel(log.DebugLevel)
+	}
+	scanner.paths = nil
+	for _, path := range strings.Split(f.Paths, ",") {
+		if path = strings.TrimLeft(strings.TrimSpace(path), "/"); path != "" {
+			scanner.paths = append(scanner.paths, challengeDirectory+path)
+		}
+	}
+	return nil
+}
+
+// InitPerSender initializes the scanner for a given sender.
+func (scanner *Scanner) InitPerSender(senderID int) error {
+	return nil
+}
+
+// GetName returns the Scanner name defined in the Flags.
+func (scanner *Scanner) GetName() string {
+	return scanner.config.Name
+}
+
+// GetTrigger returns the Trigger defined in the Flags.
+func (scanner *Scanner) GetTrigger() string {
+	return scanner.config.Trigger
+}
+
+// Protocol returns the protocol identifier of the scan.
+func (scanner *Scanner) Protocol() string {
+	return "acme"
+}
+
+// GetPort returns the port being scanned.
+func (scanner *Scanner) GetPort() uint {
+	return scanner.config.Port
+}
+
+// Scan makes the tls-alpn-01 handshake and fetches the http-01 challenge
+// paths. It fails only if neither the handshake nor any request reached
+// the server.
+func (scanner *Scanner) Scan(target zgrab2.ScanTarget) (zgrab2.ScanStatus, interface{}, error) {
+	result := new(Results)
+	var firstErr error
+	reached := false
+	if !scanner.config.SkipTLSALPN {
+		var err error
+		result.TLSALPN, err = scanner.tlsALPN(&target)
+		if err != nil {
+			firstErr = err
+		}
+		reached = result.TLSALPN.TLS != nil
+	}
+	if scanner.config.HTTPPort != 0 {
+		token, err := newToken()
+		if err != nil {
+			return zgrab2.SCAN_UNKNOWN_ERROR, nil, err
+		}
+		for _, path := range append([]string{challengeDirectory + token, challengeDirectory}, scanner.paths...) {
+			challenge, err := scanner.fetch(&target, path)
+			result.Challenges = append(result.Challenges, challenge)
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				// The port is closed, or not speaking HTTP.
+				break
+			}
+			reached = true
+		}
+		if len(result.Challenges) > 0 {
+			result.AccountThumbprint = reflectedThumbprint(token, result.Challenges[0])
+			result.KeyAuthorizationReflected = result.AccountThumbprint != ""
+		}
+	}
+	if !reached {
+		return zgrab2.TryGetScanStatus(firstErr), result, firstErr
+	}
+	return zgrab2.SCAN_SUCCESS, result, nil
+}
+
+// tlsALPN makes a TLS handshake offering only acme-tls/1, and checks the
+// certificate the server sends. The error is returned if there was no
+// ServerHello.
+func (scanner *Scanner) tlsALPN(target *zgrab2.ScanTarget) (*TLSALPN, error) {
+	ret := new(TLSALPN)
+	tlsFlags := scanner.config.TLSFlags
+	tlsFlags.NextProtos = acmeTLSProtocol
+	conn, err := target.OpenTLS(&scanner.config.BaseFlags, &tlsFlags)
+	if conn != nil {
+		defer conn.Close()
+	}
+	if err != nil {
+		ret.Error = err.Error()
+	}
+	if conn == nil || conn.GetLog().HandshakeLog == nil || conn.GetLog().HandshakeLog.ServerHello == nil {
+		return ret, err
+	}
+	ret.TLS = conn.GetLog()
+	handshake := ret.TLS.HandshakeLog
+	ret.Protocol = handshake.ServerHello.AlpnProtocol
+	ret.Negotiated = ret.Protocol == acmeTLSProtocol
+	serverName := tlsFlags.ServerName
+	if serverName == "" {
+		serverName = target.Domain
+	}
+	if handshake.ServerCertificates != nil {
+		ret.check(handshake.ServerCertificates.Certificate.Raw, serverName)
+	}
+	return ret, nil
+}
+
+// check records the certificate's acmeIdentifier and names, and the ways it
+// is not a valid tls-alpn-01 challenge response for the server name.
+func (ret *TLSALPN) check(raw []byte, serverName string) {
+	cert, err := x509.ParseCertificate(raw)
+	if err != nil {
+		if ret.Negotiated {
+			ret.Issues = append(ret.Issues, "unparseable certificate")
+		}
+		return
+	}
+	ret.Names = cert.DNSNames
+	ret.SelfSigned = bytes.Equal(cert.RawIssuer, cert.RawSubject) && cert.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature) == nil
+	critical := false
+	for _, ext := range cert.Extensions {
+		if ext.Id.Equal(oidACMEIdentifier) {
+			var digest []byte
+			if _, err := asn1.Unmarshal(ext.Value, &digest); err != nil || len(digest) != 32 {
+				ret.Issues = append(ret.Issues, "acmeIdentifier is not a SHA-256 digest")
+			}
+			ret.ACMEIdentifier = digest
+			critical = ext.Critical
+		}
+	}
+	if !ret.Negotiated {
+		if ret.ACMEIdentifier != nil {
+			ret.Issues = append(ret.Issues, "challenge certificate sent without acme-tls/1")
+		}
+		return
+	}
+	if ret.ACMEIdentifier == nil {
+		ret.Issues = append(ret.Issues, "no acmeIdentifier extension")
+	} else if !critical {
+		ret.Issues = append(ret.Issues, "acmeIdentifier extension not critical")
+	}
+	if len(cert.DNSNames) != 1 || (serverName != "" && !strings.EqualFold(cert.DNSNames[0], serverName)) {
+		ret.Issues = append(ret.Issues, "certificate does not name only the server name")
+	}
+}
+
+// newToken returns a random challenge token, like those ACME servers issue.
+func newToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// reflectedThumbprint returns the account key thumbprint in a key
+// authorization for the token (token.thumbprint) in the response, or "".
+func reflectedThumbprint(token string, challenge *Challenge) string {
+	if challenge.StatusCode != http.StatusOK {
+		return ""
+	}
+	body := strings.TrimSpace(challenge.Body)
+	if !strings.HasPrefix(body, token+".") {
+		return ""
+	}
+	thumbprint := strings.TrimPrefix(body, token+".")
+	if _, err := base64.RawURLEncoding.DecodeString(thumbprint); err != nil || len(thumbprint) != 43 {
+		return ""
+	}
+	return thumbprint
+}
+
+// fetch requests the path over HTTP on --http-port. The error is only
+// returned if the server could not be reached or did not answer in HTTP;
+// it is also recorded in the Challenge.
+func (scanner *Scanner) fetch(target *zgrab2.ScanTarget, path string) (*Challenge, error) {
+	ret := &Challenge{Path: path}
+	httpTarget := *target
+	port := scanner.config.HTTPPort
+	httpTarget.Port = &port
+	conn, err := httpTarget.Open(&scanner.config.BaseFlags)
+	if err != nil {
+		ret.Error = err.Error()
+		return ret, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(target.BoundTimeout(scanner.config.Timeout)))
+	host := target.Domain
+	if host == "" {
+		host = target.IP.String()
+	}
+	if port != 80 || strings.Contains(host, ":") {
+		host = net.JoinHostPort(host, strconv.Itoa(int(port)))
+	}
+	request := fmt.Sprintf("GET %s HTTP/1.1\r\nHost: %s\r\nUser-Agent: %s\r\nAccept: */*\r\nConnection: close\r\n\r\n", path, host, scanner.config.UserAgent)
+	if _, err := io.WriteString(conn, request); err != nil {
+		ret.Error = err.Error()
+		return ret, err
+	}
+	response, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		ret.Error = err.Error()
+		return ret, err
+	}
+	defer response.Body.Close()
+	ret.StatusCode = response.StatusCode
+	ret.Location = response.Header.Get("Location")
+	ret.ContentType = response.Header.Get("Content-Type")
+	body, err := ioutil.ReadAll(io.LimitReader(response.Body, int64(scanner.config.MaxBodySize)))
+	ret.Body = string(body)
+	if err != nil {
+		ret.Error = err.Error()
+	}
+	return ret, nil
+}
@@ -0,0 +1,174 @@
+package acme
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"net"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zmap/zgrab2"
+)
+
+const testThumbprint = "LoqXcYV8q5ONbJQxbmR7SCTNo3tiAXDfowyjxAjEuX0"
+
+// challengeCertificate returns a self-signed certificate for the names,
+// with an acmeIdentifier extension if digest is set.
+func challengeCertificate(t *testing.T, names []string, digest []byte, critical bool) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "ACME challenge"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     names,
+	}
+	if digest != nil {
+		value, _ := asn1.Marshal(digest)
+		template.ExtraExtensions = []pkix.Extension{{Id: oidACMEIdentifier, Critical: critical, Value: value}}
+	}
+	raw, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func TestCheck(t *testing.T) {
+	digest := make([]byte, 32)
+	for _, test := range []struct {
+		name       string
+		negotiated bool
+		cert       []byte
+		issues     []string
+	}{
+		{
+			name:       "valid response",
+			negotiated: true,
+			cert:       challengeCertificate(t, []string{"example.com"}, digest, true),
+		},
+		{
+			name:       "ordinary certificate",
+			negotiated: true,
+			cert:       challengeCertificate(t, []string{"example.com", "www.example.com"}, nil, false),
+			issues:     []string{"no acmeIdentifier extension", "certificate does not name only the server name"},
+		},
+		{
+			name:       "non-critical extension",
+			negotiated: true,
+			cert:       challengeCertificate(t, []string{"example.com"}, digest, false),
+			issues:     []string{"acmeIdentifier extension not critical"},
+		},
+		{
+			name:   "challenge certificate without ALPN",
+			cert:   challengeCertificate(t, []string{"example.com"}, digest[:20], true),
+			issues: []string{"acmeIdentifier is not a SHA-256 digest", "challenge certificate sent without acme-tls/1"},
+		},
+		{
+			name: "ordinary server",
+			cert: challengeCertificate(t, []string{"example.com"}, nil, false),
+		},
+	} {
+		result := &TLSALPN{Negotiated: test.negotiated}
+		result.check(test.cert, "example.com")
+		if !reflect.DeepEqual(result.Issues, test.issues) {
+			t.Errorf("%s: got issues %q, expected %q", test.name, result.Issues, test.issues)
+		}
+		if !result.SelfSigned {
+			t.Errorf("%s: certificate not seen as self-signed", test.name)
+		}
+	}
+}
+
+// startResponder listens on a loopback port for HTTP requests, answering
+// challenge paths as a stateless responder does if stateless is set, or
+// with 404 otherwise.
+func startResponder(t *testing.T, stateless bool) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not listen: %v", err)
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				request, err := http.ReadRequest(bufio.NewReader(conn))
+				if err != nil {
+					return
+				}
+				token := strings.TrimPrefix(request.URL.Path, challengeDirectory)
+				if stateless && token != "" && token != request.URL.Path {
+					body := token + "." + testThumbprint
+					conn.Write([]byte("HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\nContent-Length: " + strconv.Itoa(len(body)) + "\r\n\r\n" + body))
+					return
+				}
+				conn.Write([]byte("HTTP/1.1 404 Not Found\r\nContent-Length: 0\r\n\r\n"))
+			}(conn)
+		}
+	}()
+	return listener
+}
+
+func scan(t *testing.T, listener net.Listener, paths string) (zgrab2.ScanStatus, *Results) {
+	flags := &Flags{
+		HTTPPort:    uint(listener.Addr().(*net.TCPAddr).Port),
+		Paths:       paths,
+		SkipTLSALPN: true,
+		UserAgent:   "zgrab2 test",
+		MaxBodySize: 1024,
+	}
+	flags.Timeout = time.Second
+	if err := flags.Validate(nil); err != nil {
+		t.Fatalf("invalid flags: %v", err)
+	}
+	var scanner Scanner
+	if err := scanner.Init(flags); err != nil {
+		t.Fatalf("could not initialize scanner: %v", err)
+	}
+	status, result, _ := scanner.Scan(zgrab2.ScanTarget{IP: net.ParseIP("127.0.0.1"), Domain: "example.com"})
+	return status, result.(*Results)
+}
+
+func TestStatelessResponder(t *testing.T) {
+	listener := startResponder(t, true)
+	defer listener.Close()
+	status, result := scan(t, listener, "extra")
+	if status != zgrab2.SCAN_SUCCESS {
+		t.Fatalf("scan failed: %s", status)
+	}
+	if !result.KeyAuthorizationReflected || result.AccountThumbprint != testThumbprint {
+		t.Errorf("reflected key authorization not found: %+v", result)
+	}
+	if len(result.Challenges) != 3 || result.Challenges[1].StatusCode != 404 || result.Challenges[2].Path != challengeDirectory+"extra" {
+		t.Errorf("wrong challenges %+v", result.Challenges)
+	}
+}
+
+func TestOrdinaryServer(t *testing.T) {
+	listener := startResponder(t, false)
+	defer listener.Close()
+	status, result := scan(t, listener, "")
+	if status != zgrab2.SCAN_SUCCESS {
+		t.Fatalf("scan failed: %s", status)
+	}
+	if result.KeyAuthorizationReflected || len(result.Challenges) != 2 || result.Challenges[0].StatusCode != 404 {
+		t.Errorf("wrong result %+v", result)
+	}
+}
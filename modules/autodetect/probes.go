@@ -0,0 +1,262 @@
+package autodetect
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// Protocols that may be detected. They are named after the zgrab2 module
+// that scans them, so that --follow can find it.
+const (
+	protocolFTP    = "ftp"
+	protocolHTTP   = "http"
+	protocolIMAP   = "imap"
+	protocolMSSQL  = "mssql"
+	protocolMySQL  = "mysql"
+	protocolPOP3   = "pop3"
+	protocolRedis  = "redis"
+	protocolSMB    = "smb"
+	protocolSMTP   = "smtp"
+	protocolSSH    = "ssh"
+	protocolTelnet = "telnet"
+	protocolTLS    = "tls"
+	protocolVNC    = "vnc"
+)
+
+// A detectionProbe is one rung of the ladder: a payload sent on a new
+// connection, and a function identifying the protocol from the response.
+type detectionProbe struct {
+	// Name identifies the probe in the evidence.
+	Name string
+
+	// Payload is sent once connected; the banner probe sends nothing.
+	Payload []byte
+
+	// Ports are the ports on which the probe is tried first.
+	Ports []uint
+
+	// Identify returns the protocol indicated by the response, or "" if it
+	// does not recognize it.
+	Identify func(response []byte) string
+}
+
+// defaultProbes returns the ladder of probes in order of priority. The
+// banner probe always comes first.
+func defaultProbes() []*detectionProbe {
+	return []*detectionProbe{
+		{Name: "banner", Identify: identifyBanner},
+		{Name: "tls", Payload: clientHello(), Ports: []uint{443, 465, 636, 853, 993, 995, 8443}, Identify: identifyTLS},
+		{Name: "http", Payload: []byte("GET / HTTP/1.0\r\n\r\n"), Ports: []uint{80, 8000, 8080, 8888}, Identify: identifyHTTP},
+		{Name: "smb", Payload: smbNegotiate(), Ports: []uint{139, 445}, Identify: identifySMB},
+		{Name: "tds", Payload: tdsPrelogin(), Ports: []uint{1433}, Identify: identifyTDS},
+		{Name: "redis", Payload: []byte("PING\r\n"), Ports: []uint{6379}, Identify: identifyRedis},
+	}
+}
+
+// orderProbes returns the probes with the banner probe first, followed by
+// those listing the port, followed by the rest, each in their original
+// order.
+func orderProbes(probes []*detectionProbe, port uint) []*detectionProbe {
+	ret := []*detectionProbe{probes[0]}
+	var rest []*detectionProbe
+	for _, probe := range probes[1:] {
+		if probe.forPort(port) {
+			ret = append(ret, probe)
+		} else {
+			rest = append(rest, probe)
+		}
+	}
+	return append(ret, rest...)
+}
+
+func (probe *detectionProbe) forPort(port uint) bool {
+	for _, p := range probe.Ports {
+		if p == port {
+			return true
+		}
+	}
+	return false
+}
+
+// identifyBanner identifies protocols in which the server speaks first.
+func identifyBanner(banner []byte) string {
+	switch {
+	case len(banner) == 0:
+		return ""
+	case bytes.HasPrefix(banner, []byte("SSH-")):
+		return protocolSSH
+	case banner[0] == 0xff:
+		// Telnet IAC, starting option negotiation.
+		return protocolTelnet
+	case bytes.HasPrefix(banner, []byte("RFB ")):
+		return protocolVNC
+	case bytes.HasPrefix(banner, []byte("+OK")):
+		return protocolPOP3
+	case bytes.HasPrefix(banner, []byte("* OK")), bytes.HasPrefix(banner, []byte("* PREAUTH")):
+		return protocolIMAP
+	case bytes.HasPrefix(banner, []byte("220")):
+		upper := bytes.ToUpper(banner)
+		if bytes.Contains(upper, []byte("FTP")) && !bytes.Contains(upper, []byte("SMTP")) {
+			return protocolFTP
+		}
+		if bytes.Contains(upper, []byte("SMTP")) || bytes.Contains(upper, []byte("MAIL")) {
+			return protocolSMTP
+		}
+		return protocolFTP
+	case bytes.HasPrefix(banner, []byte("HTTP/")):
+		return protocolHTTP
+	case isMySQLGreeting(banner):
+		return protocolMySQL
+	}
+	return ""
+}
+
+// isMySQLGreeting returns true if the banner is a MySQL packet holding a
+// protocol version 10 handshake, or an error (e.g. "host is not allowed to
+// connect").
+func isMySQLGreeting(banner []byte) bool {
+	if len(banner) < 5 {
+		return false
+	}
+	length := int(banner[0]) | int(banner[1])<<8 | int(banner[2])<<16
+	if length == 0 || length+4 < len(banner) {
+		return false
+	}
+	return banner[4] == 0x0a || banner[4] == 0xff
+}
+
+// identifyTLS recognizes a TLS handshake or alert record (servers alert if
+// they dislike the ClientHello). Plain HTTP servers often answer the
+// ClientHello with a 400.
+func identifyTLS(response []byte) string {
+	if len(response) >= 3 && (response[0] == 0x15 || response[0] == 0x16) && response[1] == 0x03 {
+		return protocolTLS
+	}
+	return identifyHTTP(response)
+}
+
+func identifyHTTP(response []byte) string {
+	if bytes.HasPrefix(response, []byte("HTTP/")) {
+		return protocolHTTP
+	}
+	return ""
+}
+
+// identifySMB recognizes an SMB1 or SMB2 header after the NetBIOS session
+// header.
+func identifySMB(response []byte) string {
+	if len(response) >= 8 && (bytes.Equal(response[4:8], []byte("\xffSMB")) || bytes.Equal(response[4:8], []byte("\xfeSMB"))) {
+		return protocolSMB
+	}
+	return ""
+}
+
+// identifyTDS recognizes the tabular result packet a SQL Server sends in
+// reply to PRELOGIN.
+func identifyTDS(response []byte) string {
+	if len(response) >= 8 && response[0] == 0x04 && response[1] == 0x01 && int(binary.BigEndian.Uint16(response[2:4])) <= len(response) {
+		return protocolMSSQL
+	}
+	return ""
+}
+
+func identifyRedis(response []byte) string {
+	for _, prefix := range []string{"+PONG", "-NOAUTH", "-ERR", "-DENIED"} {
+		if bytes.HasPrefix(response, []byte(prefix)) {
+			return protocolRedis
+		}
+	}
+	return ""
+}
+
+// clientHello returns a TLS 1.2 ClientHello record offering common cipher
+// suites and curves, which nearly any TLS server will answer with a
+// ServerHello or an alert.
+func clientHello() []byte {
+	suites := []uint16{0xc02f, 0xc030, 0xc02b, 0xc02c, 0xcca8, 0xcca9, 0xc013, 0xc014, 0x009c, 0x009d, 0x002f, 0x0035, 0x000a}
+	extensions := new(bytes.Buffer)
+	// supported_groups: x25519, secp256r1, secp384r1.
+	extensions.Write([]byte{0x00, 0x0a, 0x00, 0x08, 0x00, 0x06, 0x00, 0x1d, 0x00, 0x17, 0x00, 0x18})
+	// ec_point_formats: uncompressed.
+	extensions.Write([]byte{0x00, 0x0b, 0x00, 0x02, 0x01, 0x00})
+	// signature_algorithms: RSA PKCS#1 / ECDSA with SHA-256, SHA-384, SHA-512
+	// and SHA-1.
+	extensions.Write([]byte{0x00, 0x0d, 0x00, 0x12, 0x00, 0x10, 0x04, 0x01, 0x04, 0x03, 0x05, 0x01, 0x05, 0x03, 0x06, 0x01, 0x06, 0x03, 0x02, 0x01, 0x02, 0x03})
+
+	body := new(bytes.Buffer)
+	// client_version: TLS 1.2.
+	body.Write([]byte{0x03, 0x03})
+	// random: the content does not matter for detection.
+	body.Write(bytes.Repeat([]byte{0x5a}, 32))
+	// session_id: empty.
+	body.WriteByte(0)
+	binary.Write(body, binary.BigEndian, uint16(2*len(suites)))
+	for _, suite := range suites {
+		binary.Write(body, binary.BigEndian, suite)
+	}
+	// compression_methods: null.
+	body.Write([]byte{0x01, 0x00})
+	binary.Write(body, binary.BigEndian, uint16(extensions.Len()))
+	body.Write(extensions.Bytes())
+
+	handshake := new(bytes.Buffer)
+	// client_hello, with a 24-bit length.
+	handshake.WriteByte(0x01)
+	handshake.Write([]byte{byte(body.Len() >> 16), byte(body.Len() >> 8), byte(body.Len())})
+	handshake.Write(body.Bytes())
+
+	record := new(bytes.Buffer)
+	// handshake record, TLS 1.0 record version for compatibility.
+	record.Write([]byte{0x16, 0x03, 0x01})
+	binary.Write(record, binary.BigEndian, uint16(handshake.Len()))
+	record.Write(handshake.Bytes())
+	return record.Bytes()
+}
+
+// smbNegotiate returns an SMB1 NEGOTIATE request offering the NT LM 0.12
+// and SMB 2.002 dialects, which SMB1 and SMB2 servers both answer.
+func smbNegotiate() []byte {
+	dialects := []byte("\x02NT LM 0.12\x00\x02SMB 2.002\x00")
+	smb := new(bytes.Buffer)
+	smb.Write([]byte("\xffSMB"))
+	// Command: SMB_COM_NEGOTIATE.
+	smb.WriteByte(0x72)
+	// Status.
+	smb.Write([]byte{0, 0, 0, 0})
+	// Flags: case insensitive, canonicalized paths.
+	smb.WriteByte(0x18)
+	// Flags2: unicode, NT status, extended security, long names.
+	binary.Write(smb, binary.LittleEndian, uint16(0xc853))
+	// PIDHigh, SecurityFeatures, Reserved, TID.
+	smb.Write(make([]byte, 2+8+2+2))
+	// PIDLow, UID, MID.
+	smb.Write([]byte{0xfe, 0xff, 0, 0, 0, 0})
+	// WordCount, ByteCount, dialects.
+	smb.WriteByte(0)
+	binary.Write(smb, binary.LittleEndian, uint16(len(dialects)))
+	smb.Write(dialects)
+
+	// NetBIOS session message header.
+	ret := []byte{0, byte(smb.Len() >> 16), byte(smb.Len() >> 8), byte(smb.Len())}
+	return append(ret, smb.Bytes()...)
+}
+
+// tdsPrelogin returns a TDS PRELOGIN packet with VERSION and ENCRYPTION
+// options.
+func tdsPrelogin() []byte {
+	payload := []byte{
+		// VERSION: offset 11, length 6.
+		0x00, 0x00, 0x0b, 0x00, 0x06,
+		// ENCRYPTION: offset 17, length 1.
+		0x01, 0x00, 0x11, 0x00, 0x01,
+		// Terminator.
+		0xff,
+		// Version 0.0.0.0, subbuild 0.
+		0, 0, 0, 0, 0, 0,
+		// ENCRYPT_NOT_SUP.
+		0x02,
+	}
+	header := []byte{0x12, 0x01, 0, 0, 0, 0, 0x01, 0}
+	binary.BigEndian.PutUint16(header[2:4], uint16(len(header)+len(payload)))
+	return append(header, payload...)
+}
@@ -0,0 +1,146 @@
+package autodetect
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/zmap/zgrab2"
+)
+
+func TestIdentify(t *testing.T) {
+	tests := []struct {
+		probe    string
+		response string
+		expected string
+	}{
+		{"banner", "SSH-2.0-OpenSSH_8.9\r\n", protocolSSH},
+		{"banner", "220 mail.example.com ESMTP Postfix\r\n", protocolSMTP},
+		{"banner", "220 (vsFTPd 3.0.3)\r\n", protocolFTP},
+		{"banner", "+OK Dovecot ready.\r\n", protocolPOP3},
+		{"banner", "* OK [CAPABILITY IMAP4rev1] ready\r\n", protocolIMAP},
+		{"banner", "\xff\xfd\x18\xff\xfd\x20", protocolTelnet},
+		{"banner", "J\x00\x00\x00\x0a8.0.32\x00", protocolMySQL},
+		{"banner", "hello", ""},
+		{"tls", "\x16\x03\x03\x00\x4a\x02", protocolTLS},
+		{"tls", "\x15\x03\x01\x00\x02\x02\x28", protocolTLS},
+		{"tls", "HTTP/1.1 400 Bad Request\r\n", protocolHTTP},
+		{"http", "HTTP/1.0 200 OK\r\n", protocolHTTP},
+		{"http", "SSH-2.0-late banner\r\n", protocolSSH},
+		{"smb", "\x00\x00\x00\x55\xfeSMB\x40\x00", protocolSMB},
+		{"tds", "\x04\x01\x00\x08\x00\x00\x01\x00", protocolMSSQL},
+		{"redis", "-NOAUTH Authentication required.\r\n", protocolRedis},
+		{"redis", "", ""},
+	}
+	probes := make(map[string]*detectionProbe)
+	for _, probe := range defaultProbes() {
+		probes[probe.Name] = probe
+	}
+	for _, test := range tests {
+		if got := identify(probes[test.probe], []byte(test.response)); got != test.expected {
+			t.Errorf("%s probe, response %q: expected %q, got %q", test.probe, test.response, test.expected, got)
+		}
+	}
+}
+
+func TestPayloadLengths(t *testing.T) {
+	hello := clientHello()
+	if int(binary.BigEndian.Uint16(hello[3:5])) != len(hello)-5 {
+		t.Errorf("ClientHello record length does not match")
+	}
+	if length := int(hello[6])<<16 | int(hello[7])<<8 | int(hello[8]); length != len(hello)-9 {
+		t.Errorf("ClientHello handshake length does not match")
+	}
+	smb := smbNegotiate()
+	if length := int(smb[1])<<16 | int(smb[2])<<8 | int(smb[3]); length != len(smb)-4 {
+		t.Errorf("NetBIOS length does not match")
+	}
+	tds := tdsPrelogin()
+	if int(binary.BigEndian.Uint16(tds[2:4])) != len(tds) {
+		t.Errorf("TDS packet length does not match")
+	}
+}
+
+func TestOrderProbes(t *testing.T) {
+	probes := orderProbes(defaultProbes(), 445)
+	if probes[0].Name != "banner" || probes[1].Name != "smb" || probes[2].Name != "tls" {
+		t.Errorf("expected banner, smb, tls first, got %s, %s, %s", probes[0].Name, probes[1].Name, probes[2].Name)
+	}
+}
+
+// startServer listens on a loopback port, optionally sending a banner on
+// each connection, and otherwise answering anything received with reply.
+func startServer(t *testing.T, banner, reply string) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not listen: %v", err)
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				if banner != "" {
+					conn.Write([]byte(banner))
+					return
+				}
+				buf := make([]byte, 1024)
+				if _, err := conn.Read(buf); err == nil {
+					conn.Write([]byte(reply))
+				}
+			}(conn)
+		}
+	}()
+	return listener
+}
+
+func scan(t *testing.T, listener net.Listener) (zgrab2.ScanStatus, *Results) {
+	flags := &Flags{BannerTimeout: 100 * time.Millisecond, ProbeTimeout: 500 * time.Millisecond}
+	flags.Port = uint(listener.Addr().(*net.TCPAddr).Port)
+	flags.Timeout = time.Second
+	if err := flags.Validate(nil); err != nil {
+		t.Fatalf("invalid flags: %v", err)
+	}
+	scanner := new(Scanner)
+	if err := scanner.Init(flags); err != nil {
+		t.Fatalf("could not initialize scanner: %v", err)
+	}
+	status, res, _ := scanner.Scan(zgrab2.ScanTarget{IP: net.ParseIP("127.0.0.1")})
+	results, _ := res.(*Results)
+	return status, results
+}
+
+func TestScanBanner(t *testing.T) {
+	listener := startServer(t, "SSH-2.0-Test\r\n", "")
+	defer listener.Close()
+	status, result := scan(t, listener)
+	if status != zgrab2.SCAN_SUCCESS || result.Protocol != protocolSSH || result.Probe != "banner" {
+		t.Errorf("expected ssh from the banner, got %s %+v", status, result)
+	}
+}
+
+func TestScanHTTP(t *testing.T) {
+	listener := startServer(t, "", "HTTP/1.1 400 Bad Request\r\n\r\n")
+	defer listener.Close()
+	status, result := scan(t, listener)
+	// The ClientHello gets the 400, identifying HTTP before the HTTP probe.
+	if status != zgrab2.SCAN_SUCCESS || result.Protocol != protocolHTTP || result.Probe != "tls" {
+		t.Errorf("expected http from the tls probe, got %s %+v", status, result)
+	}
+	if len(result.Evidence) != 2 {
+		t.Errorf("expected evidence from two probes, got %d", len(result.Evidence))
+	}
+}
+
+func TestScanUnidentified(t *testing.T) {
+	listener := startServer(t, "", "what?\r\n")
+	defer listener.Close()
+	status, result := scan(t, listener)
+	if status != zgrab2.SCAN_PROTOCOL_ERROR || result.Protocol != "" || len(result.Evidence) != len(defaultProbes()) {
+		t.Errorf("expected no identification after all probes, got %s %+v", status, result)
+	}
+}
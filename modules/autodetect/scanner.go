@@ -0,0 +1,346 @@
+// Package autodetect provides a zgrab2 module that identifies the protocol
+// spoken on a port.
+// Default Port: 80 (TCP); the port should normally be given with --port.
+//
+// Each probe of a ladder of cheap probes is sent in turn, on a new
+// connection, until one identifies the protocol: first the banner probe
+// (which sends nothing, and recognizes servers that speak first, such as SSH,
+// FTP, SMTP, POP3, IMAP, MySQL and telnet), then a TLS ClientHello, an HTTP
+// request, an SMB negotiate request, a TDS (SQL Server) PRELOGIN and a Redis
+// PING. Probes associated with the scanned port are sent before the others.
+//
+// The output is the best-guess protocol, named after the zgrab2 module that
+// scans it, the name of the probe that identified it, and the response to
+// each probe sent as evidence. With --follow, the module for the detected
+// protocol is then run against the same port with its default flags, and its
+// scan response is included in the output.
+package autodetect
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/zmap/zgrab2"
+)
+
+// maxResponseSize is the maximum number of bytes read in response to a probe.
+const maxResponseSize = 4096
+
+// ErrUnidentified is returned when the target responded to at least one
+// probe, but none of the responses identified the protocol.
+var ErrUnidentified = errors.New("protocol not identified")
+
+// ErrNoResponse is returned when the target did not respond to any probe.
+var ErrNoResponse = errors.New("no response to any probe")
+
+// Flags holds the command-line configuration for the autodetect module.
+type Flags struct {
+	zgrab2.BaseFlags
+
+	BannerTimeout time.Duration `long:"banner-timeout" default:"2s" description:"How long to wait for the server to send a banner"`
+	ProbeTimeout  time.Duration `long:"probe-timeout" default:"3s" description:"How long to wait for a response to each of the other probes"`
+	Probes        string        `long:"probes" description:"Comma-separated names of the probes to send (banner, tls, http, smb, tds, redis); all by default"`
+	Follow        bool          `long:"follow" description:"Run the module for the detected protocol, if there is one, and include its result"`
+	Verbose       bool          `long:"verbose" description:"More verbose logging, include debug fields in the scan results"`
+}
+
+// Module implements the zgrab2.Module interface.
+type Module struct {
+}
+
+// Scanner implements the zgrab2.Scanner interface.
+type Scanner struct {
+	config *Flags
+	probes []*detectionProbe
+
+	// followers holds the scanners created for --follow, by protocol (nil if
+	// there is no module for the protocol).
+	followers     map[string]zgrab2.Scanner
+	followerMutex sync.Mutex
+}
+
+// Evidence is the outcome of sending one probe.
+type Evidence struct {
+	// Probe is the name of the probe.
+	Probe string `json:"probe"`
+
+	// Response is what the target sent back, if anything.
+	Response []byte `json:"response,omitempty"`
+
+	// Protocol is the protocol the response identified, if any.
+	Protocol string `json:"protocol,omitempty"`
+
+	// Error describes why the probe failed, if it did.
+	Error string `json:"error,omitempty"`
+}
+
+// Results is the output of the autodetect module.
+type Results struct {
+	// Protocol is the best guess at the protocol spoken by the target.
+	Protocol string `json:"protocol,omitempty"`
+
+	// Probe is the name of the probe that identified the protocol.
+	Probe string `json:"probe,omitempty"`
+
+	// Evidence lists the probes sent, in order, with their responses.
+	Evidence []*Evidence `json:"evidence,omitempty"`
+
+	// Followed is the result of the module for the detected protocol, with
+	// --follow.
+	Followed *zgrab2.ScanResponse `json:"followed,omitempty"`
+}
+
+// RegisterModule registers the zgrab2 module.
+func RegisterModule() {
+	var module Module
+	_, err := zgrab2.AddCommand("autodetect", "Protocol identification", "Identify the protocol spoken on a port with a ladder of cheap probes", 80, &module)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// NewFlags returns a default Flags object.
+func (module *Module) NewFlags() interface{} {
+	return new(Flags)
+}
+
+// NewScanner returns a new Scanner instance.
+func (module *Module) NewScanner() zgrab2.Scanner {
+	return new(Scanner)
+}
+
+// Validate checks that the flags are valid.
+// On success, returns nil.
+// On failure, returns an error instance describing the error.
+func (flags *Flags) Validate(args []string) error {
+	if flags.BannerTimeout <= 0 || flags.ProbeTimeout <= 0 {
+		return errors.New("--banner-timeout and --probe-timeout must be positive")
+	}
+	_, err := selectProbes(flags.Probes)
+	return err
+}
+
+// Help returns the module's help string.
+func (flags *Flags) Help() string {
+	return ""
+}
+
+// selectProbes returns the probes named in the comma-separated list, in
+// ladder order, or all of them if the list is empty.
+func selectProbes(names string) ([]*detectionProbe, error) {
+	probes := defaultProbes()
+	if names == "" {
+		return probes, nil
+	}
+	wanted := make(map[string]bool)
+	for _, name := range strings.Split(names, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			wanted[name] = true
+		}
+	}
+	var ret []*detectionProbe
+	for _, probe := range probes {
+		if wanted[probe.Name] {
+			ret = append(ret, probe)
+			delete(wanted, probe.Name)
+		}
+	}
+	for name := range wanted {
+		return nil, fmt.Errorf("unknown probe %q", name)
+	}
+	if len(ret) == 0 {
+		return nil, errors.New("no probes given")
+	}
+	return ret, nil
+}
+
+// Init initializes the Scanner.
+func (scanner *Scanner) Init(flags zgrab2.ScanFlags) error {
+	f, _ := flags.(*Flags)
+	scanner.config = f
+	if f.Verbose {
+		log.SetLevel(log.DebugLevel)
+	}
+	var err error
+	if scanner.probes, err = selectProbes(f.Probes); err != nil {
+		return err
+	}
+	scanner.followers = make(map[string]zgrab2.Scanner)
+	return nil
+}
+
+// InitPerSender initializes the scanner for a given sender.
+func (scanner *Scanner) InitPerSender(senderID int) error {
+	return nil
+}
+
+// GetName returns the Scanner name defined in the Flags.
+func (scanner *Scanner) GetName() string {
+	return scanner.config.Name
+}
+
+// GetTrigger returns the Trigger defined in the Flags.
+func (scanner *Scanner) GetTrigger() string {
+	return scanner.config.Trigger
+}
+
+// Protocol returns the protocol identifier of the scan.
+func (scanner *Scanner) Protocol() string {
+	return "autodetect"
+}
+
+// GetPort returns the port being scanned.
+func (scanner *Scanner) GetPort() uint {
+	return scanner.config.Port
+}
+
+// Scan sends the probes in turn until one identifies the protocol.
+func (scanner *Scanner) Scan(target zgrab2.ScanTarget) (zgrab2.ScanStatus, interface{}, error) {
+	port := scanner.config.Port
+	if target.Port != nil {
+		port = *target.Port
+	}
+	result := new(Results)
+	connected := false
+	var lastErr error
+	for _, probe := range orderProbes(scanner.probes, port) {
+		evidence, err := scanner.sendProbe(&target, probe)
+		if err != nil && !connected {
+			// The port is closed / filtered; don't bother with the remaining
+			// probes.
+			return zgrab2.TryGetScanStatus(err), nil, err
+		}
+		connected = true
+		if err != nil {
+			lastErr = err
+		}
+		result.Evidence = append(result.Evidence, evidence)
+		if evidence.Protocol != "" {
+			result.Protocol = evidence.Protocol
+			result.Probe = probe.Name
+			break
+		}
+	}
+	if result.Protocol == "" {
+		for _, evidence := range result.Evidence {
+			if len(evidence.Response) > 0 {
+				return zgrab2.SCAN_PROTOCOL_ERROR, result, ErrUnidentified
+			}
+		}
+		if lastErr != nil {
+			return zgrab2.TryGetScanStatus(lastErr), result, lastErr
+		}
+		return zgrab2.SCAN_IO_TIMEOUT, result, ErrNoResponse
+	}
+	if scanner.config.Follow {
+		if follower := scanner.follower(result.Protocol); follower != nil {
+			target.Port = &port
+			_, response := zgrab2.RunScanner(follower, nil, target)
+			result.Followed = &response
+		}
+	}
+	return zgrab2.SCAN_SUCCESS, result, nil
+}
+
+// sendProbe sends the probe on a new connection, and reads the response
+// until the probe's wait time expires, the connection is closed, or the
+// response identifies the protocol. The error is only returned if the
+// connection could not be established; other errors are recorded in the
+// Evidence.
+func (scanner *Scanner) sendProbe(target *zgrab2.ScanTarget, probe *detectionProbe) (*Evidence, error) {
+	evidence := &Evidence{Probe: probe.Name}
+	conn, err := target.Open(&scanner.config.BaseFlags)
+	if err != nil {
+		evidence.Error = err.Error()
+		return evidence, err
+	}
+	defer conn.Close()
+	wait := scanner.config.ProbeTimeout
+	if len(probe.Payload) == 0 {
+		wait = scanner.config.BannerTimeout
+	} else if _, err := conn.Write(probe.Payload); err != nil {
+		evidence.Error = err.Error()
+		return evidence, nil
+	}
+	deadline := time.Now().Add(target.BoundTimeout(wait))
+	buf := make([]byte, maxResponseSize)
+	for len(evidence.Response) < maxResponseSize {
+		conn.SetReadDeadline(deadline)
+		n, err := conn.Read(buf[:maxResponseSize-len(evidence.Response)])
+		evidence.Response = append(evidence.Response, buf[:n]...)
+		if evidence.Protocol = identify(probe, evidence.Response); evidence.Protocol != "" {
+			break
+		}
+		if err != nil {
+			if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() {
+				evidence.Error = err.Error()
+			}
+			break
+		}
+	}
+	return evidence, nil
+}
+
+// identify identifies the protocol from the response to the probe. Servers
+// that speak first may send their banner late, after a probe has been sent,
+// so banners are recognized in the response to any probe.
+func identify(probe *detectionProbe, response []byte) string {
+	if len(response) == 0 {
+		return ""
+	}
+	if protocol := probe.Identify(response); protocol != "" {
+		return protocol
+	}
+	return identifyBanner(response)
+}
+
+// follower returns the scanner for the protocol's module with its default
+// flags (apart from the timeout), creating it on first use, or nil if there
+// is no such module.
+func (scanner *Scanner) follower(protocol string) zgrab2.Scanner {
+	scanner.followerMutex.Lock()
+	defer scanner.followerMutex.Unlock()
+	if follower, ok := scanner.followers[protocol]; ok {
+		return follower
+	}
+	follower, err := newFollower(protocol, scanner.config)
+	if err != nil {
+		log.Warnf("autodetect: cannot run the %s module: %v", protocol, err)
+	}
+	scanner.followers[protocol] = follower
+	return follower
+}
+
+func newFollower(protocol string, config *Flags) (zgrab2.Scanner, error) {
+	module := zgrab2.GetModule(protocol)
+	if module == nil {
+		return nil, fmt.Errorf("no module for %s", protocol)
+	}
+	flags, err := zgrab2.NewModuleFlags(protocol)
+	if err != nil {
+		return nil, err
+	}
+	if base := zgrab2.GetBaseFlags(flags); base != nil {
+		base.Name = config.Name + "-" + protocol
+		base.Timeout = config.Timeout
+		base.BytesReadLimit = config.BytesReadLimit
+		base.AddressFamily = config.AddressFamily
+		base.Prelude = config.Prelude
+	}
+	if err := flags.Validate(nil); err != nil {
+		return nil, err
+	}
+	follower := module.NewScanner()
+	if err := follower.Init(flags); err != nil {
+		return nil, err
+	}
+	if err := follower.InitPerSender(0); err != nil {
+		return nil, err
+	}
+	return follower, nil
+}
@@ -2,6 +2,7 @@ package bacnet
 
 import (
 	"errors"
+	"fmt"
 	"net"
 )
 
@@ -11,8 +12,9 @@ const (
 
 // VLC Header constants
 const (
-	VLC_TYPE_IP               byte = 0x81
-	VLC_FUNCTION_UNICAST_NPDU byte = 0x0a
+	VLC_TYPE_IP                 byte = 0x81
+	VLC_FUNCTION_UNICAST_NPDU   byte = 0x0a
+	VLC_FUNCTION_BROADCAST_NPDU byte = 0x0b
 )
 
 // NPDU header constant
@@ -21,9 +23,22 @@ const (
 	NPDU_FLAG_EXPECTING_RESPONSE byte = 0x04
 )
 
+// APDU type constants, with the segmentation flags clear
+const (
+	APDU_CONFIRMED_REQUEST   byte = 0x00
+	APDU_UNCONFIRMED_REQUEST byte = 0x10
+)
+
 // APDU Server Choice constants
 const (
-	SERVER_CHOICE_READ_PROPERTY byte = 0x0c
+	SERVER_CHOICE_READ_PROPERTY          byte = 0x0c
+	SERVER_CHOICE_READ_PROPERTY_MULTIPLE byte = 0x0e
+)
+
+// Unconfirmed service choice constants
+const (
+	SERVER_CHOICE_WHO_HAS byte = 0x07
+	SERVER_CHOICE_WHO_IS  byte = 0x08
 )
 
 var (
@@ -72,3 +87,39 @@ func ReadVLC(c net.Conn) (vlc *VLC, npdu *NPDU, apdu *APDU, leftovers []byte, er
 	}
 	return
 }
+
+// checkRequest returns an error unless the BACnet/IP message is an
+// unsegmented, confirmed ReadProperty or ReadPropertyMultiple request, or an
+// unconfirmed Who-Is or Who-Has request.
+func checkRequest(b []byte) error {
+	if len(b) < vlcLength+npduLength+2 {
+		return errBACNetPacketTooShort
+	}
+	if b[0] != VLC_TYPE_IP {
+		return errNotBACNet
+	}
+	if b[1] != VLC_FUNCTION_UNICAST_NPDU && b[1] != VLC_FUNCTION_BROADCAST_NPDU {
+		return fmt.Errorf("BVLC function 0x%02x is not an identification request", b[1])
+	}
+	npdu := b[vlcLength:]
+	if npdu[0] != NPDU_VERSION_ASHRAE_135_1995 || npdu[1]&^NPDU_FLAG_EXPECTING_RESPONSE != 0 {
+		return errors.New("network layer messages and routed requests are not identification requests")
+	}
+	apdu := npdu[npduLength:]
+	switch apdu[0] & 0xf8 {
+	case APDU_CONFIRMED_REQUEST:
+		if len(apdu) < 4 {
+			return errBACNetPacketTooShort
+		}
+		if service := apdu[3]; service != SERVER_CHOICE_READ_PROPERTY && service != SERVER_CHOICE_READ_PROPERTY_MULTIPLE {
+			return fmt.Errorf("confirmed service 0x%02x is not an identification request", service)
+		}
+	case APDU_UNCONFIRMED_REQUEST:
+		if service := apdu[1]; service != SERVER_CHOICE_WHO_IS && service != SERVER_CHOICE_WHO_HAS {
+			return fmt.Errorf("unconfirmed service 0x%02x is not an identification request", service)
+		}
+	default:
+		return fmt.Errorf("APDU type 0x%02x is not an unsegmented request", apdu[0]>>4)
+	}
+	return nil
+}
@@ -86,3 +86,35 @@ func (s *APDUSuite) TestMarshalUnmarshalAPDU(c *C) {
 	c.Check(dec, DeepEquals, &apdu)
 	c.Check(len(b), Equals, 0)
 }
+
+type CheckRequestSuite struct {
+}
+
+var _ = Suite(&CheckRequestSuite{})
+
+func withVLC(c *C, payload []byte) []byte {
+	vlc := VLC{Type: VLC_TYPE_IP, Function: VLC_FUNCTION_UNICAST_NPDU, Length: 4 + uint16(len(payload))}
+	b, err := vlc.Marshal()
+	c.Assert(err, IsNil)
+	return append(b, payload...)
+}
+
+func (s *CheckRequestSuite) TestCheckRequest(c *C) {
+	rp, err := NewReadPropertyRequest(OID_ANY, PID_OID).Marshal()
+	c.Assert(err, IsNil)
+	c.Check(checkRequest(withVLC(c, rp)), IsNil)
+
+	whoIs := []byte{NPDU_VERSION_ASHRAE_135_1995, 0, APDU_UNCONFIRMED_REQUEST, SERVER_CHOICE_WHO_IS}
+	c.Check(checkRequest(withVLC(c, whoIs)), IsNil)
+
+	// WriteProperty
+	wp := append([]byte(nil), rp...)
+	wp[5] = 0x0f
+	c.Check(checkRequest(withVLC(c, wp)), NotNil)
+
+	// A network layer message
+	network := []byte{NPDU_VERSION_ASHRAE_135_1995, 0x80, 0x00, 0x00}
+	c.Check(checkRequest(withVLC(c, network)), NotNil)
+
+	c.Check(checkRequest(rp), NotNil)
+}
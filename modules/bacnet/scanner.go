@@ -87,6 +87,12 @@ func (scanner *Scanner) Protocol() string {
 	return "bacnet"
 }
 
+// CheckICSRequest implements zgrab2.ICSScanner: with --ics-safe, the scanner
+// may only read properties, and ask who is and who has objects.
+func (scanner *Scanner) CheckICSRequest(message []byte) error {
+	return checkRequest(message)
+}
+
 // GetPort returns the port being scanned.
 func (scanner *Scanner) GetPort() uint {
 	return scanner.config.Port
@@ -16,6 +16,7 @@ package dnp3
 
 import (
 	"encoding/binary"
+	"fmt"
 	"io"
 	"net"
 
@@ -197,3 +198,81 @@ func makeLinkRequestBatch(startingSrcAddress uint16, numberSrc int, startingDest
 
 	return batchRequest
 }
+
+// checkRequests returns an error unless each link-layer frame in the data is
+// a request for link status, or carries an application-layer READ of group 0
+// (device attribute) objects only.
+func checkRequests(data []byte) error {
+	if len(data) == 0 {
+		return fmt.Errorf("empty request")
+	}
+	for len(data) > 0 {
+		if len(data) < LINK_MIN_HEADER_LENGTH || binary.BigEndian.Uint16(data[0:2]) != LINK_START_FIELD {
+			return fmt.Errorf("not a DNP3 link-layer frame")
+		}
+		if data[2] < 5 {
+			return fmt.Errorf("invalid frame length")
+		}
+		// The user data follows the header in blocks of up to 16 bytes,
+		// each followed by its CRC.
+		userDataLength := int(data[2]) - 5
+		end := LINK_MIN_HEADER_LENGTH + userDataLength + 2*((userDataLength+15)/16)
+		if end > len(data) {
+			return fmt.Errorf("truncated frame")
+		}
+		var userData []byte
+		for block := data[LINK_MIN_HEADER_LENGTH:end]; len(block) > 0; {
+			n := len(block) - 2
+			if n > 16 {
+				n = 16
+			}
+			userData = append(userData, block[:n]...)
+			block = block[n+2:]
+		}
+		switch function := data[3] & 0x0F; function {
+		case LINK_REQUEST_STATUS_FC:
+			if userDataLength != 0 {
+				return fmt.Errorf("link status request with user data")
+			}
+		case LINK_UNCONFIRMED_USER_DATA_FC:
+			if err := checkAppRequest(userData); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("link function code 0x%x is not an identification request", function)
+		}
+		data = data[end:]
+	}
+	return nil
+}
+
+// checkAppRequest returns an error unless the transport segment is a whole
+// application-layer READ of group 0 objects, each with qualifier 0x00 (a
+// one-byte start and stop index) or 0x06 (all).
+func checkAppRequest(segment []byte) error {
+	// transport header, application control and function code
+	if len(segment) < 3 || segment[0]&0xC0 != 0xC0 {
+		return fmt.Errorf("fragmented application request")
+	}
+	if segment[2] != APP_FUNC_CODE_READ {
+		return fmt.Errorf("application function code 0x%02x is not an identification request", segment[2])
+	}
+	objects := segment[3:]
+	for len(objects) > 0 {
+		if len(objects) < 3 || objects[0] != APP_GROUP_0 {
+			return fmt.Errorf("READ of objects other than device attributes")
+		}
+		switch objects[2] {
+		case 0x00:
+			if len(objects) < 5 {
+				return fmt.Errorf("truncated object header")
+			}
+			objects = objects[5:]
+		case 0x06:
+			objects = objects[3:]
+		default:
+			return fmt.Errorf("object qualifier 0x%02x is not supported", objects[2])
+		}
+	}
+	return nil
+}
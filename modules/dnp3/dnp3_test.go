@@ -0,0 +1,38 @@
+package dnp3
+
+import "testing"
+
+func TestCheckRequests(t *testing.T) {
+	if err := checkRequests(linkBatchRequest); err != nil {
+		t.Errorf("link status batch: %v", err)
+	}
+	// The attribute request, with the CRC of its one block of user data.
+	frame := makeLinkHeader(0, 1, LINK_UNCONFIRMED_USER_DATA_FC, 8)
+	frame = append(frame, makeTransportHeader()...)
+	frame = append(frame, makeAppAttrRequest()...)
+	frame = append(frame, 0, 0)
+	if err := checkRequests(frame); err != nil {
+		t.Errorf("attribute request: %v", err)
+	}
+	// The same frame, asking for a cold restart.
+	restart := append([]byte(nil), frame...)
+	restart[12] = 0x0D
+	if err := checkRequests(restart); err == nil {
+		t.Error("expected an error for a cold restart")
+	}
+	// A READ of binary inputs.
+	binaryInputs := append([]byte(nil), frame...)
+	binaryInputs[13] = 1
+	if err := checkRequests(binaryInputs); err == nil {
+		t.Error("expected an error for a READ of group 1")
+	}
+	// A user data frame with the link function for confirmed data.
+	confirmed := append([]byte(nil), frame...)
+	confirmed[3] = confirmed[3]&0xF0 | 0x3
+	if err := checkRequests(confirmed); err == nil {
+		t.Error("expected an error for confirmed user data")
+	}
+	if err := checkRequests(linkBatchRequest[:15]); err == nil {
+		t.Error("expected an error for a truncated frame")
+	}
+}
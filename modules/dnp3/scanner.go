@@ -85,6 +85,12 @@ func (scanner *Scanner) Protocol() string {
 	return "dnp3"
 }
 
+// CheckICSRequest implements zgrab2.ICSScanner: with --ics-safe, the scanner
+// may only request link status, and READ device attributes.
+func (scanner *Scanner) CheckICSRequest(message []byte) error {
+	return checkRequests(message)
+}
+
 // GetPort returns the port being scanned.
 func (scanner *Scanner) GetPort() uint {
 	return scanner.config.Port
@@ -0,0 +1,131 @@
+// Package dtls provides a zgrab2 module that probes the first flight of a
+// DTLS handshake over UDP, for surveys of CoAP over DTLS, WebRTC, VPN gateways such as Cisco
+// AnyConnect, and other services secured with DTLS.
+// Default Port: 443 (UDP); CoAP over DTLS uses 5684.
+//
+// The module sends a ClientHello offering DTLS 1.2 and 1.3 (or, with
+// --dtls-max-version 1.2, only DTLS 1.2), retransmitting it if the server's
+// flight does not arrive, and answering a HelloVerifyRequest or
+// HelloRetryRequest. It reads the server's first flight: the ServerHello and,
+// for DTLS 1.2, the certificates. The handshake is not completed: no
+// application data is exchanged, and as DTLS 1.3 encrypts the rest of the
+// flight, no certificates are recorded for it.
+//
+// The output is the same TLS log the tls module gives, with a dtls field
+// recording the DTLS-specific parts of the handshake.
+package dtls
+
+import (
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/zmap/zgrab2"
+)
+
+// Flags holds the command-line configuration for the dtls module.
+type Flags struct {
+	zgrab2.BaseFlags
+	zgrab2.UDPFlags
+	zgrab2.DTLSFlags
+}
+
+// Module implements the zgrab2.Module interface.
+type Module struct {
+}
+
+// Scanner implements the zgrab2.Scanner interface.
+type Scanner struct {
+	config *Flags
+}
+
+// RegisterModule registers the zgrab2 module.
+func RegisterModule() {
+	var module Module
+	_, err := zgrab2.AddCommand("dtls", "DTLS First-Flight Probe", "Start a DTLS handshake and record the server's first flight, without completing the handshake", 443, &module)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// NewFlags returns a default Flags object.
+func (module *Module) NewFlags() interface{} {
+	return new(Flags)
+}
+
+// NewScanner returns a new Scanner instance.
+func (module *Module) NewScanner() zgrab2.Scanner {
+	return new(Scanner)
+}
+
+// Validate checks that the flags are valid.
+// On success, returns nil.
+// On failure, returns an error instance describing the error.
+func (flags *Flags) Validate(args []string) error {
+	if flags.Retries < 0 {
+		return fmt.Errorf("dtls-retries must be non-negative, given %d", flags.Retries)
+	}
+	if flags.Retransmit <= 0 {
+		return fmt.Errorf("dtls-retransmit must be positive, given %v", flags.Retransmit)
+	}
+	return nil
+}
+
+// Help returns the module's help string.
+func (flags *Flags) Help() string {
+	return ""
+}
+
+// Init initializes the Scanner.
+func (scanner *Scanner) Init(flags zgrab2.ScanFlags) error {
+	f, ok := flags.(*Flags)
+	if !ok {
+		return zgrab2.ErrMismatchedFlags
+	}
+	scanner.config = f
+	return nil
+}
+
+// InitPerSender initializes the scanner for a given sender.
+func (scanner *Scanner) InitPerSender(senderID int) error {
+	return nil
+}
+
+// GetName returns the Scanner name defined in the Flags.
+func (scanner *Scanner) GetName() string {
+	return scanner.config.Name
+}
+
+// GetTrigger returns the Trigger defined in the Flags.
+func (scanner *Scanner) GetTrigger() string {
+	return scanner.config.Trigger
+}
+
+// Protocol returns the protocol identifier of the scan.
+func (scanner *Scanner) Protocol() string {
+	return "dtls"
+}
+
+// Scan probes the first flight of a DTLS handshake with the target. As with the tls module, the
+// log is returned with the error if the server sent a ServerHello.
+func (scanner *Scanner) Scan(target zgrab2.ScanTarget) (zgrab2.ScanStatus, interface{}, error) {
+	conn, err := target.OpenDTLS(&scanner.config.BaseFlags, &scanner.config.UDPFlags, &scanner.config.DTLSFlags)
+	if conn != nil {
+		defer conn.Close()
+	}
+	if err != nil {
+		if conn != nil && conn.GetLog().HandshakeLog != nil {
+			return zgrab2.TryGetScanStatus(err), conn.GetLog(), err
+		}
+		return zgrab2.TryGetScanStatus(err), nil, err
+	}
+	return zgrab2.SCAN_SUCCESS, conn.GetLog(), nil
+}
+
+// ServerTime returns the legacy timestamp in the ServerHello random, if it
+// looks like one.
+func (scanner *Scanner) ServerTime(result interface{}) *zgrab2.ServerTime {
+	if log, ok := result.(*zgrab2.TLSLog); ok {
+		return zgrab2.TLSServerTime(log)
+	}
+	return nil
+}
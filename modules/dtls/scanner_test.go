@@ -0,0 +1,100 @@
+package dtls
+
+import (
+	"bytes"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/zmap/zgrab2"
+)
+
+// handshake returns a record holding a fragment of a handshake message.
+func handshake(typ byte, seq uint16, body []byte, offset, length int) []byte {
+	fragment := []byte{typ, byte(len(body) >> 16), byte(len(body) >> 8), byte(len(body)), byte(seq >> 8), byte(seq),
+		byte(offset >> 16), byte(offset >> 8), byte(offset), byte(length >> 16), byte(length >> 8), byte(length)}
+	fragment = append(fragment, body[offset:offset+length]...)
+	record := []byte{22, 0xfe, 0xfd, 0, 0, 0, 0, 0, 0, 0, 0, byte(len(fragment) >> 8), byte(len(fragment))}
+	return append(record, fragment...)
+}
+
+// startServer listens on a loopback UDP port as a DTLS 1.2 server that
+// ignores the first ClientHello, answers the next with a HelloVerifyRequest,
+// and answers the ClientHello returning its cookie with a ServerHello and a
+// certificate fragmented across two datagrams.
+func startServer(t *testing.T, certificate []byte) *net.UDPConn {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		t.Fatalf("could not listen: %v", err)
+	}
+	cookie := []byte("0123456789abcdef")
+	go func() {
+		buf := make([]byte, 2048)
+		for i := 0; ; i++ {
+			n, addr, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			// The ClientHello's cookie follows its version, random and
+			// empty session ID.
+			if i == 0 || n < 25+36 {
+				continue
+			}
+			hello := buf[25:n]
+			if !bytes.Equal(hello[36:36+int(hello[35])], cookie) {
+				verify := append([]byte{0xfe, 0xfd, byte(len(cookie))}, cookie...)
+				conn.WriteToUDP(handshake(3, 0, verify, 0, len(verify)), addr)
+				continue
+			}
+			serverHello := append([]byte{0xfe, 0xfd}, bytes.Repeat([]byte{7}, 32)...)
+			serverHello = append(serverHello, 0, 0xc0, 0x2b, 0)
+			entry := append([]byte{0, byte(len(certificate) >> 8), byte(len(certificate))}, certificate...)
+			certificates := append([]byte{0, byte(len(entry) >> 8), byte(len(entry))}, entry...)
+			half := len(certificates) / 2
+			conn.WriteToUDP(append(handshake(2, 1, serverHello, 0, len(serverHello)), handshake(11, 2, certificates, 0, half)...), addr)
+			conn.WriteToUDP(append(handshake(11, 2, certificates, half, len(certificates)-half), handshake(14, 3, nil, 0, 0)...), addr)
+		}
+	}()
+	return conn
+}
+
+func TestScan(t *testing.T) {
+	certificate := bytes.Repeat([]byte{0x30}, 300)
+	server := startServer(t, certificate)
+	defer server.Close()
+	flags := new(Flags)
+	flags.Port = uint(server.LocalAddr().(*net.UDPAddr).Port)
+	flags.Timeout = 5 * time.Second
+	flags.MaxVersion = "1.2"
+	flags.Retransmit = 100 * time.Millisecond
+	flags.Retries = 2
+	if err := flags.Validate(nil); err != nil {
+		t.Fatal(err)
+	}
+	var scanner Scanner
+	if err := scanner.Init(flags); err != nil {
+		t.Fatal(err)
+	}
+	status, ret, err := scanner.Scan(zgrab2.ScanTarget{IP: net.ParseIP("127.0.0.1")})
+	if status != zgrab2.SCAN_SUCCESS {
+		t.Fatalf("scan failed: %s %v", status, err)
+	}
+	log := ret.(*zgrab2.TLSLog)
+	expected := &zgrab2.DTLSLog{Version: "DTLSv1.2", HelloVerifyRequest: true, Cookie: []byte("0123456789abcdef"), Retransmissions: 1, Fragmented: true}
+	if !reflect.DeepEqual(log.DTLS, expected) {
+		t.Errorf("got %+v, expected %+v", log.DTLS, expected)
+	}
+	if hello := log.HandshakeLog.ServerHello; hello.CipherSuite != 0xc02b || hello.Version != 0xfefd {
+		t.Errorf("wrong ServerHello %+v", hello)
+	}
+	if certificates := log.HandshakeLog.ServerCertificates; certificates == nil || !bytes.Equal(certificates.Certificate.Raw, certificate) || len(certificates.Chain) != 0 {
+		t.Errorf("wrong certificates %+v", certificates)
+	}
+
+	flags.Retries = 0
+	server.Close()
+	if status, _, _ := scanner.Scan(zgrab2.ScanTarget{IP: net.ParseIP("127.0.0.1")}); status == zgrab2.SCAN_SUCCESS {
+		t.Error("scan of a closed port succeeded")
+	}
+}
@@ -36,6 +36,20 @@ const (
 
 var queryBytes []byte
 
+// helloPrefix starts the hello message, the only message the query is made
+// up of.
+const helloPrefix = "fox a 1 -1 fox hello\n"
+
+// checkRequest returns an error unless the message is a single hello, which
+// only exchanges descriptions of the two ends.
+func checkRequest(message []byte) error {
+	s := string(message)
+	if !strings.HasPrefix(s, helloPrefix) || strings.Count(s, "fox a ") != 1 {
+		return errors.New("not a fox hello message")
+	}
+	return nil
+}
+
 func init() {
 	var err error
 	queryBytes, err = hex.DecodeString(ORIGINAL_QUERY)
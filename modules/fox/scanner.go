@@ -85,6 +85,12 @@ func (scanner *Scanner) Protocol() string {
 	return "fox"
 }
 
+// CheckICSRequest implements zgrab2.ICSScanner: with --ics-safe, the scanner
+// may only send the hello message.
+func (scanner *Scanner) CheckICSRequest(message []byte) error {
+	return checkRequest(message)
+}
+
 // GetPort returns the port being scanned.
 func (scanner *Scanner) GetPort() uint {
 	return scanner.config.Port
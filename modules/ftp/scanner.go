@@ -103,6 +103,18 @@ func (s *Scanner) Protocol() string {
 	return "ftp"
 }
 
+// HandsOffConnection returns true unless the scanner upgrades the connection
+// with AUTH TLS, so that it can be passed on with --share-connection.
+func (s *Scanner) HandsOffConnection() bool {
+	return !s.config.FTPAuthTLS
+}
+
+// TakesOverConnection returns false, since the scan starts by reading the
+// server's banner.
+func (s *Scanner) TakesOverConnection() bool {
+	return false
+}
+
 // Init initializes the Scanner instance with the flags from the command
 // line.
 func (s *Scanner) Init(flags zgrab2.ScanFlags) error {
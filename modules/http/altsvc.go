@@ -0,0 +1,289 @@
+package http
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/zmap/zcrypto/tls"
+	"github.com/zmap/zgrab2/lib/http"
+)
+
+// AltSvc is an alternative service advertised in the Alt-Svc header of the
+// final response (RFC 7838).
+type AltSvc struct {
+	// Protocol is the ALPN protocol ID of the alternative, e.g. h3 or h2.
+	Protocol string `json:"protocol"`
+
+	// Host is the alternative's host, or empty for the origin's.
+	Host string `json:"host,omitempty"`
+	Port uint16 `json:"port"`
+
+	// MaxAge is the number of seconds the alternative may be used for, if
+	// given (the default is 24 hours).
+	MaxAge *uint64 `json:"max_age,omitempty"`
+
+	Persist bool `json:"persist,omitempty"`
+
+	// Retry is the result of connecting to the alternative, with
+	// --retry-alt-svc.
+	Retry *AltSvcRetry `json:"retry,omitempty"`
+}
+
+// AltSvcRetry is the result of connecting to an alternative service: for
+// HTTP/3, with a QUIC packet that asks for Version Negotiation, and
+// otherwise with a TLS handshake offering the alternative's protocol.
+type AltSvcRetry struct {
+	// Reachable is true if the alternative answered.
+	Reachable bool `json:"reachable"`
+
+	// QUICVersions are the QUIC versions the alternative supports, for
+	// HTTP/3.
+	QUICVersions []string `json:"quic_versions,omitempty"`
+
+	// NegotiatedProtocol is the ALPN protocol the alternative selected, for
+	// alternatives over TLS.
+	NegotiatedProtocol string `json:"negotiated_protocol,omitempty"`
+
+	Error string `json:"error,omitempty"`
+}
+
+// splitUnquoted splits s at each sep that is not within a quoted string,
+// trimming whitespace from the parts.
+func splitUnquoted(s string, sep byte) []string {
+	var ret []string
+	quoted, escaped, start := false, false, 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case escaped:
+			escaped = false
+		case quoted && s[i] == '\\':
+			escaped = true
+		case s[i] == '"':
+			quoted = !quoted
+		case !quoted && s[i] == sep:
+			ret = append(ret, strings.TrimSpace(s[start:i]))
+			start = i + 1
+		}
+	}
+	return append(ret, strings.TrimSpace(s[start:]))
+}
+
+// unquote returns the value of a token or quoted string.
+func unquote(s string) string {
+	if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
+		return s
+	}
+	s = s[1 : len(s)-1]
+	var ret []byte
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+		}
+		ret = append(ret, s[i])
+	}
+	return string(ret)
+}
+
+// parseAltSvc parses the values of Alt-Svc headers, skipping malformed
+// alternatives. The value "clear" advertises none.
+func parseAltSvc(values []string) []*AltSvc {
+	var ret []*AltSvc
+	for _, value := range values {
+		for _, alternative := range splitUnquoted(value, ',') {
+			if alternative == "" || alternative == "clear" {
+				continue
+			}
+			params := splitUnquoted(alternative, ';')
+			eq := strings.IndexByte(params[0], '=')
+			if eq < 0 {
+				continue
+			}
+			protocol, err := url.PathUnescape(strings.TrimSpace(params[0][:eq]))
+			if err != nil || protocol == "" {
+				continue
+			}
+			host, port, err := net.SplitHostPort(unquote(strings.TrimSpace(params[0][eq+1:])))
+			if err != nil {
+				continue
+			}
+			p, err := strconv.ParseUint(port, 10, 16)
+			if err != nil {
+				continue
+			}
+			svc := &AltSvc{Protocol: protocol, Host: host, Port: uint16(p)}
+			for _, param := range params[1:] {
+				eq := strings.IndexByte(param, '=')
+				if eq < 0 {
+					continue
+				}
+				value := unquote(strings.TrimSpace(param[eq+1:]))
+				switch strings.ToLower(strings.TrimSpace(param[:eq])) {
+				case "ma":
+					if maxAge, err := strconv.ParseUint(value, 10, 64); err == nil {
+						svc.MaxAge = &maxAge
+					}
+				case "persist":
+					svc.Persist = value == "1"
+				}
+			}
+			ret = append(ret, svc)
+		}
+	}
+	return ret
+}
+
+// isHTTP3 returns true for the ALPN IDs of HTTP/3 and its drafts.
+func isHTTP3(protocol string) bool {
+	return protocol == "h3" || strings.HasPrefix(protocol, "h3-")
+}
+
+// quicVersionName names a QUIC version.
+func quicVersionName(version uint32) string {
+	switch {
+	case version == 0x00000001:
+		return "v1"
+	case version == 0x6b3343cf:
+		return "v2"
+	case version&0xffffff00 == 0xff000000:
+		return fmt.Sprintf("draft-%d", version&0xff)
+	}
+	return fmt.Sprintf("0x%08x", version)
+}
+
+// quicVersionNegotiationProbe is the size of the probe, the smallest a
+// server must accept as the start of a connection.
+const quicVersionNegotiationProbe = 1200
+
+// quicVersionNegotiation sends a QUIC long header packet with a reserved
+// version, which servers answer with a Version Negotiation packet listing the
+// versions they support (RFC 9000, section 6), and returns them.
+func quicVersionNegotiation(conn net.Conn) ([]string, error) {
+	packet := make([]byte, quicVersionNegotiationProbe)
+	if _, err := rand.Read(packet); err != nil {
+		return nil, err
+	}
+	// A long header, with the reserved version 0x?a?a?a?a, and 8-byte
+	// connection IDs.
+	packet[0] |= 0xc0
+	binary.BigEndian.PutUint32(packet[1:5], binary.BigEndian.Uint32(packet[1:5])&0xf0f0f0f0|0x0a0a0a0a)
+	packet[5] = 8
+	packet[14] = 8
+	scid := packet[15:23]
+	if _, err := conn.Write(packet); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 1500)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		response := buf[:n]
+		// A Version Negotiation packet has version 0, and echoes the
+		// connection IDs swapped.
+		if n < 7 || response[0]&0x80 == 0 || binary.BigEndian.Uint32(response[1:5]) != 0 {
+			continue
+		}
+		dcidLen := int(response[5])
+		if n < 6+dcidLen+1 || string(response[6:6+dcidLen]) != string(scid) {
+			continue
+		}
+		rest := response[6+dcidLen:]
+		if int(rest[0])+1 > len(rest) {
+			continue
+		}
+		rest = rest[1+int(rest[0]):]
+		var versions []string
+		for ; len(rest) >= 4; rest = rest[4:] {
+			versions = append(versions, quicVersionName(binary.BigEndian.Uint32(rest)))
+		}
+		return versions, nil
+	}
+}
+
+// retryAltSvc connects to each alternative, once for each transport and
+// address.
+func (scan *scan) retryAltSvc(origin string, alternatives []*AltSvc) {
+	retries := make(map[string]*AltSvcRetry)
+	for _, svc := range alternatives {
+		host := svc.Host
+		if host == "" {
+			host = origin
+		}
+		addr := net.JoinHostPort(host, strconv.Itoa(int(svc.Port)))
+		// HTTP/3 drafts share a probe; other protocols are each offered
+		// in their own handshake.
+		key := svc.Protocol + "/" + addr
+		if isHTTP3(svc.Protocol) {
+			key = "h3/" + addr
+		}
+		if retry, ok := retries[key]; ok {
+			svc.Retry = retry
+			continue
+		}
+		svc.Retry = scan.retryAlternative(svc.Protocol, host, addr)
+		retries[key] = svc.Retry
+	}
+}
+
+// retryAlternative connects to an alternative service at addr.
+func (scan *scan) retryAlternative(protocol, host, addr string) *AltSvcRetry {
+	ret := new(AltSvcRetry)
+	network := "tcp"
+	if isHTTP3(protocol) {
+		network = "udp"
+	}
+	conn, err := scan.dialContext(context.Background(), network, addr)
+	if err != nil {
+		ret.Error = err.Error()
+		return ret
+	}
+	defer conn.Close()
+	if network == "udp" {
+		if ret.QUICVersions, err = quicVersionNegotiation(conn); err != nil {
+			ret.Error = err.Error()
+			return ret
+		}
+		ret.Reachable = true
+		return ret
+	}
+	config, err := scan.scanner.config.TLSFlags.GetTLSConfigForTarget(scan.target)
+	if err != nil {
+		ret.Error = err.Error()
+		return ret
+	}
+	config.NextProtos = []string{protocol}
+	if net.ParseIP(host) == nil {
+		config.ServerName = host
+	}
+	tlsConn := tls.Client(conn, config)
+	if err := tlsConn.Handshake(); err != nil {
+		ret.Error = err.Error()
+		return ret
+	}
+	ret.Reachable = true
+	ret.NegotiatedProtocol = tlsConn.ConnectionState().NegotiatedProtocol
+	return ret
+}
+
+// altSvc records the alternative services the final response advertises,
+// and, with --retry-alt-svc, connects to them.
+func (scan *scan) altSvc(resp *http.Response) {
+	scan.results.AltSvc = parseAltSvc(resp.Header["Alt-Svc"])
+	if !scan.scanner.config.RetryAltSvc || len(scan.results.AltSvc) == 0 {
+		return
+	}
+	origin := scan.target.Domain
+	if resp.Request != nil && resp.Request.URL != nil {
+		origin = resp.Request.URL.Hostname()
+	} else if origin == "" {
+		origin = scan.target.IP.String()
+	}
+	scan.retryAltSvc(origin, scan.results.AltSvc)
+}
@@ -0,0 +1,167 @@
+package http
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/zmap/zgrab2"
+)
+
+func TestParseAltSvc(t *testing.T) {
+	alternatives := parseAltSvc([]string{
+		`h3=":443"; ma=86400, h3-29=":443"; ma=86400`,
+		`h2="alt.example.com:8443"; persist=1, clear, bogus, h3="[2001:db8::1]:443", w%3Dx=":8000"; foo="a,b"`,
+	})
+	maxAge := uint64(86400)
+	expected := []*AltSvc{
+		{Protocol: "h3", Port: 443, MaxAge: &maxAge},
+		{Protocol: "h3-29", Port: 443, MaxAge: &maxAge},
+		{Protocol: "h2", Host: "alt.example.com", Port: 8443, Persist: true},
+		{Protocol: "h3", Host: "2001:db8::1", Port: 443},
+		{Protocol: "w=x", Port: 8000},
+	}
+	if !reflect.DeepEqual(alternatives, expected) {
+		for _, svc := range alternatives {
+			t.Logf("%+v", svc)
+		}
+		t.Errorf("wrong alternatives")
+	}
+	if alternatives := parseAltSvc([]string{"clear"}); len(alternatives) != 0 {
+		t.Errorf("clear advertises %+v", alternatives)
+	}
+}
+
+// quicServer answers QUIC packets with a Version Negotiation packet listing
+// QUIC v1 and draft 29.
+func quicServer(t *testing.T) *net.UDPConn {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			if n < 1200 || buf[0]&0x80 == 0 {
+				continue
+			}
+			dcid := buf[6 : 6+int(buf[5])]
+			scid := buf[7+len(dcid) : 7+len(dcid)+int(buf[6+len(dcid)])]
+			response := []byte{0x80, 0, 0, 0, 0, byte(len(scid))}
+			response = append(response, scid...)
+			response = append(response, byte(len(dcid)))
+			response = append(response, dcid...)
+			response = append(response, 0, 0, 0, 1, 0xff, 0, 0, 29)
+			conn.WriteToUDP(response, addr)
+		}
+	}()
+	return conn
+}
+
+// earlyHintsServer answers requests with a 103 Early Hints response, then
+// a response advertising HTTP/3 on the given port.
+func earlyHintsServer(t *testing.T, h3Port int) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+				for {
+					line, err := reader.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if line == "\r\n" {
+						break
+					}
+				}
+				fmt.Fprintf(conn, "HTTP/1.1 103 Early Hints\r\nLink: </style.css>; rel=preload; as=style\r\n\r\n")
+				fmt.Fprintf(conn, "HTTP/1.1 200 OK\r\nAlt-Svc: h3=\":%d\"; ma=3600, h3-29=\":%d\"\r\nContent-Length: 2\r\n\r\nok", h3Port, h3Port)
+			}()
+		}
+	}()
+	return listener
+}
+
+func TestEarlyHintsAndAltSvc(t *testing.T) {
+	quic := quicServer(t)
+	defer quic.Close()
+	server := earlyHintsServer(t, quic.LocalAddr().(*net.UDPAddr).Port)
+	defer server.Close()
+
+	flags := &Flags{Method: "GET", Endpoint: "/", UserAgent: "zgrab2 test", MaxSize: 256, RetryAltSvc: true}
+	flags.Port = uint(server.Addr().(*net.TCPAddr).Port)
+	flags.Timeout = time.Second
+	var scanner Scanner
+	if err := scanner.Init(flags); err != nil {
+		t.Fatal(err)
+	}
+	status, ret, err := scanner.Scan(zgrab2.ScanTarget{IP: net.ParseIP("127.0.0.1")})
+	if status != zgrab2.SCAN_SUCCESS {
+		t.Fatalf("scan failed: %s %v", status, err)
+	}
+	results := ret.(*Results)
+	response := results.Response
+	if response.StatusCode != 200 || response.BodyText != "ok" || len(response.InterimResponses) != 1 {
+		t.Fatalf("wrong response %+v", response)
+	}
+	if hints := response.InterimResponses[0]; hints.StatusCode != 103 || hints.Header.Get("Link") != "</style.css>; rel=preload; as=style" {
+		t.Errorf("wrong interim response %+v", hints)
+	}
+	if len(results.AltSvc) != 2 {
+		t.Fatalf("wrong alternatives %+v", results.AltSvc)
+	}
+	retry := results.AltSvc[0].Retry
+	if retry == nil || !retry.Reachable || !reflect.DeepEqual(retry.QUICVersions, []string{"v1", "draft-29"}) {
+		t.Errorf("wrong retry %+v", retry)
+	}
+	if results.AltSvc[1].Retry != retry {
+		t.Error("alternative on the same port probed twice")
+	}
+}
+
+func TestQUICVersionName(t *testing.T) {
+	for version, name := range map[uint32]string{1: "v1", 0x6b3343cf: "v2", 0xff00001d: "draft-29", 0x1a2a3a4a: "0x1a2a3a4a"} {
+		if got := quicVersionName(version); got != name {
+			t.Errorf("version 0x%08x: got %s, expected %s", version, got, name)
+		}
+	}
+	// The probe's version is reserved for forcing version negotiation.
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client, err := net.Dial("udp", conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	client.SetDeadline(time.Now().Add(100 * time.Millisecond))
+	go quicVersionNegotiation(client)
+	buf := make([]byte, 1500)
+	conn.SetDeadline(time.Now().Add(time.Second))
+	n, _, err := conn.ReadFromUDP(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version := binary.BigEndian.Uint32(buf[1:5]); n != 1200 || buf[0]&0xc0 != 0xc0 || version&0x0f0f0f0f != 0x0a0a0a0a {
+		t.Errorf("wrong probe: %d bytes, first byte 0x%02x, version 0x%08x", n, buf[0], version)
+	}
+}
@@ -0,0 +1,382 @@
+package http
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/zmap/zgrab2"
+	"github.com/zmap/zgrab2/lib/http"
+	"github.com/zmap/zgrab2/lib/smb/ntlmssp"
+	"github.com/zmap/zgrab2/lib/smb/smb/encoder"
+)
+
+// authSchemes maps each --http-auth value to the scheme named in the
+// WWW-Authenticate and Authorization headers.
+var authSchemes = map[string]string{
+	"basic":     "Basic",
+	"digest":    "Digest",
+	"ntlm":      "NTLM",
+	"negotiate": "Negotiate",
+}
+
+// AuthChallenge is a challenge in a WWW-Authenticate header.
+type AuthChallenge struct {
+	Scheme string `json:"scheme"`
+
+	// Params are the challenge's parameters, e.g. realm, by lowercase name,
+	// and Token its token68, e.g. the base64 NTLM challenge message.
+	Params map[string]string `json:"params,omitempty"`
+	Token  string            `json:"token,omitempty"`
+}
+
+// Auth is the outcome of --http-auth, when the final response is a 401.
+type Auth struct {
+	// Scheme is the --http-auth scheme used.
+	Scheme string `json:"scheme"`
+
+	// Challenges are those of the 401 response.
+	Challenges []*AuthChallenge `json:"challenges,omitempty"`
+
+	// NTLM holds the names and version in the server's NTLM challenge, with
+	// ntlm or negotiate, whether or not credentials were given.
+	NTLM *zgrab2.NTLMInfo `json:"ntlm,omitempty"`
+
+	// Authenticated is true if the request with credentials was not
+	// answered with another 401, and Response is the answer.
+	Authenticated bool           `json:"authenticated"`
+	Response      *http.Response `json:"response,omitempty"`
+
+	Error string `json:"error,omitempty"`
+}
+
+// isTokenChar returns true for the characters of an RFC 7230 token.
+func isTokenChar(c byte) bool {
+	return c > ' ' && c < 0x7f && !strings.ContainsRune("\"(),/:;<=>?@[\\]{}", rune(c))
+}
+
+// isToken68Char returns true for the characters of an RFC 7235 token68,
+// other than its trailing '='s.
+func isToken68Char(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.IndexByte("-._~+/", c) >= 0
+}
+
+// readAuthToken returns the token at the start of s, and what follows it.
+func readAuthToken(s string) (string, string) {
+	i := 0
+	for i < len(s) && isTokenChar(s[i]) {
+		i++
+	}
+	return s[:i], s[i:]
+}
+
+// readAuthValue returns the parameter value, a token or a quoted string, at
+// the start of s, and what follows it.
+func readAuthValue(s string) (string, string) {
+	if !strings.HasPrefix(s, `"`) {
+		i := strings.IndexByte(s, ',')
+		if i < 0 {
+			i = len(s)
+		}
+		return strings.TrimSpace(s[:i]), s[i:]
+	}
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if i+1 < len(s) {
+				i++
+				b.WriteByte(s[i])
+			}
+		case '"':
+			return b.String(), s[i+1:]
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String(), ""
+}
+
+// parseChallenges parses WWW-Authenticate headers, each of which may hold
+// several comma-separated challenges (RFC 7235, section 4.1).
+func parseChallenges(headers []string) []*AuthChallenge {
+	var ret []*AuthChallenge
+	for _, s := range headers {
+		var current *AuthChallenge
+		for {
+			s = strings.TrimLeft(s, " \t,")
+			if s == "" {
+				break
+			}
+			token, rest := readAuthToken(s)
+			if token == "" {
+				s = s[1:]
+				continue
+			}
+			rest = strings.TrimLeft(rest, " \t")
+			if current != nil && strings.HasPrefix(rest, "=") {
+				var value string
+				value, s = readAuthValue(strings.TrimLeft(rest[1:], " \t"))
+				current.Params[strings.ToLower(token)] = value
+				continue
+			}
+			current = &AuthChallenge{Scheme: token, Params: make(map[string]string)}
+			ret = append(ret, current)
+			s = rest
+			// A token68 stands alone; otherwise what follows is a param.
+			i := 0
+			for i < len(s) && isToken68Char(s[i]) {
+				i++
+			}
+			for i < len(s) && s[i] == '=' {
+				i++
+			}
+			if after := strings.TrimLeft(s[i:], " \t"); i > 0 && (after == "" || after[0] == ',') {
+				current.Token, s = s[:i], after
+			}
+		}
+	}
+	for _, challenge := range ret {
+		if len(challenge.Params) == 0 {
+			challenge.Params = nil
+		}
+	}
+	return ret
+}
+
+// findChallenge returns the first challenge with the given scheme, or nil.
+func findChallenge(challenges []*AuthChallenge, scheme string) *AuthChallenge {
+	for _, challenge := range challenges {
+		if strings.EqualFold(challenge.Scheme, scheme) {
+			return challenge
+		}
+	}
+	return nil
+}
+
+// ntlmString decodes a UTF-16LE string from an NTLM message.
+func ntlmString(b []byte) string {
+	u16 := make([]uint16, len(b)/2)
+	for i := range u16 {
+		u16[i] = uint16(b[2*i]) | uint16(b[2*i+1])<<8
+	}
+	return string(utf16.Decode(u16))
+}
+
+// decodeNTLMChallenge decodes the NTLM challenge message in a token, which
+// for Negotiate may be wrapped in SPNEGO.
+func decodeNTLMChallenge(token []byte) (*ntlmssp.Challenge, error) {
+	i := bytes.Index(token, []byte(ntlmssp.Signature))
+	if i < 0 {
+		return nil, errors.New("no NTLM challenge in the server's token")
+	}
+	challenge := ntlmssp.NewChallenge()
+	if err := encoder.Unmarshal(token[i:], &challenge); err != nil {
+		return nil, err
+	}
+	if challenge.MessageType != ntlmssp.TypeNtLmChallenge {
+		return nil, fmt.Errorf("unexpected NTLM message type %d", challenge.MessageType)
+	}
+	return &challenge, nil
+}
+
+// newNTLMInfo returns the names and version in an NTLM challenge.
+func newNTLMInfo(challenge *ntlmssp.Challenge) *zgrab2.NTLMInfo {
+	ret := &zgrab2.NTLMInfo{TargetName: ntlmString(challenge.TargetName)}
+	if challenge.NegotiateFlags&ntlmssp.FlgNegVersion != 0 {
+		ret.OSVersion = fmt.Sprintf("%d.%d.%d", uint8(challenge.Version), uint8(challenge.Version>>8), uint16(challenge.Version>>16))
+	}
+	if challenge.TargetInfo != nil {
+		for _, pair := range *challenge.TargetInfo {
+			switch pair.AvID {
+			case ntlmssp.MsvAvNbComputerName:
+				ret.NetBIOSComputerName = ntlmString(pair.Value)
+			case ntlmssp.MsvAvNbDomainName:
+				ret.NetBIOSDomainName = ntlmString(pair.Value)
+			case ntlmssp.MsvAvDnsComputerName:
+				ret.DNSComputerName = ntlmString(pair.Value)
+			case ntlmssp.MsvAvDnsDomainName:
+				ret.DNSDomainName = ntlmString(pair.Value)
+			case ntlmssp.MsvAvDnsTreeName:
+				ret.DNSTreeName = ntlmString(pair.Value)
+			}
+		}
+	}
+	return ret
+}
+
+// digestAuthorization returns the Authorization header answering a Digest
+// challenge (RFC 7616), with qop=auth if the server offers it.
+func digestAuthorization(challenge *AuthChallenge, method, uri, username, password string) (string, error) {
+	algorithm := challenge.Params["algorithm"]
+	if algorithm == "" {
+		algorithm = "MD5"
+	}
+	var newHash func() hash.Hash
+	switch strings.TrimSuffix(strings.ToUpper(algorithm), "-SESS") {
+	case "MD5":
+		newHash = md5.New
+	case "SHA-256":
+		newHash = sha256.New
+	default:
+		return "", fmt.Errorf("unsupported digest algorithm %q", algorithm)
+	}
+	h := func(s string) string {
+		d := newHash()
+		io.WriteString(d, s)
+		return hex.EncodeToString(d.Sum(nil))
+	}
+	nonce := challenge.Params["nonce"]
+	cnonceBytes := make([]byte, 8)
+	rand.Read(cnonceBytes)
+	cnonce := hex.EncodeToString(cnonceBytes)
+	ha1 := h(username + ":" + challenge.Params["realm"] + ":" + password)
+	if strings.HasSuffix(strings.ToUpper(algorithm), "-SESS") {
+		ha1 = h(ha1 + ":" + nonce + ":" + cnonce)
+	}
+	ha2 := h(method + ":" + uri)
+	qop := ""
+	for _, offered := range strings.Split(challenge.Params["qop"], ",") {
+		if strings.TrimSpace(offered) == "auth" {
+			qop = "auth"
+		}
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, `Digest username=%q, realm=%q, nonce=%q, uri=%q, algorithm=%s`, username, challenge.Params["realm"], nonce, uri, algorithm)
+	if qop != "" {
+		fmt.Fprintf(&b, `, response="%s", qop=auth, nc=00000001, cnonce=%q`, h(ha1+":"+nonce+":00000001:"+cnonce+":auth:"+ha2), cnonce)
+	} else {
+		fmt.Fprintf(&b, `, response="%s"`, h(ha1+":"+nonce+":"+ha2))
+	}
+	if opaque, ok := challenge.Params["opaque"]; ok {
+		fmt.Fprintf(&b, `, opaque=%q`, opaque)
+	}
+	return b.String(), nil
+}
+
+// authenticate answers the final response's 401 with the --http-auth
+// scheme: sending the credentials for basic and digest, and for ntlm and
+// negotiate, an NTLM negotiate message, whose challenge is decoded, then,
+// if credentials were given, the authenticate message, on the same
+// connection.
+func (scan *scan) authenticate(resp *http.Response) {
+	if resp.StatusCode != 401 || resp.Request == nil || resp.Request.URL == nil {
+		return
+	}
+	config := scan.scanner.config
+	scheme := authSchemes[config.HTTPAuth]
+	ret := &Auth{Scheme: config.HTTPAuth, Challenges: parseChallenges(resp.Header["Www-Authenticate"])}
+	scan.results.Auth = ret
+	challenge := findChallenge(ret.Challenges, scheme)
+	if challenge == nil {
+		ret.Error = fmt.Sprintf("the server does not offer %s authentication", scheme)
+		return
+	}
+	var err error
+	switch config.HTTPAuth {
+	case "basic":
+		credentials := base64.StdEncoding.EncodeToString([]byte(config.AuthUsername + ":" + config.AuthPassword))
+		err = scan.sendAuthorization(resp.Request, "Basic "+credentials, ret)
+	case "digest":
+		var authorization string
+		authorization, err = digestAuthorization(challenge, resp.Request.Method, resp.Request.URL.RequestURI(), config.AuthUsername, config.AuthPassword)
+		if err == nil {
+			err = scan.sendAuthorization(resp.Request, authorization, ret)
+		}
+	default:
+		err = scan.authenticateNTLM(resp.Request, scheme, ret)
+	}
+	if err != nil {
+		ret.Error = err.Error()
+	}
+}
+
+// authenticateNTLM performs the NTLM exchange, stopping after the challenge
+// if no credentials were given.
+func (scan *scan) authenticateNTLM(original *http.Request, scheme string, ret *Auth) error {
+	negotiate, err := encoder.Marshal(ntlmssp.NewNegotiate(scan.scanner.config.AuthDomain, ""))
+	if err != nil {
+		return err
+	}
+	if err := scan.sendAuthorization(original, scheme+" "+base64.StdEncoding.EncodeToString(negotiate), ret); err != nil {
+		return err
+	}
+	challengeResponse := ret.Response
+	ret.Response, ret.Authenticated = nil, false
+	if challengeResponse.StatusCode != 401 {
+		return fmt.Errorf("the server answered the negotiate message with %s", challengeResponse.Status)
+	}
+	reply := findChallenge(parseChallenges(challengeResponse.Header["Www-Authenticate"]), scheme)
+	if reply == nil || reply.Token == "" {
+		return errors.New("the server did not send an NTLM challenge")
+	}
+	token, err := base64.StdEncoding.DecodeString(reply.Token)
+	if err != nil {
+		return err
+	}
+	challenge, err := decodeNTLMChallenge(token)
+	if err != nil {
+		return err
+	}
+	ret.NTLM = newNTLMInfo(challenge)
+	config := scan.scanner.config
+	if config.AuthUsername == "" {
+		return nil
+	}
+	authenticate, err := encoder.Marshal(ntlmssp.NewAuthenticatePass(config.AuthDomain, config.AuthUsername, "", config.AuthPassword, *challenge))
+	if err != nil {
+		return err
+	}
+	return scan.sendAuthorization(original, scheme+" "+base64.StdEncoding.EncodeToString(authenticate), ret)
+}
+
+// sendAuthorization repeats the request with the given Authorization
+// header, on the connection left open by the last request where the server
+// keeps it alive, and records the response.
+func (scan *scan) sendAuthorization(original *http.Request, authorization string, ret *Auth) error {
+	var body io.Reader
+	if scan.scanner.body != nil && original.Method == scan.scanner.config.Method {
+		body = bytes.NewReader(scan.scanner.body)
+	}
+	request, err := http.NewRequest(original.Method, original.URL.String(), body)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Accept", "*/*")
+	request.Header.Set("User-Agent", scan.scanner.config.UserAgent)
+	scan.setAcceptEncoding(request)
+	if body != nil && scan.scanner.config.ContentType != "" {
+		request.Header.Set("Content-Type", scan.scanner.config.ContentType)
+	}
+	request.Header.Set("Authorization", authorization)
+	scan.addJarCookies(request)
+	resp, err := scan.transport.RoundTrip(request)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	scan.readBody(resp)
+	scan.storeJarCookies(request.URL, resp)
+	ret.Response = resp
+	ret.Authenticated = resp.StatusCode != 401
+	return nil
+}
+
+// NTLMInfo returns the names and version in the server's NTLM challenge, if
+// --http-auth=ntlm or negotiate was given and the server sent one.
+func (scanner *Scanner) NTLMInfo(result interface{}) *zgrab2.NTLMInfo {
+	results, ok := result.(*Results)
+	if !ok || results == nil || results.Auth == nil {
+		return nil
+	}
+	return results.Auth.NTLM
+}
@@ -0,0 +1,163 @@
+package http
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net"
+	nethttp "net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zmap/zgrab2"
+	"github.com/zmap/zgrab2/lib/smb/ntlmssp"
+	"github.com/zmap/zgrab2/lib/smb/smb/encoder"
+)
+
+func TestParseChallenges(t *testing.T) {
+	got := parseChallenges([]string{
+		`Negotiate, NTLM`,
+		`Basic realm="Intranet \"HR\"", charset="UTF-8", Digest realm=x, nonce="a,b", qop="auth,auth-int"`,
+		`NTLM TlRMTVNTUAACAAAA==`,
+	})
+	expected := []*AuthChallenge{
+		{Scheme: "Negotiate"},
+		{Scheme: "NTLM"},
+		{Scheme: "Basic", Params: map[string]string{"realm": `Intranet "HR"`, "charset": "UTF-8"}},
+		{Scheme: "Digest", Params: map[string]string{"realm": "x", "nonce": "a,b", "qop": "auth,auth-int"}},
+		{Scheme: "NTLM", Token: "TlRMTVNTUAACAAAA=="},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		for _, challenge := range got {
+			t.Logf("%+v", challenge)
+		}
+		t.Error("wrong challenges")
+	}
+}
+
+func newAuthScanner(t *testing.T, server *httptest.Server, auth, username string) *Scanner {
+	flags := &Flags{Method: "GET", Endpoint: "/", UserAgent: "zgrab2 test", MaxSize: 256, HTTPAuth: auth, AuthUsername: username, AuthPassword: "secret"}
+	flags.Port = uint(server.Listener.Addr().(*net.TCPAddr).Port)
+	flags.Timeout = 2 * time.Second
+	if err := flags.Validate(nil); err != nil {
+		t.Fatal(err)
+	}
+	scanner := new(Scanner)
+	if err := scanner.Init(flags); err != nil {
+		t.Fatal(err)
+	}
+	return scanner
+}
+
+func TestHTTPAuthNTLM(t *testing.T) {
+	challenge := ntlmssp.NewChallenge()
+	challenge.TargetName = encoder.ToUnicode("CORP")
+	challenge.Version = 10 | 0<<8 | 17763<<16 | 15<<56
+	challenge.TargetInfo = &ntlmssp.AvPairSlice{
+		{AvID: ntlmssp.MsvAvNbDomainName, AvLen: 8, Value: encoder.ToUnicode("CORP")},
+		{AvID: ntlmssp.MsvAvNbComputerName, AvLen: 8, Value: encoder.ToUnicode("WEB1")},
+		{AvID: ntlmssp.MsvAvDnsComputerName, AvLen: 30, Value: encoder.ToUnicode("web1.corp.local")},
+		{AvID: ntlmssp.MsvAvEOL},
+	}
+	message, err := encoder.Marshal(challenge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var challengedOn string
+	handler := nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		authorization := r.Header.Get("Authorization")
+		if !strings.HasPrefix(authorization, "NTLM ") {
+			w.Header().Add("WWW-Authenticate", "Negotiate")
+			w.Header().Add("WWW-Authenticate", "NTLM")
+			w.WriteHeader(401)
+			return
+		}
+		token, _ := base64.StdEncoding.DecodeString(authorization[5:])
+		if len(token) < 12 || string(token[:8]) != ntlmssp.Signature {
+			w.WriteHeader(400)
+			return
+		}
+		switch token[8] {
+		case 1:
+			challengedOn = r.RemoteAddr
+			w.Header().Set("WWW-Authenticate", "NTLM "+base64.StdEncoding.EncodeToString(message))
+			w.WriteHeader(401)
+		case 3:
+			if r.RemoteAddr != challengedOn {
+				w.WriteHeader(401)
+				return
+			}
+			w.Write([]byte("welcome"))
+		}
+	})
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	target := zgrab2.ScanTarget{IP: net.ParseIP("127.0.0.1")}
+
+	scanner := newAuthScanner(t, server, "ntlm", "")
+	if classes := scanner.ProbeClasses(); len(classes) != 1 || classes[0] != zgrab2.ProbeAuthenticating {
+		t.Errorf("wrong probe classes %v", classes)
+	}
+	_, ret, _ := scanner.Scan(target)
+	results := ret.(*Results)
+	if results.Auth == nil || results.Auth.Error != "" || len(results.Auth.Challenges) != 2 {
+		t.Fatalf("wrong auth %+v", results.Auth)
+	}
+	expected := &zgrab2.NTLMInfo{
+		TargetName:          "CORP",
+		NetBIOSComputerName: "WEB1",
+		NetBIOSDomainName:   "CORP",
+		DNSComputerName:     "web1.corp.local",
+		OSVersion:           "10.0.17763",
+	}
+	if info := scanner.NTLMInfo(results); !reflect.DeepEqual(info, expected) {
+		t.Errorf("wrong NTLM info %+v", info)
+	}
+	if results.Auth.Authenticated || results.Auth.Response != nil {
+		t.Errorf("authenticated without credentials")
+	}
+
+	_, ret, _ = newAuthScanner(t, server, "ntlm", "alice").Scan(target)
+	auth := ret.(*Results).Auth
+	if auth == nil || !auth.Authenticated || auth.Response == nil || auth.Response.BodyText != "welcome" {
+		t.Errorf("wrong auth with credentials %+v", auth)
+	}
+
+	_, ret, _ = newAuthScanner(t, server, "basic", "alice").Scan(target)
+	if auth := ret.(*Results).Auth; auth == nil || auth.Error != "the server does not offer Basic authentication" {
+		t.Errorf("wrong auth with an unoffered scheme %+v", auth)
+	}
+}
+
+func TestHTTPAuthDigest(t *testing.T) {
+	md5hex := func(s string) string {
+		sum := md5.Sum([]byte(s))
+		return hex.EncodeToString(sum[:])
+	}
+	handler := nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		challenges := parseChallenges([]string{r.Header.Get("Authorization")})
+		if len(challenges) == 1 && challenges[0].Scheme == "Digest" {
+			p := challenges[0].Params
+			ha1 := md5hex("alice:zgrab:secret")
+			ha2 := md5hex(r.Method + ":" + p["uri"])
+			expected := md5hex(fmt.Sprintf("%s:%s:%s:%s:%s:%s", ha1, p["nonce"], p["nc"], p["cnonce"], p["qop"], ha2))
+			if p["response"] == expected && p["opaque"] == "op" && p["uri"] == "/" {
+				w.Write([]byte("welcome"))
+				return
+			}
+		}
+		w.Header().Set("WWW-Authenticate", `Digest realm="zgrab", qop="auth,auth-int", nonce="n0nce", opaque="op"`)
+		w.WriteHeader(401)
+	})
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	_, ret, _ := newAuthScanner(t, server, "digest", "alice").Scan(zgrab2.ScanTarget{IP: net.ParseIP("127.0.0.1")})
+	if auth := ret.(*Results).Auth; auth == nil || !auth.Authenticated || auth.Response.BodyText != "welcome" {
+		t.Errorf("wrong auth %+v", auth)
+	}
+}
@@ -0,0 +1,99 @@
+package http
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/zmap/zgrab2/lib/http"
+)
+
+// setBody records the body read of the response, with its SHA-256 digest
+// and MurmurHash3.
+func setBody(res *http.Response, body []byte) {
+	res.BodyText = string(body)
+	if len(body) == 0 {
+		return
+	}
+	sum := sha256.Sum256(body)
+	res.BodySHA256 = sum[:]
+	mmh3 := int32(murmur3(body, 0))
+	res.BodyMMH3 = &mmh3
+}
+
+// storeBody writes the body of the response to a file in dir named by its
+// SHA-256 digest, in a subdirectory named by the digest's first byte (e.g.
+// 3f/3fa2...), and records the file in its place. Bodies already stored are
+// not written again.
+func storeBody(dir string, res *http.Response) error {
+	if res == nil || res.BodyText == "" {
+		return nil
+	}
+	name := hex.EncodeToString(res.BodySHA256)
+	file := filepath.Join(name[:2], name)
+	path := filepath.Join(dir, file)
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		// The body is written to a temporary file renamed into place, so
+		// that concurrent scans and readers never see a partial body.
+		tmp, err := ioutil.TempFile(filepath.Dir(path), name+".tmp")
+		if err != nil {
+			return err
+		}
+		_, err = tmp.WriteString(res.BodyText)
+		if closeErr := tmp.Close(); err == nil {
+			err = closeErr
+		}
+		if err == nil {
+			err = os.Rename(tmp.Name(), path)
+		}
+		if err != nil {
+			os.Remove(tmp.Name())
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+	res.BodyFile = filepath.ToSlash(file)
+	res.BodyText = ""
+	return nil
+}
+
+// responses returns every response in the results.
+func (results *Results) responses() []*http.Response {
+	ret := append([]*http.Response{results.Response}, results.RedirectResponseChain...)
+	if results.Auth != nil {
+		ret = append(ret, results.Auth.Response)
+	}
+	if results.HTTP2 != nil {
+		ret = append(ret, results.HTTP2.Response)
+	}
+	if results.HTTP3 != nil {
+		ret = append(ret, results.HTTP3.Response)
+	}
+	if results.WebSocket != nil {
+		ret = append(ret, results.WebSocket.Response)
+	}
+	for _, response := range results.Sequence {
+		ret = append(ret, response.Response)
+	}
+	for _, file := range results.Files {
+		ret = append(ret, file.Response)
+	}
+	return ret
+}
+
+// storeBodies writes the bodies of the responses to the --body-store-dir. A
+// body that cannot be written is left in its response.
+func (scan *scan) storeBodies() {
+	for _, response := range scan.results.responses() {
+		if err := storeBody(scan.scanner.config.BodyStoreDir, response); err != nil {
+			log.Warnf("could not store body: %v", err)
+		}
+	}
+}
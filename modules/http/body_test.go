@@ -0,0 +1,80 @@
+package http
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net"
+	nethttp "net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/zmap/zgrab2"
+)
+
+func TestStoreBodies(t *testing.T) {
+	server := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		if r.URL.Path == "/" {
+			nethttp.Redirect(w, r, "/hello", nethttp.StatusFound)
+			return
+		}
+		w.Write([]byte("hello"))
+	}))
+	defer server.Close()
+	dir, err := ioutil.TempDir("", "zgrab2-bodies")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	flags := &Flags{Method: "GET", Endpoint: "/", UserAgent: "zgrab2 test", MaxSize: 256, MaxRedirects: 1, FollowLocalhostRedirects: true}
+	flags.Port = uint(server.Listener.Addr().(*net.TCPAddr).Port)
+	flags.Timeout = 2 * time.Second
+	if err := flags.Validate(nil); err != nil {
+		t.Fatal(err)
+	}
+	var scanner Scanner
+	if err := scanner.Init(flags); err != nil {
+		t.Fatal(err)
+	}
+	_, ret, err := scanner.Scan(zgrab2.ScanTarget{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	response := ret.(*Results).Response
+	sum := sha256.Sum256([]byte("hello"))
+	// mmh3.hash("hello") in Python.
+	if response.BodyText != "hello" || hex.EncodeToString(response.BodySHA256) != hex.EncodeToString(sum[:]) || response.BodyMMH3 == nil || *response.BodyMMH3 != 613153351 {
+		t.Errorf("wrong body hashes %q %x %v", response.BodyText, response.BodySHA256, response.BodyMMH3)
+	}
+
+	flags.BodyStoreDir = filepath.Join(dir, "store")
+	if err := scanner.Init(flags); err != nil {
+		t.Fatal(err)
+	}
+	_, ret, err = scanner.Scan(zgrab2.ScanTarget{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	results := ret.(*Results)
+	name := hex.EncodeToString(sum[:])
+	response = results.Response
+	if response.BodyText != "" || response.BodyFile != name[:2]+"/"+name || response.BodyMMH3 == nil {
+		t.Fatalf("body not stored: %+v", response)
+	}
+	stored, err := ioutil.ReadFile(filepath.Join(flags.BodyStoreDir, response.BodyFile))
+	if err != nil || string(stored) != "hello" {
+		t.Errorf("wrong stored body %q: %v", stored, err)
+	}
+	if len(results.RedirectResponseChain) != 1 || results.RedirectResponseChain[0].BodyText != "" || results.RedirectResponseChain[0].BodyFile == "" {
+		t.Errorf("redirect body not stored: %+v", results.RedirectResponseChain)
+	}
+
+	flags.Lite = true
+	if err := flags.Validate(nil); err == nil {
+		t.Error("expected an error for --lite with --body-store-dir")
+	}
+}
@@ -0,0 +1,165 @@
+package http
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zmap/zgrab2/lib/http"
+)
+
+// SetCookie is a Set-Cookie header, parsed. Unlike http.Cookie, it keeps
+// cookies with invalid names or values, and the attributes that the jar does
+// not use, such as SameSite.
+type SetCookie struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+
+	// Domain is empty for a host-only cookie.
+	Domain string `json:"domain,omitempty"`
+	Path   string `json:"path,omitempty"`
+
+	// Expires is the Expires attribute, and ExpiresTime its time, if it
+	// could be parsed.
+	Expires     string     `json:"expires,omitempty"`
+	ExpiresTime *time.Time `json:"expires_time,omitempty"`
+	MaxAge      *int       `json:"max_age,omitempty"`
+
+	Secure      bool   `json:"secure"`
+	HttpOnly    bool   `json:"http_only"`
+	SameSite    string `json:"same_site,omitempty"`
+	Partitioned bool   `json:"partitioned,omitempty"`
+
+	// Prefix is __Secure- or __Host- if the name starts with one (RFC
+	// 6265bis, section 4.1.3), in which case the browser only accepts the
+	// cookie if its attributes meet the prefix's requirements.
+	Prefix string `json:"prefix,omitempty"`
+
+	// Unparsed are the attributes not recognized, or with invalid values.
+	Unparsed []string `json:"unparsed,omitempty"`
+	Raw      string   `json:"raw"`
+}
+
+// SentCookie is a cookie sent in a request's Cookie header.
+type SentCookie struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// CookieExchange is the cookies sent with a request with --cookie-jar, and
+// those set by its response.
+type CookieExchange struct {
+	URL  string        `json:"url"`
+	Sent []*SentCookie `json:"sent,omitempty"`
+	Set  []*SetCookie  `json:"set,omitempty"`
+}
+
+// parseSetCookie parses a Set-Cookie header as RFC 6265, section 5.2 does:
+// a pair without an = is a value with an empty name.
+func parseSetCookie(line string) *SetCookie {
+	parts := strings.Split(line, ";")
+	ret := &SetCookie{Raw: line}
+	pair := strings.TrimSpace(parts[0])
+	if i := strings.IndexByte(pair, '='); i >= 0 {
+		ret.Name, ret.Value = strings.TrimSpace(pair[:i]), strings.TrimSpace(pair[i+1:])
+	} else {
+		ret.Value = pair
+	}
+	for _, prefix := range []string{"__Secure-", "__Host-"} {
+		if strings.HasPrefix(ret.Name, prefix) {
+			ret.Prefix = prefix
+		}
+	}
+	for _, part := range parts[1:] {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		attr, value := part, ""
+		if i := strings.IndexByte(part, '='); i >= 0 {
+			attr, value = strings.TrimSpace(part[:i]), strings.TrimSpace(part[i+1:])
+		}
+		switch strings.ToLower(attr) {
+		case "domain":
+			ret.Domain = strings.TrimPrefix(value, ".")
+		case "path":
+			ret.Path = value
+		case "expires":
+			ret.Expires = value
+			if expires, err := http.ParseTime(value); err == nil {
+				ret.ExpiresTime = &expires
+			} else if expires, err := time.Parse("Mon, 02-Jan-2006 15:04:05 MST", value); err == nil {
+				ret.ExpiresTime = &expires
+			}
+		case "max-age":
+			maxAge, err := strconv.Atoi(value)
+			if err != nil {
+				ret.Unparsed = append(ret.Unparsed, part)
+				continue
+			}
+			ret.MaxAge = &maxAge
+		case "secure":
+			ret.Secure = true
+		case "httponly":
+			ret.HttpOnly = true
+		case "samesite":
+			ret.SameSite = value
+		case "partitioned":
+			ret.Partitioned = true
+		default:
+			ret.Unparsed = append(ret.Unparsed, part)
+		}
+	}
+	return ret
+}
+
+// newCookieExchange records the cookies sent with the response's request,
+// and those the response set.
+func newCookieExchange(resp *http.Response) *CookieExchange {
+	ret := new(CookieExchange)
+	if resp.Request != nil {
+		if resp.Request.URL != nil {
+			ret.URL = resp.Request.URL.String()
+		}
+		for _, cookie := range resp.Request.Cookies() {
+			ret.Sent = append(ret.Sent, &SentCookie{Name: cookie.Name, Value: cookie.Value})
+		}
+	}
+	for _, line := range resp.Header["Set-Cookie"] {
+		ret.Set = append(ret.Set, parseSetCookie(line))
+	}
+	return ret
+}
+
+// cookies records the cookie exchanges of each response in the redirect
+// chain, and of the final response.
+func (scan *scan) cookies(resp *http.Response) {
+	for _, redirect := range scan.results.RedirectResponseChain {
+		scan.results.Cookies = append(scan.results.Cookies, newCookieExchange(redirect))
+	}
+	scan.results.Cookies = append(scan.results.Cookies, newCookieExchange(resp))
+}
+
+// addJarCookies adds the cookies in the jar for the request's URL, for
+// requests sent without the client.
+func (scan *scan) addJarCookies(request *http.Request) {
+	if scan.client.Jar == nil {
+		return
+	}
+	for _, cookie := range scan.client.Jar.Cookies(request.URL) {
+		request.AddCookie(cookie)
+	}
+}
+
+// storeJarCookies stores the cookies set by a response to a request sent
+// without the client, and records the exchange.
+func (scan *scan) storeJarCookies(target *url.URL, resp *http.Response) {
+	if scan.client.Jar == nil {
+		return
+	}
+	if cookies := resp.Cookies(); len(cookies) > 0 {
+		scan.client.Jar.SetCookies(target, cookies)
+	}
+	scan.results.Cookies = append(scan.results.Cookies, newCookieExchange(resp))
+}
@@ -0,0 +1,98 @@
+package http
+
+import (
+	"io/ioutil"
+	"net"
+	nethttp "net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/zmap/zgrab2"
+)
+
+func TestParseSetCookie(t *testing.T) {
+	maxAge := 0
+	expires := time.Date(2015, 10, 21, 7, 28, 0, 0, time.UTC)
+	for line, expected := range map[string]*SetCookie{
+		"id=a3fWa; Expires=Wed, 21 Oct 2015 07:28:00 GMT; Secure; HttpOnly; SameSite=Strict": {
+			Name: "id", Value: "a3fWa", Expires: "Wed, 21 Oct 2015 07:28:00 GMT", ExpiresTime: &expires,
+			Secure: true, HttpOnly: true, SameSite: "Strict",
+		},
+		"__Host-sid=x y; path=/; Max-Age=0; domain=.Example.com; Partitioned; Priority=High": {
+			Name: "__Host-sid", Value: "x y", Path: "/", MaxAge: &maxAge, Domain: "Example.com",
+			Partitioned: true, Prefix: "__Host-", Unparsed: []string{"Priority=High"},
+		},
+		"novalue; max-age=soon": {Value: "novalue", Unparsed: []string{"max-age=soon"}},
+	} {
+		expected.Raw = line
+		if got := parseSetCookie(line); !reflect.DeepEqual(got, expected) {
+			t.Errorf("%q: got %+v, expected %+v", line, got, expected)
+		}
+	}
+}
+
+func TestCookieJar(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cookies")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sequenceFile := filepath.Join(dir, "sequence.yaml")
+	if err := ioutil.WriteFile(sequenceFile, []byte("requests:\n  - path: /account\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	handler := nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		switch r.URL.Path {
+		case "/":
+			w.Header().Add("Set-Cookie", "session=1; Path=/; HttpOnly; SameSite=Lax")
+			w.Header().Set("Location", "/login")
+			w.WriteHeader(302)
+		case "/login":
+			w.Header().Add("Set-Cookie", "session=2; Path=/")
+			w.Header().Add("Set-Cookie", "theme=dark; Path=/login")
+		}
+		w.Write([]byte(r.Header.Get("Cookie")))
+	})
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	flags := &Flags{Method: "GET", Endpoint: "/", UserAgent: "zgrab2 test", MaxSize: 256, MaxRedirects: 1, FollowLocalhostRedirects: true, CookieJar: true, SequenceFile: sequenceFile}
+	flags.Port = uint(server.Listener.Addr().(*net.TCPAddr).Port)
+	flags.Timeout = 2 * time.Second
+	if err := flags.Validate(nil); err != nil {
+		t.Fatal(err)
+	}
+	var scanner Scanner
+	if err := scanner.Init(flags); err != nil {
+		t.Fatal(err)
+	}
+	status, ret, err := scanner.Scan(zgrab2.ScanTarget{IP: net.ParseIP("127.0.0.1")})
+	if status != zgrab2.SCAN_SUCCESS {
+		t.Fatalf("scan failed: %s %v", status, err)
+	}
+	results := ret.(*Results)
+	if body := results.Response.BodyText; body != "session=1" {
+		t.Errorf("wrong cookies sent with the redirect %q", body)
+	}
+	if len(results.Sequence) != 1 || results.Sequence[0].Response == nil || results.Sequence[0].Response.BodyText != "session=2" {
+		t.Errorf("wrong cookies sent with the sequence %+v", results.Sequence)
+	}
+	var history [][2]int
+	for _, exchange := range results.Cookies {
+		history = append(history, [2]int{len(exchange.Sent), len(exchange.Set)})
+	}
+	if !reflect.DeepEqual(history, [][2]int{{0, 1}, {1, 2}, {1, 0}}) {
+		t.Fatalf("wrong cookie history %v", history)
+	}
+	if set := results.Cookies[0].Set[0]; set.Name != "session" || !set.HttpOnly || set.SameSite != "Lax" {
+		t.Errorf("wrong cookie set %+v", set)
+	}
+	if sent := results.Cookies[1].Sent[0]; sent.Name != "session" || sent.Value != "1" || results.Cookies[1].URL != server.URL+"/login" {
+		t.Errorf("wrong cookie sent %+v to %s", sent, results.Cookies[1].URL)
+	}
+}
@@ -0,0 +1,218 @@
+package http
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+	"github.com/zmap/zgrab2/lib/http"
+)
+
+// EncodingProbe is the response to a request for the final URL with one
+// Accept-Encoding value, with --probe-encodings.
+type EncodingProbe struct {
+	AcceptEncoding  string `json:"accept_encoding"`
+	StatusCode      int    `json:"status_code,omitempty"`
+	ContentEncoding string `json:"content_encoding,omitempty"`
+	Vary            string `json:"vary,omitempty"`
+
+	// Length is the number of body bytes received, up to --max-size.
+	Length    int  `json:"length"`
+	Truncated bool `json:"truncated,omitempty"`
+
+	// BodySHA256 is the digest of the body as received.
+	BodySHA256 http.PageFingerprint `json:"body_sha256,omitempty"`
+
+	// DecodedSHA256 is the digest of the decoded body, for encodings that
+	// can be decoded (identity, gzip, deflate, br and zstd) and bodies that
+	// were received in full.
+	DecodedSHA256 http.PageFingerprint `json:"decoded_sha256,omitempty"`
+
+	Error string `json:"error,omitempty"`
+}
+
+// Encodings compares the responses to requests with different
+// Accept-Encoding values.
+type Encodings struct {
+	Probes []*EncodingProbe `json:"probes"`
+
+	// Used are the content encodings the server used, in the order first
+	// seen; identity for responses without one.
+	Used []string `json:"used,omitempty"`
+
+	// ContentDiffers is true if the decoded bodies are not all the same.
+	ContentDiffers bool `json:"content_differs"`
+}
+
+// errUnknownEncoding is returned for content encodings that cannot be
+// decoded.
+var errUnknownEncoding = errors.New("unknown content encoding")
+
+// zstdMaxWindow is the largest zstd window decoded, as recommended for the
+// zstd content encoding (RFC 9659), bounding the memory a body can demand.
+const zstdMaxWindow = 8 << 20
+
+// newDecoder returns a reader decoding the content encoding from r.
+func newDecoder(encoding string, r io.Reader) (io.ReadCloser, error) {
+	switch encoding {
+	case "", "identity":
+		return ioutil.NopCloser(r), nil
+	case "gzip", "x-gzip":
+		return gzip.NewReader(r)
+	case "deflate":
+		// deflate is meant to be zlib, but some servers send a raw
+		// DEFLATE stream.
+		br := bufio.NewReader(r)
+		if header, err := br.Peek(2); err == nil && header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+			return zlib.NewReader(br)
+		}
+		return flate.NewReader(br), nil
+	case "br":
+		return ioutil.NopCloser(brotli.NewReader(r)), nil
+	case "zstd":
+		decoder, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1), zstd.WithDecoderLowmem(true), zstd.WithDecoderMaxWindow(zstdMaxWindow))
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	}
+	return nil, errUnknownEncoding
+}
+
+// contentEncodings returns the content encodings of the response, in the
+// order they were applied.
+func contentEncodings(header http.Header) []string {
+	var ret []string
+	for _, encoding := range splitHeaderList(header["Content-Encoding"]) {
+		if encoding = strings.ToLower(encoding); encoding != "identity" {
+			ret = append(ret, encoding)
+		}
+	}
+	return ret
+}
+
+// decode decodes up to limit bytes of a body with the content encodings, in
+// the order they were applied.
+func decode(encodings []string, body []byte, limit int64) ([]byte, error) {
+	var reader io.Reader = bytes.NewReader(body)
+	for i := len(encodings) - 1; i >= 0; i-- {
+		decoder, err := newDecoder(encodings[i], reader)
+		if err != nil {
+			return nil, err
+		}
+		defer decoder.Close()
+		reader = decoder
+	}
+	var decoded bytes.Buffer
+	_, err := io.CopyN(&decoded, reader, limit)
+	if err == io.EOF {
+		err = nil
+	}
+	return decoded.Bytes(), err
+}
+
+// decodeBody decodes a body with the given content encoding, returning false
+// if it cannot.
+func decodeBody(encoding string, body []byte) ([]byte, bool) {
+	reader, err := newDecoder(encoding, bytes.NewReader(body))
+	if err != nil {
+		return nil, false
+	}
+	defer reader.Close()
+	decoded, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, false
+	}
+	return decoded, true
+}
+
+// probeEncoding requests the URL accepting the given encoding.
+func (scan *scan) probeEncoding(url, acceptEncoding string) *EncodingProbe {
+	ret := &EncodingProbe{AcceptEncoding: acceptEncoding}
+	request, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		ret.Error = err.Error()
+		return ret
+	}
+	request.Header.Set("Accept", "*/*")
+	request.Header.Set("Accept-Encoding", acceptEncoding)
+	request.Header.Set("User-Agent", scan.scanner.config.UserAgent)
+	// The transport does not follow redirects, and, since Accept-Encoding
+	// is set, leaves the body as received.
+	resp, err := scan.transport.RoundTrip(request)
+	if err != nil {
+		ret.Error = err.Error()
+		return ret
+	}
+	defer resp.Body.Close()
+	ret.StatusCode = resp.StatusCode
+	ret.ContentEncoding = strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding")))
+	ret.Vary = resp.Header.Get("Vary")
+	maxSize := int64(scan.scanner.config.MaxSize) * 1024
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxSize+1))
+	if err != nil {
+		ret.Error = err.Error()
+	}
+	if int64(len(body)) > maxSize {
+		body, ret.Truncated = body[:maxSize], true
+	}
+	ret.Length = len(body)
+	sum := sha256.Sum256(body)
+	ret.BodySHA256 = sum[:]
+	if err == nil && !ret.Truncated {
+		if decoded, ok := decodeBody(ret.ContentEncoding, body); ok {
+			sum := sha256.Sum256(decoded)
+			ret.DecodedSHA256 = sum[:]
+		}
+	}
+	return ret
+}
+
+// probeEncodings requests the final URL once for each of the
+// --probe-encoding-list, recording the encodings the server uses and whether
+// the content differs.
+func (scan *scan) probeEncodings(resp *http.Response) {
+	if resp.Request == nil || resp.Request.URL == nil {
+		return
+	}
+	url := resp.Request.URL.String()
+	ret := new(Encodings)
+	used := make(map[string]bool)
+	var decoded []byte
+	for _, encoding := range strings.Split(scan.scanner.config.ProbeEncodingList, ",") {
+		encoding = strings.TrimSpace(encoding)
+		if encoding == "" {
+			continue
+		}
+		probe := scan.probeEncoding(url, encoding)
+		ret.Probes = append(ret.Probes, probe)
+		if probe.Error != "" {
+			continue
+		}
+		contentEncoding := probe.ContentEncoding
+		if contentEncoding == "" {
+			contentEncoding = "identity"
+		}
+		if !used[contentEncoding] {
+			used[contentEncoding] = true
+			ret.Used = append(ret.Used, contentEncoding)
+		}
+		if probe.DecodedSHA256 != nil {
+			if decoded == nil {
+				decoded = probe.DecodedSHA256
+			} else if !bytes.Equal(decoded, probe.DecodedSHA256) {
+				ret.ContentDiffers = true
+			}
+		}
+	}
+	scan.results.Encodings = ret
+}
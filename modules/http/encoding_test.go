@@ -0,0 +1,162 @@
+package http
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"net"
+	nethttp "net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+	"github.com/zmap/zgrab2"
+)
+
+func TestProbeEncodings(t *testing.T) {
+	const page = "<html><body>Hello, world</body></html>"
+	server := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		w.Header().Set("Vary", "Accept-Encoding")
+		var body bytes.Buffer
+		switch r.Header.Get("Accept-Encoding") {
+		case "gzip":
+			w.Header().Set("Content-Encoding", "gzip")
+			writer := gzip.NewWriter(&body)
+			writer.Write([]byte(page))
+			writer.Close()
+		case "deflate":
+			// A different page, e.g. from a misconfigured cache.
+			w.Header().Set("Content-Encoding", "deflate")
+			writer := zlib.NewWriter(&body)
+			writer.Write([]byte("<html><body>Goodbye</body></html>"))
+			writer.Close()
+		case "br":
+			// Invalid, so neither decoded nor compared.
+			w.Header().Set("Content-Encoding", "br")
+			body.Write([]byte{0x1b, 0x25, 0, 0xf8})
+		default:
+			body.WriteString(page)
+		}
+		w.Write(body.Bytes())
+	}))
+	defer server.Close()
+
+	flags := &Flags{Method: "GET", Endpoint: "/", UserAgent: "zgrab2 test", MaxSize: 256, ProbeEncodings: true, ProbeEncodingList: "identity,gzip,br"}
+	flags.Port = uint(server.Listener.Addr().(*net.TCPAddr).Port)
+	flags.Timeout = time.Second
+	var scanner Scanner
+	if err := scanner.Init(flags); err != nil {
+		t.Fatal(err)
+	}
+	status, ret, err := scanner.Scan(zgrab2.ScanTarget{IP: net.ParseIP("127.0.0.1")})
+	if status != zgrab2.SCAN_SUCCESS {
+		t.Fatalf("scan failed: %s %v", status, err)
+	}
+	encodings := ret.(*Results).Encodings
+	if encodings == nil || len(encodings.Probes) != 3 {
+		t.Fatalf("wrong encodings %+v", encodings)
+	}
+	if !reflect.DeepEqual(encodings.Used, []string{"identity", "gzip", "br"}) || encodings.ContentDiffers {
+		t.Errorf("wrong encodings %+v", encodings)
+	}
+	identity, gzipped, brotli := encodings.Probes[0], encodings.Probes[1], encodings.Probes[2]
+	if identity.Length != len(page) || identity.Vary != "Accept-Encoding" || !bytes.Equal(identity.BodySHA256, identity.DecodedSHA256) {
+		t.Errorf("wrong identity probe %+v", identity)
+	}
+	if gzipped.ContentEncoding != "gzip" || bytes.Equal(gzipped.BodySHA256, gzipped.DecodedSHA256) || !bytes.Equal(gzipped.DecodedSHA256, identity.DecodedSHA256) {
+		t.Errorf("wrong gzip probe %+v", gzipped)
+	}
+	if brotli.ContentEncoding != "br" || brotli.DecodedSHA256 != nil || brotli.Length != 4 {
+		t.Errorf("wrong br probe %+v", brotli)
+	}
+
+	flags.ProbeEncodingList = "identity,deflate"
+	_, ret, _ = scanner.Scan(zgrab2.ScanTarget{IP: net.ParseIP("127.0.0.1")})
+	if encodings := ret.(*Results).Encodings; !encodings.ContentDiffers {
+		t.Errorf("different content not noticed: %+v", encodings)
+	}
+}
+
+func TestDecodeBodies(t *testing.T) {
+	page := strings.Repeat("<p>Hello, world</p>", 100)
+	var acceptEncoding string
+	server := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		acceptEncoding = r.Header.Get("Accept-Encoding")
+		content := page
+		if r.URL.Query().Get("bomb") != "" {
+			content = strings.Repeat("\x00", 1<<20)
+		}
+		var body bytes.Buffer
+		encoding := r.URL.Query().Get("encoding")
+		if r.URL.Query().Get("invalid") != "" {
+			w.Header().Set("Content-Encoding", encoding)
+			w.Write([]byte("not compressed"))
+			return
+		}
+		switch encoding {
+		case "br":
+			writer := brotli.NewWriter(&body)
+			writer.Write([]byte(content))
+			writer.Close()
+		case "zstd":
+			writer, _ := zstd.NewWriter(&body)
+			writer.Write([]byte(content))
+			writer.Close()
+		case "gzip, br":
+			var gzipped bytes.Buffer
+			gzipWriter := gzip.NewWriter(&gzipped)
+			gzipWriter.Write([]byte(content))
+			gzipWriter.Close()
+			writer := brotli.NewWriter(&body)
+			writer.Write(gzipped.Bytes())
+			writer.Close()
+		}
+		w.Header().Set("Content-Encoding", encoding)
+		w.Write(body.Bytes())
+	}))
+	defer server.Close()
+
+	flags := &Flags{Method: "GET", UserAgent: "zgrab2 test", MaxSize: 256, AcceptEncoding: "gzip, br, zstd"}
+	flags.Port = uint(server.Listener.Addr().(*net.TCPAddr).Port)
+	flags.Timeout = time.Second
+	var scanner Scanner
+	scan := func(endpoint string) *Results {
+		flags.Endpoint = endpoint
+		if err := scanner.Init(flags); err != nil {
+			t.Fatal(err)
+		}
+		_, ret, err := scanner.Scan(zgrab2.ScanTarget{IP: net.ParseIP("127.0.0.1")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ret.(*Results)
+	}
+
+	for _, encoding := range []string{"br", "zstd", "gzip,+br"} {
+		response := scan("/?encoding=" + encoding).Response
+		if response.BodyText != page || response.DecodedLength != int64(len(page)) || response.EncodedLength == 0 || response.EncodedLength >= response.DecodedLength || response.DecodeError != "" {
+			t.Errorf("%s body not decoded: %q, %d from %d bytes, %s", encoding, response.BodyText, response.DecodedLength, response.EncodedLength, response.DecodeError)
+		}
+	}
+	if acceptEncoding != "gzip, br, zstd" {
+		t.Errorf("wrong Accept-Encoding %q", acceptEncoding)
+	}
+
+	// A body decoding to far more than --max-size is cut short.
+	flags.MaxSize = 1
+	response := scan("/?encoding=zstd&bomb=1").Response
+	if response.DecodedLength != 1024 || len(response.BodyText) != 1024 || response.DecodeError != "" {
+		t.Errorf("bomb not bounded: %d bytes, %s", response.DecodedLength, response.DecodeError)
+	}
+
+	// A body that cannot be decoded is kept as received.
+	flags.MaxSize = 256
+	response = scan("/?encoding=zstd&invalid=1").Response
+	if response.BodyText != "not compressed" || response.DecodeError == "" || response.DecodedLength != 0 {
+		t.Errorf("wrong undecodable body %q, %s", response.BodyText, response.DecodeError)
+	}
+}
@@ -0,0 +1,200 @@
+package http
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/bits"
+	"net/url"
+	"strings"
+
+	"github.com/zmap/zgrab2/lib/http"
+)
+
+// maxFaviconRedirects is the most redirects followed to fetch the icon.
+const maxFaviconRedirects = 3
+
+// Favicon is the site's icon, with --with-favicon: the one the final page
+// links to, or else /favicon.ico.
+type Favicon struct {
+	// URL is the icon's URL, and Source where it came from: html for the
+	// page's <link rel="icon">, or default for /favicon.ico.
+	URL    string `json:"url"`
+	Source string `json:"source"`
+
+	// FinalURL is the URL the icon was served from, if the request for it
+	// was redirected.
+	FinalURL string `json:"final_url,omitempty"`
+
+	StatusCode  int    `json:"status_code,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+	Length      int    `json:"length"`
+
+	// Truncated is true if the icon was longer than --max-size, in which
+	// case it is not hashed.
+	Truncated bool `json:"truncated,omitempty"`
+
+	// MD5 is the hex MD5 digest of the icon, and MMH3 the MurmurHash3 of
+	// its base64 encoding (in lines of 76 characters, each ending with a
+	// newline), as a signed integer: the hash Shodan's http.favicon.hash
+	// filter matches.
+	MD5  string `json:"md5,omitempty"`
+	MMH3 *int32 `json:"mmh3,omitempty"`
+
+	Error string `json:"error,omitempty"`
+}
+
+// faviconURL returns the URL of the icon the page links to, resolved
+// against the page's URL, or else /favicon.ico on the page's origin.
+func faviconURL(page *url.URL, body []byte) (*url.URL, string) {
+	if href := htmlIcon(body); href != "" {
+		if icon, err := page.Parse(href); err == nil {
+			return icon, "html"
+		}
+	}
+	return &url.URL{Scheme: page.Scheme, Host: page.Host, Path: "/favicon.ico"}, "default"
+}
+
+// murmur3 returns the 32-bit MurmurHash3 (x86) of data.
+func murmur3(data []byte, seed uint32) uint32 {
+	const c1, c2 = 0xcc9e2d51, 0x1b873593
+	h := seed
+	n := len(data) / 4 * 4
+	for i := 0; i < n; i += 4 {
+		k := binary.LittleEndian.Uint32(data[i:])
+		k = bits.RotateLeft32(k*c1, 15) * c2
+		h ^= k
+		h = bits.RotateLeft32(h, 13)*5 + 0xe6546b64
+	}
+	var k uint32
+	switch tail := data[n:]; len(tail) {
+	case 3:
+		k ^= uint32(tail[2]) << 16
+		fallthrough
+	case 2:
+		k ^= uint32(tail[1]) << 8
+		fallthrough
+	case 1:
+		k ^= uint32(tail[0])
+		h ^= bits.RotateLeft32(k*c1, 15) * c2
+	}
+	h ^= uint32(len(data))
+	h ^= h >> 16
+	h *= 0x85ebca6b
+	h ^= h >> 13
+	h *= 0xc2b2ae35
+	h ^= h >> 16
+	return h
+}
+
+// faviconHash returns the MurmurHash3 of the icon's base64 encoding, broken
+// into lines as by Python's base64.encodebytes.
+func faviconHash(icon []byte) int32 {
+	encoded := base64.StdEncoding.EncodeToString(icon)
+	var b strings.Builder
+	for len(encoded) > 76 {
+		b.WriteString(encoded[:76])
+		b.WriteByte('\n')
+		encoded = encoded[76:]
+	}
+	b.WriteString(encoded)
+	b.WriteByte('\n')
+	return int32(murmur3([]byte(b.String()), 0))
+}
+
+// decodeDataURL returns the media type and contents of a data: URL.
+func decodeDataURL(u *url.URL) (string, []byte, error) {
+	data := u.Opaque
+	if data == "" {
+		data = strings.TrimPrefix(u.String(), "data:")
+	}
+	comma := strings.IndexByte(data, ',')
+	if comma < 0 {
+		return "", nil, errors.New("malformed data URL")
+	}
+	mediaType, payload := data[:comma], data[comma+1:]
+	if strings.HasSuffix(mediaType, ";base64") {
+		decoded, err := base64.StdEncoding.DecodeString(payload)
+		return strings.TrimSuffix(mediaType, ";base64"), decoded, err
+	}
+	decoded, err := url.PathUnescape(payload)
+	return mediaType, []byte(decoded), err
+}
+
+// favicon fetches and hashes the site's icon.
+func (scan *scan) favicon(resp *http.Response) {
+	if resp.Request == nil || resp.Request.URL == nil {
+		return
+	}
+	icon, source := faviconURL(resp.Request.URL, []byte(resp.BodyText))
+	ret := &Favicon{URL: icon.String(), Source: source}
+	scan.results.Favicon = ret
+	var data []byte
+	var err error
+	if icon.Scheme == "data" {
+		ret.ContentType, data, err = decodeDataURL(icon)
+	} else {
+		data, err = scan.fetchFavicon(icon, ret)
+	}
+	if err != nil {
+		ret.Error = err.Error()
+		return
+	}
+	ret.Length = len(data)
+	if ret.Truncated || len(data) == 0 {
+		return
+	}
+	sum := md5.Sum(data)
+	ret.MD5 = hex.EncodeToString(sum[:])
+	hash := faviconHash(data)
+	ret.MMH3 = &hash
+}
+
+// fetchFavicon requests the icon, following up to maxFaviconRedirects
+// redirects, and returns its contents if the server served it.
+func (scan *scan) fetchFavicon(icon *url.URL, ret *Favicon) ([]byte, error) {
+	for redirects := 0; ; redirects++ {
+		if icon.Scheme != "http" && icon.Scheme != "https" {
+			return nil, fmt.Errorf("unsupported icon URL scheme %q", icon.Scheme)
+		}
+		request, err := http.NewRequest("GET", icon.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+		request.Header.Set("Accept", "*/*")
+		request.Header.Set("User-Agent", scan.scanner.config.UserAgent)
+		resp, err := scan.transport.RoundTrip(request)
+		if err != nil {
+			return nil, err
+		}
+		ret.StatusCode = resp.StatusCode
+		ret.ContentType = resp.Header.Get("Content-Type")
+		location := resp.Header.Get("Location")
+		if resp.StatusCode >= 300 && resp.StatusCode < 400 && location != "" {
+			resp.Body.Close()
+			if redirects == maxFaviconRedirects {
+				return nil, ErrTooManyRedirects
+			}
+			if icon, err = icon.Parse(location); err != nil {
+				return nil, err
+			}
+			ret.FinalURL = icon.String()
+			continue
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != 200 {
+			return nil, fmt.Errorf("icon request returned %s", resp.Status)
+		}
+		maxSize := int64(scan.scanner.config.MaxSize) * 1024
+		data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxSize+1))
+		if int64(len(data)) > maxSize {
+			data, ret.Truncated = data[:maxSize], true
+		}
+		return data, err
+	}
+}
@@ -0,0 +1,82 @@
+package http
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"net"
+	nethttp "net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/zmap/zgrab2"
+)
+
+func TestMurmur3(t *testing.T) {
+	for _, test := range []struct {
+		data     string
+		seed     uint32
+		expected uint32
+	}{
+		{"", 0, 0},
+		{"", 1, 0x514e28b7},
+		{"hello", 0, 0x248bfa47},
+		{"Hello, world!", 1234, 0xfaf6cdb3},
+		{"The quick brown fox jumps over the lazy dog", 0, 0x2e4ff723},
+	} {
+		if got := murmur3([]byte(test.data), test.seed); got != test.expected {
+			t.Errorf("%q, %d: got %08x, expected %08x", test.data, test.seed, got, test.expected)
+		}
+	}
+}
+
+func TestFaviconURL(t *testing.T) {
+	page, _ := url.Parse("https://example.com/app/index.html")
+	for body, expected := range map[string]string{
+		`<link rel="stylesheet" href="a.css"><link rel="shortcut icon" href="img/icon.png">`: "https://example.com/app/img/icon.png",
+		`<html><head><title>No icon</title></head></html>`:                                   "https://example.com/favicon.ico",
+	} {
+		if icon, _ := faviconURL(page, []byte(body)); icon.String() != expected {
+			t.Errorf("got %s, expected %s", icon, expected)
+		}
+	}
+}
+
+func TestFavicon(t *testing.T) {
+	icon := []byte("\x00\x00\x01\x00 not really an icon")
+	mux := nethttp.NewServeMux()
+	mux.HandleFunc("/", func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		w.Write([]byte(`<link rel=icon href="/static/icon">`))
+	})
+	mux.Handle("/static/icon", nethttp.RedirectHandler("/static/icon.ico", nethttp.StatusFound))
+	mux.HandleFunc("/static/icon.ico", func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		w.Header().Set("Content-Type", "image/x-icon")
+		w.Write(icon)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	flags := &Flags{Method: "GET", Endpoint: "/", UserAgent: "zgrab2 test", MaxSize: 256, WithFavicon: true}
+	flags.Port = uint(server.Listener.Addr().(*net.TCPAddr).Port)
+	flags.Timeout = 2 * time.Second
+	var scanner Scanner
+	if err := scanner.Init(flags); err != nil {
+		t.Fatal(err)
+	}
+	status, ret, err := scanner.Scan(zgrab2.ScanTarget{IP: net.ParseIP("127.0.0.1")})
+	if status != zgrab2.SCAN_SUCCESS {
+		t.Fatalf("scan failed: %s %v", status, err)
+	}
+	favicon := ret.(*Results).Favicon
+	if favicon == nil || favicon.Error != "" {
+		t.Fatalf("no favicon: %+v", favicon)
+	}
+	sum := md5.Sum(icon)
+	if favicon.Source != "html" || favicon.FinalURL != server.URL+"/static/icon.ico" || favicon.ContentType != "image/x-icon" || favicon.Length != len(icon) || favicon.MD5 != hex.EncodeToString(sum[:]) {
+		t.Errorf("wrong favicon %+v", favicon)
+	}
+	if favicon.MMH3 == nil || *favicon.MMH3 != faviconHash(icon) {
+		t.Errorf("wrong hash %+v", favicon)
+	}
+}
@@ -0,0 +1,281 @@
+package http
+
+import (
+	"bufio"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/zmap/zgrab2/lib/http"
+)
+
+// maxFileRedirects is the most redirects followed to fetch each file.
+const maxFileRedirects = 3
+
+const (
+	robotsTxtPath   = "/robots.txt"
+	securityTxtPath = "/.well-known/security.txt"
+)
+
+// FetchedFile is a file fetched from the final URL's origin, with
+// --with-robots-txt, --with-security-txt or --fetch-path.
+type FetchedFile struct {
+	Path string `json:"path"`
+
+	// URL is the file's URL, and FinalURL the URL it was served from, if
+	// the request for it was redirected.
+	URL      string `json:"url"`
+	FinalURL string `json:"final_url,omitempty"`
+
+	Response *http.Response `json:"response,omitempty"`
+
+	// RobotsTxt and SecurityTxt are the contents of robots.txt and
+	// security.txt, parsed, if they were served.
+	RobotsTxt   *RobotsTxt   `json:"robots_txt,omitempty"`
+	SecurityTxt *SecurityTxt `json:"security_txt,omitempty"`
+
+	Error string `json:"error,omitempty"`
+}
+
+// RobotsTxt is a robots.txt file (RFC 9309).
+type RobotsTxt struct {
+	Groups []*RobotsGroup `json:"groups,omitempty"`
+
+	// Sitemaps are the URLs of the Sitemap lines, which apply to every
+	// group.
+	Sitemaps []string `json:"sitemaps,omitempty"`
+}
+
+// RobotsGroup is the rules of a robots.txt for the user agents they apply
+// to.
+type RobotsGroup struct {
+	UserAgents []string `json:"user_agents"`
+	Allow      []string `json:"allow,omitempty"`
+	Disallow   []string `json:"disallow,omitempty"`
+	CrawlDelay string   `json:"crawl_delay,omitempty"`
+}
+
+// SecurityTxt is a security.txt file (RFC 9116).
+type SecurityTxt struct {
+	Contact            []string `json:"contact,omitempty"`
+	Expires            string   `json:"expires,omitempty"`
+	Encryption         []string `json:"encryption,omitempty"`
+	Acknowledgments    []string `json:"acknowledgments,omitempty"`
+	PreferredLanguages string   `json:"preferred_languages,omitempty"`
+	Canonical          []string `json:"canonical,omitempty"`
+	Policy             []string `json:"policy,omitempty"`
+	Hiring             []string `json:"hiring,omitempty"`
+
+	// Expired is true if Expires is in the past.
+	Expired bool `json:"expired,omitempty"`
+
+	// Signed is true if the file is an OpenPGP cleartext signed message,
+	// whose signature is not verified.
+	Signed bool `json:"signed,omitempty"`
+}
+
+// filePaths returns the paths of the files fetched, in order.
+func (scanner *Scanner) filePaths() []string {
+	var ret []string
+	if scanner.config.WithRobotsTxt {
+		ret = append(ret, robotsTxtPath)
+	}
+	if scanner.config.WithSecurityTxt {
+		ret = append(ret, securityTxtPath)
+	}
+	return append(ret, scanner.config.FetchPaths...)
+}
+
+// textLines returns the lines of the text of the form "key: value", as the
+// lowercase key and the value, without surrounding spaces or comments.
+// Comments start with # and take the whole line unless trailingComments is
+// true.
+func textLines(text string, trailingComments bool) [][2]string {
+	var ret [][2]string
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 && (trailingComments || strings.TrimSpace(line[:i]) == "") {
+			line = line[:i]
+		}
+		i := strings.IndexByte(line, ':')
+		if i < 0 {
+			continue
+		}
+		ret = append(ret, [2]string{strings.ToLower(strings.TrimSpace(line[:i])), strings.TrimSpace(line[i+1:])})
+	}
+	return ret
+}
+
+// parseRobotsTxt parses a robots.txt.
+func parseRobotsTxt(text string) *RobotsTxt {
+	ret := new(RobotsTxt)
+	var group *RobotsGroup
+	// A user-agent line after a group's rules starts a new group, and
+	// consecutive ones share a group.
+	rules := false
+	for _, line := range textLines(text, true) {
+		key, value := line[0], line[1]
+		switch key {
+		case "user-agent":
+			if group == nil || rules {
+				group = new(RobotsGroup)
+				ret.Groups = append(ret.Groups, group)
+				rules = false
+			}
+			group.UserAgents = append(group.UserAgents, value)
+		case "allow", "disallow", "crawl-delay":
+			if group == nil {
+				continue
+			}
+			rules = true
+			switch key {
+			case "allow":
+				group.Allow = append(group.Allow, value)
+			case "disallow":
+				group.Disallow = append(group.Disallow, value)
+			default:
+				group.CrawlDelay = value
+			}
+		case "sitemap":
+			ret.Sitemaps = append(ret.Sitemaps, value)
+		}
+	}
+	return ret
+}
+
+// pgpSignedHeader starts an OpenPGP cleartext signed message, followed by
+// armor headers, a blank line and the text, and pgpSignature the signature
+// ending it.
+const (
+	pgpSignedHeader = "-----BEGIN PGP SIGNED MESSAGE-----"
+	pgpSignature    = "-----BEGIN PGP SIGNATURE-----"
+)
+
+// pgpSignedText returns the text of an OpenPGP cleartext signed message.
+func pgpSignedText(message string) string {
+	var b strings.Builder
+	lines := strings.Split(message, "\n")
+	i := 1
+	for ; i < len(lines) && strings.TrimSpace(lines[i]) != ""; i++ {
+	}
+	for _, line := range lines[i:] {
+		if strings.HasPrefix(line, pgpSignature) {
+			break
+		}
+		b.WriteString(strings.TrimPrefix(line, "- "))
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// parseSecurityTxt parses a security.txt.
+func parseSecurityTxt(text string) *SecurityTxt {
+	ret := new(SecurityTxt)
+	if strings.HasPrefix(strings.TrimSpace(text), pgpSignedHeader) {
+		ret.Signed = true
+		text = pgpSignedText(strings.TrimSpace(text))
+	}
+	for _, line := range textLines(text, false) {
+		value := line[1]
+		switch line[0] {
+		case "contact":
+			ret.Contact = append(ret.Contact, value)
+		case "expires":
+			ret.Expires = value
+			if expires, err := time.Parse(time.RFC3339, value); err == nil {
+				ret.Expired = expires.Before(time.Now())
+			}
+		case "encryption":
+			ret.Encryption = append(ret.Encryption, value)
+		case "acknowledgments", "acknowledgements":
+			ret.Acknowledgments = append(ret.Acknowledgments, value)
+		case "preferred-languages":
+			ret.PreferredLanguages = value
+		case "canonical":
+			ret.Canonical = append(ret.Canonical, value)
+		case "policy":
+			ret.Policy = append(ret.Policy, value)
+		case "hiring":
+			ret.Hiring = append(ret.Hiring, value)
+		}
+	}
+	return ret
+}
+
+// isText returns true if the response is served as plain text, or without a
+// Content-Type, rather than e.g. as an HTML error page.
+func isText(resp *http.Response) bool {
+	contentType := strings.ToLower(resp.Header.Get("Content-Type"))
+	return contentType == "" || strings.HasPrefix(contentType, "text/plain")
+}
+
+// files fetches the --with-robots-txt, --with-security-txt and --fetch-path
+// files from the final URL's origin.
+func (scan *scan) files(resp *http.Response) {
+	if resp.Request == nil || resp.Request.URL == nil {
+		return
+	}
+	page := resp.Request.URL
+	origin := &url.URL{Scheme: page.Scheme, Host: page.Host}
+	for _, path := range scan.scanner.filePaths() {
+		ret := &FetchedFile{Path: path}
+		scan.results.Files = append(scan.results.Files, ret)
+		u, err := origin.Parse(scan.expand(path))
+		if err != nil {
+			ret.Error = err.Error()
+			continue
+		}
+		ret.URL = u.String()
+		if err := scan.fetchFile(u, ret); err != nil {
+			ret.Error = err.Error()
+			continue
+		}
+		if ret.Response.StatusCode != 200 || !isText(ret.Response) {
+			continue
+		}
+		switch path {
+		case robotsTxtPath:
+			ret.RobotsTxt = parseRobotsTxt(ret.Response.BodyText)
+		case securityTxtPath:
+			ret.SecurityTxt = parseSecurityTxt(ret.Response.BodyText)
+		}
+	}
+}
+
+// fetchFile requests the file, following up to maxFileRedirects redirects,
+// and records the final response.
+func (scan *scan) fetchFile(u *url.URL, ret *FetchedFile) error {
+	for redirects := 0; ; redirects++ {
+		request, err := http.NewRequest("GET", u.String(), nil)
+		if err != nil {
+			return err
+		}
+		request.Header.Set("Accept", "*/*")
+		request.Header.Set("User-Agent", scan.scanner.config.UserAgent)
+		scan.setAcceptEncoding(request)
+		resp, err := scan.transport.RoundTrip(request)
+		if err != nil {
+			return err
+		}
+		location := resp.Header.Get("Location")
+		if resp.StatusCode >= 300 && resp.StatusCode < 400 && location != "" && redirects < maxFileRedirects {
+			resp.Body.Close()
+			next, err := u.Parse(location)
+			if err != nil {
+				return err
+			}
+			if next.Scheme != "http" && next.Scheme != "https" {
+				return fmt.Errorf("unsupported redirect URL scheme %q", next.Scheme)
+			}
+			u = next
+			ret.FinalURL = u.String()
+			continue
+		}
+		defer resp.Body.Close()
+		scan.readBody(resp)
+		ret.Response = resp
+		return nil
+	}
+}
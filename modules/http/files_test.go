@@ -0,0 +1,120 @@
+package http
+
+import (
+	"net"
+	nethttp "net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/zmap/zgrab2"
+)
+
+const testRobotsTxt = `# robots.txt
+User-agent: Googlebot
+User-agent: Bingbot
+Disallow: /private/ # not for crawlers
+Allow: /private/public.html
+
+User-agent: *
+Disallow: /
+Crawl-delay: 10
+
+Sitemap: https://example.com/sitemap.xml
+`
+
+const testSecurityTxt = `-----BEGIN PGP SIGNED MESSAGE-----
+Hash: SHA256
+
+# Our security policy
+Contact: mailto:security@example.com
+Contact: https://example.com/security#report
+Expires: 2000-01-01T00:00:00Z
+Preferred-Languages: en, fr
+- -----BEGIN not a signature
+-----BEGIN PGP SIGNATURE-----
+
+iQIzBAEBCAAdFiEE
+-----END PGP SIGNATURE-----
+`
+
+func TestFetchFiles(t *testing.T) {
+	server := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		switch r.URL.Path {
+		case "/robots.txt":
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write([]byte(testRobotsTxt))
+		case "/security.txt":
+			w.Write([]byte(testSecurityTxt))
+		case "/.well-known/security.txt":
+			nethttp.Redirect(w, r, "/security.txt", nethttp.StatusMovedPermanently)
+		case "/humans.txt":
+			w.Write([]byte("We are people"))
+		default:
+			w.Write([]byte("<html><body>Home</body></html>"))
+		}
+	}))
+	defer server.Close()
+
+	flags := &Flags{Method: "GET", Endpoint: "/", UserAgent: "zgrab2 test", MaxSize: 256, WithRobotsTxt: true, WithSecurityTxt: true, FetchPaths: []string{"/humans.txt", "/{port}.txt"}}
+	flags.Port = uint(server.Listener.Addr().(*net.TCPAddr).Port)
+	flags.Timeout = 2 * time.Second
+	if err := flags.Validate(nil); err != nil {
+		t.Fatal(err)
+	}
+	var scanner Scanner
+	if err := scanner.Init(flags); err != nil {
+		t.Fatal(err)
+	}
+	status, ret, err := scanner.Scan(zgrab2.ScanTarget{IP: net.ParseIP("127.0.0.1")})
+	if status != zgrab2.SCAN_SUCCESS {
+		t.Fatalf("scan failed: %s %v", status, err)
+	}
+	files := ret.(*Results).Files
+	if len(files) != 4 {
+		t.Fatalf("expected 4 files, got %d", len(files))
+	}
+
+	robots := files[0]
+	if robots.Path != "/robots.txt" || robots.Error != "" || robots.Response.StatusCode != 200 || robots.RobotsTxt == nil {
+		t.Fatalf("wrong robots.txt %+v", robots)
+	}
+	expected := &RobotsTxt{
+		Groups: []*RobotsGroup{
+			{UserAgents: []string{"Googlebot", "Bingbot"}, Allow: []string{"/private/public.html"}, Disallow: []string{"/private/"}},
+			{UserAgents: []string{"*"}, Disallow: []string{"/"}, CrawlDelay: "10"},
+		},
+		Sitemaps: []string{"https://example.com/sitemap.xml"},
+	}
+	if !reflect.DeepEqual(robots.RobotsTxt, expected) {
+		t.Errorf("wrong robots.txt %+v", robots.RobotsTxt)
+	}
+
+	security := files[1]
+	if security.FinalURL != server.URL+"/security.txt" || security.SecurityTxt == nil {
+		t.Fatalf("wrong security.txt %+v", security)
+	}
+	expectedSecurity := &SecurityTxt{
+		Contact:            []string{"mailto:security@example.com", "https://example.com/security#report"},
+		Expires:            "2000-01-01T00:00:00Z",
+		PreferredLanguages: "en, fr",
+		Expired:            true,
+		Signed:             true,
+	}
+	if !reflect.DeepEqual(security.SecurityTxt, expectedSecurity) {
+		t.Errorf("wrong security.txt %+v", security.SecurityTxt)
+	}
+
+	if humans := files[2]; humans.Response.BodyText != "We are people" || humans.RobotsTxt != nil || humans.SecurityTxt != nil {
+		t.Errorf("wrong humans.txt %+v", humans)
+	}
+	if expanded := files[3]; expanded.Path != "/{port}.txt" || expanded.URL != server.URL+"/"+server.URL[len("http://127.0.0.1:"):]+".txt" {
+		t.Errorf("path not expanded: %+v", expanded)
+	}
+
+	flags.FetchPaths = []string{"humans.txt"}
+	if err := flags.Validate(nil); err == nil {
+		t.Error("expected an error for a relative path")
+	}
+}
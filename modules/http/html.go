@@ -0,0 +1,244 @@
+package http
+
+import (
+	"bytes"
+	"html"
+	"mime"
+	"strings"
+
+	"github.com/zmap/zgrab2/lib/http"
+)
+
+// maxTitleLength is the most bytes of a page title recorded.
+const maxTitleLength = 1024
+
+// indexFold returns the index of the first instance of the lowercase ASCII
+// string sep in s, ignoring case, or -1.
+func indexFold(s []byte, sep string) int {
+	b := []byte(sep)
+	for i := 0; i+len(b) <= len(s); i++ {
+		if bytes.EqualFold(s[i:i+len(b)], b) {
+			return i
+		}
+	}
+	return -1
+}
+
+// htmlTitle returns the text of the first <title> element in body, unescaped
+// and with runs of whitespace collapsed, or "" if there is none.
+func htmlTitle(body []byte) string {
+	for {
+		start := indexFold(body, "<title")
+		if start < 0 {
+			return ""
+		}
+		body = body[start+len("<title"):]
+		if isTagEnd(body) {
+			break
+		}
+	}
+	open := bytes.IndexByte(body, '>')
+	if open < 0 {
+		return ""
+	}
+	body = body[open+1:]
+	if end := indexFold(body, "</title"); end >= 0 {
+		body = body[:end]
+	}
+	title := strings.Join(strings.Fields(html.UnescapeString(string(body))), " ")
+	if len(title) > maxTitleLength {
+		title = title[:maxTitleLength]
+	}
+	return title
+}
+
+func isHTMLSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
+
+// isTagEnd returns true if rest, which follows a tag name, ends the name:
+// <titlebar> is not a <title>.
+func isTagEnd(rest []byte) bool {
+	return len(rest) > 0 && (isHTMLSpace(rest[0]) || rest[0] == '>' || rest[0] == '/')
+}
+
+// htmlTags returns the attributes of each <name> tag in body, with lowercase
+// names and unescaped values.
+func htmlTags(body []byte, name string) []map[string]string {
+	var ret []map[string]string
+	for {
+		start := indexFold(body, "<"+name)
+		if start < 0 {
+			return ret
+		}
+		body = body[start+1+len(name):]
+		if !isTagEnd(body) {
+			continue
+		}
+		attributes := make(map[string]string)
+		body = parseAttributes(body, attributes)
+		ret = append(ret, attributes)
+	}
+}
+
+// parseAttributes reads the attributes of a tag into attributes, returning
+// the rest of body after the tag. The first value of each attribute is kept.
+func parseAttributes(body []byte, attributes map[string]string) []byte {
+	i := 0
+	for i < len(body) {
+		for i < len(body) && (isHTMLSpace(body[i]) || body[i] == '/') {
+			i++
+		}
+		if i >= len(body) || body[i] == '>' {
+			break
+		}
+		start := i
+		for i < len(body) && !isHTMLSpace(body[i]) && body[i] != '=' && body[i] != '>' && body[i] != '/' {
+			i++
+		}
+		key := strings.ToLower(string(body[start:i]))
+		for i < len(body) && isHTMLSpace(body[i]) {
+			i++
+		}
+		value := ""
+		if i < len(body) && body[i] == '=' {
+			i++
+			for i < len(body) && isHTMLSpace(body[i]) {
+				i++
+			}
+			if i < len(body) && (body[i] == '"' || body[i] == '\'') {
+				quote := body[i]
+				end := bytes.IndexByte(body[i+1:], quote)
+				if end < 0 {
+					end = len(body) - i - 1
+				}
+				value = string(body[i+1 : i+1+end])
+				i += end + 2
+			} else {
+				start := i
+				for i < len(body) && !isHTMLSpace(body[i]) && body[i] != '>' {
+					i++
+				}
+				value = string(body[start:i])
+			}
+		}
+		if _, ok := attributes[key]; !ok && key != "" {
+			attributes[key] = html.UnescapeString(value)
+		}
+	}
+	if i >= len(body) {
+		return nil
+	}
+	return body[i+1:]
+}
+
+// htmlLink returns the href of the first <link> whose rel includes the given
+// lowercase type, or "" if there is none.
+func htmlLink(body []byte, rel string) string {
+	for _, link := range htmlTags(body, "link") {
+		for _, r := range strings.Fields(strings.ToLower(link["rel"])) {
+			if r == rel && link["href"] != "" {
+				return link["href"]
+			}
+		}
+	}
+	return ""
+}
+
+// htmlIcon returns the href of the first <link> whose rel includes icon, or
+// "" if there is none.
+func htmlIcon(body []byte) string {
+	return htmlLink(body, "icon")
+}
+
+// htmlMeta returns the content of the first <meta> with the given lowercase
+// name, or "" if there is none.
+func htmlMeta(body []byte, name string) string {
+	for _, meta := range htmlTags(body, "meta") {
+		if strings.ToLower(strings.TrimSpace(meta["name"])) == name {
+			return strings.TrimSpace(meta["content"])
+		}
+	}
+	return ""
+}
+
+// htmlCharset returns the charset declared with <meta charset> or <meta
+// http-equiv="Content-Type">, lowercased, or "" if there is none.
+func htmlCharset(body []byte) string {
+	for _, meta := range htmlTags(body, "meta") {
+		if charset := strings.TrimSpace(meta["charset"]); charset != "" {
+			return strings.ToLower(charset)
+		}
+		if strings.EqualFold(strings.TrimSpace(meta["http-equiv"]), "content-type") {
+			if charset := contentTypeCharset(meta["content"]); charset != "" {
+				return charset
+			}
+		}
+	}
+	return ""
+}
+
+// contentTypeCharset returns the lowercase charset parameter of a
+// Content-Type, or "".
+func contentTypeCharset(contentType string) string {
+	_, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(strings.Trim(params["charset"], `"' `))
+}
+
+// HTMLMetadata summarizes an HTML page, so that common questions about it
+// can be answered without parsing its body again.
+type HTMLMetadata struct {
+	Title string `json:"title,omitempty"`
+
+	// Generator is the content of <meta name="generator">.
+	Generator string `json:"generator,omitempty"`
+
+	// Charset is the page's character encoding, lowercased, and
+	// CharsetSource where it was given: header for the Content-Type header,
+	// or meta for a <meta> tag in the page.
+	Charset       string `json:"charset,omitempty"`
+	CharsetSource string `json:"charset_source,omitempty"`
+
+	// Canonical is the URL of <link rel="canonical">, resolved against the
+	// page's URL.
+	Canonical string `json:"canonical,omitempty"`
+}
+
+// isHTML returns true if the response's Content-Type, or if it has none the
+// start of its body, is that of an HTML page.
+func isHTML(resp *http.Response) bool {
+	contentType := resp.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = http.DetectContentType([]byte(resp.BodyText))
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && (mediaType == "text/html" || mediaType == "application/xhtml+xml")
+}
+
+// newHTMLMetadata summarizes the part of the response's body read, or
+// returns nil if it is not HTML.
+func newHTMLMetadata(resp *http.Response) *HTMLMetadata {
+	if resp == nil || resp.BodyText == "" || !isHTML(resp) {
+		return nil
+	}
+	body := []byte(resp.BodyText)
+	ret := &HTMLMetadata{
+		Title:     htmlTitle(body),
+		Generator: htmlMeta(body, "generator"),
+		Canonical: htmlLink(body, "canonical"),
+	}
+	if ret.Charset = contentTypeCharset(resp.Header.Get("Content-Type")); ret.Charset != "" {
+		ret.CharsetSource = "header"
+	} else if ret.Charset = htmlCharset(body); ret.Charset != "" {
+		ret.CharsetSource = "meta"
+	}
+	if ret.Canonical != "" && resp.Request != nil && resp.Request.URL != nil {
+		if canonical, err := resp.Request.URL.Parse(ret.Canonical); err == nil {
+			ret.Canonical = canonical.String()
+		}
+	}
+	return ret
+}
@@ -0,0 +1,543 @@
+package http
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/zmap/zcrypto/tls"
+	"github.com/zmap/zgrab2/lib/http"
+	"golang.org/x/net/http2/hpack"
+)
+
+// HTTP2 is the result of requesting the final URL over HTTP/2, with
+// --http2.
+type HTTP2 struct {
+	// Negotiation is how HTTP/2 was offered: alpn, or upgrade for an h2c
+	// Upgrade header.
+	Negotiation string `json:"negotiation"`
+
+	// Negotiated is true if the server agreed to speak HTTP/2.
+	Negotiated bool `json:"negotiated"`
+
+	// NegotiatedProtocol is the ALPN protocol the server selected.
+	NegotiatedProtocol string `json:"negotiated_protocol,omitempty"`
+
+	// UpgradeStatusCode is the status of the server's response to the
+	// Upgrade request: 101 if it switched to HTTP/2.
+	UpgradeStatusCode int `json:"upgrade_status_code,omitempty"`
+
+	// Settings are the parameters of the server's first SETTINGS frame, in
+	// the order sent.
+	Settings []HTTP2Setting `json:"settings,omitempty"`
+
+	// Pushes are the requests the server promised to push responses to.
+	Pushes []HTTP2Push `json:"pushes,omitempty"`
+
+	// HeaderBlockSize is the size of the response's header block as sent,
+	// HPACK-compressed.
+	HeaderBlockSize int `json:"header_block_size,omitempty"`
+
+	// HeaderListSize is the size of the response's decoded header list, as
+	// defined for SETTINGS_MAX_HEADER_LIST_SIZE.
+	HeaderListSize int `json:"header_list_size,omitempty"`
+
+	// Response is the response served over HTTP/2.
+	Response *http.Response `json:"response,omitempty"`
+
+	// GoAway is the GOAWAY frame the server sent, if any.
+	GoAway *HTTP2GoAway `json:"goaway,omitempty"`
+
+	Error string `json:"error,omitempty"`
+}
+
+// HTTP2Setting is a parameter of a SETTINGS frame.
+type HTTP2Setting struct {
+	// Name is the setting's name, e.g. MAX_CONCURRENT_STREAMS, if known.
+	Name  string `json:"name,omitempty"`
+	ID    uint16 `json:"id"`
+	Value uint32 `json:"value"`
+}
+
+// HTTP2Push is a request the server promised to push a response to.
+type HTTP2Push struct {
+	StreamID  uint32 `json:"stream_id"`
+	Method    string `json:"method,omitempty"`
+	Authority string `json:"authority,omitempty"`
+	Path      string `json:"path,omitempty"`
+}
+
+// HTTP2GoAway is a GOAWAY frame.
+type HTTP2GoAway struct {
+	LastStreamID uint32 `json:"last_stream_id"`
+	ErrorCode    string `json:"error_code"`
+	DebugData    string `json:"debug_data,omitempty"`
+}
+
+const http2Preface = "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
+
+const (
+	http2FrameData         = 0x0
+	http2FrameHeaders      = 0x1
+	http2FrameRSTStream    = 0x3
+	http2FrameSettings     = 0x4
+	http2FramePushPromise  = 0x5
+	http2FramePing         = 0x6
+	http2FrameGoAway       = 0x7
+	http2FrameWindowUpdate = 0x8
+	http2FrameContinuation = 0x9
+)
+
+const (
+	http2FlagEndStream  = 0x1
+	http2FlagAck        = 0x1
+	http2FlagEndHeaders = 0x4
+	http2FlagPadded     = 0x8
+	http2FlagPriority   = 0x20
+)
+
+// http2MaxFrameSize is the largest frame accepted: servers must not send
+// frames over the default SETTINGS_MAX_FRAME_SIZE, which is not changed.
+const http2MaxFrameSize = 16384
+
+var http2SettingNames = map[uint16]string{
+	0x1: "HEADER_TABLE_SIZE",
+	0x2: "ENABLE_PUSH",
+	0x3: "MAX_CONCURRENT_STREAMS",
+	0x4: "INITIAL_WINDOW_SIZE",
+	0x5: "MAX_FRAME_SIZE",
+	0x6: "MAX_HEADER_LIST_SIZE",
+	0x8: "ENABLE_CONNECT_PROTOCOL",
+	0x9: "NO_RFC7540_PRIORITIES",
+}
+
+var http2ErrorCodes = []string{
+	"NO_ERROR",
+	"PROTOCOL_ERROR",
+	"INTERNAL_ERROR",
+	"FLOW_CONTROL_ERROR",
+	"SETTINGS_TIMEOUT",
+	"STREAM_CLOSED",
+	"FRAME_SIZE_ERROR",
+	"REFUSED_STREAM",
+	"CANCEL",
+	"COMPRESSION_ERROR",
+	"CONNECT_ERROR",
+	"ENHANCE_YOUR_CALM",
+	"INADEQUATE_SECURITY",
+	"HTTP_1_1_REQUIRED",
+}
+
+func http2ErrorCodeName(code uint32) string {
+	if int(code) < len(http2ErrorCodes) {
+		return http2ErrorCodes[code]
+	}
+	return fmt.Sprintf("0x%x", code)
+}
+
+// http2Frame is a frame read from the server.
+type http2Frame struct {
+	Type     byte
+	Flags    byte
+	StreamID uint32
+	Payload  []byte
+}
+
+// http2Conn is a client connection speaking HTTP/2 for a single request, on
+// stream 1.
+type http2Conn struct {
+	conn    net.Conn
+	reader  *bufio.Reader
+	decoder *hpack.Decoder
+	result  *HTTP2
+
+	// window is the flow-control window advertised for the response body.
+	window uint32
+}
+
+func (c *http2Conn) writeFrame(frameType, flags byte, streamID uint32, payload []byte) error {
+	header := make([]byte, 9, 9+len(payload))
+	header[0], header[1], header[2] = byte(len(payload)>>16), byte(len(payload)>>8), byte(len(payload))
+	header[3], header[4] = frameType, flags
+	binary.BigEndian.PutUint32(header[5:], streamID)
+	_, err := c.conn.Write(append(header, payload...))
+	return err
+}
+
+func (c *http2Conn) readFrame() (*http2Frame, error) {
+	header := make([]byte, 9)
+	if _, err := io.ReadFull(c.reader, header); err != nil {
+		return nil, err
+	}
+	length := int(header[0])<<16 | int(header[1])<<8 | int(header[2])
+	if length > http2MaxFrameSize {
+		return nil, fmt.Errorf("frame of %d bytes is too large", length)
+	}
+	frame := &http2Frame{
+		Type:     header[3],
+		Flags:    header[4],
+		StreamID: binary.BigEndian.Uint32(header[5:]) & 0x7fffffff,
+		Payload:  make([]byte, length),
+	}
+	if _, err := io.ReadFull(c.reader, frame.Payload); err != nil {
+		return nil, err
+	}
+	return frame, nil
+}
+
+// http2SettingsPayload returns the SETTINGS sent: push is enabled so that
+// the server's push behavior can be seen, and the window allows the whole
+// body to be sent.
+func http2SettingsPayload(window uint32) []byte {
+	payload := make([]byte, 12)
+	binary.BigEndian.PutUint16(payload[0:], 0x2)
+	binary.BigEndian.PutUint32(payload[2:], 1)
+	binary.BigEndian.PutUint16(payload[6:], 0x4)
+	binary.BigEndian.PutUint32(payload[8:], window)
+	return payload
+}
+
+// start sends the connection preface, the client's SETTINGS and a
+// WINDOW_UPDATE widening the connection's window to match the stream's.
+func (c *http2Conn) start() error {
+	if _, err := c.conn.Write([]byte(http2Preface)); err != nil {
+		return err
+	}
+	if err := c.writeFrame(http2FrameSettings, 0, 0, http2SettingsPayload(c.window)); err != nil {
+		return err
+	}
+	if c.window > 65535 {
+		increment := make([]byte, 4)
+		binary.BigEndian.PutUint32(increment, c.window-65535)
+		return c.writeFrame(http2FrameWindowUpdate, 0, 0, increment)
+	}
+	return nil
+}
+
+// sendRequest sends the request on stream 1.
+func (c *http2Conn) sendRequest(method string, u *url.URL, userAgent string) error {
+	var block bytes.Buffer
+	encoder := hpack.NewEncoder(&block)
+	fields := []hpack.HeaderField{
+		{Name: ":method", Value: method},
+		{Name: ":scheme", Value: u.Scheme},
+		{Name: ":authority", Value: u.Host},
+		{Name: ":path", Value: u.RequestURI()},
+		{Name: "user-agent", Value: userAgent},
+		{Name: "accept", Value: "*/*"},
+	}
+	for _, field := range fields {
+		encoder.WriteField(field)
+	}
+	if block.Len() > http2MaxFrameSize {
+		return errors.New("request headers are too large")
+	}
+	return c.writeFrame(http2FrameHeaders, http2FlagEndStream|http2FlagEndHeaders, 1, block.Bytes())
+}
+
+// framePayload returns the payload of a DATA, HEADERS or PUSH_PROMISE frame
+// without its padding.
+func framePayload(frame *http2Frame) ([]byte, error) {
+	payload := frame.Payload
+	if frame.Flags&http2FlagPadded == 0 {
+		return payload, nil
+	}
+	if len(payload) == 0 || int(payload[0]) >= len(payload) {
+		return nil, errors.New("invalid padding")
+	}
+	return payload[1 : len(payload)-int(payload[0])], nil
+}
+
+// readHeaderBlock returns the header block starting with fragment,
+// reading any CONTINUATION frames that follow.
+func (c *http2Conn) readHeaderBlock(frame *http2Frame, fragment []byte) ([]byte, error) {
+	block := append([]byte(nil), fragment...)
+	for flags := frame.Flags; flags&http2FlagEndHeaders == 0; {
+		next, err := c.readFrame()
+		if err != nil {
+			return nil, err
+		}
+		if next.Type != http2FrameContinuation || next.StreamID != frame.StreamID {
+			return nil, errors.New("header block not continued")
+		}
+		block = append(block, next.Payload...)
+		flags = next.Flags
+	}
+	return block, nil
+}
+
+// readResponse reads frames until the response on stream 1 is complete,
+// up to limit bytes of body, answering the server's SETTINGS and PINGs.
+func (c *http2Conn) readResponse(limit int64) error {
+	var body bytes.Buffer
+	var response *http.Response
+	for {
+		frame, err := c.readFrame()
+		if err != nil {
+			return err
+		}
+		switch frame.Type {
+		case http2FrameSettings:
+			if frame.Flags&http2FlagAck != 0 {
+				continue
+			}
+			if c.result.Settings == nil {
+				for p := frame.Payload; len(p) >= 6; p = p[6:] {
+					id := binary.BigEndian.Uint16(p)
+					c.result.Settings = append(c.result.Settings, HTTP2Setting{Name: http2SettingNames[id], ID: id, Value: binary.BigEndian.Uint32(p[2:])})
+				}
+			}
+			if err := c.writeFrame(http2FrameSettings, http2FlagAck, 0, nil); err != nil {
+				return err
+			}
+		case http2FramePing:
+			if frame.Flags&http2FlagAck == 0 {
+				if err := c.writeFrame(http2FramePing, http2FlagAck, 0, frame.Payload); err != nil {
+					return err
+				}
+			}
+		case http2FrameGoAway:
+			if len(frame.Payload) < 8 {
+				return errors.New("invalid GOAWAY frame")
+			}
+			c.result.GoAway = &HTTP2GoAway{
+				LastStreamID: binary.BigEndian.Uint32(frame.Payload) & 0x7fffffff,
+				ErrorCode:    http2ErrorCodeName(binary.BigEndian.Uint32(frame.Payload[4:])),
+				DebugData:    string(frame.Payload[8:]),
+			}
+			if response == nil {
+				return fmt.Errorf("server sent GOAWAY %s", c.result.GoAway.ErrorCode)
+			}
+			return nil
+		case http2FrameRSTStream:
+			if frame.StreamID == 1 && len(frame.Payload) >= 4 {
+				return fmt.Errorf("server reset the stream with %s", http2ErrorCodeName(binary.BigEndian.Uint32(frame.Payload)))
+			}
+		case http2FramePushPromise:
+			payload, err := framePayload(frame)
+			if err != nil {
+				return err
+			}
+			if len(payload) < 4 {
+				return errors.New("invalid PUSH_PROMISE frame")
+			}
+			block, err := c.readHeaderBlock(frame, payload[4:])
+			if err != nil {
+				return err
+			}
+			fields, err := c.decoder.DecodeFull(block)
+			if err != nil {
+				return err
+			}
+			push := HTTP2Push{StreamID: binary.BigEndian.Uint32(payload) & 0x7fffffff}
+			for _, field := range fields {
+				switch field.Name {
+				case ":method":
+					push.Method = field.Value
+				case ":authority":
+					push.Authority = field.Value
+				case ":path":
+					push.Path = field.Value
+				}
+			}
+			c.result.Pushes = append(c.result.Pushes, push)
+		case http2FrameHeaders:
+			payload, err := framePayload(frame)
+			if err != nil {
+				return err
+			}
+			if frame.Flags&http2FlagPriority != 0 {
+				if len(payload) < 5 {
+					return errors.New("invalid HEADERS frame")
+				}
+				payload = payload[5:]
+			}
+			block, err := c.readHeaderBlock(frame, payload)
+			if err != nil {
+				return err
+			}
+			// Header blocks on other streams (for pushed responses) must
+			// still be decoded, to keep the HPACK state in step.
+			fields, err := c.decoder.DecodeFull(block)
+			if err != nil {
+				return err
+			}
+			if frame.StreamID != 1 {
+				continue
+			}
+			if response == nil {
+				status := ""
+				header := make(http.Header)
+				size := 0
+				for _, field := range fields {
+					size += int(field.Size())
+					if field.Name == ":status" {
+						status = field.Value
+					} else if !strings.HasPrefix(field.Name, ":") {
+						header.Add(field.Name, field.Value)
+					}
+				}
+				code, err := strconv.Atoi(status)
+				if err != nil {
+					return fmt.Errorf("invalid status %q", status)
+				}
+				if code >= 100 && code < 200 {
+					continue
+				}
+				c.result.HeaderBlockSize, c.result.HeaderListSize = len(block), size
+				response = &http.Response{
+					Status:     status + " " + http.StatusText(code),
+					StatusCode: code,
+					Protocol:   http.Protocol{Name: "HTTP/2.0", Major: 2},
+					Header:     header,
+				}
+				c.result.Response = response
+			} else {
+				response.Trailer = make(http.Header)
+				for _, field := range fields {
+					response.Trailer.Add(field.Name, field.Value)
+				}
+			}
+			if frame.Flags&http2FlagEndStream != 0 {
+				c.setBody(&body)
+				return nil
+			}
+		case http2FrameData:
+			if frame.StreamID != 1 {
+				continue
+			}
+			payload, err := framePayload(frame)
+			if err != nil {
+				return err
+			}
+			if remaining := limit - int64(body.Len()); int64(len(payload)) > remaining {
+				body.Write(payload[:remaining])
+				c.setBody(&body)
+				return nil
+			}
+			body.Write(payload)
+			if frame.Flags&http2FlagEndStream != 0 {
+				c.setBody(&body)
+				return nil
+			}
+		}
+	}
+}
+
+// setBody records the body received.
+func (c *http2Conn) setBody(body *bytes.Buffer) {
+	if c.result.Response == nil || body.Len() == 0 {
+		return
+	}
+	setBody(c.result.Response, body.Bytes())
+}
+
+// upgrade sends the request as HTTP/1.1 with an h2c Upgrade header, and
+// returns true if the server switched to HTTP/2.
+func (c *http2Conn) upgrade(method string, u *url.URL, userAgent string) (bool, error) {
+	request := fmt.Sprintf("%s %s HTTP/1.1\r\nHost: %s\r\nUser-Agent: %s\r\nAccept: */*\r\nConnection: Upgrade, HTTP2-Settings\r\nUpgrade: h2c\r\nHTTP2-Settings: %s\r\n\r\n",
+		method, u.RequestURI(), u.Host, userAgent, base64.RawURLEncoding.EncodeToString(http2SettingsPayload(c.window)))
+	if _, err := c.conn.Write([]byte(request)); err != nil {
+		return false, err
+	}
+	resp, err := http.ReadResponse(c.reader, nil)
+	if err != nil {
+		return false, err
+	}
+	c.result.UpgradeStatusCode = resp.StatusCode
+	if resp.StatusCode != 101 || !strings.EqualFold(resp.Header.Get("Upgrade"), "h2c") {
+		return false, nil
+	}
+	return true, nil
+}
+
+// http2 requests the final URL over HTTP/2, negotiated with ALPN for https
+// URLs and, with --http2-upgrade, with an h2c Upgrade for http URLs.
+func (scan *scan) http2(resp *http.Response) {
+	if resp.Request == nil || resp.Request.URL == nil {
+		return
+	}
+	u := resp.Request.URL
+	ret := &HTTP2{Negotiation: "alpn"}
+	if u.Scheme == "http" {
+		if !scan.scanner.config.HTTP2Upgrade {
+			return
+		}
+		ret.Negotiation = "upgrade"
+	}
+	scan.results.HTTP2 = ret
+	if err := scan.requestHTTP2(u, ret); err != nil {
+		ret.Error = err.Error()
+	}
+}
+
+func (scan *scan) requestHTTP2(u *url.URL, ret *HTTP2) error {
+	port := u.Port()
+	if port == "" {
+		port = strconv.Itoa(int(protoToPort[u.Scheme]))
+	}
+	conn, err := scan.dialContext(context.Background(), "tcp", net.JoinHostPort(u.Hostname(), port))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	limit := scan.bodyLimit()
+	window := uint32(65535)
+	if limit > int64(window) && limit < 1<<31 {
+		window = uint32(limit)
+	}
+	if u.Scheme == "https" {
+		config, err := scan.scanner.config.TLSFlags.GetTLSConfigForTarget(scan.target)
+		if err != nil {
+			return err
+		}
+		config.NextProtos = []string{"h2", "http/1.1"}
+		if net.ParseIP(u.Hostname()) == nil {
+			config.ServerName = u.Hostname()
+		}
+		tlsConn := tls.Client(conn, config)
+		if err := tlsConn.Handshake(); err != nil {
+			return err
+		}
+		ret.NegotiatedProtocol = tlsConn.ConnectionState().NegotiatedProtocol
+		conn = tlsConn
+	}
+	c := &http2Conn{
+		conn:    conn,
+		reader:  bufio.NewReader(conn),
+		decoder: hpack.NewDecoder(4096, nil),
+		result:  ret,
+		window:  window,
+	}
+	method := scan.scanner.config.Method
+	userAgent := scan.scanner.config.UserAgent
+	if ret.Negotiation == "upgrade" {
+		if ret.Negotiated, err = c.upgrade(method, u, userAgent); err != nil || !ret.Negotiated {
+			return err
+		}
+		// The response to the upgraded request comes on stream 1.
+		if err := c.start(); err != nil {
+			return err
+		}
+	} else {
+		if ret.Negotiated = ret.NegotiatedProtocol == "h2"; !ret.Negotiated {
+			return nil
+		}
+		if err := c.start(); err != nil {
+			return err
+		}
+		if err := c.sendRequest(method, u, userAgent); err != nil {
+			return err
+		}
+	}
+	return c.readResponse(limit)
+}
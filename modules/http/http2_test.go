@@ -0,0 +1,113 @@
+package http
+
+import (
+	"net"
+	nethttp "net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/zmap/zgrab2"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+func TestHTTP2Upgrade(t *testing.T) {
+	handler := nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		w.Header().Set("X-Powered-By", "test")
+		w.Write([]byte("<html><body>Hello, world</body></html>"))
+	})
+	server := httptest.NewServer(h2c.NewHandler(handler, &http2.Server{MaxConcurrentStreams: 50}))
+	defer server.Close()
+
+	flags := &Flags{Method: "GET", Endpoint: "/", UserAgent: "zgrab2 test", MaxSize: 256, HTTP2: true, HTTP2Upgrade: true}
+	flags.Port = uint(server.Listener.Addr().(*net.TCPAddr).Port)
+	flags.Timeout = 2 * time.Second
+	if err := flags.Validate(nil); err != nil {
+		t.Fatal(err)
+	}
+	var scanner Scanner
+	if err := scanner.Init(flags); err != nil {
+		t.Fatal(err)
+	}
+	status, ret, err := scanner.Scan(zgrab2.ScanTarget{IP: net.ParseIP("127.0.0.1")})
+	if status != zgrab2.SCAN_SUCCESS {
+		t.Fatalf("scan failed: %s %v", status, err)
+	}
+	result := ret.(*Results).HTTP2
+	if result == nil || result.Error != "" || !result.Negotiated || result.Negotiation != "upgrade" || result.UpgradeStatusCode != 101 {
+		t.Fatalf("wrong result %+v", result)
+	}
+	response := result.Response
+	if response == nil || response.StatusCode != 200 || response.Header.Get("X-Powered-By") != "test" || response.BodyText != "<html><body>Hello, world</body></html>" {
+		t.Errorf("wrong response %+v", response)
+	}
+	found := false
+	for _, setting := range result.Settings {
+		if setting.Name == "MAX_CONCURRENT_STREAMS" && setting.Value == 50 {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("MAX_CONCURRENT_STREAMS missing from %+v", result.Settings)
+	}
+	if result.HeaderBlockSize == 0 || result.HeaderBlockSize >= result.HeaderListSize {
+		t.Errorf("wrong header sizes %d and %d", result.HeaderBlockSize, result.HeaderListSize)
+	}
+
+	flags.HTTP2Upgrade = false
+	_, ret, _ = scanner.Scan(zgrab2.ScanTarget{IP: net.ParseIP("127.0.0.1")})
+	if result := ret.(*Results).HTTP2; result != nil {
+		t.Errorf("HTTP/2 offered without --http2-upgrade: %+v", result)
+	}
+}
+
+func TestHTTP2ALPNPush(t *testing.T) {
+	handler := nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		if r.URL.Path == "/style.css" {
+			w.Write([]byte("body {}"))
+			return
+		}
+		if pusher, ok := w.(nethttp.Pusher); ok {
+			if err := pusher.Push("/style.css", nil); err != nil {
+				t.Errorf("could not push: %v", err)
+			}
+		}
+		w.Header().Set("X-Protocol", r.Proto)
+		w.Write([]byte("<html><body>Hello, world</body></html>"))
+	})
+	server := httptest.NewUnstartedServer(handler)
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+
+	flags := &Flags{Method: "GET", Endpoint: "/", UserAgent: "zgrab2 test", MaxSize: 256, UseHTTPS: true, HTTP2: true}
+	flags.Port = uint(server.Listener.Addr().(*net.TCPAddr).Port)
+	flags.Timeout = 2 * time.Second
+	if err := flags.Validate(nil); err != nil {
+		t.Fatal(err)
+	}
+	var scanner Scanner
+	if err := scanner.Init(flags); err != nil {
+		t.Fatal(err)
+	}
+	status, ret, err := scanner.Scan(zgrab2.ScanTarget{IP: net.ParseIP("127.0.0.1")})
+	if status != zgrab2.SCAN_SUCCESS {
+		t.Fatalf("scan failed: %s %v", status, err)
+	}
+	result := ret.(*Results).HTTP2
+	if result == nil || result.Error != "" || !result.Negotiated || result.Negotiation != "alpn" || result.NegotiatedProtocol != "h2" {
+		t.Fatalf("wrong result %+v", result)
+	}
+	response := result.Response
+	if response == nil || response.StatusCode != 200 || response.Header.Get("X-Protocol") != "HTTP/2.0" || response.BodyText != "<html><body>Hello, world</body></html>" {
+		t.Errorf("wrong response %+v", response)
+	}
+	if len(result.Pushes) != 1 {
+		t.Fatalf("expected one push, got %+v", result.Pushes)
+	}
+	push := result.Pushes[0]
+	if push.StreamID == 0 || push.StreamID%2 != 0 || push.Method != "GET" || push.Path != "/style.css" || push.Authority == "" {
+		t.Errorf("wrong push %+v", push)
+	}
+}
@@ -0,0 +1,606 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/zmap/zgrab2/lib/http"
+	"golang.org/x/net/http2/hpack"
+)
+
+// HTTP3 is the result of requesting the final URL over HTTP/3, with
+// --http3.
+type HTTP3 struct {
+	// Address is the UDP address the request was sent to.
+	Address string `json:"address"`
+
+	// Source is where the address came from: alt_svc for an h3 alternative
+	// the final response advertised, or origin for the final URL's host and
+	// port.
+	Source string `json:"source"`
+
+	// QUICVersion is the QUIC version the connection used.
+	QUICVersion string `json:"quic_version,omitempty"`
+
+	// SupportedVersions are the QUIC versions in the server's Version
+	// Negotiation packet, if it did not accept version 1.
+	SupportedVersions []string `json:"supported_versions,omitempty"`
+
+	// Retry is true if the server asked the client to prove its address
+	// with a Retry packet.
+	Retry bool `json:"retry,omitempty"`
+
+	// Retransmissions is the number of times the client's handshake data
+	// was sent again because the server did not acknowledge it in time.
+	Retransmissions int `json:"retransmissions,omitempty"`
+
+	// NegotiatedProtocol is the ALPN protocol the server selected.
+	NegotiatedProtocol string `json:"negotiated_protocol,omitempty"`
+
+	// TransportParameters are the server's QUIC transport parameters, in
+	// the order sent.
+	TransportParameters []QUICTransportParameter `json:"transport_parameters,omitempty"`
+
+	// Settings are the parameters of the server's SETTINGS frame, in the
+	// order sent.
+	Settings []HTTP3Setting `json:"settings,omitempty"`
+
+	// Response is the response served over HTTP/3.
+	Response *http.Response `json:"response,omitempty"`
+
+	// ConnectionClose is the CONNECTION_CLOSE frame the server sent, if
+	// any.
+	ConnectionClose *QUICConnectionClose `json:"connection_close,omitempty"`
+
+	Error string `json:"error,omitempty"`
+}
+
+// QUICTransportParameter is a QUIC transport parameter.
+type QUICTransportParameter struct {
+	// Name is the parameter's name, e.g. initial_max_data, if known.
+	Name string `json:"name,omitempty"`
+	ID   uint64 `json:"id"`
+
+	// Value is the value of an integer parameter, and Raw the value of any
+	// other.
+	Value *uint64 `json:"value,omitempty"`
+	Raw   []byte  `json:"raw,omitempty"`
+}
+
+// HTTP3Setting is a parameter of a SETTINGS frame.
+type HTTP3Setting struct {
+	// Name is the setting's name, e.g. QPACK_MAX_TABLE_CAPACITY, if known.
+	Name  string `json:"name,omitempty"`
+	ID    uint64 `json:"id"`
+	Value uint64 `json:"value"`
+}
+
+// QUICConnectionClose is a CONNECTION_CLOSE frame.
+type QUICConnectionClose struct {
+	// Application is true if the connection was closed by HTTP/3 rather
+	// than by QUIC.
+	Application bool   `json:"application,omitempty"`
+	ErrorCode   string `json:"error_code"`
+	Reason      string `json:"reason,omitempty"`
+}
+
+const (
+	http3FrameData     = 0x0
+	http3FrameHeaders  = 0x1
+	http3FrameSettings = 0x4
+)
+
+const (
+	http3StreamControl = 0x0
+
+	// http3RequestStream is the client-initiated bidirectional stream the
+	// request is sent on, and http3ControlStream the client-initiated
+	// unidirectional stream its control stream is.
+	http3RequestStream = 0x0
+	http3ControlStream = 0x2
+)
+
+const http3NoError = 0x100
+
+var http3SettingNames = map[uint64]string{
+	0x01: "QPACK_MAX_TABLE_CAPACITY",
+	0x06: "MAX_FIELD_SECTION_SIZE",
+	0x07: "QPACK_BLOCKED_STREAMS",
+	0x08: "ENABLE_CONNECT_PROTOCOL",
+	0x33: "H3_DATAGRAM",
+}
+
+var http3ErrorCodes = []string{
+	"H3_NO_ERROR",
+	"H3_GENERAL_PROTOCOL_ERROR",
+	"H3_INTERNAL_ERROR",
+	"H3_STREAM_CREATION_ERROR",
+	"H3_CLOSED_CRITICAL_STREAM",
+	"H3_FRAME_UNEXPECTED",
+	"H3_FRAME_ERROR",
+	"H3_EXCESSIVE_LOAD",
+	"H3_ID_ERROR",
+	"H3_SETTINGS_ERROR",
+	"H3_MISSING_SETTINGS",
+	"H3_REQUEST_REJECTED",
+	"H3_REQUEST_CANCELLED",
+	"H3_REQUEST_INCOMPLETE",
+	"H3_MESSAGE_ERROR",
+	"H3_CONNECT_ERROR",
+	"H3_VERSION_FALLBACK",
+}
+
+var qpackErrorCodes = []string{
+	"QPACK_DECOMPRESSION_FAILED",
+	"QPACK_ENCODER_STREAM_ERROR",
+	"QPACK_DECODER_STREAM_ERROR",
+}
+
+func http3ErrorName(code uint64) string {
+	switch {
+	case code >= http3NoError && code-http3NoError < uint64(len(http3ErrorCodes)):
+		return http3ErrorCodes[code-http3NoError]
+	case code >= 0x200 && code-0x200 < uint64(len(qpackErrorCodes)):
+		return qpackErrorCodes[code-0x200]
+	}
+	return fmt.Sprintf("0x%x", code)
+}
+
+// qpackStaticTable is the QPACK static table (RFC 9204, appendix A).
+var qpackStaticTable = []hpack.HeaderField{
+	{Name: ":authority"},
+	{Name: ":path", Value: "/"},
+	{Name: "age", Value: "0"},
+	{Name: "content-disposition"},
+	{Name: "content-length", Value: "0"},
+	{Name: "cookie"},
+	{Name: "date"},
+	{Name: "etag"},
+	{Name: "if-modified-since"},
+	{Name: "if-none-match"},
+	{Name: "last-modified"},
+	{Name: "link"},
+	{Name: "location"},
+	{Name: "referer"},
+	{Name: "set-cookie"},
+	{Name: ":method", Value: "CONNECT"},
+	{Name: ":method", Value: "DELETE"},
+	{Name: ":method", Value: "GET"},
+	{Name: ":method", Value: "HEAD"},
+	{Name: ":method", Value: "OPTIONS"},
+	{Name: ":method", Value: "POST"},
+	{Name: ":method", Value: "PUT"},
+	{Name: ":scheme", Value: "http"},
+	{Name: ":scheme", Value: "https"},
+	{Name: ":status", Value: "103"},
+	{Name: ":status", Value: "200"},
+	{Name: ":status", Value: "304"},
+	{Name: ":status", Value: "404"},
+	{Name: ":status", Value: "503"},
+	{Name: "accept", Value: "*/*"},
+	{Name: "accept", Value: "application/dns-message"},
+	{Name: "accept-encoding", Value: "gzip, deflate, br"},
+	{Name: "accept-ranges", Value: "bytes"},
+	{Name: "access-control-allow-headers", Value: "cache-control"},
+	{Name: "access-control-allow-headers", Value: "content-type"},
+	{Name: "access-control-allow-origin", Value: "*"},
+	{Name: "cache-control", Value: "max-age=0"},
+	{Name: "cache-control", Value: "max-age=2592000"},
+	{Name: "cache-control", Value: "max-age=604800"},
+	{Name: "cache-control", Value: "no-cache"},
+	{Name: "cache-control", Value: "no-store"},
+	{Name: "cache-control", Value: "public, max-age=31536000"},
+	{Name: "content-encoding", Value: "br"},
+	{Name: "content-encoding", Value: "gzip"},
+	{Name: "content-type", Value: "application/dns-message"},
+	{Name: "content-type", Value: "application/javascript"},
+	{Name: "content-type", Value: "application/json"},
+	{Name: "content-type", Value: "application/x-www-form-urlencoded"},
+	{Name: "content-type", Value: "image/gif"},
+	{Name: "content-type", Value: "image/jpeg"},
+	{Name: "content-type", Value: "image/png"},
+	{Name: "content-type", Value: "text/css"},
+	{Name: "content-type", Value: "text/html; charset=utf-8"},
+	{Name: "content-type", Value: "text/plain"},
+	{Name: "content-type", Value: "text/plain;charset=utf-8"},
+	{Name: "range", Value: "bytes=0-"},
+	{Name: "strict-transport-security", Value: "max-age=31536000"},
+	{Name: "strict-transport-security", Value: "max-age=31536000; includesubdomains"},
+	{Name: "strict-transport-security", Value: "max-age=31536000; includesubdomains; preload"},
+	{Name: "vary", Value: "accept-encoding"},
+	{Name: "vary", Value: "origin"},
+	{Name: "x-content-type-options", Value: "nosniff"},
+	{Name: "x-xss-protection", Value: "1; mode=block"},
+	{Name: ":status", Value: "100"},
+	{Name: ":status", Value: "204"},
+	{Name: ":status", Value: "206"},
+	{Name: ":status", Value: "302"},
+	{Name: ":status", Value: "400"},
+	{Name: ":status", Value: "403"},
+	{Name: ":status", Value: "421"},
+	{Name: ":status", Value: "425"},
+	{Name: ":status", Value: "500"},
+	{Name: "accept-language"},
+	{Name: "access-control-allow-credentials", Value: "FALSE"},
+	{Name: "access-control-allow-credentials", Value: "TRUE"},
+	{Name: "access-control-allow-headers", Value: "*"},
+	{Name: "access-control-allow-methods", Value: "get"},
+	{Name: "access-control-allow-methods", Value: "get, post, options"},
+	{Name: "access-control-allow-methods", Value: "options"},
+	{Name: "access-control-expose-headers", Value: "content-length"},
+	{Name: "access-control-request-headers", Value: "content-type"},
+	{Name: "access-control-request-method", Value: "get"},
+	{Name: "access-control-request-method", Value: "post"},
+	{Name: "alt-svc", Value: "clear"},
+	{Name: "authorization"},
+	{Name: "content-security-policy", Value: "script-src 'none'; object-src 'none'; base-uri 'none'"},
+	{Name: "early-data", Value: "1"},
+	{Name: "expect-ct"},
+	{Name: "forwarded"},
+	{Name: "if-range"},
+	{Name: "origin"},
+	{Name: "purpose", Value: "prefetch"},
+	{Name: "server"},
+	{Name: "timing-allow-origin", Value: "*"},
+	{Name: "upgrade-insecure-requests", Value: "1"},
+	{Name: "user-agent"},
+	{Name: "x-forwarded-for"},
+	{Name: "x-frame-options", Value: "deny"},
+	{Name: "x-frame-options", Value: "sameorigin"},
+}
+
+// errQPACKDynamic is returned for field sections that refer to the dynamic
+// table, which the client's SETTINGS do not allow.
+var errQPACKDynamic = errors.New("field section refers to the QPACK dynamic table")
+
+// appendQPACKInteger appends v as an integer with a prefix of the given
+// number of bits, the rest of the first byte being flags (RFC 9204,
+// section 4.1.1).
+func appendQPACKInteger(b []byte, flags byte, bits uint, v uint64) []byte {
+	max := uint64(1)<<bits - 1
+	if v < max {
+		return append(b, flags|byte(v))
+	}
+	b = append(b, flags|byte(max))
+	for v -= max; v >= 0x80; v >>= 7 {
+		b = append(b, byte(v)|0x80)
+	}
+	return append(b, byte(v))
+}
+
+// qpackInteger reads an integer with a prefix of the given number of bits,
+// starting in first.
+func qpackInteger(r *wireReader, first byte, bits uint) uint64 {
+	max := uint64(1)<<bits - 1
+	v := uint64(first) & max
+	if v < max {
+		return v
+	}
+	for shift := uint(0); r.ok; shift += 7 {
+		if shift > 56 {
+			r.ok = false
+			break
+		}
+		b := r.uint8()
+		v += uint64(b&0x7f) << shift
+		if b&0x80 == 0 {
+			break
+		}
+	}
+	return v
+}
+
+// qpackString reads a string whose length has a prefix of the given number
+// of bits, starting in first, and whose Huffman flag is the bit above it.
+func qpackString(r *wireReader, first byte, bits uint) (string, error) {
+	huffman := first&(1<<bits) != 0
+	n := qpackInteger(r, first, bits)
+	if n > uint64(len(r.data)) {
+		r.ok = false
+		return "", nil
+	}
+	data := r.bytes(int(n))
+	if huffman {
+		return hpack.HuffmanDecodeToString(data)
+	}
+	return string(data), nil
+}
+
+// encodeQPACK encodes a field section with literal names and values only,
+// so it needs neither the static nor the dynamic table.
+func encodeQPACK(fields []hpack.HeaderField) []byte {
+	// Required Insert Count and Base are 0.
+	b := []byte{0, 0}
+	for _, field := range fields {
+		b = appendQPACKInteger(b, 0x20, 3, uint64(len(field.Name)))
+		b = append(b, field.Name...)
+		b = appendQPACKInteger(b, 0, 7, uint64(len(field.Value)))
+		b = append(b, field.Value...)
+	}
+	return b
+}
+
+// decodeQPACK decodes a field section that refers only to the static table
+// (RFC 9204, section 4.5).
+func decodeQPACK(block []byte) ([]hpack.HeaderField, error) {
+	r := newWireReader(block)
+	insertCount := qpackInteger(r, r.uint8(), 8)
+	qpackInteger(r, r.uint8(), 7)
+	if insertCount != 0 {
+		return nil, errQPACKDynamic
+	}
+	var fields []hpack.HeaderField
+	for r.ok && len(r.data) > 0 {
+		b := r.uint8()
+		switch {
+		case b&0x80 != 0:
+			// An indexed field line.
+			if b&0x40 == 0 {
+				return nil, errQPACKDynamic
+			}
+			index := qpackInteger(r, b, 6)
+			if index >= uint64(len(qpackStaticTable)) {
+				return nil, fmt.Errorf("invalid QPACK static index %d", index)
+			}
+			fields = append(fields, qpackStaticTable[index])
+		case b&0x40 != 0:
+			// A literal field line with a name reference.
+			if b&0x10 == 0 {
+				return nil, errQPACKDynamic
+			}
+			index := qpackInteger(r, b, 4)
+			if index >= uint64(len(qpackStaticTable)) {
+				return nil, fmt.Errorf("invalid QPACK static index %d", index)
+			}
+			value, err := qpackString(r, r.uint8(), 7)
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, hpack.HeaderField{Name: qpackStaticTable[index].Name, Value: value})
+		case b&0x20 != 0:
+			// A literal field line with a literal name.
+			name, err := qpackString(r, b, 3)
+			if err != nil {
+				return nil, err
+			}
+			value, err := qpackString(r, r.uint8(), 7)
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, hpack.HeaderField{Name: name, Value: value})
+		default:
+			// Post-base references are into the dynamic table.
+			return nil, errQPACKDynamic
+		}
+	}
+	if !r.ok {
+		return nil, errors.New("malformed QPACK field section")
+	}
+	return fields, nil
+}
+
+// http3Frame returns a frame with the payload.
+func http3Frame(typ uint64, payload []byte) []byte {
+	frame := appendVarint(appendVarint(nil, typ), uint64(len(payload)))
+	return append(frame, payload...)
+}
+
+// readControlStream records the server's SETTINGS once the start of its
+// control stream, a unidirectional stream, has been received.
+func (c *quicConn) readControlStream(s *quicStream) {
+	if c.settingsRead {
+		return
+	}
+	r := newWireReader(s.data)
+	if r.varint() != http3StreamControl || r.varint() != http3FrameSettings {
+		return
+	}
+	payload := r.varintBytes()
+	if !r.ok {
+		return
+	}
+	c.settingsRead = true
+	settings := newWireReader(payload)
+	for settings.ok && len(settings.data) > 0 {
+		id, value := settings.varint(), settings.varint()
+		if settings.ok {
+			c.result.Settings = append(c.result.Settings, HTTP3Setting{Name: http3SettingNames[id], ID: id, Value: value})
+		}
+	}
+}
+
+// sendRequest opens the client's control stream, with empty SETTINGS that
+// leave the QPACK dynamic table disabled, and sends the request.
+func (c *quicConn) sendRequest(method string, u *url.URL, userAgent string) error {
+	control := append([]byte{http3StreamControl}, http3Frame(http3FrameSettings, nil)...)
+	headers := encodeQPACK([]hpack.HeaderField{
+		{Name: ":method", Value: method},
+		{Name: ":scheme", Value: "https"},
+		{Name: ":authority", Value: u.Host},
+		{Name: ":path", Value: u.RequestURI()},
+		{Name: "user-agent", Value: userAgent},
+		{Name: "accept", Value: "*/*"},
+	})
+	payload := streamFrame(http3ControlStream, control, false)
+	payload = append(payload, streamFrame(http3RequestStream, http3Frame(http3FrameHeaders, headers), true)...)
+	return c.send(quicSpaceApplication, payload)
+}
+
+// readResponse reads packets until the response on the request stream is
+// complete, up to limit bytes of body.
+func (c *quicConn) readResponse(limit int64) error {
+	var body bytes.Buffer
+	// read is how much of the stream has been handled, and dataLeft how
+	// much of the current DATA frame remains.
+	read, dataLeft := 0, uint64(0)
+	for {
+		s := c.stream(http3RequestStream)
+		for read < len(s.data) {
+			if dataLeft > 0 {
+				n := uint64(len(s.data) - read)
+				if n > dataLeft {
+					n = dataLeft
+				}
+				data := s.data[read : read+int(n)]
+				read += int(n)
+				dataLeft -= n
+				if remaining := limit - int64(body.Len()); int64(len(data)) >= remaining {
+					body.Write(data[:remaining])
+					c.setBody(&body)
+					return nil
+				}
+				body.Write(data)
+				continue
+			}
+			r := newWireReader(s.data[read:])
+			typ, length := r.varint(), r.varint()
+			if !r.ok {
+				break
+			}
+			if typ == http3FrameData {
+				if c.result.Response == nil {
+					return errors.New("DATA frame before the response's HEADERS")
+				}
+				read, dataLeft = len(s.data)-len(r.data), length
+				continue
+			}
+			if length > uint64(len(r.data)) {
+				break
+			}
+			payload := r.bytes(int(length))
+			read = len(s.data) - len(r.data)
+			if typ != http3FrameHeaders {
+				// Unknown and reserved frame types are ignored.
+				continue
+			}
+			if err := c.handleHeaders(payload); err != nil {
+				return err
+			}
+		}
+		if s.complete() && read == len(s.data) {
+			if c.result.Response == nil {
+				return errors.New("request stream ended without a response")
+			}
+			c.setBody(&body)
+			return nil
+		}
+		if c.reset != nil {
+			return fmt.Errorf("server reset the request stream with %s", http3ErrorName(*c.reset))
+		}
+		if err := c.readDatagram(); err != nil {
+			return err
+		}
+	}
+}
+
+// handleHeaders records the response from a HEADERS frame, skipping
+// interim responses, or the trailers that follow the body.
+func (c *quicConn) handleHeaders(payload []byte) error {
+	fields, err := decodeQPACK(payload)
+	if err != nil {
+		return err
+	}
+	if c.result.Response != nil {
+		c.result.Response.Trailer = make(http.Header)
+		for _, field := range fields {
+			c.result.Response.Trailer.Add(field.Name, field.Value)
+		}
+		return nil
+	}
+	status := ""
+	header := make(http.Header)
+	for _, field := range fields {
+		if field.Name == ":status" {
+			status = field.Value
+		} else if !strings.HasPrefix(field.Name, ":") {
+			header.Add(field.Name, field.Value)
+		}
+	}
+	code, err := strconv.Atoi(status)
+	if err != nil {
+		return fmt.Errorf("invalid status %q", status)
+	}
+	if code >= 100 && code < 200 {
+		return nil
+	}
+	c.result.Response = &http.Response{
+		Status:     status + " " + http.StatusText(code),
+		StatusCode: code,
+		Protocol:   http.Protocol{Name: "HTTP/3.0", Major: 3},
+		Header:     header,
+	}
+	return nil
+}
+
+// setBody records the body received.
+func (c *quicConn) setBody(body *bytes.Buffer) {
+	if c.result.Response == nil || body.Len() == 0 {
+		return
+	}
+	setBody(c.result.Response, body.Bytes())
+}
+
+// http3Address returns the address to request the URL from over HTTP/3:
+// that of the first h3 alternative advertised with Alt-Svc, or else the
+// URL's host and port.
+func http3Address(u *url.URL, alternatives []*AltSvc) (string, string) {
+	for _, svc := range alternatives {
+		if svc.Protocol != "h3" {
+			continue
+		}
+		host := svc.Host
+		if host == "" {
+			host = u.Hostname()
+		}
+		return net.JoinHostPort(host, strconv.Itoa(int(svc.Port))), "alt_svc"
+	}
+	port := u.Port()
+	if port == "" {
+		port = strconv.Itoa(int(protoToPort["https"]))
+	}
+	return net.JoinHostPort(u.Hostname(), port), "origin"
+}
+
+// http3 requests the final URL over HTTP/3, if it is an https URL.
+func (scan *scan) http3(resp *http.Response) {
+	if resp.Request == nil || resp.Request.URL == nil || resp.Request.URL.Scheme != "https" {
+		return
+	}
+	u := resp.Request.URL
+	ret := new(HTTP3)
+	ret.Address, ret.Source = http3Address(u, scan.results.AltSvc)
+	scan.results.HTTP3 = ret
+	if err := scan.requestHTTP3(u, ret); err != nil {
+		ret.Error = err.Error()
+	}
+}
+
+func (scan *scan) requestHTTP3(u *url.URL, ret *HTTP3) error {
+	conn, err := scan.dialContext(context.Background(), "udp", ret.Address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	serverName := ""
+	if net.ParseIP(u.Hostname()) == nil {
+		serverName = u.Hostname()
+	}
+	c, err := newQUICConn(conn, serverName, ret)
+	if err != nil {
+		return err
+	}
+	if err := c.handshake(); err != nil {
+		return err
+	}
+	defer c.close()
+	if err := c.sendRequest(scan.scanner.config.Method, u, scan.scanner.config.UserAgent); err != nil {
+		return err
+	}
+	return c.readResponse(scan.bodyLimit())
+}
@@ -0,0 +1,315 @@
+package http
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"net"
+	nethttp "net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+	"github.com/zmap/zgrab2"
+	"golang.org/x/net/http2/hpack"
+)
+
+func TestQPACKRoundTrip(t *testing.T) {
+	fields := []hpack.HeaderField{
+		{Name: ":status", Value: "200"},
+		{Name: "server", Value: "test"},
+		{Name: "x-long", Value: string(bytes.Repeat([]byte("a"), 300))},
+	}
+	decoded, err := decodeQPACK(encodeQPACK(fields))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, fields) {
+		t.Errorf("got %+v", decoded)
+	}
+}
+
+func TestDecodeQPACKStatic(t *testing.T) {
+	// :status 200 indexed, content-type with a Huffman-coded literal value,
+	// and a reference to the dynamic table.
+	huffman := hpack.AppendHuffmanString(nil, "text/html")
+	block := []byte{0, 0, 0xc0 | 25, 0x50 | 0xf, 52 - 15, 0x80 | byte(len(huffman))}
+	block = append(block, huffman...)
+	fields, err := decodeQPACK(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []hpack.HeaderField{{Name: ":status", Value: "200"}, {Name: "content-type", Value: "text/html"}}
+	if !reflect.DeepEqual(fields, expected) {
+		t.Errorf("got %+v", fields)
+	}
+	if _, err := decodeQPACK([]byte{0, 0, 0x80}); err != errQPACKDynamic {
+		t.Errorf("dynamic reference not rejected: %v", err)
+	}
+}
+
+func TestParseQUICTransportParameters(t *testing.T) {
+	data := []byte{0x04, 0x04, 0x80, 0x10, 0x00, 0x00, 0x0c, 0x00, 0x02, 0x02, 0xab, 0xcd}
+	params := parseQUICTransportParameters(data)
+	if len(params) != 3 || params[0].Name != "initial_max_data" || params[0].Value == nil || *params[0].Value != 0x100000 {
+		t.Fatalf("got %+v", params)
+	}
+	if params[1].Name != "disable_active_migration" || params[1].Value != nil || params[1].Raw != nil {
+		t.Errorf("got %+v", params[1])
+	}
+	if !bytes.Equal(params[2].Raw, []byte{0xab, 0xcd}) {
+		t.Errorf("got %+v", params[2])
+	}
+}
+
+// TestQUICInitialKeys checks the keys against RFC 9001, appendix A.
+func TestQUICInitialKeys(t *testing.T) {
+	dcid, _ := hex.DecodeString("8394c8f03e515708")
+	client, server, err := quicInitialKeys(dcid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if iv := hex.EncodeToString(client.iv); iv != "fa044b2f42a3fd3b46fb255c" {
+		t.Errorf("wrong client IV %s", iv)
+	}
+	if iv := hex.EncodeToString(server.iv); iv != "0ac1493ca1905853b0bba03e" {
+		t.Errorf("wrong server IV %s", iv)
+	}
+	sample, _ := hex.DecodeString("d1b1c98dd7689fb8ec11d242b123dc9b")
+	if mask := hex.EncodeToString(client.mask(sample)[:5]); mask != "437b9aec36" {
+		t.Errorf("wrong client header protection mask %s", mask)
+	}
+}
+
+func TestHTTP3ErrorName(t *testing.T) {
+	for code, name := range map[uint64]string{0x100: "H3_NO_ERROR", 0x10c: "H3_REQUEST_CANCELLED", 0x200: "QPACK_DECOMPRESSION_FAILED", 0x21: "0x21"} {
+		if got := http3ErrorName(code); got != name {
+			t.Errorf("%x: got %s, expected %s", code, got, name)
+		}
+	}
+}
+
+// TestQUICRetryIntegrityTag checks the tag against RFC 9001, appendix A.4.
+func TestQUICRetryIntegrityTag(t *testing.T) {
+	odcid, _ := hex.DecodeString("8394c8f03e515708")
+	packet, _ := hex.DecodeString("ff000000010008f067a5502a4262b5746f6b656e04a265ba2eff4d829058fb3f0f2496ba")
+	tag, err := retryIntegrityTag(odcid, packet[:len(packet)-16])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(tag, packet[len(packet)-16:]) {
+		t.Errorf("wrong tag %x", tag)
+	}
+
+	result := new(HTTP3)
+	c, err := newQUICConn(&timeoutConn{}, "", result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.dcid = odcid
+	forged := append([]byte(nil), packet...)
+	forged[len(forged)-1] ^= 1
+	if err := c.processDatagram(forged); err != nil || result.Retry {
+		t.Errorf("Retry with a forged tag not discarded: %v", err)
+	}
+	if err := c.processDatagram(packet); err != nil || !result.Retry || string(c.token) != "token" {
+		t.Errorf("Retry not answered: %v, token %q", err, c.token)
+	}
+}
+
+// timeoutConn is a connection whose reads all time out, recording the
+// datagrams written to it.
+type timeoutConn struct {
+	net.Conn
+	written [][]byte
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func (c *timeoutConn) Read(b []byte) (int, error) {
+	return 0, timeoutError{}
+}
+
+func (c *timeoutConn) Write(b []byte) (int, error) {
+	c.written = append(c.written, append([]byte(nil), b...))
+	return len(b), nil
+}
+
+func (c *timeoutConn) SetReadDeadline(time.Time) error {
+	return nil
+}
+
+func TestQUICCryptoRetransmission(t *testing.T) {
+	conn := new(timeoutConn)
+	result := new(HTTP3)
+	c, err := newQUICConn(conn, "", result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.handshake(); err == nil {
+		t.Fatal("handshake completed without a server")
+	}
+	if len(conn.written) != 1+quicMaxPTOs || result.Retransmissions != quicMaxPTOs {
+		t.Fatalf("sent %d datagrams with %d retransmissions", len(conn.written), result.Retransmissions)
+	}
+	for _, datagram := range conn.written {
+		if len(datagram) < quicMinInitialSize || datagram[0]>>4&0x3 != quicPacketInitial {
+			t.Errorf("retransmission is not a padded Initial packet: %x", datagram[:8])
+		}
+	}
+
+	// An ACK of one of the packets, in a range after a gap, ends the
+	// retransmissions.
+	initial := c.spaces[quicSpaceInitial]
+	acked := &quicSpace{received: map[uint64]bool{2: true, 6: true, 7: true}}
+	if err := c.processFrames(quicSpaceInitial, acked.ackFrame()); err != nil {
+		t.Fatal(err)
+	}
+	if !initial.cryptoAcked || len(c.unacknowledgedCrypto()) != 0 {
+		t.Error("acknowledged ClientHello still to be sent again")
+	}
+
+	// The Finished is sent again until the server confirms the handshake.
+	handshake := c.spaces[quicSpaceHandshake]
+	handshake.send, handshake.receive = initial.send, initial.receive
+	if err := c.sendCrypto(quicSpaceHandshake, nil, []byte("finished")); err != nil {
+		t.Fatal(err)
+	}
+	if pending := c.unacknowledgedCrypto(); !reflect.DeepEqual(pending, []int{quicSpaceHandshake}) {
+		t.Errorf("wrong spaces to send again %v", pending)
+	}
+	if err := c.processFrames(quicSpaceHandshake, []byte{quicFrameHandshakeDone}); err != nil {
+		t.Fatal(err)
+	}
+	if pending := c.unacknowledgedCrypto(); len(pending) != 0 {
+		t.Errorf("handshake data sent again after HANDSHAKE_DONE: %v", pending)
+	}
+}
+
+// lossyProxy forwards datagrams between a client and the server at target,
+// dropping the client's first datagram.
+func lossyProxy(t *testing.T, target net.Addr) *net.UDPConn {
+	proxy, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	upstream, err := net.DialUDP("udp", nil, target.(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mutex sync.Mutex
+	var client *net.UDPAddr
+	go func() {
+		defer upstream.Close()
+		buf := make([]byte, 65536)
+		for dropped := false; ; dropped = true {
+			n, addr, err := proxy.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			mutex.Lock()
+			client = addr
+			mutex.Unlock()
+			if dropped {
+				upstream.Write(buf[:n])
+			}
+		}
+	}()
+	go func() {
+		buf := make([]byte, 65536)
+		for {
+			n, err := upstream.Read(buf)
+			if err != nil {
+				return
+			}
+			mutex.Lock()
+			addr := client
+			mutex.Unlock()
+			proxy.WriteToUDP(buf[:n], addr)
+		}
+	}()
+	return proxy
+}
+
+// TestHTTP3QUICGo requests a URL over HTTP/3 from quic-go's server, which
+// the final response advertises with Alt-Svc. The server is reached through
+// a proxy that drops the first ClientHello, and asks for a Retry if retry
+// is set.
+func TestHTTP3QUICGo(t *testing.T) {
+	for _, retry := range []bool{false, true} {
+		var h3Port int
+		handler := nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+			w.Header().Set("Alt-Svc", fmt.Sprintf(`h3=":%d"`, h3Port))
+			w.Header().Set("X-Protocol", r.Proto)
+			w.Write([]byte("<html><body>Hello, world</body></html>"))
+		})
+		https := httptest.NewTLSServer(handler)
+		udp, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		proxy := lossyProxy(t, udp.LocalAddr())
+		h3Port = proxy.LocalAddr().(*net.UDPAddr).Port
+		transport := &quic.Transport{Conn: udp, VerifySourceAddress: func(net.Addr) bool { return retry }}
+		listener, err := transport.ListenEarly(http3.ConfigureTLSConfig(&tls.Config{Certificates: https.TLS.Certificates}), &quic.Config{MaxIdleTimeout: 30 * time.Second})
+		if err != nil {
+			t.Fatal(err)
+		}
+		server := &http3.Server{Handler: handler}
+		go server.ServeListener(listener)
+
+		flags := &Flags{Method: "GET", Endpoint: "/", UserAgent: "zgrab2 test", MaxSize: 256, UseHTTPS: true, HTTP3: true}
+		flags.Port = uint(https.Listener.Addr().(*net.TCPAddr).Port)
+		flags.Timeout = 10 * time.Second
+		if err := flags.Validate(nil); err != nil {
+			t.Fatal(err)
+		}
+		var scanner Scanner
+		if err := scanner.Init(flags); err != nil {
+			t.Fatal(err)
+		}
+		status, ret, err := scanner.Scan(zgrab2.ScanTarget{IP: net.ParseIP("127.0.0.1")})
+		server.Close()
+		listener.Close()
+		transport.Close()
+		proxy.Close()
+		https.Close()
+		if status != zgrab2.SCAN_SUCCESS {
+			t.Fatalf("scan failed: %s %v", status, err)
+		}
+
+		result := ret.(*Results).HTTP3
+		if result == nil || result.Error != "" || result.Source != "alt_svc" || result.QUICVersion != "v1" || result.NegotiatedProtocol != "h3" {
+			t.Fatalf("wrong result %+v", result)
+		}
+		if result.Retry != retry || result.Retransmissions == 0 {
+			t.Errorf("Retry %v with %d retransmissions, expected Retry %v after a lost ClientHello", result.Retry, result.Retransmissions, retry)
+		}
+		params := make(map[string]*QUICTransportParameter)
+		for i, param := range result.TransportParameters {
+			params[param.Name] = &result.TransportParameters[i]
+		}
+		if p := params["initial_max_data"]; p == nil || p.Value == nil || *p.Value == 0 {
+			t.Errorf("initial_max_data missing from %+v", result.TransportParameters)
+		}
+		if p := params["initial_source_connection_id"]; p == nil || len(p.Raw) == 0 {
+			t.Errorf("initial_source_connection_id missing from %+v", result.TransportParameters)
+		}
+		if p := params["retry_source_connection_id"]; (p != nil) != retry {
+			t.Errorf("retry_source_connection_id %+v, expected it with Retry %v", p, retry)
+		}
+		response := result.Response
+		if response == nil || response.StatusCode != 200 || response.Protocol.Name != "HTTP/3.0" || response.Header.Get("X-Protocol") != "HTTP/3.0" || response.BodyText != "<html><body>Hello, world</body></html>" {
+			t.Errorf("wrong response %+v", response)
+		}
+	}
+}
@@ -338,6 +338,7 @@ func (cfg *readLimitTestConfig) runTest(t *testing.T, testName string) {
 // TestReadLimitHTTP checks that the HTTP scanner works as expected with the default
 // ReadLimitExeededAction (specifically, ReadLimnitExceededActionTruncate) defined in conn.go.
 func TestReadLimitHTTP(t *testing.T) {
+	defer func(limit int) { zgrab2.DefaultBytesReadLimit = limit }(zgrab2.DefaultBytesReadLimit)
 	if zgrab2.DefaultReadLimitExceededAction != zgrab2.ReadLimitExceededActionTruncate {
 		t.Logf("Warning: DefaultReadLimitExceededAction is %s, not %s", zgrab2.DefaultReadLimitExceededAction, zgrab2.ReadLimitExceededActionTruncate)
 	}
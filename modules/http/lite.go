@@ -0,0 +1,96 @@
+package http
+
+import (
+	"crypto/sha256"
+	"net/url"
+
+	"github.com/zmap/zcrypto/tls"
+	"github.com/zmap/zgrab2/lib/http"
+)
+
+// LiteResult is the small record of fixed shape the module gives with
+// --lite, for sweeps of very many endpoints: the final response's status and
+// a few of its headers, the page's title and icon, and a fingerprint of the
+// server's certificate instead of its chain.
+type LiteResult struct {
+	URL           string `json:"url"`
+	StatusCode    int    `json:"status_code"`
+	Server        string `json:"server,omitempty"`
+	ContentType   string `json:"content_type,omitempty"`
+	ContentLength int64  `json:"content_length"`
+	Location      string `json:"location,omitempty"`
+
+	// Title is the page's title, and Icon the URL of the icon it links to,
+	// if they are in the part of the body read.
+	Title string `json:"title,omitempty"`
+	Icon  string `json:"icon,omitempty"`
+
+	// BodyLength is the number of body bytes read, up to
+	// --lite-body-size, and BodySHA256 their digest.
+	BodyLength int                  `json:"body_length"`
+	BodySHA256 http.PageFingerprint `json:"body_sha256,omitempty"`
+
+	TLS *LiteTLS `json:"tls,omitempty"`
+}
+
+// LiteTLS summarizes the TLS handshake of the final response's connection.
+type LiteTLS struct {
+	Version     tls.TLSVersion  `json:"version"`
+	CipherSuite tls.CipherSuite `json:"cipher_suite"`
+
+	// CertificateSHA256 is the SHA-256 fingerprint of the server's
+	// certificate.
+	CertificateSHA256 http.PageFingerprint `json:"certificate_sha256,omitempty"`
+}
+
+// newLiteResult summarizes the final response, or returns nil if there was
+// none.
+func newLiteResult(resp *http.Response) *LiteResult {
+	if resp == nil {
+		return nil
+	}
+	ret := &LiteResult{
+		StatusCode:    resp.StatusCode,
+		Server:        resp.Header.Get("Server"),
+		ContentType:   resp.Header.Get("Content-Type"),
+		ContentLength: resp.ContentLength,
+		Location:      resp.Header.Get("Location"),
+		BodyLength:    len(resp.BodyText),
+		BodySHA256:    resp.BodySHA256,
+	}
+	body := []byte(resp.BodyText)
+	ret.Title = htmlTitle(body)
+	ret.Icon = htmlIcon(body)
+	if resp.Request == nil {
+		return ret
+	}
+	if resp.Request.URL != nil {
+		ret.URL = resp.Request.URL.String()
+		if ret.Icon != "" {
+			if icon, err := url.Parse(ret.Icon); err == nil {
+				ret.Icon = resp.Request.URL.ResolveReference(icon).String()
+			}
+		}
+	}
+	if log := resp.Request.TLSLog; log != nil && log.HandshakeLog != nil && log.HandshakeLog.ServerHello != nil {
+		hello := log.HandshakeLog.ServerHello
+		ret.TLS = &LiteTLS{Version: hello.Version, CipherSuite: hello.CipherSuite}
+		if certificates := log.HandshakeLog.ServerCertificates; certificates != nil && len(certificates.Certificate.Raw) > 0 {
+			sum := sha256.Sum256(certificates.Certificate.Raw)
+			ret.TLS.CertificateSHA256 = sum[:]
+		}
+	}
+	return ret
+}
+
+// result returns the scan's results: with --lite, only the LiteResult and
+// the VHosts, and with --body-store-dir, with the bodies stored.
+func (scan *scan) result() *Results {
+	if !scan.scanner.config.Lite {
+		if scan.scanner.config.BodyStoreDir != "" {
+			scan.storeBodies()
+		}
+		return &scan.results
+	}
+	return &Results{Lite: newLiteResult(scan.results.Response), VHosts: scan.results.VHosts}
+}
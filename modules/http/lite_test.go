@@ -0,0 +1,123 @@
+package http
+
+import (
+	"fmt"
+	"net"
+	nethttp "net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zmap/zgrab2"
+	"github.com/zmap/zgrab2/lib/http"
+)
+
+func TestHTMLTitle(t *testing.T) {
+	for body, title := range map[string]string{
+		"<html><head><TITLE>Router\n  Login</TITLE></head>": "Router Login",
+		"<title lang=en>Caf&eacute; &amp; Bar</title>":      "Café & Bar",
+		"<titlebar>no</titlebar><title>yes</title>":         "yes",
+		"<title>Unterminated":                               "Unterminated",
+		"<p>No title</p>":                                   "",
+	} {
+		if got := htmlTitle([]byte(body)); got != title {
+			t.Errorf("%q: got %q, expected %q", body, got, title)
+		}
+	}
+	for body, icon := range map[string]string{
+		`<link rel="stylesheet" href="a.css"><link REL="shortcut icon" href='/f.ico'>`: "/f.ico",
+		`<link rel=icon href=/static/icon.png?v=1&amp;x=2>`:                            "/static/icon.png?v=1&x=2",
+		`<linked rel="icon" href="no">`:                                                "",
+		`<link rel="icon" href="`:                                                      "",
+	} {
+		if got := htmlIcon([]byte(body)); got != icon {
+			t.Errorf("%q: got %q, expected %q", body, got, icon)
+		}
+	}
+}
+
+func TestHTMLMetadata(t *testing.T) {
+	page, _ := url.Parse("http://example.com/blog/post?id=1")
+	for _, test := range []struct {
+		contentType string
+		body        string
+		expected    *HTMLMetadata
+	}{
+		{
+			"text/html; charset=ISO-8859-1",
+			`<title>Post</title><meta name="Generator" content=" WordPress 6.4 "><meta charset="utf-8"><link rel="canonical" href="/blog/post">`,
+			&HTMLMetadata{Title: "Post", Generator: "WordPress 6.4", Charset: "iso-8859-1", CharsetSource: "header", Canonical: "http://example.com/blog/post"},
+		},
+		{
+			"",
+			`<!DOCTYPE html><meta http-equiv="Content-Type" content="text/html; charset=Shift_JIS"><title>Top</title>`,
+			&HTMLMetadata{Title: "Top", Charset: "shift_jis", CharsetSource: "meta"},
+		},
+		{"application/json", `{"title": "<title>no</title>"}`, nil},
+	} {
+		resp := &http.Response{Header: http.Header{}, BodyText: test.body, Request: &http.Request{URL: page}}
+		if test.contentType != "" {
+			resp.Header.Set("Content-Type", test.contentType)
+		}
+		if got := newHTMLMetadata(resp); !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("%q: got %+v, expected %+v", test.body, got, test.expected)
+		}
+	}
+}
+
+func TestLite(t *testing.T) {
+	page := "<html><head><title>Welcome</title><link rel=icon href=/img/fav.png></head><body>" + strings.Repeat("x", 4096) + "</body></html>"
+	server := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		w.Header().Set("Server", "nginx/1.25.3")
+		w.Header().Set("Content-Type", "text/html")
+		w.Header().Set("Content-Length", fmt.Sprint(len(page)))
+		w.Write([]byte(page))
+	}))
+	defer server.Close()
+
+	flags := &Flags{Method: "GET", Endpoint: "/", UserAgent: "zgrab2 test", MaxSize: 256, Lite: true, LiteBodySize: 1}
+	if err := flags.Validate(nil); err != nil {
+		t.Fatal(err)
+	}
+	port := server.Listener.Addr().(*net.TCPAddr).Port
+	flags.Port = uint(port)
+	flags.Timeout = time.Second
+	var scanner Scanner
+	if err := scanner.Init(flags); err != nil {
+		t.Fatal(err)
+	}
+	status, ret, err := scanner.Scan(zgrab2.ScanTarget{IP: net.ParseIP("127.0.0.1")})
+	if status != zgrab2.SCAN_SUCCESS {
+		t.Fatalf("scan failed: %s %v", status, err)
+	}
+	results := ret.(*Results)
+	if results.Response != nil || results.Lite == nil {
+		t.Fatalf("wrong results %+v", results)
+	}
+	lite := results.Lite
+	expected := LiteResult{
+		URL:           fmt.Sprintf("http://127.0.0.1:%d/", port),
+		StatusCode:    200,
+		Server:        "nginx/1.25.3",
+		ContentType:   "text/html",
+		ContentLength: int64(len(page)),
+		Title:         "Welcome",
+		Icon:          fmt.Sprintf("http://127.0.0.1:%d/img/fav.png", port),
+		BodyLength:    1024,
+	}
+	lite.BodySHA256 = nil
+	if !reflect.DeepEqual(*lite, expected) {
+		t.Errorf("got %+v, expected %+v", lite, expected)
+	}
+	if product := scanner.IdentifyProduct(results); product == nil || product.Version != "1.25.3" {
+		t.Errorf("wrong product %+v", product)
+	}
+
+	flags.ProbeEncodings = true
+	if err := flags.Validate(nil); err == nil {
+		t.Error("--lite with --probe-encodings accepted")
+	}
+}
@@ -0,0 +1,68 @@
+package http
+
+import (
+	"strings"
+
+	"github.com/zmap/zgrab2"
+)
+
+// serverProduct gives the CPE vendor and product names for a Server header
+// product token.
+type serverProduct struct {
+	vendor string
+	name   string
+}
+
+// knownServers maps lowercased Server header product tokens to their CPE
+// names.
+var knownServers = map[string]serverProduct{
+	"apache":        {"apache", "http_server"},
+	"nginx":         {"f5", "nginx"},
+	"openresty":     {"openresty", "openresty"},
+	"microsoft-iis": {"microsoft", "internet_information_services"},
+	"lighttpd":      {"lighttpd", "lighttpd"},
+	"litespeed":     {"litespeedtech", "litespeed_web_server"},
+	"jetty":         {"eclipse", "jetty"},
+	"caddy":         {"caddyserver", "caddy"},
+	"gunicorn":      {"gunicorn", "gunicorn"},
+	"boa":           {"boa", "boa"},
+	"mini_httpd":    {"acme", "mini_httpd"},
+	"thttpd":        {"acme", "thttpd"},
+	"goahead-webs":  {"embedthis", "goahead"},
+}
+
+// parseServerHeader returns the product named by the first product token of
+// a Server header, e.g. "Apache/2.4.41 (Ubuntu)", or nil if it is empty.
+func parseServerHeader(server string) *zgrab2.Product {
+	fields := strings.Fields(server)
+	if len(fields) == 0 {
+		return nil
+	}
+	name, version := fields[0], ""
+	if i := strings.Index(name, "/"); i >= 0 {
+		name, version = name[:i], name[i+1:]
+	}
+	if name == "" {
+		return nil
+	}
+	if known, ok := knownServers[strings.ToLower(name)]; ok {
+		return zgrab2.NewProduct(zgrab2.CPEApplication, known.vendor, known.name, version)
+	}
+	return zgrab2.NewProduct(zgrab2.CPEApplication, "", name, version)
+}
+
+// IdentifyProduct returns the web server named by the Server header of the
+// final response.
+func (scanner *Scanner) IdentifyProduct(result interface{}) *zgrab2.Product {
+	results, ok := result.(*Results)
+	if !ok || results == nil {
+		return nil
+	}
+	if results.Lite != nil {
+		return parseServerHeader(results.Lite.Server)
+	}
+	if results.Response == nil {
+		return nil
+	}
+	return parseServerHeader(results.Response.Header.Get("Server"))
+}
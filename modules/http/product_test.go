@@ -0,0 +1,26 @@
+package http
+
+import (
+	"testing"
+)
+
+func TestParseServerHeader(t *testing.T) {
+	tests := []struct {
+		header                string
+		vendor, name, version string
+	}{
+		{"Apache/2.4.41 (Ubuntu)", "apache", "http_server", "2.4.41"},
+		{"nginx", "f5", "nginx", ""},
+		{"Microsoft-IIS/10.0", "microsoft", "internet_information_services", "10.0"},
+		{"cloudflare", "", "cloudflare", ""},
+	}
+	for _, test := range tests {
+		product := parseServerHeader(test.header)
+		if product == nil || product.Vendor != test.vendor || product.Name != test.name || product.Version != test.version {
+			t.Errorf("%q: expected %s %s %s, got %+v", test.header, test.vendor, test.name, test.version, product)
+		}
+	}
+	if product := parseServerHeader(" "); product != nil {
+		t.Errorf("expected no product for an empty header, got %+v", product)
+	}
+}
@@ -0,0 +1,1203 @@
+package http
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"time"
+
+	"github.com/zmap/zgrab2"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+)
+
+// quicVersion1 is the only QUIC version spoken (RFC 9000).
+const quicVersion1 = 0x00000001
+
+// quicInitialSalt is the salt the keys protecting QUIC version 1 Initial
+// packets are derived with (RFC 9001, section 5.2).
+var quicInitialSalt = []byte{0x38, 0x76, 0x2c, 0xf7, 0xf5, 0x59, 0x34, 0xb3, 0x4d, 0x17, 0x9a, 0xe6, 0xa4, 0xc8, 0x0c, 0xad, 0xcc, 0xbb, 0x7f, 0x0a}
+
+// quicRetryKey and quicRetryNonce are the key and nonce of the integrity
+// tags of QUIC version 1 Retry packets (RFC 9001, section 5.8).
+var (
+	quicRetryKey   = []byte{0xbe, 0x0c, 0x69, 0x0b, 0x9f, 0x66, 0x57, 0x5a, 0x1d, 0x76, 0x6b, 0x54, 0xe3, 0x68, 0xc8, 0x4e}
+	quicRetryNonce = []byte{0x46, 0x15, 0x99, 0xd3, 0x5d, 0x63, 0x2b, 0xf2, 0x23, 0x98, 0x25, 0xbb}
+)
+
+// quicInitialPTO is the time to wait for the server to acknowledge the
+// client's handshake data before sending it again, doubled each time, up to
+// quicMaxPTOs times (RFC 9002, section 6.2).
+const (
+	quicInitialPTO = time.Second
+	quicMaxPTOs    = 4
+)
+
+// quicMinInitialSize is the size client datagrams carrying Initial packets
+// are padded to.
+const quicMinInitialSize = 1200
+
+// quicConnectionIDLength is the length of the connection IDs chosen.
+const quicConnectionIDLength = 8
+
+// quicFlowControlLimit is the flow-control limit advertised for the
+// connection and each stream, large enough for any response body read.
+const quicFlowControlLimit = 1 << 24
+
+// Long header packet types.
+const (
+	quicPacketInitial   = 0x0
+	quicPacketHandshake = 0x2
+	quicPacketRetry     = 0x3
+)
+
+// Packet number spaces.
+const (
+	quicSpaceInitial = iota
+	quicSpaceHandshake
+	quicSpaceApplication
+)
+
+const (
+	quicFramePadding         = 0x00
+	quicFramePing            = 0x01
+	quicFrameAck             = 0x02
+	quicFrameAckECN          = 0x03
+	quicFrameResetStream     = 0x04
+	quicFrameStopSending     = 0x05
+	quicFrameCrypto          = 0x06
+	quicFrameNewToken        = 0x07
+	quicFrameStream          = 0x08
+	quicFrameMaxData         = 0x10
+	quicFrameMaxStreamData   = 0x11
+	quicFrameMaxStreamsBidi  = 0x12
+	quicFrameMaxStreamsUni   = 0x13
+	quicFrameDataBlocked     = 0x14
+	quicFrameStreamBlocked   = 0x15
+	quicFrameStreamsBlocked  = 0x16
+	quicFrameStreamsBlockedU = 0x17
+	quicFrameNewConnectionID = 0x18
+	quicFrameRetireConnID    = 0x19
+	quicFramePathChallenge   = 0x1a
+	quicFramePathResponse    = 0x1b
+	quicFrameConnectionClose = 0x1c
+	quicFrameApplicationCl   = 0x1d
+	quicFrameHandshakeDone   = 0x1e
+	quicFrameDatagram        = 0x30
+	quicFrameDatagramLength  = 0x31
+)
+
+// Flags in the type of STREAM frames.
+const (
+	quicStreamFin    = 0x1
+	quicStreamLength = 0x2
+	quicStreamOffset = 0x4
+)
+
+// TLS values used in the QUIC handshake, which only offers
+// TLS_AES_128_GCM_SHA256 and X25519.
+const (
+	quicTLSClientHello         = 1
+	quicTLSServerHello         = 2
+	quicTLSEncryptedExtensions = 8
+	quicTLSFinished            = 20
+
+	quicTLSExtensionServerName          = 0
+	quicTLSExtensionSupportedGroups     = 10
+	quicTLSExtensionSignatureAlgorithms = 13
+	quicTLSExtensionALPN                = 16
+	quicTLSExtensionSupportedVersions   = 43
+	quicTLSExtensionKeyShare            = 51
+	quicTLSExtensionTransportParameters = 0x39
+
+	quicTLSCipherSuite = 0x1301
+	quicTLSGroupX25519 = 0x001d
+)
+
+var quicTLSSignatureAlgorithms = []uint16{0x0403, 0x0804, 0x0401, 0x0503, 0x0805, 0x0501, 0x0806, 0x0601, 0x0807}
+
+// quicHelloRetryRandom is the random of a ServerHello that is a
+// HelloRetryRequest.
+var quicHelloRetryRandom = []byte{
+	0xcf, 0x21, 0xad, 0x74, 0xe5, 0x9a, 0x61, 0x11, 0xbe, 0x1d, 0x8c, 0x02, 0x1e, 0x65, 0xb8, 0x91,
+	0xc2, 0xa2, 0x11, 0x16, 0x7a, 0xbb, 0x8c, 0x5e, 0x07, 0x9e, 0x09, 0xe2, 0xc8, 0xa8, 0x33, 0x9c,
+}
+
+var quicTransportParameterNames = map[uint64]string{
+	0x00:   "original_destination_connection_id",
+	0x01:   "max_idle_timeout",
+	0x02:   "stateless_reset_token",
+	0x03:   "max_udp_payload_size",
+	0x04:   "initial_max_data",
+	0x05:   "initial_max_stream_data_bidi_local",
+	0x06:   "initial_max_stream_data_bidi_remote",
+	0x07:   "initial_max_stream_data_uni",
+	0x08:   "initial_max_streams_bidi",
+	0x09:   "initial_max_streams_uni",
+	0x0a:   "ack_delay_exponent",
+	0x0b:   "max_ack_delay",
+	0x0c:   "disable_active_migration",
+	0x0d:   "preferred_address",
+	0x0e:   "active_connection_id_limit",
+	0x0f:   "initial_source_connection_id",
+	0x10:   "retry_source_connection_id",
+	0x11:   "version_information",
+	0x20:   "max_datagram_frame_size",
+	0x2ab2: "grease_quic_bit",
+}
+
+// quicIntegerParameters are the transport parameters whose values are
+// integers.
+var quicIntegerParameters = map[uint64]bool{
+	0x01: true, 0x03: true, 0x04: true, 0x05: true, 0x06: true, 0x07: true,
+	0x08: true, 0x09: true, 0x0a: true, 0x0b: true, 0x0e: true, 0x20: true,
+}
+
+var quicTransportErrors = []string{
+	"NO_ERROR",
+	"INTERNAL_ERROR",
+	"CONNECTION_REFUSED",
+	"FLOW_CONTROL_ERROR",
+	"STREAM_LIMIT_ERROR",
+	"STREAM_STATE_ERROR",
+	"FINAL_SIZE_ERROR",
+	"FRAME_ENCODING_ERROR",
+	"TRANSPORT_PARAMETER_ERROR",
+	"CONNECTION_ID_LIMIT_ERROR",
+	"PROTOCOL_VIOLATION",
+	"INVALID_TOKEN",
+	"APPLICATION_ERROR",
+	"CRYPTO_BUFFER_EXCEEDED",
+	"KEY_UPDATE_ERROR",
+	"AEAD_LIMIT_REACHED",
+	"NO_VIABLE_PATH",
+}
+
+func quicTransportErrorName(code uint64) string {
+	switch {
+	case code < uint64(len(quicTransportErrors)):
+		return quicTransportErrors[code]
+	case code >= 0x100 && code <= 0x1ff:
+		return fmt.Sprintf("CRYPTO_ERROR (TLS alert %d)", code-0x100)
+	}
+	return fmt.Sprintf("0x%x", code)
+}
+
+// wireReader reads QUIC and TLS encodings, setting ok to false once it
+// runs out of data.
+type wireReader struct {
+	data []byte
+	ok   bool
+}
+
+func newWireReader(data []byte) *wireReader {
+	return &wireReader{data: data, ok: true}
+}
+
+func (r *wireReader) bytes(n int) []byte {
+	if !r.ok || n < 0 || len(r.data) < n {
+		r.ok = false
+		return nil
+	}
+	ret := r.data[:n]
+	r.data = r.data[n:]
+	return ret
+}
+
+func (r *wireReader) uint8() uint8 {
+	b := r.bytes(1)
+	if b == nil {
+		return 0
+	}
+	return b[0]
+}
+
+func (r *wireReader) uint16() uint16 {
+	b := r.bytes(2)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint16(b)
+}
+
+// varint reads a QUIC variable-length integer (RFC 9000, section 16).
+func (r *wireReader) varint() uint64 {
+	b := r.bytes(1)
+	if b == nil {
+		return 0
+	}
+	rest := r.bytes(1<<(b[0]>>6) - 1)
+	value := uint64(b[0] & 0x3f)
+	for _, c := range rest {
+		value = value<<8 | uint64(c)
+	}
+	return value
+}
+
+// varintBytes reads bytes preceded by their length as a varint.
+func (r *wireReader) varintBytes() []byte {
+	n := r.varint()
+	if n > uint64(len(r.data)) {
+		r.ok = false
+		return nil
+	}
+	return r.bytes(int(n))
+}
+
+// vector reads a TLS vector whose length takes lengthBytes bytes.
+func (r *wireReader) vector(lengthBytes int) *wireReader {
+	n := 0
+	for _, b := range r.bytes(lengthBytes) {
+		n = n<<8 | int(b)
+	}
+	data := r.bytes(n)
+	return &wireReader{data: data, ok: r.ok}
+}
+
+func appendVarint(b []byte, v uint64) []byte {
+	switch {
+	case v < 1<<6:
+		return append(b, byte(v))
+	case v < 1<<14:
+		return append(b, 0x40|byte(v>>8), byte(v))
+	case v < 1<<30:
+		return append(b, 0x80|byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+	}
+	return append(b, 0xc0|byte(v>>56), byte(v>>48), byte(v>>40), byte(v>>32), byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+// quicKeys protect the packets sent by one side in one packet number space
+// (RFC 9001, section 5).
+type quicKeys struct {
+	aead cipher.AEAD
+	iv   []byte
+	hp   cipher.Block
+}
+
+// newQUICKeys derives the AES-128-GCM packet protection keys from a
+// traffic secret.
+func newQUICKeys(secret []byte) (*quicKeys, error) {
+	block, err := aes.NewCipher(zgrab2.TLS13ExpandLabel(secret, "quic key", nil, 16))
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	hp, err := aes.NewCipher(zgrab2.TLS13ExpandLabel(secret, "quic hp", nil, 16))
+	if err != nil {
+		return nil, err
+	}
+	return &quicKeys{aead: aead, iv: zgrab2.TLS13ExpandLabel(secret, "quic iv", nil, 12), hp: hp}, nil
+}
+
+// retryIntegrityTag returns the integrity tag of a Retry packet, given
+// without its tag, answering a packet sent to the connection ID odcid.
+func retryIntegrityTag(odcid, packet []byte) ([]byte, error) {
+	block, err := aes.NewCipher(quicRetryKey)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	pseudo := append(zgrab2.TLSVector(1, odcid), packet...)
+	return aead.Seal(nil, quicRetryNonce, nil, pseudo), nil
+}
+
+// quicInitialKeys derives the keys protecting the client's and the
+// server's Initial packets from the destination connection ID of the
+// client's first Initial packet.
+func quicInitialKeys(dcid []byte) (client, server *quicKeys, err error) {
+	secret := hkdf.Extract(sha256.New, dcid, quicInitialSalt)
+	if client, err = newQUICKeys(zgrab2.TLS13ExpandLabel(secret, "client in", nil, 32)); err != nil {
+		return nil, nil, err
+	}
+	if server, err = newQUICKeys(zgrab2.TLS13ExpandLabel(secret, "server in", nil, 32)); err != nil {
+		return nil, nil, err
+	}
+	return client, server, nil
+}
+
+func (k *quicKeys) nonce(pn uint64) []byte {
+	nonce := append([]byte(nil), k.iv...)
+	for i := 0; i < 8; i++ {
+		nonce[len(nonce)-1-i] ^= byte(pn >> (8 * uint(i)))
+	}
+	return nonce
+}
+
+func (k *quicKeys) mask(sample []byte) []byte {
+	mask := make([]byte, aes.BlockSize)
+	k.hp.Encrypt(mask, sample)
+	return mask
+}
+
+// seal encrypts the payload of a packet whose header ends with a 4-byte
+// packet number at pnOffset, and protects the header.
+func (k *quicKeys) seal(header []byte, pnOffset int, pn uint64, payload []byte) []byte {
+	packet := k.aead.Seal(header[:len(header):len(header)], k.nonce(pn), payload, header)
+	mask := k.mask(packet[pnOffset+4 : pnOffset+4+aes.BlockSize])
+	if packet[0]&0x80 != 0 {
+		packet[0] ^= mask[0] & 0x0f
+	} else {
+		packet[0] ^= mask[0] & 0x1f
+	}
+	for i := 0; i < 4; i++ {
+		packet[pnOffset+i] ^= mask[1+i]
+	}
+	return packet
+}
+
+// open removes the header protection from the packet, whose packet number
+// starts at pnOffset, and decrypts its payload, given the largest packet
+// number received in its space.
+func (k *quicKeys) open(packet []byte, pnOffset int, largest int64) (uint64, []byte, error) {
+	if len(packet) < pnOffset+4+aes.BlockSize {
+		return 0, nil, errors.New("packet too short")
+	}
+	mask := k.mask(packet[pnOffset+4 : pnOffset+4+aes.BlockSize])
+	header := append([]byte(nil), packet[:pnOffset+4]...)
+	if header[0]&0x80 != 0 {
+		header[0] ^= mask[0] & 0x0f
+	} else {
+		header[0] ^= mask[0] & 0x1f
+	}
+	pnLength := int(header[0]&0x3) + 1
+	var truncated uint64
+	for i := 0; i < pnLength; i++ {
+		header[pnOffset+i] ^= mask[1+i]
+		truncated = truncated<<8 | uint64(header[pnOffset+i])
+	}
+	header = header[:pnOffset+pnLength]
+	pn := decodePacketNumber(largest, truncated, uint(8*pnLength))
+	payload, err := k.aead.Open(nil, k.nonce(pn), packet[pnOffset+pnLength:], header)
+	if err != nil {
+		return 0, nil, err
+	}
+	return pn, payload, nil
+}
+
+// decodePacketNumber recovers a packet number from its truncated bits
+// (RFC 9000, appendix A.3).
+func decodePacketNumber(largest int64, truncated uint64, bits uint) uint64 {
+	expected := uint64(largest + 1)
+	window := uint64(1) << bits
+	half := window / 2
+	candidate := expected&^(window-1) | truncated
+	if candidate+half <= expected && candidate < 1<<62-window {
+		return candidate + window
+	}
+	if candidate > expected+half && candidate >= window {
+		return candidate - window
+	}
+	return candidate
+}
+
+// quicStream reassembles the data of a stream, or of the CRYPTO frames of
+// a packet number space.
+type quicStream struct {
+	data    []byte
+	pending map[uint64][]byte
+
+	fin       bool
+	finalSize uint64
+}
+
+func (s *quicStream) add(offset uint64, data []byte, fin bool) {
+	if fin {
+		s.fin, s.finalSize = true, offset+uint64(len(data))
+	}
+	if offset > uint64(len(s.data)) {
+		if s.pending == nil {
+			s.pending = make(map[uint64][]byte)
+		}
+		s.pending[offset] = append([]byte(nil), data...)
+		return
+	}
+	if end := offset + uint64(len(data)); end > uint64(len(s.data)) {
+		s.data = append(s.data, data[uint64(len(s.data))-offset:]...)
+	}
+	for offset, data := range s.pending {
+		if offset <= uint64(len(s.data)) {
+			delete(s.pending, offset)
+			s.add(offset, data, false)
+			return
+		}
+	}
+}
+
+// complete returns true once all of the stream's data has been received.
+func (s *quicStream) complete() bool {
+	return s.fin && uint64(len(s.data)) == s.finalSize
+}
+
+// quicSpace is the state of a packet number space.
+type quicSpace struct {
+	send, receive *quicKeys
+
+	nextPN   uint64
+	largest  int64
+	received map[uint64]bool
+
+	// ackPending is true if an ack-eliciting packet has been received
+	// since the last ACK was sent.
+	ackPending bool
+
+	crypto       quicStream
+	cryptoRead   int
+	cryptoOffset uint64
+
+	// cryptoSent is the handshake data sent in the space, cryptoPackets
+	// the packets that carried it, and cryptoAcked is true once the server
+	// has acknowledged one of them.
+	cryptoSent    []byte
+	cryptoPackets map[uint64]bool
+	cryptoAcked   bool
+}
+
+// acknowledge notes that the server acknowledged the packets numbered low
+// to high.
+func (s *quicSpace) acknowledge(low, high uint64) {
+	for pn := range s.cryptoPackets {
+		if pn >= low && pn <= high {
+			s.cryptoAcked = true
+		}
+	}
+}
+
+// quicMaxAckRanges is the most ranges of packets acknowledged in an ACK
+// frame.
+const quicMaxAckRanges = 64
+
+// ackFrame returns an ACK frame for the packets received, acknowledging
+// only the most recent if they fall in too many ranges.
+func (s *quicSpace) ackFrame() []byte {
+	pns := make([]uint64, 0, len(s.received))
+	for pn := range s.received {
+		pns = append(pns, pn)
+	}
+	sort.Slice(pns, func(i, j int) bool { return pns[i] > pns[j] })
+	var ranges [][2]uint64
+	for _, pn := range pns {
+		if n := len(ranges); n > 0 && ranges[n-1][1] == pn+1 {
+			ranges[n-1][1] = pn
+		} else if n < quicMaxAckRanges {
+			ranges = append(ranges, [2]uint64{pn, pn})
+		} else {
+			break
+		}
+	}
+	frame := appendVarint([]byte{quicFrameAck}, ranges[0][0])
+	frame = appendVarint(frame, 0)
+	frame = appendVarint(frame, uint64(len(ranges)-1))
+	frame = appendVarint(frame, ranges[0][0]-ranges[0][1])
+	for i := 1; i < len(ranges); i++ {
+		frame = appendVarint(frame, ranges[i-1][1]-ranges[i][0]-2)
+		frame = appendVarint(frame, ranges[i][0]-ranges[i][1])
+	}
+	return frame
+}
+
+// quicConn is a client connection speaking QUIC version 1 for a single
+// HTTP/3 request. Its TLS 1.3 handshake offers only TLS_AES_128_GCM_SHA256
+// and X25519, and does not verify the server's certificate. Of the packets
+// lost, only those carrying handshake data are sent again.
+type quicConn struct {
+	conn       net.Conn
+	serverName string
+	result     *HTTP3
+
+	// dcid is the server's connection ID, and scid the client's.
+	dcid, scid []byte
+
+	// token is the token from a Retry packet.
+	token []byte
+
+	// serverCID is true once dcid is the connection ID the server chose.
+	serverCID bool
+
+	spaces [3]*quicSpace
+
+	private     []byte
+	clientHello []byte
+	transcript  []byte
+
+	// handshakeSecret and clientHandshakeSecret are kept until the
+	// server's Finished.
+	handshakeSecret, clientHandshakeSecret, serverHandshakeSecret []byte
+
+	// established is true once the client's Finished has been sent, and
+	// confirmed once the server's HANDSHAKE_DONE has been received.
+	established, confirmed bool
+
+	// ptos is the number of times the handshake data has been sent again,
+	// and ptoDeadline is true while a read deadline is set to send it.
+	ptos        int
+	ptoDeadline bool
+
+	streams map[uint64]*quicStream
+
+	// settingsRead is true once the server's SETTINGS have been read.
+	settingsRead bool
+
+	// reset is the error code the server reset the request stream with.
+	reset *uint64
+}
+
+func newQUICConn(conn net.Conn, serverName string, result *HTTP3) (*quicConn, error) {
+	c := &quicConn{
+		conn:       conn,
+		serverName: serverName,
+		result:     result,
+		dcid:       make([]byte, quicConnectionIDLength),
+		scid:       make([]byte, quicConnectionIDLength),
+		streams:    make(map[uint64]*quicStream),
+	}
+	for i := range c.spaces {
+		c.spaces[i] = &quicSpace{largest: -1, received: make(map[uint64]bool), cryptoPackets: make(map[uint64]bool)}
+	}
+	for _, b := range [][]byte{c.dcid, c.scid} {
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+	}
+	var err error
+	c.spaces[quicSpaceInitial].send, c.spaces[quicSpaceInitial].receive, err = quicInitialKeys(c.dcid)
+	return c, err
+}
+
+// transportParameters returns the client's transport parameters.
+func (c *quicConn) transportParameters() []byte {
+	var ret []byte
+	integer := func(id, value uint64) {
+		ret = appendVarint(ret, id)
+		ret = appendVarint(ret, uint64(len(appendVarint(nil, value))))
+		ret = appendVarint(ret, value)
+	}
+	integer(0x01, 30000)
+	integer(0x04, quicFlowControlLimit)
+	integer(0x05, quicFlowControlLimit)
+	integer(0x06, quicFlowControlLimit)
+	integer(0x07, quicFlowControlLimit)
+	integer(0x09, 16)
+	ret = appendVarint(ret, 0x0f)
+	ret = appendVarint(ret, uint64(len(c.scid)))
+	return append(ret, c.scid...)
+}
+
+// newClientHello returns a ClientHello offering h3 and the client's
+// transport parameters.
+func (c *quicConn) newClientHello() ([]byte, error) {
+	var public [32]byte
+	c.private = make([]byte, 32)
+	random := make([]byte, 32)
+	for _, b := range [][]byte{c.private, random} {
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+	}
+	var private [32]byte
+	copy(private[:], c.private)
+	curve25519.ScalarBaseMult(&public, &private)
+	var extensions []byte
+	if c.serverName != "" {
+		extensions = append(extensions, zgrab2.TLSExtension(quicTLSExtensionServerName, zgrab2.TLSVector(2, []byte{0}, zgrab2.TLSVector(2, []byte(c.serverName))))...)
+	}
+	extensions = append(extensions, zgrab2.TLSExtension(quicTLSExtensionSupportedGroups, zgrab2.TLSVector(2, zgrab2.TLSUint16s(quicTLSGroupX25519)))...)
+	extensions = append(extensions, zgrab2.TLSExtension(quicTLSExtensionSignatureAlgorithms, zgrab2.TLSVector(2, zgrab2.TLSUint16s(quicTLSSignatureAlgorithms...)))...)
+	extensions = append(extensions, zgrab2.TLSExtension(quicTLSExtensionALPN, zgrab2.TLSVector(2, zgrab2.TLSVector(1, []byte("h3"))))...)
+	extensions = append(extensions, zgrab2.TLSExtension(quicTLSExtensionSupportedVersions, zgrab2.TLSVector(1, zgrab2.TLSUint16s(0x0304)))...)
+	extensions = append(extensions, zgrab2.TLSExtension(quicTLSExtensionKeyShare, zgrab2.TLSVector(2, zgrab2.TLSUint16s(quicTLSGroupX25519), zgrab2.TLSVector(2, public[:])))...)
+	extensions = append(extensions, zgrab2.TLSExtension(quicTLSExtensionTransportParameters, c.transportParameters())...)
+	body := bytes.Join([][]byte{
+		zgrab2.TLSUint16s(0x0303),
+		random,
+		{0},
+		zgrab2.TLSVector(2, zgrab2.TLSUint16s(quicTLSCipherSuite)),
+		{1, 0},
+		zgrab2.TLSVector(2, extensions),
+	}, nil)
+	return append([]byte{quicTLSClientHello}, zgrab2.TLSVector(3, body)...), nil
+}
+
+// send sends a datagram holding a single packet with the payload, in the
+// given packet number space.
+func (c *quicConn) send(space int, payload []byte) error {
+	s := c.spaces[space]
+	pn := s.nextPN
+	s.nextPN++
+	var header []byte
+	if space == quicSpaceApplication {
+		header = append([]byte{0x43}, c.dcid...)
+	} else {
+		typ := byte(quicPacketInitial)
+		if space == quicSpaceHandshake {
+			typ = quicPacketHandshake
+		}
+		header = []byte{0xc3 | typ<<4, 0, 0, 0, quicVersion1}
+		header = append(header, zgrab2.TLSVector(1, c.dcid)...)
+		header = append(header, zgrab2.TLSVector(1, c.scid)...)
+		if space == quicSpaceInitial {
+			header = appendVarint(header, uint64(len(c.token)))
+			header = append(header, c.token...)
+			// The length field takes 2 bytes.
+			if size := len(header) + 2 + 4 + len(payload) + 16; size < quicMinInitialSize {
+				payload = append(payload, make([]byte, quicMinInitialSize-size)...)
+			}
+		}
+		length := 4 + len(payload) + 16
+		header = append(header, 0x40|byte(length>>8), byte(length))
+	}
+	pnOffset := len(header)
+	header = append(header, byte(pn>>24), byte(pn>>16), byte(pn>>8), byte(pn))
+	_, err := c.conn.Write(s.send.seal(header, pnOffset, pn, payload))
+	return err
+}
+
+// cryptoFrame returns a CRYPTO frame with data at offset.
+func cryptoFrame(offset uint64, data []byte) []byte {
+	frame := appendVarint([]byte{quicFrameCrypto}, offset)
+	frame = appendVarint(frame, uint64(len(data)))
+	return append(frame, data...)
+}
+
+// sendCrypto sends the next data of the space's handshake messages in a
+// CRYPTO frame, after the frames in prefix, noting the packet so that the
+// data can be sent again until the server acknowledges it.
+func (c *quicConn) sendCrypto(space int, prefix, data []byte) error {
+	s := c.spaces[space]
+	s.cryptoPackets[s.nextPN] = true
+	frame := cryptoFrame(s.cryptoOffset, data)
+	s.cryptoOffset += uint64(len(data))
+	s.cryptoSent = append(s.cryptoSent, data...)
+	return c.send(space, append(prefix, frame...))
+}
+
+// unacknowledgedCrypto returns the spaces whose handshake data the server
+// has not acknowledged. The ClientHello needs no acknowledgment once the
+// server's Handshake packets arrive, and the Finished none once the server
+// confirms the handshake.
+func (c *quicConn) unacknowledgedCrypto() []int {
+	var ret []int
+	if s := c.spaces[quicSpaceInitial]; len(s.cryptoSent) > 0 && !s.cryptoAcked && c.spaces[quicSpaceHandshake].receive == nil {
+		ret = append(ret, quicSpaceInitial)
+	}
+	if s := c.spaces[quicSpaceHandshake]; len(s.cryptoSent) > 0 && !s.cryptoAcked && !c.confirmed {
+		ret = append(ret, quicSpaceHandshake)
+	}
+	return ret
+}
+
+// resendCrypto sends all of the handshake data of the spaces again, in new
+// packets.
+func (c *quicConn) resendCrypto(spaces []int) error {
+	for _, space := range spaces {
+		s := c.spaces[space]
+		s.cryptoPackets[s.nextPN] = true
+		if err := c.send(space, cryptoFrame(0, s.cryptoSent)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// handshake sends the ClientHello and reads packets until the handshake is
+// complete.
+func (c *quicConn) handshake() error {
+	var err error
+	if c.clientHello, err = c.newClientHello(); err != nil {
+		return err
+	}
+	c.transcript = append(c.transcript, c.clientHello...)
+	if err := c.sendCrypto(quicSpaceInitial, nil, c.clientHello); err != nil {
+		return err
+	}
+	for !c.established {
+		if err := c.readDatagram(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readDatagram reads and processes a datagram, then acknowledges the
+// packets it held. While the server has not acknowledged the client's
+// handshake data, it is sent again each time the wait for a datagram times
+// out.
+func (c *quicConn) readDatagram() error {
+	buf := make([]byte, 65536)
+	var n int
+	for {
+		pending := c.unacknowledgedCrypto()
+		retransmit := len(pending) > 0 && c.ptos < quicMaxPTOs
+		if retransmit || c.ptoDeadline {
+			var deadline time.Time
+			if retransmit {
+				deadline = time.Now().Add(quicInitialPTO << uint(c.ptos))
+			}
+			if err := c.conn.SetReadDeadline(deadline); err != nil {
+				return err
+			}
+			c.ptoDeadline = retransmit
+		}
+		var err error
+		if n, err = c.conn.Read(buf); err == nil {
+			break
+		}
+		if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() || !retransmit {
+			return err
+		}
+		c.ptos++
+		c.result.Retransmissions++
+		if err := c.resendCrypto(pending); err != nil {
+			return err
+		}
+	}
+	if err := c.processDatagram(buf[:n]); err != nil {
+		return err
+	}
+	for _, space := range []int{quicSpaceHandshake, quicSpaceApplication} {
+		s := c.spaces[space]
+		if s.ackPending && s.send != nil {
+			s.ackPending = false
+			if err := c.send(space, s.ackFrame()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// processDatagram processes the packets coalesced in a datagram, skipping
+// those that cannot be decrypted.
+func (c *quicConn) processDatagram(data []byte) error {
+	for len(data) > 0 {
+		if data[0]&0x80 == 0 {
+			s := c.spaces[quicSpaceApplication]
+			if s.receive == nil {
+				return nil
+			}
+			return c.processPacket(quicSpaceApplication, data, 1+len(c.scid), nil)
+		}
+		r := newWireReader(data[1:])
+		version := binary.BigEndian.Uint32(r.bytes(4))
+		dcid := r.vector(1)
+		scid := r.vector(1).data
+		if !r.ok || !dcid.ok {
+			return nil
+		}
+		if version == 0 {
+			return c.versionNegotiation(r.data)
+		}
+		if version != quicVersion1 {
+			return nil
+		}
+		typ := data[0] >> 4 & 0x3
+		if typ == quicPacketRetry {
+			return c.retry(data, scid, r.data)
+		}
+		if typ == quicPacketInitial {
+			r.varintBytes()
+		}
+		length := r.varint()
+		if !r.ok || length > uint64(len(r.data)) {
+			return nil
+		}
+		pnOffset := len(data) - len(r.data)
+		packet := data[:pnOffset+int(length)]
+		data = data[len(packet):]
+		space := -1
+		switch typ {
+		case quicPacketInitial:
+			space = quicSpaceInitial
+		case quicPacketHandshake:
+			space = quicSpaceHandshake
+		}
+		if space < 0 || c.spaces[space].receive == nil {
+			continue
+		}
+		if err := c.processPacket(space, packet, pnOffset, scid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// processPacket decrypts a packet and processes its frames. The first
+// packet decrypted gives the server's connection ID, scid.
+func (c *quicConn) processPacket(space int, packet []byte, pnOffset int, scid []byte) error {
+	s := c.spaces[space]
+	pn, payload, err := s.receive.open(packet, pnOffset, s.largest)
+	if err != nil {
+		return nil
+	}
+	if int64(pn) > s.largest {
+		s.largest = int64(pn)
+	}
+	s.received[pn] = true
+	if scid != nil && !c.serverCID {
+		c.dcid, c.serverCID = append([]byte(nil), scid...), true
+	}
+	return c.processFrames(space, payload)
+}
+
+// versionNegotiation records the versions listed in a Version Negotiation
+// packet.
+func (c *quicConn) versionNegotiation(data []byte) error {
+	c.result.SupportedVersions = nil
+	for ; len(data) >= 4; data = data[4:] {
+		version := binary.BigEndian.Uint32(data)
+		if version == quicVersion1 {
+			return nil
+		}
+		c.result.SupportedVersions = append(c.result.SupportedVersions, quicVersionName(version))
+	}
+	return errors.New("server does not support QUIC version 1")
+}
+
+// retry answers a Retry packet by sending the ClientHello again, with the
+// server's token, to the connection ID it chose. data is what follows the
+// header of the Retry packet: the token and the integrity tag. Retry packets
+// whose integrity tag does not match are discarded.
+func (c *quicConn) retry(packet, scid, data []byte) error {
+	if c.result.Retry || c.serverCID || len(data) <= 16 {
+		return nil
+	}
+	tag, err := retryIntegrityTag(c.dcid, packet[:len(packet)-16])
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal(tag, packet[len(packet)-16:]) {
+		return nil
+	}
+	c.result.Retry = true
+	c.dcid = append([]byte(nil), scid...)
+	c.token = append([]byte(nil), data[:len(data)-16]...)
+	s := c.spaces[quicSpaceInitial]
+	if s.send, s.receive, err = quicInitialKeys(c.dcid); err != nil {
+		return err
+	}
+	s.cryptoOffset, s.cryptoSent, s.cryptoAcked = 0, nil, false
+	s.cryptoPackets = make(map[uint64]bool)
+	return c.sendCrypto(quicSpaceInitial, nil, c.clientHello)
+}
+
+// processFrames processes the frames in a packet's payload.
+func (c *quicConn) processFrames(space int, payload []byte) error {
+	s := c.spaces[space]
+	r := newWireReader(payload)
+	for r.ok && len(r.data) > 0 {
+		typ := r.varint()
+		switch typ {
+		case quicFramePadding, quicFrameAck, quicFrameAckECN:
+		default:
+			s.ackPending = true
+		}
+		switch typ {
+		case quicFramePadding, quicFramePing:
+		case quicFrameHandshakeDone:
+			c.confirmed = true
+		case quicFrameAck, quicFrameAckECN:
+			largest := r.varint()
+			r.varint()
+			ranges := r.varint()
+			first := r.varint()
+			if first > largest {
+				r.ok = false
+			}
+			smallest := largest - first
+			if r.ok {
+				s.acknowledge(smallest, largest)
+			}
+			for i := uint64(0); i < ranges && r.ok; i++ {
+				gap, length := r.varint(), r.varint()
+				if gap+2+length > smallest {
+					r.ok = false
+					break
+				}
+				largest = smallest - gap - 2
+				smallest = largest - length
+				s.acknowledge(smallest, largest)
+			}
+			if typ == quicFrameAckECN {
+				r.varint()
+				r.varint()
+				r.varint()
+			}
+		case quicFrameResetStream:
+			id, code := r.varint(), r.varint()
+			r.varint()
+			if r.ok && id == 0 {
+				c.reset = &code
+			}
+		case quicFrameStopSending, quicFrameMaxStreamData, quicFrameStreamBlocked:
+			r.varint()
+			r.varint()
+		case quicFrameCrypto:
+			offset := r.varint()
+			data := r.varintBytes()
+			if !r.ok {
+				break
+			}
+			s.crypto.add(offset, data, false)
+			if err := c.readHandshakeMessages(space); err != nil {
+				return err
+			}
+		case quicFrameNewToken:
+			r.varintBytes()
+		case quicFrameStream, quicFrameStream | 0x1, quicFrameStream | 0x2, quicFrameStream | 0x3,
+			quicFrameStream | 0x4, quicFrameStream | 0x5, quicFrameStream | 0x6, quicFrameStream | 0x7:
+			id := r.varint()
+			var offset uint64
+			if typ&quicStreamOffset != 0 {
+				offset = r.varint()
+			}
+			var data []byte
+			if typ&quicStreamLength != 0 {
+				data = r.varintBytes()
+			} else {
+				data, r.data = r.data, nil
+			}
+			if r.ok && space == quicSpaceApplication {
+				c.stream(id).add(offset, data, typ&quicStreamFin != 0)
+				if id&0x3 == 0x3 {
+					c.readControlStream(c.streams[id])
+				}
+			}
+		case quicFrameMaxData, quicFrameMaxStreamsBidi, quicFrameMaxStreamsUni, quicFrameDataBlocked,
+			quicFrameStreamsBlocked, quicFrameStreamsBlockedU, quicFrameRetireConnID:
+			r.varint()
+		case quicFrameNewConnectionID:
+			r.varint()
+			r.varint()
+			r.vector(1)
+			r.bytes(16)
+		case quicFramePathChallenge, quicFramePathResponse:
+			r.bytes(8)
+		case quicFrameConnectionClose, quicFrameApplicationCl:
+			closed := &QUICConnectionClose{Application: typ == quicFrameApplicationCl}
+			code := r.varint()
+			if typ == quicFrameConnectionClose {
+				r.varint()
+				closed.ErrorCode = quicTransportErrorName(code)
+			} else {
+				closed.ErrorCode = http3ErrorName(code)
+			}
+			closed.Reason = string(r.varintBytes())
+			c.result.ConnectionClose = closed
+			return fmt.Errorf("server closed the connection with %s", closed.ErrorCode)
+		case quicFrameDatagram:
+			r.data = nil
+		case quicFrameDatagramLength:
+			r.varintBytes()
+		default:
+			return fmt.Errorf("unknown QUIC frame type 0x%x", typ)
+		}
+	}
+	if !r.ok {
+		return errors.New("malformed QUIC frame")
+	}
+	return nil
+}
+
+// stream returns the stream with the given ID.
+func (c *quicConn) stream(id uint64) *quicStream {
+	s, ok := c.streams[id]
+	if !ok {
+		s = new(quicStream)
+		c.streams[id] = s
+	}
+	return s
+}
+
+// readHandshakeMessages handles the complete handshake messages received
+// in the space.
+func (c *quicConn) readHandshakeMessages(space int) error {
+	s := c.spaces[space]
+	for {
+		data := s.crypto.data[s.cryptoRead:]
+		if len(data) < 4 {
+			return nil
+		}
+		length := int(data[1])<<16 | int(data[2])<<8 | int(data[3])
+		if length += 4; len(data) < length {
+			return nil
+		}
+		s.cryptoRead += length
+		if space == quicSpaceApplication {
+			// NewSessionTickets are not used.
+			continue
+		}
+		if err := c.handleHandshakeMessage(data[:length]); err != nil {
+			return err
+		}
+	}
+}
+
+// handleHandshakeMessage handles the server's ServerHello, deriving the
+// handshake keys, its EncryptedExtensions, which hold the ALPN protocol and
+// transport parameters, and its Finished, which completes the handshake.
+// Its certificate is not verified.
+func (c *quicConn) handleHandshakeMessage(msg []byte) error {
+	switch msg[0] {
+	case quicTLSServerHello:
+		c.transcript = append(c.transcript, msg...)
+		return c.handleServerHello(msg[4:])
+	case quicTLSEncryptedExtensions:
+		c.transcript = append(c.transcript, msg...)
+		extensions := newWireReader(msg[4:]).vector(2)
+		for extensions.ok && len(extensions.data) > 0 {
+			typ := extensions.uint16()
+			body := extensions.vector(2)
+			switch typ {
+			case quicTLSExtensionALPN:
+				c.result.NegotiatedProtocol = string(body.vector(2).vector(1).data)
+			case quicTLSExtensionTransportParameters:
+				c.result.TransportParameters = parseQUICTransportParameters(body.data)
+			}
+		}
+		if !extensions.ok {
+			return errors.New("malformed EncryptedExtensions")
+		}
+		if c.result.NegotiatedProtocol != "h3" {
+			return fmt.Errorf("server did not select h3 with ALPN")
+		}
+	case quicTLSFinished:
+		finishedKey := zgrab2.TLS13ExpandLabel(c.serverHandshakeSecret, "finished", nil, sha256.Size)
+		mac := hmac.New(sha256.New, finishedKey)
+		mac.Write(zgrab2.TLSTranscriptHash(c.transcript))
+		if !hmac.Equal(mac.Sum(nil), msg[4:]) {
+			return errors.New("server Finished is invalid")
+		}
+		c.transcript = append(c.transcript, msg...)
+		return c.finish()
+	default:
+		c.transcript = append(c.transcript, msg...)
+	}
+	return nil
+}
+
+func (c *quicConn) handleServerHello(body []byte) error {
+	r := newWireReader(body)
+	r.uint16()
+	random := r.bytes(32)
+	r.vector(1)
+	suite := r.uint16()
+	r.uint8()
+	extensions := r.vector(2)
+	if !r.ok {
+		return errors.New("malformed ServerHello")
+	}
+	if bytes.Equal(random, quicHelloRetryRandom) {
+		return errors.New("server sent a HelloRetryRequest")
+	}
+	if suite != quicTLSCipherSuite {
+		return fmt.Errorf("server selected unsupported cipher suite 0x%04x", suite)
+	}
+	var share []byte
+	for extensions.ok && len(extensions.data) > 0 {
+		typ := extensions.uint16()
+		ext := extensions.vector(2)
+		if typ == quicTLSExtensionKeyShare && ext.uint16() == quicTLSGroupX25519 {
+			share = ext.vector(2).data
+		}
+	}
+	if len(share) != 32 {
+		return errors.New("server sent no X25519 key share")
+	}
+	var private, public, shared [32]byte
+	copy(private[:], c.private)
+	copy(public[:], share)
+	curve25519.ScalarMult(&shared, &private, &public)
+	early := hkdf.Extract(sha256.New, make([]byte, sha256.Size), nil)
+	derived := zgrab2.TLS13ExpandLabel(early, "derived", zgrab2.TLSTranscriptHash(nil), sha256.Size)
+	c.handshakeSecret = hkdf.Extract(sha256.New, shared[:], derived)
+	hash := zgrab2.TLSTranscriptHash(c.transcript)
+	c.clientHandshakeSecret = zgrab2.TLS13ExpandLabel(c.handshakeSecret, "c hs traffic", hash, sha256.Size)
+	c.serverHandshakeSecret = zgrab2.TLS13ExpandLabel(c.handshakeSecret, "s hs traffic", hash, sha256.Size)
+	s := c.spaces[quicSpaceHandshake]
+	var err error
+	if s.send, err = newQUICKeys(c.clientHandshakeSecret); err != nil {
+		return err
+	}
+	s.receive, err = newQUICKeys(c.serverHandshakeSecret)
+	c.result.QUICVersion = quicVersionName(quicVersion1)
+	return err
+}
+
+// finish derives the application keys and sends the client's Finished.
+func (c *quicConn) finish() error {
+	hash := zgrab2.TLSTranscriptHash(c.transcript)
+	derived := zgrab2.TLS13ExpandLabel(c.handshakeSecret, "derived", zgrab2.TLSTranscriptHash(nil), sha256.Size)
+	master := hkdf.Extract(sha256.New, make([]byte, sha256.Size), derived)
+	s := c.spaces[quicSpaceApplication]
+	var err error
+	if s.send, err = newQUICKeys(zgrab2.TLS13ExpandLabel(master, "c ap traffic", hash, sha256.Size)); err != nil {
+		return err
+	}
+	if s.receive, err = newQUICKeys(zgrab2.TLS13ExpandLabel(master, "s ap traffic", hash, sha256.Size)); err != nil {
+		return err
+	}
+	mac := hmac.New(sha256.New, zgrab2.TLS13ExpandLabel(c.clientHandshakeSecret, "finished", nil, sha256.Size))
+	mac.Write(hash)
+	finished := append([]byte{quicTLSFinished}, zgrab2.TLSVector(3, mac.Sum(nil))...)
+	handshake := c.spaces[quicSpaceHandshake]
+	ack := handshake.ackFrame()
+	handshake.ackPending = false
+	if err := c.sendCrypto(quicSpaceHandshake, ack, finished); err != nil {
+		return err
+	}
+	c.established = true
+	return nil
+}
+
+// streamFrame returns a STREAM frame with data at offset 0.
+func streamFrame(id uint64, data []byte, fin bool) []byte {
+	typ := byte(quicFrameStream | quicStreamLength)
+	if fin {
+		typ |= quicStreamFin
+	}
+	frame := appendVarint([]byte{typ}, id)
+	frame = appendVarint(frame, uint64(len(data)))
+	return append(frame, data...)
+}
+
+// close sends an application CONNECTION_CLOSE with H3_NO_ERROR.
+func (c *quicConn) close() error {
+	frame := appendVarint([]byte{quicFrameApplicationCl}, http3NoError)
+	return c.send(quicSpaceApplication, append(frame, 0))
+}
+
+// parseQUICTransportParameters parses the transport parameters from the
+// server's quic_transport_parameters extension.
+func parseQUICTransportParameters(data []byte) []QUICTransportParameter {
+	var ret []QUICTransportParameter
+	r := newWireReader(data)
+	for r.ok && len(r.data) > 0 {
+		id := r.varint()
+		value := r.varintBytes()
+		if !r.ok {
+			break
+		}
+		param := QUICTransportParameter{Name: quicTransportParameterNames[id], ID: id}
+		v := newWireReader(value)
+		if n := v.varint(); quicIntegerParameters[id] && v.ok && len(v.data) == 0 {
+			param.Value = &n
+		} else if len(value) > 0 {
+			param.Raw = value
+		}
+		ret = append(ret, param)
+	}
+	return ret
+}
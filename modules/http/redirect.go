@@ -0,0 +1,103 @@
+package http
+
+import (
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/zmap/zgrab2/lib/http"
+)
+
+// Reasons a redirect was not followed, given in RedirectHop.Blocked.
+const (
+	redirectBlockedLocalhost    = "localhost"
+	redirectBlockedMaxRedirects = "max-redirects"
+	redirectBlockedCrossHost    = "cross-host"
+	redirectBlockedCrossScheme  = "cross-scheme"
+	redirectBlockedCrossPort    = "cross-port"
+)
+
+// RedirectHop is a response in a redirect chain.
+type RedirectHop struct {
+	URL        string      `json:"url"`
+	StatusCode int         `json:"status_code"`
+	Headers    http.Header `json:"headers,omitempty"`
+
+	// IP and Port are those of the server the request was sent to.
+	IP   string `json:"ip,omitempty"`
+	Port int    `json:"port,omitempty"`
+
+	// Location is the URL the response redirects to, resolved against URL,
+	// and CrossHost, CrossScheme and CrossPort tell how it differs from URL.
+	Location    string `json:"location,omitempty"`
+	CrossHost   bool   `json:"cross_host,omitempty"`
+	CrossScheme bool   `json:"cross_scheme,omitempty"`
+	CrossPort   bool   `json:"cross_port,omitempty"`
+
+	// Followed is true if the redirect was followed, and Blocked otherwise
+	// tells why not: localhost, max-redirects, or cross-host, cross-scheme
+	// or cross-port for the --no-cross-*-redirects policies.
+	Followed bool   `json:"followed,omitempty"`
+	Blocked  string `json:"blocked,omitempty"`
+
+	response *http.Response
+}
+
+// urlPort returns the port of the URL, or its scheme's default port.
+func urlPort(u *url.URL) string {
+	if port := u.Port(); port != "" {
+		return port
+	}
+	return strconv.Itoa(int(protoToPort[u.Scheme]))
+}
+
+// newRedirectHop records the response, redirecting to next if it is not
+// nil.
+func newRedirectHop(res *http.Response, next *url.URL) *RedirectHop {
+	ret := &RedirectHop{
+		StatusCode: res.StatusCode,
+		Headers:    res.Header,
+		response:   res,
+	}
+	if tcp, ok := res.RemoteAddr.(*net.TCPAddr); ok {
+		ret.IP, ret.Port = tcp.IP.String(), tcp.Port
+	}
+	if res.Request == nil || res.Request.URL == nil {
+		return ret
+	}
+	u := res.Request.URL
+	ret.URL = u.String()
+	if next != nil {
+		ret.Location = next.String()
+		ret.CrossHost = !strings.EqualFold(u.Hostname(), next.Hostname())
+		ret.CrossScheme = u.Scheme != next.Scheme
+		ret.CrossPort = urlPort(u) != urlPort(next)
+	}
+	return ret
+}
+
+// blockedBy returns the policy blocking the redirect, or "" if it may be
+// followed.
+func (scan *scan) blockedBy(hop *RedirectHop) string {
+	config := scan.scanner.config
+	switch {
+	case hop.CrossHost && config.NoCrossHostRedirects:
+		return redirectBlockedCrossHost
+	case hop.CrossScheme && config.NoCrossSchemeRedirects:
+		return redirectBlockedCrossScheme
+	case hop.CrossPort && config.NoCrossPortRedirects:
+		return redirectBlockedCrossPort
+	}
+	return ""
+}
+
+// finishRedirects records the final response of a redirect chain, unless
+// it is the last hop already recorded.
+func (scan *scan) finishRedirects(resp *http.Response) {
+	hops := scan.results.Redirects
+	if len(hops) == 0 || resp == nil || hops[len(hops)-1].response == resp {
+		return
+	}
+	scan.results.Redirects = append(hops, newRedirectHop(resp, nil))
+}
@@ -0,0 +1,78 @@
+package http
+
+import (
+	"net"
+	nethttp "net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/zmap/zgrab2"
+)
+
+func TestRedirects(t *testing.T) {
+	other := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		w.Write([]byte("final"))
+	}))
+	defer other.Close()
+	otherPort := other.Listener.Addr().(*net.TCPAddr).Port
+	server := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		switch r.URL.Path {
+		case "/":
+			nethttp.Redirect(w, r, "/moved", nethttp.StatusMovedPermanently)
+		case "/moved":
+			nethttp.Redirect(w, r, other.URL+"/elsewhere", nethttp.StatusFound)
+		default:
+			w.Write([]byte("here"))
+		}
+	}))
+	defer server.Close()
+	port := server.Listener.Addr().(*net.TCPAddr).Port
+
+	flags := &Flags{Method: "GET", Endpoint: "/", UserAgent: "zgrab2 test", MaxSize: 256, MaxRedirects: 5, FollowLocalhostRedirects: true}
+	flags.Port = uint(port)
+	flags.Timeout = 2 * time.Second
+	var scanner Scanner
+	scan := func() *Results {
+		if err := scanner.Init(flags); err != nil {
+			t.Fatal(err)
+		}
+		status, ret, err := scanner.Scan(zgrab2.ScanTarget{IP: net.ParseIP("127.0.0.1")})
+		if status != zgrab2.SCAN_SUCCESS {
+			t.Fatalf("scan failed: %s %v", status, err)
+		}
+		return ret.(*Results)
+	}
+
+	results := scan()
+	hops := results.Redirects
+	if len(hops) != 3 || len(results.RedirectResponseChain) != 2 || results.Response.BodyText != "final" {
+		t.Fatalf("wrong redirects %+v", hops)
+	}
+	base := "http://127.0.0.1:" + strconv.Itoa(port)
+	if hop := hops[0]; hop.URL != base+"/" || hop.StatusCode != 301 || hop.Location != base+"/moved" || !hop.Followed || hop.CrossHost || hop.CrossPort || hop.IP != "127.0.0.1" || hop.Port != port || hop.Headers.Get("Location") != "/moved" {
+		t.Errorf("wrong first hop %+v", hop)
+	}
+	if hop := hops[1]; hop.StatusCode != 302 || hop.Location != other.URL+"/elsewhere" || !hop.Followed || hop.CrossHost || hop.CrossScheme || !hop.CrossPort {
+		t.Errorf("wrong second hop %+v", hop)
+	}
+	if hop := hops[2]; hop.URL != other.URL+"/elsewhere" || hop.StatusCode != 200 || hop.Location != "" || hop.Followed || hop.Blocked != "" || hop.Port != otherPort {
+		t.Errorf("wrong final hop %+v", hop)
+	}
+
+	flags.NoCrossPortRedirects = true
+	results = scan()
+	hops = results.Redirects
+	if len(hops) != 2 || len(results.RedirectResponseChain) != 1 || results.Response.StatusCode != 302 {
+		t.Fatalf("wrong redirects %+v", hops)
+	}
+	if hop := hops[1]; hop.Followed || hop.Blocked != "cross-port" || hop.response != results.Response {
+		t.Errorf("wrong blocked hop %+v", hop)
+	}
+
+	flags.Endpoint = "/here"
+	if results := scan(); results.Redirects != nil {
+		t.Errorf("hops recorded without redirects: %+v", results.Redirects)
+	}
+}
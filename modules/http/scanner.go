@@ -4,22 +4,41 @@
 // specified Path (e.g. "/"). If UseHTTPS is true, the scanner uses TLS for the
 // initial request. The Result contains the final HTTP response following each
 // response in the redirect chain.
+//
+// With --all-default-ports, targets without a port are scanned on ports 80,
+// 8080, 8000 and 443 (the last with TLS), or on the ports given with
+// --default-ports, instead of only on --port.
+//
+// With --lite, for sweeps of very many endpoints, only the start of each body
+// is read, and the Result is a small summary of the final response.
+//
+// With --vhosts or --vhosts-file, the first request is sent again to the
+// target for each of a list of host names, e.g. to enumerate the sites of a
+// shared host or CDN origin, recording a summary of each response.
+//
+// The --endpoint, --custom-header values and request bodies, and the paths,
+// headers and bodies of the --sequence-file requests, may use the template
+// variables {target_ip}, {target_host}, {port} and {sni}, expanded for each
+// target, e.g. to sweep Host headers or paths without a config per target.
 package http
 
 import (
 	"bytes"
 	"context"
-	"crypto/sha256"
 	"errors"
+	"fmt"
 	"io"
 	"net"
 	"net/url"
+	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/zmap/zgrab2"
 	"github.com/zmap/zgrab2/lib/http"
+	"github.com/zmap/zgrab2/lib/http/cookiejar"
 )
 
 var (
@@ -34,8 +53,6 @@ var (
 
 // Flags holds the command-line configuration for the HTTP scan module.
 // Populated by the framework.
-//
-// TODO: Custom headers?
 type Flags struct {
 	zgrab2.BaseFlags
 	zgrab2.TLSFlags
@@ -46,13 +63,95 @@ type Flags struct {
 	MaxSize      int    `long:"max-size" default:"256" description:"Max kilobytes to read in response to an HTTP request"`
 	MaxRedirects int    `long:"max-redirects" default:"0" description:"Max number of redirects to follow"`
 
+	// AcceptEncoding is sent with each request, and the bodies of responses
+	// in any of gzip, deflate, br and zstd are decoded.
+	AcceptEncoding string `long:"accept-encoding" default:"gzip, br, zstd" description:"Accept-Encoding to send; bodies encoded with gzip, deflate, br or zstd are decoded, up to --max-size, recording their encoded and decoded lengths. If empty, only gzip is requested"`
+
+	// RequestBody or RequestBodyFile give a body to send with the request,
+	// with the ContentType if one is given.
+	RequestBody     string `long:"request-body" description:"Send this body with the request (e.g. with --method=POST)"`
+	RequestBodyFile string `long:"request-body-file" description:"Send the contents of this file as the body of the request"`
+	ContentType     string `long:"content-type" description:"Content-Type header to send with the request body"`
+
+	// CustomHeaders are sent with the first request, as "Name: value".
+	CustomHeaders []string `long:"custom-header" description:"Header to send with the first request, as 'Name: value' (may be repeated); a Host header sets the Host requested. The endpoint, headers and bodies may use {target_ip}, {target_host}, {port} and {sni}, expanded for each target"`
+
+	// SequenceFile lists requests to send after the first.
+	SequenceFile string `long:"sequence-file" description:"YAML or JSON file listing requests (name, method, path, headers, and body or body_file) to send in order after the first, to the final URL's origin on the same connection where the server keeps it alive, recording each response"`
+
+	// CookieJar keeps the cookies responses set, and records them.
+	CookieJar bool `long:"cookie-jar" description:"Keep the cookies responses set in a jar, sending them with redirects and --sequence-file requests, and record the cookies sent with each request and the Set-Cookie headers of its response, parsed"`
+
+	// HTTPAuth answers a 401 final response with the given scheme, using
+	// the Auth credentials if they are given.
+	HTTPAuth     string `long:"http-auth" choice:"basic" choice:"digest" choice:"ntlm" choice:"negotiate" description:"If the final response is a 401, authenticate with this scheme (basic, digest, ntlm or negotiate) and record the response; with ntlm or negotiate, the server's NTLM challenge is decoded even without credentials"`
+	AuthUsername string `long:"auth-username" description:"Username to authenticate with, with --http-auth"`
+	AuthPassword string `long:"auth-password" description:"Password to authenticate with, with --http-auth. WARNING: with basic, this is sent in the clear."`
+	AuthDomain   string `long:"auth-domain" description:"Domain to authenticate to, with --http-auth=ntlm or negotiate"`
+
 	// FollowLocalhostRedirects overrides the default behavior to return
 	// ErrRedirLocalhost whenever a redirect points to localhost.
 	FollowLocalhostRedirects bool `long:"follow-localhost-redirects" description:"Follow HTTP redirects to localhost"`
 
+	// NoCrossHostRedirects, NoCrossSchemeRedirects and NoCrossPortRedirects
+	// stop at redirects to another host, scheme or port, leaving the
+	// redirect as the final response.
+	NoCrossHostRedirects   bool `long:"no-cross-host-redirects" description:"Do not follow redirects to another host; the redirect is the final response"`
+	NoCrossSchemeRedirects bool `long:"no-cross-scheme-redirects" description:"Do not follow redirects to another scheme (e.g. from http to https); the redirect is the final response"`
+	NoCrossPortRedirects   bool `long:"no-cross-port-redirects" description:"Do not follow redirects to another port, the schemes' default ports filled in; the redirect is the final response"`
+
 	// UseHTTPS causes the first request to be over TLS, without requiring a
 	// redirect to HTTPS. It does not change the port used for the connection.
 	UseHTTPS bool `long:"use-https" description:"Perform an HTTPS connection on the initial host"`
+
+	// RetryAltSvc connects to the alternative services advertised in the
+	// final response's Alt-Svc header.
+	RetryAltSvc bool `long:"retry-alt-svc" description:"Connect to each alternative service the final response advertises with Alt-Svc, and report whether it is reachable: with a QUIC Version Negotiation probe for HTTP/3, and a TLS handshake offering its protocol otherwise"`
+
+	// ProbeEncodings requests the final URL again with each of
+	// ProbeEncodingList as the Accept-Encoding.
+	ProbeEncodings    bool   `long:"probe-encodings" description:"Request the final URL again once for each of --probe-encoding-list as the Accept-Encoding, and report the encodings the server uses and whether the content differs"`
+	ProbeEncodingList string `long:"probe-encoding-list" default:"identity,gzip,deflate,br,zstd" description:"Comma-separated Accept-Encoding values to send with --probe-encodings"`
+
+	// HTTP2 requests the final URL again over HTTP/2.
+	HTTP2        bool `long:"http2" description:"Request the final URL again over HTTP/2, offering h2 with ALPN for https URLs, and report the server's SETTINGS, pushes, header compression and response"`
+	HTTP2Upgrade bool `long:"http2-upgrade" description:"With --http2, also offer HTTP/2 for http URLs, with an h2c Upgrade header"`
+
+	// HTTP3 requests the final URL again over HTTP/3.
+	HTTP3 bool `long:"http3" description:"Request an https final URL again over HTTP/3, at the h3 alternative its response advertises with Alt-Svc or else at its host and port over UDP, and report the QUIC version, transport parameters, SETTINGS and response"`
+
+	// WebSocket opens a WebSocket to this path on the final URL's origin.
+	WebSocket          string        `long:"websocket" description:"Open a WebSocket to this path (e.g. /ws) on the final URL's origin with an HTTP Upgrade handshake, and record the server's response, subprotocol and extensions. The path may use the template variables"`
+	WebSocketProtocols string        `long:"websocket-protocols" description:"Comma-separated subprotocols to offer with --websocket"`
+	WebSocketOrigin    string        `long:"websocket-origin" description:"Origin to send with --websocket, instead of the final URL's origin"`
+	WebSocketFrames    int           `long:"websocket-frames" default:"0" description:"Number of frames the server sends first to record with --websocket, up to --max-size kilobytes each"`
+	WebSocketWait      time.Duration `long:"websocket-wait" default:"2s" description:"How long to wait for the frames of --websocket-frames"`
+
+	// WithFavicon fetches and hashes the site's icon.
+	WithFavicon bool `long:"with-favicon" description:"Fetch the icon the final page links to, or else /favicon.ico, and report its MD5 and the MurmurHash3 Shodan's favicon fingerprints use"`
+
+	// WithRobotsTxt, WithSecurityTxt and FetchPaths fetch files from the
+	// final URL's origin.
+	WithRobotsTxt   bool     `long:"with-robots-txt" description:"Fetch /robots.txt from the final URL's origin, and record it with its groups and sitemaps parsed"`
+	WithSecurityTxt bool     `long:"with-security-txt" description:"Fetch /.well-known/security.txt from the final URL's origin, and record it with its fields parsed"`
+	FetchPaths      []string `long:"fetch-path" description:"Path to fetch from the final URL's origin and record (may be repeated). The path may use the template variables"`
+
+	// VHosts and VHostsFile name the hosts the first request is sent again
+	// for.
+	VHosts     string `long:"vhosts" description:"Comma-separated host names to send the first request again for, each on its own connection to the target with the name as the Host header and in the SNI extension, recording the status, title and main headers of each response without following redirects"`
+	VHostsFile string `long:"vhosts-file" description:"File of host names, one per line, to send the first request again for as with --vhosts"`
+
+	// TechnologiesFile holds the rules that Technologies are detected
+	// with.
+	TechnologiesFile string `long:"technologies-file" description:"Wappalyzer-style JSON file of technologies (name: {headers, cookies, meta, html, scriptSrc, url, implies, cats, cpe}) to detect in the final response, with their versions"`
+
+	// BodyStoreDir is where bodies are written instead of being inlined.
+	BodyStoreDir string `long:"body-store-dir" description:"Write each response body to a file in this directory named by its SHA-256 digest (e.g. 3f/3fa2...), and record the file as body_file instead of inlining the body"`
+
+	// Lite reads only the start of each body, and gives a LiteResult
+	// instead of the full responses.
+	Lite         bool `long:"lite" description:"Read only the first --lite-body-size kilobytes of each body, and give a small record of the final response's status, main headers, title and icon, and the certificate's fingerprint, instead of the full responses and TLS log"`
+	LiteBodySize int  `long:"lite-body-size" default:"16" description:"Kilobytes of each body to read with --lite"`
 }
 
 // A Results object is returned by the HTTP module's Scanner.Scan()
@@ -64,6 +163,62 @@ type Results struct {
 	// RedirectResponseChain is non-empty is the scanner follows a redirect.
 	// It contains all redirect response prior to the final response.
 	RedirectResponseChain []*http.Response `json:"redirect_response_chain,omitempty"`
+
+	// Redirects are the hops of the redirect chain, if the first response
+	// was a redirect: each response, with the address it came from and
+	// whether its redirect was followed, ending with the final response.
+	Redirects []*RedirectHop `json:"redirects,omitempty"`
+
+	// Cookies are the cookies sent with each request and set by its
+	// response, in order, with --cookie-jar.
+	Cookies []*CookieExchange `json:"cookies,omitempty"`
+
+	// Auth is the outcome of --http-auth, if the final response is a 401.
+	Auth *Auth `json:"auth,omitempty"`
+
+	// HTML summarizes the final response's body, if it is an HTML page.
+	HTML *HTMLMetadata `json:"html,omitempty"`
+
+	// AltSvc are the alternative services advertised in the final
+	// response's Alt-Svc header.
+	AltSvc []*AltSvc `json:"alt_svc,omitempty"`
+
+	// Encodings compares the responses to requests with different
+	// Accept-Encoding values, with --probe-encodings.
+	Encodings *Encodings `json:"encodings,omitempty"`
+
+	// HTTP2 is the response to the final URL over HTTP/2, with --http2.
+	HTTP2 *HTTP2 `json:"http2,omitempty"`
+
+	// HTTP3 is the response to the final URL over HTTP/3, with --http3.
+	HTTP3 *HTTP3 `json:"http3,omitempty"`
+
+	// WebSocket is the outcome of the WebSocket handshake, with
+	// --websocket.
+	WebSocket *WebSocket `json:"websocket,omitempty"`
+
+	// Technologies are the technologies detected in the final response,
+	// with --technologies-file.
+	Technologies []*Technology `json:"technologies,omitempty"`
+
+	// Favicon is the site's icon, with --with-favicon.
+	Favicon *Favicon `json:"favicon,omitempty"`
+
+	// Files are the files fetched with --with-robots-txt,
+	// --with-security-txt and --fetch-path, in that order.
+	Files []*FetchedFile `json:"files,omitempty"`
+
+	// Sequence are the responses to the requests in the --sequence-file,
+	// in order.
+	Sequence []*SequenceResponse `json:"sequence,omitempty"`
+
+	// VHosts are the responses to the first request sent again for each of
+	// the --vhosts, in order.
+	VHosts []*VHost `json:"vhosts,omitempty"`
+
+	// Lite summarizes the final response, with --lite, in place of the
+	// other fields but VHosts.
+	Lite *LiteResult `json:"lite,omitempty"`
 }
 
 // Module is an implementation of the zgrab2.Module interface.
@@ -73,6 +228,21 @@ type Module struct {
 // Scanner is the implementation of the zgrab2.Scanner interface.
 type Scanner struct {
 	config *Flags
+
+	// ports are the ports to scan on targets that do not specify one, with
+	// --all-default-ports.
+	ports []zgrab2.DefaultPort
+
+	// body is the body sent with the first request, and sequence the
+	// requests sent after it.
+	body     []byte
+	sequence []*SequenceRequest
+
+	// technologies are the rules of the --technologies-file.
+	technologies []*technology
+
+	// vhosts are the --vhosts and the names in the --vhosts-file.
+	vhosts []string
 }
 
 // scan holds the state for a single scan. This may entail multiple connections.
@@ -86,6 +256,9 @@ type scan struct {
 	results        Results
 	url            string
 	globalDeadline time.Time
+
+	// templates expands the template variables for the target.
+	templates *strings.Replacer
 }
 
 // NewFlags returns an empty Flags object.
@@ -100,6 +273,43 @@ func (module *Module) NewScanner() zgrab2.Scanner {
 
 // Validate performs any needed validation on the arguments
 func (flags *Flags) Validate(args []string) error {
+	if flags.Lite {
+		if flags.LiteBodySize <= 0 {
+			return fmt.Errorf("lite-body-size must be positive, given %d", flags.LiteBodySize)
+		}
+		if flags.RetryAltSvc || flags.ProbeEncodings || flags.HTTP2 || flags.HTTP3 || flags.SequenceFile != "" || flags.WithFavicon || flags.TechnologiesFile != "" || flags.CookieJar || flags.HTTPAuth != "" || flags.WebSocket != "" || flags.BodyStoreDir != "" ||
+			flags.WithRobotsTxt || flags.WithSecurityTxt || len(flags.FetchPaths) > 0 {
+			return errors.New("--lite cannot be used with --retry-alt-svc, --probe-encodings, --http2, --http3, --sequence-file, --with-favicon, --technologies-file, --cookie-jar, --http-auth, --websocket, --body-store-dir, --with-robots-txt, --with-security-txt or --fetch-path")
+		}
+	}
+	if flags.HTTPAuth == "" && (flags.AuthUsername != "" || flags.AuthPassword != "" || flags.AuthDomain != "") {
+		return errors.New("--auth-username, --auth-password and --auth-domain require --http-auth")
+	}
+	if (flags.HTTPAuth == "basic" || flags.HTTPAuth == "digest") && flags.AuthUsername == "" {
+		return fmt.Errorf("--http-auth=%s requires --auth-username", flags.HTTPAuth)
+	}
+	if flags.HTTP2Upgrade && !flags.HTTP2 {
+		return errors.New("--http2-upgrade requires --http2")
+	}
+	if flags.WebSocket == "" && (flags.WebSocketProtocols != "" || flags.WebSocketOrigin != "" || flags.WebSocketFrames != 0) {
+		return errors.New("--websocket-protocols, --websocket-origin and --websocket-frames require --websocket")
+	}
+	if flags.WebSocketFrames < 0 {
+		return fmt.Errorf("websocket-frames must be non-negative, given %d", flags.WebSocketFrames)
+	}
+	if flags.RequestBody != "" && flags.RequestBodyFile != "" {
+		return errors.New("--request-body and --request-body-file cannot both be given")
+	}
+	for _, path := range flags.FetchPaths {
+		if !strings.HasPrefix(path, "/") {
+			return fmt.Errorf("invalid path %q to fetch, expected one starting with /", path)
+		}
+	}
+	for _, header := range flags.CustomHeaders {
+		if i := strings.IndexByte(header, ':'); i <= 0 || strings.TrimSpace(header[:i]) == "" {
+			return fmt.Errorf("invalid custom header %q, expected 'Name: value'", header)
+		}
+	}
 	return nil
 }
 
@@ -113,10 +323,71 @@ func (s *Scanner) Protocol() string {
 	return "http"
 }
 
+// HandsOffConnection returns false, since the HTTP client manages its own
+// connections.
+func (s *Scanner) HandsOffConnection() bool {
+	return false
+}
+
+// TakesOverConnection returns true unless the first request is over TLS: a
+// plaintext request can be sent on a connection passed on with
+// --share-connection.
+func (s *Scanner) TakesOverConnection() bool {
+	return !s.config.UseHTTPS
+}
+
+// ProbeClasses returns ProbeAuthenticating if --http-auth is given, and
+// ProbeStateChanging if the method of the request, or of any in the
+// --sequence-file, is not a safe one (GET, HEAD or OPTIONS).
+func (s *Scanner) ProbeClasses() []string {
+	var ret []string
+	if s.config.HTTPAuth != "" {
+		ret = append(ret, zgrab2.ProbeAuthenticating)
+	}
+	methods := []string{s.config.Method}
+	for _, request := range s.sequence {
+		methods = append(methods, request.Method)
+	}
+	for _, method := range methods {
+		switch strings.ToUpper(method) {
+		case "GET", "HEAD", "OPTIONS":
+		default:
+			return append(ret, zgrab2.ProbeStateChanging)
+		}
+	}
+	return ret
+}
+
 // Init initializes the scanner with the given flags
 func (scanner *Scanner) Init(flags zgrab2.ScanFlags) error {
 	fl, _ := flags.(*Flags)
 	scanner.config = fl
+	ports, err := fl.ScanPorts()
+	if err != nil {
+		return err
+	}
+	scanner.ports = ports
+	if scanner.body, err = fl.requestBody(); err != nil {
+		return err
+	}
+	if fl.SequenceFile != "" {
+		if scanner.sequence, err = loadSequenceFile(fl.SequenceFile); err != nil {
+			return err
+		}
+	}
+	if fl.TechnologiesFile != "" {
+		if scanner.technologies, err = loadTechnologies(fl.TechnologiesFile); err != nil {
+			return err
+		}
+	}
+	if scanner.vhosts, err = loadVHosts(fl); err != nil {
+		return err
+	}
+	if fl.BodyStoreDir != "" {
+		if err := os.MkdirAll(fl.BodyStoreDir, 0755); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -135,6 +406,24 @@ func (scanner *Scanner) GetTrigger() string {
 	return scanner.config.Trigger
 }
 
+// ServerTime returns the time in the Date header of the final response.
+func (scanner *Scanner) ServerTime(result interface{}) *zgrab2.ServerTime {
+	results, ok := result.(*Results)
+	if !ok || results == nil || results.Response == nil {
+		return nil
+	}
+	date, err := http.ParseTime(results.Response.Header.Get("Date"))
+	if err != nil {
+		return nil
+	}
+	return &zgrab2.ServerTime{Time: date, Source: "http_date", Resolution: time.Second}
+}
+
+// GetScanPorts returns the ports to scan on targets that do not specify one.
+func (scanner *Scanner) GetScanPorts() []zgrab2.DefaultPort {
+	return scanner.ports
+}
+
 // Cleanup closes any connections that have been opened during the scan
 func (scan *scan) Cleanup() {
 	if scan.connections != nil {
@@ -159,27 +448,49 @@ func (scan *scan) withDeadlineContext(ctx context.Context) context.Context {
 // Dial a connection using the configured timeouts, as well as the global deadline, and on success,
 // add the connection to the list of connections to be cleaned up.
 func (scan *scan) dialContext(ctx context.Context, net string, addr string) (net.Conn, error) {
-	dialer := zgrab2.GetTimeoutConnectionDialer(scan.scanner.config.Timeout)
+	timeout := scan.target.BoundTimeout(scan.scanner.config.Timeout)
+	dialer := zgrab2.GetTimeoutConnectionDialer(timeout)
+	dialer.AddressFamily = scan.scanner.config.AddressFamily
+	preludes, err := scan.scanner.config.GetPreludes()
+	if err != nil {
+		return nil, err
+	}
+	dialer.Preludes = preludes
+	if dialer.Proxies, err = scan.scanner.config.GetProxies(); err != nil {
+		return nil, err
+	}
 
-	timeoutContext, _ := context.WithTimeout(context.Background(), scan.scanner.config.Timeout)
+	timeoutContext, _ := context.WithTimeout(context.Background(), timeout)
 
 	conn, err := dialer.DialContext(scan.withDeadlineContext(timeoutContext), net, addr)
 	if err != nil {
 		return nil, err
 	}
+	scan.target.RecordConnection(conn)
 	scan.connections = append(scan.connections, conn)
 	return conn, nil
 }
 
+// dialPlainContext dials a plaintext connection, using the connection passed
+// on by an earlier module with --share-connection, if there is one.
+func (scan *scan) dialPlainContext(ctx context.Context, net string, addr string) (net.Conn, error) {
+	if conn := scan.target.SharedConnection(&scan.scanner.config.BaseFlags, addr); conn != nil {
+		scan.connections = append(scan.connections, conn)
+		return conn, nil
+	}
+	return scan.dialContext(ctx, net, addr)
+}
+
 // getTLSDialer returns a Dial function that connects using the
-// zgrab2.GetTLSConnection()
-func (scan *scan) getTLSDialer() func(net, addr string) (net.Conn, error) {
+// zgrab2.GetTLSConnectionForTarget(), sending the target's name, if it is not
+// nil, as the server name.
+func (scan *scan) getTLSDialer(target *zgrab2.ScanTarget) func(net, addr string) (net.Conn, error) {
 	return func(net, addr string) (net.Conn, error) {
 		outer, err := scan.dialContext(context.Background(), net, addr)
 		if err != nil {
 			return nil, err
 		}
-		tlsConn, err := scan.scanner.config.TLSFlags.GetTLSConnection(outer)
+		tlsConn, err := scan.scanner.config.TLSFlags.GetTLSConnectionForTarget(outer, target)
 		if err != nil {
 			return nil, err
 		}
@@ -210,35 +521,91 @@ func redirectsToLocalhost(host string) bool {
 	return false
 }
 
+// setAcceptEncoding sets the --accept-encoding of the request; without one,
+// the transport requests gzip.
+func (scan *scan) setAcceptEncoding(request *http.Request) {
+	if scan.scanner.config.AcceptEncoding != "" {
+		request.Header.Set("Accept-Encoding", scan.scanner.config.AcceptEncoding)
+	}
+}
+
+// bodyLimit returns the most bytes read of each response body.
+func (scan *scan) bodyLimit() int64 {
+	if scan.scanner.config.Lite {
+		return int64(scan.scanner.config.LiteBodySize) * 1024
+	}
+	return int64(scan.scanner.config.MaxSize) * 1024
+}
+
+// readBody reads the response's body, up to the body limit, into its
+// BodyText, decoded from its Content-Encoding, and records its hashes.
+func (scan *scan) readBody(res *http.Response) {
+	b := new(bytes.Buffer)
+	maxReadLen := scan.bodyLimit()
+	readLen := maxReadLen
+	if res.ContentLength >= 0 && res.ContentLength < maxReadLen {
+		readLen = res.ContentLength
+	}
+	io.CopyN(b, res.Body, readLen)
+	body := b.Bytes()
+	// Bodies the transport did not decode itself are decoded here, up to
+	// the same limit, so that compressed bodies cannot expand without
+	// bound.
+	if encodings := contentEncodings(res.Header); len(encodings) > 0 && !res.Uncompressed && len(body) > 0 {
+		decoded, err := decode(encodings, body, maxReadLen)
+		// A body cut short at the limit decodes to as much as was read.
+		truncated := int64(len(body)) == maxReadLen && res.ContentLength != maxReadLen
+		if err == io.ErrUnexpectedEOF && truncated {
+			err = nil
+		}
+		if err != errUnknownEncoding {
+			res.EncodedLength = int64(len(body))
+			if err != nil {
+				res.DecodeError = err.Error()
+			} else {
+				res.DecodedLength, body = int64(len(decoded)), decoded
+			}
+		}
+	}
+	setBody(res, body)
+}
+
 // Taken from zgrab/zlib/grabber.go -- get a CheckRedirect callback that uses the redirectToLocalhost and MaxRedirects config
 func (scan *scan) getCheckRedirect() func(*http.Request, *http.Response, []*http.Request) error {
 	return func(req *http.Request, res *http.Response, via []*http.Request) error {
+		hop := newRedirectHop(res, req.URL)
+		scan.results.Redirects = append(scan.results.Redirects, hop)
 		if !scan.scanner.config.FollowLocalhostRedirects && redirectsToLocalhost(req.URL.Hostname()) {
+			hop.Blocked = redirectBlockedLocalhost
 			return ErrRedirLocalhost
 		}
-		scan.results.RedirectResponseChain = append(scan.results.RedirectResponseChain, res)
-		b := new(bytes.Buffer)
-		maxReadLen := int64(scan.scanner.config.MaxSize) * 1024
-		readLen := maxReadLen
-		if res.ContentLength >= 0 && res.ContentLength < maxReadLen {
-			readLen = res.ContentLength
-		}
-		io.CopyN(b, res.Body, readLen)
-		res.BodyText = b.String()
-		if len(res.BodyText) > 0 {
-			m := sha256.New()
-			m.Write(b.Bytes())
-			res.BodySHA256 = m.Sum(nil)
+		// A redirect the policy blocks leaves its response as the final
+		// one.
+		if hop.Blocked = scan.blockedBy(hop); hop.Blocked != "" {
+			return http.ErrUseLastResponse
 		}
+		scan.results.RedirectResponseChain = append(scan.results.RedirectResponseChain, res)
+		scan.readBody(res)
 
 		if len(via) > scan.scanner.config.MaxRedirects {
+			hop.Blocked = redirectBlockedMaxRedirects
 			return ErrTooManyRedirects
 		}
 
+		hop.Followed = true
 		return nil
 	}
 }
 
+// defaultPorts are the ports scanned on targets without a port with
+// --all-default-ports; the first is the default for --port.
+var defaultPorts = []zgrab2.DefaultPort{
+	{Port: 80},
+	{Port: 8080},
+	{Port: 8000},
+	{Port: 443, TLS: true},
+}
+
 // Maps URL protocol to the default port for that protocol
 var protoToPort = map[string]uint16{
 	"http":  80,
@@ -260,7 +627,7 @@ func getHTTPURL(https bool, host string, port uint16, endpoint string) string {
 }
 
 // NewHTTPScan gets a new Scan instance for the given target
-func (scanner *Scanner) newHTTPScan(t *zgrab2.ScanTarget) *scan {
+func (scanner *Scanner) newHTTPScan(t *zgrab2.ScanTarget, useHTTPS bool) *scan {
 	ret := scan{
 		scanner: scanner,
 		target:  t,
@@ -271,38 +638,71 @@ func (scanner *Scanner) newHTTPScan(t *zgrab2.ScanTarget) *scan {
 			MaxIdleConnsPerHost: scanner.config.MaxRedirects,
 		},
 		client:         http.MakeNewClient(),
-		globalDeadline: time.Now().Add(scanner.config.Timeout),
+		globalDeadline: time.Now().Add(t.BoundTimeout(scanner.config.Timeout)),
 	}
-	ret.transport.DialTLS = ret.getTLSDialer()
-	ret.transport.DialContext = ret.dialContext
+	ret.transport.DialTLS = ret.getTLSDialer(nil)
+	ret.transport.DialContext = ret.dialPlainContext
 	ret.client.UserAgent = scanner.config.UserAgent
 	ret.client.CheckRedirect = ret.getCheckRedirect()
 	ret.client.Transport = ret.transport
-	ret.client.Jar = nil // Don't send or receive cookies (otherwise use CookieJar)
+	if scanner.config.CookieJar {
+		// Without a public suffix list, the jar accepts a Domain attribute
+		// naming any parent of the host, as a study of what servers set
+		// wants.
+		ret.client.Jar, _ = cookiejar.New(nil)
+	} else {
+		ret.client.Jar = nil // Don't send or receive cookies
+	}
 	ret.client.Timeout = scanner.config.Timeout
 	host := t.Domain
 	if host == "" {
 		host = t.IP.String()
 	}
-	ret.url = getHTTPURL(scanner.config.UseHTTPS, host, uint16(scanner.config.BaseFlags.Port), scanner.config.Endpoint)
+	port := scanner.config.BaseFlags.Port
+	if t.Port != nil {
+		port = *t.Port
+	}
+	ret.templates = newTemplateReplacer(scanner.config, t, port)
+	ret.url = getHTTPURL(useHTTPS, host, uint16(port), ret.expand(scanner.config.Endpoint))
 
 	return &ret
 }
 
+// firstRequest returns the first request of the scan, with its body and
+// headers.
+func (scan *scan) firstRequest() (*http.Request, error) {
+	var body io.Reader
+	if scan.scanner.body != nil {
+		body = strings.NewReader(scan.expand(string(scan.scanner.body)))
+	}
+	request, err := http.NewRequest(scan.scanner.config.Method, scan.url, body)
+	if err != nil {
+		return nil, err
+	}
+	// TODO: Headers from input?
+	request.Header.Set("Accept", "*/*")
+	scan.setAcceptEncoding(request)
+	if body != nil && scan.scanner.config.ContentType != "" {
+		request.Header.Set("Content-Type", scan.scanner.config.ContentType)
+	}
+	for _, header := range scan.customHeaders() {
+		setHeader(request, header[0], header[1])
+	}
+	return request, nil
+}
+
 // Grab performs the HTTP scan -- implementation taken from zgrab/zlib/grabber.go
 func (scan *scan) Grab() *zgrab2.ScanError {
-	// TODO: Allow body?
-	request, err := http.NewRequest(scan.scanner.config.Method, scan.url, nil)
+	request, err := scan.firstRequest()
 	if err != nil {
 		return zgrab2.NewScanError(zgrab2.SCAN_UNKNOWN_ERROR, err)
 	}
-	// TODO: Headers from input?
-	request.Header.Set("Accept", "*/*")
 	resp, err := scan.client.Do(request)
 	if resp != nil && resp.Body != nil {
 		defer resp.Body.Close()
 	}
 	scan.results.Response = resp
+	scan.finishRedirects(resp)
 	if err != nil {
 		if urlError, ok := err.(*url.Error); ok {
 			err = urlError.Err
@@ -319,18 +719,47 @@ func (scan *scan) Grab() *zgrab2.ScanError {
 		}
 	}
 
-	buf := new(bytes.Buffer)
-	maxReadLen := int64(scan.scanner.config.MaxSize) * 1024
-	readLen := maxReadLen
-	if resp.ContentLength >= 0 && resp.ContentLength < maxReadLen {
-		readLen = resp.ContentLength
+	scan.readBody(resp)
+	if len(scan.scanner.vhosts) > 0 {
+		scan.vhosts()
+	}
+	if scan.scanner.config.Lite {
+		return nil
+	}
+	if scan.client.Jar != nil {
+		scan.cookies(resp)
+	}
+	// Authentication goes first, while the connection is fresh, as NTLM's
+	// messages must all be sent on one.
+	if scan.scanner.config.HTTPAuth != "" {
+		scan.authenticate(resp)
+	}
+	scan.results.HTML = newHTMLMetadata(resp)
+	if len(scan.scanner.technologies) > 0 {
+		scan.technologies(resp)
+	}
+	// The sequence goes first, while the connection is fresh.
+	if len(scan.scanner.sequence) > 0 {
+		scan.sequence(resp)
+	}
+	scan.altSvc(resp)
+	if scan.scanner.config.WithFavicon {
+		scan.favicon(resp)
+	}
+	if len(scan.scanner.filePaths()) > 0 {
+		scan.files(resp)
+	}
+	if scan.scanner.config.ProbeEncodings {
+		scan.probeEncodings(resp)
+	}
+	if scan.scanner.config.HTTP2 {
+		scan.http2(resp)
+	}
+	if scan.scanner.config.HTTP3 {
+		scan.http3(resp)
 	}
-	io.CopyN(buf, resp.Body, readLen)
-	scan.results.Response.BodyText = buf.String()
-	if len(scan.results.Response.BodyText) > 0 {
-		m := sha256.New()
-		m.Write(buf.Bytes())
-		scan.results.Response.BodySHA256 = m.Sum(nil)
+	if scan.scanner.config.WebSocket != "" {
+		scan.websocket(resp)
 	}
 
 	return nil
@@ -340,24 +769,24 @@ func (scan *scan) Grab() *zgrab2.ScanError {
 // the target. If the scanner is configured to follow redirects, this may entail
 // multiple TCP connections to hosts other than target.
 func (scanner *Scanner) Scan(t zgrab2.ScanTarget) (zgrab2.ScanStatus, interface{}, error) {
-	scan := scanner.newHTTPScan(&t)
+	useHTTPS := scanner.config.UseHTTPS || t.UseTLS
+	scan := scanner.newHTTPScan(&t, useHTTPS)
 	defer scan.Cleanup()
 	err := scan.Grab()
 	if err != nil {
-		if scanner.config.RetryHTTPS && !scanner.config.UseHTTPS {
+		if scanner.config.RetryHTTPS && !useHTTPS {
 			scan.Cleanup()
-			scanner.config.UseHTTPS = true
-			retry := scanner.newHTTPScan(&t)
+			retry := scanner.newHTTPScan(&t, true)
 			defer retry.Cleanup()
 			retryError := retry.Grab()
 			if retryError != nil {
-				return retryError.Unpack(&retry.results)
+				return retryError.Unpack(retry.result())
 			}
-			return zgrab2.SCAN_SUCCESS, &retry.results, nil
+			return zgrab2.SCAN_SUCCESS, retry.result(), nil
 		}
-		return err.Unpack(&scan.results)
+		return err.Unpack(scan.result())
 	}
-	return zgrab2.SCAN_SUCCESS, &scan.results, nil
+	return zgrab2.SCAN_SUCCESS, scan.result(), nil
 }
 
 // RegisterModule is called by modules/http.go to register this module with the
@@ -365,7 +794,7 @@ func (scanner *Scanner) Scan(t zgrab2.ScanTarget) (zgrab2.ScanStatus, interface{
 func RegisterModule() {
 	var module Module
 
-	_, err := zgrab2.AddCommand("http", "HTTP Banner Grab", "Grab a banner over HTTP", 80, &module)
+	_, err := zgrab2.AddCommandWithPorts("http", "HTTP Banner Grab", "Grab a banner over HTTP", defaultPorts, &module)
 	if err != nil {
 		log.Fatal(err)
 	}
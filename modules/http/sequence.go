@@ -0,0 +1,164 @@
+package http
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"github.com/zmap/zgrab2/lib/http"
+	"gopkg.in/yaml.v2"
+)
+
+// SequenceRequest is a request in a --sequence-file, which is YAML (or
+// JSON) of the form:
+//
+//	requests:
+//	  - name: login
+//	    method: POST
+//	    path: /api/login
+//	    headers:
+//	      Content-Type: application/json
+//	    body: '{"user": "guest"}'
+//	  - method: GET
+//	    path: /api/status
+type SequenceRequest struct {
+	// Name identifies the request in the results.
+	Name   string `yaml:"name"`
+	Method string `yaml:"method"`
+
+	// Path is the path (and query) requested, relative to the final URL of
+	// the first request.
+	Path    string            `yaml:"path"`
+	Headers map[string]string `yaml:"headers"`
+
+	// Body is the request body, or BodyFile the file holding it, relative
+	// to the sequence file.
+	Body     string `yaml:"body"`
+	BodyFile string `yaml:"body_file"`
+}
+
+// sequenceFile is the contents of a --sequence-file.
+type sequenceFile struct {
+	Requests []*SequenceRequest `yaml:"requests"`
+}
+
+// SequenceResponse is the response to a request in the --sequence-file.
+type SequenceResponse struct {
+	Name   string `json:"name,omitempty"`
+	Method string `json:"method"`
+	URL    string `json:"url"`
+
+	// ReusedConnection is true if the request was sent on a connection
+	// already opened by an earlier request.
+	ReusedConnection bool `json:"reused_connection"`
+
+	Response *http.Response `json:"response,omitempty"`
+	Error    string         `json:"error,omitempty"`
+}
+
+// loadSequenceFile reads the requests in a --sequence-file, loading their
+// body files.
+func loadSequenceFile(path string) ([]*SequenceRequest, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file sequenceFile
+	if err := yaml.UnmarshalStrict(data, &file); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if len(file.Requests) == 0 {
+		return nil, fmt.Errorf("%s: no requests", path)
+	}
+	for i, request := range file.Requests {
+		if request.Method == "" {
+			request.Method = "GET"
+		}
+		if request.Path == "" {
+			request.Path = "/"
+		}
+		if request.BodyFile == "" {
+			continue
+		}
+		if request.Body != "" {
+			return nil, fmt.Errorf("%s: request %d has both a body and a body_file", path, i+1)
+		}
+		bodyFile := request.BodyFile
+		if !filepath.IsAbs(bodyFile) {
+			bodyFile = filepath.Join(filepath.Dir(path), bodyFile)
+		}
+		body, err := ioutil.ReadFile(bodyFile)
+		if err != nil {
+			return nil, err
+		}
+		request.Body = string(body)
+	}
+	return file.Requests, nil
+}
+
+// requestBody returns the body to send with the first request, from
+// --request-body or --request-body-file, or nil if neither is given.
+func (flags *Flags) requestBody() ([]byte, error) {
+	if flags.RequestBodyFile != "" {
+		return ioutil.ReadFile(flags.RequestBodyFile)
+	}
+	if flags.RequestBody != "" {
+		return []byte(flags.RequestBody), nil
+	}
+	return nil, nil
+}
+
+// sequence sends each request in the --sequence-file in turn, to the origin
+// of the final URL, on the connection left open by the first request where
+// the server keeps it alive. Redirects are not followed.
+func (scan *scan) sequence(resp *http.Response) {
+	if resp.Request == nil || resp.Request.URL == nil {
+		return
+	}
+	base := resp.Request.URL
+	for _, spec := range scan.scanner.sequence {
+		ret := &SequenceResponse{Name: spec.Name, Method: spec.Method}
+		scan.results.Sequence = append(scan.results.Sequence, ret)
+		target, err := base.Parse(scan.expand(spec.Path))
+		if err != nil {
+			ret.Error = err.Error()
+			continue
+		}
+		ret.URL = target.String()
+		if err := scan.sendSequenceRequest(spec, target, ret); err != nil {
+			ret.Error = err.Error()
+		}
+	}
+}
+
+func (scan *scan) sendSequenceRequest(spec *SequenceRequest, target *url.URL, ret *SequenceResponse) error {
+	var body io.Reader
+	if spec.Body != "" {
+		body = strings.NewReader(scan.expand(spec.Body))
+	}
+	request, err := http.NewRequest(spec.Method, target.String(), body)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Accept", "*/*")
+	request.Header.Set("User-Agent", scan.scanner.config.UserAgent)
+	scan.setAcceptEncoding(request)
+	for name, value := range spec.Headers {
+		setHeader(request, name, scan.expand(value))
+	}
+	scan.addJarCookies(request)
+	connections := len(scan.connections)
+	resp, err := scan.transport.RoundTrip(request)
+	ret.ReusedConnection = err == nil && len(scan.connections) == connections
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	ret.Response = resp
+	scan.storeJarCookies(target, resp)
+	scan.readBody(resp)
+	return nil
+}
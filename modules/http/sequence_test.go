@@ -0,0 +1,87 @@
+package http
+
+import (
+	"io/ioutil"
+	"net"
+	nethttp "net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/zmap/zgrab2"
+)
+
+func TestSequence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sequence")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "login.json"), []byte(`{"user":"guest"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	spec := `
+requests:
+  - name: login
+    method: POST
+    path: /api/login
+    headers:
+      Content-Type: application/json
+    body_file: login.json
+  - path: /api/status?verbose=1
+`
+	sequenceFile := filepath.Join(dir, "sequence.yaml")
+	if err := ioutil.WriteFile(sequenceFile, []byte(spec), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	handler := nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Write([]byte(r.Method + " " + r.URL.RequestURI() + " " + r.Header.Get("Content-Type") + " " + string(body)))
+	})
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	flags := &Flags{Method: "PUT", Endpoint: "/", UserAgent: "zgrab2 test", MaxSize: 256, RequestBody: "a=b", ContentType: "application/x-www-form-urlencoded", SequenceFile: sequenceFile}
+	flags.Port = uint(server.Listener.Addr().(*net.TCPAddr).Port)
+	flags.Timeout = 2 * time.Second
+	if err := flags.Validate(nil); err != nil {
+		t.Fatal(err)
+	}
+	var scanner Scanner
+	if err := scanner.Init(flags); err != nil {
+		t.Fatal(err)
+	}
+	if classes := scanner.ProbeClasses(); len(classes) != 1 || classes[0] != zgrab2.ProbeStateChanging {
+		t.Errorf("wrong probe classes %v", classes)
+	}
+	status, ret, err := scanner.Scan(zgrab2.ScanTarget{IP: net.ParseIP("127.0.0.1")})
+	if status != zgrab2.SCAN_SUCCESS {
+		t.Fatalf("scan failed: %s %v", status, err)
+	}
+	results := ret.(*Results)
+	if body := results.Response.BodyText; body != "PUT / application/x-www-form-urlencoded a=b" {
+		t.Errorf("wrong first response %q", body)
+	}
+	expected := []string{
+		"POST /api/login application/json {\"user\":\"guest\"}",
+		"GET /api/status?verbose=1  ",
+	}
+	if len(results.Sequence) != len(expected) {
+		t.Fatalf("wrong sequence %+v", results.Sequence)
+	}
+	for i, response := range results.Sequence {
+		if response.Error != "" || response.Response == nil || response.Response.BodyText != expected[i] {
+			t.Errorf("wrong response %d: %+v", i, response)
+			continue
+		}
+		if !response.ReusedConnection {
+			t.Errorf("request %d was not sent on the same connection", i)
+		}
+	}
+	if results.Sequence[0].Name != "login" || results.Sequence[1].Method != "GET" {
+		t.Errorf("wrong requests %+v", results.Sequence)
+	}
+}
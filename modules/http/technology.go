@@ -0,0 +1,378 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/zmap/zgrab2/lib/http"
+)
+
+// Technology is a technology detected in the final response by the rules in
+// the --technologies-file.
+type Technology struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+
+	// Confidence is the sum of the confidences of the rules that matched,
+	// up to 100.
+	Confidence int `json:"confidence"`
+
+	Categories []int  `json:"categories,omitempty"`
+	CPE        string `json:"cpe,omitempty"`
+
+	// ImpliedBy is the technology whose detection implied this one, if it
+	// was not itself matched.
+	ImpliedBy string `json:"implied_by,omitempty"`
+}
+
+// technologyPattern is a rule of a technology: a regular expression
+// followed by \; separated tags, e.g. "nginx(?:/([\d.]+))?\;version:\1".
+type technologyPattern struct {
+	regex *regexp.Regexp
+
+	// version is the version template, where \1 stands for the first
+	// group matched.
+	version    string
+	confidence int
+}
+
+// impliedTechnology is a technology implied by another, e.g. PHP by
+// WordPress.
+type impliedTechnology struct {
+	name       string
+	confidence int
+}
+
+// technology holds the rules of a technology in the --technologies-file.
+type technology struct {
+	name       string
+	categories []int
+	cpe        string
+
+	// headers, cookies and meta are keyed by lowercase name.
+	headers   map[string][]*technologyPattern
+	cookies   map[string][]*technologyPattern
+	meta      map[string][]*technologyPattern
+	html      []*technologyPattern
+	scriptSrc []*technologyPattern
+	url       []*technologyPattern
+	implies   []impliedTechnology
+}
+
+// technologyJSON is a technology in the Wappalyzer format. Patterns may be
+// given as a string or a list of strings; rules on what the scanner does not
+// see, such as the DOM or JavaScript variables, are ignored.
+type technologyJSON struct {
+	Cats      []int                      `json:"cats"`
+	CPE       string                     `json:"cpe"`
+	Headers   map[string]string          `json:"headers"`
+	Cookies   map[string]string          `json:"cookies"`
+	Meta      map[string]json.RawMessage `json:"meta"`
+	HTML      json.RawMessage            `json:"html"`
+	ScriptSrc json.RawMessage            `json:"scriptSrc"`
+	Scripts   json.RawMessage            `json:"scripts"`
+	URL       json.RawMessage            `json:"url"`
+	Implies   json.RawMessage            `json:"implies"`
+}
+
+// stringList decodes a string or a list of strings.
+func stringList(raw json.RawMessage) ([]string, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+	var one string
+	if err := json.Unmarshal(raw, &one); err == nil {
+		return []string{one}, nil
+	}
+	var ret []string
+	if err := json.Unmarshal(raw, &ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// splitTags splits a pattern into its value and its \; separated tags.
+func splitTags(pattern string) (string, map[string]string) {
+	parts := strings.Split(pattern, `\;`)
+	tags := make(map[string]string)
+	for _, tag := range parts[1:] {
+		if i := strings.IndexByte(tag, ':'); i >= 0 {
+			tags[tag[:i]] = tag[i+1:]
+		}
+	}
+	return parts[0], tags
+}
+
+// tagConfidence returns the confidence tag, or 100.
+func tagConfidence(tags map[string]string) int {
+	if confidence, err := strconv.Atoi(tags["confidence"]); err == nil {
+		return confidence
+	}
+	return 100
+}
+
+// parseTechnologyPattern compiles a pattern, case-insensitively as
+// Wappalyzer does. It returns nil for patterns that Go's regular expressions
+// cannot express, such as those with lookarounds.
+func parseTechnologyPattern(name, pattern string) *technologyPattern {
+	expression, tags := splitTags(pattern)
+	regex, err := regexp.Compile("(?i)" + expression)
+	if err != nil {
+		log.Warnf("technologies: skipping a pattern of %s: %v", name, err)
+		return nil
+	}
+	return &technologyPattern{regex: regex, version: tags["version"], confidence: tagConfidence(tags)}
+}
+
+func parseTechnologyPatterns(name string, patterns []string) []*technologyPattern {
+	var ret []*technologyPattern
+	for _, pattern := range patterns {
+		if p := parseTechnologyPattern(name, pattern); p != nil {
+			ret = append(ret, p)
+		}
+	}
+	return ret
+}
+
+// loadTechnologies reads a --technologies-file: an object mapping names to
+// technologies, as in Wappalyzer's technologies/*.json, or the older
+// apps.json with that object under "technologies" (or "apps").
+func loadTechnologies(path string) ([]*technology, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file map[string]json.RawMessage
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	for _, key := range []string{"technologies", "apps"} {
+		if nested, ok := file[key]; ok {
+			file = nil
+			if err := json.Unmarshal(nested, &file); err != nil {
+				return nil, fmt.Errorf("%s: %v", path, err)
+			}
+			break
+		}
+	}
+	var ret []*technology
+	for name, raw := range file {
+		var def technologyJSON
+		if err := json.Unmarshal(raw, &def); err != nil {
+			return nil, fmt.Errorf("%s: %s: %v", path, name, err)
+		}
+		tech, err := newTechnology(name, &def)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s: %v", path, name, err)
+		}
+		ret = append(ret, tech)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].name < ret[j].name })
+	return ret, nil
+}
+
+func newTechnology(name string, def *technologyJSON) (*technology, error) {
+	ret := &technology{
+		name:       name,
+		categories: def.Cats,
+		cpe:        def.CPE,
+		headers:    make(map[string][]*technologyPattern),
+		cookies:    make(map[string][]*technologyPattern),
+		meta:       make(map[string][]*technologyPattern),
+	}
+	for header, pattern := range def.Headers {
+		ret.headers[strings.ToLower(header)] = parseTechnologyPatterns(name, []string{pattern})
+	}
+	for cookie, pattern := range def.Cookies {
+		ret.cookies[strings.ToLower(cookie)] = parseTechnologyPatterns(name, []string{pattern})
+	}
+	for meta, raw := range def.Meta {
+		patterns, err := stringList(raw)
+		if err != nil {
+			return nil, err
+		}
+		ret.meta[strings.ToLower(meta)] = parseTechnologyPatterns(name, patterns)
+	}
+	for _, field := range []struct {
+		raw  json.RawMessage
+		dest *[]*technologyPattern
+	}{
+		{def.HTML, &ret.html},
+		{def.ScriptSrc, &ret.scriptSrc},
+		{def.Scripts, &ret.scriptSrc},
+		{def.URL, &ret.url},
+	} {
+		patterns, err := stringList(field.raw)
+		if err != nil {
+			return nil, err
+		}
+		*field.dest = append(*field.dest, parseTechnologyPatterns(name, patterns)...)
+	}
+	implies, err := stringList(def.Implies)
+	if err != nil {
+		return nil, err
+	}
+	for _, implied := range implies {
+		implied, tags := splitTags(implied)
+		ret.implies = append(ret.implies, impliedTechnology{name: implied, confidence: tagConfidence(tags)})
+	}
+	return ret, nil
+}
+
+// versionOf fills in the pattern's version template from the groups matched.
+func (p *technologyPattern) versionOf(groups []string) string {
+	version := p.version
+	for i := len(groups) - 1; i > 0; i-- {
+		version = strings.Replace(version, `\`+strconv.Itoa(i), groups[i], -1)
+	}
+	return strings.TrimSpace(version)
+}
+
+// technologyInput is what the rules are matched against.
+type technologyInput struct {
+	url       string
+	headers   http.Header
+	cookies   map[string]string
+	meta      map[string]string
+	scriptSrc []string
+	body      string
+}
+
+// newTechnologyInput extracts what the rules are matched against from the
+// final response.
+func newTechnologyInput(resp *http.Response) *technologyInput {
+	ret := &technologyInput{
+		headers: resp.Header,
+		cookies: make(map[string]string),
+		meta:    make(map[string]string),
+		body:    resp.BodyText,
+	}
+	if resp.Request != nil && resp.Request.URL != nil {
+		ret.url = resp.Request.URL.String()
+	}
+	for _, cookie := range resp.Header["Set-Cookie"] {
+		pair := strings.TrimSpace(strings.SplitN(cookie, ";", 2)[0])
+		if i := strings.IndexByte(pair, '='); i > 0 {
+			ret.cookies[strings.ToLower(pair[:i])] = pair[i+1:]
+		}
+	}
+	if isHTML(resp) {
+		body := []byte(resp.BodyText)
+		for _, meta := range htmlTags(body, "meta") {
+			if name := strings.ToLower(meta["name"]); name != "" {
+				if _, ok := ret.meta[name]; !ok {
+					ret.meta[name] = meta["content"]
+				}
+			}
+		}
+		for _, script := range htmlTags(body, "script") {
+			if script["src"] != "" {
+				ret.scriptSrc = append(ret.scriptSrc, script["src"])
+			}
+		}
+	}
+	return ret
+}
+
+// match returns the technology if any of its rules match, or nil.
+func (t *technology) match(in *technologyInput) *Technology {
+	ret := &Technology{Name: t.name, Categories: t.categories, CPE: t.cpe}
+	matched := false
+	try := func(patterns []*technologyPattern, value string) {
+		for _, p := range patterns {
+			groups := p.regex.FindStringSubmatch(value)
+			if groups == nil {
+				continue
+			}
+			matched = true
+			ret.Confidence += p.confidence
+			if ret.Version == "" {
+				ret.Version = p.versionOf(groups)
+			}
+		}
+	}
+	for name, patterns := range t.headers {
+		if values, ok := in.headers[http.CanonicalHeaderKey(name)]; ok {
+			try(patterns, strings.Join(values, ", "))
+		}
+	}
+	for name, patterns := range t.cookies {
+		if value, ok := in.cookies[name]; ok {
+			try(patterns, value)
+		}
+	}
+	for name, patterns := range t.meta {
+		if value, ok := in.meta[name]; ok {
+			try(patterns, value)
+		}
+	}
+	for _, src := range in.scriptSrc {
+		try(t.scriptSrc, src)
+	}
+	try(t.url, in.url)
+	try(t.html, in.body)
+	if !matched {
+		return nil
+	}
+	if ret.Confidence > 100 {
+		ret.Confidence = 100
+	}
+	return ret
+}
+
+// detectTechnologies matches the rules against the final response, and adds
+// the technologies implied by those detected.
+func detectTechnologies(technologies []*technology, resp *http.Response) []*Technology {
+	in := newTechnologyInput(resp)
+	byName := make(map[string]*technology, len(technologies))
+	detected := make(map[string]*Technology)
+	var queue []*Technology
+	for _, t := range technologies {
+		byName[t.name] = t
+		if match := t.match(in); match != nil {
+			detected[t.name] = match
+			queue = append(queue, match)
+		}
+	}
+	for len(queue) > 0 {
+		parent := queue[0]
+		queue = queue[1:]
+		t, ok := byName[parent.Name]
+		if !ok {
+			continue
+		}
+		for _, implied := range t.implies {
+			if _, ok := detected[implied.name]; ok {
+				continue
+			}
+			ret := &Technology{Name: implied.name, Confidence: implied.confidence, ImpliedBy: parent.Name}
+			if parent.Confidence < ret.Confidence {
+				ret.Confidence = parent.Confidence
+			}
+			if known, ok := byName[implied.name]; ok {
+				ret.Categories, ret.CPE = known.categories, known.cpe
+			}
+			detected[implied.name] = ret
+			queue = append(queue, ret)
+		}
+	}
+	ret := make([]*Technology, 0, len(detected))
+	for _, t := range detected {
+		ret = append(ret, t)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Name < ret[j].Name })
+	return ret
+}
+
+// technologies records the technologies the final response matches.
+func (scan *scan) technologies(resp *http.Response) {
+	if detected := detectTechnologies(scan.scanner.technologies, resp); len(detected) > 0 {
+		scan.results.Technologies = detected
+	}
+}
@@ -0,0 +1,82 @@
+package http
+
+import (
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/zmap/zgrab2/lib/http"
+)
+
+const testTechnologies = `{
+	"technologies": {
+		"Nginx": {
+			"cats": [22],
+			"headers": {"Server": "nginx(?:/([\\d.]+))?\\;version:\\1"},
+			"cpe": "cpe:2.3:a:f5:nginx:*:*:*:*:*:*:*:*"
+		},
+		"WordPress": {
+			"cats": [1],
+			"meta": {"generator": ["^WordPress ?([\\d.]+)?\\;version:\\1"]},
+			"html": "<link rel=.stylesheet. [^>]+/wp-(?:content|includes)/\\;confidence:50",
+			"scriptSrc": "/wp-includes/",
+			"implies": ["PHP", "MySQL\\;confidence:50"]
+		},
+		"PHP": {
+			"cats": [27],
+			"cookies": {"PHPSESSID": ""},
+			"headers": {"X-Powered-By": "^php/?([\\d.]+)?\\;version:\\1"}
+		},
+		"Lookahead": {
+			"html": "(?=unsupported)"
+		},
+		"jQuery": {
+			"scriptSrc": "jquery[.-]([\\d.]*\\d)[^/]*\\.js\\;version:\\1"
+		}
+	}
+}`
+
+func TestDetectTechnologies(t *testing.T) {
+	dir, err := ioutil.TempDir("", "technologies")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "technologies.json")
+	if err := ioutil.WriteFile(path, []byte(testTechnologies), 0600); err != nil {
+		t.Fatal(err)
+	}
+	technologies, err := loadTechnologies(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(technologies) != 5 {
+		t.Fatalf("loaded %d technologies", len(technologies))
+	}
+	page, _ := url.Parse("https://example.com/")
+	resp := &http.Response{
+		Header: http.Header{
+			"Server":       {"nginx/1.18.0 (Ubuntu)"},
+			"Content-Type": {"text/html"},
+			"Set-Cookie":   {"PHPSESSID=abc; path=/"},
+		},
+		BodyText: `<meta name="generator" content="WordPress 6.4.2"><script src="/wp-includes/js/jquery/jquery-3.7.1.min.js"></script>`,
+		Request:  &http.Request{URL: page},
+	}
+	expected := []*Technology{
+		{Name: "MySQL", Confidence: 50, ImpliedBy: "WordPress"},
+		{Name: "Nginx", Version: "1.18.0", Confidence: 100, Categories: []int{22}, CPE: "cpe:2.3:a:f5:nginx:*:*:*:*:*:*:*:*"},
+		{Name: "PHP", Confidence: 100, Categories: []int{27}},
+		{Name: "WordPress", Version: "6.4.2", Confidence: 100, Categories: []int{1}},
+		{Name: "jQuery", Version: "3.7.1", Confidence: 100},
+	}
+	if detected := detectTechnologies(technologies, resp); !reflect.DeepEqual(detected, expected) {
+		for _, d := range detected {
+			t.Logf("%+v", d)
+		}
+		t.Error("wrong technologies")
+	}
+}
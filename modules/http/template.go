@@ -0,0 +1,76 @@
+package http
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/zmap/zgrab2"
+	"github.com/zmap/zgrab2/lib/http"
+)
+
+// templateVariables are the placeholders expanded, per target, in the
+// --endpoint, --custom-header values, the request body, and the paths,
+// headers and bodies of the --sequence-file requests. Other text in braces,
+// such as JSON, is left as it is.
+var templateVariables = []string{"target_ip", "target_host", "port", "sni"}
+
+// newTemplateReplacer returns the replacer expanding the template variables
+// for a scan of the target on the port:
+//
+//	{target_ip}    the target's IP address, or nothing if it was given by name only
+//	{target_host}  the target's name, or else its IP address
+//	{port}         the port scanned
+//	{sni}          the server name sent in TLS handshakes (--server-name, or the
+//	               target's name unless --no-sni), or nothing
+func newTemplateReplacer(flags *Flags, t *zgrab2.ScanTarget, port uint) *strings.Replacer {
+	var ip string
+	if t.IP != nil {
+		ip = t.IP.String()
+	}
+	host := t.Domain
+	if host == "" {
+		host = ip
+	}
+	sni := flags.ServerName
+	if sni == "" && !flags.NoSNI {
+		sni = t.Domain
+	}
+	values := map[string]string{
+		"target_ip":   ip,
+		"target_host": host,
+		"port":        strconv.FormatUint(uint64(port), 10),
+		"sni":         sni,
+	}
+	pairs := make([]string, 0, 2*len(templateVariables))
+	for _, name := range templateVariables {
+		pairs = append(pairs, "{"+name+"}", values[name])
+	}
+	return strings.NewReplacer(pairs...)
+}
+
+// expand expands the template variables in s.
+func (scan *scan) expand(s string) string {
+	if !strings.Contains(s, "{") {
+		return s
+	}
+	return scan.templates.Replace(s)
+}
+
+// customHeaders returns the --custom-header names and values, expanded.
+func (scan *scan) customHeaders() [][2]string {
+	var ret [][2]string
+	for _, header := range scan.scanner.config.CustomHeaders {
+		i := strings.IndexByte(header, ':')
+		ret = append(ret, [2]string{strings.TrimSpace(header[:i]), scan.expand(strings.TrimSpace(header[i+1:]))})
+	}
+	return ret
+}
+
+// setHeader sets a request header, or the Host the request is sent with.
+func setHeader(request *http.Request, name, value string) {
+	if strings.EqualFold(name, "Host") {
+		request.Host = value
+		return
+	}
+	request.Header.Set(name, value)
+}
@@ -0,0 +1,59 @@
+package http
+
+import (
+	"io/ioutil"
+	"net"
+	nethttp "net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/zmap/zgrab2"
+)
+
+func TestTemplates(t *testing.T) {
+	handler := nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Write([]byte(r.Host + " " + r.URL.RequestURI() + " " + r.Header.Get("X-Target") + " " + string(body)))
+	})
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	port := uint(server.Listener.Addr().(*net.TCPAddr).Port)
+
+	flags := &Flags{
+		Method:        "POST",
+		Endpoint:      "/{target_host}/{port}",
+		UserAgent:     "zgrab2 test",
+		MaxSize:       256,
+		RequestBody:   `{"ip": "{target_ip}", "sni": "{sni}", "other": "{other}"}`,
+		CustomHeaders: []string{"Host: {target_host}.example", "X-Target: {target_ip}:{port}"},
+	}
+	flags.Port = port
+	flags.Timeout = 2 * time.Second
+	flags.ServerName = "sni.example"
+	if err := flags.Validate(nil); err != nil {
+		t.Fatal(err)
+	}
+	var scanner Scanner
+	if err := scanner.Init(flags); err != nil {
+		t.Fatal(err)
+	}
+	status, ret, err := scanner.Scan(zgrab2.ScanTarget{IP: net.ParseIP("127.0.0.1"), Domain: "localhost"})
+	if status != zgrab2.SCAN_SUCCESS {
+		t.Fatalf("scan failed: %s %v", status, err)
+	}
+	p := strconv.Itoa(int(port))
+	expected := "localhost.example /localhost/" + p + " 127.0.0.1:" + p +
+		` {"ip": "127.0.0.1", "sni": "sni.example", "other": "{other}"}`
+	if body := ret.(*Results).Response.BodyText; body != expected {
+		t.Errorf("expected %q, got %q", expected, body)
+	}
+
+	for _, header := range []string{"no colon", ": value", " : value"} {
+		flags := &Flags{CustomHeaders: []string{header}}
+		if err := flags.Validate(nil); err == nil {
+			t.Errorf("%q: expected an error", header)
+		}
+	}
+}
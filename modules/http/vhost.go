@@ -0,0 +1,111 @@
+package http
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"strings"
+
+	"github.com/zmap/zgrab2"
+	"github.com/zmap/zgrab2/lib/http"
+)
+
+// VHost is the response to the first request sent again with one of the
+// --vhosts as the Host header and TLS server name, without following
+// redirects.
+type VHost struct {
+	Host string `json:"host"`
+
+	*LiteResult
+
+	// Differs is true if the status or body differs from those of the
+	// response to the first request.
+	Differs bool `json:"differs,omitempty"`
+
+	Error string `json:"error,omitempty"`
+}
+
+// loadVHosts returns the --vhosts followed by the names in --vhosts-file,
+// one per line, ignoring blank lines and comments starting with #, without
+// duplicates.
+func loadVHosts(flags *Flags) ([]string, error) {
+	names := strings.Split(flags.VHosts, ",")
+	if flags.VHostsFile != "" {
+		file, err := os.Open(flags.VHostsFile)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			if line := strings.TrimSpace(scanner.Text()); !strings.HasPrefix(line, "#") {
+				names = append(names, line)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+	var ret []string
+	seen := make(map[string]bool)
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" || seen[strings.ToLower(name)] {
+			continue
+		}
+		seen[strings.ToLower(name)] = true
+		ret = append(ret, name)
+	}
+	return ret, nil
+}
+
+// firstResponse returns the response to the first request: the first hop
+// of the redirect chain, if there is one.
+func (scan *scan) firstResponse() *http.Response {
+	if len(scan.results.Redirects) > 0 {
+		return scan.results.Redirects[0].response
+	}
+	return scan.results.Response
+}
+
+// vhosts sends the first request again for each of the --vhosts, each on
+// its own connection to the target, sending the name in the SNI extension
+// unless --server-name or --no-sni is given.
+func (scan *scan) vhosts() {
+	first := scan.firstResponse()
+	for _, vhost := range scan.scanner.vhosts {
+		ret := &VHost{Host: vhost}
+		scan.results.VHosts = append(scan.results.VHosts, ret)
+		resp, err := scan.requestVHost(vhost)
+		if err != nil {
+			ret.Error = err.Error()
+			continue
+		}
+		ret.LiteResult = newLiteResult(resp)
+		if first != nil {
+			ret.Differs = resp.StatusCode != first.StatusCode || !bytes.Equal(resp.BodySHA256, first.BodySHA256)
+		}
+	}
+}
+
+// requestVHost sends the first request with the Host header vhost, and
+// reads the response.
+func (scan *scan) requestVHost(vhost string) (*http.Response, error) {
+	request, err := scan.firstRequest()
+	if err != nil {
+		return nil, err
+	}
+	request.Host = vhost
+	transport := &http.Transport{
+		DisableKeepAlives: true,
+		DialContext:       scan.dialContext,
+		DialTLS:           scan.getTLSDialer(&zgrab2.ScanTarget{Domain: vhost}),
+	}
+	resp, err := transport.RoundTrip(request)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	scan.readBody(resp)
+	return resp, nil
+}
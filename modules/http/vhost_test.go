@@ -0,0 +1,123 @@
+package http
+
+import (
+	"io/ioutil"
+	"net"
+	nethttp "net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zmap/zgrab2"
+)
+
+func TestLoadVHosts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vhosts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "vhosts.txt")
+	if err := ioutil.WriteFile(path, []byte("# sites\nc.example.com\n\nA.example.com\n  d.example.com  \n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	vhosts, err := loadVHosts(&Flags{VHosts: "a.example.com, b.example.com,", VHostsFile: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"a.example.com", "b.example.com", "c.example.com", "d.example.com"}; !reflect.DeepEqual(vhosts, expected) {
+		t.Errorf("got %q, expected %q", vhosts, expected)
+	}
+}
+
+func TestVHosts(t *testing.T) {
+	// The handler runs on the servers' connection goroutines.
+	var mutex sync.Mutex
+	var serverNames []string
+	handler := nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		if r.TLS != nil {
+			mutex.Lock()
+			serverNames = append(serverNames, r.TLS.ServerName)
+			mutex.Unlock()
+		}
+		switch r.Host {
+		case "shop.example.com":
+			w.Write([]byte("<html><title>Shop</title></html>"))
+		case "old.example.com":
+			nethttp.Redirect(w, r, "https://new.example.com/", nethttp.StatusMovedPermanently)
+		default:
+			nethttp.NotFound(w, r)
+		}
+	})
+
+	for _, useHTTPS := range []bool{false, true} {
+		var server *httptest.Server
+		if useHTTPS {
+			server = httptest.NewTLSServer(handler)
+		} else {
+			server = httptest.NewServer(handler)
+		}
+		defer server.Close()
+		mutex.Lock()
+		serverNames = nil
+		mutex.Unlock()
+
+		flags := &Flags{Method: "GET", Endpoint: "/", UserAgent: "zgrab2 test", MaxSize: 256, UseHTTPS: useHTTPS, VHosts: "shop.example.com,old.example.com,default.example.com"}
+		flags.Port = uint(server.Listener.Addr().(*net.TCPAddr).Port)
+		flags.Timeout = 10 * time.Second
+		var scanner Scanner
+		if err := scanner.Init(flags); err != nil {
+			t.Fatal(err)
+		}
+		_, ret, err := scanner.Scan(zgrab2.ScanTarget{IP: net.ParseIP("127.0.0.1")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		vhosts := ret.(*Results).VHosts
+		if len(vhosts) != 3 {
+			t.Fatalf("https %v: wrong vhosts %+v", useHTTPS, vhosts)
+		}
+		shop, old, other := vhosts[0], vhosts[1], vhosts[2]
+		if shop.Host != "shop.example.com" || shop.LiteResult == nil || shop.StatusCode != 200 || shop.Title != "Shop" || !shop.Differs {
+			t.Errorf("https %v: wrong shop vhost %+v", useHTTPS, shop)
+		}
+		if old.LiteResult == nil || old.StatusCode != 301 || old.Location != "https://new.example.com/" || !old.Differs {
+			t.Errorf("https %v: wrong redirecting vhost %+v", useHTTPS, old)
+		}
+		if other.LiteResult == nil || other.StatusCode != 404 || other.Differs {
+			t.Errorf("https %v: wrong default vhost %+v", useHTTPS, other)
+		}
+		if useHTTPS {
+			if shop.TLS == nil || len(shop.TLS.CertificateSHA256) == 0 {
+				t.Errorf("no TLS recorded: %+v", shop.TLS)
+			}
+			mutex.Lock()
+			if expected := []string{"", "shop.example.com", "old.example.com", "default.example.com"}; !reflect.DeepEqual(serverNames, expected) {
+				t.Errorf("got server names %q, expected %q", serverNames, expected)
+			}
+			mutex.Unlock()
+		}
+	}
+
+	// With --lite, the vhosts are recorded beside the LiteResult.
+	flags := &Flags{Method: "GET", Endpoint: "/", UserAgent: "zgrab2 test", MaxSize: 256, Lite: true, LiteBodySize: 16, VHosts: "shop.example.com"}
+	server := httptest.NewServer(handler)
+	flags.Port = uint(server.Listener.Addr().(*net.TCPAddr).Port)
+	flags.Timeout = 10 * time.Second
+	var scanner Scanner
+	if err := scanner.Init(flags); err != nil {
+		t.Fatal(err)
+	}
+	_, ret, err := scanner.Scan(zgrab2.ScanTarget{IP: net.ParseIP("127.0.0.1")})
+	server.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results := ret.(*Results); results.Lite == nil || len(results.VHosts) != 1 || results.VHosts[0].Title != "Shop" {
+		t.Errorf("wrong lite results %+v", results)
+	}
+}
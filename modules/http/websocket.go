@@ -0,0 +1,320 @@
+package http
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zmap/zcrypto/tls"
+	"github.com/zmap/zgrab2/lib/http"
+)
+
+// websocketGUID is appended to the Sec-WebSocket-Key to compute the
+// Sec-WebSocket-Accept the server must answer with (RFC 6455, section 1.3).
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// WebSocket is the outcome of the WebSocket opening handshake, with
+// --websocket.
+type WebSocket struct {
+	URL string `json:"url"`
+
+	// Response is the server's response to the Upgrade request, without
+	// its body.
+	Response *http.Response `json:"response,omitempty"`
+
+	// Upgraded is true if the server switched to WebSocket: it answered
+	// with 101 and the Sec-WebSocket-Accept matching the key sent.
+	Upgraded bool `json:"upgraded"`
+
+	// Subprotocol is the subprotocol the server selected from
+	// --websocket-protocols, and Extensions those it accepted.
+	Subprotocol string   `json:"subprotocol,omitempty"`
+	Extensions  []string `json:"extensions,omitempty"`
+
+	// Frames are the first frames the server sent, up to
+	// --websocket-frames.
+	Frames []*WebSocketFrame `json:"frames,omitempty"`
+
+	Error string `json:"error,omitempty"`
+}
+
+// WebSocketFrame is a frame the server sent.
+type WebSocketFrame struct {
+	// Opcode is continuation, text, binary, close, ping, pong, or the hex
+	// value of a reserved opcode.
+	Opcode string `json:"opcode"`
+	Final  bool   `json:"final"`
+	Length uint64 `json:"length"`
+
+	// Text is the payload of a text frame, and Data that of any other.
+	Text string `json:"text,omitempty"`
+	Data []byte `json:"data,omitempty"`
+
+	// CloseCode and CloseReason are the status of a close frame.
+	CloseCode   uint16 `json:"close_code,omitempty"`
+	CloseReason string `json:"close_reason,omitempty"`
+
+	// Truncated is true if the payload was longer than --max-size.
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+const (
+	websocketOpContinuation = 0x0
+	websocketOpText         = 0x1
+	websocketOpBinary       = 0x2
+	websocketOpClose        = 0x8
+	websocketOpPing         = 0x9
+	websocketOpPong         = 0xa
+)
+
+var websocketOpcodes = map[byte]string{
+	websocketOpContinuation: "continuation",
+	websocketOpText:         "text",
+	websocketOpBinary:       "binary",
+	websocketOpClose:        "close",
+	websocketOpPing:         "ping",
+	websocketOpPong:         "pong",
+}
+
+// websocketAccept returns the Sec-WebSocket-Accept for the key.
+func websocketAccept(key string) string {
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// splitHeaderList returns the comma-separated elements of the header's
+// values.
+func splitHeaderList(values []string) []string {
+	var ret []string
+	for _, value := range values {
+		for _, element := range strings.Split(value, ",") {
+			if element = strings.TrimSpace(element); element != "" {
+				ret = append(ret, element)
+			}
+		}
+	}
+	return ret
+}
+
+// readWebSocketFrame reads a frame, keeping up to limit bytes of its
+// payload. Its payload is only partly read if it is truncated.
+func readWebSocketFrame(r io.Reader, limit int64) (*WebSocketFrame, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	opcode := header[0] & 0xf
+	frame := &WebSocketFrame{
+		Opcode: websocketOpcodes[opcode],
+		Final:  header[0]&0x80 != 0,
+		Length: uint64(header[1] & 0x7f),
+	}
+	if frame.Opcode == "" {
+		frame.Opcode = fmt.Sprintf("0x%x", opcode)
+	}
+	switch frame.Length {
+	case 126:
+		ext := make([]byte, 2)
+		if _, err := io.ReadFull(r, ext); err != nil {
+			return nil, err
+		}
+		frame.Length = uint64(binary.BigEndian.Uint16(ext))
+	case 127:
+		ext := make([]byte, 8)
+		if _, err := io.ReadFull(r, ext); err != nil {
+			return nil, err
+		}
+		frame.Length = binary.BigEndian.Uint64(ext)
+	}
+	// Servers must not mask their frames, but any that do are unmasked.
+	var mask []byte
+	if header[1]&0x80 != 0 {
+		mask = make([]byte, 4)
+		if _, err := io.ReadFull(r, mask); err != nil {
+			return nil, err
+		}
+	}
+	n := frame.Length
+	if limit >= 0 && n > uint64(limit) {
+		n = uint64(limit)
+		frame.Truncated = true
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+	for i := range mask {
+		for j := i; j < len(payload); j += 4 {
+			payload[j] ^= mask[i]
+		}
+	}
+	switch opcode {
+	case websocketOpText:
+		frame.Text = string(payload)
+	case websocketOpClose:
+		if len(payload) >= 2 {
+			frame.CloseCode = binary.BigEndian.Uint16(payload)
+			frame.CloseReason = string(payload[2:])
+		}
+	default:
+		if len(payload) > 0 {
+			frame.Data = payload
+		}
+	}
+	return frame, nil
+}
+
+// websocketCloseFrame returns a masked close frame with status 1000 (normal
+// closure), as clients send.
+func websocketCloseFrame() []byte {
+	mask := make([]byte, 4)
+	rand.Read(mask)
+	frame := []byte{0x80 | websocketOpClose, 0x80 | 2, mask[0], mask[1], mask[2], mask[3], 0x03, 0xe8}
+	for i := 0; i < 2; i++ {
+		frame[6+i] ^= mask[i]
+	}
+	return frame
+}
+
+// websocket opens a WebSocket to the --websocket path on the final URL's
+// origin.
+func (scan *scan) websocket(resp *http.Response) {
+	if resp.Request == nil || resp.Request.URL == nil {
+		return
+	}
+	page := resp.Request.URL
+	u := &url.URL{Scheme: page.Scheme, Host: page.Host}
+	target, err := u.Parse(scan.expand(scan.scanner.config.WebSocket))
+	ret := &WebSocket{URL: u.String()}
+	scan.results.WebSocket = ret
+	if err != nil {
+		ret.Error = err.Error()
+		return
+	}
+	ret.URL = target.String()
+	if err := scan.requestWebSocket(target, ret); err != nil {
+		ret.Error = err.Error()
+	}
+}
+
+func (scan *scan) requestWebSocket(u *url.URL, ret *WebSocket) error {
+	config := scan.scanner.config
+	port := u.Port()
+	if port == "" {
+		port = strconv.Itoa(int(protoToPort[u.Scheme]))
+	}
+	conn, err := scan.dialContext(context.Background(), "tcp", net.JoinHostPort(u.Hostname(), port))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if u.Scheme == "https" {
+		tlsConfig, err := config.TLSFlags.GetTLSConfigForTarget(scan.target)
+		if err != nil {
+			return err
+		}
+		tlsConfig.NextProtos = []string{"http/1.1"}
+		if net.ParseIP(u.Hostname()) == nil {
+			tlsConfig.ServerName = u.Hostname()
+		}
+		tlsConn := tls.Client(conn, tlsConfig)
+		if err := tlsConn.Handshake(); err != nil {
+			return err
+		}
+		conn = tlsConn
+	}
+	conn.SetDeadline(scan.globalDeadline)
+
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	key := base64.StdEncoding.EncodeToString(nonce)
+	request, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("User-Agent", config.UserAgent)
+	request.Header.Set("Upgrade", "websocket")
+	request.Header.Set("Connection", "Upgrade")
+	request.Header.Set("Sec-WebSocket-Key", key)
+	request.Header.Set("Sec-WebSocket-Version", "13")
+	if config.WebSocketProtocols != "" {
+		request.Header.Set("Sec-WebSocket-Protocol", config.WebSocketProtocols)
+	}
+	// Servers commonly refuse cross-origin handshakes, so the page's
+	// origin is sent unless another is given.
+	origin := config.WebSocketOrigin
+	if origin == "" {
+		origin = (&url.URL{Scheme: u.Scheme, Host: u.Host}).String()
+	}
+	request.Header.Set("Origin", scan.expand(origin))
+	if scan.client.Jar != nil {
+		for _, cookie := range scan.client.Jar.Cookies(u) {
+			request.AddCookie(cookie)
+		}
+	}
+	for _, header := range scan.customHeaders() {
+		setHeader(request, header[0], header[1])
+	}
+	if err := request.Write(conn); err != nil {
+		return err
+	}
+
+	reader := bufio.NewReader(conn)
+	response, err := http.ReadResponse(reader, request)
+	if err != nil {
+		return err
+	}
+	ret.Response = response
+	if response.StatusCode != 101 {
+		// The body of a refusal is read, as for any other response.
+		scan.readBody(response)
+		return nil
+	}
+	if !strings.EqualFold(response.Header.Get("Upgrade"), "websocket") {
+		return errors.New("server switched to another protocol than websocket")
+	}
+	if response.Header.Get("Sec-WebSocket-Accept") != websocketAccept(key) {
+		return errors.New("wrong Sec-WebSocket-Accept")
+	}
+	ret.Upgraded = true
+	ret.Subprotocol = response.Header.Get("Sec-WebSocket-Protocol")
+	ret.Extensions = splitHeaderList(response.Header[http.CanonicalHeaderKey("Sec-WebSocket-Extensions")])
+
+	if config.WebSocketFrames > 0 {
+		deadline := time.Now().Add(config.WebSocketWait)
+		if deadline.After(scan.globalDeadline) {
+			deadline = scan.globalDeadline
+		}
+		conn.SetReadDeadline(deadline)
+		for len(ret.Frames) < config.WebSocketFrames {
+			frame, err := readWebSocketFrame(reader, scan.bodyLimit())
+			if err != nil {
+				// Servers that send nothing, or fewer frames than asked
+				// for, are not in error.
+				if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+					break
+				}
+				return err
+			}
+			ret.Frames = append(ret.Frames, frame)
+			if frame.Truncated || frame.Opcode == "close" {
+				return nil
+			}
+		}
+	}
+	conn.Write(websocketCloseFrame())
+	return nil
+}
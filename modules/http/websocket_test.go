@@ -0,0 +1,86 @@
+package http
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"net"
+	nethttp "net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/zmap/zgrab2"
+)
+
+func TestWebSocket(t *testing.T) {
+	handler := nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		if r.URL.Path != "/ws" || r.Header.Get("Upgrade") != "websocket" || r.Header.Get("Sec-WebSocket-Version") != "13" {
+			w.WriteHeader(400)
+			w.Write([]byte("not a websocket"))
+			return
+		}
+		if r.Header.Get("Origin") != "http://"+r.Host || r.Header.Get("X-Token") != "secret" {
+			w.WriteHeader(403)
+			return
+		}
+		conn, rw, err := w.(nethttp.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		sum := sha1.Sum([]byte(r.Header.Get("Sec-WebSocket-Key") + "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"))
+		rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n")
+		rw.WriteString("Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) + "\r\n")
+		rw.WriteString("Sec-WebSocket-Protocol: chat\r\nSec-WebSocket-Extensions: permessage-deflate, x-test\r\n\r\n")
+		rw.Write([]byte{0x81, 5, 'h', 'e', 'l', 'l', 'o'})
+		rw.Write([]byte{0x89, 2, 0xca, 0xfe})
+		rw.Write([]byte{0x88, 4, 0x03, 0xe9, 'b', 'y'})
+		rw.Flush()
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		conn.Read(make([]byte, 16))
+	})
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	flags := &Flags{Method: "GET", Endpoint: "/", UserAgent: "zgrab2 test", MaxSize: 256, WebSocket: "/ws", WebSocketProtocols: "chat, superchat", WebSocketFrames: 5, WebSocketWait: time.Second}
+	flags.CustomHeaders = []string{"X-Token: secret"}
+	flags.Port = uint(server.Listener.Addr().(*net.TCPAddr).Port)
+	flags.Timeout = 2 * time.Second
+	if err := flags.Validate(nil); err != nil {
+		t.Fatal(err)
+	}
+	var scanner Scanner
+	if err := scanner.Init(flags); err != nil {
+		t.Fatal(err)
+	}
+	status, ret, err := scanner.Scan(zgrab2.ScanTarget{IP: net.ParseIP("127.0.0.1")})
+	if status != zgrab2.SCAN_SUCCESS {
+		t.Fatalf("scan failed: %s %v", status, err)
+	}
+	result := ret.(*Results).WebSocket
+	if result == nil || result.Error != "" || !result.Upgraded || result.Response.StatusCode != 101 || result.Subprotocol != "chat" {
+		t.Fatalf("wrong result %+v", result)
+	}
+	if len(result.Extensions) != 2 || result.Extensions[0] != "permessage-deflate" || result.Extensions[1] != "x-test" {
+		t.Errorf("wrong extensions %v", result.Extensions)
+	}
+	if len(result.Frames) != 3 {
+		t.Fatalf("expected 3 frames, got %d", len(result.Frames))
+	}
+	if frame := result.Frames[0]; frame.Opcode != "text" || !frame.Final || frame.Length != 5 || frame.Text != "hello" {
+		t.Errorf("wrong text frame %+v", frame)
+	}
+	if frame := result.Frames[1]; frame.Opcode != "ping" || string(frame.Data) != "\xca\xfe" {
+		t.Errorf("wrong ping frame %+v", frame)
+	}
+	if frame := result.Frames[2]; frame.Opcode != "close" || frame.CloseCode != 1001 || frame.CloseReason != "by" {
+		t.Errorf("wrong close frame %+v", frame)
+	}
+
+	flags.WebSocket = "/other"
+	_, ret, _ = scanner.Scan(zgrab2.ScanTarget{IP: net.ParseIP("127.0.0.1")})
+	result = ret.(*Results).WebSocket
+	if result == nil || result.Error != "" || result.Upgraded || result.Response.StatusCode != 400 || result.Response.BodyText != "not a websocket" {
+		t.Errorf("wrong result for a refused handshake %+v", result)
+	}
+}
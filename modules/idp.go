@@ -0,0 +1,7 @@
+package modules
+
+import "github.com/zmap/zgrab2/modules/idp"
+
+func init() {
+	idp.RegisterModule()
+}
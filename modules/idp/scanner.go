@@ -0,0 +1,647 @@
+// Package idp provides a zgrab2 module that discovers the identity
+// infrastructure a server runs, for measurement studies of single sign-on
+// deployments.
+// Default Port: 443 (TCP)
+//
+// The module fetches the OAuth 2.0 authorization server metadata (RFC 8414)
+// and OpenID Connect discovery documents under /.well-known/, and the usual
+// locations of SAML 2.0 metadata (ADFS, Shibboleth, SimpleSAMLphp,
+// Keycloak and others), over HTTPS (or HTTP, with --use-http). It records
+// the issuer, endpoints and supported algorithms each document gives.
+// Requests share one connection while the server keeps it open.
+package idp
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/zmap/zgrab2"
+)
+
+// Flags holds the command-line configuration for the idp module.
+type Flags struct {
+	zgrab2.BaseFlags
+	zgrab2.TLSFlags
+
+	UseHTTP        bool   `long:"use-http" description:"Fetch over plain HTTP instead of HTTPS"`
+	DiscoveryPaths string `long:"discovery-paths" default:"/.well-known/openid-configuration,/.well-known/oauth-authorization-server" description:"Comma-separated paths of OAuth and OpenID Connect metadata to fetch"`
+	SAMLPaths      string `long:"saml-paths" default:"/FederationMetadata/2007-06/FederationMetadata.xml,/saml/metadata,/saml2/metadata,/idp/shibboleth,/Shibboleth.sso/Metadata,/simplesaml/saml2/idp/metadata.php,/auth/realms/master/protocol/saml/descriptor" description:"Comma-separated paths of SAML metadata to fetch"`
+	UserAgent      string `long:"user-agent" default:"Mozilla/5.0 zgrab/0.x" description:"Set a custom user agent"`
+	MaxSize        int    `long:"max-size" default:"256" description:"Max kilobytes to read of each response"`
+	Verbose        bool   `long:"verbose" description:"More verbose logging, include debug fields in the scan results"`
+}
+
+// Module implements the zgrab2.Module interface.
+type Module struct {
+}
+
+// Scanner implements the zgrab2.Scanner interface.
+type Scanner struct {
+	config         *Flags
+	discoveryPaths []string
+	samlPaths      []string
+}
+
+// Fetch is the response to a request for one of the paths.
+type Fetch struct {
+	Path        string `json:"path"`
+	StatusCode  int    `json:"status_code,omitempty"`
+	Location    string `json:"location,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+
+	// Length is the number of bytes of the body read, and Truncated is true
+	// if there were more than --max-size.
+	Length    int  `json:"length"`
+	Truncated bool `json:"truncated,omitempty"`
+
+	// Error is the reason the request failed, or its response could not be
+	// parsed, if it did.
+	Error string `json:"error,omitempty"`
+}
+
+// OAuthMetadata is an OAuth 2.0 authorization server metadata or OpenID
+// Connect discovery document. The fields have the names they have in the
+// document.
+type OAuthMetadata struct {
+	// Path is where the document was found.
+	Path string `json:"path"`
+
+	Issuer                      string `json:"issuer,omitempty"`
+	AuthorizationEndpoint       string `json:"authorization_endpoint,omitempty"`
+	TokenEndpoint               string `json:"token_endpoint,omitempty"`
+	UserinfoEndpoint            string `json:"userinfo_endpoint,omitempty"`
+	JWKSURI                     string `json:"jwks_uri,omitempty"`
+	RegistrationEndpoint        string `json:"registration_endpoint,omitempty"`
+	IntrospectionEndpoint       string `json:"introspection_endpoint,omitempty"`
+	RevocationEndpoint          string `json:"revocation_endpoint,omitempty"`
+	EndSessionEndpoint          string `json:"end_session_endpoint,omitempty"`
+	DeviceAuthorizationEndpoint string `json:"device_authorization_endpoint,omitempty"`
+
+	ScopesSupported                            []string `json:"scopes_supported,omitempty"`
+	ResponseTypesSupported                     []string `json:"response_types_supported,omitempty"`
+	ResponseModesSupported                     []string `json:"response_modes_supported,omitempty"`
+	GrantTypesSupported                        []string `json:"grant_types_supported,omitempty"`
+	SubjectTypesSupported                      []string `json:"subject_types_supported,omitempty"`
+	CodeChallengeMethodsSupported              []string `json:"code_challenge_methods_supported,omitempty"`
+	TokenEndpointAuthMethodsSupported          []string `json:"token_endpoint_auth_methods_supported,omitempty"`
+	TokenEndpointAuthSigningAlgValuesSupported []string `json:"token_endpoint_auth_signing_alg_values_supported,omitempty"`
+	IDTokenSigningAlgValuesSupported           []string `json:"id_token_signing_alg_values_supported,omitempty"`
+	IDTokenEncryptionAlgValuesSupported        []string `json:"id_token_encryption_alg_values_supported,omitempty"`
+	UserinfoSigningAlgValuesSupported          []string `json:"userinfo_signing_alg_values_supported,omitempty"`
+	RequestObjectSigningAlgValuesSupported     []string `json:"request_object_signing_alg_values_supported,omitempty"`
+	DPoPSigningAlgValuesSupported              []string `json:"dpop_signing_alg_values_supported,omitempty"`
+	ClaimsSupported                            []string `json:"claims_supported,omitempty"`
+}
+
+// SAMLMetadata is a SAML 2.0 metadata document.
+type SAMLMetadata struct {
+	// Path is where the document was found.
+	Path string `json:"path"`
+
+	ValidUntil string `json:"valid_until,omitempty"`
+
+	// SignatureAlgorithm and DigestAlgorithm are the algorithms of the
+	// document's own signature, if it is signed.
+	SignatureAlgorithm string `json:"signature_algorithm,omitempty"`
+	DigestAlgorithm    string `json:"digest_algorithm,omitempty"`
+
+	// Entities are the entities the document describes: one, unless it is
+	// an EntitiesDescriptor, as federations publish.
+	Entities []*SAMLEntity `json:"entities"`
+}
+
+// SAMLEntity is a SAML entity: an identity provider, a service provider,
+// or both.
+type SAMLEntity struct {
+	EntityID string `json:"entity_id"`
+
+	IDP *SAMLRole `json:"idp,omitempty"`
+	SP  *SAMLRole `json:"sp,omitempty"`
+
+	// SigningMethods and DigestMethods are the algorithms the entity
+	// supports, from its metadata extensions (SAML V2.0 Metadata Profile
+	// for Algorithm Support).
+	SigningMethods []string `json:"signing_methods,omitempty"`
+	DigestMethods  []string `json:"digest_methods,omitempty"`
+}
+
+// SAMLRole is an IDPSSODescriptor or SPSSODescriptor.
+type SAMLRole struct {
+	ProtocolSupport string `json:"protocol_support,omitempty"`
+
+	// WantAuthnRequestsSigned is set by identity providers;
+	// AuthnRequestsSigned and WantAssertionsSigned by service providers.
+	WantAuthnRequestsSigned bool `json:"want_authn_requests_signed,omitempty"`
+	AuthnRequestsSigned     bool `json:"authn_requests_signed,omitempty"`
+	WantAssertionsSigned    bool `json:"want_assertions_signed,omitempty"`
+
+	NameIDFormats             []string        `json:"name_id_formats,omitempty"`
+	SingleSignOnServices      []*SAMLEndpoint `json:"single_sign_on_services,omitempty"`
+	SingleLogoutServices      []*SAMLEndpoint `json:"single_logout_services,omitempty"`
+	AssertionConsumerServices []*SAMLEndpoint `json:"assertion_consumer_services,omitempty"`
+
+	Keys []*SAMLKey `json:"keys,omitempty"`
+
+	// EncryptionMethods are the algorithms the role's encryption keys are
+	// for.
+	EncryptionMethods []string `json:"encryption_methods,omitempty"`
+}
+
+// SAMLEndpoint is a service's binding and location.
+type SAMLEndpoint struct {
+	Binding  string `json:"binding"`
+	Location string `json:"location"`
+}
+
+// SAMLKey is a certificate in a KeyDescriptor.
+type SAMLKey struct {
+	// Use is signing or encryption, or empty if the key is for both.
+	Use string `json:"use,omitempty"`
+
+	FingerprintSHA256  string     `json:"fingerprint_sha256"`
+	CommonName         string     `json:"common_name,omitempty"`
+	NotAfter           *time.Time `json:"not_after,omitempty"`
+	SignatureAlgorithm string     `json:"signature_algorithm,omitempty"`
+
+	// Error is the reason the certificate could not be parsed, if it could
+	// not.
+	Error string `json:"error,omitempty"`
+}
+
+// Results is the output of the idp module.
+type Results struct {
+	// Fetches are the responses to the requests for each path.
+	Fetches []*Fetch `json:"fetches,omitempty"`
+
+	// OAuth are the OAuth and OpenID Connect metadata documents found.
+	OAuth []*OAuthMetadata `json:"oauth,omitempty"`
+
+	// SAML are the SAML metadata documents found.
+	SAML []*SAMLMetadata `json:"saml,omitempty"`
+
+	// TLS is the log of the first handshake.
+	TLS *zgrab2.TLSLog `json:"tls,omitempty"`
+}
+
+// RegisterModule registers the zgrab2 module.
+func RegisterModule() {
+	var module Module
+	_, err := zgrab2.AddCommand("idp", "Identity provider metadata", "Fetch OAuth, OpenID Connect and SAML metadata", 443, &module)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// NewFlags returns a default Flags object.
+func (module *Module) NewFlags() interface{} {
+	return new(Flags)
+}
+
+// NewScanner returns a new Scanner instance.
+func (module *Module) NewScanner() zgrab2.Scanner {
+	return new(Scanner)
+}
+
+// Validate checks that the flags are valid.
+// On success, returns nil.
+// On failure, returns an error instance describing the error.
+func (flags *Flags) Validate(args []string) error {
+	if flags.MaxSize <= 0 {
+		return errors.New("--max-size must be positive")
+	}
+	if len(splitPaths(flags.DiscoveryPaths)) == 0 && len(splitPaths(flags.SAMLPaths)) == 0 {
+		return errors.New("no --discovery-paths or --saml-paths to fetch")
+	}
+	return nil
+}
+
+// Help returns the module's help string.
+func (flags *Flags) Help() string {
+	return ""
+}
+
+// splitPaths splits a comma-separated list of paths.
+func splitPaths(s string) []string {
+	var ret []string
+	for _, path := range strings.Split(s, ",") {
+		if path = strings.TrimSpace(path); path != "" {
+			ret = append(ret, "/"+strings.TrimLeft(path, "/"))
+		}
+	}
+	return ret
+}
+
+// Init initializes the Scanner.
+func (scanner *Scanner) Init(flags zgrab2.ScanFlags) error {
+	f, ok := flags.(*Flags)
+	if !ok {
+		return zgrab2.ErrMismatchedFlags
+	}
+	scanner.config = f
+	if f.Verbose {
+		log.SetLevel(log.DebugLevel)
+	}
+	scanner.discoveryPaths = splitPaths(f.DiscoveryPaths)
+	scanner.samlPaths = splitPaths(f.SAMLPaths)
+	return nil
+}
+
+// InitPerSender initializes the scanner for a given sender.
+func (scanner *Scanner) InitPerSender(senderID int) error {
+	return nil
+}
+
+// GetName returns the Scanner name defined in the Flags.
+func (scanner *Scanner) GetName() string {
+	return scanner.config.Name
+}
+
+// GetTrigger returns the Trigger defined in the Flags.
+func (scanner *Scanner) GetTrigger() string {
+	return scanner.config.Trigger
+}
+
+// Protocol returns the protocol identifier of the scan.
+func (scanner *Scanner) Protocol() string {
+	return "idp"
+}
+
+// GetPort returns the port being scanned.
+func (scanner *Scanner) GetPort() uint {
+	return scanner.config.Port
+}
+
+// Scan fetches each of the paths, and parses the metadata documents found.
+// It fails only if no request got a response.
+func (scanner *Scanner) Scan(target zgrab2.ScanTarget) (zgrab2.ScanStatus, interface{}, error) {
+	result := new(Results)
+	c := &client{scanner: scanner, target: &target}
+	defer c.close()
+	var firstErr error
+	reached := false
+	fetchAll := func(paths []string, parse func(fetch *Fetch, body []byte) error) {
+		for _, path := range paths {
+			fetch, body, err := c.get(path)
+			result.Fetches = append(result.Fetches, fetch)
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				continue
+			}
+			reached = true
+			if fetch.StatusCode == http.StatusOK {
+				if err := parse(fetch, body); err != nil {
+					fetch.Error = err.Error()
+				}
+			}
+		}
+	}
+	fetchAll(scanner.discoveryPaths, func(fetch *Fetch, body []byte) error {
+		metadata, err := parseOAuthMetadata(body)
+		if metadata != nil {
+			metadata.Path = fetch.Path
+			result.OAuth = append(result.OAuth, metadata)
+		}
+		return err
+	})
+	fetchAll(scanner.samlPaths, func(fetch *Fetch, body []byte) error {
+		metadata, err := parseSAMLMetadata(body)
+		if metadata != nil {
+			metadata.Path = fetch.Path
+			result.SAML = append(result.SAML, metadata)
+		}
+		return err
+	})
+	result.TLS = c.tls
+	if !reached {
+		return zgrab2.TryGetScanStatus(firstErr), result, firstErr
+	}
+	return zgrab2.SCAN_SUCCESS, result, nil
+}
+
+// client makes HTTP requests to the target, over one connection for as long
+// as the server keeps it open.
+type client struct {
+	scanner *Scanner
+	target  *zgrab2.ScanTarget
+	conn    net.Conn
+	reader  *bufio.Reader
+
+	// failed is set once a connection could not be opened, after which no
+	// more are tried.
+	failed error
+
+	// tls is the log of the first TLS handshake.
+	tls *zgrab2.TLSLog
+}
+
+func (c *client) open() error {
+	if c.failed != nil {
+		return c.failed
+	}
+	config := c.scanner.config
+	var conn net.Conn
+	var err error
+	if config.UseHTTP {
+		conn, err = c.target.Open(&config.BaseFlags)
+	} else {
+		var tlsConn *zgrab2.TLSConnection
+		tlsConn, err = c.target.OpenTLS(&config.BaseFlags, &config.TLSFlags)
+		if tlsConn != nil {
+			if c.tls == nil && tlsConn.GetLog().HandshakeLog != nil {
+				c.tls = tlsConn.GetLog()
+			}
+			if err != nil {
+				tlsConn.Close()
+			}
+		}
+		conn = tlsConn
+	}
+	if err != nil {
+		c.failed = err
+		return err
+	}
+	c.conn, c.reader = conn, bufio.NewReader(conn)
+	return nil
+}
+
+func (c *client) close() {
+	if c.conn != nil {
+		c.conn.Close()
+		c.conn = nil
+	}
+}
+
+// get requests the path, reconnecting if the server has closed the
+// connection. The error is only returned if the server could not be reached
+// or did not answer in HTTP; it is also recorded in the Fetch.
+func (c *client) get(path string) (*Fetch, []byte, error) {
+	fetch := &Fetch{Path: path}
+	reused := c.conn != nil
+	if !reused {
+		if err := c.open(); err != nil {
+			fetch.Error = err.Error()
+			return fetch, nil, err
+		}
+	}
+	body, err := c.roundTrip(fetch)
+	if err != nil && reused {
+		// The server closed the connection it had kept open.
+		c.close()
+		if err = c.open(); err == nil {
+			body, err = c.roundTrip(fetch)
+		}
+	}
+	if err != nil {
+		c.close()
+		fetch.Error = err.Error()
+		return fetch, nil, err
+	}
+	return fetch, body, nil
+}
+
+// roundTrip sends the request for the Fetch's path on the open connection,
+// and reads the response into it, closing the connection if it cannot be
+// reused.
+func (c *client) roundTrip(fetch *Fetch) ([]byte, error) {
+	config := c.scanner.config
+	c.conn.SetDeadline(time.Now().Add(c.target.BoundTimeout(config.Timeout)))
+	host := c.target.Domain
+	if host == "" {
+		host = c.target.IP.String()
+	}
+	port := config.Port
+	if c.target.Port != nil {
+		port = *c.target.Port
+	}
+	if (config.UseHTTP && port != 80) || (!config.UseHTTP && port != 443) || strings.Contains(host, ":") {
+		host = net.JoinHostPort(host, strconv.Itoa(int(port)))
+	}
+	request := fmt.Sprintf("GET %s HTTP/1.1\r\nHost: %s\r\nUser-Agent: %s\r\nAccept: application/json, application/samlmetadata+xml, application/xml, */*\r\n\r\n", fetch.Path, host, config.UserAgent)
+	if _, err := io.WriteString(c.conn, request); err != nil {
+		return nil, err
+	}
+	response, err := http.ReadResponse(c.reader, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	fetch.StatusCode = response.StatusCode
+	fetch.Location = response.Header.Get("Location")
+	fetch.ContentType = response.Header.Get("Content-Type")
+	maxSize := config.MaxSize * 1024
+	body, err := ioutil.ReadAll(io.LimitReader(response.Body, int64(maxSize)+1))
+	if len(body) > maxSize {
+		body, fetch.Truncated = body[:maxSize], true
+	}
+	fetch.Length = len(body)
+	if err != nil || fetch.Truncated || response.Close {
+		c.close()
+	}
+	if err != nil {
+		fetch.Error = err.Error()
+	}
+	return body, nil
+}
+
+// parseOAuthMetadata parses an OAuth or OpenID Connect metadata document.
+// It returns nil if the body is not one, and the metadata along with the
+// error if some fields could not be parsed.
+func parseOAuthMetadata(body []byte) (*OAuthMetadata, error) {
+	ret := new(OAuthMetadata)
+	err := json.Unmarshal(body, ret)
+	if _, ok := err.(*json.UnmarshalTypeError); err != nil && !ok {
+		return nil, fmt.Errorf("invalid metadata: %v", err)
+	}
+	ret.Path = ""
+	if ret.Issuer == "" && ret.AuthorizationEndpoint == "" && ret.TokenEndpoint == "" {
+		return nil, errors.New("not OAuth metadata")
+	}
+	if err != nil {
+		return ret, fmt.Errorf("invalid metadata: %v", err)
+	}
+	return ret, nil
+}
+
+// The parts of SAML metadata (and of XML signatures) recorded. Elements are
+// matched in any namespace.
+type samlAlgorithmXML struct {
+	Algorithm string `xml:"Algorithm,attr"`
+}
+
+type samlEndpointXML struct {
+	Binding  string `xml:"Binding,attr"`
+	Location string `xml:"Location,attr"`
+}
+
+type samlKeyXML struct {
+	Use               string             `xml:"use,attr"`
+	Certificates      []string           `xml:"KeyInfo>X509Data>X509Certificate"`
+	EncryptionMethods []samlAlgorithmXML `xml:"EncryptionMethod"`
+}
+
+type samlRoleXML struct {
+	ProtocolSupport           string             `xml:"protocolSupportEnumeration,attr"`
+	WantAuthnRequestsSigned   string             `xml:"WantAuthnRequestsSigned,attr"`
+	AuthnRequestsSigned       string             `xml:"AuthnRequestsSigned,attr"`
+	WantAssertionsSigned      string             `xml:"WantAssertionsSigned,attr"`
+	SigningMethods            []samlAlgorithmXML `xml:"Extensions>SigningMethod"`
+	DigestMethods             []samlAlgorithmXML `xml:"Extensions>DigestMethod"`
+	Keys                      []samlKeyXML       `xml:"KeyDescriptor"`
+	NameIDFormats             []string           `xml:"NameIDFormat"`
+	SingleSignOnServices      []samlEndpointXML  `xml:"SingleSignOnService"`
+	SingleLogoutServices      []samlEndpointXML  `xml:"SingleLogoutService"`
+	AssertionConsumerServices []samlEndpointXML  `xml:"AssertionConsumerService"`
+}
+
+// samlDescriptorXML is an EntityDescriptor or EntitiesDescriptor.
+type samlDescriptorXML struct {
+	XMLName            xml.Name
+	EntityID           string              `xml:"entityID,attr"`
+	ValidUntil         string              `xml:"validUntil,attr"`
+	SignatureAlgorithm samlAlgorithmXML    `xml:"Signature>SignedInfo>SignatureMethod"`
+	DigestAlgorithm    samlAlgorithmXML    `xml:"Signature>SignedInfo>Reference>DigestMethod"`
+	SigningMethods     []samlAlgorithmXML  `xml:"Extensions>SigningMethod"`
+	DigestMethods      []samlAlgorithmXML  `xml:"Extensions>DigestMethod"`
+	IDP                *samlRoleXML        `xml:"IDPSSODescriptor"`
+	SP                 *samlRoleXML        `xml:"SPSSODescriptor"`
+	Entities           []samlDescriptorXML `xml:"EntityDescriptor"`
+	Groups             []samlDescriptorXML `xml:"EntitiesDescriptor"`
+}
+
+// parseSAMLMetadata parses a SAML metadata document. It returns nil if the
+// body is not one.
+func parseSAMLMetadata(body []byte) (*SAMLMetadata, error) {
+	var root samlDescriptorXML
+	if err := xml.Unmarshal(body, &root); err != nil {
+		return nil, fmt.Errorf("invalid metadata: %v", err)
+	}
+	ret := &SAMLMetadata{
+		ValidUntil:         root.ValidUntil,
+		SignatureAlgorithm: root.SignatureAlgorithm.Algorithm,
+		DigestAlgorithm:    root.DigestAlgorithm.Algorithm,
+	}
+	switch root.XMLName.Local {
+	case "EntityDescriptor":
+		ret.Entities = append(ret.Entities, samlEntity(&root))
+	case "EntitiesDescriptor":
+		ret.Entities = samlEntities(&root, ret.Entities)
+	default:
+		return nil, errors.New("not SAML metadata")
+	}
+	return ret, nil
+}
+
+// samlEntities appends the entities in the EntitiesDescriptor, and in those
+// nested in it, to ret.
+func samlEntities(group *samlDescriptorXML, ret []*SAMLEntity) []*SAMLEntity {
+	for i := range group.Entities {
+		ret = append(ret, samlEntity(&group.Entities[i]))
+	}
+	for i := range group.Groups {
+		ret = samlEntities(&group.Groups[i], ret)
+	}
+	return ret
+}
+
+func samlEntity(entity *samlDescriptorXML) *SAMLEntity {
+	ret := &SAMLEntity{
+		EntityID:       entity.EntityID,
+		SigningMethods: samlAlgorithms(entity.SigningMethods),
+		DigestMethods:  samlAlgorithms(entity.DigestMethods),
+	}
+	for _, role := range []*samlRoleXML{entity.IDP, entity.SP} {
+		if role != nil {
+			ret.SigningMethods = append(ret.SigningMethods, samlAlgorithms(role.SigningMethods)...)
+			ret.DigestMethods = append(ret.DigestMethods, samlAlgorithms(role.DigestMethods)...)
+		}
+	}
+	if entity.IDP != nil {
+		ret.IDP = samlRole(entity.IDP)
+	}
+	if entity.SP != nil {
+		ret.SP = samlRole(entity.SP)
+	}
+	return ret
+}
+
+func samlAlgorithms(algorithms []samlAlgorithmXML) []string {
+	var ret []string
+	for _, a := range algorithms {
+		ret = append(ret, a.Algorithm)
+	}
+	return ret
+}
+
+func samlEndpoints(endpoints []samlEndpointXML) []*SAMLEndpoint {
+	var ret []*SAMLEndpoint
+	for _, e := range endpoints {
+		ret = append(ret, &SAMLEndpoint{Binding: e.Binding, Location: e.Location})
+	}
+	return ret
+}
+
+// xmlBoolean parses an xs:boolean.
+func xmlBoolean(s string) bool {
+	s = strings.TrimSpace(s)
+	return s == "true" || s == "1"
+}
+
+func samlRole(role *samlRoleXML) *SAMLRole {
+	ret := &SAMLRole{
+		ProtocolSupport:           role.ProtocolSupport,
+		WantAuthnRequestsSigned:   xmlBoolean(role.WantAuthnRequestsSigned),
+		AuthnRequestsSigned:       xmlBoolean(role.AuthnRequestsSigned),
+		WantAssertionsSigned:      xmlBoolean(role.WantAssertionsSigned),
+		SingleSignOnServices:      samlEndpoints(role.SingleSignOnServices),
+		SingleLogoutServices:      samlEndpoints(role.SingleLogoutServices),
+		AssertionConsumerServices: samlEndpoints(role.AssertionConsumerServices),
+	}
+	for _, format := range role.NameIDFormats {
+		ret.NameIDFormats = append(ret.NameIDFormats, strings.TrimSpace(format))
+	}
+	for _, key := range role.Keys {
+		for _, certificate := range key.Certificates {
+			ret.Keys = append(ret.Keys, samlKey(key.Use, certificate))
+		}
+		ret.EncryptionMethods = append(ret.EncryptionMethods, samlAlgorithms(key.EncryptionMethods)...)
+	}
+	return ret
+}
+
+// samlKey parses the base64-encoded certificate.
+func samlKey(use, certificate string) *SAMLKey {
+	ret := &SAMLKey{Use: use}
+	raw, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(certificate), ""))
+	if err != nil {
+		ret.Error = err.Error()
+		return ret
+	}
+	fingerprint := sha256.Sum256(raw)
+	ret.FingerprintSHA256 = hex.EncodeToString(fingerprint[:])
+	cert, err := x509.ParseCertificate(raw)
+	if err != nil {
+		ret.Error = err.Error()
+		return ret
+	}
+	ret.CommonName = cert.Subject.CommonName
+	ret.NotAfter = &cert.NotAfter
+	ret.SignatureAlgorithm = cert.SignatureAlgorithm.String()
+	return ret
+}
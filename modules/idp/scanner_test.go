@@ -0,0 +1,172 @@
+package idp
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/zmap/zgrab2"
+)
+
+const testOpenIDConfiguration = `{
+  "issuer": "https://login.example.com",
+  "authorization_endpoint": "https://login.example.com/authorize",
+  "token_endpoint": "https://login.example.com/token",
+  "jwks_uri": "https://login.example.com/keys",
+  "response_types_supported": ["code", "id_token"],
+  "id_token_signing_alg_values_supported": ["RS256", "ES256"],
+  "code_challenge_methods_supported": ["S256"]
+}`
+
+const testSAMLMetadata = `<?xml version="1.0"?>
+<md:EntityDescriptor xmlns:md="urn:oasis:names:tc:SAML:2.0:metadata" xmlns:ds="http://www.w3.org/2000/09/xmldsig#" xmlns:alg="urn:oasis:names:tc:SAML:metadata:algsupport" entityID="https://login.example.com/idp" validUntil="2030-01-01T00:00:00Z">
+  <ds:Signature>
+    <ds:SignedInfo>
+      <ds:SignatureMethod Algorithm="http://www.w3.org/2001/04/xmldsig-more#rsa-sha256"/>
+      <ds:Reference URI=""><ds:DigestMethod Algorithm="http://www.w3.org/2001/04/xmlenc#sha256"/></ds:Reference>
+    </ds:SignedInfo>
+  </ds:Signature>
+  <md:Extensions>
+    <alg:DigestMethod Algorithm="http://www.w3.org/2001/04/xmlenc#sha256"/>
+    <alg:SigningMethod Algorithm="http://www.w3.org/2001/04/xmldsig-more#rsa-sha256"/>
+  </md:Extensions>
+  <md:IDPSSODescriptor WantAuthnRequestsSigned="true" protocolSupportEnumeration="urn:oasis:names:tc:SAML:2.0:protocol">
+    <md:KeyDescriptor use="signing">
+      <ds:KeyInfo><ds:X509Data><ds:X509Certificate>
+        CERTIFICATE
+      </ds:X509Certificate></ds:X509Data></ds:KeyInfo>
+    </md:KeyDescriptor>
+    <md:NameIDFormat>urn:oasis:names:tc:SAML:2.0:nameid-format:persistent</md:NameIDFormat>
+    <md:SingleSignOnService Binding="urn:oasis:names:tc:SAML:2.0:bindings:HTTP-Redirect" Location="https://login.example.com/sso"/>
+  </md:IDPSSODescriptor>
+</md:EntityDescriptor>`
+
+// testCertificate returns a self-signed certificate, base64-encoded.
+func testCertificate(t *testing.T) string {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "login.example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	raw, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(raw)
+}
+
+func TestParseSAMLMetadata(t *testing.T) {
+	body := []byte(testSAMLMetadata)
+	metadata, err := parseSAMLMetadata(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metadata.SignatureAlgorithm != "http://www.w3.org/2001/04/xmldsig-more#rsa-sha256" || metadata.ValidUntil != "2030-01-01T00:00:00Z" || len(metadata.Entities) != 1 {
+		t.Fatalf("wrong metadata %+v", metadata)
+	}
+	entity := metadata.Entities[0]
+	if entity.EntityID != "https://login.example.com/idp" || entity.SP != nil || entity.IDP == nil {
+		t.Fatalf("wrong entity %+v", entity)
+	}
+	if !reflect.DeepEqual(entity.SigningMethods, []string{"http://www.w3.org/2001/04/xmldsig-more#rsa-sha256"}) || len(entity.DigestMethods) != 1 {
+		t.Errorf("wrong algorithms %+v", entity)
+	}
+	idp := entity.IDP
+	if !idp.WantAuthnRequestsSigned || len(idp.SingleSignOnServices) != 1 || idp.SingleSignOnServices[0].Location != "https://login.example.com/sso" || idp.NameIDFormats[0] != "urn:oasis:names:tc:SAML:2.0:nameid-format:persistent" {
+		t.Errorf("wrong role %+v", idp)
+	}
+	// The placeholder is not a certificate.
+	if len(idp.Keys) != 1 || idp.Keys[0].Use != "signing" || idp.Keys[0].Error == "" {
+		t.Errorf("wrong keys %+v", idp.Keys)
+	}
+
+	federation := []byte(`<EntitiesDescriptor xmlns="urn:oasis:names:tc:SAML:2.0:metadata"><EntityDescriptor entityID="a"/><EntitiesDescriptor><EntityDescriptor entityID="b"/></EntitiesDescriptor></EntitiesDescriptor>`)
+	if metadata, err = parseSAMLMetadata(federation); err != nil || len(metadata.Entities) != 2 || metadata.Entities[1].EntityID != "b" {
+		t.Errorf("wrong federation metadata %+v, error %v", metadata, err)
+	}
+	if _, err := parseSAMLMetadata([]byte("<html><body>Not found</body></html>")); err == nil {
+		t.Error("HTML page accepted")
+	}
+}
+
+func TestScan(t *testing.T) {
+	certificate := testCertificate(t)
+	var connections int32
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(testOpenIDConfiguration))
+		case "/FederationMetadata/2007-06/FederationMetadata.xml":
+			w.Header().Set("Content-Type", "application/samlmetadata+xml")
+			w.Write([]byte(strings.Replace(testSAMLMetadata, "CERTIFICATE", certificate, 1)))
+		case "/saml/metadata":
+			w.Write([]byte("<html><body>Sign in</body></html>"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&connections, 1)
+		}
+	}
+	server.Start()
+	defer server.Close()
+
+	port := server.Listener.Addr().(*net.TCPAddr).Port
+	flags := &Flags{
+		UseHTTP:        true,
+		DiscoveryPaths: "/.well-known/openid-configuration,.well-known/oauth-authorization-server",
+		SAMLPaths:      "/FederationMetadata/2007-06/FederationMetadata.xml,/saml/metadata",
+		UserAgent:      "zgrab2 test",
+		MaxSize:        256,
+	}
+	flags.Port = uint(port)
+	flags.Timeout = time.Second
+	if err := flags.Validate(nil); err != nil {
+		t.Fatalf("invalid flags: %v", err)
+	}
+	var scanner Scanner
+	if err := scanner.Init(flags); err != nil {
+		t.Fatalf("could not initialize scanner: %v", err)
+	}
+	status, ret, err := scanner.Scan(zgrab2.ScanTarget{IP: net.ParseIP("127.0.0.1"), Domain: "login.example.com"})
+	if status != zgrab2.SCAN_SUCCESS {
+		t.Fatalf("scan failed: %s %v", status, err)
+	}
+	result := ret.(*Results)
+	if len(result.Fetches) != 4 || result.Fetches[1].Path != "/.well-known/oauth-authorization-server" || result.Fetches[1].StatusCode != 404 || result.Fetches[3].Error != "not SAML metadata" {
+		t.Errorf("wrong fetches %+v", result.Fetches)
+	}
+	if len(result.OAuth) != 1 || result.OAuth[0].Issuer != "https://login.example.com" || result.OAuth[0].Path != "/.well-known/openid-configuration" || !reflect.DeepEqual(result.OAuth[0].IDTokenSigningAlgValuesSupported, []string{"RS256", "ES256"}) {
+		t.Errorf("wrong OAuth metadata %+v", result.OAuth)
+	}
+	if len(result.SAML) != 1 || len(result.SAML[0].Entities) != 1 {
+		t.Fatalf("wrong SAML metadata %+v", result.SAML)
+	}
+	keys := result.SAML[0].Entities[0].IDP.Keys
+	if len(keys) != 1 || keys[0].CommonName != "login.example.com" || keys[0].Error != "" || len(keys[0].FingerprintSHA256) != 64 {
+		t.Errorf("wrong keys %+v", keys)
+	}
+	if n := atomic.LoadInt32(&connections); n != 1 {
+		t.Errorf("%d connections, expected 1", n)
+	}
+}
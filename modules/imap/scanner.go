@@ -135,6 +135,18 @@ func (scanner *Scanner) Protocol() string {
 	return "imap"
 }
 
+// HandsOffConnection returns true if the scanner leaves the connection in
+// plaintext, without sending CLOSE, so that it can be passed on with --share-connection.
+func (scanner *Scanner) HandsOffConnection() bool {
+	return !scanner.config.IMAPSecure && !scanner.config.StartTLS && !scanner.config.SendCLOSE
+}
+
+// TakesOverConnection returns false, since the scan starts by reading the
+// server's banner.
+func (scanner *Scanner) TakesOverConnection() bool {
+	return false
+}
+
 // GetPort returns the port being scanned.
 func (scanner *Scanner) GetPort() uint {
 	return scanner.config.Port
@@ -442,7 +442,7 @@ func (scanner *Scanner) augmentWithCUPSData(scan *scan, target *zgrab2.ScanTarge
 	// Store data into BodyText and BodySHA256 of cupsResp
 	storeBody(cupsResp, scanner)
 	if versionNotSupported(scan.results.CUPSResponse.BodyText) {
-		return zgrab2.NewScanError(zgrab2.SCAN_APPLICATION_ERROR, ErrVersionNotSupported)
+		return zgrab2.NewRejectionError(zgrab2.REJECTED_VERSION_UNSUPPORTED, ErrVersionNotSupported)
 	}
 
 	if err := scanner.tryReadAttributes(scan.results.CUPSResponse, scan); err != nil {
@@ -527,7 +527,7 @@ func (scanner *Scanner) Grab(scan *scan, target *zgrab2.ScanTarget, version *ver
 	}
 	storeBody(resp, scanner)
 	if versionNotSupported(scan.results.Response.BodyText) {
-		return zgrab2.NewScanError(zgrab2.SCAN_APPLICATION_ERROR, ErrVersionNotSupported)
+		return zgrab2.NewRejectionError(zgrab2.REJECTED_VERSION_UNSUPPORTED, ErrVersionNotSupported)
 	}
 
 	protocols := strings.Split(resp.Header.Get("Server"), " ")
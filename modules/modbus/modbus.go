@@ -143,7 +143,7 @@ type ModbusEvent struct {
 	Function FunctionCode `json:"function_code"`
 
 	// Response is the response data (not including the function code).
-	Response []byte `json:"raw_response,omitempty"`
+	Response []byte `json:"raw_response,omitempty" zgrab:"raw"`
 
 	// MEIResponse is the parsed response; it is present if the response was decoded successfully and there was no
 	// exception.
@@ -154,7 +154,7 @@ type ModbusEvent struct {
 	ExceptionResponse *ExceptionResponse `json:"exception_response,omitempty"`
 
 	// Raw is the full raw response from the server, including the header.
-	Raw []byte `json:"raw,omitempty"`
+	Raw []byte `json:"raw,omitempty" zgrab:"raw"`
 }
 
 // IsException returns true if this response indicates an exception has occurred.
@@ -305,6 +305,39 @@ func (c *Conn) MarshalRequest(r *ModbusRequest) (data []byte, err error) {
 	return
 }
 
+// ModbusFunctionReportServerID identifies the Report Server ID function, which, like Read Device Identification,
+// only describes the device.
+var ModbusFunctionReportServerID = FunctionCode(0x11)
+
+// checkRequests returns an error unless each Modbus/TCP request in the data is an identification request: Read
+// Device Identification (0x2B/0x0E) or Report Server ID (0x11).
+func checkRequests(data []byte) error {
+	if len(data) == 0 {
+		return fmt.Errorf("empty request")
+	}
+	for len(data) > 0 {
+		if len(data) < 8 {
+			return fmt.Errorf("truncated request header")
+		}
+		if protocolID := binary.BigEndian.Uint16(data[2:4]); protocolID != 0 {
+			return fmt.Errorf("protocol ID 0x%04x is not Modbus", protocolID)
+		}
+		end := 6 + int(binary.BigEndian.Uint16(data[4:6]))
+		if end < 8 || end > len(data) {
+			return fmt.Errorf("invalid request length")
+		}
+		pdu := data[7:end]
+		switch function := FunctionCode(pdu[0]); {
+		case function == ModbusFunctionEncapsulatedInterface && len(pdu) > 1 && pdu[1] == 0x0E:
+		case function == ModbusFunctionReportServerID && len(pdu) == 1:
+		default:
+			return fmt.Errorf("function code 0x%02x is not an identification request", byte(function))
+		}
+		data = data[end:]
+	}
+	return nil
+}
+
 // ModbusResponse wraps the data returned by the server in response to the ModbusRequest.
 type ModbusResponse struct {
 	// Length is the number of bytes the server says it will return.
@@ -113,6 +113,12 @@ func (scanner *Scanner) Protocol() string {
 	return "modbus"
 }
 
+// CheckICSRequest implements zgrab2.ICSScanner: with --ics-safe, the scanner may only send Read Device
+// Identification and Report Server ID requests.
+func (scanner *Scanner) CheckICSRequest(message []byte) error {
+	return checkRequests(message)
+}
+
 // GetPort returns the port being scanned.
 func (scanner *Scanner) GetPort() uint {
 	return scanner.config.Port
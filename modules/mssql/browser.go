@@ -0,0 +1,150 @@
+package mssql
+
+import (
+	"encoding/binary"
+	"strconv"
+	"strings"
+
+	"github.com/zmap/zgrab2"
+)
+
+// The SQL Server Browser service speaks the SQL Server Resolution Protocol
+// (SSRP) over UDP; see https://msdn.microsoft.com/en-us/library/cc219703.aspx.
+const (
+	// ssrpClientUnicastEx requests information on all instances on the server.
+	ssrpClientUnicastEx byte = 0x03
+
+	// ssrpServerResponse is the type of the server's reply.
+	ssrpServerResponse byte = 0x05
+
+	// ssrpMaxResponseSize is the largest response the server can send.
+	ssrpMaxResponseSize = 3 + 0xffff
+)
+
+// BrowserInstance describes a single database instance, as advertised by the
+// SQL Server Browser service.
+type BrowserInstance struct {
+	// ServerName is the name of the server hosting the instance.
+	ServerName string `json:"server_name,omitempty"`
+
+	// InstanceName is the name of the instance (MSSQLSERVER for the default).
+	InstanceName string `json:"instance_name,omitempty"`
+
+	// IsClustered is true if the instance is part of a failover cluster.
+	IsClustered bool `json:"is_clustered"`
+
+	// Version is the version string of the instance.
+	Version string `json:"version,omitempty"`
+
+	// TCPPort is the TCP port the instance listens on, if any.
+	TCPPort uint `json:"tcp_port,omitempty"`
+
+	// NamedPipe is the named pipe the instance listens on, if any.
+	NamedPipe string `json:"named_pipe,omitempty"`
+
+	// Other contains any other key / value pairs in the instance's entry
+	// (e.g. the via or rpc protocol parameters).
+	Other map[string]string `json:"other,omitempty"`
+}
+
+// BrowserResults contains the response from the SQL Server Browser service.
+type BrowserResults struct {
+	// Instances are the instances listed in the response.
+	Instances []BrowserInstance `json:"instances,omitempty"`
+
+	// Raw is the raw response string. Debug only.
+	Raw string `json:"raw,omitempty" zgrab:"debug,raw"`
+}
+
+// parseBrowserResponse decodes the RESP_DATA string of an SVR_RESP message,
+// which is a list of ;;-terminated instance entries, each of which is a list
+// of ;-separated key / value pairs.
+func parseBrowserResponse(data string) []BrowserInstance {
+	var ret []BrowserInstance
+	for _, entry := range strings.Split(data, ";;") {
+		if entry == "" {
+			continue
+		}
+		fields := strings.Split(entry, ";")
+		instance := BrowserInstance{}
+		for i := 0; i+1 < len(fields); i += 2 {
+			key, value := fields[i], fields[i+1]
+			switch strings.ToLower(key) {
+			case "servername":
+				instance.ServerName = value
+			case "instancename":
+				instance.InstanceName = value
+			case "isclustered":
+				instance.IsClustered = strings.EqualFold(value, "yes")
+			case "version":
+				instance.Version = value
+			case "tcp":
+				if port, err := strconv.ParseUint(value, 10, 16); err == nil {
+					instance.TCPPort = uint(port)
+				}
+			case "np":
+				instance.NamedPipe = value
+			default:
+				if instance.Other == nil {
+					instance.Other = make(map[string]string)
+				}
+				instance.Other[key] = value
+			}
+		}
+		ret = append(ret, instance)
+	}
+	return ret
+}
+
+// decodeBrowserResponse decodes an SVR_RESP message: the type byte, the
+// little-endian length of the RESP_DATA, and the RESP_DATA itself.
+func decodeBrowserResponse(buf []byte) (*BrowserResults, error) {
+	if len(buf) < 3 || buf[0] != ssrpServerResponse {
+		return nil, ErrInvalidData
+	}
+	size := int(binary.LittleEndian.Uint16(buf[1:3]))
+	if 3+size > len(buf) {
+		return nil, ErrInvalidData
+	}
+	raw := string(buf[3 : 3+size])
+	return &BrowserResults{Instances: parseBrowserResponse(raw), Raw: raw}, nil
+}
+
+// queryBrowser sends a CLNT_UCAST_EX request to the SQL Server Browser
+// service on the target and decodes the response.
+func (scanner *Scanner) queryBrowser(target *zgrab2.ScanTarget) (*BrowserResults, error) {
+	// The target's port would take precedence over one set in the flags.
+	browserTarget := *target
+	port := scanner.config.BrowserPort
+	browserTarget.Port = &port
+	conn, err := browserTarget.OpenUDP(&scanner.config.BaseFlags, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte{ssrpClientUnicastEx}); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, ssrpMaxResponseSize)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return nil, err
+	}
+	return decodeBrowserResponse(buf[:n])
+}
+
+// followedEndpoints returns the instances whose TCP ports are to be scanned
+// with --follow-endpoints: those with a TCP port other than the one already
+// scanned, each port once.
+func followedEndpoints(instances []BrowserInstance, scannedPort uint) []BrowserInstance {
+	var ret []BrowserInstance
+	scanned := map[uint]bool{scannedPort: true}
+	for _, instance := range instances {
+		if instance.TCPPort == 0 || scanned[instance.TCPPort] {
+			continue
+		}
+		scanned[instance.TCPPort] = true
+		ret = append(ret, instance)
+	}
+	return ret
+}
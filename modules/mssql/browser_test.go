@@ -0,0 +1,182 @@
+package mssql
+
+import (
+	"encoding/binary"
+	"net"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zmap/zgrab2"
+)
+
+// svrResp encodes data as an SVR_RESP message.
+func svrResp(data string) []byte {
+	ret := []byte{ssrpServerResponse, 0, 0}
+	binary.LittleEndian.PutUint16(ret[1:], uint16(len(data)))
+	return append(ret, data...)
+}
+
+func TestParseBrowserResponse(t *testing.T) {
+	data := "ServerName;DB1;InstanceName;MSSQLSERVER;IsClustered;No;Version;15.0.2000.5;tcp;1433;np;\\\\DB1\\pipe\\sql\\query;;" +
+		"ServerName;DB1;InstanceName;SQLEXPRESS;IsClustered;Yes;Version;16.0.1000.6;tcp;51234;rpc;DB1;;"
+	expected := []BrowserInstance{
+		{
+			ServerName:   "DB1",
+			InstanceName: "MSSQLSERVER",
+			Version:      "15.0.2000.5",
+			TCPPort:      1433,
+			NamedPipe:    "\\\\DB1\\pipe\\sql\\query",
+		},
+		{
+			ServerName:   "DB1",
+			InstanceName: "SQLEXPRESS",
+			IsClustered:  true,
+			Version:      "16.0.1000.6",
+			TCPPort:      51234,
+			Other:        map[string]string{"rpc": "DB1"},
+		},
+	}
+	if got := parseBrowserResponse(data); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %+v, got %+v", expected, got)
+	}
+	if got := parseBrowserResponse("InstanceName;BAD;tcp;70000;;"); len(got) != 1 || got[0].TCPPort != 0 {
+		t.Errorf("expected an out of range port to be ignored, got %+v", got)
+	}
+}
+
+func TestDecodeBrowserResponse(t *testing.T) {
+	data := "ServerName;DB1;InstanceName;MSSQLSERVER;tcp;1433;;"
+	result, err := decodeBrowserResponse(append(svrResp(data), "trailing"...))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.Raw != data || len(result.Instances) != 1 || result.Instances[0].TCPPort != 1433 {
+		t.Errorf("unexpected result %+v", result)
+	}
+	for _, bad := range [][]byte{
+		nil,
+		{ssrpServerResponse, 0},
+		{0x04, 0, 0},
+		svrResp(data)[:len(data)],
+	} {
+		if _, err := decodeBrowserResponse(bad); err != ErrInvalidData {
+			t.Errorf("%x: expected ErrInvalidData, got %v", bad, err)
+		}
+	}
+}
+
+func TestFollowedEndpoints(t *testing.T) {
+	instances := []BrowserInstance{
+		{InstanceName: "MSSQLSERVER", TCPPort: 1433},
+		{InstanceName: "PIPEONLY", NamedPipe: "\\\\DB1\\pipe\\sql\\query"},
+		{InstanceName: "A", TCPPort: 51234},
+		{InstanceName: "B", TCPPort: 51234},
+		{InstanceName: "C", TCPPort: 1500},
+	}
+	var names []string
+	for _, instance := range followedEndpoints(instances, 1433) {
+		names = append(names, instance.InstanceName)
+	}
+	if expected := []string{"A", "C"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("expected %v, got %v", expected, names)
+	}
+}
+
+// acceptCounter accepts and closes connections, counting them.
+type acceptCounter struct {
+	net.Listener
+	mutex sync.Mutex
+	count int
+}
+
+func newAcceptCounter(t *testing.T) *acceptCounter {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not listen: %v", err)
+	}
+	ret := &acceptCounter{Listener: l}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			ret.mutex.Lock()
+			ret.count++
+			ret.mutex.Unlock()
+			conn.Close()
+		}
+	}()
+	return ret
+}
+
+func (a *acceptCounter) port() uint {
+	return uint(a.Addr().(*net.TCPAddr).Port)
+}
+
+func (a *acceptCounter) accepted() int {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.count
+}
+
+// The Browser query and the scans of the advertised endpoints go to their own
+// ports, even though the target gives the port of the main scan.
+func TestScanFollowsEndpoints(t *testing.T) {
+	primary := newAcceptCounter(t)
+	defer primary.Close()
+	alternate := newAcceptCounter(t)
+	defer alternate.Close()
+
+	browser, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		t.Fatalf("could not listen: %v", err)
+	}
+	defer browser.Close()
+	data := "InstanceName;MSSQLSERVER;tcp;" + strconv.Itoa(int(primary.port())) + ";;" +
+		"InstanceName;OTHER;tcp;" + strconv.Itoa(int(alternate.port())) + ";;"
+	go func() {
+		buf := make([]byte, 16)
+		for {
+			n, addr, err := browser.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			if n == 1 && buf[0] == ssrpClientUnicastEx {
+				browser.WriteToUDP(svrResp(data), addr)
+			}
+		}
+	}()
+
+	flags := &Flags{
+		EncryptMode:     "ENCRYPT_ON",
+		BrowserPort:     uint(browser.LocalAddr().(*net.UDPAddr).Port),
+		FollowEndpoints: true,
+	}
+	// The configured port is not listening; the target's is scanned.
+	flags.Port = 1
+	flags.Timeout = 2 * time.Second
+	scanner := new(Scanner)
+	if err := scanner.Init(flags); err != nil {
+		t.Fatalf("could not initialize scanner: %v", err)
+	}
+	port := primary.port()
+	_, res, _ := scanner.Scan(zgrab2.ScanTarget{IP: net.ParseIP("127.0.0.1"), Port: &port})
+	result, ok := res.(*ScanResults)
+	if !ok || result == nil {
+		t.Fatalf("expected results, got %v", res)
+	}
+	if result.Browser == nil || len(result.Browser.Instances) != 2 {
+		t.Fatalf("expected the Browser response, got %+v (%s)", result.Browser, result.BrowserError)
+	}
+	if len(result.AlternateEndpoints) != 1 || result.AlternateEndpoints[0].InstanceName != "OTHER" ||
+		result.AlternateEndpoints[0].Port != alternate.port() {
+		t.Errorf("expected only the other instance to be followed, got %+v", result.AlternateEndpoints)
+	}
+	if primary.accepted() != 1 || alternate.accepted() != 1 {
+		t.Errorf("expected one connection to each port, got %d and %d", primary.accepted(), alternate.accepted())
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"io"
 	"net"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -247,6 +248,35 @@ func decodeServerVersion(buf []byte) *ServerVersion {
 	}
 }
 
+// encodeClientVersion encodes a MAJOR.MINOR.BUILD[.SUBBUILD] version as a
+// VERSION token value, in the same layout as decodeServerVersion reads (with
+// US_SUBBUILD in the last two bytes), or returns nil if version is empty.
+func encodeClientVersion(version string) ([]byte, error) {
+	if version == "" {
+		return nil, nil
+	}
+	parts := strings.Split(version, ".")
+	if len(parts) != 3 && len(parts) != 4 {
+		return nil, fmt.Errorf("invalid client version %s: must be MAJOR.MINOR.BUILD[.SUBBUILD]", version)
+	}
+	var values [4]uint64
+	for i, part := range parts {
+		bits := 16
+		if i < 2 {
+			bits = 8
+		}
+		value, err := strconv.ParseUint(part, 10, bits)
+		if err != nil {
+			return nil, fmt.Errorf("invalid client version %s: %v", version, err)
+		}
+		values[i] = value
+	}
+	ret := []byte{byte(values[0]), byte(values[1]), 0, 0, 0, 0}
+	binary.BigEndian.PutUint16(ret[2:4], uint16(values[2]))
+	binary.BigEndian.PutUint16(ret[4:6], uint16(values[3]))
+	return ret, nil
+}
+
 // String returns the dotted-decimal representation of the ServerVersion:
 // "MAJOR.MINOR.BUILD_NUMBER".
 func (version *ServerVersion) String() string {
@@ -627,6 +657,10 @@ type Connection struct {
 	// PreloginOptions contains the values returned by the server in the
 	// PRELOGIN call, once it has happened.
 	PreloginOptions *PreloginOptions
+
+	// clientVersion is the VERSION token value to send in PRELOGIN, or nil
+	// for all zeroes.
+	clientVersion []byte
 }
 
 // SendTDSPacket sends a TDS packet with the given type and body.
@@ -666,8 +700,12 @@ func (connection *Connection) prelogin(clientEncrypt EncryptMode) (EncryptMode,
 	if clientEncrypt < 0 || clientEncrypt > 0xff {
 		return EncryptModeUnknown, ErrInvalidData
 	}
+	version := connection.clientVersion
+	if version == nil {
+		version = []byte{0, 0, 0, 0, 0, 0}
+	}
 	clientOptions := PreloginOptions{
-		PreloginVersion:    {0, 0, 0, 0, 0, 0},
+		PreloginVersion:    version,
 		PreloginEncryption: {byte(clientEncrypt)},
 		PreloginInstance:   {0},
 		PreloginThreadID:   {0, 0, 0, 0},
@@ -7,6 +7,16 @@
 //
 // The scan performs a PRELOGIN and if possible does a TLS handshake.
 //
+// With --probe-all-encrypt-modes, an additional PRELOGIN is sent on a fresh
+// connection for each of ENCRYPT_OFF, ENCRYPT_ON and ENCRYPT_NOT_SUP, and the
+// server's response to each is included in encrypt_mode_probes.
+//
+// With --browser, the SQL Server Browser service (UDP port 1434 by default) is
+// also queried for the server's instances and the TCP ports / named pipes they
+// listen on. With --follow-endpoints, the PRELOGIN scan is repeated against
+// every advertised TCP port other than the one scanned, and those results are
+// included in alternate_endpoints.
+//
 // The output is the the server version and instance name, and if applicable the
 // TLS output.
 package mssql
@@ -38,14 +48,71 @@ type ScanResults struct {
 
 	// TLSLog is the shared TLS handshake/scan log.
 	TLSLog *zgrab2.TLSLog `json:"tls,omitempty"`
+
+	// EncryptModeProbes are the server's responses to PRELOGIN requests with
+	// each client encrypt mode, if --probe-all-encrypt-modes was given.
+	EncryptModeProbes []EncryptModeProbe `json:"encrypt_mode_probes,omitempty"`
+
+	// Browser is the response from the SQL Server Browser service, if it
+	// was queried.
+	Browser *BrowserResults `json:"browser,omitempty"`
+
+	// BrowserError is the error returned when querying the Browser service.
+	BrowserError string `json:"browser_error,omitempty"`
+
+	// AlternateEndpoints are the results of scanning the other TCP ports
+	// advertised by the Browser service.
+	AlternateEndpoints []AlternateEndpoint `json:"alternate_endpoints,omitempty"`
+}
+
+// EncryptModeProbe is the server's response to a PRELOGIN request with a
+// particular client encrypt mode.
+type EncryptModeProbe struct {
+	// ClientMode is the encrypt mode sent by the client.
+	ClientMode EncryptMode `json:"client_mode"`
+
+	// ServerMode is the encrypt mode returned by the server.
+	ServerMode *EncryptMode `json:"server_mode,omitempty"`
+
+	// Error is the error, if any, returned by the PRELOGIN. Note that this
+	// includes the server rejecting the client's mode.
+	Error string `json:"error,omitempty"`
+}
+
+// probedEncryptModes are the client encrypt modes sent with
+// --probe-all-encrypt-modes.
+var probedEncryptModes = []EncryptMode{EncryptModeOff, EncryptModeOn, EncryptModeNotSupported}
+
+// AlternateEndpoint is the result of scanning a TCP port advertised by the
+// SQL Server Browser service.
+type AlternateEndpoint struct {
+	// InstanceName is the name of the instance advertised on the port.
+	InstanceName string `json:"instance_name,omitempty"`
+
+	// Port is the TCP port that was scanned.
+	Port uint `json:"port"`
+
+	// Status is the status of the scan of the port.
+	Status zgrab2.ScanStatus `json:"status"`
+
+	// Result is the result of the scan of the port.
+	Result *ScanResults `json:"result,omitempty"`
+
+	// Error is the error, if any, returned by the scan of the port.
+	Error string `json:"error,omitempty"`
 }
 
 // Flags defines the command-line configuration options for the module.
 type Flags struct {
 	zgrab2.BaseFlags
 	zgrab2.TLSFlags
-	EncryptMode string `long:"encrypt-mode" description:"The type of encryption to request in the pre-login step. One of ENCRYPT_ON, ENCRYPT_OFF, ENCRYPT_NOT_SUP." default:"ENCRYPT_ON"`
-	Verbose     bool   `long:"verbose" description:"More verbose logging, include debug fields in the scan results"`
+	EncryptMode     string `long:"encrypt-mode" description:"The type of encryption to request in the pre-login step. One of ENCRYPT_ON, ENCRYPT_OFF, ENCRYPT_NOT_SUP." default:"ENCRYPT_ON"`
+	Browser         bool   `long:"browser" description:"Query the SQL Server Browser service for the server's instances and their endpoints"`
+	BrowserPort     uint   `long:"browser-port" default:"1434" description:"UDP port of the SQL Server Browser service"`
+	FollowEndpoints bool   `long:"follow-endpoints" description:"Also scan the TCP ports advertised by the SQL Server Browser service (implies --browser)"`
+	ProbeAllModes   bool   `long:"probe-all-encrypt-modes" description:"Send an additional PRELOGIN with each client encrypt mode and record the server's response to each"`
+	ClientVersion   string `long:"client-version" description:"Client version to send in the PRELOGIN VERSION token, as MAJOR.MINOR.BUILD[.SUBBUILD] (e.g. 16.0.1000 for SQL Server 2022's client); zero if not given"`
+	Verbose         bool   `long:"verbose" description:"More verbose logging, include debug fields in the scan results"`
 }
 
 // Module is the implementation of zgrab2.Module for the MSSQL protocol.
@@ -55,6 +122,9 @@ type Module struct {
 // Scanner is the implementation of zgrab2.Scanner for the MSSQL protocol.
 type Scanner struct {
 	config *Flags
+
+	// clientVersion is the encoded --client-version, or nil.
+	clientVersion []byte
 }
 
 // NewFlags returns a default Flags instance to be populated by the command
@@ -70,6 +140,9 @@ func (module *Module) NewScanner() zgrab2.Scanner {
 
 // Validate does nothing in this module.
 func (flags *Flags) Validate(args []string) error {
+	if _, err := encodeClientVersion(flags.ClientVersion); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -85,6 +158,11 @@ func (scanner *Scanner) Init(flags zgrab2.ScanFlags) error {
 	if f.Verbose {
 		log.SetLevel(log.DebugLevel)
 	}
+	version, err := encodeClientVersion(f.ClientVersion)
+	if err != nil {
+		return err
+	}
+	scanner.clientVersion = version
 	return nil
 }
 
@@ -113,6 +191,16 @@ func (scanner *Scanner) GetPort() uint {
 	return scanner.config.Port
 }
 
+// IdentifyProduct returns SQL Server, with the version from the PRELOGIN
+// response.
+func (scanner *Scanner) IdentifyProduct(result interface{}) *zgrab2.Product {
+	results, ok := result.(*ScanResults)
+	if !ok || results == nil || results.Version == "" {
+		return nil
+	}
+	return zgrab2.NewProduct(zgrab2.CPEApplication, "microsoft", "sql_server", results.Version)
+}
+
 // Scan performs the MSSQL scan.
 // 1. Open a TCP connection to the target port (default 1433).
 // 2. Send a PRELOGIN packet to the server.
@@ -120,13 +208,81 @@ func (scanner *Scanner) GetPort() uint {
 // 4. If the server encrypt mode is EncryptModeNotSupported, break.
 // 5. Perform a TLS handshake, with the packets wrapped in TDS headers.
 // 6. Decode the Version and InstanceName from the PRELOGIN response
+// 7. If requested, query the Browser service and scan its other TCP endpoints.
 func (scanner *Scanner) Scan(target zgrab2.ScanTarget) (zgrab2.ScanStatus, interface{}, error) {
+	port := target.EffectivePort(&scanner.config.BaseFlags)
+	status, result, err := scanner.scanPort(&target, port)
+	if result != nil && scanner.config.ProbeAllModes {
+		for _, mode := range probedEncryptModes {
+			result.EncryptModeProbes = append(result.EncryptModeProbes, scanner.probeEncryptMode(&target, mode))
+		}
+	}
+	if !scanner.config.Browser && !scanner.config.FollowEndpoints {
+		if result == nil {
+			return status, nil, err
+		}
+		return status, result, err
+	}
+	browser, browserErr := scanner.queryBrowser(&target)
+	if browser == nil && browserErr != nil && result == nil {
+		return status, nil, err
+	}
+	if result == nil {
+		result = &ScanResults{}
+	}
+	result.Browser = browser
+	if browserErr != nil {
+		result.BrowserError = browserErr.Error()
+	}
+	if browser != nil && scanner.config.FollowEndpoints {
+		for _, instance := range followedEndpoints(browser.Instances, port) {
+			endpoint := AlternateEndpoint{InstanceName: instance.InstanceName, Port: instance.TCPPort}
+			var endpointErr error
+			endpoint.Status, endpoint.Result, endpointErr = scanner.scanPort(&target, instance.TCPPort)
+			if endpointErr != nil {
+				endpoint.Error = endpointErr.Error()
+			}
+			result.AlternateEndpoints = append(result.AlternateEndpoints, endpoint)
+		}
+	}
+	return status, result, err
+}
+
+// probeEncryptMode sends a PRELOGIN with the given client encrypt mode on a
+// new connection, and records the server's response.
+func (scanner *Scanner) probeEncryptMode(target *zgrab2.ScanTarget, mode EncryptMode) EncryptModeProbe {
+	ret := EncryptModeProbe{ClientMode: mode}
 	conn, err := target.Open(&scanner.config.BaseFlags)
+	if err != nil {
+		ret.Error = err.Error()
+		return ret
+	}
+	sql := NewConnection(conn)
+	defer sql.Close()
+	sql.clientVersion = scanner.clientVersion
+	_, err = sql.prelogin(mode)
+	if sql.PreloginOptions != nil {
+		serverMode := sql.getEncryptMode()
+		ret.ServerMode = &serverMode
+	}
+	if err != nil {
+		ret.Error = err.Error()
+	}
+	return ret
+}
+
+// scanPort performs the PRELOGIN / TLS handshake against the given TCP port.
+func (scanner *Scanner) scanPort(target *zgrab2.ScanTarget, port uint) (zgrab2.ScanStatus, *ScanResults, error) {
+	// The target's port would take precedence over one set in the flags.
+	portTarget := *target
+	portTarget.Port = &port
+	conn, err := portTarget.Open(&scanner.config.BaseFlags)
 	if err != nil {
 		return zgrab2.TryGetScanStatus(err), nil, err
 	}
 	sql := NewConnection(conn)
 	defer sql.Close()
+	sql.clientVersion = scanner.clientVersion
 	result := &ScanResults{}
 
 	encryptMode, handshakeErr := sql.Handshake(scanner.config)
@@ -164,9 +320,9 @@ func (scanner *Scanner) Scan(target zgrab2.ScanTarget) (zgrab2.ScanStatus, inter
 		}
 		switch handshakeErr {
 		case ErrNoServerEncryption:
-			return zgrab2.SCAN_APPLICATION_ERROR, result, handshakeErr
+			return zgrab2.SCAN_APPLICATION_ERROR, result, zgrab2.NewRejectionError(zgrab2.REJECTED_ENCRYPTION_UNSUPPORTED, handshakeErr)
 		case ErrServerRequiresEncryption:
-			return zgrab2.SCAN_APPLICATION_ERROR, result, handshakeErr
+			return zgrab2.SCAN_APPLICATION_ERROR, result, zgrab2.NewRejectionError(zgrab2.REJECTED_ENCRYPTION_REQUIRED, handshakeErr)
 		default:
 			return zgrab2.TryGetScanStatus(handshakeErr), result, handshakeErr
 		}
@@ -0,0 +1,176 @@
+package mssql
+
+import (
+	"io"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zmap/zgrab2"
+)
+
+// preloginResponder is a fake server that answers each connection's PRELOGIN
+// with the encrypt mode returned by respond for the client's mode, or closes
+// the connection if respond returns false. It does not go on to the TLS
+// handshake.
+type preloginResponder struct {
+	net.Listener
+	respond func(client EncryptMode) (EncryptMode, bool)
+
+	mutex   sync.Mutex
+	clients []EncryptMode
+}
+
+func newPreloginResponder(t *testing.T, respond func(EncryptMode) (EncryptMode, bool)) *preloginResponder {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not listen: %v", err)
+	}
+	ret := &preloginResponder{Listener: l, respond: respond}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go ret.serve(t, conn)
+		}
+	}()
+	return ret
+}
+
+func (r *preloginResponder) serve(t *testing.T, conn net.Conn) {
+	defer conn.Close()
+	header, err := readTDSHeader(conn)
+	if err != nil || header.Type != TDSPacketTypePrelogin || header.Length < 8 {
+		t.Errorf("expected a PRELOGIN packet, got %+v (%v)", header, err)
+		return
+	}
+	body := make([]byte, header.Length-8)
+	if _, err := io.ReadFull(conn, body); err != nil {
+		t.Errorf("could not read PRELOGIN body: %v", err)
+		return
+	}
+	options, _, err := decodePreloginOptions(body)
+	if err != nil {
+		t.Errorf("invalid PRELOGIN: %v", err)
+		return
+	}
+	client, err := options.GetByteOption(PreloginEncryption)
+	if err != nil {
+		t.Errorf("PRELOGIN has no encrypt mode: %v", err)
+		return
+	}
+	r.mutex.Lock()
+	r.clients = append(r.clients, EncryptMode(client))
+	r.mutex.Unlock()
+	mode, ok := r.respond(EncryptMode(client))
+	if !ok {
+		return
+	}
+	response, err := PreloginOptions{
+		PreloginVersion:    {15, 0, 0x07, 0xd0, 0, 0},
+		PreloginEncryption: {byte(mode)},
+	}.Encode()
+	if err != nil {
+		t.Errorf("could not encode response: %v", err)
+		return
+	}
+	packet := &TDSPacket{
+		TDSHeader: TDSHeader{Type: TDSPacketTypeTabularResult, Status: TDSStatusEOM},
+		Body:      response,
+	}
+	encoded, err := packet.Encode()
+	if err != nil {
+		t.Errorf("could not encode packet: %v", err)
+		return
+	}
+	conn.Write(encoded)
+}
+
+func (r *preloginResponder) clientModes() []EncryptMode {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]EncryptMode(nil), r.clients...)
+}
+
+func encryptModePtr(mode EncryptMode) *EncryptMode {
+	return &mode
+}
+
+func TestProbeAllEncryptModes(t *testing.T) {
+	tests := []struct {
+		name    string
+		mode    string
+		respond func(EncryptMode) (EncryptMode, bool)
+		probes  []EncryptModeProbe
+	}{
+		{
+			name: "no encryption",
+			mode: "ENCRYPT_NOT_SUP",
+			respond: func(EncryptMode) (EncryptMode, bool) {
+				return EncryptModeNotSupported, true
+			},
+			probes: []EncryptModeProbe{
+				{ClientMode: EncryptModeOff, ServerMode: encryptModePtr(EncryptModeNotSupported)},
+				{ClientMode: EncryptModeOn, ServerMode: encryptModePtr(EncryptModeNotSupported), Error: ErrNoServerEncryption.Error()},
+				{ClientMode: EncryptModeNotSupported, ServerMode: encryptModePtr(EncryptModeNotSupported)},
+			},
+		},
+		{
+			// The main scan's TLS handshake fails, but the PRELOGIN
+			// response is still recorded and the modes probed.
+			name: "forced encryption",
+			mode: "ENCRYPT_ON",
+			respond: func(client EncryptMode) (EncryptMode, bool) {
+				if client == EncryptModeNotSupported {
+					return EncryptModeRequired, true
+				}
+				return EncryptModeOn, true
+			},
+			probes: []EncryptModeProbe{
+				{ClientMode: EncryptModeOff, ServerMode: encryptModePtr(EncryptModeOn)},
+				{ClientMode: EncryptModeOn, ServerMode: encryptModePtr(EncryptModeOn)},
+				{ClientMode: EncryptModeNotSupported, ServerMode: encryptModePtr(EncryptModeRequired), Error: ErrServerRequiresEncryption.Error()},
+			},
+		},
+		{
+			name: "no response to ENCRYPT_OFF",
+			mode: "ENCRYPT_NOT_SUP",
+			respond: func(client EncryptMode) (EncryptMode, bool) {
+				return EncryptModeOff, client != EncryptModeOff
+			},
+			probes: []EncryptModeProbe{
+				{ClientMode: EncryptModeOff, Error: io.EOF.Error()},
+				{ClientMode: EncryptModeOn, ServerMode: encryptModePtr(EncryptModeOff)},
+				{ClientMode: EncryptModeNotSupported, ServerMode: encryptModePtr(EncryptModeOff)},
+			},
+		},
+	}
+	for _, test := range tests {
+		server := newPreloginResponder(t, test.respond)
+		flags := &Flags{EncryptMode: test.mode, ProbeAllModes: true}
+		flags.Port = uint(server.Addr().(*net.TCPAddr).Port)
+		flags.Timeout = 2 * time.Second
+		scanner := new(Scanner)
+		if err := scanner.Init(flags); err != nil {
+			t.Fatalf("%s: could not initialize scanner: %v", test.name, err)
+		}
+		_, res, _ := scanner.Scan(zgrab2.ScanTarget{IP: net.ParseIP("127.0.0.1")})
+		server.Close()
+		result, ok := res.(*ScanResults)
+		if !ok || result == nil {
+			t.Errorf("%s: expected results, got %v", test.name, res)
+			continue
+		}
+		if !reflect.DeepEqual(result.EncryptModeProbes, test.probes) {
+			t.Errorf("%s: expected probes %+v, got %+v", test.name, test.probes, result.EncryptModeProbes)
+		}
+		expected := append([]EncryptMode{getEncryptMode(test.mode)}, probedEncryptModes...)
+		if clients := server.clientModes(); !reflect.DeepEqual(clients, expected) {
+			t.Errorf("%s: expected PRELOGINs with %v, got %v", test.name, expected, clients)
+		}
+	}
+}
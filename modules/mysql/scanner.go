@@ -67,7 +67,7 @@ type ScanResults struct {
 
 	// RawPackets contains the base64 encoding of all packets sent and
 	// received during the scan.
-	RawPackets []string `json:"raw_packets,omitempty" zgrab:"debug"`
+	RawPackets []string `json:"raw_packets,omitempty" zgrab:"debug,raw"`
 
 	// TLSLog contains the usual shared TLS logs.
 	TLSLog *zgrab2.TLSLog `json:"tls,omitempty"`
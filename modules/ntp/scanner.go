@@ -784,7 +784,7 @@ type Results struct {
 
 	// MonListResponse is the raw data returned by the call to monlist.
 	// Only present if --monlist is set.
-	MonListResponse []byte `json:"monlist_response,omitempty"`
+	MonListResponse []byte `json:"monlist_response,omitempty" zgrab:"raw"`
 
 	// MonListHeader is the header returned by the call to monlist.
 	// Only present if --monlist is set. Debug only.
@@ -868,6 +868,16 @@ func (scanner *Scanner) GetTrigger() string {
 	return scanner.config.Trigger
 }
 
+// ServerTime returns the receive timestamp of the server's get time
+// response.
+func (scanner *Scanner) ServerTime(result interface{}) *zgrab2.ServerTime {
+	results, ok := result.(*Results)
+	if !ok || results == nil || results.Time == nil {
+		return nil
+	}
+	return &zgrab2.ServerTime{Time: *results.Time, Source: "ntp_receive_timestamp"}
+}
+
 // GetPort returns the port that is being scanned
 func (scanner *Scanner) GetPort() uint {
 	return scanner.config.Port
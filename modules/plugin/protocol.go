@@ -0,0 +1,165 @@
+package plugin
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+
+	"github.com/zmap/zgrab2"
+)
+
+// Description is the JSON object a plugin writes to stdout when invoked with
+// the "describe" argument.
+type Description struct {
+	// Name is the module name used on the command line / in multiple configs.
+	Name string `json:"name"`
+
+	// Protocol is the protocol identifier reported in results; defaults to Name.
+	Protocol string `json:"protocol,omitempty"`
+
+	// ShortDescription and LongDescription are used for the command help.
+	ShortDescription string `json:"short_description,omitempty"`
+	LongDescription  string `json:"long_description,omitempty"`
+
+	// Port is the default port to scan.
+	Port int `json:"port"`
+}
+
+// Request is written to the plugin's stdin, one JSON object per line, for each
+// target to be scanned.
+type Request struct {
+	IP             string  `json:"ip,omitempty"`
+	Domain         string  `json:"domain,omitempty"`
+	Tag            string  `json:"tag,omitempty"`
+	Port           uint    `json:"port"`
+	Timeout        float64 `json:"timeout"`
+	AddressFamily  string  `json:"address_family,omitempty"`
+	BytesReadLimit int     `json:"bytes_read_limit,omitempty"`
+}
+
+// Response is read from the plugin's stdout, one JSON object per line, in
+// reply to each Request.
+type Response struct {
+	Status zgrab2.ScanStatus `json:"status"`
+	Result json.RawMessage   `json:"result,omitempty"`
+	Error  string            `json:"error,omitempty"`
+}
+
+// errPluginExited is returned when the plugin process closes its stdout.
+var errPluginExited = errors.New("plugin process exited")
+
+// describe runs the plugin executable with the "describe" argument and parses
+// its Description.
+func describe(path string) (*Description, error) {
+	out, err := exec.Command(path, "describe").Output()
+	if err != nil {
+		return nil, err
+	}
+	ret := new(Description)
+	if err := json.Unmarshal(out, ret); err != nil {
+		return nil, fmt.Errorf("invalid description: %v", err)
+	}
+	if ret.Name == "" {
+		return nil, errors.New("description has no name")
+	}
+	if ret.Protocol == "" {
+		ret.Protocol = ret.Name
+	}
+	return ret, nil
+}
+
+// process is a running "scan" invocation of a plugin.
+type process struct {
+	cmd       *exec.Cmd
+	stdin     io.WriteCloser
+	responses chan []byte
+}
+
+// startProcess runs the plugin executable with the "scan" argument followed
+// by args, and starts reading response lines from its stdout.
+func startProcess(path string, args []string) (*process, error) {
+	cmd := exec.Command(path, append([]string{"scan"}, args...)...)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	ret := &process{cmd: cmd, stdin: stdin, responses: make(chan []byte)}
+	go func() {
+		defer close(ret.responses)
+		reader := bufio.NewReader(stdout)
+		for {
+			line, err := reader.ReadBytes('\n')
+			if len(line) > 0 {
+				ret.responses <- line
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return ret, nil
+}
+
+// send writes a request to the process.
+func (p *process) send(req *Request) error {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	_, err = p.stdin.Write(append(data, '\n'))
+	return err
+}
+
+// kill stops the process and reaps it.
+func (p *process) kill() {
+	p.stdin.Close()
+	p.cmd.Process.Kill()
+	go func() {
+		// Drain any pending output so the reader goroutine can exit.
+		for range p.responses {
+		}
+		p.cmd.Wait()
+	}()
+}
+
+// pool holds idle plugin processes for reuse; a new process is started
+// whenever all existing ones are busy.
+type pool struct {
+	mutex sync.Mutex
+	path  string
+	args  []string
+	idle  []*process
+}
+
+// get returns an idle process, or starts a new one.
+func (p *pool) get() (*process, error) {
+	p.mutex.Lock()
+	if n := len(p.idle); n > 0 {
+		ret := p.idle[n-1]
+		p.idle = p.idle[:n-1]
+		p.mutex.Unlock()
+		return ret, nil
+	}
+	p.mutex.Unlock()
+	return startProcess(p.path, p.args)
+}
+
+// put returns a healthy process to the pool.
+func (p *pool) put(proc *process) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.idle = append(p.idle, proc)
+}
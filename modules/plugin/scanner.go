@@ -0,0 +1,210 @@
+// Package plugin registers external scanner executables as zgrab2 modules, so
+// that scanners for niche protocols can be written in any language and used
+// without modifying zgrab2.
+//
+// Each executable file in the directory given by --plugin-dir (or the
+// ZGRAB2_PLUGIN_DIR environment variable) is a plugin, and must support two
+// invocations:
+//
+//	<plugin> describe
+//
+// writes a single JSON Description object to stdout, e.g.
+//
+//	{"name": "gopher", "short_description": "gopher", "long_description": "Probe for Gopher", "port": 70}
+//
+// and exits. The plugin is then registered as a module with that name.
+//
+//	<plugin> scan [--plugin-args ...]
+//
+// reads one JSON Request object per line from stdin, e.g.
+//
+//	{"ip": "10.0.0.1", "domain": "example.com", "port": 70, "timeout": 10, "address_family": "any"}
+//
+// and, for each, performs the scan itself and writes one JSON Response object
+// per line to stdout, e.g.
+//
+//	{"status": "success", "result": {"banner": "..."}}
+//
+// where status is one of the zgrab2 scan statuses and result is copied
+// verbatim into the output. Requests are sent one at a time to each plugin
+// process; zgrab2 starts as many processes as there are concurrent scans and
+// reuses them across targets. A process that does not respond within the
+// module timeout is killed. Anything the plugin writes to stderr is passed
+// through to zgrab2's stderr.
+package plugin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/zmap/zgrab2"
+)
+
+// Flags holds the command-line configuration for a plugin module.
+type Flags struct {
+	zgrab2.BaseFlags
+
+	PluginArgs string `long:"plugin-args" description:"Space-separated arguments passed to the plugin after 'scan'"`
+}
+
+// Module implements the zgrab2.Module interface for a single plugin.
+type Module struct {
+	path        string
+	description *Description
+}
+
+// Scanner implements the zgrab2.Scanner interface.
+type Scanner struct {
+	config *Flags
+	module *Module
+	pool   *pool
+}
+
+// RegisterPlugins registers each executable in dir as a module. An empty dir
+// is ignored; plugins that cannot be described are logged and skipped. It must
+// be called before zgrab2.ParseCommandLine, e.g. with zgrab2.PluginDir.
+func RegisterPlugins(dir string) {
+	if dir == "" {
+		return
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		log.Fatalf("could not read plugin directory: %v", err)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || entry.Mode()&0111 == 0 {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		desc, err := describe(path)
+		if err != nil {
+			log.Errorf("skipping plugin %s: %v", path, err)
+			continue
+		}
+		if zgrab2.GetModule(desc.Name) != nil {
+			log.Errorf("skipping plugin %s: module %s already exists", path, desc.Name)
+			continue
+		}
+		module := &Module{path: path, description: desc}
+		if _, err := zgrab2.AddCommand(desc.Name, desc.ShortDescription, desc.LongDescription, desc.Port, module); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+// NewFlags returns a default Flags object.
+func (module *Module) NewFlags() interface{} {
+	return new(Flags)
+}
+
+// NewScanner returns a new Scanner instance.
+func (module *Module) NewScanner() zgrab2.Scanner {
+	return &Scanner{module: module}
+}
+
+// Validate checks that the flags are valid.
+// On success, returns nil.
+// On failure, returns an error instance describing the error.
+func (flags *Flags) Validate(args []string) error {
+	return nil
+}
+
+// Help returns the module's help string.
+func (flags *Flags) Help() string {
+	return ""
+}
+
+// Init initializes the Scanner.
+func (scanner *Scanner) Init(flags zgrab2.ScanFlags) error {
+	f, _ := flags.(*Flags)
+	scanner.config = f
+	scanner.pool = &pool{
+		path: scanner.module.path,
+		args: strings.Fields(f.PluginArgs),
+	}
+	return nil
+}
+
+// InitPerSender initializes the scanner for a given sender.
+func (scanner *Scanner) InitPerSender(senderID int) error {
+	return nil
+}
+
+// GetName returns the Scanner name defined in the Flags.
+func (scanner *Scanner) GetName() string {
+	return scanner.config.Name
+}
+
+// GetTrigger returns the Trigger defined in the Flags.
+func (scanner *Scanner) GetTrigger() string {
+	return scanner.config.Trigger
+}
+
+// Protocol returns the protocol identifier given by the plugin.
+func (scanner *Scanner) Protocol() string {
+	return scanner.module.description.Protocol
+}
+
+// Scan sends the target to a plugin process and returns its response.
+func (scanner *Scanner) Scan(target zgrab2.ScanTarget) (zgrab2.ScanStatus, interface{}, error) {
+	timeout := target.BoundTimeout(scanner.config.Timeout)
+	req := &Request{
+		Domain:         target.Domain,
+		Tag:            target.Tag,
+		Port:           target.EffectivePort(&scanner.config.BaseFlags),
+		Timeout:        timeout.Seconds(),
+		AddressFamily:  scanner.config.AddressFamily,
+		BytesReadLimit: zgrab2.DefaultBytesReadLimit,
+	}
+	if target.IP != nil {
+		req.IP = target.IP.String()
+	}
+
+	proc, err := scanner.pool.get()
+	if err != nil {
+		return zgrab2.SCAN_UNKNOWN_ERROR, nil, fmt.Errorf("could not start plugin: %v", err)
+	}
+	if err := proc.send(req); err != nil {
+		proc.kill()
+		return zgrab2.SCAN_UNKNOWN_ERROR, nil, fmt.Errorf("could not send request to plugin: %v", err)
+	}
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	select {
+	case line, ok := <-proc.responses:
+		if !ok {
+			proc.kill()
+			return zgrab2.SCAN_UNKNOWN_ERROR, nil, errPluginExited
+		}
+		scanner.pool.put(proc)
+		resp := new(Response)
+		if err := json.Unmarshal(line, resp); err != nil {
+			return zgrab2.SCAN_PROTOCOL_ERROR, nil, fmt.Errorf("invalid response from plugin: %v", err)
+		}
+		if resp.Status == "" {
+			resp.Status = zgrab2.SCAN_SUCCESS
+		}
+		var result interface{}
+		if len(resp.Result) > 0 {
+			result = resp.Result
+		}
+		if resp.Error != "" {
+			return resp.Status, result, errors.New(resp.Error)
+		}
+		return resp.Status, result, nil
+	case <-expired:
+		proc.kill()
+		return zgrab2.SCAN_IO_TIMEOUT, nil, fmt.Errorf("plugin did not respond within %v", timeout)
+	}
+}
@@ -0,0 +1,272 @@
+// +build linux darwin freebsd netbsd openbsd dragonfly
+
+package plugin
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"reflect"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/zmap/zgrab2"
+)
+
+// testPluginEnv is set when the test binary is run as a plugin.
+const testPluginEnv = "ZGRAB2_TEST_PLUGIN"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(testPluginEnv) != "" {
+		runTestPlugin(os.Args[1:])
+		os.Exit(0)
+	}
+	os.Setenv(testPluginEnv, "1")
+	os.Exit(m.Run())
+}
+
+// testResult is the result the test plugin returns for each request.
+type testResult struct {
+	Request Request  `json:"request"`
+	PID     int      `json:"pid"`
+	Args    []string `json:"args"`
+}
+
+// runTestPlugin implements a plugin. For each request, it replies with the
+// request, its pid and its arguments, unless the request's domain is one of:
+// hang (never reply), exit (exit without replying), refused (reply with a
+// connection-refused error) or garbage (reply with invalid JSON).
+func runTestPlugin(args []string) {
+	if len(args) > 0 && args[0] == "describe" {
+		fmt.Println(`{"name": "test", "short_description": "Test", "long_description": "A test plugin", "port": 70}`)
+		return
+	}
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		var req Request
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			fmt.Fprintf(os.Stderr, "invalid request: %v\n", err)
+			os.Exit(1)
+		}
+		switch req.Domain {
+		case "hang":
+			select {}
+		case "exit":
+			os.Exit(1)
+		case "refused":
+			fmt.Println(`{"status": "connection-refused", "error": "refused"}`)
+		case "garbage":
+			fmt.Println("not json")
+		default:
+			result, _ := json.Marshal(testResult{Request: req, PID: os.Getpid(), Args: args[1:]})
+			fmt.Printf("{\"result\": %s}\n", result)
+		}
+	}
+}
+
+// newTestScanner returns a scanner for the test plugin.
+func newTestScanner(t *testing.T, timeout time.Duration) *Scanner {
+	path, err := os.Executable()
+	if err != nil {
+		t.Fatalf("could not find test executable: %v", err)
+	}
+	desc, err := describe(path)
+	if err != nil {
+		t.Fatalf("could not describe plugin: %v", err)
+	}
+	flags := &Flags{PluginArgs: "-x  y"}
+	flags.Name = desc.Name
+	flags.Port = uint(desc.Port)
+	flags.Timeout = timeout
+	scanner := (&Module{path: path, description: desc}).NewScanner().(*Scanner)
+	if err := scanner.Init(flags); err != nil {
+		t.Fatalf("could not initialize scanner: %v", err)
+	}
+	return scanner
+}
+
+// scanTestPlugin scans a target with the given domain, and decodes the result.
+func scanTestPlugin(t *testing.T, scanner *Scanner, domain string, port *uint) (zgrab2.ScanStatus, *testResult, error) {
+	target := zgrab2.ScanTarget{IP: net.ParseIP("192.0.2.1"), Domain: domain, Port: port}
+	status, res, err := scanner.Scan(target)
+	if res == nil {
+		return status, nil, err
+	}
+	raw, ok := res.(json.RawMessage)
+	if !ok {
+		t.Fatalf("unexpected result type %T", res)
+	}
+	result := new(testResult)
+	if err := json.Unmarshal(raw, result); err != nil {
+		t.Fatalf("invalid result %s: %v", raw, err)
+	}
+	return status, result, err
+}
+
+// waitExited waits for the process with the given pid to be reaped.
+func waitExited(t *testing.T, pid int) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if err := syscall.Kill(pid, 0); err == syscall.ESRCH {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Errorf("process %d is still running", pid)
+}
+
+func TestDescribe(t *testing.T) {
+	scanner := newTestScanner(t, time.Second)
+	expected := &Description{
+		Name:             "test",
+		Protocol:         "test",
+		ShortDescription: "Test",
+		LongDescription:  "A test plugin",
+		Port:             70,
+	}
+	if !reflect.DeepEqual(scanner.module.description, expected) {
+		t.Errorf("expected %+v, got %+v", expected, scanner.module.description)
+	}
+}
+
+func TestScanProtocol(t *testing.T) {
+	scanner := newTestScanner(t, 5*time.Second)
+
+	status, result, err := scanTestPlugin(t, scanner, "example.com", nil)
+	if status != zgrab2.SCAN_SUCCESS || err != nil {
+		t.Fatalf("expected success, got %s (%v)", status, err)
+	}
+	expected := Request{
+		IP:             "192.0.2.1",
+		Domain:         "example.com",
+		Port:           70,
+		Timeout:        5,
+		BytesReadLimit: zgrab2.DefaultBytesReadLimit,
+	}
+	if !reflect.DeepEqual(result.Request, expected) {
+		t.Errorf("expected request %+v, got %+v", expected, result.Request)
+	}
+	if !reflect.DeepEqual(result.Args, []string{"-x", "y"}) {
+		t.Errorf("expected the --plugin-args, got %q", result.Args)
+	}
+
+	// The target's port takes precedence, and the process is reused.
+	port := uint(7070)
+	_, second, _ := scanTestPlugin(t, scanner, "example.com", &port)
+	if second == nil || second.Request.Port != 7070 {
+		t.Errorf("expected the target's port to be sent, got %+v", second)
+	} else if second.PID != result.PID {
+		t.Errorf("expected the process to be reused, got pids %d and %d", result.PID, second.PID)
+	}
+
+	status, _, err = scanTestPlugin(t, scanner, "refused", nil)
+	if status != zgrab2.SCAN_CONNECTION_REFUSED || err == nil || err.Error() != "refused" {
+		t.Errorf("expected the plugin's error, got %s (%v)", status, err)
+	}
+	status, _, err = scanTestPlugin(t, scanner, "garbage", nil)
+	if status != zgrab2.SCAN_PROTOCOL_ERROR || err == nil {
+		t.Errorf("expected a protocol error for an invalid response, got %s (%v)", status, err)
+	}
+	status, _, err = scanTestPlugin(t, scanner, "exit", nil)
+	if status != zgrab2.SCAN_UNKNOWN_ERROR || err != errPluginExited {
+		t.Errorf("expected errPluginExited, got %s (%v)", status, err)
+	}
+	waitExited(t, result.PID)
+	if len(scanner.pool.idle) != 0 {
+		t.Errorf("expected the exited process to be dropped from the pool, got %d idle", len(scanner.pool.idle))
+	}
+}
+
+func TestPool(t *testing.T) {
+	scanner := newTestScanner(t, 5*time.Second)
+
+	// Concurrent scans each get a process of their own.
+	const concurrency = 3
+	pids := make(chan int, concurrency)
+	var procs []*process
+	for i := 0; i < concurrency; i++ {
+		proc, err := scanner.pool.get()
+		if err != nil {
+			t.Fatalf("could not start process: %v", err)
+		}
+		procs = append(procs, proc)
+	}
+	var wg sync.WaitGroup
+	for _, proc := range procs {
+		wg.Add(1)
+		go func(proc *process) {
+			defer wg.Done()
+			if err := proc.send(&Request{Domain: "example.com"}); err != nil {
+				t.Errorf("could not send request: %v", err)
+				return
+			}
+			var result struct {
+				Result testResult `json:"result"`
+			}
+			if err := json.Unmarshal(<-proc.responses, &result); err != nil {
+				t.Errorf("invalid response: %v", err)
+				return
+			}
+			pids <- result.Result.PID
+		}(proc)
+	}
+	wg.Wait()
+	close(pids)
+	seen := make(map[int]bool)
+	for pid := range pids {
+		seen[pid] = true
+	}
+	if len(seen) != concurrency {
+		t.Errorf("expected %d processes, got %v", concurrency, seen)
+	}
+
+	// Idle processes are reused rather than started anew.
+	for _, proc := range procs {
+		scanner.pool.put(proc)
+	}
+	for i := 0; i < concurrency; i++ {
+		_, result, err := scanTestPlugin(t, scanner, "example.com", nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !seen[result.PID] {
+			t.Errorf("expected an idle process to be reused, got pid %d", result.PID)
+		}
+	}
+	for _, proc := range scanner.pool.idle {
+		proc.kill()
+	}
+}
+
+func TestScanTimeout(t *testing.T) {
+	scanner := newTestScanner(t, 200*time.Millisecond)
+	_, result, err := scanTestPlugin(t, scanner, "example.com", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	start := time.Now()
+	status, _, err := scanTestPlugin(t, scanner, "hang", nil)
+	if status != zgrab2.SCAN_IO_TIMEOUT || err == nil {
+		t.Errorf("expected a timeout, got %s (%v)", status, err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("scan took %v", elapsed)
+	}
+	// The hung process is killed, and not returned to the pool.
+	waitExited(t, result.PID)
+	if len(scanner.pool.idle) != 0 {
+		t.Errorf("expected the hung process to be dropped from the pool, got %d idle", len(scanner.pool.idle))
+	}
+	_, next, err := scanTestPlugin(t, scanner, "example.com", nil)
+	if err != nil || next.PID == result.PID {
+		t.Errorf("expected a new process, got %+v (%v)", next, err)
+	}
+	for _, proc := range scanner.pool.idle {
+		proc.kill()
+	}
+}
@@ -150,6 +150,18 @@ func (scanner *Scanner) Protocol() string {
 	return "pop3"
 }
 
+// HandsOffConnection returns true if the scanner leaves the connection in
+// plaintext, without sending QUIT, so that it can be passed on with --share-connection.
+func (scanner *Scanner) HandsOffConnection() bool {
+	return !scanner.config.POP3Secure && !scanner.config.StartTLS && !scanner.config.SendQUIT
+}
+
+// TakesOverConnection returns false, since the scan starts by reading the
+// server's banner.
+func (scanner *Scanner) TakesOverConnection() bool {
+	return false
+}
+
 // GetPort returns the port being scanned.
 func (scanner *Scanner) GetPort() uint {
 	return scanner.config.Port
@@ -191,7 +191,7 @@ func (c *Connection) RequestSSL() (bool, *zgrab2.ScanError) {
 	}
 	switch packet.Type {
 	case 'E':
-		return false, zgrab2.NewScanError(zgrab2.SCAN_APPLICATION_ERROR, fmt.Errorf("Application rejected SSLRequest packet -- response = %s", packet.ToString()))
+		return false, zgrab2.NewRejectionError(zgrab2.REJECTED_ENCRYPTION_UNSUPPORTED, fmt.Errorf("Application rejected SSLRequest packet -- response = %s", packet.ToString()))
 	default:
 		// Returning PROTOCOL_ERROR here since any garbage data that starts with a small-ish u32 could be a valid packet, and no known server versions return anything beyond S/N/E.
 		return false, zgrab2.NewScanError(zgrab2.SCAN_PROTOCOL_ERROR, fmt.Errorf("Unexpected response type '%c' from server (full response = %s)", packet.Type, packet.ToString()))
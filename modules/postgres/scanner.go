@@ -309,6 +309,15 @@ func (s *Scanner) Protocol() string {
 	return "postgres"
 }
 
+// ProbeClasses returns ProbeAuthenticating if a user is sent in the
+// StartupMessage, which makes the server start authenticating it.
+func (s *Scanner) ProbeClasses() []string {
+	if s.Config.User != "" {
+		return []string{zgrab2.ProbeAuthenticating}
+	}
+	return nil
+}
+
 // GetName returns the name from the parameters.
 func (s *Scanner) GetName() string {
 	return s.Config.Name
@@ -0,0 +1,185 @@
+package probe
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Identification is the service, product and version information produced
+// by a match line.
+type Identification struct {
+	// Service is the service name, e.g. "http" or "ssh".
+	Service string `json:"service"`
+
+	// SoftMatch is true if the service was identified only by a softmatch,
+	// so no product / version information is available.
+	SoftMatch bool `json:"soft_match,omitempty"`
+
+	Product    string   `json:"product,omitempty"`
+	Version    string   `json:"version,omitempty"`
+	Info       string   `json:"info,omitempty"`
+	Hostname   string   `json:"hostname,omitempty"`
+	OS         string   `json:"os,omitempty"`
+	DeviceType string   `json:"device_type,omitempty"`
+	CPE        []string `json:"cpe,omitempty"`
+}
+
+// latin1 decodes the response as Latin-1, so that each byte becomes exactly
+// one rune and patterns' \xHH escapes match raw bytes.
+func latin1(data []byte) string {
+	buf := make([]rune, len(data))
+	for i, b := range data {
+		buf[i] = rune(b)
+	}
+	return string(buf)
+}
+
+// unlatin1 reverses latin1.
+func unlatin1(s string) []byte {
+	ret := make([]byte, 0, len(s))
+	for _, r := range s {
+		ret = append(ret, byte(r))
+	}
+	return ret
+}
+
+// Apply matches the pattern against the (Latin-1 decoded) response, and
+// returns the resulting identification, or nil if the pattern does not match.
+func (m *Match) Apply(response string) *Identification {
+	groups := m.Pattern.FindStringSubmatch(response)
+	if groups == nil {
+		return nil
+	}
+	ret := &Identification{
+		Service:    m.Service,
+		SoftMatch:  m.Soft,
+		Product:    expand(m.Templates["p"], groups),
+		Version:    expand(m.Templates["v"], groups),
+		Info:       expand(m.Templates["i"], groups),
+		Hostname:   expand(m.Templates["h"], groups),
+		OS:         expand(m.Templates["o"], groups),
+		DeviceType: expand(m.Templates["d"], groups),
+	}
+	for _, cpe := range m.CPE {
+		ret.CPE = append(ret.CPE, expand(cpe, groups))
+	}
+	return ret
+}
+
+// expand substitutes the capture groups into a version info template. It
+// supports $1-$9, and the helpers $P(n) (printable characters only),
+// $SUBST(n,"from","to") and $I(n,">") / $I(n,"<") (unsigned integer decoding
+// of big / little endian bytes).
+func expand(template string, groups []string) string {
+	if template == "" {
+		return ""
+	}
+	var out bytes.Buffer
+	for i := 0; i < len(template); i++ {
+		c := template[i]
+		if c != '$' || i+1 >= len(template) {
+			out.WriteByte(c)
+			continue
+		}
+		rest := template[i+1:]
+		switch {
+		case rest[0] >= '1' && rest[0] <= '9':
+			out.WriteString(printable(group(groups, int(rest[0]-'0')), false))
+			i++
+		case strings.HasPrefix(rest, "P("), strings.HasPrefix(rest, "SUBST("), strings.HasPrefix(rest, "I("):
+			open := strings.IndexByte(rest, '(')
+			end := strings.IndexByte(rest, ')')
+			if end < 0 {
+				out.WriteByte(c)
+				continue
+			}
+			args := splitArgs(rest[open+1 : end])
+			out.WriteString(helper(rest[:open], args, groups))
+			i += end + 1
+		default:
+			out.WriteByte(c)
+		}
+	}
+	return strings.TrimSpace(out.String())
+}
+
+// group returns capture group n, or "" if there is no such group.
+func group(groups []string, n int) string {
+	if n < len(groups) {
+		return groups[n]
+	}
+	return ""
+}
+
+// splitArgs splits the arguments of a helper, removing quotes.
+func splitArgs(s string) []string {
+	var ret []string
+	for _, arg := range strings.Split(s, ",") {
+		arg = strings.TrimSpace(arg)
+		if unquoted, err := strconv.Unquote(arg); err == nil {
+			arg = unquoted
+		}
+		ret = append(ret, arg)
+	}
+	return ret
+}
+
+// helper evaluates one of the $P / $SUBST / $I template helpers.
+func helper(name string, args []string, groups []string) string {
+	if len(args) == 0 {
+		return ""
+	}
+	n, err := strconv.Atoi(args[0])
+	if err != nil {
+		return ""
+	}
+	value := group(groups, n)
+	switch name {
+	case "P":
+		return printable(value, true)
+	case "SUBST":
+		if len(args) != 3 {
+			return ""
+		}
+		return printable(strings.Replace(value, args[1], args[2], -1), false)
+	case "I":
+		if len(args) != 2 {
+			return ""
+		}
+		data := unlatin1(value)
+		if len(data) > 8 {
+			return ""
+		}
+		var v uint64
+		for i := range data {
+			b := data[i]
+			if args[1] == "<" {
+				b = data[len(data)-1-i]
+			}
+			v = v<<8 | uint64(b)
+		}
+		return strconv.FormatUint(v, 10)
+	}
+	return ""
+}
+
+// printable converts a Latin-1 decoded value back for display: if its raw
+// bytes are valid UTF-8 they are used as-is. If strip is true, non-printable
+// ASCII characters are dropped instead (as with $P()).
+func printable(value string, strip bool) string {
+	if !strip {
+		if raw := unlatin1(value); utf8.Valid(raw) {
+			return string(raw)
+		}
+		return value
+	}
+	var out bytes.Buffer
+	for _, r := range value {
+		if r >= 0x20 && r < 0x7f {
+			out.WriteRune(r)
+		}
+	}
+	return out.String()
+}
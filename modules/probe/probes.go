@@ -0,0 +1,373 @@
+package probe
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ServiceProbe is a single Probe entry from an nmap-service-probes file,
+// together with the match / softmatch lines and directives following it.
+type ServiceProbe struct {
+	// Protocol is "TCP" or "UDP".
+	Protocol string
+
+	// Name is the probe's name, e.g. "NULL" or "GetRequest".
+	Name string
+
+	// Payload is the (unescaped) probe string to send.
+	Payload []byte
+
+	// Ports and SSLPorts are the ports on which the probe is likely to
+	// elicit a response.
+	Ports    []portRange
+	SSLPorts []portRange
+
+	// Rarity is how rarely the probe is expected to elicit a response, from
+	// 1 (common) to 9 (rare).
+	Rarity int
+
+	// TotalWaitMS is how long to wait for a response, if given.
+	TotalWaitMS int
+
+	// Fallback names the probes whose matches are also tried against this
+	// probe's response.
+	Fallback []string
+
+	// Matches are the probe's match and softmatch lines, in file order.
+	Matches []*Match
+
+	fallbackProbes []*ServiceProbe
+}
+
+// Match is a single match or softmatch line.
+type Match struct {
+	// Service is the service name identified by the match.
+	Service string
+
+	// Soft is true for softmatch lines, which identify the service but not
+	// the product / version.
+	Soft bool
+
+	// Pattern is the compiled pattern. It is matched against the response
+	// decoded as Latin-1, so that \xHH escapes match raw bytes.
+	Pattern *regexp.Regexp
+
+	// Templates maps version info fields (p, v, i, h, o, d) to their
+	// templates, which may contain $1-style substitutions.
+	Templates map[string]string
+
+	// CPE is the list of cpe:/.../ templates.
+	CPE []string
+}
+
+// portRange is an inclusive range of ports.
+type portRange struct {
+	low, high uint
+}
+
+// ProbeFile is a parsed nmap-service-probes file.
+type ProbeFile struct {
+	// Probes are the probes in file order.
+	Probes []*ServiceProbe
+
+	// Skipped is the number of match lines whose patterns could not be
+	// compiled (typically those using PCRE-only features such as
+	// backreferences or lookaround).
+	Skipped int
+}
+
+// errNoProbe is returned for directives appearing before the first Probe.
+var errNoProbe = errors.New("directive appears before any Probe")
+
+// ParseProbes parses an nmap-service-probes file.
+func ParseProbes(r io.Reader) (*ProbeFile, error) {
+	ret := new(ProbeFile)
+	var current *ServiceProbe
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		directive, rest := line, ""
+		if i := strings.IndexAny(line, " \t"); i >= 0 {
+			directive, rest = line[:i], strings.TrimSpace(line[i+1:])
+		}
+		var err error
+		switch directive {
+		case "Exclude":
+			// Only meaningful to nmap's port scanner.
+		case "Probe":
+			current, err = parseProbeLine(rest)
+			if err == nil {
+				ret.Probes = append(ret.Probes, current)
+			}
+		case "match", "softmatch":
+			if current == nil {
+				err = errNoProbe
+				break
+			}
+			var m *Match
+			m, err = parseMatchLine(rest, directive == "softmatch")
+			if err == nil {
+				current.Matches = append(current.Matches, m)
+			} else if _, ok := err.(*regexError); ok {
+				ret.Skipped++
+				err = nil
+			}
+		case "ports", "sslports":
+			if current == nil {
+				err = errNoProbe
+				break
+			}
+			var ranges []portRange
+			ranges, err = parsePorts(rest)
+			if directive == "ports" {
+				current.Ports = ranges
+			} else {
+				current.SSLPorts = ranges
+			}
+		case "rarity":
+			if current == nil {
+				err = errNoProbe
+				break
+			}
+			current.Rarity, err = strconv.Atoi(rest)
+		case "totalwaitms":
+			if current == nil {
+				err = errNoProbe
+				break
+			}
+			current.TotalWaitMS, err = strconv.Atoi(rest)
+		case "fallback":
+			if current == nil {
+				err = errNoProbe
+				break
+			}
+			current.Fallback = strings.Split(rest, ",")
+		case "tcpwrappedms":
+			// Not used.
+		default:
+			err = fmt.Errorf("unknown directive %q", directive)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNo, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	ret.resolveFallbacks()
+	return ret, nil
+}
+
+// resolveFallbacks links each probe to its fallback probes. As in nmap, TCP
+// probes without an explicit fallback fall back to the NULL probe.
+func (file *ProbeFile) resolveFallbacks() {
+	byName := make(map[string]*ServiceProbe)
+	for _, probe := range file.Probes {
+		byName[probe.Protocol+"/"+probe.Name] = probe
+	}
+	for _, probe := range file.Probes {
+		for _, name := range probe.Fallback {
+			if fallback, ok := byName[probe.Protocol+"/"+strings.TrimSpace(name)]; ok && fallback != probe {
+				probe.fallbackProbes = append(probe.fallbackProbes, fallback)
+			}
+		}
+		if null, ok := byName["TCP/NULL"]; ok && probe.Protocol == "TCP" && probe != null {
+			probe.fallbackProbes = append(probe.fallbackProbes, null)
+		}
+	}
+}
+
+// parseProbeLine parses the arguments of a Probe directive:
+// <protocol> <name> q|<probe string>|
+func parseProbeLine(rest string) (*ServiceProbe, error) {
+	fields := strings.SplitN(rest, " ", 3)
+	if len(fields) < 3 {
+		return nil, fmt.Errorf("invalid Probe %q", rest)
+	}
+	protocol := strings.ToUpper(fields[0])
+	if protocol != "TCP" && protocol != "UDP" {
+		return nil, fmt.Errorf("invalid Probe protocol %q", fields[0])
+	}
+	str := fields[2]
+	if len(str) < 3 || str[0] != 'q' {
+		return nil, fmt.Errorf("invalid Probe string %q", str)
+	}
+	delim := str[1]
+	end := strings.IndexByte(str[2:], delim)
+	if end < 0 {
+		return nil, fmt.Errorf("unterminated Probe string %q", str)
+	}
+	payload, err := unescape(str[2 : 2+end])
+	if err != nil {
+		return nil, err
+	}
+	return &ServiceProbe{Protocol: protocol, Name: fields[1], Payload: payload, Rarity: 1}, nil
+}
+
+// unescape decodes the C-style escapes allowed in probe strings.
+func unescape(s string) ([]byte, error) {
+	ret := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			ret = append(ret, s[i])
+			continue
+		}
+		i++
+		if i >= len(s) {
+			return nil, fmt.Errorf("trailing backslash in %q", s)
+		}
+		switch s[i] {
+		case '0':
+			ret = append(ret, 0)
+		case 'a':
+			ret = append(ret, '\a')
+		case 'b':
+			ret = append(ret, '\b')
+		case 'f':
+			ret = append(ret, '\f')
+		case 'n':
+			ret = append(ret, '\n')
+		case 'r':
+			ret = append(ret, '\r')
+		case 't':
+			ret = append(ret, '\t')
+		case 'v':
+			ret = append(ret, '\v')
+		case 'x':
+			if i+2 >= len(s) {
+				return nil, fmt.Errorf("truncated \\x escape in %q", s)
+			}
+			b, err := strconv.ParseUint(s[i+1:i+3], 16, 8)
+			if err != nil {
+				return nil, fmt.Errorf("invalid \\x escape in %q", s)
+			}
+			ret = append(ret, byte(b))
+			i += 2
+		default:
+			ret = append(ret, s[i])
+		}
+	}
+	return ret, nil
+}
+
+// regexError is returned for match lines whose pattern cannot be compiled;
+// these are skipped rather than failing the whole file.
+type regexError struct {
+	err error
+}
+
+func (e *regexError) Error() string {
+	return e.err.Error()
+}
+
+// parseMatchLine parses the arguments of a match / softmatch directive:
+// <service> m<delim><pattern><delim>[opts] [<versioninfo> ...]
+func parseMatchLine(rest string, soft bool) (*Match, error) {
+	i := strings.IndexByte(rest, ' ')
+	if i < 0 {
+		return nil, fmt.Errorf("invalid match %q", rest)
+	}
+	ret := &Match{Service: rest[:i], Soft: soft, Templates: make(map[string]string)}
+	rest = strings.TrimLeft(rest[i+1:], " ")
+	if len(rest) < 3 || rest[0] != 'm' {
+		return nil, fmt.Errorf("invalid match pattern %q", rest)
+	}
+	delim := rest[1]
+	end := strings.IndexByte(rest[2:], delim)
+	if end < 0 {
+		return nil, fmt.Errorf("unterminated match pattern %q", rest)
+	}
+	pattern := rest[2 : 2+end]
+	rest = rest[2+end+1:]
+	var opts string
+	for len(rest) > 0 && (rest[0] == 'i' || rest[0] == 's') {
+		opts += rest[:1]
+		rest = rest[1:]
+	}
+	if opts != "" {
+		pattern = "(?" + opts + ")" + pattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, &regexError{err}
+	}
+	ret.Pattern = re
+
+	// Version info fields are of the form <field><delim><template><delim>,
+	// and cpe:<delim><template><delim>[a], separated by whitespace.
+	for {
+		rest = strings.TrimLeft(rest, " \t")
+		if rest == "" {
+			break
+		}
+		field := rest[:1]
+		if strings.HasPrefix(rest, "cpe:") {
+			field = "cpe"
+		}
+		rest = rest[len(field):]
+		if field == "cpe" {
+			rest = rest[1:]
+		}
+		if rest == "" {
+			return nil, fmt.Errorf("truncated version info for %s", field)
+		}
+		delim := rest[0]
+		end := strings.IndexByte(rest[1:], delim)
+		if end < 0 {
+			return nil, fmt.Errorf("unterminated version info for %s", field)
+		}
+		value := rest[1 : 1+end]
+		rest = rest[1+end+1:]
+		if field == "cpe" {
+			ret.CPE = append(ret.CPE, "cpe:/"+value)
+			rest = strings.TrimPrefix(rest, "a")
+			continue
+		}
+		ret.Templates[field] = value
+	}
+	return ret, nil
+}
+
+// parsePorts parses a comma-separated list of ports and port ranges.
+func parsePorts(s string) ([]portRange, error) {
+	var ret []portRange
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		low, high := part, part
+		if i := strings.IndexByte(part, '-'); i >= 0 {
+			low, high = part[:i], part[i+1:]
+		}
+		l, err := strconv.ParseUint(low, 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port %q", part)
+		}
+		h, err := strconv.ParseUint(high, 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port %q", part)
+		}
+		ret = append(ret, portRange{uint(l), uint(h)})
+	}
+	return ret, nil
+}
+
+// hasPort returns true if port is in one of the ranges.
+func hasPort(ranges []portRange, port uint) bool {
+	for _, r := range ranges {
+		if port >= r.low && port <= r.high {
+			return true
+		}
+	}
+	return false
+}
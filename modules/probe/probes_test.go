@@ -0,0 +1,126 @@
+package probe
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testProbes = `# Test probes
+Exclude T:9100-9107
+
+Probe TCP NULL q||
+totalwaitms 6000
+match ftp m|^220 ProFTPD (\d[-.\w]+) Server| p/ProFTPD/ v/$1/ cpe:/a:proftpd:proftpd:$1/
+match ssh m|^SSH-([\d.]+)-OpenSSH_([\w._-]+)\r?\n|i p/OpenSSH/ v/$2/ i/protocol $1/
+softmatch ftp m|^220[- ]|
+
+Probe TCP GetRequest q|GET / HTTP/1.0\r\n\r\n|
+rarity 1
+ports 80,8000-8010
+match http m|^HTTP/1\.[01] \d\d\d .*\r\nServer: nginx/([\d.]+)\r\n|s p/nginx/ v/$1/
+match http m|(?<=foo)bar| p/lookbehind/
+
+Probe UDP DNSStatusRequest q|\0\0\x10\0\0\0\0\0\0\0\0\0|
+rarity 9
+match dns m|^\0\0\x90| p/some DNS/ i/$I(1,">")/
+`
+
+func TestParseProbes(t *testing.T) {
+	file, err := ParseProbes(strings.NewReader(testProbes))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(file.Probes) != 3 {
+		t.Fatalf("expected 3 probes, got %d", len(file.Probes))
+	}
+	if file.Skipped != 1 {
+		t.Errorf("expected 1 skipped match, got %d", file.Skipped)
+	}
+	null, get, dns := file.Probes[0], file.Probes[1], file.Probes[2]
+	if null.TotalWaitMS != 6000 || len(null.Matches) != 3 || !null.Matches[2].Soft {
+		t.Errorf("unexpected NULL probe %+v", null)
+	}
+	if string(get.Payload) != "GET / HTTP/1.0\r\n\r\n" {
+		t.Errorf("unexpected GetRequest payload %q", get.Payload)
+	}
+	if !hasPort(get.Ports, 8005) || hasPort(get.Ports, 443) {
+		t.Errorf("unexpected GetRequest ports %v", get.Ports)
+	}
+	if !reflect.DeepEqual(get.fallbackProbes, []*ServiceProbe{null}) {
+		t.Errorf("expected GetRequest to fall back to NULL")
+	}
+	if dns.Protocol != "UDP" || dns.Rarity != 9 || string(dns.Payload) != "\x00\x00\x10\x00\x00\x00\x00\x00\x00\x00\x00\x00" {
+		t.Errorf("unexpected DNS probe %+v", dns)
+	}
+}
+
+func TestMatchProbe(t *testing.T) {
+	file, err := ParseProbes(strings.NewReader(testProbes))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	null, get := file.Probes[0], file.Probes[1]
+	tests := []struct {
+		probe    *ServiceProbe
+		response string
+		expected *Identification
+	}{
+		{
+			probe:    null,
+			response: "220 ProFTPD 1.3.5e Server (Debian)\r\n",
+			expected: &Identification{Service: "ftp", Product: "ProFTPD", Version: "1.3.5e", CPE: []string{"cpe:/a:proftpd:proftpd:1.3.5e"}},
+		},
+		{
+			probe:    null,
+			response: "220 some other ftpd\r\n",
+			expected: &Identification{Service: "ftp", SoftMatch: true},
+		},
+		{
+			probe:    null,
+			response: "ssh-2.0-openssh_7.4\r\n",
+			expected: &Identification{Service: "ssh", Product: "OpenSSH", Version: "7.4", Info: "protocol 2.0"},
+		},
+		{
+			// The GetRequest response should also be matched against the
+			// NULL probe's match lines.
+			probe:    get,
+			response: "SSH-2.0-OpenSSH_8.0\r\nProtocol mismatch.\n",
+			expected: &Identification{Service: "ssh", Product: "OpenSSH", Version: "8.0", Info: "protocol 2.0"},
+		},
+		{
+			probe:    get,
+			response: "HTTP/1.1 200 OK\r\nServer: nginx/1.14.2\r\n\r\n",
+			expected: &Identification{Service: "http", Product: "nginx", Version: "1.14.2"},
+		},
+		{
+			probe:    get,
+			response: "\x00\xff",
+			expected: nil,
+		},
+	}
+	for _, test := range tests {
+		actual := matchProbe(test.probe, []byte(test.response))
+		if !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("%s %q: expected %+v, got %+v", test.probe.Name, test.response, test.expected, actual)
+		}
+	}
+}
+
+func TestExpandHelpers(t *testing.T) {
+	groups := []string{"", "a\x01b\x02c", "x.y.z", latin1([]byte{0x01, 0x02})}
+	tests := map[string]string{
+		"$1":                "a\x01b\x02c",
+		"$P(1)":             "abc",
+		`$SUBST(2,".","_")`: "x_y_z",
+		`$I(3,">")`:         "258",
+		`$I(3,"<")`:         "513",
+		"version $2 ($9)":   "version x.y.z ()",
+		"price: $$":         "price: $$",
+	}
+	for template, expected := range tests {
+		if actual := expand(template, groups); actual != expected {
+			t.Errorf("%q: expected %q, got %q", template, expected, actual)
+		}
+	}
+}
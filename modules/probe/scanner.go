@@ -0,0 +1,370 @@
+// Package probe provides a zgrab2 module that identifies services using the
+// probes and match lines from an nmap-service-probes file.
+// Default Port: 80 (TCP); the port should normally be given with --port.
+//
+// For each target, the probes of the selected protocol are sent in turn, each
+// on a new connection: first the NULL probe
+// (which sends nothing and waits for a banner), then any probes listing the
+// scanned port in their ports directive, then the remaining probes whose
+// rarity is at most --intensity. Responses are checked against the probe's
+// match / softmatch lines (and those of its fallback probes), and the scan
+// stops at the first hard match. After a softmatch, only probes that can
+// further identify the same service are sent.
+//
+// The TCP probes are sent by default. With --transports=udp,tcp (for
+// example), the UDP probes are sent first, and the TCP probes only if the
+// target does not respond to any of them; --udp is equivalent to
+// --transports=udp.
+//
+// Patterns are compiled with Go's regexp package, so match lines using
+// PCRE-only features (e.g. backreferences and lookaround) are skipped.
+// Probes are not sent over TLS, so sslports directives are ignored.
+//
+// The output is the service, product, version and other version info fields
+// of the match, the name of the probe that elicited it and the response.
+package probe
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/zmap/zgrab2"
+)
+
+// defaultWait is the time to wait for a response to a probe with no
+// totalwaitms directive (matching nmap's default).
+const defaultWait = 5 * time.Second
+
+// maxResponseSize is the maximum number of bytes read in response to a probe.
+const maxResponseSize = 64 * 1024
+
+// ErrNoMatch is returned when the target responded to at least one probe,
+// but no match line matched any of the responses.
+var ErrNoMatch = errors.New("no probe response matched")
+
+// ErrNoResponse is returned when the target did not respond to any probe.
+var ErrNoResponse = errors.New("no response to any probe")
+
+// Flags holds the command-line configuration for the probe module.
+type Flags struct {
+	zgrab2.BaseFlags
+	zgrab2.UDPFlags
+	zgrab2.TransportFlags
+
+	ProbesFile string `long:"probes-file" default:"/usr/share/nmap/nmap-service-probes" description:"Path to an nmap-service-probes file"`
+	UDP        bool   `long:"udp" description:"Send the UDP probes rather than the TCP probes (same as --transports=udp)"`
+	Intensity  int    `long:"intensity" default:"7" description:"Send probes with rarity up to this value (0-9), in addition to those listing the scanned port"`
+	Probes     string `long:"probes" description:"Comma-separated names of the probes to send, in file order (overrides --intensity)"`
+	Verbose    bool   `long:"verbose" description:"More verbose logging, include debug fields in the scan results"`
+}
+
+// Module implements the zgrab2.Module interface.
+type Module struct {
+}
+
+// Scanner implements the zgrab2.Scanner interface.
+type Scanner struct {
+	config *Flags
+
+	// transports are the transports to try, in order of preference.
+	transports []string
+
+	// probes maps each transport to the probes to send over it, in order.
+	probes map[string][]*ServiceProbe
+}
+
+// Results is the output of the probe module.
+type Results struct {
+	// Identification is the result of the best match, if any.
+	*Identification
+
+	// Probe is the name of the probe whose response matched.
+	Probe string `json:"probe,omitempty"`
+
+	// Response is the response that matched, or if nothing matched, the
+	// first non-empty response.
+	Response []byte `json:"response,omitempty"`
+
+	// Responses maps each probe sent to the response received. Debug only.
+	Responses map[string][]byte `json:"responses,omitempty" zgrab:"debug"`
+}
+
+// RegisterModule registers the zgrab2 module.
+func RegisterModule() {
+	var module Module
+	cmd, err := zgrab2.AddCommand("probe", "nmap service probes", "Identify services with nmap-service-probes", 80, &module)
+	if err != nil {
+		log.Fatal(err)
+	}
+	cmd.FindOptionByLongName("transports").Default = []string{zgrab2.TransportTCP}
+}
+
+// NewFlags returns a default Flags object.
+func (module *Module) NewFlags() interface{} {
+	return new(Flags)
+}
+
+// NewScanner returns a new Scanner instance.
+func (module *Module) NewScanner() zgrab2.Scanner {
+	return new(Scanner)
+}
+
+// Validate checks that the flags are valid.
+// On success, returns nil.
+// On failure, returns an error instance describing the error.
+func (flags *Flags) Validate(args []string) error {
+	if flags.Intensity < 0 || flags.Intensity > 9 {
+		return fmt.Errorf("intensity must be in the range [0,9], given %d", flags.Intensity)
+	}
+	if flags.Transports == "" {
+		flags.Transports = zgrab2.TransportTCP
+	}
+	if !flags.UDP {
+		if _, err := zgrab2.ParseTransports(flags.Transports); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Help returns the module's help string.
+func (flags *Flags) Help() string {
+	return ""
+}
+
+// Init initializes the Scanner, loading the probes file.
+func (scanner *Scanner) Init(flags zgrab2.ScanFlags) error {
+	f, _ := flags.(*Flags)
+	scanner.config = f
+	if f.Verbose {
+		log.SetLevel(log.DebugLevel)
+	}
+	file, err := os.Open(f.ProbesFile)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	probes, err := ParseProbes(file)
+	if err != nil {
+		return fmt.Errorf("%s: %v", f.ProbesFile, err)
+	}
+	if probes.Skipped > 0 {
+		log.Debugf("%s: skipped %d match lines with unsupported patterns", f.ProbesFile, probes.Skipped)
+	}
+	if f.UDP {
+		scanner.transports = []string{zgrab2.TransportUDP}
+	} else if scanner.transports, err = zgrab2.ParseTransports(f.Transports); err != nil {
+		return err
+	}
+	scanner.probes = make(map[string][]*ServiceProbe)
+	for _, transport := range scanner.transports {
+		selected := scanner.selectProbes(probes, strings.ToUpper(transport))
+		if len(selected) == 0 {
+			return fmt.Errorf("%s: no %s probes selected", f.ProbesFile, transport)
+		}
+		scanner.probes[transport] = selected
+	}
+	return nil
+}
+
+// selectProbes returns the probes of the given protocol ("TCP" or "UDP") to
+// send, in order.
+func (scanner *Scanner) selectProbes(file *ProbeFile, protocol string) []*ServiceProbe {
+	var ret []*ServiceProbe
+	if scanner.config.Probes != "" {
+		names := make(map[string]bool)
+		for _, name := range strings.Split(scanner.config.Probes, ",") {
+			names[strings.TrimSpace(name)] = true
+		}
+		for _, probe := range file.Probes {
+			if probe.Protocol == protocol && names[probe.Name] {
+				ret = append(ret, probe)
+			}
+		}
+		return ret
+	}
+	var rest []*ServiceProbe
+	for _, probe := range file.Probes {
+		switch {
+		case probe.Protocol != protocol:
+		case probe.Name == "NULL":
+			ret = append([]*ServiceProbe{probe}, ret...)
+		case hasPort(probe.Ports, scanner.config.Port):
+			ret = append(ret, probe)
+		case probe.Rarity <= scanner.config.Intensity:
+			rest = append(rest, probe)
+		}
+	}
+	return append(ret, rest...)
+}
+
+// InitPerSender initializes the scanner for a given sender.
+func (scanner *Scanner) InitPerSender(senderID int) error {
+	return nil
+}
+
+// GetName returns the Scanner name defined in the Flags.
+func (scanner *Scanner) GetName() string {
+	return scanner.config.Name
+}
+
+// GetTrigger returns the Trigger defined in the Flags.
+func (scanner *Scanner) GetTrigger() string {
+	return scanner.config.Trigger
+}
+
+// Protocol returns the protocol identifier of the scan.
+func (scanner *Scanner) Protocol() string {
+	return "probe"
+}
+
+// GetPort returns the port being scanned.
+func (scanner *Scanner) GetPort() uint {
+	return scanner.config.Port
+}
+
+// GetTransports returns the transports to try, in order of preference.
+func (scanner *Scanner) GetTransports() []string {
+	return scanner.transports
+}
+
+// Scan sends the selected probes to the target until one of the responses
+// is identified by a match line.
+func (scanner *Scanner) Scan(target zgrab2.ScanTarget) (zgrab2.ScanStatus, interface{}, error) {
+	result := &Results{Responses: make(map[string][]byte)}
+	var lastErr error
+	connected := false
+	transport := target.Transport
+	if transport == "" {
+		transport = scanner.transports[0]
+	}
+	for _, probe := range scanner.probes[transport] {
+		if result.Identification != nil && !probeIdentifies(probe, result.Service) {
+			continue
+		}
+		response, err := scanner.sendProbe(&target, probe)
+		if err != nil {
+			if !connected {
+				// The port is closed / filtered; don't bother with the
+				// remaining probes.
+				return zgrab2.TryGetScanStatus(err), nil, err
+			}
+			lastErr = err
+			continue
+		}
+		connected = true
+		if len(response) == 0 {
+			continue
+		}
+		result.Responses[probe.Name] = response
+		if result.Response == nil {
+			result.Response = response
+		}
+		if id := matchProbe(probe, response); id != nil {
+			if result.Identification == nil || !id.SoftMatch {
+				result.Identification = id
+				result.Probe = probe.Name
+				result.Response = response
+			}
+			if !id.SoftMatch {
+				break
+			}
+		}
+	}
+	switch {
+	case result.Identification != nil:
+		return zgrab2.SCAN_SUCCESS, result, nil
+	case result.Response != nil:
+		return zgrab2.SCAN_PROTOCOL_ERROR, result, ErrNoMatch
+	case lastErr != nil:
+		return zgrab2.TryGetScanStatus(lastErr), nil, lastErr
+	default:
+		return zgrab2.SCAN_IO_TIMEOUT, nil, ErrNoResponse
+	}
+}
+
+// sendProbe sends the probe on a new connection and returns what the target
+// sends back before the probe's wait time expires, or a hard match is found.
+func (scanner *Scanner) sendProbe(target *zgrab2.ScanTarget, probe *ServiceProbe) ([]byte, error) {
+	var conn net.Conn
+	var err error
+	if probe.Protocol == "UDP" {
+		conn, err = target.OpenUDP(&scanner.config.BaseFlags, &scanner.config.UDPFlags)
+	} else {
+		conn, err = target.Open(&scanner.config.BaseFlags)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if len(probe.Payload) > 0 {
+		if _, err := conn.Write(probe.Payload); err != nil {
+			return nil, err
+		}
+	}
+	wait := defaultWait
+	if probe.TotalWaitMS > 0 {
+		wait = time.Duration(probe.TotalWaitMS) * time.Millisecond
+	}
+	if timeout := target.BoundTimeout(scanner.config.Timeout); timeout > 0 && timeout < wait {
+		wait = timeout
+	}
+	deadline := time.Now().Add(wait)
+	var response []byte
+	buf := make([]byte, 4096)
+	for len(response) < maxResponseSize {
+		if err := conn.SetReadDeadline(deadline); err != nil {
+			return nil, err
+		}
+		n, err := conn.Read(buf)
+		response = append(response, buf[:n]...)
+		if n > 0 {
+			if id := matchProbe(probe, response); id != nil && !id.SoftMatch {
+				break
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+	return response, nil
+}
+
+// matchProbe checks the response against the probe's match lines, then those
+// of its fallback probes, returning the first hard match, or failing that the
+// first softmatch.
+func matchProbe(probe *ServiceProbe, response []byte) *Identification {
+	decoded := latin1(response)
+	var soft *Identification
+	for _, p := range append([]*ServiceProbe{probe}, probe.fallbackProbes...) {
+		for _, m := range p.Matches {
+			id := m.Apply(decoded)
+			if id == nil {
+				continue
+			}
+			if !id.SoftMatch {
+				return id
+			}
+			if soft == nil {
+				soft = id
+			}
+		}
+	}
+	return soft
+}
+
+// probeIdentifies returns true if the probe has a hard match line for the
+// given service, i.e. it is worth sending after a softmatch for the service.
+func probeIdentifies(probe *ServiceProbe, service string) bool {
+	for _, m := range probe.Matches {
+		if !m.Soft && m.Service == service {
+			return true
+		}
+	}
+	return false
+}
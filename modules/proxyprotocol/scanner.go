@@ -0,0 +1,260 @@
+// Package proxyprotocol provides a zgrab2 module that detects servers that
+// accept an HAProxy PROXY protocol header from the scanner, i.e. that trust
+// any client to tell them which address a connection comes from.
+// Default Port: 80 (TCP)
+//
+// The module sends --payload (by default an HTTP request) on three
+// connections:
+//  1. with no header (the baseline),
+//  2. after a PROXY header giving the scanner's real address, and
+//  3. after a PROXY header claiming to come from --spoofed-source.
+//
+// A server accepts the header if the second connection gets a response that
+// is not a rejection (an empty response or an HTTP 400); it requires it if it
+// also rejects the baseline. If the responses to the second and third
+// connections differ in their first line, or the third reflects the spoofed
+// address, the server's behavior depends on the claimed client address.
+//
+// The comparison assumes a client-first protocol such as HTTP; servers that
+// send a banner before reading anything may appear to accept the header.
+package proxyprotocol
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/zmap/zgrab2"
+)
+
+// maxResponseSize is the maximum number of bytes read on each connection.
+const maxResponseSize = 16 * 1024
+
+// Flags holds the command-line configuration for the proxyprotocol module.
+type Flags struct {
+	zgrab2.BaseFlags
+
+	Version       int    `long:"version" default:"1" choice:"1" choice:"2" description:"PROXY protocol version to send"`
+	SpoofedSource string `long:"spoofed-source" default:"127.0.0.1:40000" description:"Client address claimed by the spoofed PROXY header"`
+	Payload       string `long:"payload" default:"GET / HTTP/1.0\\r\\n\\r\\n" description:"Data to send after the header, with Go string escapes"`
+	Verbose       bool   `long:"verbose" description:"More verbose logging, include debug fields in the scan results"`
+}
+
+// Module implements the zgrab2.Module interface.
+type Module struct {
+}
+
+// Scanner implements the zgrab2.Scanner interface.
+type Scanner struct {
+	config  *Flags
+	payload []byte
+	spoofed net.IP
+}
+
+// Exchange is the result of sending the payload on one connection.
+type Exchange struct {
+	// Response is what the server sent back.
+	Response []byte `json:"response,omitempty"`
+
+	// Error describes why the connection or exchange failed, if it did.
+	Error string `json:"error,omitempty"`
+}
+
+// Results is the output of the proxyprotocol module.
+type Results struct {
+	// Accepted is true if the server accepted a PROXY header.
+	Accepted bool `json:"accepted"`
+
+	// Required is true if the server rejected the payload without a PROXY
+	// header, but accepted it with one.
+	Required bool `json:"required,omitempty"`
+
+	// ClientAddressSensitive is true if the server responded differently
+	// when the PROXY header claimed the spoofed address.
+	ClientAddressSensitive bool `json:"client_address_sensitive,omitempty"`
+
+	// SpoofedAddressReflected is true if the response to the spoofed header
+	// contains the spoofed address.
+	SpoofedAddressReflected bool `json:"spoofed_address_reflected,omitempty"`
+
+	// Baseline is the exchange without a PROXY header.
+	Baseline *Exchange `json:"baseline,omitempty"`
+
+	// Proxied is the exchange after a PROXY header with the real address.
+	Proxied *Exchange `json:"proxied,omitempty"`
+
+	// Spoofed is the exchange after a PROXY header with the spoofed address.
+	Spoofed *Exchange `json:"spoofed,omitempty"`
+}
+
+// RegisterModule registers the zgrab2 module.
+func RegisterModule() {
+	var module Module
+	_, err := zgrab2.AddCommand("proxyprotocol", "PROXY protocol exposure", "Detect servers that accept a PROXY protocol header from the scanner", 80, &module)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// NewFlags returns a default Flags object.
+func (module *Module) NewFlags() interface{} {
+	return new(Flags)
+}
+
+// NewScanner returns a new Scanner instance.
+func (module *Module) NewScanner() zgrab2.Scanner {
+	return new(Scanner)
+}
+
+// Validate checks that the flags are valid.
+// On success, returns nil.
+// On failure, returns an error instance describing the error.
+func (flags *Flags) Validate(args []string) error {
+	if flags.Version != 1 && flags.Version != 2 {
+		return fmt.Errorf("version must be 1 or 2, given %d", flags.Version)
+	}
+	if _, err := zgrab2.ParsePreludes(flags.prelude(flags.SpoofedSource)); err != nil {
+		return err
+	}
+	if _, err := strconv.Unquote(`"` + flags.Payload + `"`); err != nil {
+		return fmt.Errorf("invalid payload %q: %v", flags.Payload, err)
+	}
+	return nil
+}
+
+// Help returns the module's help string.
+func (flags *Flags) Help() string {
+	return ""
+}
+
+// prelude returns the --prelude specification of a PROXY header claiming the
+// given source, or the real address if source is empty.
+func (flags *Flags) prelude(source string) string {
+	spec := fmt.Sprintf("proxy-v%d", flags.Version)
+	if source != "" {
+		spec += "=" + source
+	}
+	return spec
+}
+
+// Init initializes the Scanner.
+func (scanner *Scanner) Init(flags zgrab2.ScanFlags) error {
+	f, _ := flags.(*Flags)
+	scanner.config = f
+	if f.Verbose {
+		log.SetLevel(log.DebugLevel)
+	}
+	payload, err := strconv.Unquote(`"` + f.Payload + `"`)
+	if err != nil {
+		return err
+	}
+	scanner.payload = []byte(payload)
+	host, _, err := net.SplitHostPort(f.SpoofedSource)
+	if err != nil {
+		return err
+	}
+	scanner.spoofed = net.ParseIP(host)
+	return nil
+}
+
+// InitPerSender initializes the scanner for a given sender.
+func (scanner *Scanner) InitPerSender(senderID int) error {
+	return nil
+}
+
+// GetName returns the Scanner name defined in the Flags.
+func (scanner *Scanner) GetName() string {
+	return scanner.config.Name
+}
+
+// GetTrigger returns the Trigger defined in the Flags.
+func (scanner *Scanner) GetTrigger() string {
+	return scanner.config.Trigger
+}
+
+// Protocol returns the protocol identifier of the scan.
+func (scanner *Scanner) Protocol() string {
+	return "proxyprotocol"
+}
+
+// GetPort returns the port being scanned.
+func (scanner *Scanner) GetPort() uint {
+	return scanner.config.Port
+}
+
+// Scan sends the payload with no header, a truthful header and a spoofed
+// header, and compares the responses.
+func (scanner *Scanner) Scan(target zgrab2.ScanTarget) (zgrab2.ScanStatus, interface{}, error) {
+	result := new(Results)
+	var err error
+	// If the port is closed there is no point in trying the others.
+	if result.Baseline, err = scanner.exchange(&target, ""); err != nil {
+		return zgrab2.TryGetScanStatus(err), nil, err
+	}
+	result.Proxied, _ = scanner.exchange(&target, scanner.config.prelude(""))
+	result.Spoofed, _ = scanner.exchange(&target, scanner.config.prelude(scanner.config.SpoofedSource))
+
+	result.Accepted = !isRejection(result.Proxied)
+	result.Required = result.Accepted && isRejection(result.Baseline)
+	if result.Accepted {
+		result.ClientAddressSensitive = !bytes.Equal(firstLine(result.Proxied.Response), firstLine(result.Spoofed.Response))
+		result.SpoofedAddressReflected = bytes.Contains(result.Spoofed.Response, []byte(scanner.spoofed.String()))
+	}
+	return zgrab2.SCAN_SUCCESS, result, nil
+}
+
+// exchange connects to the target, sending the given prelude (if any) and
+// the payload, and reads the response until the server closes the
+// connection or stops sending. The error is only returned if the connection
+// could not be established; other errors are recorded in the Exchange.
+func (scanner *Scanner) exchange(target *zgrab2.ScanTarget, prelude string) (*Exchange, error) {
+	flags := scanner.config.BaseFlags
+	flags.Prelude = prelude
+	ret := new(Exchange)
+	conn, err := target.Open(&flags)
+	if err != nil {
+		ret.Error = err.Error()
+		return ret, err
+	}
+	defer conn.Close()
+	if _, err := conn.Write(scanner.payload); err != nil {
+		ret.Error = err.Error()
+		return ret, nil
+	}
+	buf := make([]byte, 4096)
+	deadline := time.Now().Add(target.BoundTimeout(scanner.config.Timeout))
+	for len(ret.Response) < maxResponseSize {
+		conn.SetReadDeadline(deadline)
+		n, err := conn.Read(buf)
+		ret.Response = append(ret.Response, buf[:n]...)
+		if err != nil {
+			if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() || len(ret.Response) == 0 {
+				ret.Error = err.Error()
+			}
+			break
+		}
+	}
+	return ret, nil
+}
+
+// isRejection returns true if the exchange got no response, or an HTTP 400
+// response (which is how HTTP servers treat an unexpected PROXY header, or
+// its absence where one is required).
+func isRejection(exchange *Exchange) bool {
+	if exchange == nil || len(exchange.Response) == 0 {
+		return true
+	}
+	fields := bytes.Fields(firstLine(exchange.Response))
+	return len(fields) >= 2 && bytes.HasPrefix(fields[0], []byte("HTTP/")) && string(fields[1]) == "400"
+}
+
+// firstLine returns the first line of the response, without the line ending.
+func firstLine(response []byte) []byte {
+	if i := bytes.IndexByte(response, '\n'); i >= 0 {
+		response = response[:i]
+	}
+	return bytes.TrimRight(response, "\r")
+}
@@ -0,0 +1,87 @@
+package proxyprotocol
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zmap/zgrab2"
+)
+
+// startServer listens on a loopback port for HTTP requests, optionally
+// preceded by a PROXY v1 header. If requireHeader is set, requests without a
+// header are rejected; otherwise requests with one are. Clients claiming to
+// be 192.0.2.1 get a different response.
+func startServer(t *testing.T, requireHeader bool) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not listen: %v", err)
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+				line, _ := reader.ReadString('\n')
+				client := ""
+				if strings.HasPrefix(line, "PROXY ") {
+					client = strings.Fields(line)[2]
+					line, _ = reader.ReadString('\n')
+				}
+				switch {
+				case (client != "") != requireHeader:
+					conn.Write([]byte("HTTP/1.0 400 Bad Request\r\n\r\n"))
+				case client == "192.0.2.1":
+					conn.Write([]byte("HTTP/1.0 200 OK\r\n\r\nadmin console for " + client))
+				default:
+					conn.Write([]byte("HTTP/1.0 403 Forbidden\r\n\r\n"))
+				}
+			}(conn)
+		}
+	}()
+	return listener
+}
+
+func scan(t *testing.T, listener net.Listener) *Results {
+	flags := &Flags{
+		Version:       1,
+		SpoofedSource: "192.0.2.1:40000",
+		Payload:       `GET / HTTP/1.0\r\n\r\n`,
+	}
+	flags.Port = uint(listener.Addr().(*net.TCPAddr).Port)
+	flags.Timeout = time.Second
+	if err := flags.Validate(nil); err != nil {
+		t.Fatalf("invalid flags: %v", err)
+	}
+	scanner := new(Scanner)
+	if err := scanner.Init(flags); err != nil {
+		t.Fatalf("could not initialize scanner: %v", err)
+	}
+	status, res, err := scanner.Scan(zgrab2.ScanTarget{IP: net.ParseIP("127.0.0.1")})
+	if status != zgrab2.SCAN_SUCCESS || err != nil {
+		t.Fatalf("expected success, got %s (%v)", status, err)
+	}
+	return res.(*Results)
+}
+
+func TestScan(t *testing.T) {
+	listener := startServer(t, true)
+	defer listener.Close()
+	result := scan(t, listener)
+	if !result.Accepted || !result.Required || !result.ClientAddressSensitive || !result.SpoofedAddressReflected {
+		t.Errorf("expected the header to be accepted, required and trusted, got %+v", result)
+	}
+
+	listener = startServer(t, false)
+	defer listener.Close()
+	result = scan(t, listener)
+	if result.Accepted || result.Required || result.ClientAddressSensitive {
+		t.Errorf("expected the header to be rejected, got %+v", result)
+	}
+}
@@ -61,7 +61,7 @@ type Result struct {
 
 	// RawCommandOutput is the output returned by the server for each command sent;
 	// the index in RawCommandOutput matches the index in Commands.
-	RawCommandOutput [][]byte `json:"raw_command_output,omitempty" zgrab:"debug"`
+	RawCommandOutput [][]byte `json:"raw_command_output,omitempty" zgrab:"debug,raw"`
 
 	// PingResponse is the response from the server, should be the simple string
 	// "PONG".
@@ -212,6 +212,25 @@ func (s *Scanner) Protocol() string {
 	return "redis"
 }
 
+// HandsOffConnection returns false, since the scan ends with QUIT.
+func (s *Scanner) HandsOffConnection() bool {
+	return false
+}
+
+// TakesOverConnection returns true: the scan starts with PING, so it can use
+// a connection passed on with --share-connection.
+func (s *Scanner) TakesOverConnection() bool {
+	return true
+}
+
+// ProbeClasses returns ProbeAuthenticating if a password is set.
+func (s *Scanner) ProbeClasses() []string {
+	if s.config.Password != "" {
+		return []string{zgrab2.ProbeAuthenticating}
+	}
+	return nil
+}
+
 // Scan executes the following commands:
 // 1. PING
 // 2. (only if --password is provided) AUTH <password>
@@ -3,6 +3,8 @@ package siemens
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
+	"fmt"
 	"net"
 
 	"github.com/zmap/zgrab2"
@@ -296,3 +298,64 @@ func readRequest(connection net.Conn, slzId uint16) (packet S7Packet, err error)
 
 	return packet, nil
 }
+
+// checkRequests returns an error unless each TPKT packet in the data is a
+// COTP connection request, or carries an S7 request to set up communication
+// or read an SZL (system status list), from which the device is identified.
+func checkRequests(data []byte) error {
+	if len(data) == 0 {
+		return errors.New("empty request")
+	}
+	for len(data) > 0 {
+		if len(data) < tpktLength+2 || data[0] != 3 {
+			return errors.New("not a TPKT packet")
+		}
+		end := int(binary.BigEndian.Uint16(data[2:4]))
+		if end < tpktLength+2 || end > len(data) {
+			return errors.New("invalid TPKT length")
+		}
+		cotp := data[tpktLength:end]
+		if err := checkCOTPRequest(cotp); err != nil {
+			return err
+		}
+		data = data[end:]
+	}
+	return nil
+}
+
+func checkCOTPRequest(cotp []byte) error {
+	headerEnd := int(cotp[0]) + 1
+	if headerEnd > len(cotp) {
+		return errors.New("invalid COTP header length")
+	}
+	switch cotp[1] & 0xf0 {
+	case 0xe0: // connection request
+		return nil
+	case 0xf0: // data
+	default:
+		return fmt.Errorf("COTP PDU type 0x%02x is not an identification request", cotp[1])
+	}
+	s7 := cotp[headerEnd:]
+	if len(s7) < 10 || s7[0] != S7_PROTOCOL_ID {
+		return errNotS7
+	}
+	paramLength := int(binary.BigEndian.Uint16(s7[6:8]))
+	if 10+paramLength > len(s7) || paramLength < 1 {
+		return errInvalidPacket
+	}
+	param := s7[10 : 10+paramLength]
+	switch s7[1] {
+	case S7_REQUEST:
+		if param[0] == 0xf0 { // setup communication
+			return nil
+		}
+		return fmt.Errorf("S7 job function 0x%02x is not an identification request", param[0])
+	case S7_REQUEST_USER_DATA:
+		if len(param) >= 7 && param[5] == (S7_SZL_REQUEST*0x10)+S7_SZL_FUNCTIONS && param[6] == S7_SZL_READ {
+			return nil
+		}
+		return errors.New("S7 user data function is not an SZL read")
+	default:
+		return fmt.Errorf("S7 PDU type 0x%02x is not a request", s7[1])
+	}
+}
@@ -84,6 +84,12 @@ func (scanner *Scanner) Protocol() string {
 	return "siemens"
 }
 
+// CheckICSRequest implements zgrab2.ICSScanner: with --ics-safe, the scanner
+// may only connect, set up communication and read SZLs.
+func (scanner *Scanner) CheckICSRequest(message []byte) error {
+	return checkRequests(message)
+}
+
 // GetPort returns the port being scanned.
 func (scanner *Scanner) GetPort() uint {
 	return scanner.config.Port
@@ -3,6 +3,8 @@
 package smb
 
 import (
+	"time"
+
 	log "github.com/sirupsen/logrus"
 	"github.com/zmap/zgrab2"
 	"github.com/zmap/zgrab2/lib/smb/smb"
@@ -16,6 +18,9 @@ type Flags struct {
 	// SetupSession tells the client to continue the handshake up to the point where credentials would be needed.
 	SetupSession bool `long:"setup-session" description:"After getting the response from the negotiation request, send a setup session packet."`
 
+	// NegotiateContexts tells the client to offer SMB 3.1.1 with negotiate contexts.
+	NegotiateContexts bool `long:"negotiate-contexts" description:"Offer all SMB 2/3 dialects, with SMB 3.1.1 negotiate contexts. With --debug, the server's contexts are parsed into the scan results."`
+
 	// Verbose requests more verbose logging / output.
 	Verbose bool `long:"verbose" description:"More verbose logging, include debug fields in the scan results"`
 }
@@ -87,16 +92,63 @@ func (scanner *Scanner) Protocol() string {
 	return "smb"
 }
 
+// ServerTime returns the system time in the server's SMB2 NEGOTIATE
+// response.
+func (scanner *Scanner) ServerTime(result interface{}) *zgrab2.ServerTime {
+	smbLog, ok := result.(*smb.SMBLog)
+	if !ok || smbLog == nil || smbLog.NegotiationLog == nil || smbLog.NegotiationLog.SystemTime == 0 {
+		return nil
+	}
+	return &zgrab2.ServerTime{Time: time.Unix(int64(smbLog.NegotiationLog.SystemTime), 0), Source: "smb_system_time", Resolution: time.Second}
+}
+
 // GetPort returns the port being scanned.
 func (scanner *Scanner) GetPort() uint {
 	return scanner.config.Port
 }
 
+// IdentifyProduct returns Windows, with the operating system version from
+// the NTLM challenge, if --setup-session was given and the server sent one.
+// Samba servers send a Windows version too, so they are misidentified.
+func (scanner *Scanner) IdentifyProduct(result interface{}) *zgrab2.Product {
+	smbLog, ok := result.(*smb.SMBLog)
+	if !ok || smbLog == nil || smbLog.SessionSetupLog == nil || smbLog.SessionSetupLog.OSVersion == nil {
+		return nil
+	}
+	return zgrab2.NewProduct(zgrab2.CPEOperatingSystem, "microsoft", "windows", smbLog.SessionSetupLog.OSVersion.String())
+}
+
+// NTLMInfo returns the names and version in the NTLM challenge, if
+// --setup-session was given and the server sent one.
+func (scanner *Scanner) NTLMInfo(result interface{}) *zgrab2.NTLMInfo {
+	smbLog, ok := result.(*smb.SMBLog)
+	if !ok || smbLog == nil || smbLog.SessionSetupLog == nil {
+		return nil
+	}
+	setup := smbLog.SessionSetupLog
+	ret := &zgrab2.NTLMInfo{TargetName: setup.TargetName}
+	if setup.OSVersion != nil {
+		ret.OSVersion = setup.OSVersion.String()
+	}
+	if info := setup.TargetInfo; info != nil {
+		ret.NetBIOSComputerName = info.NetBIOSComputerName
+		ret.NetBIOSDomainName = info.NetBIOSDomainName
+		ret.DNSComputerName = info.DNSComputerName
+		ret.DNSDomainName = info.DNSDomainName
+		ret.DNSTreeName = info.DNSTreeName
+	}
+	if *ret == (zgrab2.NTLMInfo{}) {
+		return nil
+	}
+	return ret
+}
+
 // Scan performs the following:
 // 1. Connect to the TCP port (default 445).
 // 2. Send a negotiation packet with the default values:
 //      Dialects = { DialectSmb_2_1 },
 //      SecurityMode = SecurityModeSigningEnabled
+//    With --negotiate-contexts, offer all dialects up to DialectSmb_3_1_1, with negotiate contexts.
 // 3. Read response from server; on failure, exit with log = nil.
 //      If the server returns a protocol ID indicating support for version 1, set smbv1_support = true
 //      Pull out the relevant information from the response packet
@@ -110,12 +162,9 @@ func (scanner *Scanner) Scan(target zgrab2.ScanTarget) (zgrab2.ScanStatus, inter
 		return zgrab2.TryGetScanStatus(err), nil, err
 	}
 	defer conn.Close()
-	var result *smb.SMBLog
-	if scanner.config.SetupSession {
-		result, err = smb.GetSMBLog(conn, scanner.config.Verbose)
-	} else {
-		result, err = smb.GetSMBBanner(conn, scanner.config.Verbose)
-	}
+	session := smb.NewLoggedSession(conn, smb.Options{NegotiateContexts: scanner.config.NegotiateContexts}, scanner.config.Verbose)
+	err = session.LoggedNegotiateProtocol(scanner.config.SetupSession)
+	result := session.Log
 	if err != nil {
 		return zgrab2.TryGetScanStatus(err), result, err
 	}
@@ -176,6 +176,18 @@ func (scanner *Scanner) Protocol() string {
 	return "smtp"
 }
 
+// HandsOffConnection returns true if the scanner leaves the connection in
+// plaintext, without sending QUIT, so that it can be passed on with --share-connection.
+func (scanner *Scanner) HandsOffConnection() bool {
+	return !scanner.config.SMTPSecure && !scanner.config.StartTLS && !scanner.config.SendQUIT
+}
+
+// TakesOverConnection returns false, since the scan starts by reading the
+// server's banner.
+func (scanner *Scanner) TakesOverConnection() bool {
+	return false
+}
+
 // GetPort returns the port being scanned.
 func (scanner *Scanner) GetPort() uint {
 	return scanner.config.Port
@@ -270,7 +282,7 @@ func (scanner *Scanner) Scan(target zgrab2.ScanTarget) (zgrab2.ScanStatus, inter
 			return zgrab2.TryGetScanStatus(err), result, err
 		}
 		if code < 200 || code >= 300 {
-			return zgrab2.SCAN_APPLICATION_ERROR, result, fmt.Errorf("SMTP error code %d returned from STARTTLS command (%s)", code, ret)
+			return zgrab2.SCAN_APPLICATION_ERROR, result, zgrab2.NewRejectionError(zgrab2.REJECTED_ENCRYPTION_UNSUPPORTED, fmt.Errorf("SMTP error code %d returned from STARTTLS command (%s)", code, ret))
 		}
 		tlsConn, err := scanner.config.TLSFlags.GetTLSConnection(conn.Conn)
 		if err != nil {
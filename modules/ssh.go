@@ -114,3 +114,50 @@ func (s *SSHScanner) Scan(t zgrab2.ScanTarget) (zgrab2.ScanStatus, interface{},
 func (s *SSHScanner) Protocol() string {
 	return "ssh"
 }
+
+// ProbeClasses returns ProbeAuthenticating if the 'none' userauth request is
+// sent.
+func (s *SSHScanner) ProbeClasses() []string {
+	if s.config.CollectUserAuth {
+		return []string{zgrab2.ProbeAuthenticating}
+	}
+	return nil
+}
+
+// knownSSHServers maps lowercased SSH software names to their CPE vendor and
+// product names.
+var knownSSHServers = map[string][2]string{
+	"openssh":  {"openbsd", "openssh"},
+	"dropbear": {"dropbear_ssh_project", "dropbear_ssh"},
+	"libssh":   {"libssh", "libssh"},
+	"paramiko": {"paramiko", "paramiko"},
+}
+
+// parseSSHSoftware returns the product named by the software version of an
+// SSH identification string, e.g. "OpenSSH_8.2p1" or "Cisco-1.25".
+func parseSSHSoftware(software string) *zgrab2.Product {
+	fields := strings.Fields(software)
+	if len(fields) == 0 {
+		return nil
+	}
+	name, version := fields[0], ""
+	if i := strings.Index(name, "_"); i > 0 {
+		name, version = name[:i], name[i+1:]
+	} else if i := strings.Index(name, "-"); i > 0 && i+1 < len(name) && name[i+1] >= '0' && name[i+1] <= '9' {
+		name, version = name[:i], name[i+1:]
+	}
+	if known, ok := knownSSHServers[strings.ToLower(name)]; ok {
+		return zgrab2.NewProduct(zgrab2.CPEApplication, known[0], known[1], version)
+	}
+	return zgrab2.NewProduct(zgrab2.CPEApplication, "", name, version)
+}
+
+// IdentifyProduct returns the SSH server named by the server's
+// identification string.
+func (s *SSHScanner) IdentifyProduct(result interface{}) *zgrab2.Product {
+	data, ok := result.(*ssh.HandshakeLog)
+	if !ok || data == nil || data.ServerID == nil {
+		return nil
+	}
+	return parseSSHSoftware(data.ServerID.SoftwareVersion)
+}
@@ -0,0 +1,347 @@
+package starttls
+
+import (
+	"encoding/asn1"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"strings"
+
+	"github.com/zmap/zgrab2"
+)
+
+const readBufferSize int = 0x10000
+
+var (
+	// lineEnd matches a response ending with a line ending.
+	lineEnd = regexp.MustCompile(`\n$`)
+
+	// replyEnd matches a complete, possibly multi-line, SMTP or FTP reply.
+	replyEnd = regexp.MustCompile(`(?:^\d\d\d\s.*\r\n$)|(?:^\d\d\d-[\s\S]*\r\n\d\d\d\s.*\r\n$)`)
+
+	// pop3ListEnd matches a POP3 error, or a multi-line response ended by a
+	// line containing a single dot.
+	pop3ListEnd = regexp.MustCompile(`(?:^-ERR.*\r\n$)|(?:\r\n\.\r\n$)`)
+
+	// nntpListEnd matches an NNTP error, or a multi-line response ended by a
+	// line containing a single dot.
+	nntpListEnd = regexp.MustCompile(`(?:^[45]\d\d .*\r\n$)|(?:\r\n\.\r\n$)`)
+
+	// xmppFeaturesEnd matches the end of the stream features, or of a stream
+	// closed by an error.
+	xmppFeaturesEnd = regexp.MustCompile(`</stream:features>|</stream:stream>`)
+
+	// xmppStartTLSEnd matches the server's answer to <starttls/>.
+	xmppStartTLSEnd = regexp.MustCompile(`<proceed[^>]*>|<failure[^>]*>|</stream:stream>`)
+)
+
+// exchanges maps each protocol to the function performing its STARTTLS
+// exchange.
+var exchanges = map[string]func(s *session) error{
+	"smtp":     smtpExchange,
+	"ftp":      ftpExchange,
+	"imap":     imapExchange,
+	"pop3":     pop3Exchange,
+	"nntp":     nntpExchange,
+	"xmpp":     xmppExchange,
+	"ldap":     ldapExchange,
+	"postgres": postgresExchange,
+	"custom":   customExchange,
+}
+
+// defaultPorts maps each protocol to the port it uses STARTTLS on.
+var defaultPorts = map[string]uint{
+	"smtp":     25,
+	"ftp":      21,
+	"imap":     143,
+	"pop3":     110,
+	"nntp":     119,
+	"xmpp":     5222,
+	"ldap":     389,
+	"postgres": 5432,
+}
+
+// session is the state of a STARTTLS exchange with one target.
+type session struct {
+	conn    net.Conn
+	result  *Results
+	scanner *Scanner
+	target  *zgrab2.ScanTarget
+}
+
+// startTLS performs the exchange of the configured protocol.
+func (s *session) startTLS() error {
+	return exchanges[s.scanner.config.Protocol](s)
+}
+
+// read reads from the connection until what was read matches expr.
+func (s *session) read(expr *regexp.Regexp) (string, error) {
+	buf := make([]byte, readBufferSize)
+	n, err := zgrab2.ReadUntilRegex(s.conn, buf, expr)
+	return string(buf[:n]), err
+}
+
+// command sends cmd followed by a CRLF, and reads the response until it
+// matches expr.
+func (s *session) command(cmd string, expr *regexp.Regexp) (string, error) {
+	if _, err := s.conn.Write([]byte(cmd + "\r\n")); err != nil {
+		return "", err
+	}
+	return s.read(expr)
+}
+
+// sendStartTLS sends the STARTTLS command cmd, recording the response, and
+// returns a rejection error if it does not match accepted.
+func (s *session) sendStartTLS(cmd string, expr *regexp.Regexp, accepted *regexp.Regexp) error {
+	ret, err := s.command(cmd, expr)
+	s.result.StartTLS = ret
+	if err != nil {
+		return err
+	}
+	if !accepted.MatchString(ret) {
+		return refused(ret)
+	}
+	return nil
+}
+
+// refused returns the error for a server that refused to start TLS with the
+// given response.
+func refused(response string) error {
+	return zgrab2.NewRejectionError(zgrab2.REJECTED_ENCRYPTION_UNSUPPORTED, fmt.Errorf("server refused to start TLS: %s", strings.TrimSpace(response)))
+}
+
+func smtpExchange(s *session) error {
+	var err error
+	if s.result.Banner, err = s.read(replyEnd); err != nil {
+		return err
+	}
+	if s.result.Capabilities, err = s.command("EHLO "+s.scanner.config.EHLODomain, replyEnd); err != nil {
+		return err
+	}
+	return s.sendStartTLS("STARTTLS", replyEnd, regexp.MustCompile(`^220`))
+}
+
+func ftpExchange(s *session) error {
+	var err error
+	if s.result.Banner, err = s.read(replyEnd); err != nil {
+		return err
+	}
+	if s.result.Capabilities, err = s.command("FEAT", replyEnd); err != nil {
+		return err
+	}
+	return s.sendStartTLS("AUTH TLS", replyEnd, regexp.MustCompile(`^234`))
+}
+
+func imapExchange(s *session) error {
+	var err error
+	if s.result.Banner, err = s.read(lineEnd); err != nil {
+		return err
+	}
+	if s.result.Capabilities, err = s.command("a001 CAPABILITY", regexp.MustCompile(`(?m)^a001 .*\r\n\z`)); err != nil {
+		return err
+	}
+	return s.sendStartTLS("a002 STARTTLS", regexp.MustCompile(`(?m)^a002 .*\r\n\z`), regexp.MustCompile(`(?m)^a002 OK`))
+}
+
+func pop3Exchange(s *session) error {
+	var err error
+	if s.result.Banner, err = s.read(lineEnd); err != nil {
+		return err
+	}
+	if s.result.Capabilities, err = s.command("CAPA", pop3ListEnd); err != nil {
+		return err
+	}
+	return s.sendStartTLS("STLS", lineEnd, regexp.MustCompile(`^\+OK`))
+}
+
+func nntpExchange(s *session) error {
+	var err error
+	if s.result.Banner, err = s.read(lineEnd); err != nil {
+		return err
+	}
+	if s.result.Capabilities, err = s.command("CAPABILITIES", nntpListEnd); err != nil {
+		return err
+	}
+	return s.sendStartTLS("STARTTLS", lineEnd, regexp.MustCompile(`^382`))
+}
+
+func xmppExchange(s *session) error {
+	domain := s.scanner.config.XMPPDomain
+	if domain == "" {
+		domain = s.target.Domain
+	}
+	if domain == "" {
+		domain = s.target.IP.String()
+	}
+	header := fmt.Sprintf("<?xml version='1.0'?><stream:stream to='%s' xmlns='jabber:client' xmlns:stream='http://etherx.jabber.org/streams' version='1.0'>", domain)
+	if _, err := s.conn.Write([]byte(header)); err != nil {
+		return err
+	}
+	ret, err := s.read(xmppFeaturesEnd)
+	if i := strings.Index(ret, "<stream:features"); i >= 0 {
+		s.result.Banner, s.result.Capabilities = ret[:i], ret[i:]
+	} else {
+		s.result.Banner = ret
+	}
+	if err != nil {
+		return err
+	}
+	if s.result.Capabilities == "" {
+		return refused(ret)
+	}
+	if _, err := s.conn.Write([]byte("<starttls xmlns='urn:ietf:params:xml:ns:xmpp-tls'/>")); err != nil {
+		return err
+	}
+	if s.result.StartTLS, err = s.read(xmppStartTLSEnd); err != nil {
+		return err
+	}
+	if !strings.Contains(s.result.StartTLS, "<proceed") {
+		return refused(s.result.StartTLS)
+	}
+	return nil
+}
+
+// ldapStartTLSRequest is an LDAPMessage with message ID 1 containing an
+// ExtendedRequest for the StartTLS operation (1.3.6.1.4.1.1466.20037).
+var ldapStartTLSRequest = append([]byte{0x30, 0x1d, 0x02, 0x01, 0x01, 0x77, 0x18, 0x80, 0x16}, "1.3.6.1.4.1.1466.20037"...)
+
+// ldapResultCodes names the LDAP result codes servers refusing StartTLS
+// usually send.
+var ldapResultCodes = map[int]string{
+	0:  "success",
+	1:  "operationsError",
+	2:  "protocolError",
+	52: "unavailable",
+	53: "unwillingToPerform",
+}
+
+func ldapExchange(s *session) error {
+	if _, err := s.conn.Write(ldapStartTLSRequest); err != nil {
+		return err
+	}
+	message, err := readBERElement(s.conn)
+	if err != nil {
+		return err
+	}
+	code, diagnostic, err := parseLDAPExtendedResponse(message)
+	if err != nil {
+		return err
+	}
+	name, ok := ldapResultCodes[code]
+	if !ok {
+		name = fmt.Sprintf("resultCode %d", code)
+	}
+	s.result.StartTLS = name
+	if diagnostic != "" {
+		s.result.StartTLS += ": " + diagnostic
+	}
+	if code != 0 {
+		return refused(s.result.StartTLS)
+	}
+	return nil
+}
+
+// readBERElement reads one BER-encoded element with a definite length.
+func readBERElement(r io.Reader) ([]byte, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	length := int(header[1])
+	if length&0x80 != 0 {
+		n := length & 0x7f
+		if n == 0 || n > 3 {
+			return nil, fmt.Errorf("unsupported BER length encoding 0x%02x", header[1])
+		}
+		lengthBytes := make([]byte, n)
+		if _, err := io.ReadFull(r, lengthBytes); err != nil {
+			return nil, err
+		}
+		header = append(header, lengthBytes...)
+		length = 0
+		for _, b := range lengthBytes {
+			length = length<<8 | int(b)
+		}
+	}
+	ret := make([]byte, len(header)+length)
+	copy(ret, header)
+	if _, err := io.ReadFull(r, ret[len(header):]); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// parseLDAPExtendedResponse returns the result code and diagnostic message
+// of an LDAPMessage containing an ExtendedResponse.
+func parseLDAPExtendedResponse(message []byte) (int, string, error) {
+	var envelope, messageID, op asn1.RawValue
+	if _, err := asn1.Unmarshal(message, &envelope); err != nil {
+		return 0, "", err
+	}
+	rest, err := asn1.Unmarshal(envelope.Bytes, &messageID)
+	if err != nil {
+		return 0, "", err
+	}
+	if _, err := asn1.Unmarshal(rest, &op); err != nil {
+		return 0, "", err
+	}
+	if op.Class != asn1.ClassApplication || op.Tag != 24 {
+		return 0, "", fmt.Errorf("unexpected LDAP operation %d", op.Tag)
+	}
+	var code, matchedDN, diagnostic asn1.RawValue
+	if rest, err = asn1.Unmarshal(op.Bytes, &code); err != nil {
+		return 0, "", err
+	}
+	if code.Tag != asn1.TagEnum || len(code.Bytes) == 0 || len(code.Bytes) > 4 {
+		return 0, "", fmt.Errorf("invalid LDAP result code")
+	}
+	value := 0
+	for _, b := range code.Bytes {
+		value = value<<8 | int(b)
+	}
+	if rest, err = asn1.Unmarshal(rest, &matchedDN); err == nil {
+		if _, err = asn1.Unmarshal(rest, &diagnostic); err == nil {
+			return value, string(diagnostic.Bytes), nil
+		}
+	}
+	return value, "", nil
+}
+
+// postgresSSLRequest is the SSLRequest message: its length, then the
+// request code 80877103.
+var postgresSSLRequest = []byte{0x00, 0x00, 0x00, 0x08, 0x04, 0xd2, 0x16, 0x2f}
+
+func postgresExchange(s *session) error {
+	if _, err := s.conn.Write(postgresSSLRequest); err != nil {
+		return err
+	}
+	ret := make([]byte, 1)
+	if _, err := io.ReadFull(s.conn, ret); err != nil {
+		return err
+	}
+	s.result.StartTLS = string(ret)
+	if ret[0] != 'S' {
+		return refused(s.result.StartTLS)
+	}
+	return nil
+}
+
+func customExchange(s *session) error {
+	var err error
+	if s.scanner.config.ReadBanner {
+		if s.result.Banner, err = s.read(lineEnd); err != nil {
+			return err
+		}
+	}
+	if _, err := s.conn.Write(s.scanner.command); err != nil {
+		return err
+	}
+	if s.result.StartTLS, err = s.read(lineEnd); err != nil {
+		return err
+	}
+	if !s.scanner.expect.MatchString(s.result.StartTLS) {
+		return refused(s.result.StartTLS)
+	}
+	return nil
+}
@@ -0,0 +1,201 @@
+// Package starttls provides a zgrab2 module that upgrades a plaintext
+// connection to TLS the way a given protocol does, then performs the TLS
+// handshake, so that the TLS configuration of STARTTLS-capable services can
+// be measured without a full module for each of them.
+// Default Port: the protocol's standard port (e.g. 25 for smtp).
+//
+// --protocol selects the exchange:
+//   - smtp: read the banner, send EHLO and STARTTLS (expecting 220).
+//   - ftp: read the banner, send FEAT and AUTH TLS (expecting 234).
+//   - imap: read the banner, send CAPABILITY and STARTTLS (expecting OK).
+//   - pop3: read the banner, send CAPA and STLS (expecting +OK).
+//   - nntp: read the banner, send CAPABILITIES and STARTTLS (expecting 382).
+//   - xmpp: open a client stream, read its features and send <starttls/>
+//     (expecting <proceed/>).
+//   - ldap: send the StartTLS extended operation (expecting success).
+//   - postgres: send an SSLRequest (expecting S).
+//   - custom: optionally read a banner line (--read-banner), send --command
+//     and read a line, which must match the regular expression --expect.
+//
+// The output is the banner, the response to the capabilities command, the
+// response to the STARTTLS command and, if the server accepted it, the
+// standard TLS log. Servers refusing to start TLS give an
+// application-error status with the encryption-unsupported reason.
+package starttls
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/zmap/zgrab2"
+)
+
+// Flags holds the command-line configuration for the starttls module.
+type Flags struct {
+	zgrab2.BaseFlags
+	zgrab2.TLSFlags
+
+	Protocol   string `long:"protocol" default:"smtp" choice:"smtp" choice:"ftp" choice:"imap" choice:"pop3" choice:"nntp" choice:"xmpp" choice:"ldap" choice:"postgres" choice:"custom" description:"Protocol whose STARTTLS exchange is performed"`
+	EHLODomain string `long:"ehlo-domain" default:"localhost" description:"With --protocol=smtp, the domain sent in the EHLO command"`
+	XMPPDomain string `long:"xmpp-domain" description:"With --protocol=xmpp, the domain sent in the stream header (default: the target's domain, or its IP address)"`
+	Command    string `long:"command" description:"With --protocol=custom, the data sent to request TLS, with Go string escapes"`
+	Expect     string `long:"expect" default:"." description:"With --protocol=custom, a regular expression the response to --command must match"`
+	ReadBanner bool   `long:"read-banner" description:"With --protocol=custom, read a line from the server before sending --command"`
+	Verbose    bool   `long:"verbose" description:"More verbose logging, include debug fields in the scan results"`
+}
+
+// Module implements the zgrab2.Module interface.
+type Module struct {
+}
+
+// Scanner implements the zgrab2.Scanner interface.
+type Scanner struct {
+	config  *Flags
+	command []byte
+	expect  *regexp.Regexp
+}
+
+// Results is the output of the starttls module.
+type Results struct {
+	// Protocol is the protocol whose exchange was performed.
+	Protocol string `json:"protocol"`
+
+	// Banner is what the server sent on connecting, or in response to the
+	// stream header for XMPP.
+	Banner string `json:"banner,omitempty"`
+
+	// Capabilities is the server's response to the command listing its
+	// capabilities (e.g. EHLO or CAPA), or the stream features for XMPP.
+	Capabilities string `json:"capabilities,omitempty"`
+
+	// StartTLS is the server's response to the STARTTLS command. For LDAP,
+	// it is the result code name and diagnostic message of the extended
+	// response.
+	StartTLS string `json:"starttls,omitempty"`
+
+	// TLSLog is the standard TLS log, if the server agreed to start TLS.
+	TLSLog *zgrab2.TLSLog `json:"tls,omitempty"`
+}
+
+// RegisterModule registers the zgrab2 module.
+func RegisterModule() {
+	var module Module
+	_, err := zgrab2.AddCommand("starttls", "STARTTLS", "Upgrade a connection to TLS using a protocol's STARTTLS command", 0, &module)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// NewFlags returns a default Flags object.
+func (module *Module) NewFlags() interface{} {
+	return new(Flags)
+}
+
+// NewScanner returns a new Scanner instance.
+func (module *Module) NewScanner() zgrab2.Scanner {
+	return new(Scanner)
+}
+
+// Validate checks that the flags are valid, and sets the port to the
+// protocol's standard port if none was given.
+// On success, returns nil.
+// On failure, returns an error instance describing the error.
+func (flags *Flags) Validate(args []string) error {
+	if _, ok := exchanges[flags.Protocol]; !ok {
+		return fmt.Errorf("unknown protocol %s", flags.Protocol)
+	}
+	if flags.Protocol == "custom" {
+		if flags.Command == "" {
+			return fmt.Errorf("--command is required with --protocol=custom")
+		}
+		if _, err := strconv.Unquote(`"` + flags.Command + `"`); err != nil {
+			return fmt.Errorf("invalid command %q: %v", flags.Command, err)
+		}
+		if _, err := regexp.Compile(flags.Expect); err != nil {
+			return fmt.Errorf("invalid expect pattern %q: %v", flags.Expect, err)
+		}
+	}
+	if flags.Port == 0 {
+		if flags.Protocol == "custom" {
+			return fmt.Errorf("--port is required with --protocol=custom")
+		}
+		flags.Port = defaultPorts[flags.Protocol]
+	}
+	return nil
+}
+
+// Help returns the module's help string.
+func (flags *Flags) Help() string {
+	return ""
+}
+
+// Init initializes the Scanner.
+func (scanner *Scanner) Init(flags zgrab2.ScanFlags) error {
+	f, _ := flags.(*Flags)
+	scanner.config = f
+	if f.Verbose {
+		log.SetLevel(log.DebugLevel)
+	}
+	if f.Protocol == "custom" {
+		command, err := strconv.Unquote(`"` + f.Command + `"`)
+		if err != nil {
+			return err
+		}
+		scanner.command = []byte(command)
+		if scanner.expect, err = regexp.Compile(f.Expect); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// InitPerSender initializes the scanner for a given sender.
+func (scanner *Scanner) InitPerSender(senderID int) error {
+	return nil
+}
+
+// GetName returns the Scanner name defined in the Flags.
+func (scanner *Scanner) GetName() string {
+	return scanner.config.Name
+}
+
+// GetTrigger returns the Trigger defined in the Flags.
+func (scanner *Scanner) GetTrigger() string {
+	return scanner.config.Trigger
+}
+
+// Protocol returns the protocol identifier of the scan.
+func (scanner *Scanner) Protocol() string {
+	return "starttls"
+}
+
+// GetPort returns the port being scanned.
+func (scanner *Scanner) GetPort() uint {
+	return scanner.config.Port
+}
+
+// Scan connects to the target, performs the protocol's STARTTLS exchange,
+// then the TLS handshake.
+func (scanner *Scanner) Scan(target zgrab2.ScanTarget) (zgrab2.ScanStatus, interface{}, error) {
+	conn, err := target.Open(&scanner.config.BaseFlags)
+	if err != nil {
+		return zgrab2.TryGetScanStatus(err), nil, err
+	}
+	defer conn.Close()
+	result := &Results{Protocol: scanner.config.Protocol}
+	s := &session{conn: conn, result: result, scanner: scanner, target: &target}
+	if err := s.startTLS(); err != nil {
+		return zgrab2.TryGetScanStatus(err), result, err
+	}
+	tlsConn, err := scanner.config.TLSFlags.GetTLSConnectionForTarget(conn, &target)
+	if err != nil {
+		return zgrab2.TryGetScanStatus(err), result, err
+	}
+	result.TLSLog = tlsConn.GetLog()
+	if err := tlsConn.Handshake(); err != nil {
+		return zgrab2.TryGetScanStatus(err), result, err
+	}
+	return zgrab2.SCAN_SUCCESS, result, nil
+}
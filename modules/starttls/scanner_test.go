@@ -0,0 +1,189 @@
+package starttls
+
+import (
+	"io"
+	"net"
+	"testing"
+
+	"github.com/zmap/zgrab2"
+)
+
+// step is one exchange of a scripted server: it reads expect (if not
+// empty), then sends reply.
+type step struct {
+	expect string
+	reply  string
+}
+
+// runExchange performs the STARTTLS exchange of the given flags against a
+// server following the script, returning the results and error.
+func runExchange(t *testing.T, flags *Flags, script []step) (*Results, error) {
+	if err := flags.Validate(nil); err != nil {
+		t.Fatalf("invalid flags: %v", err)
+	}
+	scanner := new(Scanner)
+	if err := scanner.Init(flags); err != nil {
+		t.Fatalf("could not initialize scanner: %v", err)
+	}
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		defer server.Close()
+		for _, step := range script {
+			if step.expect != "" {
+				buf := make([]byte, len(step.expect))
+				if _, err := io.ReadFull(server, buf); err != nil || string(buf) != step.expect {
+					t.Errorf("server got %q, expected %q", buf, step.expect)
+					return
+				}
+			}
+			server.Write([]byte(step.reply))
+		}
+	}()
+	result := &Results{Protocol: flags.Protocol}
+	target := &zgrab2.ScanTarget{IP: net.ParseIP("192.0.2.1"), Domain: "example.com"}
+	s := &session{conn: client, result: result, scanner: scanner, target: target}
+	return result, s.startTLS()
+}
+
+func TestExchanges(t *testing.T) {
+	tests := []struct {
+		flags    Flags
+		script   []step
+		expected Results
+	}{
+		{
+			flags: Flags{Protocol: "smtp", EHLODomain: "scanner.example.net"},
+			script: []step{
+				{"", "220 mail.example.com ESMTP\r\n"},
+				{"EHLO scanner.example.net\r\n", "250-mail.example.com\r\n250 STARTTLS\r\n"},
+				{"STARTTLS\r\n", "220 Ready to start TLS\r\n"},
+			},
+			expected: Results{
+				Protocol:     "smtp",
+				Banner:       "220 mail.example.com ESMTP\r\n",
+				Capabilities: "250-mail.example.com\r\n250 STARTTLS\r\n",
+				StartTLS:     "220 Ready to start TLS\r\n",
+			},
+		},
+		{
+			flags: Flags{Protocol: "imap"},
+			script: []step{
+				{"", "* OK IMAP4rev1 ready\r\n"},
+				{"a001 CAPABILITY\r\n", "* CAPABILITY IMAP4rev1 STARTTLS\r\na001 OK done\r\n"},
+				{"a002 STARTTLS\r\n", "a002 OK Begin TLS negotiation now\r\n"},
+			},
+			expected: Results{
+				Protocol:     "imap",
+				Banner:       "* OK IMAP4rev1 ready\r\n",
+				Capabilities: "* CAPABILITY IMAP4rev1 STARTTLS\r\na001 OK done\r\n",
+				StartTLS:     "a002 OK Begin TLS negotiation now\r\n",
+			},
+		},
+		{
+			flags: Flags{Protocol: "pop3"},
+			script: []step{
+				{"", "+OK POP3 ready\r\n"},
+				{"CAPA\r\n", "+OK\r\nSTLS\r\n.\r\n"},
+				{"STLS\r\n", "+OK Begin TLS\r\n"},
+			},
+			expected: Results{
+				Protocol:     "pop3",
+				Banner:       "+OK POP3 ready\r\n",
+				Capabilities: "+OK\r\nSTLS\r\n.\r\n",
+				StartTLS:     "+OK Begin TLS\r\n",
+			},
+		},
+		{
+			flags: Flags{Protocol: "xmpp"},
+			script: []step{
+				{
+					"<?xml version='1.0'?><stream:stream to='example.com' xmlns='jabber:client' xmlns:stream='http://etherx.jabber.org/streams' version='1.0'>",
+					"<stream:stream id='1'><stream:features><starttls xmlns='urn:ietf:params:xml:ns:xmpp-tls'/></stream:features>",
+				},
+				{"<starttls xmlns='urn:ietf:params:xml:ns:xmpp-tls'/>", "<proceed xmlns='urn:ietf:params:xml:ns:xmpp-tls'/>"},
+			},
+			expected: Results{
+				Protocol:     "xmpp",
+				Banner:       "<stream:stream id='1'>",
+				Capabilities: "<stream:features><starttls xmlns='urn:ietf:params:xml:ns:xmpp-tls'/></stream:features>",
+				StartTLS:     "<proceed xmlns='urn:ietf:params:xml:ns:xmpp-tls'/>",
+			},
+		},
+		{
+			flags: Flags{Protocol: "ldap"},
+			script: []step{
+				// An ExtendedResponse with resultCode success.
+				{string(ldapStartTLSRequest), "\x30\x0c\x02\x01\x01\x78\x07\x0a\x01\x00\x04\x00\x04\x00"},
+			},
+			expected: Results{Protocol: "ldap", StartTLS: "success"},
+		},
+		{
+			flags:    Flags{Protocol: "postgres"},
+			script:   []step{{string(postgresSSLRequest), "S"}},
+			expected: Results{Protocol: "postgres", StartTLS: "S"},
+		},
+		{
+			flags: Flags{BaseFlags: zgrab2.BaseFlags{Port: 4000}, Protocol: "custom", Command: `TLS\n`, Expect: "^GO", ReadBanner: true},
+			script: []step{
+				{"", "HELLO\n"},
+				{"TLS\n", "GO AHEAD\n"},
+			},
+			expected: Results{Protocol: "custom", Banner: "HELLO\n", StartTLS: "GO AHEAD\n"},
+		},
+	}
+	for _, test := range tests {
+		result, err := runExchange(t, &test.flags, test.script)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", test.flags.Protocol, err)
+		}
+		if *result != test.expected {
+			t.Errorf("%s: got %+v, expected %+v", test.flags.Protocol, result, test.expected)
+		}
+	}
+}
+
+func TestExchangeRefused(t *testing.T) {
+	tests := []struct {
+		flags    Flags
+		script   []step
+		response string
+	}{
+		{
+			flags: Flags{Protocol: "ftp"},
+			script: []step{
+				{"", "220 FTP ready\r\n"},
+				{"FEAT\r\n", "211-Features:\r\n UTF8\r\n211 End\r\n"},
+				{"AUTH TLS\r\n", "502 Command not implemented\r\n"},
+			},
+			response: "502 Command not implemented\r\n",
+		},
+		{
+			flags: Flags{Protocol: "ldap"},
+			script: []step{
+				{string(ldapStartTLSRequest), "\x30\x1a\x02\x01\x01\x78\x15\x0a\x01\x02\x04\x00\x04\x0eunsupported op"},
+			},
+			response: "protocolError: unsupported op",
+		},
+	}
+	for _, test := range tests {
+		result, err := runExchange(t, &test.flags, test.script)
+		if reason := zgrab2.GetRejectionReason(err); reason != zgrab2.REJECTED_ENCRYPTION_UNSUPPORTED {
+			t.Errorf("%s: expected a rejection, got %v", test.flags.Protocol, err)
+		}
+		if result.StartTLS != test.response {
+			t.Errorf("%s: got response %q, expected %q", test.flags.Protocol, result.StartTLS, test.response)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	flags := &Flags{Protocol: "imap"}
+	if err := flags.Validate(nil); err != nil || flags.Port != 143 {
+		t.Errorf("got port %d (%v), expected 143", flags.Port, err)
+	}
+	flags = &Flags{Protocol: "custom", Command: "STARTTLS"}
+	if err := flags.Validate(nil); err == nil {
+		t.Error("custom protocol without a port was accepted")
+	}
+}
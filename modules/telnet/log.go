@@ -30,6 +30,87 @@ type TelnetLog struct {
 
 	// Dont is the list of options that the server requests the client *not* use.
 	Dont []TelnetOption `json:"dont,omitempty"`
+
+	// TerminalType is the terminal type sent by the server in a TERMINAL-TYPE subnegotiation.
+	TerminalType string `json:"terminal_type,omitempty"`
+
+	// Environment is the list of variables sent by the server in NEW-ENVIRON subnegotiations.
+	Environment []EnvironmentVariable `json:"environment,omitempty"`
+}
+
+// EnvironmentVariable is a variable sent by the server with NEW-ENVIRON.
+type EnvironmentVariable struct {
+	// Type is "var" for well-known variables (e.g. USER, SYSTEMTYPE) and "uservar" for others.
+	Type string `json:"type"`
+
+	// Name is the name of the variable.
+	Name string `json:"name"`
+
+	// Value is the value of the variable, if the server gave one.
+	Value *string `json:"value,omitempty"`
+}
+
+// recordSubnegotiation records the values in a subnegotiation sent by the server (the bytes between IAC SB and
+// IAC SE, unescaped). Requests for the client's values (SEND) and unknown options are ignored.
+func (log *TelnetLog) recordSubnegotiation(sub []byte) {
+	if len(sub) < 2 {
+		return
+	}
+	option, command, data := sub[0], sub[1], sub[2:]
+	switch {
+	case option == TERMINAL_TYPE && command == SUB_IS:
+		log.TerminalType = string(data)
+	case option == NEW_ENVIRON && (command == SUB_IS || command == SUB_INFO):
+		log.Environment = append(log.Environment, parseEnvironment(data)...)
+	}
+}
+
+// parseEnvironment parses the VAR / USERVAR list of a NEW-ENVIRON IS or INFO subnegotiation.
+func parseEnvironment(data []byte) []EnvironmentVariable {
+	var ret []EnvironmentVariable
+	var current *EnvironmentVariable
+	var value []byte
+	inValue := false
+	finish := func() {
+		if current == nil {
+			return
+		}
+		if inValue {
+			v := string(value)
+			current.Value = &v
+		}
+		ret = append(ret, *current)
+	}
+	for i := 0; i < len(data); i++ {
+		switch b := data[i]; b {
+		case ENV_VAR, ENV_USERVAR:
+			finish()
+			current = &EnvironmentVariable{Type: "var"}
+			if b == ENV_USERVAR {
+				current.Type = "uservar"
+			}
+			value, inValue = nil, false
+		case ENV_VALUE:
+			if current != nil {
+				value, inValue = []byte{}, true
+			}
+		default:
+			if b == ENV_ESC && i+1 < len(data) {
+				i++
+				b = data[i]
+			}
+			if current == nil {
+				continue
+			}
+			if inValue {
+				value = append(value, b)
+			} else {
+				current.Name += string(b)
+			}
+		}
+	}
+	finish()
+	return ret
 }
 
 // isTelnet checks if this struct represents having actually detected a Telnet service.
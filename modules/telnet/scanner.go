@@ -7,8 +7,13 @@
 // The scan negotiates the options and attempts to grab the banner, using the
 // same behavior as the original zgrab.
 //
+// With --request-environment, the TERMINAL-TYPE and NEW-ENVIRON options are
+// accepted when the server offers them, and their values requested; some
+// devices reveal their hostname or model this way.
+//
 // The output contains the banner and the negotiated options, in the same
-// format as the original zgrab.
+// format as the original zgrab, and any terminal type and environment
+// variables the server sent.
 package telnet
 
 import (
@@ -20,8 +25,9 @@ import (
 // Populated by the framework.
 type Flags struct {
 	zgrab2.BaseFlags
-	MaxReadSize int  `long:"max-read-size" description:"Set the maximum number of bytes to read when grabbing the banner" default:"65536"`
-	Verbose     bool `long:"verbose" description:"More verbose logging, include debug fields in the scan results"`
+	MaxReadSize        int  `long:"max-read-size" description:"Set the maximum number of bytes to read when grabbing the banner" default:"65536"`
+	RequestEnvironment bool `long:"request-environment" description:"If the server offers TERMINAL-TYPE or NEW-ENVIRON, accept and ask for its terminal type and environment variables"`
+	Verbose            bool `long:"verbose" description:"More verbose logging, include debug fields in the scan results"`
 }
 
 // Module implements the zgrab2.Module interface.
@@ -104,7 +110,7 @@ func (scanner *Scanner) Scan(target zgrab2.ScanTarget) (zgrab2.ScanStatus, inter
 	}
 	defer conn.Close()
 	result := new(TelnetLog)
-	if err := GetTelnetBanner(result, conn, scanner.config.MaxReadSize); err != nil {
+	if err := GetTelnetBanner(result, conn, scanner.config.MaxReadSize, scanner.config.RequestEnvironment); err != nil {
 		return zgrab2.TryGetScanStatus(err), result.getResult(), err
 	}
 	return zgrab2.SCAN_SUCCESS, result, nil
@@ -43,9 +43,15 @@ const (
 	// WILL means these options will be used.
 	WILL = byte(0xfb)
 
+	// SB marks the start of a subnegotiation.
+	SB = byte(0xfa)
+
 	// GO_AHEAD is the special go ahead command.
 	GO_AHEAD = byte(0xf9)
 
+	// SE marks the end of a subnegotiation.
+	SE = byte(0xf0)
+
 	// IAC_CMD_LENGTH gives the length of the special IAC command (inclusive).
 	IAC_CMD_LENGTH = 3
 
@@ -53,6 +59,33 @@ const (
 	READ_BUFFER_LENGTH = 8209
 )
 
+// Options whose values can be solicited from the server, and their
+// subnegotiation commands.
+// RFC 1091 - https://tools.ietf.org/html/rfc1091
+// RFC 1572 - https://tools.ietf.org/html/rfc1572
+const (
+	// TERMINAL_TYPE is the TERMINAL-TYPE option.
+	TERMINAL_TYPE = byte(24)
+
+	// NEW_ENVIRON is the NEW-ENVIRON option.
+	NEW_ENVIRON = byte(39)
+
+	// SUB_IS introduces the sender's values in a subnegotiation.
+	SUB_IS = byte(0)
+
+	// SUB_SEND asks the peer to send its values in a subnegotiation.
+	SUB_SEND = byte(1)
+
+	// SUB_INFO introduces updated values in a NEW-ENVIRON subnegotiation.
+	SUB_INFO = byte(2)
+
+	// ENV_VAR, ENV_VALUE, ENV_ESC and ENV_USERVAR delimit NEW-ENVIRON variables.
+	ENV_VAR     = byte(0)
+	ENV_VALUE   = byte(1)
+	ENV_ESC     = byte(2)
+	ENV_USERVAR = byte(3)
+)
+
 // TelnetOption provides mappings of telnet option enum values to/from their friendly names.
 type TelnetOption uint16
 
@@ -93,9 +126,10 @@ func (opt *TelnetOption) UnmarshalJSON(b []byte) error {
 }
 
 // GetTelnetBanner attempts to negotiate the options and fetch the telnet banner over the given connection, reading at
-// most maxReadSize bytes.
-func GetTelnetBanner(logStruct *TelnetLog, conn net.Conn, maxReadSize int) (err error) {
-	if err = NegotiateOptions(logStruct, conn); err != nil {
+// most maxReadSize bytes. If requestEnvironment is true, the server's terminal type and environment are solicited when
+// it offers them.
+func GetTelnetBanner(logStruct *TelnetLog, conn net.Conn, maxReadSize int, requestEnvironment bool) (err error) {
+	if err = NegotiateOptions(logStruct, conn, requestEnvironment); err != nil {
 		return err
 	}
 	// Keep reading until READ_BUFFER_LENGTH chunks until
@@ -118,8 +152,10 @@ func GetTelnetBanner(logStruct *TelnetLog, conn net.Conn, maxReadSize int) (err
 	return nil
 }
 
-// NegotiateOptions attempts to negotiate the connection options over the given connection.
-func NegotiateOptions(logStruct *TelnetLog, conn net.Conn) error {
+// NegotiateOptions attempts to negotiate the connection options over the given connection. All offered options are
+// refused, except that if requestEnvironment is true, TERMINAL-TYPE and NEW-ENVIRON offered by the server (WILL) are
+// accepted and their values requested.
+func NegotiateOptions(logStruct *TelnetLog, conn net.Conn, requestEnvironment bool) error {
 	var readBuffer, retBuffer []byte
 	var option, optionType, returnOptionType byte
 	var iacIndex, firstUnreadIndex, numBytes, numDataBytes int
@@ -154,6 +190,28 @@ func NegotiateOptions(logStruct *TelnetLog, conn net.Conn) error {
 				break
 			}
 
+			// record subnegotiations, reading the rest if it was split
+			if optionType == SB {
+				end := getSEIndex(readBuffer[iacIndex:numDataBytes])
+				for end == -1 {
+					if numDataBytes == len(readBuffer) {
+						return errors.New("Not enough buffer space for telnet subnegotiation")
+					}
+					n, err := conn.Read(readBuffer[numDataBytes:])
+					numBytes += n
+					numDataBytes += n
+					if err != nil {
+						return err
+					}
+					end = getSEIndex(readBuffer[iacIndex:numDataBytes])
+				}
+				logStruct.recordSubnegotiation(unescapeIAC(readBuffer[iacIndex+2 : iacIndex+end]))
+				firstUnreadIndex = iacIndex + end + 2
+				numDataBytes -= firstUnreadIndex
+				readBuffer = readBuffer[firstUnreadIndex:]
+				continue
+			}
+
 			// record all offered options
 			opt := TelnetOption(option)
 			if optionType == WILL {
@@ -166,8 +224,10 @@ func NegotiateOptions(logStruct *TelnetLog, conn net.Conn) error {
 				logStruct.Dont = append(logStruct.Dont, opt)
 			}
 
-			// reject all offered options
-			if optionType == WILL || optionType == WONT {
+			// reject all offered options, except for those whose values are solicited
+			if optionType == WILL && requestEnvironment && (option == TERMINAL_TYPE || option == NEW_ENVIRON) {
+				returnOptionType = DO
+			} else if optionType == WILL || optionType == WONT {
 				returnOptionType = DONT
 			} else if optionType == DO || optionType == DONT {
 				returnOptionType = WONT
@@ -178,6 +238,9 @@ func NegotiateOptions(logStruct *TelnetLog, conn net.Conn) error {
 			retBuffer = append(retBuffer, IAC)
 			retBuffer = append(retBuffer, returnOptionType)
 			retBuffer = append(retBuffer, option)
+			if returnOptionType == DO {
+				retBuffer = append(retBuffer, IAC, SB, option, SUB_SEND, IAC, SE)
+			}
 
 			firstUnreadIndex = iacIndex + IAC_CMD_LENGTH
 			numDataBytes -= firstUnreadIndex
@@ -200,6 +263,27 @@ func NegotiateOptions(logStruct *TelnetLog, conn net.Conn) error {
 	return nil
 }
 
+// getSEIndex returns the index of the IAC SE ending the subnegotiation that the buffer starts with, or -1 if it is
+// incomplete.
+func getSEIndex(buffer []byte) int {
+	for i := 2; i+1 < len(buffer); i++ {
+		if buffer[i] != IAC {
+			continue
+		}
+		if buffer[i+1] == SE {
+			return i
+		}
+		// skip escaped 0xFF data bytes
+		i++
+	}
+	return -1
+}
+
+// unescapeIAC replaces each IAC IAC in a subnegotiation with a single 0xFF data byte.
+func unescapeIAC(buffer []byte) []byte {
+	return bytes.Replace(buffer, []byte{IAC, IAC}, []byte{IAC}, -1)
+}
+
 func getIACIndex(buffer []byte) int {
 	// TODO: This doesn't seem to take into account that a 0xFF data byte is encoded as 0xFF + 0xFF
 	return bytes.IndexByte(buffer, IAC)
@@ -0,0 +1,66 @@
+package telnet
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+)
+
+func TestParseEnvironment(t *testing.T) {
+	data := []byte("\x00SYSTEMTYPE\x01IOS\x03HOSTNAME\x01core-\x02\x01sw1\x00USER")
+	vars := parseEnvironment(data)
+	if len(vars) != 3 {
+		t.Fatalf("expected 3 variables, got %+v", vars)
+	}
+	if vars[0].Type != "var" || vars[0].Name != "SYSTEMTYPE" || vars[0].Value == nil || *vars[0].Value != "IOS" {
+		t.Errorf("bad first variable %+v", vars[0])
+	}
+	if vars[1].Type != "uservar" || vars[1].Name != "HOSTNAME" || vars[1].Value == nil || *vars[1].Value != "core-\x01sw1" {
+		t.Errorf("bad escaped variable %+v", vars[1])
+	}
+	if vars[2].Name != "USER" || vars[2].Value != nil {
+		t.Errorf("bad undefined variable %+v", vars[2])
+	}
+}
+
+func TestNegotiateEnvironment(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	done := make(chan []byte, 1)
+	go func() {
+		defer server.Close()
+		server.Write([]byte{IAC, WILL, TERMINAL_TYPE, IAC, WILL, NEW_ENVIRON, IAC, DO, 1})
+		buf := make([]byte, 64)
+		n, _ := server.Read(buf)
+		done <- buf[:n]
+		// Split the subnegotiation across writes.
+		server.Write([]byte{IAC, SB, TERMINAL_TYPE, SUB_IS})
+		server.Write([]byte("VT100\xff\xf0\xff\xfa\x27\x00\x00SYSTEMTYPE\x01router\xff\xf0login: "))
+		// Writes on a pipe block until read, even empty ones.
+		io.Copy(ioutil.Discard, server)
+	}()
+	log := new(TelnetLog)
+	if err := NegotiateOptions(log, client, true); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	reply := <-done
+	expected := []byte{
+		IAC, DO, TERMINAL_TYPE, IAC, SB, TERMINAL_TYPE, SUB_SEND, IAC, SE,
+		IAC, DO, NEW_ENVIRON, IAC, SB, NEW_ENVIRON, SUB_SEND, IAC, SE,
+		IAC, WONT, 1,
+	}
+	if !bytes.Equal(reply, expected) {
+		t.Errorf("expected reply %x, got %x", expected, reply)
+	}
+	if log.TerminalType != "VT100" {
+		t.Errorf("expected terminal type VT100, got %q", log.TerminalType)
+	}
+	if len(log.Environment) != 1 || log.Environment[0].Name != "SYSTEMTYPE" || *log.Environment[0].Value != "router" {
+		t.Errorf("bad environment %+v", log.Environment)
+	}
+	if log.Banner != "login: " {
+		t.Errorf("expected banner after the subnegotiations, got %q", log.Banner)
+	}
+}
@@ -1,6 +1,10 @@
 package modules
 
 import (
+	"errors"
+	"net"
+	"time"
+
 	log "github.com/sirupsen/logrus"
 	"github.com/zmap/zgrab2"
 )
@@ -8,6 +12,22 @@ import (
 type TLSFlags struct {
 	zgrab2.BaseFlags
 	zgrab2.TLSFlags
+
+	ReadBanner    bool          `long:"read-banner" description:"Before the handshake, wait --banner-timeout for a cleartext banner, and record it if one is sent"`
+	BannerTimeout time.Duration `long:"banner-timeout" default:"1s" description:"How long to wait for a cleartext banner with --read-banner"`
+}
+
+// maxBannerSize is the maximum number of bytes of cleartext banner recorded.
+const maxBannerSize = 4096
+
+// TLSResult is the output of the tls module: the TLS logs, and any cleartext
+// banner the server sent before the handshake.
+type TLSResult struct {
+	*zgrab2.TLSLog
+
+	// Banner is the cleartext data sent by the server before the handshake,
+	// with --read-banner.
+	Banner string `json:"banner,omitempty"`
 }
 
 type TLSModule struct {
@@ -34,6 +54,9 @@ func (m *TLSModule) NewScanner() zgrab2.Scanner {
 }
 
 func (f *TLSFlags) Validate(args []string) error {
+	if f.ReadBanner && f.BannerTimeout <= 0 {
+		return errors.New("--banner-timeout must be positive")
+	}
 	return nil
 }
 
@@ -66,7 +89,13 @@ func (s *TLSScanner) InitPerSender(senderID int) error {
 // a TLS handshake. If the handshake gets past the ServerHello stage, the
 // handshake log is returned (along with any other TLS-related logs, such as
 // heartbleed, if enabled).
+// With --read-banner, it first waits for the server to speak; since TLS
+// servers never do, any data sent is recorded as the banner, alongside the
+// handshake's outcome.
 func (s *TLSScanner) Scan(t zgrab2.ScanTarget) (zgrab2.ScanStatus, interface{}, error) {
+	if s.config.ReadBanner {
+		return s.scanWithBanner(&t)
+	}
 	conn, err := t.OpenTLS(&s.config.BaseFlags, &s.config.TLSFlags)
 	if conn != nil {
 		defer conn.Close()
@@ -91,3 +120,57 @@ func (s *TLSScanner) Scan(t zgrab2.ScanTarget) (zgrab2.ScanStatus, interface{},
 func (s *TLSScanner) Protocol() string {
 	return "tls"
 }
+
+// ServerTime returns the legacy timestamp in the ServerHello random, if it
+// looks like one.
+func (s *TLSScanner) ServerTime(result interface{}) *zgrab2.ServerTime {
+	switch r := result.(type) {
+	case *zgrab2.TLSLog:
+		return zgrab2.TLSServerTime(r)
+	case *TLSResult:
+		return zgrab2.TLSServerTime(r.TLSLog)
+	}
+	return nil
+}
+
+// scanWithBanner waits for a cleartext banner before performing the TLS
+// handshake on the same connection.
+func (s *TLSScanner) scanWithBanner(t *zgrab2.ScanTarget) (zgrab2.ScanStatus, interface{}, error) {
+	conn, err := t.Open(&s.config.BaseFlags)
+	if err != nil {
+		return zgrab2.TryGetScanStatus(err), nil, err
+	}
+	defer conn.Close()
+	return s.bannerHandshake(conn, t)
+}
+
+// bannerHandshake records any cleartext banner the server sends on conn within
+// --banner-timeout, then performs the TLS handshake. A server that sent a
+// banner is still reported as failing the handshake, with the banner recorded.
+func (s *TLSScanner) bannerHandshake(conn net.Conn, t *zgrab2.ScanTarget) (zgrab2.ScanStatus, interface{}, error) {
+	buf := make([]byte, maxBannerSize)
+	conn.SetReadDeadline(time.Now().Add(s.config.BannerTimeout))
+	n, err := conn.Read(buf)
+	if netErr, ok := err.(net.Error); n == 0 && err != nil && (!ok || !netErr.Timeout()) {
+		return zgrab2.TryGetScanStatus(err), nil, err
+	}
+	banner := string(buf[:n])
+	// The banner deadline only applies to that read; the handshake uses the
+	// usual timeouts.
+	conn.SetReadDeadline(time.Time{})
+	tlsConn, err := s.config.TLSFlags.GetTLSConnectionForTarget(conn, t)
+	if err != nil {
+		return zgrab2.TryGetScanStatus(err), nil, err
+	}
+	if err := tlsConn.Handshake(); err != nil {
+		result := &TLSResult{Banner: banner}
+		if log := tlsConn.GetLog(); log.HandshakeLog != nil && log.HandshakeLog.ServerHello != nil {
+			result.TLSLog = log
+		}
+		if result.TLSLog == nil && banner == "" {
+			return zgrab2.TryGetScanStatus(err), nil, err
+		}
+		return zgrab2.TryGetScanStatus(err), result, err
+	}
+	return zgrab2.SCAN_SUCCESS, &TLSResult{TLSLog: tlsConn.GetLog(), Banner: banner}, nil
+}
@@ -0,0 +1,79 @@
+package modules
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/zmap/zgrab2"
+)
+
+// A server that sends a cleartext banner has it recorded, while the handshake
+// is still attempted and its failure reported.
+func TestScanWithBanner(t *testing.T) {
+	const banner = "220 mail.example.com ESMTP ready\r\n"
+	client, server := net.Pipe()
+	defer client.Close()
+	received := make(chan int, 1)
+	go func() {
+		defer server.Close()
+		if _, err := io.WriteString(server, banner); err != nil {
+			t.Errorf("could not send banner: %v", err)
+			received <- 0
+			return
+		}
+		// Read the ClientHello, then hang up on it.
+		server.SetReadDeadline(time.Now().Add(time.Second))
+		buf := make([]byte, 1024)
+		n, _ := server.Read(buf)
+		received <- n
+	}()
+
+	flags := &TLSFlags{ReadBanner: true, BannerTimeout: 5 * time.Second}
+	scanner := new(TLSScanner)
+	if err := scanner.Init(flags); err != nil {
+		t.Fatalf("could not initialize scanner: %v", err)
+	}
+	target := &zgrab2.ScanTarget{IP: net.ParseIP("192.0.2.1"), Domain: "mail.example.com"}
+	status, res, err := scanner.bannerHandshake(client, target)
+	if err == nil || status == zgrab2.SCAN_SUCCESS {
+		t.Errorf("expected the handshake to fail, got %s (%v)", status, err)
+	}
+	result, ok := res.(*TLSResult)
+	if !ok || result == nil {
+		t.Fatalf("expected a result, got %v", res)
+	}
+	if result.Banner != banner {
+		t.Errorf("expected banner %q, got %q", banner, result.Banner)
+	}
+	if result.TLSLog != nil {
+		t.Errorf("expected no TLS log without a ServerHello, got %+v", result.TLSLog)
+	}
+	if n := <-received; n == 0 {
+		t.Error("expected a ClientHello after the banner")
+	}
+}
+
+// Without a banner, a failed handshake gives no result.
+func TestScanWithoutBanner(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		// Swallow the ClientHello, then hang up on it.
+		server.SetReadDeadline(time.Now().Add(time.Second))
+		io.CopyN(ioutil.Discard, server, 1)
+		server.Close()
+	}()
+
+	flags := &TLSFlags{ReadBanner: true, BannerTimeout: 50 * time.Millisecond}
+	scanner := new(TLSScanner)
+	if err := scanner.Init(flags); err != nil {
+		t.Fatalf("could not initialize scanner: %v", err)
+	}
+	status, res, err := scanner.bannerHandshake(client, &zgrab2.ScanTarget{IP: net.ParseIP("192.0.2.1")})
+	if err == nil || status == zgrab2.SCAN_SUCCESS || res != nil {
+		t.Errorf("expected a bare failure, got %s %v (%v)", status, res, err)
+	}
+}
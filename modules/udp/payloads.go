@@ -0,0 +1,136 @@
+package udp
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Payload is a request in the built-in library, chosen with --payload.
+type Payload struct {
+	Name        string
+	Description string
+
+	// Port is the port the service usually listens on, for reference; the
+	// port scanned is still --port.
+	Port uint
+
+	Data []byte
+
+	// Retries and RetransmitInterval are the payload's retransmit policy,
+	// used unless --retries or --retransmit-interval is given. Retries is
+	// -1, and RetransmitInterval 0, for the module's defaults.
+	Retries            int
+	RetransmitInterval time.Duration
+}
+
+// The module's retransmit policy, for payloads without their own and for
+// --payload-hex and --payload-file.
+const (
+	defaultRetries            = 2
+	defaultRetransmitInterval = time.Second
+)
+
+// payloads is the built-in payload library, by name.
+var payloads = map[string]*Payload{}
+
+func addPayload(name, description string, port uint, data string) *Payload {
+	ret := &Payload{
+		Name:        name,
+		Description: description,
+		Port:        port,
+		Data:        []byte(data),
+		Retries:     -1,
+	}
+	payloads[name] = ret
+	return ret
+}
+
+// mustDecodeHex decodes a payload given in hex.
+func mustDecodeHex(s string) string {
+	ret, err := decodeHex(s)
+	if err != nil {
+		panic(err)
+	}
+	return string(ret)
+}
+
+func init() {
+	addPayload("dns", "DNS CHAOS TXT query for version.bind", 53, mustDecodeHex(
+		"7a67 0100 0001 0000 0000 0000 07 76657273696f6e 04 62696e64 00 0010 0003"))
+	addPayload("ntp", "NTPv4 client request", 123, mustDecodeHex("e3")+strings.Repeat("\x00", 47))
+	addPayload("snmp", "SNMPv2c get of sysDescr.0 with community public", 161, mustDecodeHex(
+		"3029 020101 0406 7075626c6963 a01c 0204 7a677232 020100 020100 300e 300c 0608 2b06010201010100 0500"))
+	// Every device on the network may answer an M-SEARCH, each more than
+	// once; collect the replies with --max-responses rather than resending.
+	addPayload("ssdp", "SSDP M-SEARCH for all services", 1900, "M-SEARCH * HTTP/1.1\r\n"+
+		"HOST: 239.255.255.250:1900\r\n"+
+		"MAN: \"ssdp:discover\"\r\n"+
+		"MX: 1\r\n"+
+		"ST: ssdp:all\r\n\r\n").Retries = 0
+	addPayload("netbios", "NetBIOS node status (NBSTAT) query for *", 137, mustDecodeHex(
+		"7a67 0000 0001 0000 0000 0000 20 434b"+strings.Repeat("41", 30)+" 00 0021 0001"))
+	addPayload("mdns", "Unicast mDNS PTR query for _services._dns-sd._udp.local", 5353, mustDecodeHex(
+		"0000 0000 0001 0000 0000 0000 09 5f7365727669636573 07 5f646e732d7364 04 5f756470 05 6c6f63616c 00 000c 0001"))
+	// memcached's stats reply is an amplification vector many times the
+	// size of the request, so it is sent only once.
+	addPayload("memcached", "memcached stats command", 11211, mustDecodeHex("0000 0000 0001 0000")+"stats\r\n").Retries = 0
+	addPayload("portmap", "ONC RPC portmapper v2 DUMP call", 111, mustDecodeHex(
+		"7a677232 00000000 00000002 000186a0 00000002 00000004 00000000 00000000 00000000 00000000"))
+	addPayload("sip", "SIP OPTIONS request", 5060, "OPTIONS sip:nm SIP/2.0\r\n"+
+		"Via: SIP/2.0/UDP nm;branch=z9hG4bK-zgrab2;rport\r\n"+
+		"Max-Forwards: 70\r\n"+
+		"To: <sip:nm>\r\n"+
+		"From: <sip:nm@nm>;tag=zgrab2\r\n"+
+		"Call-ID: zgrab2\r\n"+
+		"CSeq: 42 OPTIONS\r\n"+
+		"Contact: <sip:nm@nm>\r\n"+
+		"Accept: application/sdp\r\n"+
+		"Content-Length: 0\r\n\r\n")
+	addPayload("ipmi", "RMCP ASF presence ping", 623, mustDecodeHex("0600ff06 000011be 80000000"))
+	addPayload("coap", "CoAP GET of /.well-known/core", 5683, mustDecodeHex(
+		"4001 7a67 bb 2e77656c6c2d6b6e6f776e 04 636f7265"))
+	addPayload("stun", "STUN binding request", 3478, mustDecodeHex(
+		"0001 0000 2112a442 7a677232 7a677232 7a677232"))
+	addPayload("tftp", "TFTP read request for a file not expected to exist", 69, mustDecodeHex("0001")+"zgrab2\x00octet\x00")
+	addPayload("openvpn", "OpenVPN P_CONTROL_HARD_RESET_CLIENT_V2", 1194, mustDecodeHex(
+		"38 7a67723200000000 00 00000000"))
+	addPayload("xdmcp", "XDMCP Query", 177, mustDecodeHex("0001 0002 0001 00"))
+	// Game servers are often slow to answer status queries.
+	addPayload("a2s", "Source engine A2S_INFO query", 27015, mustDecodeHex("ffffffff 54")+"Source Engine Query\x00").RetransmitInterval = 2 * time.Second
+	addPayload("ubiquiti", "Ubiquiti discovery request", 10001, mustDecodeHex("01000000"))
+}
+
+// getPayload returns the named payload from the library.
+func getPayload(name string) (*Payload, error) {
+	if ret, ok := payloads[name]; ok {
+		return ret, nil
+	}
+	names := make([]string, 0, len(payloads))
+	for name := range payloads {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return nil, fmt.Errorf("unknown payload %q, expected one of %s", name, strings.Join(names, ", "))
+}
+
+// retransmitPolicy returns the number of retries and the retransmit interval
+// to use: the flags if they were given, else the payload's, else the
+// module's defaults.
+func (flags *Flags) retransmitPolicy(payload *Payload) (int, time.Duration) {
+	retries, interval := flags.Retries, flags.RetransmitInterval
+	if retries < 0 {
+		retries = defaultRetries
+		if payload != nil && payload.Retries >= 0 {
+			retries = payload.Retries
+		}
+	}
+	if interval == 0 {
+		interval = defaultRetransmitInterval
+		if payload != nil && payload.RetransmitInterval > 0 {
+			interval = payload.RetransmitInterval
+		}
+	}
+	return retries, interval
+}
@@ -0,0 +1,297 @@
+// Package udp provides a zgrab2 module that sends a user-supplied payload
+// over UDP and records the replies.
+// Default Port: 53 (UDP); the port should normally be given with --port.
+//
+// The payload is chosen by name from a built-in library with --payload,
+// given in hex with --payload-hex, or read from a file with --payload-file.
+// The library holds requests for services without a dedicated module (with
+// the port they usually listen on):
+//
+//	a2s        27015  Source engine A2S_INFO query
+//	coap       5683   CoAP GET of /.well-known/core
+//	dns        53     DNS CHAOS TXT query for version.bind
+//	ipmi       623    RMCP ASF presence ping
+//	mdns       5353   Unicast mDNS PTR query for _services._dns-sd._udp.local
+//	memcached  11211  memcached stats command
+//	netbios    137    NetBIOS node status (NBSTAT) query for *
+//	ntp        123    NTPv4 client request
+//	openvpn    1194   OpenVPN P_CONTROL_HARD_RESET_CLIENT_V2
+//	portmap    111    ONC RPC portmapper v2 DUMP call
+//	sip        5060   SIP OPTIONS request
+//	snmp       161    SNMPv2c get of sysDescr.0 with community public
+//	ssdp       1900   SSDP M-SEARCH for all services
+//	stun       3478   STUN binding request
+//	tftp       69     TFTP read request for a file not expected to exist
+//	ubiquiti   10001  Ubiquiti discovery request
+//	xdmcp      177    XDMCP Query
+//
+// The payload is retransmitted every --retransmit-interval until a reply
+// arrives, up to --retries times. Unless those flags are given, payloads from
+// the library use their own policy: memcached and ssdp are sent only once,
+// and a2s is retransmitted every 2s; others, like --payload-hex and
+// --payload-file, are retransmitted twice, every 1s. After the first reply,
+// the scanner waits up to --linger for further replies, until
+// --max-responses have been received.
+//
+// If --pattern is given, each reply is matched against the regular
+// expression, and the scan succeeds only if one of them matches.
+//
+// The output is the replies and the number of times the payload was sent,
+// and the result of the pattern match if applicable.
+package udp
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/zmap/zgrab2"
+)
+
+// maxDatagramSize is the size of the buffer used to read replies.
+const maxDatagramSize = 64 * 1024
+
+// ErrNoResponse is returned when no reply is received after all retries.
+var ErrNoResponse = errors.New("no response received")
+
+// ErrNoMatch is returned when no reply matches --pattern.
+var ErrNoMatch = errors.New("no response matched the pattern")
+
+// Flags holds the command-line configuration for the udp module.
+type Flags struct {
+	zgrab2.BaseFlags
+	zgrab2.UDPFlags
+
+	Payload            string        `long:"payload" description:"Name of the built-in payload to send, e.g. dns, ntp, snmp or ssdp"`
+	PayloadHex         string        `long:"payload-hex" description:"Payload to send, in hex"`
+	PayloadFile        string        `long:"payload-file" description:"File containing the payload to send"`
+	Retries            int           `long:"retries" default:"-1" description:"Number of times to retransmit the payload if no reply is received (default: the payload's policy, or 2)"`
+	RetransmitInterval time.Duration `long:"retransmit-interval" description:"Time to wait for a reply before retransmitting (default: the payload's policy, or 1s)"`
+	MaxResponses       int           `long:"max-responses" default:"1" description:"Maximum number of replies to record"`
+	Linger             time.Duration `long:"linger" default:"500ms" description:"After the first reply, time to wait for further replies"`
+	Pattern            string        `long:"pattern" description:"Regular expression that a reply must match for the scan to succeed"`
+	Verbose            bool          `long:"verbose" description:"More verbose logging, include debug fields in the scan results"`
+}
+
+// Module implements the zgrab2.Module interface.
+type Module struct {
+}
+
+// Scanner implements the zgrab2.Scanner interface.
+type Scanner struct {
+	config  *Flags
+	payload []byte
+	pattern *regexp.Regexp
+
+	// retries and retransmitInterval are the retransmit policy in effect.
+	retries            int
+	retransmitInterval time.Duration
+}
+
+// Results is the output of the udp module.
+type Results struct {
+	// Payload is the name of the built-in payload sent, if --payload was
+	// given.
+	Payload string `json:"payload,omitempty"`
+
+	// Attempts is the number of times the payload was sent.
+	Attempts int `json:"attempts"`
+
+	// Responses are the replies received, in order.
+	Responses [][]byte `json:"responses,omitempty"`
+
+	// Matched is true if --pattern was given and matched one of the replies.
+	Matched bool `json:"matched,omitempty"`
+
+	// Submatches are the pattern's capture groups in the first matching
+	// reply (the first entry being the whole match).
+	Submatches []string `json:"submatches,omitempty"`
+}
+
+// RegisterModule registers the zgrab2 module.
+func RegisterModule() {
+	var module Module
+	_, err := zgrab2.AddCommand("udp", "UDP payload", "Send a UDP payload and record the replies", 53, &module)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// NewFlags returns a default Flags object.
+func (module *Module) NewFlags() interface{} {
+	return new(Flags)
+}
+
+// NewScanner returns a new Scanner instance.
+func (module *Module) NewScanner() zgrab2.Scanner {
+	return new(Scanner)
+}
+
+// Validate checks that the flags are valid.
+// On success, returns nil.
+// On failure, returns an error instance describing the error.
+func (flags *Flags) Validate(args []string) error {
+	given := 0
+	for _, payload := range []string{flags.Payload, flags.PayloadHex, flags.PayloadFile} {
+		if payload != "" {
+			given++
+		}
+	}
+	if given != 1 {
+		return fmt.Errorf("exactly one of --payload, --payload-hex and --payload-file must be given")
+	}
+	if flags.Payload != "" {
+		if _, err := getPayload(flags.Payload); err != nil {
+			return err
+		}
+	}
+	if flags.Retries < -1 {
+		return fmt.Errorf("retries must be non-negative, given %d", flags.Retries)
+	}
+	if flags.RetransmitInterval < 0 {
+		return fmt.Errorf("retransmit-interval must be positive, given %v", flags.RetransmitInterval)
+	}
+	if flags.MaxResponses < 1 {
+		return fmt.Errorf("max-responses must be at least 1, given %d", flags.MaxResponses)
+	}
+	return nil
+}
+
+// Help returns the module's help string.
+func (flags *Flags) Help() string {
+	return ""
+}
+
+// Init initializes the Scanner, loading the payload and compiling the pattern.
+func (scanner *Scanner) Init(flags zgrab2.ScanFlags) error {
+	f, _ := flags.(*Flags)
+	scanner.config = f
+	if f.Verbose {
+		log.SetLevel(log.DebugLevel)
+	}
+	var payload *Payload
+	var err error
+	switch {
+	case f.Payload != "":
+		if payload, err = getPayload(f.Payload); err != nil {
+			return err
+		}
+		scanner.payload = payload.Data
+	case f.PayloadFile != "":
+		scanner.payload, err = ioutil.ReadFile(f.PayloadFile)
+	default:
+		scanner.payload, err = decodeHex(f.PayloadHex)
+	}
+	if err != nil {
+		return err
+	}
+	scanner.retries, scanner.retransmitInterval = f.retransmitPolicy(payload)
+	if f.Pattern != "" {
+		if scanner.pattern, err = regexp.Compile(f.Pattern); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// decodeHex decodes a hex string, ignoring whitespace and an optional 0x
+// prefix.
+func decodeHex(s string) ([]byte, error) {
+	s = strings.Join(strings.Fields(s), "")
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "0x"), "0X")
+	return hex.DecodeString(s)
+}
+
+// InitPerSender initializes the scanner for a given sender.
+func (scanner *Scanner) InitPerSender(senderID int) error {
+	return nil
+}
+
+// GetName returns the Scanner name defined in the Flags.
+func (scanner *Scanner) GetName() string {
+	return scanner.config.Name
+}
+
+// GetTrigger returns the Trigger defined in the Flags.
+func (scanner *Scanner) GetTrigger() string {
+	return scanner.config.Trigger
+}
+
+// Protocol returns the protocol identifier of the scan.
+func (scanner *Scanner) Protocol() string {
+	return "udp"
+}
+
+// GetPort returns the port being scanned.
+func (scanner *Scanner) GetPort() uint {
+	return scanner.config.Port
+}
+
+// Scan sends the payload, retransmitting until a reply is received, then
+// collects any further replies.
+func (scanner *Scanner) Scan(target zgrab2.ScanTarget) (zgrab2.ScanStatus, interface{}, error) {
+	conn, err := target.OpenUDP(&scanner.config.BaseFlags, &scanner.config.UDPFlags)
+	if err != nil {
+		return zgrab2.TryGetScanStatus(err), nil, err
+	}
+	defer conn.Close()
+	result := &Results{Payload: scanner.config.Payload}
+	buf := make([]byte, maxDatagramSize)
+	for result.Attempts <= scanner.retries && len(result.Responses) == 0 {
+		if _, err := conn.Write(scanner.payload); err != nil {
+			return zgrab2.TryGetScanStatus(err), nil, err
+		}
+		result.Attempts++
+		if err := scanner.readResponses(conn, buf, result, scanner.retransmitInterval); err != nil {
+			return zgrab2.TryGetScanStatus(err), nil, err
+		}
+	}
+	if len(result.Responses) == 0 {
+		return zgrab2.SCAN_IO_TIMEOUT, nil, ErrNoResponse
+	}
+	if scanner.pattern == nil {
+		return zgrab2.SCAN_SUCCESS, result, nil
+	}
+	for _, response := range result.Responses {
+		if groups := scanner.pattern.FindSubmatch(response); groups != nil {
+			result.Matched = true
+			for _, group := range groups {
+				result.Submatches = append(result.Submatches, string(group))
+			}
+			return zgrab2.SCAN_SUCCESS, result, nil
+		}
+	}
+	return zgrab2.SCAN_PROTOCOL_ERROR, result, ErrNoMatch
+}
+
+// readResponses reads replies into result until wait has elapsed with no
+// reply, or the linger time has elapsed after the first reply, or
+// MaxResponses replies have been received. Timeouts are not errors.
+func (scanner *Scanner) readResponses(conn net.Conn, buf []byte, result *Results, wait time.Duration) error {
+	deadline := time.Now().Add(wait)
+	for len(result.Responses) < scanner.config.MaxResponses {
+		if err := conn.SetReadDeadline(deadline); err != nil {
+			return err
+		}
+		n, err := conn.Read(buf)
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				return nil
+			}
+			return err
+		}
+		response := make([]byte, n)
+		copy(response, buf[:n])
+		result.Responses = append(result.Responses, response)
+		if len(result.Responses) == 1 {
+			deadline = time.Now().Add(scanner.config.Linger)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package udp
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/zmap/zgrab2"
+)
+
+// startServer listens on a loopback UDP port and replies to the second
+// datagram it receives (ignoring the first, to exercise retransmission) with
+// two datagrams.
+func startServer(t *testing.T) *net.UDPConn {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		t.Fatalf("could not listen: %v", err)
+	}
+	go func() {
+		buf := make([]byte, 1024)
+		for i := 0; ; i++ {
+			n, addr, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			if i == 0 {
+				continue
+			}
+			conn.WriteToUDP(append([]byte("echo:"), buf[:n]...), addr)
+			conn.WriteToUDP([]byte("second"), addr)
+		}
+	}()
+	return conn
+}
+
+func newScanner(t *testing.T, port int, pattern string) *Scanner {
+	flags := &Flags{
+		PayloadHex:         "70 69 6e 67",
+		Retries:            2,
+		RetransmitInterval: 100 * time.Millisecond,
+		MaxResponses:       2,
+		Linger:             100 * time.Millisecond,
+		Pattern:            pattern,
+	}
+	flags.Port = uint(port)
+	flags.Timeout = time.Second
+	if err := flags.Validate(nil); err != nil {
+		t.Fatalf("invalid flags: %v", err)
+	}
+	scanner := new(Scanner)
+	if err := scanner.Init(flags); err != nil {
+		t.Fatalf("could not initialize scanner: %v", err)
+	}
+	return scanner
+}
+
+func TestScan(t *testing.T) {
+	server := startServer(t)
+	defer server.Close()
+	port := server.LocalAddr().(*net.UDPAddr).Port
+	target := zgrab2.ScanTarget{IP: net.ParseIP("127.0.0.1")}
+
+	status, res, err := newScanner(t, port, `^echo:(\w+)`).Scan(target)
+	if status != zgrab2.SCAN_SUCCESS || err != nil {
+		t.Fatalf("expected success, got %s (%v)", status, err)
+	}
+	result := res.(*Results)
+	if result.Attempts != 2 {
+		t.Errorf("expected 2 attempts, got %d", result.Attempts)
+	}
+	if len(result.Responses) != 2 || !bytes.Equal(result.Responses[0], []byte("echo:ping")) || !bytes.Equal(result.Responses[1], []byte("second")) {
+		t.Errorf("unexpected responses %q", result.Responses)
+	}
+	if !result.Matched || len(result.Submatches) != 2 || result.Submatches[1] != "ping" {
+		t.Errorf("unexpected match %v %q", result.Matched, result.Submatches)
+	}
+
+	status, _, err = newScanner(t, port, `^pong`).Scan(target)
+	if status != zgrab2.SCAN_PROTOCOL_ERROR || err != ErrNoMatch {
+		t.Errorf("expected %s, got %s (%v)", zgrab2.SCAN_PROTOCOL_ERROR, status, err)
+	}
+}
+
+func TestPayloads(t *testing.T) {
+	server := startServer(t)
+	defer server.Close()
+	port := server.LocalAddr().(*net.UDPAddr).Port
+	target := zgrab2.ScanTarget{IP: net.ParseIP("127.0.0.1")}
+
+	// The server ignores the first datagram, and memcached is sent only
+	// once unless --retries is given.
+	flags := &Flags{Payload: "memcached", Retries: -1, RetransmitInterval: 100 * time.Millisecond, MaxResponses: 1}
+	flags.Port = uint(port)
+	flags.Timeout = time.Second
+	if err := flags.Validate(nil); err != nil {
+		t.Fatalf("invalid flags: %v", err)
+	}
+	scanner := new(Scanner)
+	if err := scanner.Init(flags); err != nil {
+		t.Fatalf("could not initialize scanner: %v", err)
+	}
+	if status, _, err := scanner.Scan(target); status != zgrab2.SCAN_IO_TIMEOUT || err != ErrNoResponse {
+		t.Errorf("expected %s, got %s (%v)", zgrab2.SCAN_IO_TIMEOUT, status, err)
+	}
+
+	// The server has now seen a datagram, so answers the next.
+	flags.Retries = 1
+	if err := scanner.Init(flags); err != nil {
+		t.Fatalf("could not initialize scanner: %v", err)
+	}
+	status, res, err := scanner.Scan(target)
+	if status != zgrab2.SCAN_SUCCESS || err != nil {
+		t.Fatalf("expected success, got %s (%v)", status, err)
+	}
+	result := res.(*Results)
+	if result.Payload != "memcached" || result.Attempts != 1 {
+		t.Errorf("unexpected payload %q and attempts %d", result.Payload, result.Attempts)
+	}
+	expected := append([]byte("echo:"), payloads["memcached"].Data...)
+	if len(result.Responses) != 1 || !bytes.Equal(result.Responses[0], expected) {
+		t.Errorf("unexpected responses %q", result.Responses)
+	}
+
+	flags.Payload = "nonesuch"
+	if err := flags.Validate(nil); err == nil {
+		t.Error("expected an unknown payload to be rejected")
+	}
+	flags.Payload, flags.PayloadHex = "dns", "00"
+	if err := flags.Validate(nil); err == nil {
+		t.Error("expected --payload and --payload-hex to be rejected together")
+	}
+}
+
+func TestRetransmitPolicy(t *testing.T) {
+	tests := []struct {
+		retries  int
+		interval time.Duration
+		payload  string
+
+		expectedRetries  int
+		expectedInterval time.Duration
+	}{
+		{-1, 0, "", defaultRetries, defaultRetransmitInterval},
+		{-1, 0, "dns", defaultRetries, defaultRetransmitInterval},
+		{-1, 0, "ssdp", 0, defaultRetransmitInterval},
+		{-1, 0, "a2s", defaultRetries, 2 * time.Second},
+		{3, 0, "ssdp", 3, defaultRetransmitInterval},
+		{-1, 5 * time.Second, "a2s", defaultRetries, 5 * time.Second},
+	}
+	for _, test := range tests {
+		flags := &Flags{Retries: test.retries, RetransmitInterval: test.interval}
+		var payload *Payload
+		if test.payload != "" {
+			payload = payloads[test.payload]
+		}
+		retries, interval := flags.retransmitPolicy(payload)
+		if retries != test.expectedRetries || interval != test.expectedInterval {
+			t.Errorf("%q with %d, %v: expected %d, %v, got %d, %v", test.payload, test.retries, test.interval,
+				test.expectedRetries, test.expectedInterval, retries, interval)
+		}
+	}
+}
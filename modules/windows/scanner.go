@@ -0,0 +1,293 @@
+// Package windows provides a zgrab2 meta-module that profiles a Windows host
+// by running several Windows-related modules against it and merging their
+// results into a single document.
+//
+// The modules to run are given by --modules (by default smb, mssql, rdp,
+// winrm and kerberos); each is run on its own default port with its default
+// flags, except that the smb module is run with --setup-session so that the
+// server's target name is available. Modules that are not compiled into this
+// build of zgrab2 are skipped with a warning.
+//
+// If --gate names one of the modules (smb by default), that module is run
+// first, and the remaining modules are only run if it succeeds, so that
+// non-Windows hosts cost a single probe.
+//
+// The output contains each module's full scan response under modules, and
+// a summary of the host (SMB dialect / signing / v1 support, NTLM target
+// name, MSSQL version and instance, and the list of modules that succeeded),
+// with the NTLM info of the modules that give it merged, as in the grab's
+// ntlm block.
+package windows
+
+import (
+	"fmt"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/zmap/zgrab2"
+	"github.com/zmap/zgrab2/lib/smb/smb"
+	"github.com/zmap/zgrab2/modules/mssql"
+	smbmodule "github.com/zmap/zgrab2/modules/smb"
+)
+
+// smbSigningRequired is the SMB2 SecurityMode bit indicating that the server
+// requires message signing.
+const smbSigningRequired = 0x0002
+
+// Flags holds the command-line configuration for the windows module.
+type Flags struct {
+	zgrab2.BaseFlags
+
+	Modules string `long:"modules" default:"smb,mssql,rdp,winrm,kerberos" description:"Comma-separated list of modules to run"`
+	Gate    string `long:"gate" default:"smb" description:"Only run the other modules if this one succeeds (empty to always run all)"`
+	Verbose bool   `long:"verbose" description:"More verbose logging, include debug fields in the scan results"`
+}
+
+// Module implements the zgrab2.Module interface.
+type Module struct {
+}
+
+// Scanner implements the zgrab2.Scanner interface.
+type Scanner struct {
+	config *Flags
+
+	// gate is the scanner for the --gate module, if any.
+	gate zgrab2.Scanner
+
+	// scanners are the other scanners, in --modules order.
+	scanners []zgrab2.Scanner
+}
+
+// Profile is the merged Windows host profile.
+type Profile struct {
+	// Services lists the modules whose scans succeeded.
+	Services []string `json:"services,omitempty"`
+
+	// SMBv1Support is true if the server supports SMB version 1.
+	SMBv1Support *bool `json:"smbv1_support,omitempty"`
+
+	// SMBDialect is the SMB2 dialect negotiated with the server.
+	SMBDialect uint16 `json:"smb_dialect,omitempty"`
+
+	// SMBSigningRequired is true if the server requires SMB message signing.
+	SMBSigningRequired *bool `json:"smb_signing_required,omitempty"`
+
+	// NTLMTargetName is the target name from the SMB NTLM challenge.
+	NTLMTargetName string `json:"ntlm_target_name,omitempty"`
+
+	// NTLM merges the NTLM info from each module, and flags services that
+	// give different names.
+	NTLM *zgrab2.NTLMCorrelation `json:"ntlm,omitempty"`
+
+	// MSSQLVersion is the version returned in the MSSQL PRELOGIN response.
+	MSSQLVersion string `json:"mssql_version,omitempty"`
+
+	// MSSQLInstanceName is the instance name returned in the MSSQL PRELOGIN
+	// response.
+	MSSQLInstanceName string `json:"mssql_instance_name,omitempty"`
+
+	// Modules contains the full scan response from each module that was run.
+	Modules map[string]zgrab2.ScanResponse `json:"modules"`
+}
+
+// RegisterModule registers the zgrab2 module.
+func RegisterModule() {
+	var module Module
+	_, err := zgrab2.AddCommand("windows", "Windows host profile", "Run smb, mssql, rdp, winrm and kerberos scans and merge them into a Windows host profile", 445, &module)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// NewFlags returns a default Flags object.
+func (module *Module) NewFlags() interface{} {
+	return new(Flags)
+}
+
+// NewScanner returns a new Scanner instance.
+func (module *Module) NewScanner() zgrab2.Scanner {
+	return new(Scanner)
+}
+
+// Validate checks that the flags are valid.
+// On success, returns nil.
+// On failure, returns an error instance describing the error.
+func (flags *Flags) Validate(args []string) error {
+	if flags.Gate == "" {
+		return nil
+	}
+	for _, name := range strings.Split(flags.Modules, ",") {
+		if strings.TrimSpace(name) == flags.Gate {
+			return nil
+		}
+	}
+	return fmt.Errorf("gate module %s is not in --modules", flags.Gate)
+}
+
+// Help returns the module's help string.
+func (flags *Flags) Help() string {
+	return ""
+}
+
+// Init initializes the Scanner and each of the component scanners.
+func (scanner *Scanner) Init(flags zgrab2.ScanFlags) error {
+	f, _ := flags.(*Flags)
+	scanner.config = f
+	if f.Verbose {
+		log.SetLevel(log.DebugLevel)
+	}
+	for _, name := range strings.Split(f.Modules, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		s, err := scanner.newScanner(name)
+		if err != nil {
+			return err
+		}
+		if s == nil {
+			if name == f.Gate {
+				return fmt.Errorf("gate module %s is not available", name)
+			}
+			log.Warnf("windows: module %s is not available in this build, skipping", name)
+			continue
+		}
+		if name == f.Gate {
+			scanner.gate = s
+		} else {
+			scanner.scanners = append(scanner.scanners, s)
+		}
+	}
+	return nil
+}
+
+// newScanner creates and initializes a scanner for the named module with its
+// default flags, or returns nil if the module is not registered.
+func (scanner *Scanner) newScanner(name string) (zgrab2.Scanner, error) {
+	module := zgrab2.GetModule(name)
+	if module == nil {
+		return nil, nil
+	}
+	flags, err := zgrab2.NewModuleFlags(name)
+	if err != nil {
+		return nil, err
+	}
+	if base := zgrab2.GetBaseFlags(flags); base != nil {
+		base.Timeout = scanner.config.Timeout
+		base.BytesReadLimit = scanner.config.BytesReadLimit
+		base.AddressFamily = scanner.config.AddressFamily
+	}
+	if smbFlags, ok := flags.(*smbmodule.Flags); ok {
+		smbFlags.SetupSession = true
+	}
+	if err := flags.Validate(nil); err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	s := module.NewScanner()
+	if err := s.Init(flags); err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return s, nil
+}
+
+// InitPerSender initializes each of the component scanners for a given sender.
+func (scanner *Scanner) InitPerSender(senderID int) error {
+	for _, s := range scanner.all() {
+		if err := s.InitPerSender(senderID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// all returns the gate scanner (if any) followed by the other scanners.
+func (scanner *Scanner) all() []zgrab2.Scanner {
+	if scanner.gate == nil {
+		return scanner.scanners
+	}
+	return append([]zgrab2.Scanner{scanner.gate}, scanner.scanners...)
+}
+
+// GetName returns the Scanner name defined in the Flags.
+func (scanner *Scanner) GetName() string {
+	return scanner.config.Name
+}
+
+// GetTrigger returns the Trigger defined in the Flags.
+func (scanner *Scanner) GetTrigger() string {
+	return scanner.config.Trigger
+}
+
+// Protocol returns the protocol identifier of the scan.
+func (scanner *Scanner) Protocol() string {
+	return "windows"
+}
+
+// Scan runs the gate module, then (if it succeeded) the other modules, and
+// merges their results. The scan succeeds if any of the modules succeeded.
+func (scanner *Scanner) Scan(target zgrab2.ScanTarget) (zgrab2.ScanStatus, interface{}, error) {
+	profile := &Profile{Modules: make(map[string]zgrab2.ScanResponse)}
+	var ntlmNames []string
+	var ntlmInfos []*zgrab2.NTLMInfo
+	var firstStatus zgrab2.ScanStatus
+	var firstErr error
+	for i, s := range scanner.all() {
+		_, resp := zgrab2.RunScanner(s, nil, target)
+		profile.add(s, resp)
+		if info := zgrab2.GetNTLMInfo(s, resp.Result); info != nil {
+			ntlmNames = append(ntlmNames, s.GetName())
+			ntlmInfos = append(ntlmInfos, info)
+		}
+		if i == 0 {
+			firstStatus = resp.Status
+			if resp.Error != nil {
+				firstErr = fmt.Errorf("%s: %s", s.GetName(), *resp.Error)
+			}
+			if s == scanner.gate && resp.Status != zgrab2.SCAN_SUCCESS {
+				break
+			}
+		}
+	}
+	profile.NTLM = zgrab2.CorrelateNTLM(ntlmNames, ntlmInfos)
+	if len(profile.Services) == 0 {
+		if firstStatus == "" {
+			return zgrab2.SCAN_UNKNOWN_ERROR, nil, fmt.Errorf("no modules to run")
+		}
+		return firstStatus, nil, firstErr
+	}
+	return zgrab2.SCAN_SUCCESS, profile, nil
+}
+
+// add records a module's scan response, and updates the summary fields from
+// the results of modules the profile knows about.
+func (profile *Profile) add(s zgrab2.Scanner, resp zgrab2.ScanResponse) {
+	name := s.GetName()
+	profile.Modules[name] = resp
+	if resp.Status == zgrab2.SCAN_SUCCESS {
+		profile.Services = append(profile.Services, name)
+	}
+	switch result := resp.Result.(type) {
+	case *smb.SMBLog:
+		if result == nil {
+			return
+		}
+		supportV1 := result.SupportV1
+		profile.SMBv1Support = &supportV1
+		if result.NegotiationLog != nil {
+			profile.SMBDialect = result.NegotiationLog.DialectRevision
+			required := result.NegotiationLog.SecurityMode&smbSigningRequired != 0
+			profile.SMBSigningRequired = &required
+		}
+		if result.SessionSetupLog != nil {
+			profile.NTLMTargetName = result.SessionSetupLog.TargetName
+		}
+	case *mssql.ScanResults:
+		if result == nil {
+			return
+		}
+		profile.MSSQLVersion = result.Version
+		if result.InstanceName != nil {
+			profile.MSSQLInstanceName = *result.InstanceName
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package windows
+
+import (
+	"errors"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zmap/zgrab2"
+	"github.com/zmap/zgrab2/lib/smb/smb"
+	"github.com/zmap/zgrab2/modules/mssql"
+)
+
+// fakeResponse is what a fake module's scan returns.
+type fakeResponse struct {
+	status zgrab2.ScanStatus
+	result interface{}
+	err    error
+	ntlm   *zgrab2.NTLMInfo
+}
+
+// fakeResponses maps each fake module's name to its response.
+var fakeResponses = make(map[string]fakeResponse)
+
+// fakeScans counts the scans run by each fake module.
+var fakeScans = make(map[string]int)
+
+type fakeFlags struct {
+	zgrab2.BaseFlags
+}
+
+func (flags *fakeFlags) Validate(args []string) error { return nil }
+func (flags *fakeFlags) Help() string                 { return "" }
+
+type fakeModule struct{}
+
+func (module *fakeModule) NewFlags() interface{}      { return new(fakeFlags) }
+func (module *fakeModule) NewScanner() zgrab2.Scanner { return new(fakeScanner) }
+
+type fakeScanner struct {
+	config *fakeFlags
+}
+
+func (s *fakeScanner) Init(flags zgrab2.ScanFlags) error {
+	s.config = flags.(*fakeFlags)
+	return nil
+}
+
+func (s *fakeScanner) InitPerSender(senderID int) error { return nil }
+func (s *fakeScanner) GetName() string                  { return s.config.Name }
+func (s *fakeScanner) GetTrigger() string               { return s.config.Trigger }
+func (s *fakeScanner) Protocol() string                 { return "fake" }
+
+func (s *fakeScanner) Scan(target zgrab2.ScanTarget) (zgrab2.ScanStatus, interface{}, error) {
+	fakeScans[s.GetName()]++
+	resp := fakeResponses[s.GetName()]
+	return resp.status, resp.result, resp.err
+}
+
+func (s *fakeScanner) NTLMInfo(result interface{}) *zgrab2.NTLMInfo {
+	return fakeResponses[s.GetName()].ntlm
+}
+
+var registerFakes sync.Once
+
+// newTestScanner registers the fake modules, and returns an initialized
+// windows scanner running the given modules.
+func newTestScanner(t *testing.T, modules, gate string) (*Scanner, error) {
+	registerFakes.Do(func() {
+		for i, name := range []string{"wintest-gate", "wintest-a", "wintest-b"} {
+			if _, err := zgrab2.AddCommand(name, name, name, 1000+i, new(fakeModule)); err != nil {
+				t.Fatalf("could not register %s: %v", name, err)
+			}
+		}
+	})
+	fakeScans = make(map[string]int)
+	flags := &Flags{Modules: modules, Gate: gate}
+	flags.Name = "windows"
+	flags.Timeout = 3 * time.Second
+	if err := flags.Validate(nil); err != nil {
+		return nil, err
+	}
+	scanner := new(Scanner)
+	return scanner, scanner.Init(flags)
+}
+
+func scannerNames(scanners []zgrab2.Scanner) []string {
+	var ret []string
+	for _, s := range scanners {
+		ret = append(ret, s.GetName())
+	}
+	return ret
+}
+
+func TestSubScanSelection(t *testing.T) {
+	scanner, err := newTestScanner(t, "wintest-a, wintest-gate,,wintest-missing,wintest-b", "wintest-gate")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// The gate runs first, then the others in order; missing modules are
+	// skipped.
+	if names := scannerNames(scanner.all()); !reflect.DeepEqual(names, []string{"wintest-gate", "wintest-a", "wintest-b"}) {
+		t.Errorf("unexpected scanners %v", names)
+	}
+	for _, s := range scanner.all() {
+		if config := s.(*fakeScanner).config; config.Timeout != 3*time.Second || config.Port < 1000 {
+			t.Errorf("%s: expected the module's default port and the windows timeout, got %+v", s.GetName(), config.BaseFlags)
+		}
+	}
+
+	scanner, err = newTestScanner(t, "wintest-a,wintest-b", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if scanner.gate != nil || len(scanner.all()) != 2 {
+		t.Errorf("expected no gate, got %v", scannerNames(scanner.all()))
+	}
+
+	if _, err := newTestScanner(t, "wintest-a", "wintest-gate"); err == nil {
+		t.Error("expected an error for a gate not in --modules")
+	}
+	if _, err := newTestScanner(t, "wintest-a,wintest-missing", "wintest-missing"); err == nil {
+		t.Error("expected an error for a gate module that is not available")
+	}
+}
+
+func TestGateFailure(t *testing.T) {
+	fakeResponses = map[string]fakeResponse{
+		"wintest-gate": {status: zgrab2.SCAN_CONNECTION_REFUSED, err: errors.New("refused")},
+		"wintest-a":    {status: zgrab2.SCAN_SUCCESS},
+	}
+	scanner, err := newTestScanner(t, "wintest-gate,wintest-a", "wintest-gate")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	status, result, err := scanner.Scan(zgrab2.ScanTarget{IP: net.ParseIP("192.0.2.1")})
+	if status != zgrab2.SCAN_CONNECTION_REFUSED || result != nil || err == nil || !strings.HasPrefix(err.Error(), "wintest-gate: ") {
+		t.Errorf("expected the gate's failure, got %s %v (%v)", status, result, err)
+	}
+	if fakeScans["wintest-gate"] != 1 || fakeScans["wintest-a"] != 0 {
+		t.Errorf("expected only the gate to be scanned, got %v", fakeScans)
+	}
+}
+
+func TestResultAggregation(t *testing.T) {
+	version := "15.0.2000"
+	instance := "MSSQLSERVER"
+	fakeResponses = map[string]fakeResponse{
+		"wintest-gate": {
+			status: zgrab2.SCAN_SUCCESS,
+			result: &smb.SMBLog{
+				SupportV1:       true,
+				NegotiationLog:  &smb.NegotiationLog{DialectRevision: 0x0311, SecurityMode: 0x0003},
+				SessionSetupLog: &smb.SessionSetupLog{TargetName: "CORP"},
+			},
+			ntlm: &zgrab2.NTLMInfo{TargetName: "CORP", DNSComputerName: "db1.corp.example.com"},
+		},
+		"wintest-a": {status: zgrab2.SCAN_IO_TIMEOUT, err: errors.New("timeout")},
+		"wintest-b": {
+			status: zgrab2.SCAN_SUCCESS,
+			result: &mssql.ScanResults{Version: version, InstanceName: &instance},
+			ntlm:   &zgrab2.NTLMInfo{TargetName: "CORP", DNSComputerName: "db2.corp.example.com"},
+		},
+	}
+	scanner, err := newTestScanner(t, "wintest-gate,wintest-a,wintest-b", "wintest-gate")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	status, result, err := scanner.Scan(zgrab2.ScanTarget{IP: net.ParseIP("192.0.2.1")})
+	if status != zgrab2.SCAN_SUCCESS || err != nil {
+		t.Fatalf("expected success, got %s (%v)", status, err)
+	}
+	profile := result.(*Profile)
+	if !reflect.DeepEqual(profile.Services, []string{"wintest-gate", "wintest-b"}) {
+		t.Errorf("unexpected services %v", profile.Services)
+	}
+	if len(profile.Modules) != 3 || profile.Modules["wintest-a"].Status != zgrab2.SCAN_IO_TIMEOUT {
+		t.Errorf("expected every module's response, got %v", profile.Modules)
+	}
+	if profile.SMBv1Support == nil || !*profile.SMBv1Support || profile.SMBDialect != 0x0311 ||
+		profile.SMBSigningRequired == nil || !*profile.SMBSigningRequired || profile.NTLMTargetName != "CORP" {
+		t.Errorf("unexpected SMB summary %+v", profile)
+	}
+	if profile.MSSQLVersion != version || profile.MSSQLInstanceName != instance {
+		t.Errorf("unexpected MSSQL summary %q %q", profile.MSSQLVersion, profile.MSSQLInstanceName)
+	}
+	if profile.NTLM == nil || !reflect.DeepEqual(profile.NTLM.Sources, []string{"wintest-gate", "wintest-b"}) || !profile.NTLM.Inconsistent {
+		t.Errorf("expected the NTLM info to be merged and flagged inconsistent, got %+v", profile.NTLM)
+	}
+}
+
+func TestNoModuleSucceeds(t *testing.T) {
+	fakeResponses = map[string]fakeResponse{
+		"wintest-a": {status: zgrab2.SCAN_CONNECTION_TIMEOUT, err: errors.New("timeout")},
+		"wintest-b": {status: zgrab2.SCAN_CONNECTION_REFUSED, err: errors.New("refused")},
+	}
+	scanner, err := newTestScanner(t, "wintest-a,wintest-b", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	status, result, err := scanner.Scan(zgrab2.ScanTarget{IP: net.ParseIP("192.0.2.1")})
+	// Without a gate, every module runs, and the first one's failure is
+	// returned.
+	if fakeScans["wintest-a"] != 1 || fakeScans["wintest-b"] != 1 {
+		t.Errorf("expected both modules to be scanned, got %v", fakeScans)
+	}
+	if status != zgrab2.SCAN_CONNECTION_TIMEOUT || result != nil || err == nil || err.Error() != "wintest-a: timeout" {
+		t.Errorf("expected the first module's failure, got %s %v (%v)", status, result, err)
+	}
+}
@@ -1,10 +1,25 @@
 package zgrab2
 
-import "errors"
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/zmap/zflags"
+	"gopkg.in/yaml.v2"
+)
 
 // MultipleCommand contains the command line options for running
 type MultipleCommand struct {
 	ConfigFileName  string `short:"c" long:"config-file" default:"-" description:"Config filename, use - for stdin"`
+	ConfigFormat    string `long:"config-format" default:"auto" choice:"auto" choice:"ini" choice:"yaml" description:"Format of the config file; auto uses YAML for .yaml/.yml files, and for stdin that does not start with an INI section"`
 	ContinueOnError bool   `long:"continue-on-error" description:"If proceeding protocols error, do not run following protocols (default: true)"`
 }
 
@@ -21,3 +36,288 @@ func (x *MultipleCommand) Validate(args []string) error {
 func (x *MultipleCommand) Help() string {
 	return ""
 }
+
+// multipleConfig is the YAML form of the multiple config file:
+//
+//	defaults:          # global options, as in [Application Options]
+//	  output-file: output.json
+//	module-defaults:   # flags for every module, unless it sets them itself
+//	  timeout: 10s
+//	triggers:          # input tag -> names of the modules it invokes
+//	  web: [http]
+//	personas:          # client identities: module -> its identity flags
+//	  curl:
+//	    http: {user-agent: curl/8.5.0}
+//	modules:
+//	  - module: http   # one entry per [section] of the INI form
+//	    ports: [80, 8080]
+//	    personas: [curl]
+//	    endpoint: /
+//
+// Module entries take the module's flags by their long names. With more than
+// one port, an entry runs the module once per port, each named
+// <name>-<port>, and with more than one persona, once per persona (and
+// port), each named <name>[-<port>]-<persona>.
+type multipleConfig struct {
+	Defaults       map[string]interface{}                       `yaml:"defaults"`
+	ModuleDefaults map[string]interface{}                       `yaml:"module-defaults"`
+	Triggers       map[string][]string                          `yaml:"triggers"`
+	Personas       map[string]map[string]map[string]interface{} `yaml:"personas"`
+	Modules        []multipleModuleConfig                       `yaml:"modules"`
+}
+
+// multipleModuleConfig is a module entry in the YAML multiple config.
+type multipleModuleConfig struct {
+	Module   string                 `yaml:"module"`
+	Ports    []uint                 `yaml:"ports"`
+	Personas []string               `yaml:"personas"`
+	Flags    map[string]interface{} `yaml:",inline"`
+}
+
+// iniSection is a section of the INI config generated from the YAML config,
+// labeled with the part of the YAML config it came from for error messages.
+type iniSection struct {
+	label string
+	name  string
+	flags map[string]interface{}
+}
+
+// ParseMultipleConfig reads the config file for the multiple command, and
+// returns the module names and flags of the scanners it configures.
+func ParseMultipleConfig(m *MultipleCommand) ([]string, []interface{}, error) {
+	var data []byte
+	var err error
+	if m.ConfigFileName == "-" {
+		data, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		data, err = ioutil.ReadFile(m.ConfigFileName)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if !isYAMLConfig(m.ConfigFormat, m.ConfigFileName, data) {
+		iniParser := NewIniParser()
+		if m.ConfigFileName == "-" {
+			return iniParser.Parse(bytes.NewReader(data))
+		}
+		return iniParser.ParseFile(m.ConfigFileName)
+	}
+	sections, err := parseYAMLConfig(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	ini, lines, err := sectionsToINI(sections)
+	if err != nil {
+		return nil, nil, err
+	}
+	modTypes, flagsReturned, err := NewIniParser().Parse(bytes.NewReader(ini))
+	if iniErr, ok := err.(*flags.IniError); ok {
+		// Blame the section that the line came from.
+		for i := len(lines) - 1; i >= 0; i-- {
+			if iniErr.LineNumber >= lines[i] {
+				return nil, nil, fmt.Errorf("%s: %s", sections[i].label, iniErr.Message)
+			}
+		}
+	}
+	return modTypes, flagsReturned, err
+}
+
+// isYAMLConfig returns true if the config file is in YAML form.
+func isYAMLConfig(format string, fileName string, data []byte) bool {
+	switch format {
+	case "yaml":
+		return true
+	case "ini":
+		return false
+	}
+	if fileName != "-" {
+		ext := strings.ToLower(filepath.Ext(fileName))
+		return ext == ".yaml" || ext == ".yml"
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		return !strings.HasPrefix(line, "[")
+	}
+	return false
+}
+
+// parseYAMLConfig parses and validates the YAML config, returning the
+// equivalent INI sections.
+func parseYAMLConfig(data []byte) ([]iniSection, error) {
+	var cfg multipleConfig
+	if err := yaml.UnmarshalStrict(data, &cfg); err != nil {
+		return nil, err
+	}
+	if len(cfg.Modules) == 0 {
+		return nil, errors.New("no modules configured")
+	}
+	sections := []iniSection{{label: "defaults", name: "Application Options", flags: cfg.Defaults}}
+	byName := make(map[string]int)
+	// entries maps each module entry's name to its sections, for triggers.
+	entries := make(map[string][]int)
+	for i, mod := range cfg.Modules {
+		label := fmt.Sprintf("modules[%d]", i)
+		if mod.Module == "" {
+			return nil, fmt.Errorf("%s: no module given", label)
+		}
+		if GetModule(mod.Module) == nil {
+			return nil, fmt.Errorf("%s: unknown module %q", label, mod.Module)
+		}
+		modFlags := make(map[string]interface{})
+		for k, v := range cfg.ModuleDefaults {
+			modFlags[k] = v
+		}
+		for k, v := range mod.Flags {
+			modFlags[k] = v
+		}
+		name := mod.Module
+		if n, ok := modFlags["name"]; ok {
+			name = fmt.Sprint(n)
+		}
+		label = fmt.Sprintf("modules[%d] (%s)", i, name)
+		ports := []string{""}
+		if len(mod.Ports) > 0 {
+			if _, ok := mod.Flags["port"]; ok {
+				return nil, fmt.Errorf("%s: both port and ports given", label)
+			}
+			ports = nil
+			for _, port := range mod.Ports {
+				if port == 0 || port > 65535 {
+					return nil, fmt.Errorf("%s: invalid port %d", label, port)
+				}
+				ports = append(ports, strconv.FormatUint(uint64(port), 10))
+			}
+		}
+		personas := []string{""}
+		if len(mod.Personas) > 0 {
+			personas = mod.Personas
+		}
+		for _, persona := range personas {
+			if persona == "" {
+				continue
+			}
+			identity, ok := cfg.Personas[persona]
+			if !ok {
+				return nil, fmt.Errorf("%s: unknown persona %q", label, persona)
+			}
+			for k := range identity[mod.Module] {
+				if _, ok := mod.Flags[k]; ok {
+					return nil, fmt.Errorf("%s: %s is set both by the entry and by persona %q", label, k, persona)
+				}
+			}
+		}
+		for _, port := range ports {
+			for _, persona := range personas {
+				section := iniSection{label: label, name: mod.Module, flags: make(map[string]interface{})}
+				for k, v := range modFlags {
+					section.flags[k] = v
+				}
+				sectionName := name
+				if port != "" {
+					section.flags["port"] = port
+					if len(ports) > 1 {
+						sectionName += "-" + port
+					}
+				}
+				if persona != "" {
+					for k, v := range cfg.Personas[persona][mod.Module] {
+						section.flags[k] = v
+					}
+					if len(personas) > 1 {
+						sectionName += "-" + persona
+					}
+				}
+				section.flags["name"] = sectionName
+				if j, ok := byName[sectionName]; ok {
+					return nil, fmt.Errorf("%s: name %q is already used by %s", label, sectionName, sections[j].label)
+				}
+				byName[sectionName] = len(sections)
+				entries[name] = append(entries[name], len(sections))
+				sections = append(sections, section)
+			}
+		}
+	}
+	// Apply the triggers in a fixed order, so that errors are reproducible.
+	tags := make([]string, 0, len(cfg.Triggers))
+	for tag := range cfg.Triggers {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	for _, tag := range tags {
+		for _, name := range cfg.Triggers[tag] {
+			indices, ok := entries[name]
+			if j, isSection := byName[name]; !ok && isSection {
+				indices, ok = []int{j}, true
+			}
+			if !ok {
+				return nil, fmt.Errorf("triggers.%s: no module named %q", tag, name)
+			}
+			for _, j := range indices {
+				if _, ok := sections[j].flags["trigger"]; ok {
+					return nil, fmt.Errorf("triggers.%s: %s already has a trigger", tag, sections[j].label)
+				}
+				sections[j].flags["trigger"] = tag
+			}
+		}
+	}
+	return sections, nil
+}
+
+// sectionsToINI encodes the sections as an INI config, returning it along
+// with the line number that each section starts on.
+func sectionsToINI(sections []iniSection) ([]byte, []uint, error) {
+	var buf bytes.Buffer
+	lines := make([]uint, len(sections))
+	line := uint(1)
+	for i, section := range sections {
+		lines[i] = line
+		fmt.Fprintf(&buf, "[%s]\n", section.name)
+		line++
+		keys := make([]string, 0, len(section.flags))
+		for k := range section.flags {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			values, err := iniValues(section.flags[k])
+			if err != nil {
+				return nil, nil, fmt.Errorf("%s: %s: %v", section.label, k, err)
+			}
+			for _, v := range values {
+				fmt.Fprintf(&buf, "%s=%s\n", k, strconv.Quote(v))
+				line++
+			}
+		}
+	}
+	return buf.Bytes(), lines, nil
+}
+
+// iniValues returns the INI value(s) of a YAML flag value; a list is given as
+// one line per element, as for repeated command-line flags.
+func iniValues(v interface{}) ([]string, error) {
+	switch v := v.(type) {
+	case nil:
+		return nil, errors.New("no value given")
+	case []interface{}:
+		ret := make([]string, 0, len(v))
+		for _, elt := range v {
+			values, err := iniValues(elt)
+			if err != nil {
+				return nil, err
+			}
+			if len(values) != 1 {
+				return nil, errors.New("nested lists are not supported")
+			}
+			ret = append(ret, values[0])
+		}
+		return ret, nil
+	case map[interface{}]interface{}:
+		return nil, errors.New("nested values are not supported")
+	default:
+		return []string{fmt.Sprint(v)}, nil
+	}
+}
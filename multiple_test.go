@@ -0,0 +1,170 @@
+package zgrab2
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// fakeModule is registered to test config validation; it is never run.
+type fakeModule struct{}
+
+func (m *fakeModule) NewFlags() interface{} { return new(BaseFlags) }
+
+func (m *fakeModule) NewScanner() Scanner { return nil }
+
+func TestIsYAMLConfig(t *testing.T) {
+	tests := []struct {
+		format   string
+		fileName string
+		data     string
+		expected bool
+	}{
+		{"auto", "multiple.ini", "modules:\n", false},
+		{"auto", "multiple.yaml", "[http]\n", true},
+		{"auto", "multiple.YML", "", true},
+		{"auto", "-", "\n# comment\n[Application Options]\n", false},
+		{"auto", "-", "modules:\n  - module: http\n", true},
+		{"yaml", "multiple.ini", "", true},
+		{"ini", "multiple.yaml", "", false},
+	}
+	for _, test := range tests {
+		if got := isYAMLConfig(test.format, test.fileName, []byte(test.data)); got != test.expected {
+			t.Errorf("%s %s %q: expected %v, got %v", test.format, test.fileName, test.data, test.expected, got)
+		}
+	}
+}
+
+func TestParseYAMLConfig(t *testing.T) {
+	defer delete(modules, "fake")
+	modules["fake"] = new(fakeModule)
+
+	sections, err := parseYAMLConfig([]byte(`
+defaults:
+  senders: 10
+module-defaults:
+  timeout: 5s
+triggers:
+  web: [web]
+  mail: [fake-25]
+modules:
+  - module: fake
+    name: web
+    ports: [80, 8080]
+    timeout: 10s
+    header: [a, b]
+  - module: fake
+    port: 25
+    name: fake-25
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(sections) != 4 {
+		t.Fatalf("expected 4 sections, got %d", len(sections))
+	}
+	ini, lines, err := sectionsToINI(sections)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := `[Application Options]
+senders="10"
+[fake]
+header="a"
+header="b"
+name="web-80"
+port="80"
+timeout="10s"
+trigger="web"
+[fake]
+header="a"
+header="b"
+name="web-8080"
+port="8080"
+timeout="10s"
+trigger="web"
+[fake]
+name="fake-25"
+port="25"
+timeout="5s"
+trigger="mail"
+`
+	if string(ini) != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, ini)
+	}
+	if len(lines) != 4 || lines[1] != 3 || lines[3] != 17 {
+		t.Errorf("bad section lines %v", lines)
+	}
+}
+
+func TestParseYAMLConfigPersonas(t *testing.T) {
+	defer delete(modules, "fake")
+	modules["fake"] = new(fakeModule)
+
+	sections, err := parseYAMLConfig([]byte(`
+module-defaults:
+  user-agent: zgrab2
+personas:
+  curl:
+    fake: {user-agent: curl/8.5.0}
+  chrome:
+    fake: {user-agent: Chrome, header: [a, b]}
+    other: {client: SSH-2.0-OpenSSH_9.6}
+modules:
+  - module: fake
+    ports: [80, 8080]
+    personas: [curl, chrome]
+  - module: fake
+    name: single
+    personas: [chrome]
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var names []string
+	for _, section := range sections[1:] {
+		names = append(names, fmt.Sprintf("%v %v", section.flags["name"], section.flags["user-agent"]))
+	}
+	expected := []string{"fake-80-curl curl/8.5.0", "fake-80-chrome Chrome", "fake-8080-curl curl/8.5.0", "fake-8080-chrome Chrome", "single Chrome"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("got sections %v, expected %v", names, expected)
+	}
+	if _, ok := sections[1].flags["header"]; ok {
+		t.Errorf("curl section has chrome's flags: %v", sections[1].flags)
+	}
+}
+
+func TestParseYAMLConfigErrors(t *testing.T) {
+	modules["fake"] = new(fakeModule)
+	defer delete(modules, "fake")
+
+	tests := []struct {
+		config   string
+		expected string
+	}{
+		{"modules: []", "no modules configured"},
+		{"modules:\n  - module: nope", "modules[0]: unknown module"},
+		{"modules:\n  - module: fake\n  - module: fake", `modules[1] (fake): name "fake" is already used by modules[0] (fake)`},
+		{"modules:\n  - module: fake\n    port: 1\n    ports: [2]", "modules[0] (fake): both port and ports"},
+		{"modules:\n  - module: fake\n    ports: [70000]", "modules[0] (fake): invalid port"},
+		{"triggers:\n  tag: [x]\nmodules:\n  - module: fake", `triggers.tag: no module named "x"`},
+		{"modules:\n  - module: fake\n    personas: [x]", `modules[0] (fake): unknown persona "x"`},
+		{"personas:\n  x:\n    fake: {user-agent: a}\nmodules:\n  - module: fake\n    personas: [x]\n    user-agent: b", `modules[0] (fake): user-agent is set both by the entry and by persona "x"`},
+		{"triggers:\n  tag: [fake]\nmodules:\n  - module: fake\n    trigger: other", "triggers.tag: modules[0] (fake) already has a trigger"},
+	}
+	for _, test := range tests {
+		_, err := parseYAMLConfig([]byte(test.config))
+		if err == nil || !strings.Contains(err.Error(), test.expected) {
+			t.Errorf("%q: expected error containing %q, got %v", test.config, test.expected, err)
+		}
+	}
+
+	sections, err := parseYAMLConfig([]byte("modules:\n  - module: fake\n    endpoint:\n      path: /"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, _, err := sectionsToINI(sections); err == nil || !strings.HasPrefix(err.Error(), "modules[0] (fake): endpoint:") {
+		t.Errorf("expected an error naming the section, got %v", err)
+	}
+}
@@ -0,0 +1,98 @@
+package zgrab2
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// nat64Prefix, if set, is the --nat64-prefix that IPv4 addresses are
+// embedded in before they are dialed.
+var nat64Prefix *net.IPNet
+
+// parseNAT64Prefix parses an IPv6 prefix of one of the lengths RFC 6052
+// allows for IPv4-embedded addresses: 32, 40, 48, 56, 64 or 96.
+func parseNAT64Prefix(s string) (*net.IPNet, error) {
+	ip, prefix, err := net.ParseCIDR(s)
+	if err != nil {
+		return nil, err
+	}
+	if ip.To4() != nil {
+		return nil, fmt.Errorf("NAT64 prefix %s is not an IPv6 prefix", s)
+	}
+	switch ones, _ := prefix.Mask.Size(); ones {
+	case 32, 40, 48, 56, 64, 96:
+	default:
+		return nil, fmt.Errorf("NAT64 prefix %s must have length 32, 40, 48, 56, 64 or 96", s)
+	}
+	return prefix, nil
+}
+
+// embedIPv4 returns the IPv6 address embedding the IPv4 address ip in
+// prefix (RFC 6052, section 2.2): the IPv4 address follows the prefix,
+// skipping bits 64 to 71, which are zero.
+func embedIPv4(prefix *net.IPNet, ip net.IP) net.IP {
+	ones, _ := prefix.Mask.Size()
+	ret := make(net.IP, net.IPv6len)
+	copy(ret, prefix.IP.To16())
+	v4 := ip.To4()
+	pos := ones / 8
+	for _, b := range v4 {
+		if pos == 8 {
+			ret[pos] = 0
+			pos++
+		}
+		ret[pos] = b
+		pos++
+	}
+	return ret
+}
+
+// nat64IP returns the address to dial for ip: its NAT64 address, if ip is
+// an IPv4 address and --nat64-prefix is set, or ip otherwise.
+func nat64IP(ip net.IP) net.IP {
+	if nat64Prefix == nil || ip.To4() == nil {
+		return ip
+	}
+	return embedIPv4(nat64Prefix, ip)
+}
+
+// nat64Addrs replaces the IPv4 addresses in addrs with their NAT64
+// addresses, if --nat64-prefix is set.
+func nat64Addrs(addrs []net.IPAddr) []net.IPAddr {
+	if nat64Prefix == nil {
+		return addrs
+	}
+	ret := make([]net.IPAddr, len(addrs))
+	for i, addr := range addrs {
+		ret[i] = net.IPAddr{IP: nat64IP(addr.IP), Zone: addr.Zone}
+	}
+	return ret
+}
+
+// isNAT64Address returns true if ip was synthesized from an IPv4 address
+// with --nat64-prefix.
+func isNAT64Address(ip net.IP) bool {
+	return nat64Prefix != nil && ip.To4() == nil && nat64Prefix.Contains(ip)
+}
+
+// splitZone splits an IPv6 address with a zone (e.g. fe80::1%eth0) into
+// the address and the zone. Anything else is returned unchanged, with no
+// zone.
+func splitZone(s string) (string, string) {
+	i := strings.LastIndexByte(s, '%')
+	if i < 0 {
+		return s, ""
+	}
+	if ip := net.ParseIP(s[:i]); ip == nil || ip.To4() != nil || i == len(s)-1 {
+		return s, ""
+	}
+	return s[:i], s[i+1:]
+}
+
+// parseHostIP parses the host part of an address to dial, which may be an
+// IPv6 address with a zone, returning nil if it is not an IP address.
+func parseHostIP(host string) net.IP {
+	addr, _ := splitZone(host)
+	return net.ParseIP(addr)
+}
@@ -0,0 +1,70 @@
+package zgrab2
+
+import (
+	"context"
+	"net"
+	"testing"
+)
+
+func TestEmbedIPv4(t *testing.T) {
+	// The examples of RFC 6052, section 2.4.
+	tests := map[string]string{
+		"2001:db8::/32":         "2001:db8:c000:221::",
+		"2001:db8:100::/40":     "2001:db8:1c0:2:21::",
+		"2001:db8:122::/48":     "2001:db8:122:c000:2:2100::",
+		"2001:db8:122:300::/56": "2001:db8:122:3c0:0:221::",
+		"2001:db8:122:344::/64": "2001:db8:122:344:c0:2:2100:0",
+		"2001:db8:122:344::/96": "2001:db8:122:344::c000:221",
+		"64:ff9b::/96":          "64:ff9b::c000:221",
+	}
+	for s, expected := range tests {
+		prefix, err := parseNAT64Prefix(s)
+		if err != nil {
+			t.Errorf("%s: %v", s, err)
+			continue
+		}
+		if ip := embedIPv4(prefix, net.ParseIP("192.0.2.33")); !ip.Equal(net.ParseIP(expected)) {
+			t.Errorf("%s: got %s, expected %s", s, ip, expected)
+		}
+	}
+	for _, bad := range []string{"64:ff9b::/80", "10.0.0.0/8", "64:ff9b::"} {
+		if _, err := parseNAT64Prefix(bad); err == nil {
+			t.Errorf("%s was accepted", bad)
+		}
+	}
+}
+
+func TestNAT64CheckAddress(t *testing.T) {
+	defer func() { nat64Prefix = nil }()
+	nat64Prefix, _ = parseNAT64Prefix("64:ff9b::/96")
+	address, _, err := filter.checkAddress(context.Background(), "udp", "192.0.2.33:53")
+	if err != nil || address != "[64:ff9b::c000:221]:53" {
+		t.Errorf("got %s (%v)", address, err)
+	}
+	if !isNAT64Address(net.ParseIP("64:ff9b::c000:221")) || isNAT64Address(net.ParseIP("2001:db8::1")) {
+		t.Error("wrong NAT64 address check")
+	}
+	address, _, err = filter.checkAddress(context.Background(), "udp", "[fe80::1%eth0]:53")
+	if err != nil || address != "[fe80::1%eth0]:53" {
+		t.Errorf("got %s (%v)", address, err)
+	}
+}
+
+func TestSplitZone(t *testing.T) {
+	tests := [][3]string{
+		{"fe80::1%eth0", "fe80::1", "eth0"},
+		{"fe80::1", "fe80::1", ""},
+		{"10.0.0.1%eth0", "10.0.0.1%eth0", ""},
+		{"example.com", "example.com", ""},
+		{"fe80::1%", "fe80::1%", ""},
+	}
+	for _, test := range tests {
+		if addr, zone := splitZone(test[0]); addr != test[1] || zone != test[2] {
+			t.Errorf("%s: got %s and %s", test[0], addr, zone)
+		}
+	}
+	target := ScanTarget{IP: net.ParseIP("fe80::1"), Zone: "eth0"}
+	if host := target.Host(); host != "fe80::1%eth0" {
+		t.Errorf("got host %s", host)
+	}
+}
@@ -0,0 +1,266 @@
+package zgrab2
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// notifyTimeout bounds each post to --notify-url, and how long the end of the
+// scan waits for the last notifications to be delivered.
+const notifyTimeout = 10 * time.Second
+
+// notifyMinScans is the number of scans an interval must have for its error
+// rate to raise an alarm, so that a handful of failures do not.
+const notifyMinScans = 20
+
+// notifyQueueSize is the number of notifications that can be waiting to be
+// posted; more are dropped.
+const notifyQueueSize = 16
+
+// notifyEvent is the JSON payload posted to --notify-url for each event.
+type notifyEvent struct {
+	// Event is "start", "progress", "error-rate", "error-rate-recovered" or
+	// "complete".
+	Event string `json:"event"`
+
+	// Host is the name of the machine running the scan.
+	Host string `json:"host"`
+
+	// Text describes the event, for people.
+	Text string `json:"text"`
+
+	// Percent is the milestone reached, for progress events.
+	Percent float64 `json:"percent,omitempty"`
+
+	// ErrorRate is the fraction of scans in the last interval that failed,
+	// for error-rate events.
+	ErrorRate float64 `json:"error_rate,omitempty"`
+
+	Progress    *Progress     `json:"progress"`
+	Interrupted *Interruption `json:"interrupted,omitempty"`
+}
+
+// notifier posts scan lifecycle events to a webhook (e.g. a Slack incoming
+// webhook), so that long scans can be supervised remotely.
+type notifier struct {
+	url      string
+	slack    bool
+	host     string
+	interval time.Duration
+
+	// milestones are the percentages of the input to notify at, ascending.
+	milestones []float64
+
+	// errorRate is the fraction of failed scans in an interval above which
+	// an alarm is raised, and errorStatuses are the statuses counted as
+	// failures.
+	errorRate     float64
+	errorStatuses map[ScanStatus]bool
+
+	// snapshot returns the current progress.
+	snapshot func() *Progress
+
+	client *http.Client
+	events chan *notifyEvent
+	sent   chan struct{}
+	done   chan struct{}
+
+	nextMilestone int
+	lastStatuses  map[ScanStatus]uint64
+	alarmed       bool
+}
+
+// notifications is the notifier configured with --notify-url, if any.
+var notifications *notifier
+
+// newNotifier returns a notifier configured from the command line, or nil if
+// no --notify-url was given.
+func newNotifier() (*notifier, error) {
+	if config.NotifyURL == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(config.NotifyURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("invalid notify URL %q", config.NotifyURL)
+	}
+	if config.NotifyInterval <= 0 {
+		return nil, fmt.Errorf("notify interval must be positive, given %s", config.NotifyInterval)
+	}
+	if config.NotifyErrorRate < 0 || config.NotifyErrorRate > 1 {
+		return nil, fmt.Errorf("notify error rate must be from 0 to 1, given %v", config.NotifyErrorRate)
+	}
+	ret := &notifier{
+		url:           config.NotifyURL,
+		slack:         config.NotifyFormat == "slack" || (config.NotifyFormat == "auto" && strings.HasSuffix(u.Hostname(), "slack.com")),
+		interval:      config.NotifyInterval,
+		errorRate:     config.NotifyErrorRate,
+		errorStatuses: make(map[ScanStatus]bool),
+		snapshot:      progress.snapshot,
+		client:        &http.Client{Timeout: notifyTimeout},
+		events:        make(chan *notifyEvent, notifyQueueSize),
+		sent:          make(chan struct{}),
+		done:          make(chan struct{}),
+		lastStatuses:  make(map[ScanStatus]uint64),
+	}
+	if ret.host, err = os.Hostname(); err != nil {
+		ret.host = "unknown host"
+	}
+	if config.NotifyMilestones != "" {
+		for _, field := range strings.Split(config.NotifyMilestones, ",") {
+			percent, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+			if err != nil || percent <= 0 || percent >= 100 {
+				return nil, fmt.Errorf("invalid notify milestone %q: must be a percentage between 0 and 100", field)
+			}
+			ret.milestones = append(ret.milestones, percent)
+		}
+		sort.Float64s(ret.milestones)
+	}
+	for _, status := range strings.Split(config.NotifyErrorStatuses, ",") {
+		if status = strings.TrimSpace(status); status != "" {
+			ret.errorStatuses[ScanStatus(status)] = true
+		}
+	}
+	return ret, nil
+}
+
+// start posts the start event, and starts checking progress every interval
+// until finish is called.
+func (n *notifier) start() {
+	go n.post()
+	n.notify(&notifyEvent{Event: "start", Text: "scan started"})
+	go n.run()
+}
+
+// run checks for milestones and error rate alarms every interval.
+func (n *notifier) run() {
+	ticker := time.NewTicker(n.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-n.done:
+			return
+		case <-ticker.C:
+			n.check(n.snapshot())
+		}
+	}
+}
+
+// check posts any events due given the current progress.
+func (n *notifier) check(p *Progress) {
+	// Only the highest of several milestones passed since the last check is
+	// posted.
+	var percent float64
+	for n.nextMilestone < len(n.milestones) && p.InputFraction*100 >= n.milestones[n.nextMilestone] {
+		percent = n.milestones[n.nextMilestone]
+		n.nextMilestone++
+	}
+	if percent > 0 {
+		text := fmt.Sprintf("%g%% of the input read, %d targets completed", percent, p.TargetsCompleted)
+		if p.ETA != "" {
+			text += ", about " + p.ETA + " remaining"
+		}
+		n.notify(&notifyEvent{Event: "progress", Percent: percent, Text: text, Progress: p})
+	}
+
+	var total, failed uint64
+	var failures []string
+	for status, count := range p.Statuses {
+		delta := count - n.lastStatuses[status]
+		total += delta
+		if n.errorStatuses[status] && delta > 0 {
+			failed += delta
+			failures = append(failures, fmt.Sprintf("%s: %d", status, delta))
+		}
+		n.lastStatuses[status] = count
+	}
+	if n.errorRate <= 0 || total < notifyMinScans {
+		return
+	}
+	rate := float64(failed) / float64(total)
+	switch {
+	case rate > n.errorRate && !n.alarmed:
+		n.alarmed = true
+		sort.Strings(failures)
+		n.notify(&notifyEvent{
+			Event:     "error-rate",
+			ErrorRate: rate,
+			Text:      fmt.Sprintf("%.0f%% of the last %d scans failed (%s)", rate*100, total, strings.Join(failures, ", ")),
+			Progress:  p,
+		})
+	case rate <= n.errorRate && n.alarmed:
+		n.alarmed = false
+		n.notify(&notifyEvent{
+			Event:     "error-rate-recovered",
+			ErrorRate: rate,
+			Text:      fmt.Sprintf("error rate back down to %.0f%% of the last %d scans", rate*100, total),
+			Progress:  p,
+		})
+	}
+}
+
+// finish stops checking progress, posts the complete event, and waits for
+// the notifications to be delivered.
+func (n *notifier) finish() {
+	close(n.done)
+	p := n.snapshot()
+	event := &notifyEvent{Event: "complete", Progress: p, Interrupted: GetInterruption()}
+	event.Text = fmt.Sprintf("scan completed in %s: %d targets", p.Elapsed, p.TargetsCompleted)
+	if event.Interrupted != nil {
+		event.Text = fmt.Sprintf("scan interrupted by %s after %s: %d targets", event.Interrupted.Signal, p.Elapsed, p.TargetsCompleted)
+	}
+	n.notify(event)
+	close(n.events)
+	select {
+	case <-n.sent:
+	case <-time.After(notifyTimeout):
+		log.Warnf("could not deliver notifications to %s within %s", n.url, notifyTimeout)
+	}
+}
+
+// notify queues an event to be posted, dropping it if too many are waiting.
+func (n *notifier) notify(event *notifyEvent) {
+	event.Host = n.host
+	if event.Progress == nil {
+		event.Progress = n.snapshot()
+	}
+	select {
+	case n.events <- event:
+	default:
+		log.Warnf("dropping %s notification: too many waiting to be posted", event.Event)
+	}
+}
+
+// post delivers the queued events in order.
+func (n *notifier) post() {
+	defer close(n.sent)
+	for event := range n.events {
+		var payload interface{} = event
+		if n.slack {
+			payload = map[string]string{"text": "zgrab2 on " + n.host + ": " + event.Text}
+		}
+		body, err := json.Marshal(payload)
+		if err != nil {
+			log.Warnf("could not encode %s notification: %v", event.Event, err)
+			continue
+		}
+		resp, err := n.client.Post(n.url, "application/json", bytes.NewReader(body))
+		if err != nil {
+			log.Warnf("could not post %s notification: %v", event.Event, err)
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			log.Warnf("could not post %s notification: %s", event.Event, resp.Status)
+		}
+	}
+}
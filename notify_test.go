@@ -0,0 +1,138 @@
+package zgrab2
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// notifyTestServer records the bodies posted to it.
+type notifyTestServer struct {
+	*httptest.Server
+	mutex  sync.Mutex
+	bodies []string
+}
+
+func newNotifyTestServer() *notifyTestServer {
+	ret := new(notifyTestServer)
+	ret.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		ret.mutex.Lock()
+		ret.bodies = append(ret.bodies, string(body))
+		ret.mutex.Unlock()
+	}))
+	return ret
+}
+
+// withNotifyConfig returns a notifier configured to post to url, and a
+// function restoring the configuration.
+func withNotifyConfig(t *testing.T, url, format string) (*notifier, func()) {
+	saved := config
+	restore := func() { config = saved }
+	config.NotifyURL = url
+	config.NotifyFormat = format
+	config.NotifyMilestones = "75,25,50"
+	config.NotifyErrorRate = 0.5
+	config.NotifyErrorStatuses = "unknown-error,io-timeout"
+	config.NotifyInterval = notifyTimeout
+	n, err := newNotifier()
+	if err != nil {
+		restore()
+		t.Fatal(err)
+	}
+	return n, restore
+}
+
+func TestNotifier(t *testing.T) {
+	server := newNotifyTestServer()
+	defer server.Close()
+	n, restore := withNotifyConfig(t, server.URL, "auto")
+	defer restore()
+	if n.slack {
+		t.Error("expected JSON notifications")
+	}
+	current := &Progress{Statuses: map[ScanStatus]uint64{}}
+	n.snapshot = func() *Progress { return current }
+	n.start()
+
+	// Passing the 25% and 50% milestones at once posts only the latter.
+	current = &Progress{InputFraction: 0.6, TargetsCompleted: 100, Statuses: map[ScanStatus]uint64{SCAN_SUCCESS: 90, SCAN_IO_TIMEOUT: 10}}
+	n.check(current)
+	current = &Progress{InputFraction: 0.7, TargetsCompleted: 200, Statuses: map[ScanStatus]uint64{SCAN_SUCCESS: 100, SCAN_IO_TIMEOUT: 100}}
+	n.check(current)
+	current = &Progress{InputFraction: 0.8, TargetsCompleted: 300, Statuses: map[ScanStatus]uint64{SCAN_SUCCESS: 195, SCAN_IO_TIMEOUT: 105}}
+	n.check(current)
+	n.finish()
+
+	var events []notifyEvent
+	for _, body := range server.bodies {
+		var event notifyEvent
+		if err := json.Unmarshal([]byte(body), &event); err != nil {
+			t.Fatalf("invalid notification %s: %v", body, err)
+		}
+		events = append(events, event)
+	}
+	expected := []string{"start", "progress", "error-rate", "progress", "error-rate-recovered", "complete"}
+	if len(events) != len(expected) {
+		t.Fatalf("expected events %v, got %s", expected, server.bodies)
+	}
+	for i, event := range events {
+		if event.Event != expected[i] {
+			t.Errorf("event %d: expected %s, got %s", i, expected[i], event.Event)
+		}
+	}
+	if events[1].Percent != 50 || events[3].Percent != 75 {
+		t.Errorf("wrong milestones: %v, %v", events[1].Percent, events[3].Percent)
+	}
+	if events[2].ErrorRate != 0.9 || !strings.Contains(events[2].Text, "io-timeout: 90") {
+		t.Errorf("wrong error rate alarm: %+v", events[2])
+	}
+	if events[5].Progress == nil || events[5].Progress.TargetsCompleted != 300 {
+		t.Errorf("wrong completion: %+v", events[5])
+	}
+}
+
+func TestNotifierSlack(t *testing.T) {
+	server := newNotifyTestServer()
+	defer server.Close()
+	n, restore := withNotifyConfig(t, server.URL, "slack")
+	defer restore()
+	n.snapshot = func() *Progress { return &Progress{Elapsed: "1s", TargetsCompleted: 3} }
+	n.start()
+	n.finish()
+	if len(server.bodies) != 2 {
+		t.Fatalf("expected 2 notifications, got %v", server.bodies)
+	}
+	var payload map[string]string
+	if err := json.Unmarshal([]byte(server.bodies[1]), &payload); err != nil {
+		t.Fatal(err)
+	}
+	if len(payload) != 1 || !strings.Contains(payload["text"], "scan completed in 1s: 3 targets") {
+		t.Errorf("wrong Slack payload %s", server.bodies[1])
+	}
+}
+
+func TestNewNotifierValidation(t *testing.T) {
+	saved := config
+	defer func() { config = saved }()
+	config.NotifyFormat = "auto"
+	config.NotifyInterval = notifyTimeout
+	for _, test := range []struct{ url, milestones string }{
+		{"ftp://example.com/hook", ""},
+		{"https://hooks.slack.com/services/x", "0"},
+		{"https://hooks.slack.com/services/x", "50,abc"},
+	} {
+		config.NotifyURL, config.NotifyMilestones = test.url, test.milestones
+		if _, err := newNotifier(); err == nil {
+			t.Errorf("%+v: expected an error", test)
+		}
+	}
+	config.NotifyURL, config.NotifyMilestones = "https://hooks.slack.com/services/x", ""
+	if n, err := newNotifier(); err != nil || !n.slack {
+		t.Errorf("expected Slack notifications for a Slack URL (%v)", err)
+	}
+}
@@ -0,0 +1,138 @@
+package zgrab2
+
+import "strings"
+
+// NTLMInfo holds the names and operating system version a Windows service
+// gives in its NTLM challenge.
+type NTLMInfo struct {
+	// TargetName is the challenge's target name: usually the NetBIOS name
+	// of the server's domain, or of the server if it is not in one.
+	TargetName string `json:"target_name,omitempty"`
+
+	NetBIOSComputerName string `json:"netbios_computer_name,omitempty"`
+	NetBIOSDomainName   string `json:"netbios_domain_name,omitempty"`
+	DNSComputerName     string `json:"dns_computer_name,omitempty"`
+	DNSDomainName       string `json:"dns_domain_name,omitempty"`
+	DNSTreeName         string `json:"dns_tree_name,omitempty"`
+
+	// OSVersion is the version in the challenge, as MAJOR.MINOR.BUILD.
+	OSVersion string `json:"os_version,omitempty"`
+}
+
+// ntlmFieldNames are the JSON names of the fields of NTLMInfo, in the order
+// of fields.
+var ntlmFieldNames = []string{
+	"target_name",
+	"netbios_computer_name",
+	"netbios_domain_name",
+	"dns_computer_name",
+	"dns_domain_name",
+	"dns_tree_name",
+	"os_version",
+}
+
+func (info *NTLMInfo) fields() []*string {
+	return []*string{
+		&info.TargetName,
+		&info.NetBIOSComputerName,
+		&info.NetBIOSDomainName,
+		&info.DNSComputerName,
+		&info.DNSDomainName,
+		&info.DNSTreeName,
+		&info.OSVersion,
+	}
+}
+
+// NTLMScanner is implemented by scanners whose results can include an NTLM
+// challenge, e.g. for SMB, RDP or WinRM. The framework merges the NTLM info
+// from all of a target's scans into the grab's ntlm block.
+type NTLMScanner interface {
+	Scanner
+
+	// NTLMInfo returns the NTLM info from the result of a scan (which may
+	// have failed part way), or nil if the result does not include it.
+	NTLMInfo(result interface{}) *NTLMInfo
+}
+
+// GetNTLMInfo returns the NTLM info from the result, if the scanner is an
+// NTLMScanner.
+func GetNTLMInfo(s Scanner, result interface{}) *NTLMInfo {
+	n, ok := s.(NTLMScanner)
+	if !ok || result == nil {
+		return nil
+	}
+	return n.NTLMInfo(result)
+}
+
+// NTLMCorrelation merges the NTLM info given by each of a target's services.
+// Services on one host give the same names, so different names suggest that
+// the address forwards to several hosts, through NAT or a load balancer.
+type NTLMCorrelation struct {
+	// NTLMInfo has each name given by any service; where they differ, the
+	// one given by the first.
+	NTLMInfo
+
+	// Sources are the names of the scans that gave NTLM info, in order.
+	Sources []string `json:"sources"`
+
+	// Inconsistent is true if the services gave different values for any
+	// field.
+	Inconsistent bool `json:"inconsistent,omitempty"`
+
+	// Conflicts lists the fields whose values differ.
+	Conflicts []NTLMConflict `json:"conflicts,omitempty"`
+}
+
+// NTLMConflict is a field of NTLMInfo for which services gave different
+// values.
+type NTLMConflict struct {
+	// Field is the field's JSON name, e.g. dns_computer_name.
+	Field string `json:"field"`
+
+	// Values maps the name of each scan that gave the field to its value.
+	Values map[string]string `json:"values"`
+}
+
+// CorrelateNTLM merges the NTLM info given by the named scans, or returns
+// nil if there is none. Names are compared ignoring case, as NetBIOS and DNS
+// names are case-insensitive. Fields a service did not give do not conflict.
+func CorrelateNTLM(names []string, infos []*NTLMInfo) *NTLMCorrelation {
+	var ret *NTLMCorrelation
+	for i, info := range infos {
+		if info == nil {
+			continue
+		}
+		if ret == nil {
+			ret = new(NTLMCorrelation)
+		}
+		ret.Sources = append(ret.Sources, names[i])
+	}
+	if ret == nil {
+		return nil
+	}
+	merged := ret.NTLMInfo.fields()
+	for field, name := range ntlmFieldNames {
+		values := make(map[string]string)
+		conflict := false
+		for i, info := range infos {
+			if info == nil {
+				continue
+			}
+			value := *info.fields()[field]
+			if value == "" {
+				continue
+			}
+			values[names[i]] = value
+			if *merged[field] == "" {
+				*merged[field] = value
+			} else if !strings.EqualFold(*merged[field], value) {
+				conflict = true
+			}
+		}
+		if conflict {
+			ret.Inconsistent = true
+			ret.Conflicts = append(ret.Conflicts, NTLMConflict{Field: name, Values: values})
+		}
+	}
+	return ret
+}
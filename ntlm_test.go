@@ -0,0 +1,42 @@
+package zgrab2
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCorrelateNTLM(t *testing.T) {
+	if ret := CorrelateNTLM([]string{"smb"}, []*NTLMInfo{nil}); ret != nil {
+		t.Errorf("expected nil, got %+v", ret)
+	}
+
+	smb := &NTLMInfo{TargetName: "CORP", NetBIOSComputerName: "FS01", DNSComputerName: "fs01.corp.example.com", OSVersion: "10.0.17763"}
+	smb139 := &NTLMInfo{TargetName: "corp", NetBIOSComputerName: "FS01", DNSDomainName: "corp.example.com"}
+	ret := CorrelateNTLM([]string{"smb", "http", "smb139"}, []*NTLMInfo{smb, nil, smb139})
+	expected := &NTLMCorrelation{
+		NTLMInfo: NTLMInfo{
+			TargetName:          "CORP",
+			NetBIOSComputerName: "FS01",
+			DNSComputerName:     "fs01.corp.example.com",
+			DNSDomainName:       "corp.example.com",
+			OSVersion:           "10.0.17763",
+		},
+		Sources: []string{"smb", "smb139"},
+	}
+	if !reflect.DeepEqual(ret, expected) {
+		t.Errorf("got %+v, expected %+v", ret, expected)
+	}
+
+	rdp := &NTLMInfo{TargetName: "CORP", NetBIOSComputerName: "FS02", DNSComputerName: "fs02.corp.example.com"}
+	ret = CorrelateNTLM([]string{"smb", "rdp"}, []*NTLMInfo{smb, rdp})
+	if !ret.Inconsistent || ret.NetBIOSComputerName != "FS01" {
+		t.Fatalf("wrong correlation %+v", ret)
+	}
+	conflicts := []NTLMConflict{
+		{Field: "netbios_computer_name", Values: map[string]string{"smb": "FS01", "rdp": "FS02"}},
+		{Field: "dns_computer_name", Values: map[string]string{"smb": "fs01.corp.example.com", "rdp": "fs02.corp.example.com"}},
+	}
+	if !reflect.DeepEqual(ret.Conflicts, conflicts) {
+		t.Errorf("got conflicts %+v, expected %+v", ret.Conflicts, conflicts)
+	}
+}
@@ -2,7 +2,10 @@ package zgrab2
 
 import (
 	"bufio"
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"sync"
 )
 
 // FlagMap is a function that maps a single-bit bitmask (i.e. a number of the
@@ -130,9 +133,53 @@ func WidenMapKeys(input map[int]string) map[uint64]string {
 //
 // A function of this type receives results on the provided channel
 // and outputs them somehow.  It returns nil if there are no further
-// results or error.
+// results or error. It may pass each result to ReleaseResult once it is
+// done with it.
 type OutputResultsFunc func(results <-chan []byte) error
 
+// maxPooledResultSize is the capacity above which result buffers are not
+// reused, so that one huge result does not pin its buffer indefinitely.
+const maxPooledResultSize = 1 << 20
+
+// resultBuffers holds the buffers that results are encoded into, so that at
+// high result rates they are reused instead of being allocated (and
+// collected) for each target.
+var resultBuffers = sync.Pool{
+	New: func() interface{} {
+		return new(bytes.Buffer)
+	},
+}
+
+// encodeResultLines streams the JSON encodings of values, one per line and
+// without a trailing newline, into a buffer from the pool.
+func encodeResultLines(values ...interface{}) ([]byte, error) {
+	buf := resultBuffers.Get().(*bytes.Buffer)
+	buf.Reset()
+	encoder := json.NewEncoder(buf)
+	for _, v := range values {
+		if err := encoder.Encode(v); err != nil {
+			resultBuffers.Put(buf)
+			return nil, err
+		}
+	}
+	if buf.Len() == 0 {
+		resultBuffers.Put(buf)
+		return nil, nil
+	}
+	// Encode always terminates each value with a newline.
+	return buf.Bytes()[:buf.Len()-1], nil
+}
+
+// ReleaseResult returns the buffer holding a result received by an
+// OutputResultsFunc to the pool, to be reused for later results. The result
+// must not be used afterwards.
+func ReleaseResult(result []byte) {
+	if cap(result) > maxPooledResultSize {
+		return
+	}
+	resultBuffers.Put(bytes.NewBuffer(result[:0]))
+}
+
 // OutputResultsFile is an OutputResultsFunc that write results to
 // a filename provided on the command line.
 func OutputResultsFile(results <-chan []byte) error {
@@ -145,6 +192,7 @@ func OutputResultsFile(results <-chan []byte) error {
 		if err := out.WriteByte('\n'); err != nil {
 			return err
 		}
+		ReleaseResult(result)
 	}
 	return nil
 }
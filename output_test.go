@@ -1,7 +1,12 @@
 package zgrab2
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"testing"
+
+	"github.com/zmap/zgrab2/lib/output"
 )
 
 func ExampleMapFlagsToSet_success() {
@@ -75,3 +80,142 @@ func ExampleListFlagsToSet() {
 	// bit0: true
 	// Unknown: 0x4
 }
+
+// benchResult is a module result with a debug field and a large part that
+// cannot contain debug fields, like a TLS handshake log.
+type benchResult struct {
+	Banner       string             `json:"banner"`
+	Raw          []byte             `json:"raw,omitempty" zgrab:"debug"`
+	Dump         []byte             `json:"dump,omitempty" zgrab:"raw"`
+	Headers      map[string]string  `json:"headers"`
+	Certificates []benchCertificate `json:"certificates"`
+}
+
+type benchCertificate struct {
+	Subject   []string `json:"subject"`
+	Issuer    []string `json:"issuer"`
+	DER       []byte   `json:"der"`
+	NotBefore string   `json:"not_before"`
+	NotAfter  string   `json:"not_after"`
+}
+
+func benchGrab() *Grab {
+	result := &benchResult{
+		Banner:  "HTTP/1.1 200 OK",
+		Raw:     make([]byte, 4096),
+		Dump:    []byte("HTTP/1.1 200 OK\r\n"),
+		Headers: map[string]string{"server": "nginx", "content-type": "text/html"},
+	}
+	for i := 0; i < 3; i++ {
+		result.Certificates = append(result.Certificates, benchCertificate{
+			Subject:   []string{"CN=example.com", "O=Example"},
+			Issuer:    []string{"CN=Example CA", "O=Example"},
+			DER:       make([]byte, 1500),
+			NotBefore: "2020-01-01T00:00:00Z",
+			NotAfter:  "2030-01-01T00:00:00Z",
+		})
+	}
+	return &Grab{
+		IP: "192.0.2.1",
+		Data: map[string]ScanResponse{
+			"http": {Status: SCAN_SUCCESS, Protocol: "http", Result: result, Timing: &Timing{Total: 1000}},
+		},
+	}
+}
+
+// encodeGrabCopying is the encoding of a grab without sharing the parts that
+// cannot contain debug fields, or reusing buffers: the callback forces every
+// value to be copied.
+func encodeGrabCopying(raw *Grab) ([]byte, error) {
+	processor := output.Processor{Callback: output.NullProcessCallback}
+	stripped, err := processor.Process(raw)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(stripped)
+}
+
+func TestEncodeGrab(t *testing.T) {
+	grab := benchGrab()
+	expected, err := encodeGrabCopying(grab)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		result, err := encodeGrab(grab)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(result, expected) {
+			t.Fatalf("expected %s, got %s", expected, result)
+		}
+		ReleaseResult(result)
+	}
+	if raw := grab.Data["http"].Result.(*benchResult).Raw; len(raw) != 4096 {
+		t.Error("stripping debug fields modified the grab")
+	}
+}
+
+func TestEncodeGrabOmitFields(t *testing.T) {
+	defer func(debug, omitDebug, omitRaw bool) {
+		config.Debug, config.OmitDebugFields, config.OmitRaw = debug, omitDebug, omitRaw
+	}(config.Debug, config.OmitDebugFields, config.OmitRaw)
+	tests := []struct {
+		debug, omitDebug, omitRaw bool
+		hasRaw, hasDump           bool
+	}{
+		{false, false, false, false, true},
+		{true, false, false, true, true},
+		{true, true, false, false, true},
+		{true, false, true, true, false},
+		{false, false, true, false, false},
+	}
+	for _, test := range tests {
+		config.Debug, config.OmitDebugFields, config.OmitRaw = test.debug, test.omitDebug, test.omitRaw
+		result, err := encodeGrab(benchGrab())
+		if err != nil {
+			t.Fatal(err)
+		}
+		hasRaw := bytes.Contains(result, []byte(`"raw":`))
+		hasDump := bytes.Contains(result, []byte(`"dump":`))
+		if hasRaw != test.hasRaw || hasDump != test.hasDump {
+			t.Errorf("debug=%v omit-debug-fields=%v omit-raw=%v: expected raw %v, dump %v; got %s", test.debug, test.omitDebug, test.omitRaw, test.hasRaw, test.hasDump, result)
+		}
+	}
+}
+
+func BenchmarkEncodeGrab(b *testing.B) {
+	grab := benchGrab()
+	b.Run("copying", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := encodeGrabCopying(grab); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("streaming", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			result, err := encodeGrab(grab)
+			if err != nil {
+				b.Fatal(err)
+			}
+			ReleaseResult(result)
+		}
+	})
+}
+
+func BenchmarkEncodeGrabParallel(b *testing.B) {
+	grab := benchGrab()
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			result, err := encodeGrab(grab)
+			if err != nil {
+				b.Fatal(err)
+			}
+			ReleaseResult(result)
+		}
+	})
+}
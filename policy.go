@@ -0,0 +1,183 @@
+package zgrab2
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Classes of probe that are disabled unless the policy allows them.
+const (
+	// ProbeAuthenticating probes present credentials to the target, or
+	// attempt to authenticate.
+	ProbeAuthenticating = "authenticating"
+
+	// ProbeStateChanging probes may change state on the target (e.g. HTTP
+	// requests with methods other than GET, HEAD and OPTIONS).
+	ProbeStateChanging = "state-changing"
+)
+
+// ClassifiedScanner is implemented by scanners that, as configured, send
+// probes in any of the classes that the measurement policy disables by
+// default. Such scanners may only be run if the policy (--policy-file)
+// allows each of the classes they return.
+type ClassifiedScanner interface {
+	Scanner
+
+	// ProbeClasses returns the classes of the probes the scanner sends, e.g.
+	// ProbeAuthenticating; nil if it only reads what targets offer
+	// anonymously.
+	ProbeClasses() []string
+}
+
+// measurementPolicy holds the guardrails read from --policy-file: the probe
+// classes allowed, overall or per protocol, and per-protocol rate ceilings,
+// which apply however the scan itself is configured.
+type measurementPolicy struct {
+	mutex sync.Mutex
+
+	// allowed is the set of probe classes allowed for all protocols.
+	allowed map[string]bool
+
+	// allowedFor maps a protocol to the probe classes allowed for it.
+	allowedFor map[string]map[string]bool
+
+	// rates maps a protocol to the maximum number of scans per second.
+	rates map[string]int
+
+	// next maps a protocol to the time its next scan may start.
+	next map[string]time.Time
+}
+
+var policy = newMeasurementPolicy()
+
+func newMeasurementPolicy() *measurementPolicy {
+	return &measurementPolicy{
+		allowed:    make(map[string]bool),
+		allowedFor: make(map[string]map[string]bool),
+		rates:      make(map[string]int),
+		next:       make(map[string]time.Time),
+	}
+}
+
+// parseProbeClasses parses a comma-separated list of probe classes.
+func parseProbeClasses(s string) (map[string]bool, error) {
+	ret := make(map[string]bool)
+	for _, class := range strings.Split(s, ",") {
+		class = strings.TrimSpace(class)
+		switch class {
+		case "", "none":
+		case ProbeAuthenticating, ProbeStateChanging:
+			ret[class] = true
+		default:
+			return nil, fmt.Errorf("unknown probe class %q", class)
+		}
+	}
+	return ret, nil
+}
+
+// applyLine parses a policy line, one of
+//
+//	allow = <classes>
+//	allow.<protocol> = <classes>
+//	rate.<protocol> = <scans per second>
+//
+// where <classes> is a comma-separated list of probe classes. Empty lines
+// and # comments are ignored.
+func (p *measurementPolicy) applyLine(line string) error {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return nil
+	}
+	i := strings.IndexByte(line, '=')
+	if i < 0 {
+		return fmt.Errorf("expected 'name = value', got %q", line)
+	}
+	name, value := strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:])
+	switch {
+	case name == "allow":
+		classes, err := parseProbeClasses(value)
+		if err != nil {
+			return err
+		}
+		p.allowed = classes
+	case strings.HasPrefix(name, "allow."):
+		classes, err := parseProbeClasses(value)
+		if err != nil {
+			return err
+		}
+		p.allowedFor[strings.TrimPrefix(name, "allow.")] = classes
+	case strings.HasPrefix(name, "rate."):
+		rate, err := strconv.Atoi(value)
+		if err != nil || rate < 0 {
+			return fmt.Errorf("invalid value for %s: %q", name, value)
+		}
+		p.rates[strings.TrimPrefix(name, "rate.")] = rate
+	default:
+		return fmt.Errorf("unknown policy setting %q", name)
+	}
+	return nil
+}
+
+// loadPolicyFile reads the policy from the given file. Unlike the limits
+// file, any invalid line is an error, so that a policy is never partially
+// applied.
+func loadPolicyFile(fileName string) (*measurementPolicy, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	ret := newMeasurementPolicy()
+	scanner := bufio.NewScanner(file)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		if err := ret.applyLine(scanner.Text()); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", fileName, lineNo, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// permit returns an error if the scanner sends probes of a class that the
+// policy does not allow for its protocol.
+func (p *measurementPolicy) permit(s Scanner) error {
+	classified, ok := s.(ClassifiedScanner)
+	if !ok {
+		return nil
+	}
+	protocol := s.Protocol()
+	for _, class := range classified.ProbeClasses() {
+		if !p.allowed[class] && !p.allowedFor[protocol][class] {
+			return fmt.Errorf("%s: %s probes are disabled by the measurement policy (allow them with 'allow.%s = %s' in --policy-file)", s.GetName(), class, protocol, class)
+		}
+	}
+	return nil
+}
+
+// wait blocks until the next scan of the protocol may start under its rate
+// ceiling, if it has one.
+func (p *measurementPolicy) wait(protocol string) {
+	p.mutex.Lock()
+	rate := p.rates[protocol]
+	if rate <= 0 {
+		p.mutex.Unlock()
+		return
+	}
+	now := time.Now()
+	slot := now
+	if next := p.next[protocol]; next.After(slot) {
+		slot = next
+	}
+	p.next[protocol] = slot.Add(time.Second / time.Duration(rate))
+	p.mutex.Unlock()
+	if delay := slot.Sub(now); delay > 0 {
+		time.Sleep(delay)
+	}
+}
@@ -0,0 +1,70 @@
+package zgrab2
+
+import (
+	"testing"
+	"time"
+)
+
+// authScanner is an echoScanner that authenticates.
+type authScanner struct {
+	echoScanner
+}
+
+func (s *authScanner) Protocol() string       { return "auth" }
+func (s *authScanner) ProbeClasses() []string { return []string{ProbeAuthenticating} }
+
+func TestPolicyPermit(t *testing.T) {
+	p := newMeasurementPolicy()
+	s := &authScanner{echoScanner{name: "auth"}}
+	if err := p.permit(s); err == nil {
+		t.Errorf("expected authenticating probes to be disabled by default")
+	}
+	if err := p.permit(&echoScanner{name: "echo"}); err != nil {
+		t.Errorf("unclassified scanner was refused: %v", err)
+	}
+	if err := p.applyLine("allow.auth = authenticating"); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.permit(s); err != nil {
+		t.Errorf("expected authenticating probes to be allowed for auth: %v", err)
+	}
+
+	p = newMeasurementPolicy()
+	if err := p.applyLine("allow = state-changing, authenticating"); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.permit(s); err != nil {
+		t.Errorf("expected authenticating probes to be allowed: %v", err)
+	}
+}
+
+func TestPolicyLines(t *testing.T) {
+	p := newMeasurementPolicy()
+	for _, line := range []string{"# comment", "", "rate.http = 10", "allow = none"} {
+		if err := p.applyLine(line); err != nil {
+			t.Errorf("%q: %v", line, err)
+		}
+	}
+	if p.rates["http"] != 10 {
+		t.Errorf("expected rate.http to be 10, got %d", p.rates["http"])
+	}
+	for _, line := range []string{"rate.http = fast", "allow = everything", "senders = 10", "allow"} {
+		if err := p.applyLine(line); err == nil {
+			t.Errorf("expected an error for %q", line)
+		}
+	}
+}
+
+func TestPolicyRate(t *testing.T) {
+	p := newMeasurementPolicy()
+	p.applyLine("rate.echo = 20")
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		p.wait("echo")
+		p.wait("other")
+	}
+	// Three scans at 20 per second take at least two intervals of 50ms.
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Errorf("expected the rate ceiling to space out scans, took %v", elapsed)
+	}
+}
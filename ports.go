@@ -0,0 +1,120 @@
+package zgrab2
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// DefaultPort is one of the ports a module scans by default.
+type DefaultPort struct {
+	Port uint
+
+	// TLS is true if the port is conventionally used with TLS, e.g. 443 for
+	// HTTP.
+	TLS bool
+}
+
+func (p DefaultPort) String() string {
+	if p.TLS {
+		return fmt.Sprintf("%d/tls", p.Port)
+	}
+	return strconv.FormatUint(uint64(p.Port), 10)
+}
+
+// ParseDefaultPorts parses a comma-separated list of ports, each optionally
+// followed by /tls, e.g. "80,8080,443/tls".
+func ParseDefaultPorts(s string) ([]DefaultPort, error) {
+	var ret []DefaultPort
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		var port DefaultPort
+		if strings.HasSuffix(entry, "/tls") {
+			port.TLS = true
+			entry = strings.TrimSuffix(entry, "/tls")
+		}
+		n, err := strconv.ParseUint(entry, 10, 16)
+		if err != nil || n == 0 {
+			return nil, fmt.Errorf("invalid port %q", entry)
+		}
+		port.Port = uint(n)
+		ret = append(ret, port)
+	}
+	if len(ret) == 0 {
+		return nil, fmt.Errorf("no ports given")
+	}
+	return ret, nil
+}
+
+func formatDefaultPorts(ports []DefaultPort) string {
+	strs := make([]string, len(ports))
+	for i, port := range ports {
+		strs[i] = port.String()
+	}
+	return strings.Join(strs, ",")
+}
+
+// ScanPorts returns the ports to scan on targets that do not specify one: the
+// --default-ports if --all-default-ports is given, or nil to scan only --port.
+func (b *BaseFlags) ScanPorts() ([]DefaultPort, error) {
+	if !b.AllDefaultPorts {
+		return nil, nil
+	}
+	return ParseDefaultPorts(b.DefaultPorts)
+}
+
+// MultiPortScanner is implemented by the scanners of modules registered with
+// AddCommandWithPorts. The framework runs such a scanner once for each of the
+// ports returned by GetScanPorts on targets that do not specify a port; if it
+// returns none, the target is scanned once, on --port.
+type MultiPortScanner interface {
+	Scanner
+
+	// GetScanPorts returns the ports to scan when the target has no port.
+	GetScanPorts() []DefaultPort
+}
+
+// EffectivePort returns the port to connect to: the target's port if it has
+// one, or the configured port otherwise. The target's port takes precedence
+// even over a port a module sets in its flags, so a module that connects to a
+// port of its own choosing (rather than the one being scanned) must set it on
+// a copy of the target instead.
+func (target *ScanTarget) EffectivePort(flags *BaseFlags) uint {
+	if target.Port != nil {
+		return *target.Port
+	}
+	return flags.Port
+}
+
+// portTargets returns the copies of the target that the scanner is to be run
+// on, along with the name to record each result under. If the target has no
+// port and the scanner has several ports to scan, there is one copy per port:
+// the result for the first port is recorded under the scanner's name, and the
+// others under name:port.
+func portTargets(scanner Scanner, input ScanTarget) ([]string, []ScanTarget) {
+	name := scanner.GetName()
+	multi, ok := scanner.(MultiPortScanner)
+	if input.Port != nil || !ok {
+		return []string{name}, []ScanTarget{input}
+	}
+	ports := multi.GetScanPorts()
+	if len(ports) == 0 {
+		return []string{name}, []ScanTarget{input}
+	}
+	names := make([]string, len(ports))
+	targets := make([]ScanTarget, len(ports))
+	for i, port := range ports {
+		p := port.Port
+		targets[i] = input
+		targets[i].Port = &p
+		targets[i].UseTLS = port.TLS
+		names[i] = name
+		if i > 0 {
+			names[i] = fmt.Sprintf("%s:%d", name, p)
+		}
+	}
+	return names, targets
+}
@@ -0,0 +1,69 @@
+package zgrab2
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+// multiPortScanner is an echoScanner with several default ports.
+type multiPortScanner struct {
+	echoScanner
+	ports []DefaultPort
+}
+
+func (s *multiPortScanner) GetScanPorts() []DefaultPort {
+	return s.ports
+}
+
+func TestParseDefaultPorts(t *testing.T) {
+	ports, err := ParseDefaultPorts("80, 8080,443/tls")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []DefaultPort{{Port: 80}, {Port: 8080}, {Port: 443, TLS: true}}
+	if !reflect.DeepEqual(ports, expected) {
+		t.Errorf("expected %v, got %v", expected, ports)
+	}
+	if s := formatDefaultPorts(ports); s != "80,8080,443/tls" {
+		t.Errorf("unexpected formatted ports %q", s)
+	}
+	for _, bad := range []string{"", "http", "0", "70000", "443/ssl"} {
+		if _, err := ParseDefaultPorts(bad); err == nil {
+			t.Errorf("expected error parsing %q", bad)
+		}
+	}
+}
+
+func TestScanTargetPorts(t *testing.T) {
+	scanner := &multiPortScanner{
+		echoScanner: echoScanner{name: "multi"},
+		ports:       []DefaultPort{{Port: 80}, {Port: 443, TLS: true}},
+	}
+	grab := scanTarget(ScanTarget{IP: net.ParseIP("127.0.0.1")}, []Scanner{scanner}, nil, false, 0)
+	if len(grab.Data) != 2 || grab.Data["multi"].Port != 80 || grab.Data["multi:443"].Port != 443 {
+		t.Errorf("unexpected results %v", grab.Data)
+	}
+
+	port := uint(8443)
+	grab = scanTarget(ScanTarget{IP: net.ParseIP("127.0.0.1"), Port: &port}, []Scanner{scanner}, nil, false, 0)
+	if len(grab.Data) != 1 || grab.Data["multi"].Port != 8443 {
+		t.Errorf("expected the target's port to be scanned, got %v", grab.Data)
+	}
+}
+
+func TestScanPortsOptIn(t *testing.T) {
+	flags := BaseFlags{Port: 80, DefaultPorts: "80,8080,443/tls"}
+	if ports, err := flags.ScanPorts(); err != nil || ports != nil {
+		t.Errorf("expected only --port without --all-default-ports, got %v, %v", ports, err)
+	}
+	flags.AllDefaultPorts = true
+	ports, err := flags.ScanPorts()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []DefaultPort{{Port: 80}, {Port: 8080}, {Port: 443, TLS: true}}
+	if !reflect.DeepEqual(ports, expected) {
+		t.Errorf("expected %v, got %v", expected, ports)
+	}
+}
@@ -0,0 +1,171 @@
+package zgrab2
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// A Prelude sends data at the start of each new TCP connection, before the
+// module's protocol begins, e.g. a PROXY protocol header for scanning through
+// a load balancer. Preludes are given with --prelude, so they can be combined
+// with any module that connects through Open / OpenTLS or a Dialer.
+type Prelude interface {
+	// Send writes the prelude to a newly established connection.
+	Send(conn net.Conn) error
+}
+
+// PreludeFactory creates a Prelude from the argument given after its name
+// in --prelude (empty if there is none).
+type PreludeFactory func(arg string) (Prelude, error)
+
+var preludeFactories = make(map[string]PreludeFactory)
+
+// RegisterPrelude makes a prelude available to --prelude under the given
+// name.
+func RegisterPrelude(name string, factory PreludeFactory) {
+	preludeFactories[name] = factory
+}
+
+// ParsePreludes parses a comma-separated list of preludes, each given by its
+// name optionally followed by =argument, e.g. "proxy-v2=192.0.2.1:4242".
+func ParsePreludes(spec string) ([]Prelude, error) {
+	var ret []Prelude
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, arg := entry, ""
+		if i := strings.IndexByte(entry, '='); i >= 0 {
+			name, arg = entry[:i], entry[i+1:]
+		}
+		factory, ok := preludeFactories[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown prelude %q", name)
+		}
+		prelude, err := factory(arg)
+		if err != nil {
+			return nil, fmt.Errorf("prelude %s: %v", name, err)
+		}
+		ret = append(ret, prelude)
+	}
+	return ret, nil
+}
+
+// parsedPreludes caches the result of ParsePreludes for each --prelude value,
+// so that it is not parsed on every connection.
+var parsedPreludes = struct {
+	sync.Mutex
+	bySpec map[string][]Prelude
+}{bySpec: make(map[string][]Prelude)}
+
+// GetPreludes returns the preludes given with --prelude.
+func (b *BaseFlags) GetPreludes() ([]Prelude, error) {
+	if b.Prelude == "" {
+		return nil, nil
+	}
+	parsedPreludes.Lock()
+	defer parsedPreludes.Unlock()
+	if preludes, ok := parsedPreludes.bySpec[b.Prelude]; ok {
+		return preludes, nil
+	}
+	preludes, err := ParsePreludes(b.Prelude)
+	if err != nil {
+		return nil, err
+	}
+	parsedPreludes.bySpec[b.Prelude] = preludes
+	return preludes, nil
+}
+
+// proxySignatureV2 starts every PROXY protocol version 2 header.
+var proxySignatureV2 = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// proxyPrelude sends an HAProxy PROXY protocol header claiming that the
+// connection comes from source (or from the real local address, if nil).
+type proxyPrelude struct {
+	version int
+	source  *net.TCPAddr
+}
+
+func newProxyPrelude(version int) PreludeFactory {
+	return func(arg string) (Prelude, error) {
+		ret := &proxyPrelude{version: version}
+		if arg == "" {
+			return ret, nil
+		}
+		host, port, err := net.SplitHostPort(arg)
+		if err != nil {
+			return nil, err
+		}
+		ip := net.ParseIP(host)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid source address %q", host)
+		}
+		n, err := strconv.ParseUint(port, 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid source port %q", port)
+		}
+		ret.source = &net.TCPAddr{IP: ip, Port: int(n)}
+		return ret, nil
+	}
+}
+
+// Send writes the PROXY header for the connection.
+func (p *proxyPrelude) Send(conn net.Conn) error {
+	header, err := p.header(conn.LocalAddr(), conn.RemoteAddr())
+	if err != nil {
+		return err
+	}
+	_, err = conn.Write(header)
+	return err
+}
+
+// header returns the PROXY header for a connection between the given
+// addresses.
+func (p *proxyPrelude) header(local, remote net.Addr) ([]byte, error) {
+	src, srcOK := local.(*net.TCPAddr)
+	dst, dstOK := remote.(*net.TCPAddr)
+	if !srcOK || !dstOK {
+		return nil, errors.New("PROXY protocol requires a TCP connection")
+	}
+	if p.source != nil {
+		src = p.source
+	}
+	srcIP, dstIP := src.IP.To4(), dst.IP.To4()
+	family := "TCP4"
+	if srcIP == nil || dstIP == nil {
+		srcIP, dstIP = src.IP.To16(), dst.IP.To16()
+		family = "TCP6"
+	}
+	if p.version == 1 {
+		return []byte(fmt.Sprintf("PROXY %s %s %s %d %d\r\n", family, srcIP, dstIP, src.Port, dst.Port)), nil
+	}
+	buf := new(bytes.Buffer)
+	buf.Write(proxySignatureV2)
+	// Version 2, PROXY command.
+	buf.WriteByte(0x21)
+	if family == "TCP4" {
+		// AF_INET, STREAM.
+		buf.WriteByte(0x11)
+	} else {
+		// AF_INET6, STREAM.
+		buf.WriteByte(0x21)
+	}
+	binary.Write(buf, binary.BigEndian, uint16(2*len(srcIP)+4))
+	buf.Write(srcIP)
+	buf.Write(dstIP)
+	binary.Write(buf, binary.BigEndian, uint16(src.Port))
+	binary.Write(buf, binary.BigEndian, uint16(dst.Port))
+	return buf.Bytes(), nil
+}
+
+func init() {
+	RegisterPrelude("proxy-v1", newProxyPrelude(1))
+	RegisterPrelude("proxy-v2", newProxyPrelude(2))
+}
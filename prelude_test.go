@@ -0,0 +1,72 @@
+package zgrab2
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestProxyPreludeHeader(t *testing.T) {
+	preludes, err := ParsePreludes("proxy-v1=192.0.2.1:4242, proxy-v2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	local := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 5555}
+	remote := &net.TCPAddr{IP: net.ParseIP("198.51.100.7"), Port: 80}
+	v1, err := preludes[0].(*proxyPrelude).header(local, remote)
+	if err != nil || string(v1) != "PROXY TCP4 192.0.2.1 198.51.100.7 4242 80\r\n" {
+		t.Errorf("unexpected v1 header %q (%v)", v1, err)
+	}
+	v2, err := preludes[1].(*proxyPrelude).header(local, remote)
+	expected := append(append([]byte{}, proxySignatureV2...),
+		0x21, 0x11, 0, 12,
+		10, 0, 0, 1,
+		198, 51, 100, 7,
+		0x15, 0xb3,
+		0, 80)
+	if err != nil || !bytes.Equal(v2, expected) {
+		t.Errorf("unexpected v2 header %x (%v)", v2, err)
+	}
+	for _, bad := range []string{"nonexistent", "proxy-v1=192.0.2.1", "proxy-v2=host:80"} {
+		if _, err := ParsePreludes(bad); err == nil {
+			t.Errorf("expected error parsing %q", bad)
+		}
+	}
+}
+
+func TestDialerPreludes(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	received := make(chan []byte, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		buf := make([]byte, 64)
+		n, _ := io.ReadAtLeast(conn, buf, len("PROXY TCP4"))
+		received <- buf[:n]
+	}()
+	preludes, _ := ParsePreludes("proxy-v1")
+	dialer := NewDialer(&Dialer{Timeout: time.Second, Preludes: preludes})
+	conn, err := dialer.DialContext(context.Background(), "tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	select {
+	case data := <-received:
+		if !bytes.HasPrefix(data, []byte("PROXY TCP4 127.0.0.1 127.0.0.1 ")) {
+			t.Errorf("unexpected prelude %q", data)
+		}
+	case <-time.After(time.Second):
+		t.Error("prelude not received")
+	}
+}
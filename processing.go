@@ -1,10 +1,14 @@
 package zgrab2
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/zmap/zgrab2/lib/output"
@@ -12,9 +16,13 @@ import (
 
 // Grab contains all scan responses for a single host
 type Grab struct {
-	IP     string                  `json:"ip,omitempty"`
-	Domain string                  `json:"domain,omitempty"`
-	Data   map[string]ScanResponse `json:"data,omitempty"`
+	IP       string                  `json:"ip,omitempty"`
+	Domain   string                  `json:"domain,omitempty"`
+	Metadata json.RawMessage         `json:"metadata,omitempty"`
+	Location *Location               `json:"location,omitempty"`
+	Lookup   *DomainLookup           `json:"lookup,omitempty"`
+	NTLM     *NTLMCorrelation        `json:"ntlm,omitempty"`
+	Data     map[string]ScanResponse `json:"data,omitempty"`
 }
 
 // ScanTarget is the host that will be scanned
@@ -22,6 +30,55 @@ type ScanTarget struct {
 	IP     net.IP
 	Domain string
 	Tag    string
+
+	// Zone is the zone of IP, if it is an IPv6 address given with one (e.g.
+	// the interface of a link-local address, as in fe80::1%eth0).
+	Zone string
+
+	// Port, if non-nil, is the port to scan, overriding the port configured
+	// for the module (see EffectivePort).
+	Port *uint
+
+	// UseTLS is set when Port is one of a module's default ports that is
+	// conventionally used with TLS (e.g. 443 for HTTP).
+	UseTLS bool
+
+	// Transport is the transport to scan over, for scanners that support
+	// more than one (see MultiTransportScanner). If empty, the framework
+	// tries each of the scanner's transports.
+	Transport string
+
+	// Metadata is an opaque JSON value that is copied into the target's Grab.
+	Metadata json.RawMessage
+
+	// log collects framework-level details of the current scan; it is shared
+	// between copies of the target made during the scan.
+	log *scanLog
+
+	// sharing, if set, is the part the current scanner plays in sharing
+	// connections with the target's other scanners.
+	sharing *connectionSharing
+
+	// deadline, if set, is the end of the time budget for all scans of the
+	// target.
+	deadline time.Time
+
+	// inputRecord, if set, is the number of the input record that the target
+	// was read from.
+	inputRecord uint64
+
+	// lookup, if set, is the DNS record the target was found in, with
+	// --lookup-domain.
+	lookup *DomainLookup
+
+	// ics, if set, is the scanner whose CheckICSRequest checks each message
+	// written to the connections opened for the current scan, with
+	// --ics-safe.
+	ics ICSScanner
+
+	// completed, if set, is called once the target has been scanned and its
+	// results queued for output, or it has been skipped (see complete).
+	completed func()
 }
 
 func (target ScanTarget) String() string {
@@ -30,9 +87,9 @@ func (target ScanTarget) String() string {
 	}
 	res := ""
 	if target.IP != nil && target.Domain != "" {
-		res = target.Domain + "(" + target.IP.String() + ")"
+		res = target.Domain + "(" + target.ipString() + ")"
 	} else if target.IP != nil {
-		res = target.IP.String()
+		res = target.ipString()
 	} else {
 		res = target.Domain
 	}
@@ -42,11 +99,39 @@ func (target ScanTarget) String() string {
 	return res
 }
 
-// Host gets the host identifier as a string: the IP address if it is available,
-// or the domain if not.
+// ipString returns the target's IP address, followed by its zone if it has
+// one.
+func (target *ScanTarget) ipString() string {
+	if target.Zone != "" {
+		return target.IP.String() + "%" + target.Zone
+	}
+	return target.IP.String()
+}
+
+// BoundTimeout returns the given timeout, reduced if necessary so that it does
+// not extend beyond the target's time budget (see --target-timeout). Modules
+// that do not use Open / OpenTLS / OpenUDP should use this to bound their own
+// connections.
+func (target *ScanTarget) BoundTimeout(timeout time.Duration) time.Duration {
+	if target.deadline.IsZero() {
+		return timeout
+	}
+	remaining := time.Until(target.deadline)
+	if remaining <= 0 {
+		// A zero timeout means "no timeout", so use the smallest positive value.
+		return time.Nanosecond
+	}
+	if timeout <= 0 || remaining < timeout {
+		return remaining
+	}
+	return timeout
+}
+
+// Host gets the host identifier as a string: the IP address (with its zone,
+// if any) if it is available, or the domain if not.
 func (target *ScanTarget) Host() string {
 	if target.IP != nil {
-		return target.IP.String()
+		return target.ipString()
 	} else if target.Domain != "" {
 		return target.Domain
 	}
@@ -55,9 +140,38 @@ func (target *ScanTarget) Host() string {
 }
 
 // Open connects to the ScanTarget using the configured flags, and returns a net.Conn that uses the configured timeouts for Read/Write operations.
+// It connects to target.Port if set, and only otherwise to flags.Port.
 func (target *ScanTarget) Open(flags *BaseFlags) (net.Conn, error) {
-	address := net.JoinHostPort(target.Host(), fmt.Sprintf("%d", flags.Port))
-	return DialTimeoutConnection("tcp", address, flags.Timeout, flags.BytesReadLimit)
+	address := net.JoinHostPort(target.Host(), fmt.Sprintf("%d", target.EffectivePort(flags)))
+	preludes, err := flags.GetPreludes()
+	if err != nil {
+		return nil, err
+	}
+	options, err := flags.GetSocketOptions()
+	if err != nil {
+		return nil, err
+	}
+	if conn := target.SharedConnection(flags, address); conn != nil {
+		return target.guardICS(target.handOff(flags, address, conn)), nil
+	}
+	proxies, err := flags.GetProxies()
+	if err != nil {
+		return nil, err
+	}
+	dialer := NewDialer(&Dialer{
+		Timeout:        target.BoundTimeout(flags.Timeout),
+		BytesReadLimit: flags.BytesReadLimit,
+		AddressFamily:  flags.AddressFamily,
+		Preludes:       preludes,
+		SocketOptions:  options,
+		Proxies:        proxies,
+	})
+	conn, err := dialer.DialContext(context.Background(), "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	target.RecordConnection(conn)
+	return target.guardICS(target.handOff(flags, address, conn)), nil
 }
 
 // OpenTLS connects to the ScanTarget using the configured flags, then performs
@@ -74,8 +188,9 @@ func (target *ScanTarget) OpenTLS(baseFlags *BaseFlags, tlsFlags *TLSFlags) (*TL
 
 // OpenUDP connects to the ScanTarget using the configured flags, and returns a net.Conn that uses the configured timeouts for Read/Write operations.
 // Note that the UDP "connection" does not have an associated timeout.
+// As with Open, target.Port takes precedence over flags.Port.
 func (target *ScanTarget) OpenUDP(flags *BaseFlags, udp *UDPFlags) (net.Conn, error) {
-	address := net.JoinHostPort(target.Host(), fmt.Sprintf("%d", flags.Port))
+	address := net.JoinHostPort(target.Host(), fmt.Sprintf("%d", target.EffectivePort(flags)))
 	var local *net.UDPAddr
 	if udp != nil && (udp.LocalAddress != "" || udp.LocalPort != 0) {
 		local = &net.UDPAddr{}
@@ -86,37 +201,114 @@ func (target *ScanTarget) OpenUDP(flags *BaseFlags, udp *UDPFlags) (net.Conn, er
 			local.Port = int(udp.LocalPort)
 		}
 	}
-	remote, err := net.ResolveUDPAddr("udp", address)
+	network, err := familyNetwork("udp", flags.AddressFamily)
+	if err != nil {
+		return nil, err
+	}
+	options, err := flags.GetSocketOptions()
 	if err != nil {
 		return nil, err
 	}
-	conn, err := net.DialUDP("udp", local, remote)
+	address, resolution, err := filter.checkAddress(context.Background(), network, address)
 	if err != nil {
 		return nil, err
 	}
-	return NewTimeoutConnection(nil, conn, flags.Timeout, 0, 0, flags.BytesReadLimit), nil
+	target.recordResolution(resolution)
+	remote, err := net.ResolveUDPAddr(network, address)
+	if err != nil {
+		return nil, err
+	}
+	var conn net.Conn
+	if local != nil && local.Port != 0 {
+		conn, err = net.DialUDP(network, local, remote)
+	} else {
+		ctx, cancel := context.Background(), context.CancelFunc(func() {})
+		if timeout := target.BoundTimeout(flags.Timeout); timeout > 0 {
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+		}
+		conn, err = withSourcePort(ctx, func(port int) (net.Conn, error) {
+			bound := &net.UDPAddr{Port: port}
+			if local != nil {
+				bound.IP = local.IP
+			}
+			return net.DialUDP(network, bound, remote)
+		})
+		cancel()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if options != nil {
+		// Nothing has been sent yet, so there is no need to set the
+		// options before connecting.
+		if err := options.applyToConn(conn); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	ret := NewTimeoutConnection(nil, conn, target.BoundTimeout(flags.Timeout), 0, 0, flags.BytesReadLimit)
+	target.RecordConnection(ret)
+	return target.guardICS(ret), nil
 }
 
-// grabTarget calls handler for each action
-func grabTarget(input ScanTarget, m *Monitor) []byte {
+// scanTarget runs each of the given scanners whose trigger matches the
+// target's tag, and returns the combined Grab. If budget is positive, it
+// limits the total time spent on the target: connections are not allowed to
+// outlive it, and scanners that have not started when it runs out are skipped.
+func scanTarget(input ScanTarget, scanners []Scanner, m *Monitor, continueOnError bool, budget time.Duration) Grab {
 	moduleResult := make(map[string]ScanResponse)
+	var ntlmNames []string
+	var ntlmInfos []*NTLMInfo
+	if budget > 0 {
+		input.deadline = time.Now().Add(budget)
+	}
 
-	for _, scannerName := range orderedScanners {
-		scanner := scanners[scannerName]
-		trigger := (*scanner).GetTrigger()
+	group := new(connectionGroup)
+	defer group.closeAll()
+	for _, scanner := range scanners {
+		trigger := scanner.GetTrigger()
 		if input.Tag != trigger {
 			continue
 		}
-		defer func(name string) {
-			if e := recover(); e != nil {
-				log.Errorf("Panic on scanner %s when scanning target %s: %#v", scannerName, input.String(), e)
-				// Bubble out original error (with original stack) in lieu of explicitly logging the stack / error
-				panic(e)
+		sharing := group.sharingFor(scanner)
+		// When the scanner is run on several ports, only stop if all of
+		// them failed.
+		var ran, succeeded bool
+		names, targets := portTargets(scanner, input)
+		for i, target := range targets {
+			if !input.deadline.IsZero() && !time.Now().Before(input.deadline) {
+				errString := ErrTargetTimeout.Error()
+				now, seq := endScan()
+				moduleResult[names[i]] = ScanResponse{
+					Status:    SCAN_TARGET_TIMEOUT,
+					Protocol:  scanner.Protocol(),
+					Timestamp: now.Format(time.RFC3339),
+					StartTime: now.Format(time.RFC3339Nano),
+					EndTime:   now.Format(time.RFC3339Nano),
+					Sequence:  seq,
+					Error:     &errString,
+				}
+				continue
+			}
+			policy.wait(scanner.Protocol())
+			release := concurrency.acquire(scanner.GetName())
+			target.sharing = sharing
+			_, res := RunScanner(scanner, m, target)
+			release()
+			moduleResult[names[i]] = res
+			if info := GetNTLMInfo(scanner, res.Result); info != nil {
+				ntlmNames = append(ntlmNames, names[i])
+				ntlmInfos = append(ntlmInfos, info)
 			}
-		}(scannerName)
-		name, res := RunScanner(*scanner, m, input)
-		moduleResult[name] = res
-		if res.Error != nil && !config.Multiple.ContinueOnError {
+			ran = true
+			if res.Error == nil {
+				succeeded = true
+			} else {
+				// Don't pass on connections left in an unknown state.
+				group.closeAll()
+			}
+		}
+		if ran && !succeeded && !continueOnError {
 			break
 		}
 	}
@@ -125,76 +317,385 @@ func grabTarget(input ScanTarget, m *Monitor) []byte {
 	if input.IP == nil {
 		ipstr = ""
 	} else {
-		s := input.IP.String()
+		s := input.ipString()
 		ipstr = s
 	}
 
-	raw := Grab{IP: ipstr, Domain: input.Domain, Data: moduleResult}
+	return Grab{IP: ipstr, Domain: input.Domain, Metadata: input.Metadata, Lookup: input.lookup, NTLM: CorrelateNTLM(ntlmNames, ntlmInfos), Data: moduleResult}
+}
 
-	var outputData interface{} = raw
+// grabTarget calls handler for each action
+func grabTarget(input ScanTarget, scanners []Scanner, m *Monitor) []byte {
+	scanners = sampler.active(scanners)
+	raw := scanTarget(input, scanners, m, config.Multiple.ContinueOnError, config.TargetTimeout)
+	sampler.record(scanners, &raw)
+	raw.Location = locate(grabAddress(&raw))
+	statuses := make([]ScanStatus, 0, len(raw.Data))
+	for _, res := range raw.Data {
+		statuses = append(statuses, res.Status)
+	}
+	progress.recordStatuses(statuses)
 
-	if !includeDebugOutput() {
-		// If the caller doesn't explicitly request debug data, strip it out.
-		// Take advantage of the fact that we can skip the (expensive) call to
-		// process if debug output is included (TODO: until Process does anything else)
-		processor := output.Processor{Verbose: false}
-		stripped, err := processor.Process(raw)
-		if err != nil {
-			log.Debugf("Error processing results: %v", err)
-			stripped = raw
+	result, err := encodeGrab(&raw)
+	if err == nil && certDedup != nil {
+		result, err = certDedup.dedup(result)
+	}
+	if err != nil {
+		log.Fatalf("unable to marshal data: %s", err)
+	}
+	return result
+}
+
+// encodeGrab returns the JSON encoding of the grab, in the --output-schema
+// shape, without debug fields unless they were requested (or raw fields, with
+// --omit-raw), in a buffer that can be released with ReleaseResult once it has
+// been written. With the shodan schema the result may hold several lines, or
+// be empty.
+func encodeGrab(raw *Grab) ([]byte, error) {
+	switch config.OutputSchema {
+	case outputSchemaCensys:
+		return encodeResultLines(stripOutput(censysHostOf(raw)))
+	case outputSchemaShodan:
+		banners := shodanBannersOf(raw)
+		if len(banners) == 0 {
+			return nil, nil
 		}
-		outputData = stripped
+		for i, banner := range banners {
+			banners[i] = stripOutput(banner)
+		}
+		return encodeResultLines(banners...)
 	}
+	return encodeResultLines(stripOutput(raw))
+}
 
-	result, err := json.Marshal(outputData)
+// stripOutput returns v without debug fields unless they were requested, and
+// without raw fields with --omit-raw.
+func stripOutput(v interface{}) interface{} {
+	if includeDebugOutput() && !config.OmitRaw {
+		return v
+	}
+	// If the caller doesn't explicitly request debug data, strip it out.
+	// Parts of the grab that cannot contain debug or raw data are shared
+	// with it rather than copied.
+	processor := output.Processor{Verbose: includeDebugOutput(), OmitRaw: config.OmitRaw}
+	stripped, err := processor.Process(v)
 	if err != nil {
-		log.Fatalf("unable to marshal data: %s", err)
+		log.Debugf("Error processing results: %v", err)
+		return v
 	}
+	return stripped
+}
 
-	return result
+// senderPool manages the set of send goroutines, allowing the number of
+// senders to be changed while the scan is running.
+type senderPool struct {
+	mutex  sync.Mutex
+	wg     sync.WaitGroup
+	target int
+	live   int
+	nextID int
+	closed bool
+	run    func(senderID int)
+
+	// requested is the number of senders last asked for by the operator,
+	// which the watchdog restores once it stops shedding load.
+	requested int
+}
+
+// size returns the desired number of senders.
+func (pool *senderPool) size() int {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	return pool.target
+}
+
+// requestedSize returns the number of senders last asked for with request.
+func (pool *senderPool) requestedSize() int {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	return pool.requested
+}
+
+// request sets the number of senders asked for by the operator, at the start
+// of the scan or while it runs, and resizes the pool to it.
+func (pool *senderPool) request(n int) error {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	pool.requested = n
+	return pool.resizeLocked(n)
+}
+
+// resize sets the desired number of senders, starting new senders if there
+// are too few. Excess senders exit after finishing their current target.
+func (pool *senderPool) resize(n int) error {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	return pool.resizeLocked(n)
+}
+
+func (pool *senderPool) resizeLocked(n int) error {
+	if pool.closed {
+		return errors.New("scan is finishing")
+	}
+	pool.target = n
+	for pool.live < pool.target {
+		pool.live++
+		pool.wg.Add(1)
+		go func(id int) {
+			defer pool.wg.Done()
+			pool.run(id)
+		}(pool.nextID)
+		pool.nextID++
+	}
+	return nil
+}
+
+// shouldExit is called by a sender between targets; if there are more senders
+// than desired, it returns true and the sender must exit.
+func (pool *senderPool) shouldExit() bool {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	if pool.live > pool.target {
+		pool.live--
+		return true
+	}
+	return false
+}
+
+// exited is called by a sender that exits because the input was exhausted.
+func (pool *senderPool) exited() {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	pool.live--
+}
+
+// wait blocks until all senders have exited; no senders may be added after
+// wait is called.
+func (pool *senderPool) wait() {
+	pool.mutex.Lock()
+	pool.closed = true
+	pool.mutex.Unlock()
+	pool.wg.Wait()
 }
 
 // Process sets up an output encoder, input reader, and starts grab workers.
+// On SIGINT or SIGTERM, it stops reading targets, and returns once the scans
+// in flight have finished (or --drain-timeout has passed) and their results
+// have been written.
 func Process(mon *Monitor) {
 	workers := config.Senders
-	processQueue := make(chan ScanTarget, workers*4)
-	outputQueue := make(chan []byte, workers*4)
+	// Both queues are bounded, so that when senders or output fall behind,
+	// reading input waits rather than buffering targets or results.
+	queueSize := config.QueueSize
+	if queueSize == 0 {
+		queueSize = workers * 4
+	}
+	intakeQueue := make(chan ScanTarget)
+	processQueue := make(chan ScanTarget, queueSize)
+	outputQueue := make(chan []byte, queueSize)
+	written := make(chan []byte)
+	abandon := make(chan struct{})
 
 	//Create wait groups
-	var workerDone sync.WaitGroup
+	var intakeDone sync.WaitGroup
 	var outputDone sync.WaitGroup
-	workerDone.Add(int(workers))
-	outputDone.Add(1)
+	intakeDone.Add(1)
+	outputDone.Add(2)
+
+	signalDone := make(chan struct{})
+	defer close(signalDone)
+	go drainOnSignal(signalDone)
 
 	// Start the output encoder
 	go func() {
 		defer outputDone.Done()
-		if err := config.outputResults(outputQueue); err != nil {
+		if err := config.outputResults(written); err != nil {
 			log.Fatal(err)
 		}
 	}()
+	// write passes a result on to the encoder, adding it to the manifest.
+	write := func(result []byte) {
+		if len(result) == 0 {
+			return
+		}
+		if manifest != nil {
+			if err := manifest.add(result); err != nil {
+				log.Fatalf("unable to write manifest: %s", err)
+			}
+		}
+		written <- result
+	}
+	// Pass results on to the encoder until the workers finish or are
+	// abandoned, so that it is never closed while they may still be writing.
+	go func() {
+		defer outputDone.Done()
+		defer close(written)
+		for {
+			select {
+			case result, ok := <-outputQueue:
+				if !ok {
+					return
+				}
+				write(result)
+			case <-abandon:
+				for {
+					select {
+					case result := <-outputQueue:
+						write(result)
+					default:
+						return
+					}
+				}
+			}
+		}
+	}()
 	//Start all the workers
-	for i := 0; i < workers; i++ {
-		go func(i int) {
-			for _, scannerName := range orderedScanners {
-				scanner := *scanners[scannerName]
-				scanner.InitPerSender(i)
-			}
-			for obj := range processQueue {
-				for run := uint(0); run < uint(config.ConnectionsPerHost); run++ {
-					result := grabTarget(obj, mon)
-					outputQueue <- result
+	scanners := registeredScanners()
+	pool := &senderPool{}
+	pool.run = func(i int) {
+		for _, scanner := range scanners {
+			scanner.InitPerSender(i)
+		}
+		for obj := range processQueue {
+			// Skip targets queued before enough successes were collected,
+			// or before the scan was interrupted.
+			for run := uint(0); run < uint(config.ConnectionsPerHost) && !sampler.done(scanners) && !interrupt.stopped(); run++ {
+				result := grabTarget(obj, scanners, mon)
+				if len(result) > 0 {
+					atomic.AddUint64(&resultsQueued, 1)
 				}
+				outputQueue <- result
+			}
+			if !interrupt.stopped() {
+				obj.complete()
+			}
+			progress.targetCompleted()
+			if pool.shouldExit() {
+				return
 			}
-			workerDone.Done()
-		}(i)
+		}
+		pool.exited()
 	}
-
-	if err := config.inputTargets(processQueue); err != nil {
+	progress.begin(pool)
+	progress.watchQueues(processQueue, outputQueue)
+	if err := pool.request(workers); err != nil {
 		log.Fatal(err)
 	}
+	watchdogDone := make(chan struct{})
+	defer close(watchdogDone)
+	if w := newWatchdog(pool); w != nil {
+		go w.run(watchdogDone)
+	}
+	if manifest != nil {
+		defer func() {
+			if err := manifest.close(config.OutputFileName); err != nil {
+				log.Fatalf("unable to write manifest: %s", err)
+			}
+		}()
+	}
+	if certDedup != nil {
+		defer func() {
+			if err := certDedup.close(); err != nil {
+				log.Fatalf("unable to write certificates: %s", err)
+			}
+		}()
+	}
+	if notifications != nil {
+		notifications.start()
+		// Deferred first, so that it runs once the output is done.
+		defer notifications.finish()
+	}
+
+	// Forward targets from the input to the workers, honoring pauses and
+	// rate limits.
+	admitted := make(chan ScanTarget)
+	go func() {
+		defer intakeDone.Done()
+		limits.forward(admitted, processQueue, interrupt.stop, interrupt.targetDispatched, func(*ScanTarget) {
+			progress.targetCompleted()
+		})
+	}()
+	go func() {
+		defer close(admitted)
+		for {
+			var obj ScanTarget
+			var ok bool
+			select {
+			case obj, ok = <-intakeQueue:
+			case <-interrupt.stop:
+				return
+			}
+			if !ok {
+				return
+			}
+			if !filter.allowTarget(&obj) {
+				obj.complete()
+				continue
+			}
+			if sharder != nil && !sharder.selected(&obj) {
+				obj.complete()
+				continue
+			}
+			if sampleFilter != nil && !sampleFilter.selected(&obj) {
+				obj.complete()
+				continue
+			}
+			if dedup != nil && dedup.duplicate(&obj) {
+				obj.complete()
+				continue
+			}
+			if sampler.done(scanners) {
+				// Read the rest of the input without scanning it.
+				obj.complete()
+				continue
+			}
+			if !progress.targetRead() {
+				return
+			}
+			select {
+			case admitted <- obj:
+			case <-interrupt.stop:
+				progress.targetCompleted()
+				return
+			}
+		}
+	}()
+
+	// When interrupted, stop waiting for the input, which may never end
+	// (e.g. when piped from zmap).
+	inputDone := make(chan error, 1)
+	go func() {
+		inputDone <- config.inputTargets(intakeQueue)
+	}()
+	select {
+	case err := <-inputDone:
+		if err != nil {
+			log.Fatal(err)
+		}
+		close(intakeQueue)
+	case <-interrupt.stop:
+	}
+	intakeDone.Wait()
 	close(processQueue)
-	workerDone.Wait()
+
+	drained := make(chan struct{})
+	go func() {
+		pool.wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-interrupt.stop:
+		select {
+		case <-drained:
+		case <-time.After(config.DrainTimeout):
+			log.Warnf("scans still running after --drain-timeout %s, abandoning them", config.DrainTimeout)
+			interrupt.drainTimedOut()
+			close(abandon)
+			outputDone.Wait()
+			return
+		}
+	}
 	close(outputQueue)
 	outputDone.Wait()
 }
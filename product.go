@@ -0,0 +1,99 @@
+package zgrab2
+
+import (
+	"bytes"
+	"strings"
+)
+
+// CPE parts, giving the kind of product a CPE names.
+const (
+	CPEApplication     = "a"
+	CPEOperatingSystem = "o"
+	CPEHardware        = "h"
+)
+
+// Product identifies the software (or hardware) found by a scan, in the same
+// form for every module, so that results can be inventoried without parsing
+// each module's output. Vendor and Name use CPE dictionary names (e.g.
+// "apache" and "http_server") where the product is known to zgrab2.
+type Product struct {
+	// Vendor is the product's vendor, if known.
+	Vendor string `json:"vendor,omitempty"`
+
+	// Name is the product's name.
+	Name string `json:"name"`
+
+	// Version is the product's version, as reported by the target.
+	Version string `json:"version,omitempty"`
+
+	// CPE is the CPE 2.3 formatted string naming the product, if the vendor
+	// is known.
+	CPE string `json:"cpe,omitempty"`
+
+	// CVEs lists the IDs of the CVEs affecting the product's version, from
+	// the NVD snapshot given with --cve-file.
+	CVEs []string `json:"cves,omitempty"`
+}
+
+// NewProduct returns the Product with the given CPE part (e.g.
+// CPEApplication), vendor, name and version, which may be empty. Its CPE is
+// only set if both the vendor and name are given.
+func NewProduct(part, vendor, name, version string) *Product {
+	ret := &Product{Vendor: vendor, Name: name, Version: version}
+	if vendor != "" && name != "" {
+		ret.CPE = "cpe:2.3:" + part + ":" + cpeValue(vendor) + ":" + cpeValue(name) + ":" + cpeValue(version) + ":*:*:*:*:*:*:*"
+	}
+	return ret
+}
+
+// cpeValue encodes a CPE 2.3 formatted string attribute: lowercased, with
+// spaces replaced by underscores, and punctuation other than '-', '.' and '_'
+// escaped. An empty value is ANY ("*").
+func cpeValue(value string) string {
+	if value == "" {
+		return "*"
+	}
+	var ret bytes.Buffer
+	for _, c := range strings.ToLower(value) {
+		switch {
+		case c == ' ':
+			ret.WriteByte('_')
+		case c >= 'a' && c <= 'z', c >= '0' && c <= '9', c == '-', c == '.', c == '_':
+			ret.WriteRune(c)
+		case c < 0x80:
+			ret.WriteByte('\\')
+			ret.WriteRune(c)
+		}
+	}
+	if ret.Len() == 0 {
+		return "*"
+	}
+	return ret.String()
+}
+
+// ProductScanner is implemented by scanners that can identify the product
+// running on the target from their results. The framework records the
+// product in the scan response's product field.
+type ProductScanner interface {
+	Scanner
+
+	// IdentifyProduct returns the product identified by the result of a
+	// scan (which may have failed part way), or nil if it cannot be
+	// identified.
+	IdentifyProduct(result interface{}) *Product
+}
+
+// identifyProduct returns the product identified by the scanner from its
+// result, if it is a ProductScanner, with the CVEs affecting it if an NVD
+// snapshot was loaded.
+func identifyProduct(s Scanner, result interface{}) *Product {
+	p, ok := s.(ProductScanner)
+	if !ok || result == nil {
+		return nil
+	}
+	ret := p.IdentifyProduct(result)
+	if ret != nil {
+		ret.CVEs = cves.lookup(ret)
+	}
+	return ret
+}
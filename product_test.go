@@ -0,0 +1,47 @@
+package zgrab2
+
+import (
+	"testing"
+)
+
+func TestNewProduct(t *testing.T) {
+	tests := []struct {
+		vendor, name, version string
+		cpe                   string
+	}{
+		{"apache", "http_server", "2.4.41", "cpe:2.3:a:apache:http_server:2.4.41:*:*:*:*:*:*:*"},
+		{"Microsoft", "SQL Server", "", "cpe:2.3:a:microsoft:sql_server:*:*:*:*:*:*:*:*"},
+		{"openbsd", "openssh", "8.2p1+deb:1", "cpe:2.3:a:openbsd:openssh:8.2p1\\+deb\\:1:*:*:*:*:*:*:*"},
+		{"", "cloudflare", "", ""},
+	}
+	for _, test := range tests {
+		product := NewProduct(CPEApplication, test.vendor, test.name, test.version)
+		if product.CPE != test.cpe {
+			t.Errorf("%s %s %s: expected CPE %q, got %q", test.vendor, test.name, test.version, test.cpe, product.CPE)
+		}
+	}
+}
+
+// productScanner identifies every non-empty string result as a product.
+type productScanner struct {
+	echoScanner
+}
+
+func (s *productScanner) IdentifyProduct(result interface{}) *Product {
+	if name, ok := result.(string); ok && name != "" {
+		return NewProduct(CPEApplication, "", name, "")
+	}
+	return nil
+}
+
+func TestIdentifyProduct(t *testing.T) {
+	if p := identifyProduct(&productScanner{}, "thing"); p == nil || p.Name != "thing" {
+		t.Errorf("expected product thing, got %+v", p)
+	}
+	if p := identifyProduct(&productScanner{}, nil); p != nil {
+		t.Errorf("expected no product for a nil result, got %+v", p)
+	}
+	if p := identifyProduct(&echoScanner{}, "thing"); p != nil {
+		t.Errorf("expected no product from a scanner that cannot identify one, got %+v", p)
+	}
+}
@@ -0,0 +1,352 @@
+package zgrab2
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Progress is a snapshot of the state of a running scan, as served by the
+// status endpoint.
+type Progress struct {
+	StartTime        string                `json:"start"`
+	Elapsed          string                `json:"elapsed"`
+	TargetsRead      uint64                `json:"targets_read"`
+	TargetsCompleted uint64                `json:"targets_completed"`
+	Statuses         map[ScanStatus]uint64 `json:"statuses"`
+	Rate             float64               `json:"rate"`
+	InputFraction    float64               `json:"input_fraction,omitempty"`
+	ETA              string                `json:"eta,omitempty"`
+	Senders          int                   `json:"senders"`
+	Paused           bool                  `json:"paused"`
+	Throttled        bool                  `json:"throttled"`
+	Queued           int                   `json:"queued"`
+	OutputQueued     int                   `json:"output_queued"`
+	QueueSize        int                   `json:"queue_size"`
+
+	// ModuleSenders is the number of senders running each module that has
+	// a --senders limit of its own.
+	ModuleSenders map[string]int `json:"module_senders,omitempty"`
+}
+
+// progressTracker collects the counters that make up a Progress snapshot.
+// It also acts as the gate that allows intake to be paused at runtime.
+type progressTracker struct {
+	mutex     sync.Mutex
+	resumed   *sync.Cond
+	drained   *sync.Cond
+	start     time.Time
+	read      uint64
+	completed uint64
+	statuses  map[ScanStatus]uint64
+	paused    bool
+
+	// stopped is set when the scan is interrupted; intake does not resume.
+	stopped bool
+
+	// throttled is set by the watchdog to apply backpressure; it is kept
+	// separate from paused so that the two do not override each other.
+	throttled bool
+
+	// inputSize and inputRead track how much of the input file has been
+	// consumed; inputSize is zero if the input is not a regular file.
+	inputSize int64
+	inputRead int64
+
+	// lastSample and lastCompleted are used to compute the current rate.
+	lastSample    time.Time
+	lastCompleted uint64
+	lastRate      float64
+
+	// senders, if set, is the pool of running senders.
+	senders *senderPool
+
+	// targetQueue and resultQueue, if set, are the queues of targets
+	// waiting for a sender and of results waiting to be written.
+	targetQueue <-chan ScanTarget
+	resultQueue <-chan []byte
+}
+
+var progress = newProgressTracker()
+
+func newProgressTracker() *progressTracker {
+	ret := &progressTracker{
+		start:    time.Now(),
+		statuses: make(map[ScanStatus]uint64),
+	}
+	ret.resumed = sync.NewCond(&ret.mutex)
+	ret.drained = sync.NewCond(&ret.mutex)
+	return ret
+}
+
+// begin marks the start of the scan.
+func (p *progressTracker) begin(senders *senderPool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.start = time.Now()
+	p.lastSample = p.start
+	p.senders = senders
+}
+
+// watchQueues sets the queues whose depths are reported.
+func (p *progressTracker) watchQueues(targets <-chan ScanTarget, results <-chan []byte) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.targetQueue = targets
+	p.resultQueue = results
+}
+
+// targetRead records that a target was read from the input, blocking while
+// intake is paused. It returns false, without recording the target, if intake
+// has been stopped.
+func (p *progressTracker) targetRead() bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for (p.paused || p.throttled) && !p.stopped {
+		p.resumed.Wait()
+	}
+	if p.stopped {
+		return false
+	}
+	p.read++
+	return true
+}
+
+// stop permanently stops intake, waking any sender waiting for it to resume.
+func (p *progressTracker) stop() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.stopped = true
+	p.resumed.Broadcast()
+}
+
+// targetCompleted records that all scans for a target have finished.
+func (p *progressTracker) targetCompleted() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.completed++
+	if p.completed == p.read {
+		p.drained.Broadcast()
+	}
+}
+
+// waitDrained blocks until every target read so far has been completed.
+func (p *progressTracker) waitDrained() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for p.completed < p.read {
+		p.drained.Wait()
+	}
+}
+
+// recordStatuses records the statuses of the scans in a single grab.
+func (p *progressTracker) recordStatuses(statuses []ScanStatus) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for _, status := range statuses {
+		p.statuses[status]++
+	}
+}
+
+// setPaused pauses or resumes intake of new targets.
+func (p *progressTracker) setPaused(paused bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.paused = paused
+	if !paused {
+		p.resumed.Broadcast()
+	}
+}
+
+// setThrottled applies or releases backpressure on intake of new targets.
+func (p *progressTracker) setThrottled(throttled bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.throttled = throttled
+	if !throttled {
+		p.resumed.Broadcast()
+	}
+}
+
+// inputConsumed records that n more bytes of the input file were read.
+func (p *progressTracker) inputConsumed(n int) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.inputRead += int64(n)
+}
+
+// snapshot returns the current Progress.
+func (p *progressTracker) snapshot() *Progress {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	now := time.Now()
+	elapsed := now.Sub(p.start)
+	// Only resample the rate at most once per second, so that frequent
+	// polling does not produce a noisy value.
+	if since := now.Sub(p.lastSample); since >= time.Second {
+		p.lastRate = float64(p.completed-p.lastCompleted) / since.Seconds()
+		p.lastSample = now
+		p.lastCompleted = p.completed
+	}
+	ret := &Progress{
+		StartTime:        p.start.Format(time.RFC3339),
+		Elapsed:          elapsed.String(),
+		TargetsRead:      p.read,
+		TargetsCompleted: p.completed,
+		Statuses:         make(map[ScanStatus]uint64, len(p.statuses)),
+		Rate:             p.lastRate,
+		Paused:           p.paused,
+		Throttled:        p.throttled,
+	}
+	for k, v := range p.statuses {
+		ret.Statuses[k] = v
+	}
+	if p.senders != nil {
+		ret.Senders = p.senders.size()
+	}
+	if running := concurrency.running(); len(running) > 0 {
+		ret.ModuleSenders = running
+	}
+	if p.targetQueue != nil {
+		ret.Queued = len(p.targetQueue)
+		ret.QueueSize = cap(p.targetQueue)
+	}
+	if p.resultQueue != nil {
+		ret.OutputQueued = len(p.resultQueue)
+	}
+	if p.inputSize > 0 && p.inputRead > 0 {
+		ret.InputFraction = float64(p.inputRead) / float64(p.inputSize)
+		if ret.InputFraction < 1 {
+			remaining := time.Duration(float64(elapsed) * (1 - ret.InputFraction) / ret.InputFraction)
+			ret.ETA = remaining.Round(time.Second).String()
+		}
+	}
+	return ret
+}
+
+// progressReader wraps the input file, recording the bytes consumed.
+type progressReader struct {
+	io.Reader
+	tracker *progressTracker
+}
+
+// Read calls Read() on the underlying reader and records the bytes read.
+func (r *progressReader) Read(b []byte) (int, error) {
+	n, err := r.Reader.Read(b)
+	r.tracker.inputConsumed(n)
+	return n, err
+}
+
+// GetProgress returns a snapshot of the progress of the running scan.
+func GetProgress() *Progress {
+	return progress.snapshot()
+}
+
+// PauseIntake stops new targets from being read until ResumeIntake is called.
+// Targets already queued continue to be scanned.
+func PauseIntake() {
+	progress.setPaused(true)
+}
+
+// DrainIntake pauses intake and blocks until all targets already dispatched
+// have been scanned. Call ResumeIntake to continue the scan.
+func DrainIntake() {
+	PauseIntake()
+	progress.waitDrained()
+}
+
+// ResumeIntake resumes reading targets after a call to PauseIntake.
+func ResumeIntake() {
+	progress.setPaused(false)
+}
+
+// SetSenders changes the number of running send goroutines, or the number
+// that will be started if the scan is not yet running.
+func SetSenders(n int) error {
+	if n <= 0 {
+		return fmt.Errorf("need at least one sender, given %d", n)
+	}
+	progress.mutex.Lock()
+	senders := progress.senders
+	progress.mutex.Unlock()
+	if senders == nil {
+		// The scan has not started yet; just change the initial count.
+		config.Senders = n
+		return nil
+	}
+	return senders.request(n)
+}
+
+// newStatusHandler returns the handler for the status endpoint.
+//
+//	GET  /          returns the current Progress as JSON
+//	POST /pause     pauses target intake
+//	POST /resume    resumes target intake
+//	POST /drain     pauses target intake and waits for in-flight targets
+//	POST /senders   sets the sender count from the "count" parameter
+//
+// The POST endpoints are unauthenticated, so unless controls is true (with
+// --status-controls) they are refused.
+func newStatusHandler(controls bool) http.Handler {
+	mux := http.NewServeMux()
+	requirePost := func(w http.ResponseWriter, r *http.Request) bool {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return false
+		}
+		if !controls {
+			http.Error(w, "scan controls are disabled (see --status-controls)", http.StatusForbidden)
+			return false
+		}
+		return true
+	}
+	writeProgress := func(w http.ResponseWriter) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(GetProgress()); err != nil {
+			log.Errorf("could not write status: %v", err)
+		}
+	}
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		writeProgress(w)
+	})
+	mux.HandleFunc("/pause", func(w http.ResponseWriter, r *http.Request) {
+		if requirePost(w, r) {
+			PauseIntake()
+			writeProgress(w)
+		}
+	})
+	mux.HandleFunc("/resume", func(w http.ResponseWriter, r *http.Request) {
+		if requirePost(w, r) {
+			ResumeIntake()
+			writeProgress(w)
+		}
+	})
+	mux.HandleFunc("/drain", func(w http.ResponseWriter, r *http.Request) {
+		if requirePost(w, r) {
+			DrainIntake()
+			writeProgress(w)
+		}
+	})
+	mux.HandleFunc("/senders", func(w http.ResponseWriter, r *http.Request) {
+		if !requirePost(w, r) {
+			return
+		}
+		n, err := strconv.Atoi(r.FormValue("count"))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid count: %v", err), http.StatusBadRequest)
+			return
+		}
+		if err := SetSenders(n); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeProgress(w)
+	})
+	return mux
+}
@@ -0,0 +1,90 @@
+package zgrab2
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestProgressQueueDepth(t *testing.T) {
+	p := newProgressTracker()
+	if s := p.snapshot(); s.Queued != 0 || s.QueueSize != 0 {
+		t.Errorf("expected no queue before the scan starts, got %+v", s)
+	}
+	targets := make(chan ScanTarget, 4)
+	results := make(chan []byte, 4)
+	p.watchQueues(targets, results)
+	targets <- ScanTarget{}
+	targets <- ScanTarget{}
+	results <- []byte("{}")
+	s := p.snapshot()
+	if s.Queued != 2 || s.OutputQueued != 1 || s.QueueSize != 4 {
+		t.Errorf("expected 2 of 4 targets and 1 result queued, got %+v", s)
+	}
+}
+
+// statusRequest makes a request to the status handler, returning the status
+// code and, on success, the Progress.
+func statusRequest(t *testing.T, handler http.Handler, method, path string, form url.Values) (int, *Progress) {
+	req := httptest.NewRequest(method, path, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		return w.Code, nil
+	}
+	ret := new(Progress)
+	if err := json.Unmarshal(w.Body.Bytes(), ret); err != nil {
+		t.Fatalf("%s %s: invalid progress: %v", method, path, err)
+	}
+	return w.Code, ret
+}
+
+func TestStatusHandler(t *testing.T) {
+	defer func(p *progressTracker, senders int) {
+		progress = p
+		config.Senders = senders
+	}(progress, config.Senders)
+	progress = newProgressTracker()
+	config.Senders = 1
+
+	// Without --status-controls, only the status is served.
+	handler := newStatusHandler(false)
+	if code, p := statusRequest(t, handler, "GET", "/", nil); code != http.StatusOK || p.Paused {
+		t.Errorf("GET /: expected the status, got %d %+v", code, p)
+	}
+	for _, path := range []string{"/pause", "/resume", "/drain", "/senders"} {
+		if code, _ := statusRequest(t, handler, "POST", path, url.Values{"count": {"2"}}); code != http.StatusForbidden {
+			t.Errorf("POST %s: expected %d without controls, got %d", path, http.StatusForbidden, code)
+		}
+	}
+	if progress.snapshot().Paused || config.Senders != 1 {
+		t.Errorf("expected no change without controls")
+	}
+
+	handler = newStatusHandler(true)
+	if code, _ := statusRequest(t, handler, "GET", "/pause", nil); code != http.StatusMethodNotAllowed {
+		t.Errorf("GET /pause: expected %d, got %d", http.StatusMethodNotAllowed, code)
+	}
+	if code, p := statusRequest(t, handler, "POST", "/pause", nil); code != http.StatusOK || !p.Paused {
+		t.Errorf("POST /pause: expected to pause, got %d %+v", code, p)
+	}
+	if code, p := statusRequest(t, handler, "POST", "/resume", nil); code != http.StatusOK || p.Paused {
+		t.Errorf("POST /resume: expected to resume, got %d %+v", code, p)
+	}
+	if code, p := statusRequest(t, handler, "POST", "/drain", nil); code != http.StatusOK || !p.Paused {
+		t.Errorf("POST /drain: expected to pause, got %d %+v", code, p)
+	}
+	ResumeIntake()
+	if code, _ := statusRequest(t, handler, "POST", "/senders", url.Values{"count": {"3"}}); code != http.StatusOK || config.Senders != 3 {
+		t.Errorf("POST /senders: expected 3 senders, got %d, %d", code, config.Senders)
+	}
+	for _, count := range []string{"0", "many"} {
+		if code, _ := statusRequest(t, handler, "POST", "/senders", url.Values{"count": {count}}); code != http.StatusBadRequest {
+			t.Errorf("POST /senders count=%s: expected %d, got %d", count, http.StatusBadRequest, code)
+		}
+	}
+}
@@ -0,0 +1,286 @@
+package zgrab2
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zmap/zcrypto/tls"
+)
+
+// DNS transports usable with --dns-transports.
+const (
+	// dnsTransportUDP queries over UDP, retrying over TCP if the response is
+	// truncated.
+	dnsTransportUDP = "udp"
+
+	dnsTransportTCP = "tcp"
+
+	// dnsTransportTLS queries over DNS-over-TLS (RFC 7858).
+	dnsTransportTLS = "tls"
+)
+
+// resolverSystem is recorded as the resolver for names resolved by the
+// system resolver.
+const resolverSystem = "system"
+
+// Resolution records how the name of a target was resolved.
+type Resolution struct {
+	// Name is the name that was resolved.
+	Name string `json:"name"`
+
+	// Addresses are the addresses the name resolved to.
+	Addresses []string `json:"addresses,omitempty"`
+
+	// Resolver is the nameserver that answered, or "system" if the system
+	// resolver was used.
+	Resolver string `json:"resolver,omitempty"`
+
+	// Transport is the transport used to query the nameserver (udp, tcp or
+	// tls), if not using the system resolver.
+	Transport string `json:"transport,omitempty"`
+
+	// Cached is true if the result came from the in-process cache.
+	Cached bool `json:"cached,omitempty"`
+
+	// duration is the time the lookup took.
+	duration time.Duration
+}
+
+// dnsCacheEntry is a cached lookup result: either the resolution, or the
+// error for a name that does not exist.
+type dnsCacheEntry struct {
+	resolution *Resolution
+	addrs      []net.IPAddr
+	err        error
+	expires    time.Time
+}
+
+// dnsResolver resolves names for all connections made by the framework,
+// using either the system resolver or the configured nameservers, and caches
+// the results.
+type dnsResolver struct {
+	// servers are the nameservers given with --dns-resolvers, as host or
+	// host:port; if empty, the system resolver is used.
+	servers []string
+
+	// transports are the transports to query the servers over, in order.
+	transports []string
+
+	ttl         time.Duration
+	negativeTTL time.Duration
+	maxEntries  int
+
+	mutex sync.Mutex
+	cache map[string]*dnsCacheEntry
+	next  int
+}
+
+var resolver = &dnsResolver{cache: make(map[string]*dnsCacheEntry)}
+
+// parseDNSTransports parses the value of --dns-transports.
+func parseDNSTransports(s string) ([]string, error) {
+	var ret []string
+	for _, transport := range strings.Split(s, ",") {
+		transport = strings.ToLower(strings.TrimSpace(transport))
+		switch transport {
+		case "":
+		case dnsTransportUDP, dnsTransportTCP, dnsTransportTLS:
+			ret = append(ret, transport)
+		default:
+			return nil, fmt.Errorf("unknown DNS transport %q", transport)
+		}
+	}
+	if len(ret) == 0 {
+		return nil, fmt.Errorf("no DNS transports given")
+	}
+	return ret, nil
+}
+
+// parseDNSServers parses the value of --dns-resolvers.
+func parseDNSServers(s string) ([]string, error) {
+	var ret []string
+	for _, server := range strings.Split(s, ",") {
+		server = strings.TrimSpace(server)
+		if server == "" {
+			continue
+		}
+		host := server
+		if h, _, err := net.SplitHostPort(server); err == nil {
+			host = h
+		}
+		if net.ParseIP(host) == nil {
+			return nil, fmt.Errorf("invalid nameserver address %q", server)
+		}
+		ret = append(ret, server)
+	}
+	return ret, nil
+}
+
+// serverAddress returns the address of the nameserver to use for the given
+// transport, using the transport's default port if the server has none.
+func serverAddress(server, transport string) string {
+	if _, _, err := net.SplitHostPort(server); err == nil {
+		return server
+	}
+	if transport == dnsTransportTLS {
+		return net.JoinHostPort(server, "853")
+	}
+	return net.JoinHostPort(server, "53")
+}
+
+// lookup resolves the host, using the cache if possible.
+func (r *dnsResolver) lookup(ctx context.Context, host string) ([]net.IPAddr, *Resolution, error) {
+	now := time.Now()
+	r.mutex.Lock()
+	entry, ok := r.cache[host]
+	r.mutex.Unlock()
+	if ok && now.Before(entry.expires) {
+		if entry.err != nil {
+			return nil, nil, entry.err
+		}
+		cached := *entry.resolution
+		cached.Cached = true
+		cached.duration = time.Since(now)
+		return entry.addrs, &cached, nil
+	}
+
+	addrs, resolution, err := r.query(ctx, host)
+	if err == nil {
+		resolution.duration = time.Since(now)
+		r.store(host, &dnsCacheEntry{resolution: resolution, addrs: addrs, expires: now.Add(r.ttl)}, r.ttl)
+	} else if dnsErr, ok := err.(*net.DNSError); ok && !dnsErr.IsTimeout && !dnsErr.IsTemporary {
+		r.store(host, &dnsCacheEntry{err: err, expires: now.Add(r.negativeTTL)}, r.negativeTTL)
+	}
+	return addrs, resolution, err
+}
+
+// store adds an entry to the cache, making room for it if necessary.
+func (r *dnsResolver) store(host string, entry *dnsCacheEntry, ttl time.Duration) {
+	if ttl <= 0 || r.maxEntries <= 0 {
+		return
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if len(r.cache) >= r.maxEntries {
+		now := time.Now()
+		for key, old := range r.cache {
+			if !now.Before(old.expires) {
+				delete(r.cache, key)
+			}
+		}
+		// Map iteration order is random, so this evicts an arbitrary entry.
+		for key := range r.cache {
+			if len(r.cache) < r.maxEntries {
+				break
+			}
+			delete(r.cache, key)
+		}
+	}
+	r.cache[host] = entry
+}
+
+// query resolves the host without the cache.
+func (r *dnsResolver) query(ctx context.Context, host string) ([]net.IPAddr, *Resolution, error) {
+	var addrs []net.IPAddr
+	server, transport, err := r.resolve(ctx, func(res *net.Resolver) error {
+		var err error
+		addrs, err = res.LookupIPAddr(ctx, host)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return addrs, &Resolution{Name: host, Addresses: ipAddrStrings(addrs), Resolver: server, Transport: transport}, nil
+}
+
+// resolve calls f with the system resolver if no nameservers are
+// configured, or otherwise with a resolver for each of the transports in turn
+// until one gets an answer. It returns the nameserver that answered (or
+// "system") and the transport used.
+func (r *dnsResolver) resolve(ctx context.Context, f func(res *net.Resolver) error) (server, transport string, err error) {
+	if len(r.servers) == 0 {
+		return resolverSystem, "", f(net.DefaultResolver)
+	}
+	for _, transport := range r.transports {
+		record := new(dnsServerRecord)
+		err = f(&net.Resolver{PreferGo: true, Dial: r.dialer(transport, record)})
+		if err == nil {
+			return record.get(), transport, nil
+		}
+		if dnsErr, ok := err.(*net.DNSError); ok && !dnsErr.IsTimeout && !dnsErr.IsTemporary {
+			// The server answered (e.g. that the name does not exist), so
+			// there is no point in asking over another transport.
+			break
+		}
+	}
+	return "", "", err
+}
+
+// dnsServerRecord holds the address of the nameserver last dialed for a
+// lookup (the resolver may dial concurrently for A and AAAA queries).
+type dnsServerRecord struct {
+	mutex sync.Mutex
+	addr  string
+}
+
+func (s *dnsServerRecord) set(addr string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.addr = addr
+}
+
+func (s *dnsServerRecord) get() string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.addr
+}
+
+// dialer returns a Dial function for net.Resolver that connects to the next
+// configured nameserver over the given transport, ignoring the address of
+// the system nameserver that the resolver asks for, and records the address
+// of the nameserver in server.
+func (r *dnsResolver) dialer(transport string, server *dnsServerRecord) func(ctx context.Context, network, address string) (net.Conn, error) {
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		r.mutex.Lock()
+		addr := serverAddress(r.servers[r.next%len(r.servers)], transport)
+		r.next++
+		r.mutex.Unlock()
+		server.set(addr)
+		var dialer net.Dialer
+		switch transport {
+		case dnsTransportTCP:
+			network = "tcp"
+		case dnsTransportTLS:
+			conn, err := dialer.DialContext(ctx, "tcp", addr)
+			if err != nil {
+				return nil, err
+			}
+			if deadline, ok := ctx.Deadline(); ok {
+				conn.SetDeadline(deadline)
+			}
+			// Nameservers are given by address, so there is no name to
+			// verify the certificate against.
+			tlsConn := tls.Client(conn, &tls.Config{InsecureSkipVerify: true})
+			if err := tlsConn.Handshake(); err != nil {
+				conn.Close()
+				return nil, err
+			}
+			return tlsConn, nil
+		}
+		// For dnsTransportUDP, the resolver asks for TCP if the response is
+		// truncated.
+		return dialer.DialContext(ctx, network, addr)
+	}
+}
+
+func ipAddrStrings(addrs []net.IPAddr) []string {
+	ret := make([]string, len(addrs))
+	for i, addr := range addrs {
+		ret[i] = addr.IP.String()
+	}
+	return ret
+}
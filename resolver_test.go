@@ -0,0 +1,87 @@
+package zgrab2
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestParseDNSServers(t *testing.T) {
+	servers, err := parseDNSServers("192.0.2.53, [2001:db8::53]:5353,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(servers) != 2 || servers[0] != "192.0.2.53" || servers[1] != "[2001:db8::53]:5353" {
+		t.Errorf("unexpected servers %v", servers)
+	}
+	if _, err := parseDNSServers("dns.example.com"); err == nil {
+		t.Errorf("expected an error for a nameserver given by name")
+	}
+	if got := serverAddress("192.0.2.53", dnsTransportTLS); got != "192.0.2.53:853" {
+		t.Errorf("expected the DoT port, got %s", got)
+	}
+	if got := serverAddress("192.0.2.53", dnsTransportUDP); got != "192.0.2.53:53" {
+		t.Errorf("expected the DNS port, got %s", got)
+	}
+}
+
+func TestParseDNSTransports(t *testing.T) {
+	transports, err := parseDNSTransports("UDP,tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(transports) != 2 || transports[0] != dnsTransportUDP || transports[1] != dnsTransportTLS {
+		t.Errorf("unexpected transports %v", transports)
+	}
+	for _, bad := range []string{"", "https"} {
+		if _, err := parseDNSTransports(bad); err == nil {
+			t.Errorf("expected an error for %q", bad)
+		}
+	}
+}
+
+func TestResolverCache(t *testing.T) {
+	r := &dnsResolver{
+		cache:       make(map[string]*dnsCacheEntry),
+		ttl:         time.Minute,
+		negativeTTL: time.Minute,
+		maxEntries:  2,
+	}
+	addrs := []net.IPAddr{{IP: net.ParseIP("192.0.2.1")}}
+	r.store("cached.example", &dnsCacheEntry{
+		resolution: &Resolution{Name: "cached.example", Addresses: []string{"192.0.2.1"}, Resolver: "192.0.2.53:53", Transport: dnsTransportUDP},
+		addrs:      addrs,
+		expires:    time.Now().Add(time.Minute),
+	}, r.ttl)
+	notFound := &net.DNSError{Err: "no such host", Name: "missing.example"}
+	r.store("missing.example", &dnsCacheEntry{err: notFound, expires: time.Now().Add(time.Minute)}, r.negativeTTL)
+
+	got, resolution, err := r.lookup(context.Background(), "cached.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || !got[0].IP.Equal(addrs[0].IP) {
+		t.Errorf("unexpected addresses %v", got)
+	}
+	if !resolution.Cached || resolution.Resolver != "192.0.2.53:53" {
+		t.Errorf("unexpected resolution %+v", resolution)
+	}
+	if _, _, err := r.lookup(context.Background(), "missing.example"); err != notFound {
+		t.Errorf("expected the cached error, got %v", err)
+	}
+
+	// The cache is full, so a new entry evicts one of the others.
+	r.store("new.example", &dnsCacheEntry{err: notFound, expires: time.Now().Add(time.Minute)}, r.negativeTTL)
+	if len(r.cache) != 2 || r.cache["new.example"] == nil {
+		t.Errorf("expected the new entry to replace an old one, got %d entries", len(r.cache))
+	}
+}
+
+func TestResolverCacheDisabled(t *testing.T) {
+	r := &dnsResolver{cache: make(map[string]*dnsCacheEntry), maxEntries: 10}
+	r.store("example.com", &dnsCacheEntry{resolution: &Resolution{Name: "example.com"}, expires: time.Now().Add(time.Minute)}, 0)
+	if len(r.cache) != 0 {
+		t.Errorf("expected nothing to be cached with a zero TTL")
+	}
+}
@@ -0,0 +1,263 @@
+package zgrab2
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Runner runs scans on behalf of a program embedding zgrab2, independently of
+// the command-line configuration and the global scanner registry used by the
+// zgrab2 binary. Modules are still looked up by name in the module registry,
+// so importing github.com/zmap/zgrab2/modules makes all of the built-in
+// modules available.
+//
+// Example:
+//
+//	flags, _ := zgrab2.NewModuleFlags("http")
+//	flags.(*http.Flags).Endpoint = "/index.html"
+//	runner := zgrab2.NewRunner(100)
+//	if _, err := runner.AddModule("http", flags); err != nil {
+//		return err
+//	}
+//	for grab := range runner.Run(targets) {
+//		result := grab.Data["http"].Result.(*http.Results)
+//		...
+//	}
+type Runner struct {
+	// Senders is the number of goroutines used to scan targets concurrently.
+	Senders int
+
+	// ContinueOnError causes the remaining scanners to be run on a target even
+	// after one of them fails.
+	ContinueOnError bool
+
+	// Monitor, if non-nil, collects per-scanner success / failure counts.
+	Monitor *Monitor
+
+	// TargetTimeout, if positive, is the total time budget for all scanners
+	// on a single target.
+	TargetTimeout time.Duration
+
+	scanners []Scanner
+	names    map[string]bool
+}
+
+// NewRunner returns a Runner that scans with the given number of senders.
+func NewRunner(senders int) *Runner {
+	return &Runner{
+		Senders:         senders,
+		ContinueOnError: true,
+		names:           make(map[string]bool),
+	}
+}
+
+// AddScanner adds an initialized scanner to the runner. Scanners are run on
+// each target in the order they were added.
+func (r *Runner) AddScanner(s Scanner) error {
+	if r.names == nil {
+		r.names = make(map[string]bool)
+	}
+	if r.names[s.GetName()] {
+		return fmt.Errorf("name: %s already used", s.GetName())
+	}
+	if err := policy.permit(s); err != nil {
+		return err
+	}
+	r.names[s.GetName()] = true
+	r.scanners = append(r.scanners, s)
+	return nil
+}
+
+// AddModule creates a scanner for the named module, initializes it with the
+// given flags (or the module's defaults, if flags is nil), and adds it to the
+// runner.
+func (r *Runner) AddModule(moduleName string, flags ScanFlags) (Scanner, error) {
+	mod := GetModule(moduleName)
+	if mod == nil {
+		return nil, fmt.Errorf("unknown module %s", moduleName)
+	}
+	if flags == nil {
+		var err error
+		if flags, err = NewModuleFlags(moduleName); err != nil {
+			return nil, err
+		}
+	}
+	if err := flags.Validate(nil); err != nil {
+		return nil, err
+	}
+	s := mod.NewScanner()
+	if err := s.Init(flags); err != nil {
+		return nil, err
+	}
+	if err := r.AddScanner(s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Run scans each target received on targets with every scanner, and delivers
+// the results on the returned channel, which is closed once targets has been
+// closed and all scans have completed.
+func (r *Runner) Run(targets <-chan ScanTarget) <-chan Grab {
+	senders := r.Senders
+	if senders <= 0 {
+		senders = 1
+	}
+	results := make(chan Grab, senders)
+	var done sync.WaitGroup
+	done.Add(senders)
+	for i := 0; i < senders; i++ {
+		go func(i int) {
+			defer done.Done()
+			for _, scanner := range r.scanners {
+				scanner.InitPerSender(i)
+			}
+			for target := range targets {
+				results <- scanTarget(target, r.scanners, r.Monitor, r.ContinueOnError, r.TargetTimeout)
+			}
+		}(i)
+	}
+	go func() {
+		done.Wait()
+		close(results)
+	}()
+	return results
+}
+
+// NewModuleFlags returns a new flags object for the named module, populated
+// with the defaults that would be used on the command line.
+func NewModuleFlags(moduleName string) (ScanFlags, error) {
+	mod := GetModule(moduleName)
+	if mod == nil {
+		return nil, fmt.Errorf("unknown module %s", moduleName)
+	}
+	raw := mod.NewFlags()
+	flags, ok := raw.(ScanFlags)
+	if !ok {
+		return nil, ErrMismatchedFlags
+	}
+	if err := SetFlagDefaults(raw); err != nil {
+		return nil, err
+	}
+	if base := GetBaseFlags(raw); base != nil {
+		base.Name = moduleName
+		base.Port = moduleDefaultPorts[moduleName]
+		if ports, ok := moduleDefaultPortLists[moduleName]; ok {
+			base.DefaultPorts = formatDefaultPorts(ports)
+		}
+	}
+	// Modules set the default for --transports when they register their
+	// command, rather than in a tag (see TransportFlags).
+	if cmd := parser.Find(moduleName); cmd != nil {
+		transports := reflect.ValueOf(raw).Elem().FieldByName("Transports")
+		if option := cmd.FindOptionByLongName("transports"); option != nil && len(option.Default) > 0 &&
+			transports.IsValid() && transports.Kind() == reflect.String && transports.String() == "" {
+			transports.SetString(option.Default[0])
+		}
+	}
+	return flags, nil
+}
+
+// GetBaseFlags returns the BaseFlags embedded in the given pointer to a
+// module's flags struct, or nil if there are none.
+func GetBaseFlags(flags interface{}) *BaseFlags {
+	v := reflect.ValueOf(flags)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return nil
+	}
+	base := v.Elem().FieldByName("BaseFlags")
+	if !base.IsValid() || base.Type() != reflect.TypeOf(BaseFlags{}) {
+		return nil
+	}
+	return base.Addr().Interface().(*BaseFlags)
+}
+
+// SetFlagDefaults walks the given pointer to a flags struct (including any
+// embedded structs), setting each zero-valued field that has a `default` tag
+// to the tag's value.
+func SetFlagDefaults(flags interface{}) error {
+	v := reflect.ValueOf(flags)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return errors.New("flags must be a pointer to a struct")
+	}
+	return setStructDefaults(v.Elem())
+}
+
+func setStructDefaults(v reflect.Value) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		value := v.Field(i)
+		if !value.CanSet() {
+			continue
+		}
+		if field.Anonymous && value.Kind() == reflect.Struct {
+			if err := setStructDefaults(value); err != nil {
+				return err
+			}
+			continue
+		}
+		def, ok := field.Tag.Lookup("default")
+		if !ok || !isZero(value) {
+			continue
+		}
+		if err := setFromString(value, def); err != nil {
+			return fmt.Errorf("invalid default %q for %s: %v", def, field.Name, err)
+		}
+	}
+	return nil
+}
+
+func isZero(v reflect.Value) bool {
+	return reflect.DeepEqual(v.Interface(), reflect.Zero(v.Type()).Interface())
+}
+
+// durationType is the type of time.Duration, which is parsed from strings like
+// "10s" rather than as a plain integer.
+var durationType = reflect.TypeOf(time.Duration(0))
+
+func setFromString(v reflect.Value, s string) error {
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 0, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 0, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(s, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(n)
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
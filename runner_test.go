@@ -0,0 +1,83 @@
+package zgrab2
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// echoScanner is a Scanner that returns the target's domain as its result.
+type echoScanner struct {
+	name string
+}
+
+func (s *echoScanner) Init(flags ScanFlags) error       { return nil }
+func (s *echoScanner) InitPerSender(senderID int) error { return nil }
+func (s *echoScanner) GetName() string                  { return s.name }
+func (s *echoScanner) GetTrigger() string               { return "" }
+func (s *echoScanner) Protocol() string                 { return "echo" }
+func (s *echoScanner) Scan(t ScanTarget) (ScanStatus, interface{}, error) {
+	return SCAN_SUCCESS, t.Domain, nil
+}
+
+func TestRunner(t *testing.T) {
+	runner := NewRunner(4)
+	if err := runner.AddScanner(&echoScanner{name: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := runner.AddScanner(&echoScanner{name: "b"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := runner.AddScanner(&echoScanner{name: "a"}); err == nil {
+		t.Error("expected error adding duplicate scanner name")
+	}
+
+	targets := make(chan ScanTarget)
+	results := runner.Run(targets)
+	go func() {
+		for _, domain := range []string{"one.example", "two.example", "three.example"} {
+			targets <- ScanTarget{IP: net.ParseIP("127.0.0.1"), Domain: domain}
+		}
+		close(targets)
+	}()
+	count := 0
+	for grab := range results {
+		count++
+		for _, name := range []string{"a", "b"} {
+			res, ok := grab.Data[name]
+			if !ok {
+				t.Errorf("%s: missing result for scanner %s", grab.Domain, name)
+				continue
+			}
+			if res.Status != SCAN_SUCCESS || res.Result != grab.Domain {
+				t.Errorf("%s: unexpected response from %s: %#v", grab.Domain, name, res)
+			}
+		}
+	}
+	if count != 3 {
+		t.Errorf("expected 3 results, got %d", count)
+	}
+}
+
+func TestSetFlagDefaults(t *testing.T) {
+	type flags struct {
+		BaseFlags
+		Endpoint string `default:"/"`
+		Retries  int    `default:"3"`
+		Verbose  bool   `default:"true"`
+		Explicit string `default:"ignored"`
+	}
+	f := flags{Explicit: "kept"}
+	if err := SetFlagDefaults(&f); err != nil {
+		t.Fatal(err)
+	}
+	if f.Timeout != 10*time.Second {
+		t.Errorf("expected embedded Timeout 10s, got %s", f.Timeout)
+	}
+	if f.Endpoint != "/" || f.Retries != 3 || !f.Verbose {
+		t.Errorf("defaults not applied: %#v", f)
+	}
+	if f.Explicit != "kept" {
+		t.Errorf("explicit value overwritten: %s", f.Explicit)
+	}
+}
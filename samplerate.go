@@ -0,0 +1,82 @@
+package zgrab2
+
+import (
+	"fmt"
+	"math"
+	"sync/atomic"
+)
+
+// Sampling records the --sample-rate a scan was run with, in the summary.
+type Sampling struct {
+	Rate float64 `json:"rate"`
+	Seed uint64  `json:"seed"`
+
+	// Sampled is the number of targets chosen, and Skipped the number left
+	// out.
+	Sampled uint64 `json:"sampled"`
+	Skipped uint64 `json:"skipped"`
+}
+
+// targetSampleFilter implements --sample-rate, choosing each target with
+// the given probability by a hash of its address (or name) and port keyed
+// with the seed. The choice depends only on the target and the seed, so a
+// pilot scan can be repeated, and is independent of --shard.
+type targetSampleFilter struct {
+	rate float64
+	seed uint64
+
+	// threshold is the rate as a fraction of 2^64: targets whose keyed
+	// hash is below it are chosen.
+	threshold uint64
+
+	sampled uint64
+	skipped uint64
+}
+
+var sampleFilter *targetSampleFilter
+
+func newTargetSampleFilter(rate float64, seed uint64) (*targetSampleFilter, error) {
+	if !(rate > 0 && rate <= 1) {
+		return nil, fmt.Errorf("sample rate must be above 0 and at most 1, given %v", rate)
+	}
+	ret := &targetSampleFilter{rate: rate, seed: seed, threshold: math.MaxUint64}
+	if rate < 1 {
+		ret.threshold = uint64(rate * (1 << 64))
+	}
+	return ret, nil
+}
+
+// mix64 is the finalizer of SplitMix64, which spreads the bits of the
+// keyed hash so that it is uniform whatever the seed.
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// selected returns true if the target is in the sample, counting it.
+func (s *targetSampleFilter) selected(target *ScanTarget) bool {
+	if s.threshold == math.MaxUint64 || mix64(shardKey(target)^mix64(s.seed)) < s.threshold {
+		atomic.AddUint64(&s.sampled, 1)
+		return true
+	}
+	atomic.AddUint64(&s.skipped, 1)
+	return false
+}
+
+// GetSampling returns the parameters and counts of --sample-rate, or nil if
+// it was not given.
+func GetSampling() *Sampling {
+	if sampleFilter == nil {
+		return nil
+	}
+	return &Sampling{
+		Rate:    sampleFilter.rate,
+		Seed:    sampleFilter.seed,
+		Sampled: atomic.LoadUint64(&sampleFilter.sampled),
+		Skipped: atomic.LoadUint64(&sampleFilter.skipped),
+	}
+}
@@ -0,0 +1,39 @@
+package zgrab2
+
+import (
+	"net"
+	"testing"
+)
+
+func TestTargetSampleFilter(t *testing.T) {
+	for _, bad := range []float64{0, -0.5, 1.5} {
+		if _, err := newTargetSampleFilter(bad, 0); err == nil {
+			t.Errorf("%v: expected an error", bad)
+		}
+	}
+	const targets = 20000
+	s, _ := newTargetSampleFilter(0.1, 42)
+	again, _ := newTargetSampleFilter(0.1, 42)
+	other, _ := newTargetSampleFilter(0.1, 43)
+	differ := 0
+	for i := 0; i < targets; i++ {
+		target := &ScanTarget{IP: net.IPv4(10, byte(i>>16), byte(i>>8), byte(i))}
+		chosen := s.selected(target)
+		if again.selected(target) != chosen {
+			t.Fatalf("%s chosen differently with the same seed", target)
+		}
+		if other.selected(target) != chosen {
+			differ++
+		}
+	}
+	if s.sampled < targets/10*8/10 || s.sampled > targets/10*12/10 || s.sampled+s.skipped != targets {
+		t.Errorf("sampled %d of %d targets", s.sampled, s.sampled+s.skipped)
+	}
+	if differ == 0 {
+		t.Error("the seed does not change the sample")
+	}
+	all, _ := newTargetSampleFilter(1, 0)
+	if !all.selected(&ScanTarget{Domain: "example.com"}) {
+		t.Error("a rate of 1 must choose every target")
+	}
+}
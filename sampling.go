@@ -0,0 +1,112 @@
+package zgrab2
+
+import (
+	"sync"
+)
+
+// successSampler stops a scan early once enough successful grabs have been
+// collected, for --max-successes: overall, counting targets on which any
+// module succeeded, and per module, counting the targets each module
+// succeeded on.
+type successSampler struct {
+	mutex sync.Mutex
+
+	// max is the global limit, or 0 for none.
+	max       int
+	successes int
+
+	// moduleMax maps a scanner name to its limit, if it has one.
+	moduleMax       map[string]int
+	moduleSuccesses map[string]int
+}
+
+var sampler = newSuccessSampler()
+
+func newSuccessSampler() *successSampler {
+	return &successSampler{
+		moduleMax:       make(map[string]int),
+		moduleSuccesses: make(map[string]int),
+	}
+}
+
+// LimitSuccesses stops the scanner from being run on new targets once it
+// has succeeded on max of them (if max is positive). Once every registered
+// scanner has reached its limit, no new targets are dispatched.
+func LimitSuccesses(s Scanner, max int) {
+	sampler.mutex.Lock()
+	defer sampler.mutex.Unlock()
+	if max > 0 {
+		sampler.moduleMax[s.GetName()] = max
+	} else {
+		delete(sampler.moduleMax, s.GetName())
+	}
+}
+
+// moduleDone returns true if the named scanner has reached its limit; the
+// caller must hold the mutex.
+func (s *successSampler) moduleDone(name string) bool {
+	max, ok := s.moduleMax[name]
+	return ok && s.moduleSuccesses[name] >= max
+}
+
+// active returns the scanners that have not reached their limits.
+func (s *successSampler) active(scanners []Scanner) []Scanner {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if len(s.moduleMax) == 0 {
+		return scanners
+	}
+	ret := make([]Scanner, 0, len(scanners))
+	for _, scanner := range scanners {
+		if !s.moduleDone(scanner.GetName()) {
+			ret = append(ret, scanner)
+		}
+	}
+	return ret
+}
+
+// done returns true if no more targets need to be dispatched: the global
+// limit has been reached, or every scanner has reached its own limit.
+func (s *successSampler) done(scanners []Scanner) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.max > 0 && s.successes >= s.max {
+		return true
+	}
+	if len(s.moduleMax) == 0 || len(scanners) == 0 {
+		return false
+	}
+	for _, scanner := range scanners {
+		if !s.moduleDone(scanner.GetName()) {
+			return false
+		}
+	}
+	return true
+}
+
+// record counts the successes in the grab produced by running the scanners
+// on a target.
+func (s *successSampler) record(scanners []Scanner, grab *Grab) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	succeeded := false
+	for _, scanner := range scanners {
+		names, _ := portTargets(scanner, ScanTarget{})
+		for _, name := range names {
+			if res, ok := grab.Data[name]; ok && res.Status == SCAN_SUCCESS {
+				s.moduleSuccesses[scanner.GetName()]++
+				succeeded = true
+				break
+			}
+		}
+	}
+	if succeeded {
+		s.successes++
+	}
+}
+
+// MaxSuccessesReached returns true if the scan stopped dispatching targets
+// because of --max-successes.
+func MaxSuccessesReached() bool {
+	return sampler.done(registeredScanners())
+}
@@ -0,0 +1,60 @@
+package zgrab2
+
+import (
+	"testing"
+)
+
+func grabWith(statuses map[string]ScanStatus) *Grab {
+	grab := &Grab{Data: make(map[string]ScanResponse)}
+	for name, status := range statuses {
+		grab.Data[name] = ScanResponse{Status: status}
+	}
+	return grab
+}
+
+func TestSuccessSamplerGlobal(t *testing.T) {
+	s := newSuccessSampler()
+	s.max = 2
+	scanners := []Scanner{&echoScanner{name: "a"}, &echoScanner{name: "b"}}
+	s.record(scanners, grabWith(map[string]ScanStatus{"a": SCAN_CONNECTION_REFUSED, "b": SCAN_IO_TIMEOUT}))
+	s.record(scanners, grabWith(map[string]ScanStatus{"a": SCAN_SUCCESS, "b": SCAN_SUCCESS}))
+	if s.done(scanners) {
+		t.Fatal("done after one successful target")
+	}
+	s.record(scanners, grabWith(map[string]ScanStatus{"a": SCAN_PROTOCOL_ERROR, "b": SCAN_SUCCESS}))
+	if !s.done(scanners) {
+		t.Error("not done after two successful targets")
+	}
+}
+
+func TestSuccessSamplerPerModule(t *testing.T) {
+	defer func(old *successSampler) { sampler = old }(sampler)
+	sampler = newSuccessSampler()
+	a, b := &echoScanner{name: "a"}, &echoScanner{name: "b"}
+	scanners := []Scanner{a, b}
+	LimitSuccesses(a, 1)
+
+	sampler.record(scanners, grabWith(map[string]ScanStatus{"a": SCAN_SUCCESS, "b": SCAN_SUCCESS}))
+	if active := sampler.active(scanners); len(active) != 1 || active[0] != b {
+		t.Errorf("expected only b to be active, got %v", active)
+	}
+	if sampler.done(scanners) {
+		t.Error("done while b has no limit")
+	}
+
+	LimitSuccesses(b, 2)
+	sampler.record([]Scanner{b}, grabWith(map[string]ScanStatus{"b": SCAN_SUCCESS}))
+	if !sampler.done(scanners) {
+		t.Error("not done after every scanner reached its limit")
+	}
+}
+
+func TestSuccessSamplerMultiPort(t *testing.T) {
+	s := newSuccessSampler()
+	s.max = 1
+	scanner := &multiPortScanner{echoScanner: echoScanner{name: "http"}, ports: []DefaultPort{{Port: 80}, {Port: 443, TLS: true}}}
+	s.record([]Scanner{scanner}, grabWith(map[string]ScanStatus{"http": SCAN_CONNECTION_REFUSED, "http:443": SCAN_SUCCESS}))
+	if !s.done([]Scanner{scanner}) {
+		t.Error("success on the second port not counted")
+	}
+}
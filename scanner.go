@@ -3,7 +3,11 @@ package zgrab2
 import (
 	"fmt"
 	"log"
+	"runtime/debug"
+	"sync"
 	"time"
+
+	"github.com/sirupsen/logrus"
 )
 
 var scanners map[string]*Scanner
@@ -15,10 +19,25 @@ func RegisterScan(name string, s Scanner) {
 	if scanners[name] != nil {
 		log.Fatalf("name: %s already used", name)
 	}
+	if err := policy.permit(s); err != nil {
+		log.Fatal(err)
+	}
+	if err := checkICSSafe(s); err != nil {
+		log.Fatal(err)
+	}
 	orderedScanners = append(orderedScanners, name)
 	scanners[name] = &s
 }
 
+// registeredScanners returns the registered scanners, in registration order.
+func registeredScanners() []Scanner {
+	ret := make([]Scanner, len(orderedScanners))
+	for i, name := range orderedScanners {
+		ret[i] = *scanners[name]
+	}
+	return ret
+}
+
 // PrintScanners prints all registered scanners
 func PrintScanners() {
 	for k, v := range scanners {
@@ -26,20 +45,90 @@ func PrintScanners() {
 	}
 }
 
-// RunScanner runs a single scan on a target and returns the resulting data
+// ScanPanic is the result returned in place of the module's result when its
+// Scan() call panics.
+type ScanPanic struct {
+	// Panic is the string representation of the value passed to panic().
+	Panic string `json:"panic"`
+
+	// Stack is the stack trace at the point of the panic.
+	Stack string `json:"stack,omitempty" zgrab:"debug"`
+}
+
+// safeScan calls s.Scan(target), recovering from any panic and converting it
+// into a SCAN_INTERNAL_ERROR, so that a single misbehaving module or target
+// cannot take down the entire scan.
+func safeScan(s Scanner, target ScanTarget) (status ScanStatus, res interface{}, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			stack := string(debug.Stack())
+			logrus.Errorf("Panic on scanner %s when scanning target %s: %v", s.GetName(), target.String(), e)
+			logrus.Debugf("Stack trace for panic on scanner %s: %s", s.GetName(), stack)
+			status = SCAN_INTERNAL_ERROR
+			res = &ScanPanic{Panic: fmt.Sprintf("%v", e), Stack: stack}
+			err = fmt.Errorf("panic in scanner %s: %v", s.GetName(), e)
+		}
+	}()
+	return s.Scan(target)
+}
+
+// sequence numbers the results of scans as they end.
+var sequence struct {
+	mutex sync.Mutex
+	last  uint64
+}
+
+// endScan returns the time a scan ended and the sequence number of its
+// result. Sequence numbers are handed out in the order of the end times.
+func endScan() (time.Time, uint64) {
+	sequence.mutex.Lock()
+	defer sequence.mutex.Unlock()
+	sequence.last++
+	return time.Now(), sequence.last
+}
+
+// RunScanner runs a single scan on a target and returns the resulting data.
+// The monitor may be nil.
 func RunScanner(s Scanner, mon *Monitor, target ScanTarget) (string, ScanResponse) {
 	t := time.Now()
-	status, res, e := s.Scan(target)
+	target.log = new(scanLog)
+	target.ics = icsGuard(s)
+	transport, status, res, e := scanTransports(s, target)
+	end, seq := endScan()
 	var err *string
-	if e == nil {
-		mon.statusesChan <- moduleStatus{name: s.GetName(), st: statusSuccess}
-		err = nil
-	} else {
-		mon.statusesChan <- moduleStatus{name: s.GetName(), st: statusFailure}
+	st := statusSuccess
+	if e != nil {
+		st = statusFailure
 		errString := e.Error()
 		err = &errString
 	}
+	if mon != nil {
+		mon.statusesChan <- moduleStatus{name: s.GetName(), st: st}
+	}
 	resp := ScanResponse{Result: res, Protocol: s.Protocol(), Error: err, Timestamp: t.Format(time.RFC3339), Status: status}
+	resp.StartTime = t.Format(time.RFC3339Nano)
+	resp.EndTime = end.Format(time.RFC3339Nano)
+	resp.Sequence = seq
+	resp.Rejection = GetRejectionReason(e)
+	resp.ProxyError = GetProxyError(e)
+	resp.ICSSafe = target.ics != nil
+	target.log.mutex.Lock()
+	resp.AddressFamily = target.log.addressFamily
+	resp.NAT64Address = target.log.nat64Address
+	resp.Resolution = target.log.resolution
+	resp.Timing = target.log.phases.timing(time.Since(t))
+	resp.Amplification = target.log.datagrams.amplification()
+	target.log.mutex.Unlock()
+	resp.Transport = transport
+	resp.Product = identifyProduct(s, res)
+	resp.ClockSkew = measureClockSkew(s, res, t, end)
+	if target.Port != nil {
+		resp.Port = *target.Port
+	} else if p, ok := s.(interface {
+		GetPort() uint
+	}); ok {
+		resp.Port = p.GetPort()
+	}
 	return s.GetName(), resp
 }
 
@@ -0,0 +1,120 @@
+package zgrab2
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// panicScanner is a Scanner whose Scan() always panics.
+type panicScanner struct{}
+
+func (s *panicScanner) Init(flags ScanFlags) error       { return nil }
+func (s *panicScanner) InitPerSender(senderID int) error { return nil }
+func (s *panicScanner) GetName() string                  { return "panic" }
+func (s *panicScanner) GetTrigger() string               { return "" }
+func (s *panicScanner) Protocol() string                 { return "panic" }
+func (s *panicScanner) Scan(t ScanTarget) (ScanStatus, interface{}, error) {
+	var m map[string]int
+	m["boom"] = 1
+	return SCAN_SUCCESS, nil, nil
+}
+
+func TestSafeScanRecoversPanic(t *testing.T) {
+	status, res, err := safeScan(&panicScanner{}, ScanTarget{IP: net.ParseIP("127.0.0.1")})
+	if status != SCAN_INTERNAL_ERROR {
+		t.Errorf("expected status %s, got %s", SCAN_INTERNAL_ERROR, status)
+	}
+	if err == nil {
+		t.Errorf("expected non-nil error")
+	}
+	log, ok := res.(*ScanPanic)
+	if !ok {
+		t.Fatalf("expected *ScanPanic result, got %T", res)
+	}
+	if log.Panic == "" || log.Stack == "" {
+		t.Errorf("expected panic value and stack to be populated, got %#v", log)
+	}
+}
+
+// slowScanner is a Scanner that sleeps before succeeding.
+type slowScanner struct {
+	name  string
+	delay time.Duration
+}
+
+func (s *slowScanner) Init(flags ScanFlags) error       { return nil }
+func (s *slowScanner) InitPerSender(senderID int) error { return nil }
+func (s *slowScanner) GetName() string                  { return s.name }
+func (s *slowScanner) GetTrigger() string               { return "" }
+func (s *slowScanner) Protocol() string                 { return "slow" }
+func (s *slowScanner) Scan(t ScanTarget) (ScanStatus, interface{}, error) {
+	time.Sleep(s.delay)
+	return SCAN_SUCCESS, nil, nil
+}
+
+func TestScanTargetBudget(t *testing.T) {
+	scanners := []Scanner{
+		&slowScanner{name: "first", delay: 50 * time.Millisecond},
+		&slowScanner{name: "second", delay: 0},
+	}
+	grab := scanTarget(ScanTarget{IP: net.ParseIP("127.0.0.1")}, scanners, nil, true, 10*time.Millisecond)
+	if status := grab.Data["first"].Status; status != SCAN_SUCCESS {
+		t.Errorf("expected first scan to succeed, got %s", status)
+	}
+	if status := grab.Data["second"].Status; status != SCAN_TARGET_TIMEOUT {
+		t.Errorf("expected second scan to be skipped with %s, got %s", SCAN_TARGET_TIMEOUT, status)
+	}
+
+	grab = scanTarget(ScanTarget{IP: net.ParseIP("127.0.0.1")}, scanners, nil, true, 0)
+	if status := grab.Data["second"].Status; status != SCAN_SUCCESS {
+		t.Errorf("expected second scan to run without a budget, got %s", status)
+	}
+}
+
+// rejectedScanner is a Scanner whose Scan() is always refused.
+type rejectedScanner struct{}
+
+func (s *rejectedScanner) Init(flags ScanFlags) error       { return nil }
+func (s *rejectedScanner) InitPerSender(senderID int) error { return nil }
+func (s *rejectedScanner) GetName() string                  { return "rejected" }
+func (s *rejectedScanner) GetTrigger() string               { return "" }
+func (s *rejectedScanner) Protocol() string                 { return "rejected" }
+func (s *rejectedScanner) Scan(t ScanTarget) (ScanStatus, interface{}, error) {
+	err := NewRejectionError(REJECTED_ENCRYPTION_REQUIRED, errors.New("server requires encryption"))
+	return TryGetScanStatus(err), nil, err
+}
+
+func TestRunScannerRejection(t *testing.T) {
+	_, resp := RunScanner(&rejectedScanner{}, nil, ScanTarget{IP: net.ParseIP("127.0.0.1")})
+	if resp.Status != SCAN_APPLICATION_ERROR || resp.Rejection != REJECTED_ENCRYPTION_REQUIRED {
+		t.Errorf("expected %s with rejection %s, got %s with rejection %q", SCAN_APPLICATION_ERROR, REJECTED_ENCRYPTION_REQUIRED, resp.Status, resp.Rejection)
+	}
+	if resp.Error == nil || *resp.Error != "server requires encryption" {
+		t.Errorf("wrong error %v", resp.Error)
+	}
+	_, resp = RunScanner(&slowScanner{name: "slow"}, nil, ScanTarget{IP: net.ParseIP("127.0.0.1")})
+	if resp.Rejection != "" {
+		t.Errorf("successful scan has rejection %q", resp.Rejection)
+	}
+}
+
+func TestRunScannerSequence(t *testing.T) {
+	_, first := RunScanner(&slowScanner{name: "first", delay: time.Millisecond}, nil, ScanTarget{IP: net.ParseIP("127.0.0.1")})
+	_, second := RunScanner(&slowScanner{name: "second"}, nil, ScanTarget{IP: net.ParseIP("127.0.0.1")})
+	if first.Sequence == 0 || second.Sequence <= first.Sequence {
+		t.Errorf("sequence numbers %d and %d are not increasing", first.Sequence, second.Sequence)
+	}
+	start, err := time.Parse(time.RFC3339Nano, first.StartTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	end, err := time.Parse(time.RFC3339Nano, first.EndTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if end.Sub(start) < time.Millisecond {
+		t.Errorf("scan from %s to %s is too short", first.StartTime, first.EndTime)
+	}
+}
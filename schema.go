@@ -0,0 +1,253 @@
+package zgrab2
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Output schemas, selected with --output-schema.
+const (
+	// outputSchemaZGrab2 is zgrab2's own output: one line per target, with a
+	// scan response for each module.
+	outputSchemaZGrab2 = "zgrab2"
+
+	// outputSchemaCensys is shaped like Censys host records: one line per
+	// target, with a services array giving each responsive port.
+	outputSchemaCensys = "censys"
+
+	// outputSchemaShodan is shaped like Shodan banners: one line per
+	// identified service.
+	outputSchemaShodan = "shodan"
+)
+
+// tlsServiceNames gives the conventional names of protocols when wrapped in
+// TLS, for Censys' extended_service_name.
+var tlsServiceNames = map[string]string{
+	"HTTP": "HTTPS",
+	"SMTP": "SMTPS",
+	"IMAP": "IMAPS",
+	"POP3": "POP3S",
+	"FTP":  "FTPS",
+	"LDAP": "LDAPS",
+}
+
+// serviceResponded returns true if the scan reached a service on the port,
+// whether or not it spoke the module's protocol.
+func serviceResponded(status ScanStatus) bool {
+	switch status {
+	case SCAN_SUCCESS, SCAN_APPLICATION_ERROR, SCAN_PROTOCOL_ERROR, SCAN_IO_TIMEOUT, SCAN_CONNECTION_CLOSED:
+		return true
+	}
+	return false
+}
+
+// serviceIdentified returns true if the scan found the module's protocol on
+// the port.
+func serviceIdentified(status ScanStatus) bool {
+	return status == SCAN_SUCCESS || status == SCAN_APPLICATION_ERROR
+}
+
+// responseTransport returns the transport a scan response was made over.
+func responseTransport(response *ScanResponse) string {
+	switch {
+	case response.Transport != "":
+		return response.Transport
+	case response.Amplification != nil:
+		return "udp"
+	}
+	return "tcp"
+}
+
+// sortedScanNames returns the names of the grab's scans, ordered by port and
+// then name.
+func sortedScanNames(grab *Grab) []string {
+	names := make([]string, 0, len(grab.Data))
+	for name := range grab.Data {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		a, b := grab.Data[names[i]], grab.Data[names[j]]
+		if a.Port != b.Port {
+			return a.Port < b.Port
+		}
+		return names[i] < names[j]
+	})
+	return names
+}
+
+// censysHost is a target in the Censys-compatible output schema.
+type censysHost struct {
+	IP               string                   `json:"ip,omitempty"`
+	DNS              *censysDNS               `json:"dns,omitempty"`
+	Location         *censysLocation          `json:"location,omitempty"`
+	AutonomousSystem *censysAS                `json:"autonomous_system,omitempty"`
+	Services         []map[string]interface{} `json:"services"`
+	Metadata         json.RawMessage          `json:"metadata,omitempty"`
+}
+
+type censysLocation struct {
+	CountryCode string `json:"country_code"`
+}
+
+type censysAS struct {
+	ASN  uint   `json:"asn"`
+	Name string `json:"name,omitempty"`
+}
+
+type censysDNS struct {
+	Names []string `json:"names"`
+}
+
+type censysSoftware struct {
+	URI     string `json:"uniform_resource_identifier,omitempty"`
+	Part    string `json:"part,omitempty"`
+	Vendor  string `json:"vendor,omitempty"`
+	Product string `json:"product"`
+	Version string `json:"version,omitempty"`
+}
+
+// censysHostOf reshapes a grab into a Censys-style host record. Each scan
+// that reached a service becomes an entry in services, with the module's
+// result under the protocol's name (e.g. "http") if the protocol was
+// identified; otherwise its service_name is UNKNOWN. Ports that did not
+// respond are left out, as Censys does.
+func censysHostOf(grab *Grab) *censysHost {
+	ret := &censysHost{IP: grab.IP, Services: []map[string]interface{}{}, Metadata: grab.Metadata}
+	if grab.Domain != "" {
+		ret.DNS = &censysDNS{Names: []string{grab.Domain}}
+	}
+	if location := grab.Location; location != nil {
+		if location.Country != "" {
+			ret.Location = &censysLocation{CountryCode: location.Country}
+		}
+		if location.ASN != 0 {
+			ret.AutonomousSystem = &censysAS{ASN: location.ASN, Name: location.ASName}
+		}
+	}
+	for _, name := range sortedScanNames(grab) {
+		response := grab.Data[name]
+		if !serviceResponded(response.Status) {
+			continue
+		}
+		serviceName, extendedName := "UNKNOWN", "UNKNOWN"
+		service := map[string]interface{}{
+			"port":               response.Port,
+			"transport_protocol": strings.ToUpper(responseTransport(&response)),
+			"observed_at":        response.Timestamp,
+		}
+		if serviceIdentified(response.Status) {
+			serviceName = strings.ToUpper(response.Protocol)
+			extendedName = serviceName
+			if tlsName, ok := tlsServiceNames[serviceName]; ok && response.Timing != nil && response.Timing.TLSHandshake > 0 {
+				extendedName = tlsName
+			}
+			if response.Result != nil {
+				service[strings.ToLower(response.Protocol)] = response.Result
+			}
+			if product := response.Product; product != nil {
+				software := censysSoftware{
+					URI:     product.CPE,
+					Vendor:  product.Vendor,
+					Product: product.Name,
+					Version: product.Version,
+				}
+				if fields := splitCPE(product.CPE); len(fields) > 2 {
+					software.Part = fields[2]
+				}
+				service["software"] = []censysSoftware{software}
+			}
+		}
+		service["service_name"] = serviceName
+		service["extended_service_name"] = extendedName
+		ret.Services = append(ret.Services, service)
+	}
+	return ret
+}
+
+type shodanModule struct {
+	Module string `json:"module"`
+}
+
+type shodanLocation struct {
+	CountryCode string `json:"country_code"`
+}
+
+type shodanVuln struct {
+	Verified bool `json:"verified"`
+}
+
+// shodanTimestamp converts an RFC 3339 timestamp to Shodan's format: UTC,
+// with microseconds and no zone.
+func shodanTimestamp(timestamp string) string {
+	t, err := time.Parse(time.RFC3339Nano, timestamp)
+	if err != nil {
+		return timestamp
+	}
+	return t.UTC().Format("2006-01-02T15:04:05.000000")
+}
+
+// shodanBannersOf reshapes a grab into Shodan-style banners, one for each
+// scan that identified its protocol, with the module's result under the
+// protocol's name. The data field, which holds the raw banner in Shodan, is
+// left empty, since zgrab2 records services in structured form. CVEs found
+// with --cve-file are listed as unverified vulns.
+func shodanBannersOf(grab *Grab) []interface{} {
+	var ret []interface{}
+	hostnames := []string{}
+	if grab.Domain != "" {
+		hostnames = append(hostnames, grab.Domain)
+	}
+	for _, name := range sortedScanNames(grab) {
+		response := grab.Data[name]
+		if !serviceIdentified(response.Status) {
+			continue
+		}
+		banner := map[string]interface{}{
+			"ip_str":    grab.IP,
+			"hostnames": hostnames,
+			"port":      response.Port,
+			"transport": responseTransport(&response),
+			"timestamp": shodanTimestamp(response.Timestamp),
+			"data":      "",
+			"_shodan":   shodanModule{Module: response.Protocol},
+		}
+		if grab.IP == "" {
+			delete(banner, "ip_str")
+		}
+		if location := grab.Location; location != nil {
+			if location.Country != "" {
+				banner["location"] = shodanLocation{CountryCode: location.Country}
+			}
+			if location.ASN != 0 {
+				banner["asn"] = "AS" + strconv.FormatUint(uint64(location.ASN), 10)
+				if location.ASName != "" {
+					banner["org"] = location.ASName
+				}
+			}
+		}
+		if response.Result != nil {
+			banner[strings.ToLower(response.Protocol)] = response.Result
+		}
+		if product := response.Product; product != nil {
+			banner["product"] = product.Name
+			if product.Version != "" {
+				banner["version"] = product.Version
+			}
+			if product.CPE != "" {
+				banner["cpe23"] = []string{product.CPE}
+			}
+			if len(product.CVEs) > 0 {
+				vulns := make(map[string]shodanVuln, len(product.CVEs))
+				for _, id := range product.CVEs {
+					vulns[id] = shodanVuln{}
+				}
+				banner["vulns"] = vulns
+			}
+		}
+		ret = append(ret, banner)
+	}
+	return ret
+}
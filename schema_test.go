@@ -0,0 +1,161 @@
+package zgrab2
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+type schemaTestResult struct {
+	Banner string `json:"banner"`
+	Dump   []byte `json:"dump" zgrab:"debug"`
+}
+
+func schemaTestGrab() *Grab {
+	errString := "connection refused"
+	return &Grab{
+		IP:     "10.0.0.1",
+		Domain: "example.com",
+		Data: map[string]ScanResponse{
+			"https": {
+				Status:    SCAN_SUCCESS,
+				Protocol:  "http",
+				Port:      443,
+				Timestamp: "2026-10-16T12:00:00Z",
+				Timing:    &Timing{TLSHandshake: 1000, Total: 2000},
+				Result:    &schemaTestResult{Banner: "hello", Dump: []byte("secret")},
+				Product:   NewProduct(CPEApplication, "f5", "nginx", "1.18.0"),
+			},
+			"ssh": {
+				Status:    SCAN_PROTOCOL_ERROR,
+				Protocol:  "ssh",
+				Port:      22,
+				Timestamp: "2026-10-16T12:00:01Z",
+			},
+			"redis": {
+				Status:    SCAN_CONNECTION_REFUSED,
+				Protocol:  "redis",
+				Port:      6379,
+				Timestamp: "2026-10-16T12:00:02Z",
+				Error:     &errString,
+			},
+			"ntp": {
+				Status:        SCAN_SUCCESS,
+				Protocol:      "ntp",
+				Port:          123,
+				Timestamp:     "2026-10-16T12:00:03Z",
+				Amplification: &Amplification{RequestBytes: 48, RequestPackets: 1},
+			},
+		},
+	}
+}
+
+func TestCensysSchema(t *testing.T) {
+	defer func(schema string) { config.OutputSchema = schema }(config.OutputSchema)
+	config.OutputSchema = outputSchemaCensys
+	grab := schemaTestGrab()
+	grab.Location = &Location{Country: "NL", ASN: 64496, ASName: "Example Networks"}
+	result, err := encodeGrab(grab)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(result, []byte("secret")) {
+		t.Errorf("debug field not stripped: %s", result)
+	}
+	var host struct {
+		IP  string `json:"ip"`
+		DNS struct {
+			Names []string `json:"names"`
+		} `json:"dns"`
+		Location struct {
+			CountryCode string `json:"country_code"`
+		} `json:"location"`
+		AutonomousSystem struct {
+			ASN uint `json:"asn"`
+		} `json:"autonomous_system"`
+		Services []map[string]interface{} `json:"services"`
+	}
+	if err := json.Unmarshal(result, &host); err != nil {
+		t.Fatal(err)
+	}
+	if host.IP != "10.0.0.1" || len(host.DNS.Names) != 1 || host.DNS.Names[0] != "example.com" {
+		t.Errorf("wrong host: %s", result)
+	}
+	if host.Location.CountryCode != "NL" || host.AutonomousSystem.ASN != 64496 {
+		t.Errorf("wrong location: %s", result)
+	}
+	expected := []struct {
+		port      float64
+		name      string
+		extended  string
+		transport string
+	}{
+		{22, "UNKNOWN", "UNKNOWN", "TCP"},
+		{123, "NTP", "NTP", "UDP"},
+		{443, "HTTP", "HTTPS", "TCP"},
+	}
+	if len(host.Services) != len(expected) {
+		t.Fatalf("expected %d services, got %s", len(expected), result)
+	}
+	for i, e := range expected {
+		service := host.Services[i]
+		if service["port"] != e.port || service["service_name"] != e.name || service["extended_service_name"] != e.extended || service["transport_protocol"] != e.transport {
+			t.Errorf("service %d: expected %+v, got %v", i, e, service)
+		}
+	}
+	https := host.Services[2]
+	if banner := https["http"].(map[string]interface{})["banner"]; banner != "hello" {
+		t.Errorf("wrong protocol block: %v", https)
+	}
+	software := https["software"].([]interface{})[0].(map[string]interface{})
+	if software["part"] != "a" || software["product"] != "nginx" || software["uniform_resource_identifier"] != "cpe:2.3:a:f5:nginx:1.18.0:*:*:*:*:*:*:*" {
+		t.Errorf("wrong software: %v", software)
+	}
+}
+
+func TestShodanSchema(t *testing.T) {
+	defer func(schema string) { config.OutputSchema = schema }(config.OutputSchema)
+	config.OutputSchema = outputSchemaShodan
+	grab := schemaTestGrab()
+	grab.Data["https"].Product.CVEs = []string{"CVE-2021-23017"}
+	result, err := encodeGrab(grab)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := bytes.Split(result, []byte("\n"))
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 banners, got %s", result)
+	}
+	var banner struct {
+		IPStr     string                     `json:"ip_str"`
+		Hostnames []string                   `json:"hostnames"`
+		Port      int                        `json:"port"`
+		Transport string                     `json:"transport"`
+		Timestamp string                     `json:"timestamp"`
+		Product   string                     `json:"product"`
+		Version   string                     `json:"version"`
+		CPE23     []string                   `json:"cpe23"`
+		Vulns     map[string]json.RawMessage `json:"vulns"`
+		HTTP      *schemaTestResult          `json:"http"`
+		Shodan    shodanModule               `json:"_shodan"`
+	}
+	if err := json.Unmarshal(lines[1], &banner); err != nil {
+		t.Fatal(err)
+	}
+	if banner.IPStr != "10.0.0.1" || banner.Port != 443 || banner.Transport != "tcp" || banner.Timestamp != "2026-10-16T12:00:00.000000" {
+		t.Errorf("wrong banner: %s", lines[1])
+	}
+	if banner.Product != "nginx" || banner.Version != "1.18.0" || len(banner.CPE23) != 1 || banner.Vulns["CVE-2021-23017"] == nil {
+		t.Errorf("wrong product: %s", lines[1])
+	}
+	if banner.HTTP == nil || banner.HTTP.Banner != "hello" || banner.Shodan.Module != "http" {
+		t.Errorf("wrong protocol block: %s", lines[1])
+	}
+
+	grab = schemaTestGrab()
+	delete(grab.Data, "https")
+	delete(grab.Data, "ntp")
+	if result, err := encodeGrab(grab); err != nil || len(result) != 0 {
+		t.Errorf("expected no banners, got %s (%v)", result, err)
+	}
+}
@@ -0,0 +1,96 @@
+package zgrab2
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// targetSharder implements --shard, selecting the targets that belong to one
+// of several zgrab2 instances splitting the same input. Each target is
+// assigned to a shard by a jump consistent hash of its address (or name, if
+// it has no address) and port, so that every instance makes the same choice
+// without coordinating, and changing the number of shards moves as few
+// targets as possible between them.
+type targetSharder struct {
+	shard  int
+	shards int
+
+	// skipped counts the targets belonging to other shards.
+	skipped uint64
+}
+
+var sharder *targetSharder
+
+// parseShard parses a --shard value of the form i/n, where shards are
+// numbered from 0 to n-1.
+func parseShard(s string) (*targetSharder, error) {
+	parts := strings.Split(s, "/")
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid shard %q: must be i/n", s)
+	}
+	shard, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil {
+		return nil, fmt.Errorf("invalid shard %q: %v", s, err)
+	}
+	shards, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+	if err != nil {
+		return nil, fmt.Errorf("invalid shard %q: %v", s, err)
+	}
+	if shards < 1 || shard < 0 || shard >= shards {
+		return nil, fmt.Errorf("invalid shard %q: must be from 0/n to (n-1)/n", s)
+	}
+	return &targetSharder{shard: shard, shards: shards}, nil
+}
+
+// shardKey returns the hash that assigns the target to a shard.
+func shardKey(target *ScanTarget) uint64 {
+	h := fnv.New64a()
+	if target.IP != nil {
+		h.Write(target.IP.To16())
+	} else {
+		h.Write([]byte(strings.ToLower(target.Domain)))
+	}
+	if target.Port != nil {
+		var port [2]byte
+		binary.BigEndian.PutUint16(port[:], uint16(*target.Port))
+		h.Write([]byte{'|'})
+		h.Write(port[:])
+	}
+	return h.Sum64()
+}
+
+// jumpHash maps key to a bucket from 0 to buckets-1, with the jump consistent
+// hash of Lamping and Veach: when the number of buckets grows from n to n+1,
+// only 1/(n+1) of the keys move, all of them to the new bucket.
+func jumpHash(key uint64, buckets int) int {
+	var b, j int64 = -1, 0
+	for j < int64(buckets) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int(b)
+}
+
+// selected returns true if the target belongs to this shard, counting it as
+// skipped if not.
+func (s *targetSharder) selected(target *ScanTarget) bool {
+	if jumpHash(shardKey(target), s.shards) == s.shard {
+		return true
+	}
+	atomic.AddUint64(&s.skipped, 1)
+	return false
+}
+
+// GetOtherShardTargets returns the number of targets skipped by --shard
+// because they belong to another shard.
+func GetOtherShardTargets() uint64 {
+	if sharder == nil {
+		return 0
+	}
+	return atomic.LoadUint64(&sharder.skipped)
+}
@@ -0,0 +1,70 @@
+package zgrab2
+
+import (
+	"net"
+	"testing"
+)
+
+func TestParseShard(t *testing.T) {
+	s, err := parseShard("2/5")
+	if err != nil || s.shard != 2 || s.shards != 5 {
+		t.Errorf("wrong shard %+v (%v)", s, err)
+	}
+	for _, bad := range []string{"5/5", "-1/5", "1/0", "1", "a/2", "1/2/3"} {
+		if _, err := parseShard(bad); err == nil {
+			t.Errorf("%s: expected an error", bad)
+		}
+	}
+}
+
+func TestShardPartition(t *testing.T) {
+	const targets = 4000
+	port := uint(443)
+	assigned := make([]int, targets)
+	counts := make([]int, 4)
+	for i := 0; i < targets; i++ {
+		target := &ScanTarget{IP: net.IPv4(10, 0, byte(i>>8), byte(i))}
+		if i%2 == 0 {
+			target.Port = &port
+		}
+		matches := 0
+		for shard := 0; shard < 4; shard++ {
+			if (&targetSharder{shard: shard, shards: 4}).selected(target) {
+				assigned[i] = shard
+				matches++
+			}
+		}
+		if matches != 1 {
+			t.Fatalf("%s selected by %d shards", target, matches)
+		}
+		counts[assigned[i]]++
+
+		// Adding a fifth shard only moves targets to it.
+		if moved := jumpHash(shardKey(target), 5); moved != assigned[i] && moved != 4 {
+			t.Errorf("%s moved from shard %d to %d", target, assigned[i], moved)
+		}
+	}
+	for _, count := range counts {
+		if count < targets/4*8/10 || count > targets/4*12/10 {
+			t.Errorf("unbalanced shards: %v", counts)
+			break
+		}
+	}
+
+	// The same host in a different form lands on the same shard.
+	a := &ScanTarget{IP: net.ParseIP("192.0.2.1")}
+	b := &ScanTarget{IP: net.ParseIP("::ffff:192.0.2.1"), Domain: "example.com"}
+	if shardKey(a) != shardKey(b) {
+		t.Error("IPv4 address forms hashed differently")
+	}
+	if shardKey(&ScanTarget{Domain: "Example.com"}) != shardKey(&ScanTarget{Domain: "example.com"}) {
+		t.Error("domain case changed the shard")
+	}
+	s := &targetSharder{shard: 0, shards: 2}
+	for i := 0; i < 10; i++ {
+		s.selected(&ScanTarget{IP: net.IPv4(10, 1, 0, byte(i))})
+	}
+	if s.skipped == 0 || s.skipped == 10 {
+		t.Errorf("wrong skipped count %d", s.skipped)
+	}
+}
@@ -0,0 +1,194 @@
+package zgrab2
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+// ConnectionSharingScanner is implemented by scanners that can share a TCP
+// connection with the other scanners run on the same target, so that a group
+// of modules (e.g. a banner grab followed by a protocol-specific probe) makes
+// a single connection to rate-limited hosts. Sharing must also be enabled
+// with --share-connection in each of the modules' flags.
+//
+// The contract is:
+//   - a scanner that hands off its connection opens it with Open, and when
+//     its scan succeeds, leaves it open, in plaintext, and between exchanges
+//     (its Close then passes the connection on instead of closing it);
+//   - a scanner that takes over a connection gets it from Open (or
+//     SharedConnection, if it dials by other means), and must not rely on
+//     anything the server sends when a connection is opened, as an earlier
+//     scanner will have read it.
+//
+// Connections that are not taken over are closed once all of the target's
+// scanners have run, or as soon as a scanner fails.
+type ConnectionSharingScanner interface {
+	Scanner
+
+	// HandsOffConnection returns true if, as configured, the scanner can
+	// pass its connection on to the next scanner.
+	HandsOffConnection() bool
+
+	// TakesOverConnection returns true if, as configured, the scanner can
+	// use a connection passed on by an earlier scanner.
+	TakesOverConnection() bool
+}
+
+// connectionGroup holds the connections handed off by the scanners run on a
+// single target, by address, until they are taken over.
+type connectionGroup struct {
+	mutex sync.Mutex
+	conns map[string]net.Conn
+}
+
+// connectionSharing describes the part the current scanner plays in its
+// target's connection group.
+type connectionSharing struct {
+	group    *connectionGroup
+	handOff  bool
+	takeOver bool
+}
+
+// sharingFor returns the role of the scanner in the group, or nil if it does
+// not share connections.
+func (g *connectionGroup) sharingFor(s Scanner) *connectionSharing {
+	sharer, ok := s.(ConnectionSharingScanner)
+	if !ok {
+		return nil
+	}
+	ret := &connectionSharing{group: g, handOff: sharer.HandsOffConnection(), takeOver: sharer.TakesOverConnection()}
+	if !ret.handOff && !ret.takeOver {
+		return nil
+	}
+	return ret
+}
+
+// put stores a connection handed off for the address, closing any other
+// connection to it that was not taken over.
+func (g *connectionGroup) put(address string, conn net.Conn) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	if g.conns == nil {
+		g.conns = make(map[string]net.Conn)
+	}
+	if old, ok := g.conns[address]; ok && old != conn {
+		old.Close()
+	}
+	g.conns[address] = conn
+}
+
+// take removes and returns the connection handed off for the address, if
+// any.
+func (g *connectionGroup) take(address string) net.Conn {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	conn, ok := g.conns[address]
+	if !ok {
+		return nil
+	}
+	delete(g.conns, address)
+	return conn
+}
+
+// closeAll closes the connections that were not taken over.
+func (g *connectionGroup) closeAll() {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	for address, conn := range g.conns {
+		conn.Close()
+		delete(g.conns, address)
+	}
+}
+
+// handOffConn passes the connection on to the target's group when it is
+// closed, unless reading or writing it failed.
+type handOffConn struct {
+	net.Conn
+	group   *connectionGroup
+	address string
+	failed  bool
+	once    sync.Once
+}
+
+func (c *handOffConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.noteError(err)
+	return n, err
+}
+
+func (c *handOffConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.noteError(err)
+	return n, err
+}
+
+func (c *handOffConn) noteError(err error) {
+	if err == nil {
+		return
+	}
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		// A read that times out (e.g. waiting for more of a banner) leaves
+		// the connection usable.
+		return
+	}
+	c.failed = true
+}
+
+func (c *handOffConn) Close() error {
+	var err error
+	c.once.Do(func() {
+		if c.failed {
+			err = c.Conn.Close()
+			return
+		}
+		c.group.put(c.address, c.Conn)
+	})
+	return err
+}
+
+// renew gives a connection that is taken over the session timeout and read
+// limit of the scanner taking it over.
+func (c *TimeoutConnection) renew(timeout time.Duration, bytesReadLimit int) {
+	if c.Cancel != nil {
+		c.Cancel()
+	}
+	c.Timeout = timeout
+	c.ctx, c.Cancel = context.WithTimeout(context.Background(), timeout)
+	c.BytesRead = 0
+	c.BytesWritten = 0
+	if bytesReadLimit > 0 {
+		c.BytesReadLimit = bytesReadLimit
+	}
+	c.SetDefaults()
+}
+
+// SharedConnection returns the connection to the address (host:port) handed
+// off by an earlier scanner, if the current scanner takes over connections
+// and --share-connection is set; otherwise, nil. Scanners that dial by means
+// other than Open call it to honor the ConnectionSharingScanner contract.
+func (target *ScanTarget) SharedConnection(flags *BaseFlags, address string) net.Conn {
+	if !flags.ShareConnection || target.sharing == nil || !target.sharing.takeOver {
+		return nil
+	}
+	conn := target.sharing.group.take(address)
+	if conn == nil {
+		return nil
+	}
+	if tc, ok := conn.(*TimeoutConnection); ok {
+		tc.renew(target.BoundTimeout(flags.Timeout), flags.BytesReadLimit)
+	}
+	target.RecordConnection(conn)
+	return conn
+}
+
+// handOff wraps the connection so that it is passed on to the next scanner
+// when closed, if the current scanner hands off connections and
+// --share-connection is set.
+func (target *ScanTarget) handOff(flags *BaseFlags, address string, conn net.Conn) net.Conn {
+	if !flags.ShareConnection || target.sharing == nil || !target.sharing.handOff {
+		return conn
+	}
+	return &handOffConn{Conn: conn, group: target.sharing.group, address: address}
+}
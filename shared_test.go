@@ -0,0 +1,93 @@
+package zgrab2
+
+import (
+	"bufio"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// lineScanner opens a connection with Open, sends a line and reads the echo.
+type lineScanner struct {
+	name     string
+	flags    BaseFlags
+	handOff  bool
+	takeOver bool
+	local    string
+}
+
+func (s *lineScanner) Init(flags ScanFlags) error       { return nil }
+func (s *lineScanner) InitPerSender(senderID int) error { return nil }
+func (s *lineScanner) GetName() string                  { return s.name }
+func (s *lineScanner) GetTrigger() string               { return "" }
+func (s *lineScanner) Protocol() string                 { return "line" }
+func (s *lineScanner) HandsOffConnection() bool         { return s.handOff }
+func (s *lineScanner) TakesOverConnection() bool        { return s.takeOver }
+func (s *lineScanner) Scan(t ScanTarget) (ScanStatus, interface{}, error) {
+	conn, err := t.Open(&s.flags)
+	if err != nil {
+		return TryGetScanStatus(err), nil, err
+	}
+	defer conn.Close()
+	s.local = conn.LocalAddr().String()
+	if _, err := conn.Write([]byte(s.name + "\n")); err != nil {
+		return TryGetScanStatus(err), nil, err
+	}
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return TryGetScanStatus(err), nil, err
+	}
+	return SCAN_SUCCESS, line, nil
+}
+
+func TestSharedConnection(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	var accepted int32
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(&accepted, 1)
+			go func(conn net.Conn) {
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+				for {
+					line, err := reader.ReadString('\n')
+					if err != nil {
+						return
+					}
+					conn.Write([]byte(line))
+				}
+			}(conn)
+		}
+	}()
+	port := uint(listener.Addr().(*net.TCPAddr).Port)
+	flags := BaseFlags{Port: port, Timeout: time.Second, ShareConnection: true}
+	first := &lineScanner{name: "first", flags: flags, handOff: true}
+	second := &lineScanner{name: "second", flags: flags, takeOver: true}
+	third := &lineScanner{name: "third", flags: flags, takeOver: true}
+
+	grab := scanTarget(ScanTarget{IP: net.ParseIP("127.0.0.1")}, []Scanner{first, second, third}, nil, true, 0)
+	for _, name := range []string{"first", "second", "third"} {
+		if res := grab.Data[name]; res.Status != SCAN_SUCCESS || res.Result != name+"\n" {
+			t.Errorf("%s: unexpected response %+v", name, res)
+		}
+	}
+	if first.local != second.local {
+		t.Errorf("expected the second scanner to take over the first's connection")
+	}
+	// The second scanner does not hand off, so the third opens its own.
+	if second.local == third.local {
+		t.Errorf("expected the third scanner to open a new connection")
+	}
+	if n := atomic.LoadInt32(&accepted); n != 2 {
+		t.Errorf("expected 2 connections, got %d", n)
+	}
+}
@@ -0,0 +1,108 @@
+package zgrab2
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+)
+
+// errSocketOptionsUnsupported is returned when socket options are given on a
+// platform where zgrab2 cannot set them.
+var errSocketOptionsUnsupported = errors.New("socket options are not supported on this platform")
+
+// SocketOptions are IP and TCP options set on the sockets of a scan's
+// connections, so that measurements can control the characteristics of the
+// packets sent. Zero values leave the system defaults.
+type SocketOptions struct {
+	// TTL is the IP time-to-live (hop limit, for IPv6) of outgoing packets.
+	TTL int
+
+	// TOS is the IP type-of-service byte (traffic class, for IPv6) of
+	// outgoing packets: the DSCP in the upper six bits, and ECN in the lower
+	// two.
+	TOS int
+
+	// MSS is the TCP maximum segment size, which is also advertised in the
+	// SYN where the options are set before connecting (see
+	// socketOptionsBeforeConnect).
+	MSS int
+
+	// KeepAlive is the interval between TCP keepalive probes; negative
+	// disables keepalives.
+	KeepAlive time.Duration
+}
+
+// GetSocketOptions returns the socket options given with --ttl, --tos,
+// --tcp-mss and --tcp-keepalive, or nil if there are none.
+func (b *BaseFlags) GetSocketOptions() (*SocketOptions, error) {
+	ret := &SocketOptions{TTL: b.TTL, TOS: b.TOS, MSS: b.TCPMSS, KeepAlive: b.TCPKeepAlive}
+	if *ret == (SocketOptions{}) {
+		return nil, nil
+	}
+	if ret.TTL < 0 || ret.TTL > 255 {
+		return nil, fmt.Errorf("invalid TTL %d: must be from 1 to 255", ret.TTL)
+	}
+	if ret.TOS < 0 || ret.TOS > 255 {
+		return nil, fmt.Errorf("invalid TOS %d: must be from 0 to 255", ret.TOS)
+	}
+	if ret.MSS != 0 && (ret.MSS < 88 || ret.MSS > 65535) {
+		return nil, fmt.Errorf("invalid MSS %d: must be from 88 to 65535", ret.MSS)
+	}
+	return ret, nil
+}
+
+// applyTo sets the options on the socket fd, for an IPv6 socket if ipv6 is
+// set, and a TCP one if tcp is set. The keepalive interval is set through the
+// net.Dialer instead.
+func (o *SocketOptions) applyTo(fd uintptr, ipv6, tcp bool) error {
+	if o.TTL != 0 {
+		if err := setIPOption(fd, ipv6, ipOptionTTL, o.TTL); err != nil {
+			return fmt.Errorf("could not set TTL: %v", err)
+		}
+	}
+	if o.TOS != 0 {
+		if err := setIPOption(fd, ipv6, ipOptionTOS, o.TOS); err != nil {
+			return fmt.Errorf("could not set TOS: %v", err)
+		}
+	}
+	if o.MSS != 0 && tcp {
+		if err := setTCPMaxSegment(fd, o.MSS); err != nil {
+			return fmt.Errorf("could not set MSS: %v", err)
+		}
+	}
+	return nil
+}
+
+// applyToConn sets the options on an open connection's socket.
+func (o *SocketOptions) applyToConn(conn net.Conn) error {
+	sc, ok := conn.(interface {
+		SyscallConn() (syscall.RawConn, error)
+	})
+	if !ok {
+		return fmt.Errorf("cannot set socket options on %T", conn)
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var ipv6, tcp bool
+	switch addr := conn.RemoteAddr().(type) {
+	case *net.TCPAddr:
+		ipv6, tcp = addr.IP.To4() == nil, true
+	case *net.UDPAddr:
+		ipv6 = addr.IP.To4() == nil
+	}
+	var setErr error
+	if err := raw.Control(func(fd uintptr) { setErr = o.applyTo(fd, ipv6, tcp) }); err != nil {
+		return err
+	}
+	return setErr
+}
+
+// IP-level options set by setIPOption.
+const (
+	ipOptionTTL = iota
+	ipOptionTOS
+)
@@ -0,0 +1,28 @@
+// +build go1.11
+
+package zgrab2
+
+import (
+	"net"
+	"strings"
+	"syscall"
+)
+
+// socketOptionsBeforeConnect is true where net.Dialer can set socket options
+// before connecting, so that they also apply to the TCP handshake (e.g. the
+// MSS advertised in the SYN).
+const socketOptionsBeforeConnect = true
+
+// setDialerSocketOptions makes the dialer set the options on each socket
+// before it connects.
+func setDialerSocketOptions(dialer *net.Dialer, options *SocketOptions) {
+	dialer.Control = func(network, address string, c syscall.RawConn) error {
+		var setErr error
+		ipv6 := strings.HasSuffix(network, "6")
+		tcp := strings.HasPrefix(network, "tcp")
+		if err := c.Control(func(fd uintptr) { setErr = options.applyTo(fd, ipv6, tcp) }); err != nil {
+			return err
+		}
+		return setErr
+	}
+}
@@ -0,0 +1,13 @@
+// +build !go1.11
+
+package zgrab2
+
+import "net"
+
+// socketOptionsBeforeConnect is false before Go 1.11, whose net.Dialer cannot
+// set socket options before connecting; they are set as soon as the
+// connection is established instead, so the SYN is sent with the system
+// defaults.
+const socketOptionsBeforeConnect = false
+
+func setDialerSocketOptions(dialer *net.Dialer, options *SocketOptions) {}
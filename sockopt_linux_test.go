@@ -0,0 +1,86 @@
+// +build linux
+
+package zgrab2
+
+import (
+	"context"
+	"net"
+	"syscall"
+	"testing"
+)
+
+func getSocketOption(t *testing.T, conn net.Conn, level, name int) int {
+	raw, err := conn.(*net.TCPConn).SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var value int
+	var getErr error
+	raw.Control(func(fd uintptr) { value, getErr = syscall.GetsockoptInt(int(fd), level, name) })
+	if getErr != nil {
+		t.Fatal(getErr)
+	}
+	return value
+}
+
+func TestDialerSocketOptions(t *testing.T) {
+	listener, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	dialer := NewDialer(&Dialer{SocketOptions: &SocketOptions{TTL: 7, TOS: 0x20, MSS: 536}})
+	conn, err := dialer.DialContext(context.Background(), "tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	tcp := conn.(*TimeoutConnection).Conn
+	if ttl := getSocketOption(t, tcp, syscall.IPPROTO_IP, syscall.IP_TTL); ttl != 7 {
+		t.Errorf("expected TTL 7, got %d", ttl)
+	}
+	if tos := getSocketOption(t, tcp, syscall.IPPROTO_IP, syscall.IP_TOS); tos != 0x20 {
+		t.Errorf("expected TOS 0x20, got 0x%x", tos)
+	}
+	if mss := getSocketOption(t, tcp, syscall.IPPROTO_TCP, syscall.TCP_MAXSEG); mss > 536 {
+		t.Errorf("expected MSS at most 536, got %d", mss)
+	}
+}
+
+// TestApplyToConn checks the options being set on an established connection,
+// as they are before Go 1.11 and for UDP.
+func TestApplyToConn(t *testing.T) {
+	listener, err := net.Listen("tcp6", "[::1]:0")
+	if err != nil {
+		t.Skip("no IPv6 loopback:", err)
+	}
+	defer listener.Close()
+	go func() {
+		if conn, err := listener.Accept(); err == nil {
+			defer conn.Close()
+		}
+	}()
+	conn, err := net.Dial("tcp6", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := (&SocketOptions{TTL: 9, TOS: 0x40}).applyToConn(conn); err != nil {
+		t.Fatal(err)
+	}
+	if hops := getSocketOption(t, conn, syscall.IPPROTO_IPV6, syscall.IPV6_UNICAST_HOPS); hops != 9 {
+		t.Errorf("expected hop limit 9, got %d", hops)
+	}
+	if class := getSocketOption(t, conn, syscall.IPPROTO_IPV6, syscall.IPV6_TCLASS); class != 0x40 {
+		t.Errorf("expected traffic class 0x40, got 0x%x", class)
+	}
+}
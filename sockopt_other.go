@@ -0,0 +1,11 @@
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd,!dragonfly
+
+package zgrab2
+
+func setIPOption(fd uintptr, ipv6 bool, option int, value int) error {
+	return errSocketOptionsUnsupported
+}
+
+func setTCPMaxSegment(fd uintptr, mss int) error {
+	return errSocketOptionsUnsupported
+}
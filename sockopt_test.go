@@ -0,0 +1,18 @@
+package zgrab2
+
+import "testing"
+
+func TestGetSocketOptions(t *testing.T) {
+	if options, err := (&BaseFlags{}).GetSocketOptions(); options != nil || err != nil {
+		t.Errorf("expected no options, got %+v (%v)", options, err)
+	}
+	options, err := (&BaseFlags{TTL: 7, TOS: 0xb8, TCPMSS: 536}).GetSocketOptions()
+	if err != nil || options == nil || options.TTL != 7 || options.TOS != 0xb8 || options.MSS != 536 {
+		t.Errorf("wrong options %+v (%v)", options, err)
+	}
+	for _, flags := range []BaseFlags{{TTL: 256}, {TTL: -1}, {TOS: 300}, {TCPMSS: 40}, {TCPMSS: 70000}} {
+		if _, err := flags.GetSocketOptions(); err == nil {
+			t.Errorf("%+v: expected an error", flags)
+		}
+	}
+}
@@ -0,0 +1,25 @@
+// +build linux darwin freebsd netbsd openbsd dragonfly
+
+package zgrab2
+
+import "syscall"
+
+// setIPOption sets the TTL or TOS option (ipOptionTTL or ipOptionTOS) of an
+// IPv4 or IPv6 socket.
+func setIPOption(fd uintptr, ipv6 bool, option int, value int) error {
+	level, name := syscall.IPPROTO_IP, syscall.IP_TTL
+	switch {
+	case ipv6 && option == ipOptionTTL:
+		level, name = syscall.IPPROTO_IPV6, syscall.IPV6_UNICAST_HOPS
+	case ipv6:
+		level, name = syscall.IPPROTO_IPV6, syscall.IPV6_TCLASS
+	case option == ipOptionTOS:
+		name = syscall.IP_TOS
+	}
+	return syscall.SetsockoptInt(int(fd), level, name, value)
+}
+
+// setTCPMaxSegment sets the MSS of a TCP socket.
+func setTCPMaxSegment(fd uintptr, mss int) error {
+	return syscall.SetsockoptInt(int(fd), syscall.IPPROTO_TCP, syscall.TCP_MAXSEG, mss)
+}
@@ -0,0 +1,195 @@
+package zgrab2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// ErrSourcePortsExhausted is returned when no port in --source-port-range
+// could be bound before the connection's deadline.
+var ErrSourcePortsExhausted = errors.New("no free source port in --source-port-range")
+
+const (
+	// sourcePortMaxBackoff bounds the wait between attempts to find a free
+	// source port when all of them are in use.
+	sourcePortMaxBackoff = time.Second
+
+	// sourcePortCooldown is how long a port that the OS refused to bind
+	// (e.g. because a previous connection from it is in TIME_WAIT) is
+	// skipped for.
+	sourcePortCooldown = time.Minute
+)
+
+// sourcePortRange hands out the local ports that outgoing connections bind
+// to, with --source-port-range. Ports are used in turn, so that recently
+// closed ports are reused as late as possible.
+type sourcePortRange struct {
+	low, high int
+
+	mutex     sync.Mutex
+	next      int
+	inUse     map[int]bool
+	coolUntil map[int]time.Time
+}
+
+// sourcePorts is the range given with --source-port-range, or nil.
+var sourcePorts *sourcePortRange
+
+// parseSourcePortRange parses a port range of the form "low-high", or a
+// single port.
+func parseSourcePortRange(s string) (*sourcePortRange, error) {
+	lowStr, highStr := s, s
+	if i := strings.IndexByte(s, '-'); i >= 0 {
+		lowStr, highStr = s[:i], s[i+1:]
+	}
+	low, err := strconv.ParseUint(strings.TrimSpace(lowStr), 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid source port range %q", s)
+	}
+	high, err := strconv.ParseUint(strings.TrimSpace(highStr), 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid source port range %q", s)
+	}
+	if low == 0 || high < low {
+		return nil, fmt.Errorf("invalid source port range %q", s)
+	}
+	return &sourcePortRange{
+		low:       int(low),
+		high:      int(high),
+		inUse:     make(map[int]bool),
+		coolUntil: make(map[int]time.Time),
+	}, nil
+}
+
+func (r *sourcePortRange) size() int {
+	return r.high - r.low + 1
+}
+
+// tryAcquire returns the next port that is neither in use nor cooling down.
+func (r *sourcePortRange) tryAcquire() (int, bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	now := time.Now()
+	size := r.size()
+	for i := 0; i < size; i++ {
+		port := r.low + (r.next+i)%size
+		if r.inUse[port] || now.Before(r.coolUntil[port]) {
+			continue
+		}
+		r.next = (r.next + i + 1) % size
+		r.inUse[port] = true
+		delete(r.coolUntil, port)
+		return port, true
+	}
+	return 0, false
+}
+
+// acquire returns a free port, waiting with exponential backoff while there
+// are none, until ctx is done.
+func (r *sourcePortRange) acquire(ctx context.Context) (int, error) {
+	backoff := 10 * time.Millisecond
+	for {
+		if port, ok := r.tryAcquire(); ok {
+			return port, nil
+		}
+		select {
+		case <-ctx.Done():
+			return 0, ErrSourcePortsExhausted
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > sourcePortMaxBackoff {
+			backoff = sourcePortMaxBackoff
+		}
+	}
+}
+
+// release returns the port to the range, skipping it for cooldown.
+func (r *sourcePortRange) release(port int, cooldown time.Duration) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.inUse, port)
+	if cooldown > 0 {
+		r.coolUntil[port] = time.Now().Add(cooldown)
+	}
+}
+
+// sourcePortConn releases its source port when closed.
+type sourcePortConn struct {
+	net.Conn
+	ports *sourcePortRange
+	port  int
+	once  sync.Once
+}
+
+func (c *sourcePortConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(func() {
+		c.ports.release(c.port, 0)
+	})
+	return err
+}
+
+// isAddrInUse returns true if the dial failed because the local address
+// could not be bound.
+func isAddrInUse(err error) bool {
+	opErr, ok := err.(*net.OpError)
+	if !ok {
+		return false
+	}
+	sysErr, ok := opErr.Err.(*os.SyscallError)
+	if !ok {
+		return false
+	}
+	return sysErr.Err == syscall.EADDRINUSE || sysErr.Err == syscall.EADDRNOTAVAIL
+}
+
+// withSourcePort calls dial with a port from --source-port-range (or 0, if
+// none was given) to bind the connection to, trying other ports if the OS
+// refuses to bind the one chosen.
+func withSourcePort(ctx context.Context, dial func(port int) (net.Conn, error)) (net.Conn, error) {
+	ports := sourcePorts
+	if ports == nil {
+		return dial(0)
+	}
+	for attempt := 0; attempt < ports.size(); attempt++ {
+		port, err := ports.acquire(ctx)
+		if err != nil {
+			return nil, err
+		}
+		conn, err := dial(port)
+		if err == nil {
+			return &sourcePortConn{Conn: conn, ports: ports, port: port}, nil
+		}
+		if !isAddrInUse(err) {
+			ports.release(port, 0)
+			return nil, err
+		}
+		ports.release(port, sourcePortCooldown)
+	}
+	return nil, ErrSourcePortsExhausted
+}
+
+// dialFromSourcePort dials the address with the dialer, bound to a port from
+// --source-port-range if one was given.
+func dialFromSourcePort(ctx context.Context, dialer *net.Dialer, network, address string) (net.Conn, error) {
+	if sourcePorts == nil {
+		return dialer.DialContext(ctx, network, address)
+	}
+	return withSourcePort(ctx, func(port int) (net.Conn, error) {
+		bound := *dialer
+		if strings.HasPrefix(network, "udp") {
+			bound.LocalAddr = &net.UDPAddr{Port: port}
+		} else {
+			bound.LocalAddr = &net.TCPAddr{Port: port}
+		}
+		return bound.DialContext(ctx, network, address)
+	})
+}
@@ -0,0 +1,90 @@
+package zgrab2
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestParseSourcePortRange(t *testing.T) {
+	r, err := parseSourcePortRange("40000-40009")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.low != 40000 || r.high != 40009 || r.size() != 10 {
+		t.Errorf("unexpected range %d-%d", r.low, r.high)
+	}
+	if r, err := parseSourcePortRange("40000"); err != nil || r.size() != 1 {
+		t.Errorf("expected a single port range, got %v", err)
+	}
+	for _, bad := range []string{"", "0-10", "10-5", "1-70000", "a-b"} {
+		if _, err := parseSourcePortRange(bad); err == nil {
+			t.Errorf("expected an error for %q", bad)
+		}
+	}
+}
+
+func TestSourcePortRangeExhaustion(t *testing.T) {
+	r, _ := parseSourcePortRange("40000-40001")
+	first, _ := r.acquire(context.Background())
+	second, _ := r.acquire(context.Background())
+	if first == second {
+		t.Fatalf("port %d handed out twice", first)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := r.acquire(ctx); err != ErrSourcePortsExhausted {
+		t.Errorf("expected ErrSourcePortsExhausted, got %v", err)
+	}
+	r.release(first, 0)
+	if port, err := r.acquire(context.Background()); err != nil || port != first {
+		t.Errorf("expected released port %d, got %d (%v)", first, port, err)
+	}
+	r.release(second, time.Minute)
+	if _, ok := r.tryAcquire(); ok {
+		t.Errorf("expected the cooling port to be skipped")
+	}
+}
+
+func TestDialFromSourcePort(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	// Find a free port to use as the range.
+	probe, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	free := probe.Addr().(*net.TCPAddr).Port
+	probe.Close()
+
+	sourcePorts, _ = parseSourcePortRange(strconv.Itoa(free))
+	defer func() { sourcePorts = nil }()
+	conn, err := dialFromSourcePort(context.Background(), new(net.Dialer), "tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if port := conn.LocalAddr().(*net.TCPAddr).Port; port != free {
+		t.Errorf("expected source port %d, got %d", free, port)
+	}
+	if !sourcePorts.inUse[free] {
+		t.Errorf("expected the port to be in use")
+	}
+	conn.Close()
+	if sourcePorts.inUse[free] {
+		t.Errorf("expected the port to be released on close")
+	}
+}
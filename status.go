@@ -24,12 +24,33 @@ const (
 	SCAN_PROTOCOL_ERROR                = ScanStatus("protocol-error")      // Received data incompatible with the target protocol
 	SCAN_APPLICATION_ERROR             = ScanStatus("application-error")   // The application reported an error
 	SCAN_UNKNOWN_ERROR                 = ScanStatus("unknown-error")       // Catch-all for unrecognized errors
+	SCAN_INTERNAL_ERROR                = ScanStatus("internal-error")      // The scanner itself failed (e.g. the module panicked)
+	SCAN_TARGET_TIMEOUT                = ScanStatus("target-timeout")      // The per-target time budget was used up before the scan could run
+	SCAN_PROXY_ERROR                   = ScanStatus("proxy-error")         // A proxy in the --proxy chain failed, before the target was reached
+)
+
+// RejectionReason is a machine-readable code for why a service that was
+// found refused to go on with the probe. It refines SCAN_APPLICATION_ERROR,
+// so that "service present but refused our probe" can be told apart from
+// "no service".
+type RejectionReason string
+
+const (
+	REJECTED_ENCRYPTION_REQUIRED    = RejectionReason("encryption-required")    // The service only accepts encrypted connections
+	REJECTED_ENCRYPTION_UNSUPPORTED = RejectionReason("encryption-unsupported") // The service refused to start TLS
+	REJECTED_ACCESS_DENIED          = RejectionReason("access-denied")          // The service does not accept connections from the scanner (e.g. host not allowed or blocked)
+	REJECTED_TOO_MANY_CONNECTIONS   = RejectionReason("too-many-connections")   // The service is at its connection limit
+	REJECTED_VERSION_UNSUPPORTED    = RejectionReason("version-unsupported")    // The service does not support the protocol version offered
+	REJECTED_UNSPECIFIED            = RejectionReason("unspecified")            // The service refused the probe without a recognized reason
 )
 
 // ScanError an error that also includes a ScanStatus.
 type ScanError struct {
 	Status ScanStatus
 	Err    error
+
+	// Reason is set if the error is the service refusing the probe.
+	Reason RejectionReason
 }
 
 // Error is an implementation of the builtin.error interface -- just forward the wrapped error's Error() method
@@ -49,6 +70,21 @@ func NewScanError(status ScanStatus, err error) *ScanError {
 	return &ScanError{Status: status, Err: err}
 }
 
+// NewRejectionError returns a SCAN_APPLICATION_ERROR ScanError for a
+// service that refused the probe for the given reason.
+func NewRejectionError(reason RejectionReason, err error) *ScanError {
+	return &ScanError{Status: SCAN_APPLICATION_ERROR, Err: err, Reason: reason}
+}
+
+// GetRejectionReason returns the reason the service refused the probe, if
+// the error is a ScanError made by NewRejectionError, or "".
+func GetRejectionReason(err error) RejectionReason {
+	if e, ok := err.(*ScanError); ok {
+		return e.Reason
+	}
+	return ""
+}
+
 // DetectScanError returns a ScanError that attempts to detect the status from the given error.
 func DetectScanError(err error) *ScanError {
 	return &ScanError{Status: TryGetScanStatus(err), Err: err}
@@ -61,6 +97,9 @@ func TryGetScanStatus(err error) ScanStatus {
 	if err == nil {
 		return SCAN_SUCCESS
 	}
+	if e := GetProxyError(err); e != nil {
+		return e.Status
+	}
 	if err == io.EOF {
 		// Presumably the caller did not call TryGetScanStatus if the EOF was expected
 		return SCAN_IO_TIMEOUT
@@ -0,0 +1,249 @@
+package zgrab2
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+)
+
+// prefixSet is a set of IP prefixes supporting longest-prefix lookups.
+type prefixSet struct {
+	// byLength maps each prefix length (in bits of the 16-byte form of the
+	// address) to the set of masked addresses with that length.
+	byLength map[int]map[string]*net.IPNet
+
+	// lengths are the prefix lengths present, longest first.
+	lengths []int
+}
+
+// parsePrefix parses a CIDR block or a bare IP address, returning the
+// equivalent prefix in the 16-byte address space.
+func parsePrefix(s string) (*net.IPNet, error) {
+	if !strings.Contains(s, "/") {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid address %q", s)
+		}
+		if ip.To4() != nil {
+			s += "/32"
+		} else {
+			s += "/128"
+		}
+	}
+	_, ipnet, err := net.ParseCIDR(s)
+	return ipnet, err
+}
+
+// prefixLength returns the length of the prefix in the 16-byte form of the
+// address space, so that IPv4 and IPv6 prefixes can share one table.
+func prefixLength(ipnet *net.IPNet) int {
+	ones, bits := ipnet.Mask.Size()
+	if bits == 32 {
+		return ones + 96
+	}
+	return ones
+}
+
+// add adds a prefix to the set.
+func (set *prefixSet) add(ipnet *net.IPNet) {
+	if set.byLength == nil {
+		set.byLength = make(map[int]map[string]*net.IPNet)
+	}
+	length := prefixLength(ipnet)
+	entries, ok := set.byLength[length]
+	if !ok {
+		entries = make(map[string]*net.IPNet)
+		set.byLength[length] = entries
+		i := 0
+		for i < len(set.lengths) && set.lengths[i] > length {
+			i++
+		}
+		set.lengths = append(set.lengths, 0)
+		copy(set.lengths[i+1:], set.lengths[i:])
+		set.lengths[i] = length
+	}
+	entries[string(ipnet.IP.To16().Mask(net.CIDRMask(length, 128)))] = ipnet
+}
+
+// lookup returns the longest prefix in the set containing ip, or nil.
+func (set *prefixSet) lookup(ip net.IP) *net.IPNet {
+	ip = ip.To16()
+	if ip == nil {
+		return nil
+	}
+	for _, length := range set.lengths {
+		if ipnet, ok := set.byLength[length][string(ip.Mask(net.CIDRMask(length, 128)))]; ok {
+			return ipnet
+		}
+	}
+	return nil
+}
+
+// loadPrefixFile reads a file in ZMap's blocklist / allowlist format: one
+// CIDR block or IP address per line, with # comments.
+func loadPrefixFile(fileName string) (*prefixSet, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	set := new(prefixSet)
+	scanner := bufio.NewScanner(file)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		ipnet, err := parsePrefix(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", fileName, lineNo, err)
+		}
+		set.add(ipnet)
+	}
+	return set, scanner.Err()
+}
+
+// SkippedTargets counts the targets that were not scanned because of the
+// blocklist or allowlist.
+type SkippedTargets struct {
+	// Blocklist maps each blocklisted prefix to the number of targets skipped
+	// because they fell within it.
+	Blocklist map[string]uint64 `json:"blocklist,omitempty"`
+
+	// NotAllowlisted is the number of targets skipped because they were not
+	// in the allowlist.
+	NotAllowlisted uint64 `json:"not_allowlisted,omitempty"`
+}
+
+// targetFilter enforces the blocklist and allowlist.
+type targetFilter struct {
+	mutex     sync.Mutex
+	blocklist *prefixSet
+	allowlist *prefixSet
+	skipped   SkippedTargets
+}
+
+var filter = new(targetFilter)
+
+// enabled returns true if a blocklist or allowlist is configured.
+func (f *targetFilter) enabled() bool {
+	return f.blocklist != nil || f.allowlist != nil
+}
+
+// check returns ErrBlocklisted if the address may not be scanned, and counts
+// the skip.
+func (f *targetFilter) check(ip net.IP) error {
+	if !f.enabled() {
+		return nil
+	}
+	if f.blocklist != nil {
+		if ipnet := f.blocklist.lookup(ip); ipnet != nil {
+			f.mutex.Lock()
+			defer f.mutex.Unlock()
+			if f.skipped.Blocklist == nil {
+				f.skipped.Blocklist = make(map[string]uint64)
+			}
+			f.skipped.Blocklist[ipnet.String()]++
+			return ErrBlocklisted
+		}
+	}
+	if f.allowlist != nil && f.allowlist.lookup(ip) == nil {
+		f.mutex.Lock()
+		defer f.mutex.Unlock()
+		f.skipped.NotAllowlisted++
+		return ErrBlocklisted
+	}
+	return nil
+}
+
+// allowTarget returns false if the target has an IP that may not be scanned.
+// Targets with only a domain are checked when their name is resolved.
+func (f *targetFilter) allowTarget(target *ScanTarget) bool {
+	return target.IP == nil || f.check(target.IP) == nil
+}
+
+// filterAddrs returns those of the addresses that may be scanned, or
+// ErrBlocklisted if there are none.
+func (f *targetFilter) filterAddrs(addrs []net.IPAddr) ([]net.IPAddr, error) {
+	if !f.enabled() {
+		return addrs, nil
+	}
+	var ret []net.IPAddr
+	for _, addr := range addrs {
+		if f.check(addr.IP) == nil {
+			ret = append(ret, addr)
+		}
+	}
+	if len(ret) == 0 {
+		return nil, ErrBlocklisted
+	}
+	return ret, nil
+}
+
+// checkAddress checks the host of a host:port address against the
+// blocklist / allowlist before it is dialed, resolving it if necessary. If
+// the host is a name, the returned address has it replaced by the first of
+// its addresses (of the network's family) that may be scanned, and the
+// returned Resolution records how it was resolved. With --nat64-prefix,
+// IPv4 addresses are replaced by their NAT64 addresses once checked.
+func (f *targetFilter) checkAddress(ctx context.Context, network, address string) (string, *Resolution, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return "", nil, err
+	}
+	if ip := parseHostIP(host); ip != nil {
+		if err := f.check(ip); err != nil {
+			return "", nil, err
+		}
+		if dial := nat64IP(ip); !dial.Equal(ip) {
+			address = net.JoinHostPort(dial.String(), port)
+		}
+		return address, nil, nil
+	}
+	resolved, resolution, err := resolver.lookup(ctx, host)
+	if err != nil {
+		return "", nil, err
+	}
+	var candidates []net.IPAddr
+	for _, addr := range resolved {
+		isV4 := nat64IP(addr.IP).To4() != nil
+		if (strings.HasSuffix(network, "4") && !isV4) || (strings.HasSuffix(network, "6") && isV4) {
+			continue
+		}
+		candidates = append(candidates, addr)
+	}
+	if len(candidates) == 0 {
+		return "", resolution, &net.AddrError{Err: "no suitable address found", Addr: host}
+	}
+	allowed, err := f.filterAddrs(candidates)
+	if err != nil {
+		return "", resolution, err
+	}
+	return net.JoinHostPort(nat64IP(allowed[0].IP).String(), port), resolution, nil
+}
+
+// GetSkippedTargets returns the number of targets skipped because of the
+// blocklist and allowlist, or nil if neither is configured.
+func GetSkippedTargets() *SkippedTargets {
+	if !filter.enabled() {
+		return nil
+	}
+	filter.mutex.Lock()
+	defer filter.mutex.Unlock()
+	ret := &SkippedTargets{NotAllowlisted: filter.skipped.NotAllowlisted}
+	if len(filter.skipped.Blocklist) > 0 {
+		ret.Blocklist = make(map[string]uint64, len(filter.skipped.Blocklist))
+		for k, v := range filter.skipped.Blocklist {
+			ret.Blocklist[k] = v
+		}
+	}
+	return ret
+}
@@ -0,0 +1,72 @@
+package zgrab2
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+)
+
+func TestLoadPrefixFile(t *testing.T) {
+	file, err := ioutil.TempFile("", "blocklist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString("# Reserved ranges\n10.0.0.0/8 # RFC1918\n10.1.2.0/24\n\n192.0.2.1\n2001:db8::/32\n")
+	file.Close()
+	set, err := loadPrefixFile(file.Name())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	tests := map[string]string{
+		"10.200.0.1":   "10.0.0.0/8",
+		"10.1.2.3":     "10.1.2.0/24",
+		"192.0.2.1":    "192.0.2.1/32",
+		"192.0.2.2":    "",
+		"2001:db8::1":  "2001:db8::/32",
+		"2001:db9::1":  "",
+		"::ffff:a00:1": "10.0.0.0/8",
+		"11.0.0.1":     "",
+	}
+	for ip, expected := range tests {
+		actual := ""
+		if ipnet := set.lookup(net.ParseIP(ip)); ipnet != nil {
+			actual = ipnet.String()
+		}
+		if actual != expected {
+			t.Errorf("%s: expected %q, got %q", ip, expected, actual)
+		}
+	}
+}
+
+func TestTargetFilter(t *testing.T) {
+	block, _ := parsePrefix("192.168.1.0/24")
+	allow, _ := parsePrefix("192.168.0.0/16")
+	f := &targetFilter{blocklist: new(prefixSet), allowlist: new(prefixSet)}
+	f.blocklist.add(block)
+	f.allowlist.add(allow)
+	for ip, expected := range map[string]bool{
+		"192.168.1.1": false,
+		"192.168.1.2": false,
+		"192.168.2.1": true,
+		"10.0.0.1":    false,
+	} {
+		if actual := f.allowTarget(&ScanTarget{IP: net.ParseIP(ip)}); actual != expected {
+			t.Errorf("%s: expected %v, got %v", ip, expected, actual)
+		}
+	}
+	if !f.allowTarget(&ScanTarget{Domain: "example.com"}) {
+		t.Errorf("expected domain-only targets to be allowed at ingestion")
+	}
+	if n := f.skipped.Blocklist["192.168.1.0/24"]; n != 2 {
+		t.Errorf("expected 2 blocklist skips, got %d", n)
+	}
+	if f.skipped.NotAllowlisted != 1 {
+		t.Errorf("expected 1 allowlist skip, got %d", f.skipped.NotAllowlisted)
+	}
+	if _, _, err := f.checkAddress(context.Background(), "tcp", "192.168.1.5:80"); err != ErrBlocklisted {
+		t.Errorf("expected dialing a blocklisted address to fail, got %v", err)
+	}
+}
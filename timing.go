@@ -0,0 +1,62 @@
+package zgrab2
+
+import "time"
+
+// Timing gives the time in microseconds spent in each phase of a scan. Where
+// a scan makes several connections, the durations of each phase are summed.
+type Timing struct {
+	// Resolve is the time taken to resolve the target's name.
+	Resolve int64 `json:"resolve_us,omitempty"`
+
+	// Connect is the time taken to establish TCP connections, not including
+	// name resolution.
+	Connect int64 `json:"connect_us,omitempty"`
+
+	// TLSHandshake is the time taken by TLS handshakes.
+	TLSHandshake int64 `json:"tls_handshake_us,omitempty"`
+
+	// Application is the rest of the scan: the module's own protocol
+	// exchange with the target.
+	Application int64 `json:"application_us,omitempty"`
+
+	// Total is the time taken by the whole scan.
+	Total int64 `json:"total_us"`
+}
+
+// phaseTimes accumulates the durations of the phases of a single scan.
+type phaseTimes struct {
+	resolve      time.Duration
+	connect      time.Duration
+	tlsHandshake time.Duration
+}
+
+// timing returns the Timing for a scan that took total.
+func (p *phaseTimes) timing(total time.Duration) *Timing {
+	application := total - p.resolve - p.connect - p.tlsHandshake
+	if application < 0 {
+		application = 0
+	}
+	return &Timing{
+		Resolve:      microseconds(p.resolve),
+		Connect:      microseconds(p.connect),
+		TLSHandshake: microseconds(p.tlsHandshake),
+		Application:  microseconds(application),
+		Total:        microseconds(total),
+	}
+}
+
+func microseconds(d time.Duration) int64 {
+	return int64(d / time.Microsecond)
+}
+
+// recordTLSHandshake adds the duration of a TLS handshake to the log of the
+// scan that opened conn, if conn was opened through the framework.
+func recordTLSHandshake(conn interface{}, d time.Duration) {
+	tc, ok := conn.(*TimeoutConnection)
+	if !ok || tc.log == nil {
+		return
+	}
+	tc.log.mutex.Lock()
+	defer tc.log.mutex.Unlock()
+	tc.log.phases.tlsHandshake += d
+}
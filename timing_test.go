@@ -0,0 +1,43 @@
+package zgrab2
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestPhaseTimes(t *testing.T) {
+	p := phaseTimes{
+		resolve:      2 * time.Millisecond,
+		connect:      3 * time.Millisecond,
+		tlsHandshake: 5 * time.Millisecond,
+	}
+	timing := p.timing(15 * time.Millisecond)
+	expected := Timing{Resolve: 2000, Connect: 3000, TLSHandshake: 5000, Application: 5000, Total: 15000}
+	if *timing != expected {
+		t.Errorf("expected %+v, got %+v", expected, *timing)
+	}
+	// Overlapping phases (e.g. from concurrent connections) must not make
+	// the application time negative.
+	if timing := p.timing(time.Millisecond); timing.Application != 0 {
+		t.Errorf("expected no application time, got %d", timing.Application)
+	}
+}
+
+func TestRecordConnectionTiming(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	conn := NewTimeoutConnection(nil, client, time.Second, 0, 0, 1024)
+	conn.ConnectRTT = 4 * time.Millisecond
+	conn.resolution = &Resolution{Name: "example.com", duration: time.Millisecond}
+
+	target := ScanTarget{Domain: "example.com", log: new(scanLog)}
+	target.RecordConnection(conn)
+	recordTLSHandshake(conn, 6*time.Millisecond)
+
+	phases := target.log.phases
+	if phases.resolve != time.Millisecond || phases.connect != 4*time.Millisecond || phases.tlsHandshake != 6*time.Millisecond {
+		t.Errorf("unexpected phases %+v", phases)
+	}
+}
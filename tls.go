@@ -61,6 +61,34 @@ type TLSFlags struct {
 	ClientRandom string `long:"client-random" description:"Set an explicit Client Random (base64 encoded)"`
 	// TODO: format?
 	ClientHello string `long:"client-hello" description:"Set an explicit ClientHello (base64 encoded)"`
+
+	ClientCert string `long:"tls-client-cert" description:"PEM file with a client certificate, followed by its chain, to present if the server asks for one. Requires --tls-client-key."`
+	ClientKey  string `long:"tls-client-key" description:"PEM file with the private key for --tls-client-cert"`
+
+	Resumption bool `long:"resumption" description:"After the handshake, connect again and try to resume the session, with the session ticket or session ID the server gave, and report whether it was resumed. Implies --session-ticket. TLS 1.3 tickets are not tried: a server offering no TLS 1.2 session but accepting TLS 1.3 is reported with method unsupported_tls13"`
+
+	Enumerate bool `long:"tls-enumerate" description:"After the handshake, connect again for each protocol version, cipher suite, group and signature algorithm the server accepts, and list them"`
+
+	KeyExchange bool   `long:"tls-key-exchange" description:"After the handshake, make a separate probe connection with a TLS 1.3 ClientHello offering throwaway key shares for --tls-groups, and report the group the server selects and the length of its key share. The groups are not offered in the scan's own handshake"`
+	Groups      string `long:"tls-groups" default:"X25519MLKEM768,x25519" description:"Comma-separated key exchange groups to offer with --tls-key-exchange, by name or number, most preferred first"`
+
+	CheckSCTs bool   `long:"check-scts" description:"Parse the Signed Certificate Timestamps in the TLS extension, stapled OCSP response and certificate, and verify them against --ct-log-list. Implies --sct."`
+	CTLogList string `long:"ct-log-list" description:"Certificate Transparency log list (log_list.json, as Chrome and Apple publish) to verify SCTs against with --check-scts"`
+
+	CheckRevocation   bool          `long:"check-revocation" description:"After the handshake, ask the certificate's OCSP responders and fetch its CRLs, and report whether it is revoked, and each responder's latency"`
+	RevocationTimeout time.Duration `long:"revocation-timeout" default:"10s" description:"Timeout for each OCSP request, CRL download and issuer certificate fetch with --check-revocation"`
+
+	ECH       bool   `long:"ech" description:"After the handshake, connect again offering Encrypted Client Hello with the ECHConfig in the server name's DNS HTTPS record (or GREASE ECH, if there is none), and report whether the server accepted it, or the retry configs it sent"`
+	ECHConfig string `long:"ech-config" description:"With --ech, offer this ECHConfigList (base64 encoded) instead of looking one up"`
+
+	Behavior bool `long:"tls-behavior" description:"After the handshake, connect again to check secure and client-initiated renegotiation, and whether the server rejects a downgraded ClientHello with TLS_FALLBACK_SCSV"`
+
+	ServerNames     string `long:"tls-server-names" description:"After the handshake, connect again for each of these comma-separated server names, sending it in the SNI extension, and report the certificates the server sends for it"`
+	ServerNamesFile string `long:"tls-server-names-file" description:"File of server names, one per line, to connect again for as with --tls-server-names"`
+
+	KeyLogFile string `long:"tls-keylog-file" description:"Append the secrets of each TLS handshake to this file in the NSS key log format (as with SSLKEYLOGFILE), so that packet captures of the scan can be decrypted"`
+
+	ClientProfile string `long:"tls-client-profile" description:"Send the ClientHello of a popular client instead of the default: chrome, firefox, safari, ios or golang. The fingerprint is TLS 1.2-capped: supported_versions leaves out TLS 1.3, so JA3 and JA4 differ from the real client's. Offers the client's usual ALPN protocols unless --next-protos is given."`
 }
 
 func getCSV(arg string) []string {
@@ -119,6 +147,16 @@ func (t *TLSFlags) GetTLSConfigForTarget(target *ScanTarget) (*tls.Config, error
 		// TODO FIXME: Implement
 		log.Fatalf("--certificates not implemented")
 	}
+	if (t.ClientCert == "") != (t.ClientKey == "") {
+		return nil, fmt.Errorf("--tls-client-cert and --tls-client-key must be given together")
+	}
+	if t.ClientCert != "" {
+		cert, err := loadClientCertificate(t.ClientCert, t.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("Error loading --tls-client-cert '%s': %s", t.ClientCert, err)
+		}
+		ret.Certificates = []tls.Certificate{cert}
+	}
 	if t.CertificateMap != "" {
 		// TODO FIXME: Implement
 		log.Fatalf("--certificate-map not implemented")
@@ -208,7 +246,7 @@ func (t *TLSFlags) GetTLSConfigForTarget(target *ScanTarget) (*tls.Config, error
 		ret.ExtendedRandom = false
 	}
 
-	if t.SessionTicket {
+	if t.SessionTicket || t.Resumption {
 		ret.ForceSessionTicketExt = true
 	} else {
 		ret.ForceSessionTicketExt = false
@@ -220,12 +258,26 @@ func (t *TLSFlags) GetTLSConfigForTarget(target *ScanTarget) (*tls.Config, error
 		ret.ExtendedMasterSecret = false
 	}
 
-	if t.SCTExt {
+	if t.SCTExt || t.CheckSCTs {
 		ret.SignedCertificateTimestampExt = true
 	} else {
 		ret.SignedCertificateTimestampExt = false
 	}
 
+	if t.CheckSCTs && t.CTLogList != "" {
+		if _, err = loadCTLogList(t.CTLogList); err != nil {
+			return nil, fmt.Errorf("Error loading --ct-log-list '%s': %s", t.CTLogList, err)
+		}
+	}
+
+	if t.KeyLogFile != "" {
+		writer, err := openKeyLogFile(t.KeyLogFile)
+		if err != nil {
+			return nil, fmt.Errorf("Error opening --tls-keylog-file '%s': %s", t.KeyLogFile, err)
+		}
+		ret.KeyLogWriter = writer
+	}
+
 	if t.ClientRandom != "" {
 		ret.ClientRandom, err = base64.StdEncoding.DecodeString(t.ClientRandom)
 		if err != nil {
@@ -240,6 +292,24 @@ func (t *TLSFlags) GetTLSConfigForTarget(target *ScanTarget) (*tls.Config, error
 		}
 	}
 
+	if t.ClientProfile != "" {
+		if t.ClientHello != "" {
+			return nil, fmt.Errorf("--tls-client-profile cannot be used with --client-hello")
+		}
+		profile, err := getTLSClientProfile(t.ClientProfile)
+		if err != nil {
+			return nil, err
+		}
+		nextProtos := ret.NextProtos
+		if nextProtos == nil {
+			nextProtos = []string{"h2", "http/1.1"}
+		}
+		ret.ExternalClientHello, err = profile.clientHello(ret.ServerName, nextProtos, ret.ClientRandom)
+		if err != nil {
+			return nil, fmt.Errorf("Error building --tls-client-profile ClientHello: %s", err)
+		}
+	}
+
 	return &ret, nil
 }
 
@@ -247,6 +317,26 @@ type TLSConnection struct {
 	tls.Conn
 	flags *TLSFlags
 	log   *TLSLog
+
+	// raw is the connection the TLS connection runs over.
+	raw net.Conn
+
+	// hellos records the hello messages for fingerprinting.
+	hellos *helloRecorder
+
+	// sent and received are the plaintext handshake messages, once the
+	// handshake is done.
+	sent, received [][]byte
+
+	// config is the connection's configuration, and redial opens another
+	// connection to the same target, for --resumption, --tls-enumerate,
+	// --tls-key-exchange, --ech, --tls-behavior and --tls-server-names.
+	// redial is nil if the connection was not opened by Connect.
+	config *tls.Config
+	redial func() (net.Conn, error)
+
+	// serverNames are the --tls-server-names to connect again for.
+	serverNames []string
 }
 
 type TLSLog struct {
@@ -254,6 +344,36 @@ type TLSLog struct {
 	HandshakeLog *tls.ServerHandshake `json:"handshake_log"`
 	// This will be nil if heartbleed is not checked because of client configuration flags
 	HeartbleedLog *tls.Heartbleed `json:"heartbleed_log,omitempty"`
+	// Fingerprints are the JA3S, JA4 and JA4S fingerprints of the handshake.
+	Fingerprints *TLSFingerprints `json:"fingerprints,omitempty"`
+	// Resumption is the result of trying to resume the session, with
+	// --resumption.
+	Resumption *TLSResumption `json:"resumption,omitempty"`
+	// Enumeration lists what the server accepts, with --tls-enumerate.
+	Enumeration *TLSEnumeration `json:"enumeration,omitempty"`
+	// KeyExchange is the group the server selected, with
+	// --tls-key-exchange.
+	KeyExchange *TLSKeyExchange `json:"key_exchange,omitempty"`
+	// ECH is the result of offering Encrypted Client Hello, with --ech.
+	ECH *TLSECH `json:"ech,omitempty"`
+	// Revocation is the revocation status of the server's certificate,
+	// with --check-revocation.
+	Revocation *TLSRevocation `json:"revocation,omitempty"`
+	// SCTs are the Signed Certificate Timestamps for the server's
+	// certificate, with --check-scts.
+	SCTs []*SCT `json:"scts,omitempty"`
+	// ClientCertificate records the server's request for a client
+	// certificate, and whether --tls-client-cert was accepted.
+	ClientCertificate *TLSClientCertificate `json:"client_certificate,omitempty"`
+	// Behavior is how the server handles renegotiation and downgrades,
+	// with --tls-behavior.
+	Behavior *TLSBehavior `json:"tls_behavior,omitempty"`
+	// ServerNames are the results of handshakes sending each of
+	// --tls-server-names.
+	ServerNames []*TLSServerName `json:"server_names,omitempty"`
+	// DTLS records the parts of the handshake particular to DTLS, for
+	// handshakes over UDP.
+	DTLS *DTLSLog `json:"dtls,omitempty"`
 }
 
 func (z *TLSConnection) GetLog() *TLSLog {
@@ -264,8 +384,79 @@ func (z *TLSConnection) GetLog() *TLSLog {
 	return z.log
 }
 
-func (z *TLSConnection) Handshake() error {
+func (z *TLSConnection) Handshake() (err error) {
+	start := time.Now()
+	defer func() {
+		recordTLSHandshake(z.raw, time.Since(start))
+	}()
 	log := z.GetLog()
+	if z.flags.CheckSCTs {
+		// Run after the handshake messages are recorded.
+		defer func() {
+			if log.HandshakeLog != nil && log.HandshakeLog.ServerCertificates != nil {
+				log.SCTs = z.checkSCTs()
+			}
+		}()
+	}
+	if z.flags.CheckRevocation {
+		defer func() {
+			if log.HandshakeLog != nil && log.HandshakeLog.ServerCertificates != nil {
+				log.Revocation = z.checkRevocation()
+			}
+		}()
+	}
+	if z.flags.Enumerate && z.redial != nil {
+		defer func() {
+			if log.HandshakeLog != nil && log.HandshakeLog.ServerHello != nil {
+				log.Enumeration = z.enumerate()
+			}
+		}()
+	}
+	if z.flags.KeyExchange && z.redial != nil {
+		// Like ECH, this needs TLS 1.3.
+		defer func() {
+			log.KeyExchange = z.keyExchange()
+		}()
+	}
+	if z.flags.ECH && z.redial != nil {
+		// ECH needs TLS 1.3, which zcrypto does not negotiate, so it is
+		// offered whether or not the handshake succeeded.
+		defer func() {
+			log.ECH = z.probeECH()
+		}()
+	}
+	if z.flags.Behavior && z.redial != nil {
+		// The probes find the server's versions themselves, so they run
+		// whether or not the handshake succeeded.
+		defer func() {
+			log.Behavior = z.behavior()
+		}()
+	}
+	if len(z.serverNames) > 0 && z.redial != nil {
+		// Servers may refuse the default name but accept others, so the
+		// names are tried whether or not the handshake succeeded.
+		defer func() {
+			log.ServerNames = z.probeServerNames()
+		}()
+	}
+	if z.flags.Resumption && z.redial != nil {
+		// Deferred first, so that it runs once the handshake is logged.
+		defer func() {
+			if err == nil {
+				log.Resumption = z.resume()
+			}
+		}()
+	}
+	if z.hellos != nil {
+		// Runs once the handshake messages are recorded, below.
+		defer func() {
+			log.ClientCertificate = clientCertificateResult(z.sent, z.received, err)
+		}()
+		defer func() {
+			z.sent, z.received = z.hellos.stop()
+			log.Fingerprints = handshakeFingerprints(z.sent, z.received)
+		}()
+	}
 	if z.flags.Heartbleed {
 		buf := make([]byte, 256)
 		defer func() {
@@ -300,7 +491,14 @@ func (t *TLSFlags) Connect(target *ScanTarget, flags *BaseFlags) (*TLSConnection
 	if err != nil {
 		return nil, err
 	}
-	return t.GetTLSConnectionForTarget(tcpConn, target)
+	conn, err := t.GetTLSConnectionForTarget(tcpConn, target)
+	if err != nil {
+		return nil, err
+	}
+	conn.redial = func() (net.Conn, error) {
+		return target.Open(flags)
+	}
+	return conn, nil
 }
 
 func (t *TLSFlags) GetTLSConnection(conn net.Conn) (*TLSConnection, error) {
@@ -312,10 +510,20 @@ func (t *TLSFlags) GetTLSConnectionForTarget(conn net.Conn, target *ScanTarget)
 	if err != nil {
 		return nil, fmt.Errorf("Error getting TLSConfig for options: %s", err)
 	}
-	tlsClient := tls.Client(conn, cfg)
+	serverNames, err := t.getServerNames()
+	if err != nil {
+		return nil, fmt.Errorf("Error reading --tls-server-names-file '%s': %s", t.ServerNamesFile, err)
+	}
+	hellos := &helloRecorder{Conn: conn}
+	tlsClient := tls.Client(hellos, cfg)
 	wrappedClient := TLSConnection{
-		Conn:  *tlsClient,
-		flags: t,
+		Conn:   *tlsClient,
+		flags:  t,
+		raw:    conn,
+		hellos: hellos,
+		config: cfg,
+
+		serverNames: serverNames,
 	}
 	return &wrappedClient, nil
 }
@@ -0,0 +1,444 @@
+package zgrab2
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net"
+)
+
+// TLSBehavior is the result of the renegotiation and downgrade probes, with
+// --tls-behavior.
+type TLSBehavior struct {
+	// MaxVersion is the highest version the server accepts, up to TLS 1.3.
+	MaxVersion string `json:"max_version,omitempty"`
+
+	Renegotiation *TLSRenegotiation `json:"renegotiation,omitempty"`
+	Fallback      *TLSFallback      `json:"fallback,omitempty"`
+
+	// Error is the reason the probes could not be completed, if they could
+	// not.
+	Error string `json:"error,omitempty"`
+}
+
+// TLSRenegotiation describes how the server handles renegotiation, if it
+// accepts TLS 1.2 or below.
+type TLSRenegotiation struct {
+	// Secure is true if the server supports secure renegotiation (RFC
+	// 5746), answering with the renegotiation_info extension.
+	Secure bool `json:"secure"`
+
+	// ClientInitiated is how the server answered a ClientHello sent after a
+	// complete TLS 1.2 handshake: "accepted" if it began a new handshake,
+	// "refused" if it sent an alert, "closed" if it closed the connection,
+	// or "ignored" if it did not answer in time.
+	ClientInitiated string `json:"client_initiated,omitempty"`
+
+	// Alert is the alert the server refused renegotiation with; usually
+	// 100, no_renegotiation.
+	Alert uint8 `json:"alert,omitempty"`
+
+	// Error is the reason the TLS 1.2 handshake to renegotiate failed, if
+	// it did.
+	Error string `json:"error,omitempty"`
+}
+
+// TLSFallback describes how the server answers a ClientHello with
+// TLS_FALLBACK_SCSV (RFC 7507) offering less than its highest version, as
+// clients retrying a failed handshake at a lower version do.
+type TLSFallback struct {
+	// Version is the highest version offered.
+	Version string `json:"version"`
+
+	// Rejected is true if the server refused with an inappropriate_fallback
+	// alert, preventing downgrades.
+	Rejected bool `json:"rejected"`
+
+	// Alert is any other alert the server refused with. A server that does
+	// not accept Version at all refuses with protocol_version (70), which
+	// says nothing of its support for the SCSV.
+	Alert uint8 `json:"alert,omitempty"`
+
+	// Error is the reason the probe failed, if it did.
+	Error string `json:"error,omitempty"`
+}
+
+// Cipher suites, extensions, handshake messages and alerts used in the
+// behavior probes.
+const (
+	tlsFallbackSCSV = 0x5600
+
+	tlsExtensionRenegotiationInfo = 0xff01
+
+	tlsClientKeyExchange = 16
+	tlsFinished          = 20
+
+	tlsAlertInappropriateFallback = 86
+)
+
+// tlsBehaviorCiphers are the cipher suites the renegotiation handshake can
+// use: ECDHE with AES-GCM.
+var tlsBehaviorCiphers = []uint16{0xc02f, 0xc02b, 0xc030, 0xc02c}
+
+// tlsBehaviorGroups are the groups the renegotiation handshake can use.
+var tlsBehaviorGroups = []uint16{0x001d, 0x0017}
+
+// tlsBehaviorProber sends the behavior probes to a target.
+type tlsBehaviorProber struct {
+	serverName string
+	redial     func() (net.Conn, error)
+}
+
+// hello connects to the target, sends the probe's ClientHello and returns the
+// server's ServerHello (or HelloRetryRequest). A refusal with an alert is
+// returned as a tlsAlertError.
+func (p *tlsBehaviorProber) hello(probe *tlsProbe) (*tlsHello, error) {
+	conn, err := p.redial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	recordVersion := byte(1)
+	if probe.version == 0x0300 {
+		recordVersion = 0
+	}
+	record := append([]byte{tlsRecordHandshake, 3, recordVersion}, TLSVector(2, probe.clientHello(p.serverName))...)
+	if _, err := conn.Write(record); err != nil {
+		return nil, err
+	}
+	msgs, err := readHandshake(conn, func(msgs [][]byte) bool {
+		return firstHello(msgs, tlsServerHello) != nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	hello := parseHello(firstHello(msgs, tlsServerHello))
+	if hello == nil {
+		return nil, errors.New("unparseable ServerHello")
+	}
+	if len(hello.versions) > 0 {
+		hello.version = hello.versions[0]
+	}
+	return hello, nil
+}
+
+// probe finds the server's highest version, then offers the version below
+// it with TLS_FALLBACK_SCSV, and, if the server accepts TLS 1.2, tries to
+// renegotiate.
+func (p *tlsBehaviorProber) probe() *TLSBehavior {
+	ret := new(TLSBehavior)
+	groups := sortedKeys(tlsGroupNames)
+	sigAlgs := sortedKeys(tlsSignatureAlgorithmNames)
+	ciphers := append(append(append([]uint16(nil), tlsECDHECiphers...), tlsDHECiphers...), tlsOtherCiphers...)
+
+	maxVersion := uint16(0)
+	if _, err := p.hello(&tlsProbe{version: 0x0304, ciphers: tls13Ciphers, groups: groups, sigAlgs: sigAlgs}); err == nil {
+		maxVersion = 0x0304
+	}
+	legacy, err := p.hello(&tlsProbe{version: 0x0303, ciphers: ciphers, groups: groups, sigAlgs: sigAlgs})
+	if err == nil {
+		if maxVersion == 0 {
+			maxVersion = legacy.version
+		}
+		ret.Renegotiation = &TLSRenegotiation{Secure: containsUint16(legacy.extensions, tlsExtensionRenegotiationInfo)}
+	} else if maxVersion == 0 {
+		ret.Error = err.Error()
+		return ret
+	}
+	ret.MaxVersion = tlsVersionNames[maxVersion]
+
+	if fallback := maxVersion - 1; fallback >= 0x0300 {
+		ret.Fallback = &TLSFallback{Version: tlsVersionNames[fallback]}
+		_, err := p.hello(&tlsProbe{version: fallback, ciphers: append(ciphers, tlsFallbackSCSV), groups: groups, sigAlgs: sigAlgs})
+		if alert, ok := err.(tlsAlertError); ok {
+			ret.Fallback.Rejected = alert == tlsAlertInappropriateFallback
+			if !ret.Fallback.Rejected {
+				ret.Fallback.Alert = uint8(alert)
+			}
+		} else if err != nil {
+			ret.Fallback.Error = err.Error()
+		}
+	}
+
+	if ret.Renegotiation != nil && legacy.version == 0x0303 {
+		ret.Renegotiation.ClientInitiated, ret.Renegotiation.Alert, err = p.renegotiate()
+		if err != nil {
+			ret.Renegotiation.Error = err.Error()
+		}
+	}
+	return ret
+}
+
+// tls12PRF is the TLS 1.2 PRF (RFC 5246, section 5) with the given hash.
+func tls12PRF(newHash func() hash.Hash, secret []byte, label string, seed []byte, n int) []byte {
+	seed = append([]byte(label), seed...)
+	mac := hmac.New(newHash, secret)
+	var ret []byte
+	a := seed
+	for len(ret) < n {
+		mac.Reset()
+		mac.Write(a)
+		a = mac.Sum(nil)
+		mac.Reset()
+		mac.Write(a)
+		mac.Write(seed)
+		ret = mac.Sum(ret)
+	}
+	return ret[:n]
+}
+
+// tls12GCM protects the records sent or received in one direction of a
+// TLS 1.2 connection with an AES-GCM cipher suite.
+type tls12GCM struct {
+	aead cipher.AEAD
+	// iv is the implicit part of the nonce.
+	iv  []byte
+	seq uint64
+}
+
+func newTLS12GCM(key, iv []byte) (*tls12GCM, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &tls12GCM{aead: aead, iv: iv}, nil
+}
+
+// nonceAndData returns the nonce and additional data for the next record,
+// with the given type and plaintext length, and the explicit nonce.
+func (c *tls12GCM) nonceAndData(typ byte, length int) (nonce, data, explicit []byte) {
+	explicit = make([]byte, 8)
+	binary.BigEndian.PutUint64(explicit, c.seq)
+	c.seq++
+	nonce = append(append([]byte(nil), c.iv...), explicit...)
+	data = append(append([]byte(nil), explicit...), typ, 3, 3, byte(length>>8), byte(length))
+	return nonce, data, explicit
+}
+
+// seal returns a record holding the plaintext.
+func (c *tls12GCM) seal(typ byte, plaintext []byte) []byte {
+	nonce, data, explicit := c.nonceAndData(typ, len(plaintext))
+	body := c.aead.Seal(explicit, nonce, plaintext, data)
+	return append([]byte{typ, 3, 3}, TLSVector(2, body)...)
+}
+
+// open returns the plaintext of a record's body.
+func (c *tls12GCM) open(typ byte, body []byte) ([]byte, error) {
+	if len(body) < 8+c.aead.Overhead() {
+		return nil, errors.New("record too short")
+	}
+	nonce, data, _ := c.nonceAndData(typ, len(body)-8-c.aead.Overhead())
+	nonce = append(nonce[:len(c.iv)], body[:8]...)
+	return c.aead.Open(nil, nonce, body[8:], data)
+}
+
+// readRecord reads a record from conn, returning its type and body.
+func readRecord(conn net.Conn) (byte, []byte, error) {
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return 0, nil, err
+	}
+	body := make([]byte, binary.BigEndian.Uint16(header[3:5]))
+	if _, err := io.ReadFull(conn, body); err != nil {
+		return 0, nil, err
+	}
+	return header[0], body, nil
+}
+
+// ecdheShare returns the client's public key and the shared secret for the
+// group and the server's public key.
+func ecdheShare(group uint16, server []byte) (public, shared []byte, err error) {
+	switch group {
+	case 0x001d:
+		private, public, err := x25519KeyPair()
+		if err != nil {
+			return nil, nil, err
+		}
+		shared, err := x25519(private, server)
+		return public, shared, err
+	case 0x0017:
+		curve := elliptic.P256()
+		x, y := elliptic.Unmarshal(curve, server)
+		if x == nil {
+			return nil, nil, errors.New("invalid secp256r1 public key")
+		}
+		private, publicX, publicY, err := elliptic.GenerateKey(curve, rand.Reader)
+		if err != nil {
+			return nil, nil, err
+		}
+		sharedX, _ := curve.ScalarMult(x, y, private)
+		shared := make([]byte, 32)
+		b := sharedX.Bytes()
+		copy(shared[32-len(b):], b)
+		return elliptic.Marshal(curve, publicX, publicY), shared, nil
+	}
+	return nil, nil, fmt.Errorf("unexpected group %d", group)
+}
+
+// renegotiate completes a TLS 1.2 handshake with an ECDHE AES-GCM cipher
+// suite, then sends a ClientHello to start another, and reports how the
+// server answered. The server's signature and certificate are not checked.
+func (p *tlsBehaviorProber) renegotiate() (string, uint8, error) {
+	conn, err := p.redial()
+	if err != nil {
+		return "", 0, err
+	}
+	defer conn.Close()
+	probe := &tlsProbe{version: 0x0303, ciphers: tlsBehaviorCiphers, groups: tlsBehaviorGroups, sigAlgs: sortedKeys(tlsSignatureAlgorithmNames)}
+	clientHello := probe.clientHello(p.serverName)
+	if _, err := conn.Write(append([]byte{tlsRecordHandshake, 3, 1}, TLSVector(2, clientHello)...)); err != nil {
+		return "", 0, err
+	}
+	msgs, err := readHandshake(conn, func(msgs [][]byte) bool {
+		return len(msgs) > 0 && msgs[len(msgs)-1][0] == tlsServerHelloDone
+	})
+	if err != nil {
+		return "", 0, err
+	}
+	serverHello := firstHello(msgs, tlsServerHello)
+	hello := parseHello(serverHello)
+	if hello == nil || hello.version != 0x0303 || !containsUint16(tlsBehaviorCiphers, hello.ciphers[0]) {
+		return "", 0, errors.New("server did not choose a TLS 1.2 ECDHE AES-GCM cipher suite")
+	}
+	suite := hello.ciphers[0]
+	newHash, keyLength := sha256.New, 16
+	if suite == 0xc030 || suite == 0xc02c {
+		newHash, keyLength = sha512.New384, 32
+	}
+
+	transcript := append([]byte(nil), clientHello...)
+	var public, preMaster []byte
+	var certificateRequested bool
+	for _, msg := range msgs {
+		transcript = append(transcript, msg...)
+		switch msg[0] {
+		case tlsServerKeyExchange:
+			r := &helloReader{data: msg[4:], ok: true}
+			if r.uint8() != 3 {
+				return "", 0, errors.New("unsupported ServerKeyExchange")
+			}
+			group := r.uint16()
+			server := r.vector(1).data
+			if !r.ok {
+				return "", 0, errors.New("unparseable ServerKeyExchange")
+			}
+			if public, preMaster, err = ecdheShare(group, server); err != nil {
+				return "", 0, err
+			}
+		case tlsCertificateRequest:
+			certificateRequested = true
+		}
+	}
+	if preMaster == nil {
+		return "", 0, errors.New("no ServerKeyExchange")
+	}
+
+	var flight []byte
+	if certificateRequested {
+		certificate := append([]byte{tlsCertificate}, TLSVector(3, TLSVector(3))...)
+		transcript = append(transcript, certificate...)
+		flight = append(flight, tlsRecordHandshake, 3, 3)
+		flight = append(flight, TLSVector(2, certificate)...)
+	}
+	keyExchange := append([]byte{tlsClientKeyExchange}, TLSVector(3, TLSVector(1, public))...)
+	transcript = append(transcript, keyExchange...)
+	flight = append(flight, tlsRecordHandshake, 3, 3)
+	flight = append(flight, TLSVector(2, keyExchange)...)
+	flight = append(flight, tlsRecordChangeCipherSpec, 3, 3, 0, 1, 1)
+
+	random := func(msg []byte) []byte { return msg[6:38] }
+	master := tls12PRF(newHash, preMaster, "master secret", append(append([]byte(nil), random(clientHello)...), random(serverHello)...), 48)
+	keys := tls12PRF(newHash, master, "key expansion", append(append([]byte(nil), random(serverHello)...), random(clientHello)...), 2*keyLength+8)
+	client, err := newTLS12GCM(keys[:keyLength], keys[2*keyLength:2*keyLength+4])
+	if err != nil {
+		return "", 0, err
+	}
+	server, err := newTLS12GCM(keys[keyLength:2*keyLength], keys[2*keyLength+4:])
+	if err != nil {
+		return "", 0, err
+	}
+	digest := newHash()
+	digest.Write(transcript)
+	verifyData := tls12PRF(newHash, master, "client finished", digest.Sum(nil), 12)
+	finished := append([]byte{tlsFinished}, TLSVector(3, verifyData)...)
+	flight = append(flight, client.seal(tlsRecordHandshake, finished)...)
+	if _, err := conn.Write(flight); err != nil {
+		return "", 0, err
+	}
+
+	encrypted := false
+	for done := false; !done; {
+		typ, body, err := readRecord(conn)
+		if err != nil {
+			return "", 0, err
+		}
+		switch {
+		case typ == tlsRecordChangeCipherSpec:
+			encrypted = true
+		case typ == tlsRecordAlert && !encrypted && len(body) >= 2:
+			return "", 0, tlsAlertError(body[1])
+		case encrypted:
+			plaintext, err := server.open(typ, body)
+			if err != nil {
+				return "", 0, err
+			}
+			if typ == tlsRecordAlert && len(plaintext) >= 2 {
+				return "", 0, tlsAlertError(plaintext[1])
+			}
+			done = typ == tlsRecordHandshake && len(plaintext) > 0 && plaintext[0] == tlsFinished
+		}
+	}
+
+	probe.renegotiationInfo = verifyData
+	if _, err := conn.Write(client.seal(tlsRecordHandshake, probe.clientHello(p.serverName))); err != nil {
+		return "closed", 0, nil
+	}
+	for {
+		typ, body, err := readRecord(conn)
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			return "ignored", 0, nil
+		} else if err != nil {
+			return "closed", 0, nil
+		}
+		plaintext, err := server.open(typ, body)
+		if err != nil {
+			return "", 0, err
+		}
+		switch typ {
+		case tlsRecordAlert:
+			if len(plaintext) < 2 {
+				return "", 0, errors.New("malformed alert")
+			}
+			return "refused", plaintext[1], nil
+		case tlsRecordHandshake:
+			if bytes.HasPrefix(plaintext, []byte{tlsServerHello}) {
+				return "accepted", 0, nil
+			}
+		}
+		// Other records, such as application data the server was
+		// already sending, are skipped.
+	}
+}
+
+// behavior runs the behavior probes against the connection's target.
+func (z *TLSConnection) behavior() *TLSBehavior {
+	p := &tlsBehaviorProber{redial: z.redial}
+	if z.config != nil {
+		p.serverName = z.config.ServerName
+	}
+	return p.probe()
+}
@@ -0,0 +1,65 @@
+package zgrab2
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestTLSBehavior(t *testing.T) {
+	key := testKey(t)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	cert := testCertificate(t, template, template, key, key)
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{cert.Raw}, PrivateKey: key}},
+		MinVersion:   tls.VersionTLS10,
+		MaxVersion:   tls.VersionTLS12,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				// Reading handles the renegotiation ClientHello, which
+				// the server refuses.
+				conn.Read(make([]byte, 1))
+			}()
+		}
+	}()
+
+	p := &tlsBehaviorProber{serverName: "example.com", redial: func() (net.Conn, error) {
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		if err == nil {
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+		}
+		return conn, err
+	}}
+	behavior := p.probe()
+	if behavior.Error != "" || behavior.MaxVersion != "TLSv1.2" {
+		t.Fatalf("wrong result %+v", behavior)
+	}
+	// Go servers refuse with unexpected_message, not no_renegotiation.
+	expected := TLSRenegotiation{Secure: true, ClientInitiated: "refused", Alert: 10}
+	if renegotiation := behavior.Renegotiation; renegotiation == nil || *renegotiation != expected {
+		t.Errorf("got renegotiation %+v, expected %+v", renegotiation, expected)
+	}
+	if fallback := behavior.Fallback; fallback == nil || *fallback != (TLSFallback{Version: "TLSv1.1", Rejected: true}) {
+		t.Errorf("wrong fallback %+v", fallback)
+	}
+}
@@ -0,0 +1,172 @@
+package zgrab2
+
+import (
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/zmap/zcrypto/tls"
+)
+
+// TLSClientCertificate records the server's request for a client
+// certificate, and whether the one given with --tls-client-cert was
+// accepted.
+type TLSClientCertificate struct {
+	// Requested is true if the server sent a CertificateRequest.
+	Requested bool `json:"requested"`
+
+	// CertificateTypes, SignatureAlgorithms and CertificateAuthorities are
+	// the kinds of certificate the server asked for, and the
+	// distinguished names of the CAs it accepts.
+	CertificateTypes       []string `json:"certificate_types,omitempty"`
+	SignatureAlgorithms    []string `json:"signature_algorithms,omitempty"`
+	CertificateAuthorities []string `json:"certificate_authorities,omitempty"`
+
+	// Sent is true if a certificate was presented.
+	Sent bool `json:"sent"`
+
+	// Accepted is true if a certificate was presented, and the server
+	// completed the handshake.
+	Accepted bool `json:"accepted"`
+
+	// Error is the handshake error, if a certificate was presented and the
+	// handshake failed.
+	Error string `json:"error,omitempty"`
+}
+
+// TLS handshake messages for client authentication.
+const (
+	tlsCertificate        = 11
+	tlsCertificateRequest = 13
+)
+
+// tlsCertificateTypeNames are the names of ClientCertificateTypes.
+var tlsCertificateTypeNames = map[uint16]string{
+	1:  "rsa_sign",
+	2:  "dss_sign",
+	3:  "rsa_fixed_dh",
+	4:  "dss_fixed_dh",
+	64: "ecdsa_sign",
+	65: "rsa_fixed_ecdh",
+	66: "ecdsa_fixed_ecdh",
+}
+
+// attributeTypeNames are the short names (RFC 4514) of the attribute types
+// in distinguished names.
+var attributeTypeNames = map[string]string{
+	"2.5.4.3":                    "CN",
+	"2.5.4.5":                    "SERIALNUMBER",
+	"2.5.4.6":                    "C",
+	"2.5.4.7":                    "L",
+	"2.5.4.8":                    "ST",
+	"2.5.4.9":                    "STREET",
+	"2.5.4.10":                   "O",
+	"2.5.4.11":                   "OU",
+	"0.9.2342.19200300.100.1.25": "DC",
+	"1.2.840.113549.1.9.1":       "emailAddress",
+}
+
+// distinguishedName formats a DER-encoded distinguished name as in RFC 4514,
+// or returns it in hex if it cannot be parsed.
+func distinguishedName(der []byte) string {
+	var rdns pkix.RDNSequence
+	if rest, err := asn1.Unmarshal(der, &rdns); err != nil || len(rest) != 0 {
+		return fmt.Sprintf("%x", der)
+	}
+	escape := strings.NewReplacer(`\`, `\\`, `,`, `\,`, `+`, `\+`, `"`, `\"`, `<`, `\<`, `>`, `\>`, `;`, `\;`)
+	var parts []string
+	for i := len(rdns) - 1; i >= 0; i-- {
+		var attributes []string
+		for _, attribute := range rdns[i] {
+			typ, ok := attributeTypeNames[attribute.Type.String()]
+			if !ok {
+				typ = attribute.Type.String()
+			}
+			attributes = append(attributes, typ+"="+escape.Replace(fmt.Sprint(attribute.Value)))
+		}
+		parts = append(parts, strings.Join(attributes, "+"))
+	}
+	return strings.Join(parts, ",")
+}
+
+// parseCertificateRequest parses the body of a CertificateRequest. Before
+// TLS 1.2, it has no signature algorithms.
+func parseCertificateRequest(ret *TLSClientCertificate, body []byte, version uint16) {
+	r := &helloReader{data: body, ok: true}
+	types := r.vector(1)
+	var values []uint16
+	for _, t := range types.data {
+		values = append(values, uint16(t))
+	}
+	ret.CertificateTypes = tlsValueNames(values, tlsCertificateTypeNames)
+	if version >= tls.VersionTLS12 {
+		ret.SignatureAlgorithms = tlsValueNames(r.vector(2).uint16s(), tlsSignatureAlgorithmNames)
+	}
+	authorities := r.vector(2)
+	for authorities.ok && len(authorities.data) > 0 {
+		name := authorities.vector(2)
+		if !name.ok {
+			break
+		}
+		ret.CertificateAuthorities = append(ret.CertificateAuthorities, distinguishedName(name.data))
+	}
+}
+
+// clientCertificateResult returns what the handshake messages sent and
+// received show of client authentication, or nil if the server did not ask
+// for a certificate and none was sent.
+func clientCertificateResult(sent, received [][]byte, err error) *TLSClientCertificate {
+	ret := new(TLSClientCertificate)
+	var version uint16
+	if hello := parseHello(firstHello(received, tlsServerHello)); hello != nil {
+		version = hello.version
+	}
+	for _, msg := range received {
+		if msg[0] == tlsCertificateRequest {
+			ret.Requested = true
+			parseCertificateRequest(ret, msg[4:], version)
+			break
+		}
+	}
+	for _, msg := range sent {
+		// A client without a certificate sends an empty list.
+		if msg[0] == tlsCertificate && len(msg) > 7 {
+			ret.Sent = true
+		}
+	}
+	if !ret.Requested && !ret.Sent {
+		return nil
+	}
+	if ret.Sent {
+		if err != nil {
+			ret.Error = err.Error()
+		} else {
+			ret.Accepted = true
+		}
+	}
+	return ret
+}
+
+var clientCertificates = struct {
+	sync.Mutex
+	certificates map[[2]string]tls.Certificate
+}{certificates: make(map[[2]string]tls.Certificate)}
+
+// loadClientCertificate returns the certificate and key in the PEM files,
+// reading them once.
+func loadClientCertificate(certFile, keyFile string) (tls.Certificate, error) {
+	clientCertificates.Lock()
+	defer clientCertificates.Unlock()
+	key := [2]string{certFile, keyFile}
+	if cert, ok := clientCertificates.certificates[key]; ok {
+		return cert, nil
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return cert, err
+	}
+	clientCertificates.certificates[key] = cert
+	return cert, nil
+}
@@ -0,0 +1,55 @@
+package zgrab2
+
+import (
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestClientCertificateResult(t *testing.T) {
+	name, err := asn1.Marshal(pkix.Name{CommonName: "Device CA", Organization: []string{"Example, Inc."}}.ToRDNSequence())
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverHello := func(version uint16) []byte {
+		return tlsHandshake(tlsServerHello, TLSUint16s(version), make([]byte, 32), TLSVector(1), TLSUint16s(0xc02f), []byte{0})
+	}
+	request := tlsHandshake(tlsCertificateRequest,
+		TLSVector(1, []byte{1, 64}),
+		TLSVector(2, TLSUint16s(0x0403, 0x0804)),
+		TLSVector(2, TLSVector(2, name)),
+	)
+	certificate := tlsHandshake(tlsCertificate, TLSVector(3, TLSVector(3, []byte{0x30, 0})))
+	empty := tlsHandshake(tlsCertificate, TLSVector(3))
+
+	accepted := clientCertificateResult([][]byte{certificate}, [][]byte{serverHello(0x0303), request}, nil)
+	expected := &TLSClientCertificate{
+		Requested:              true,
+		CertificateTypes:       []string{"rsa_sign", "ecdsa_sign"},
+		SignatureAlgorithms:    []string{"ecdsa_secp256r1_sha256", "rsa_pss_rsae_sha256"},
+		CertificateAuthorities: []string{`CN=Device CA,O=Example\, Inc.`},
+		Sent:                   true,
+		Accepted:               true,
+	}
+	if !reflect.DeepEqual(accepted, expected) {
+		t.Errorf("got %+v, expected %+v", accepted, expected)
+	}
+
+	rejected := clientCertificateResult([][]byte{certificate}, [][]byte{serverHello(0x0303), request}, errors.New("remote error: bad certificate"))
+	if !rejected.Sent || rejected.Accepted || rejected.Error != "remote error: bad certificate" {
+		t.Errorf("wrong result %+v", rejected)
+	}
+
+	// Before TLS 1.2, there are no signature algorithms.
+	legacy := tlsHandshake(tlsCertificateRequest, TLSVector(1, []byte{1}), TLSVector(2, TLSVector(2, name)))
+	requested := clientCertificateResult([][]byte{empty}, [][]byte{serverHello(0x0301), legacy}, errors.New("remote error: handshake failure"))
+	if !requested.Requested || requested.Sent || requested.Accepted || requested.Error != "" || len(requested.SignatureAlgorithms) != 0 || len(requested.CertificateAuthorities) != 1 {
+		t.Errorf("wrong result %+v", requested)
+	}
+
+	if result := clientCertificateResult(nil, [][]byte{serverHello(0x0303)}, nil); result != nil {
+		t.Errorf("got %+v without a request", result)
+	}
+}
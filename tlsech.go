@@ -0,0 +1,518 @@
+package zgrab2
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// TLSECH is the result of offering Encrypted Client Hello (ECH) to the
+// server, with --ech.
+type TLSECH struct {
+	// ConfigSource is where the ECHConfigs came from: "dns" for the server
+	// name's HTTPS record, or "flag" for --ech-config. It is absent if none
+	// were found.
+	ConfigSource string `json:"config_source,omitempty"`
+
+	// Configs are the ECHConfigs found.
+	Configs []*ECHConfig `json:"configs,omitempty"`
+
+	// LookupError is the reason the HTTPS record could not be looked up, if
+	// it could not.
+	LookupError string `json:"lookup_error,omitempty"`
+
+	// Grease is true if no usable ECHConfig was found, and a GREASE ECH
+	// extension (with a random key and payload) was sent instead.
+	Grease bool `json:"grease,omitempty"`
+
+	// Accepted is true if the server decrypted the inner ClientHello and
+	// continued the handshake with it.
+	Accepted bool `json:"accepted"`
+
+	// RetryConfigs are the ECHConfigs the server sent when it did not accept
+	// ECH, for clients to retry with. Servers supporting ECH send them in
+	// reply to GREASE too.
+	RetryConfigs []*ECHConfig `json:"retry_configs,omitempty"`
+
+	// Error is the reason the handshake failed, if it did.
+	Error string `json:"error,omitempty"`
+}
+
+// ECHConfig is a server's ECH configuration: the key to encrypt the inner
+// ClientHello to, and the name of the client-facing server to send in the
+// outer one. Only the version is given for versions other than RFC 9849's.
+type ECHConfig struct {
+	Version           uint16           `json:"version"`
+	ConfigID          uint8            `json:"config_id"`
+	KEMID             uint16           `json:"kem_id"`
+	PublicKey         []byte           `json:"public_key,omitempty"`
+	CipherSuites      []ECHCipherSuite `json:"cipher_suites,omitempty"`
+	MaximumNameLength uint8            `json:"maximum_name_length"`
+	PublicName        string           `json:"public_name,omitempty"`
+
+	// raw is the encoded ECHConfig, which the HPKE context is bound to.
+	raw []byte
+}
+
+// ECHCipherSuite is an HPKE KDF and AEAD pair an ECHConfig accepts.
+type ECHCipherSuite struct {
+	KDFID  uint16 `json:"kdf_id"`
+	AEADID uint16 `json:"aead_id"`
+}
+
+// echLookupTimeout bounds the HTTPS record lookup for --ech.
+const echLookupTimeout = 10 * time.Second
+
+// TLS values used in ECH probes.
+const (
+	tlsRecordApplicationData = 23
+
+	tlsEncryptedExtensions = 8
+
+	// tlsExtensionECH is the encrypted_client_hello extension, and
+	// echVersion the ECHConfig version, of RFC 9849.
+	tlsExtensionECH = 0xfe0d
+	echVersion      = 0xfe0d
+
+	echClientHelloOuter = 0
+	echClientHelloInner = 1
+
+	// ECH probes only offer TLS_AES_128_GCM_SHA256 and X25519, which TLS
+	// 1.3 servers must (and, for X25519, should) support.
+	echCipherSuite = 0x1301
+	echGroup       = 0x001d
+)
+
+var echSignatureAlgorithms = []uint16{0x0403, 0x0804, 0x0401, 0x0503, 0x0805, 0x0501, 0x0806, 0x0601, 0x0807}
+
+// parseECHConfigList parses an ECHConfigList, as found in HTTPS records and
+// retry configs.
+func parseECHConfigList(data []byte) ([]*ECHConfig, error) {
+	r := &helloReader{data: data, ok: true}
+	list := r.vector(2)
+	if !r.ok || len(r.data) != 0 {
+		return nil, errors.New("invalid ECHConfigList")
+	}
+	var ret []*ECHConfig
+	for list.ok && len(list.data) > 0 {
+		start := list.data
+		config := &ECHConfig{Version: list.uint16()}
+		contents := list.vector(2)
+		if !list.ok {
+			break
+		}
+		config.raw = start[:len(start)-len(list.data)]
+		if config.Version == echVersion {
+			config.ConfigID = uint8(contents.uint8())
+			config.KEMID = contents.uint16()
+			config.PublicKey = contents.vector(2).data
+			suites := contents.vector(2)
+			for suites.ok && len(suites.data) >= 4 {
+				config.CipherSuites = append(config.CipherSuites, ECHCipherSuite{KDFID: suites.uint16(), AEADID: suites.uint16()})
+			}
+			config.MaximumNameLength = uint8(contents.uint8())
+			config.PublicName = string(contents.vector(1).data)
+			contents.vector(2)
+			if !contents.ok {
+				return nil, errors.New("invalid ECHConfig")
+			}
+		}
+		ret = append(ret, config)
+	}
+	if !list.ok {
+		return nil, errors.New("invalid ECHConfigList")
+	}
+	return ret, nil
+}
+
+// usableECHConfig returns the first of the configs with the RFC 9849
+// version, an X25519 key and a cipher suite that can be used, and that
+// suite.
+func usableECHConfig(configs []*ECHConfig) (*ECHConfig, ECHCipherSuite) {
+	for _, config := range configs {
+		if config.Version != echVersion || config.KEMID != hpkeKEMX25519 || len(config.PublicKey) != 32 || config.PublicName == "" {
+			continue
+		}
+		for _, suite := range config.CipherSuites {
+			if suite.KDFID == hpkeKDFSHA256 && (suite.AEADID == hpkeAEADAES128GCM || suite.AEADID == hpkeAEADAES256GCM) {
+				return config, suite
+			}
+		}
+	}
+	return nil, ECHCipherSuite{}
+}
+
+// echClientHello returns the body of a TLS 1.3 ClientHello offering an
+// X25519 key share, with the ECH extension last.
+func echClientHello(random, sessionID []byte, serverName string, keyShare, ech []byte) []byte {
+	var extensions [][]byte
+	if serverName != "" {
+		extensions = append(extensions, TLSExtension(tlsExtensionServerName, TLSVector(2, []byte{0}, TLSVector(2, []byte(serverName)))))
+	}
+	extensions = append(extensions,
+		TLSExtension(tlsExtensionSupportedGroups, TLSVector(2, TLSUint16s(echGroup))),
+		TLSExtension(tlsExtensionSignatureAlgorithms, TLSVector(2, TLSUint16s(echSignatureAlgorithms...))),
+		TLSExtension(tlsExtensionSupportedVersions, TLSVector(1, TLSUint16s(0x0304))),
+		TLSExtension(tlsExtensionKeyShare, TLSVector(2, TLSUint16s(echGroup), TLSVector(2, keyShare))),
+		TLSExtension(tlsExtensionECH, ech),
+	)
+	return bytes.Join([][]byte{
+		TLSUint16s(0x0303),
+		random,
+		TLSVector(1, sessionID),
+		TLSVector(2, TLSUint16s(echCipherSuite)),
+		TLSVector(1, []byte{0}),
+		TLSVector(2, extensions...),
+	}, nil)
+}
+
+// echPadding returns the number of zeros to pad an encoded inner ClientHello
+// with, so that its length does not reveal the server name (RFC 9849 section
+// 6.1.3).
+func echPadding(config *ECHConfig, serverName string, length int) int {
+	pad := int(config.MaximumNameLength) - len(serverName)
+	if pad < 0 {
+		pad = 0
+	}
+	return pad + 31 - (length+pad-1)%32
+}
+
+// echProbe is a ClientHello offering ECH.
+type echProbe struct {
+	// outer and inner are the ClientHelloOuter and, unless GREASE is sent,
+	// ClientHelloInner handshake messages.
+	outer, inner []byte
+
+	// private is the private key of their X25519 key share.
+	private []byte
+}
+
+// newECHProbe returns a probe offering the config with the suite, naming
+// the server in the inner ClientHello, or a GREASE ECH extension if config
+// is nil.
+func newECHProbe(config *ECHConfig, suite ECHCipherSuite, serverName string) (*echProbe, error) {
+	private, public, err := x25519KeyPair()
+	if err != nil {
+		return nil, err
+	}
+	outerRandom, innerRandom, sessionID := make([]byte, 32), make([]byte, 32), make([]byte, 32)
+	for _, b := range [][]byte{outerRandom, innerRandom, sessionID} {
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+	}
+	p := &echProbe{private: private}
+	if config == nil {
+		// A GREASE extension has a random config ID, key and payload, the
+		// size of a real one (RFC 9849 section 6.2).
+		grease := make([]byte, 1+32+208)
+		if _, err := rand.Read(grease); err != nil {
+			return nil, err
+		}
+		ext := bytes.Join([][]byte{
+			{echClientHelloOuter},
+			TLSUint16s(hpkeKDFSHA256, hpkeAEADAES128GCM),
+			grease[:1],
+			TLSVector(2, grease[1:33]),
+			TLSVector(2, grease[33:]),
+		}, nil)
+		p.outer = append([]byte{tlsClientHello}, TLSVector(3, echClientHello(outerRandom, sessionID, serverName, public, ext))...)
+		return p, nil
+	}
+
+	innerExt := []byte{echClientHelloInner}
+	p.inner = append([]byte{tlsClientHello}, TLSVector(3, echClientHello(innerRandom, sessionID, serverName, public, innerExt))...)
+	// The encoded inner ClientHello leaves out the session ID, which the
+	// server copies from the outer one.
+	encoded := echClientHello(innerRandom, nil, serverName, public, innerExt)
+	encoded = append(encoded, make([]byte, echPadding(config, serverName, len(encoded)))...)
+	info := append([]byte("tls ech\x00"), config.raw...)
+	enc, aead, nonce, err := hpkeSetupSender(config.PublicKey, suite.AEADID, info)
+	if err != nil {
+		return nil, err
+	}
+	outerExt := func(payload []byte) []byte {
+		return bytes.Join([][]byte{
+			{echClientHelloOuter},
+			TLSUint16s(suite.KDFID, suite.AEADID),
+			{config.ConfigID},
+			TLSVector(2, enc),
+			TLSVector(2, payload),
+		}, nil)
+	}
+	// The payload is authenticated along with the rest of the outer
+	// ClientHello, in which it is replaced by zeros.
+	aad := echClientHello(outerRandom, sessionID, config.PublicName, public, outerExt(make([]byte, len(encoded)+aead.Overhead())))
+	payload := aead.Seal(nil, nonce, encoded, aad)
+	p.outer = append([]byte{tlsClientHello}, TLSVector(3, echClientHello(outerRandom, sessionID, config.PublicName, public, outerExt(payload)))...)
+	return p, nil
+}
+
+// TLS13ExpandLabel is HKDF-Expand-Label (RFC 8446 section 7.1) with
+// SHA-256.
+func TLS13ExpandLabel(secret []byte, label string, context []byte, length int) []byte {
+	info := bytes.Join([][]byte{TLSUint16s(uint16(length)), TLSVector(1, []byte("tls13 "+label)), TLSVector(1, context)}, nil)
+	return hkdfExpand(secret, info, length)
+}
+
+// TLSTranscriptHash returns the SHA-256 hash of the handshake messages.
+func TLSTranscriptHash(msgs ...[]byte) []byte {
+	h := sha256.New()
+	for _, msg := range msgs {
+		h.Write(msg)
+	}
+	return h.Sum(nil)
+}
+
+// echAccepted returns true if the ServerHello confirms that the server
+// accepted the inner ClientHello (RFC 9849 section 7.2): the last 8 bytes of
+// its random are derived from the inner ClientHello's random, and the
+// transcript with those bytes zeroed.
+func echAccepted(inner, serverHello []byte) bool {
+	if len(inner) < 38 || len(serverHello) < 38 {
+		return false
+	}
+	zeroed := append([]byte(nil), serverHello...)
+	copy(zeroed[30:38], make([]byte, 8))
+	secret := hkdf.Extract(sha256.New, inner[6:38], nil)
+	confirmation := TLS13ExpandLabel(secret, "ech accept confirmation", TLSTranscriptHash(inner, zeroed), 8)
+	return hmac.Equal(confirmation, serverHello[30:38])
+}
+
+// tls13ServerHandshakeKeys returns the AEAD and IV protecting the server's
+// handshake messages, for the ECDHE shared secret and the ClientHello and
+// ServerHello of a TLS_AES_128_GCM_SHA256 handshake without a PSK.
+func tls13ServerHandshakeKeys(shared, clientHello, serverHello []byte) (cipher.AEAD, []byte, error) {
+	early := hkdf.Extract(sha256.New, make([]byte, sha256.Size), nil)
+	derived := TLS13ExpandLabel(early, "derived", TLSTranscriptHash(), sha256.Size)
+	handshake := hkdf.Extract(sha256.New, shared, derived)
+	traffic := TLS13ExpandLabel(handshake, "s hs traffic", TLSTranscriptHash(clientHello, serverHello), sha256.Size)
+	block, err := aes.NewCipher(TLS13ExpandLabel(traffic, "key", nil, 16))
+	if err != nil {
+		return nil, nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, nil, err
+	}
+	return aead, TLS13ExpandLabel(traffic, "iv", nil, 12), nil
+}
+
+// decryptTLS13Record decrypts the first record protected by the keys,
+// returning its content type and content.
+func decryptTLS13Record(aead cipher.AEAD, iv, record []byte) (byte, []byte, error) {
+	plaintext, err := aead.Open(nil, iv, record[5:], record[:5])
+	if err != nil {
+		return 0, nil, err
+	}
+	// The content is followed by its type, then padding.
+	i := len(plaintext) - 1
+	for i >= 0 && plaintext[i] == 0 {
+		i--
+	}
+	if i < 0 {
+		return 0, nil, errors.New("record has no content type")
+	}
+	return plaintext[i], plaintext[:i], nil
+}
+
+// encryptedRecord returns the first complete application data record at the
+// start of data, with its header, or nil if there is none.
+func encryptedRecord(data []byte) []byte {
+	for len(data) >= 5 {
+		length := int(binary.BigEndian.Uint16(data[3:5]))
+		if len(data) < 5+length {
+			break
+		}
+		if data[0] == tlsRecordApplicationData {
+			return data[:5+length]
+		}
+		data = data[5+length:]
+	}
+	return nil
+}
+
+// extensionBody returns the body of the extension of the given type in the
+// extensions, or nil if it is not there.
+func extensionBody(extensions *helloReader, typ uint16) []byte {
+	for extensions.ok && len(extensions.data) >= 4 {
+		t := extensions.uint16()
+		body := extensions.vector(2)
+		if t == typ && body.ok {
+			return body.data
+		}
+	}
+	return nil
+}
+
+// readECHReply reads the server's reply to an ECH probe: its ServerHello
+// and, if it negotiated TLS 1.3, the first encrypted record.
+func readECHReply(conn net.Conn) (serverHello, record []byte, err error) {
+	var data []byte
+	buf := make([]byte, 4096)
+	for len(data) < helloRecordLimit {
+		n, err := conn.Read(buf)
+		data = append(data, buf[:n]...)
+		if msg := firstHello(handshakeMessages(data), tlsServerHello); msg != nil {
+			hello := parseHello(msg)
+			if hello == nil || isHelloRetryRequest(msg) || !containsUint16(hello.versions, 0x0304) {
+				return msg, nil, nil
+			}
+			if record := encryptedRecord(data); record != nil {
+				return msg, record, nil
+			}
+		}
+		if alert, ok := recordAlert(data); ok {
+			return nil, nil, fmt.Errorf("server sent alert %d", alert)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return nil, nil, errors.New("handshake too long")
+}
+
+// echProber offers ECH to a target.
+type echProber struct {
+	serverName string
+	redial     func() (net.Conn, error)
+}
+
+// offer connects to the target and sends the probe, returning whether the
+// server accepted the inner ClientHello, or the retry configs it sent if
+// not. The server's EncryptedExtensions, which hold the retry configs, are
+// decrypted with the key exchanged in the outer ClientHello; the rest of the
+// handshake is not read.
+func (e *echProber) offer(p *echProbe) (bool, []*ECHConfig, error) {
+	conn, err := e.redial()
+	if err != nil {
+		return false, nil, err
+	}
+	defer conn.Close()
+	if _, err := conn.Write(append([]byte{tlsRecordHandshake, 3, 1}, TLSVector(2, p.outer)...)); err != nil {
+		return false, nil, err
+	}
+	serverHello, record, err := readECHReply(conn)
+	if err != nil {
+		return false, nil, err
+	}
+	hello := parseHello(serverHello)
+	switch {
+	case hello == nil:
+		return false, nil, errors.New("unparseable ServerHello")
+	case isHelloRetryRequest(serverHello):
+		return false, nil, errors.New("server sent a HelloRetryRequest, as it does not accept X25519")
+	case !containsUint16(hello.versions, 0x0304):
+		return false, nil, errors.New("server did not negotiate TLS 1.3")
+	case hello.ciphers[0] != echCipherSuite:
+		return false, nil, fmt.Errorf("server chose unexpected cipher suite 0x%04x", hello.ciphers[0])
+	}
+	if p.inner != nil && echAccepted(p.inner, serverHello) {
+		return true, nil, nil
+	}
+	shared, err := x25519(p.private, hello.keyShare)
+	if err != nil {
+		return false, nil, err
+	}
+	aead, iv, err := tls13ServerHandshakeKeys(shared, p.outer, serverHello)
+	if err != nil {
+		return false, nil, err
+	}
+	typ, content, err := decryptTLS13Record(aead, iv, record)
+	if err != nil {
+		return false, nil, fmt.Errorf("could not decrypt EncryptedExtensions: %v", err)
+	}
+	msgs := handshakeMessages(append([]byte{typ, 3, 3}, TLSVector(2, content)...))
+	if len(msgs) == 0 || msgs[0][0] != tlsEncryptedExtensions {
+		return false, nil, errors.New("no EncryptedExtensions")
+	}
+	r := &helloReader{data: msgs[0][4:], ok: true}
+	retry := extensionBody(r.vector(2), tlsExtensionECH)
+	if retry == nil {
+		return false, nil, nil
+	}
+	configs, err := parseECHConfigList(retry)
+	return false, configs, err
+}
+
+// run offers the first usable config in the ECHConfigList, or GREASE ECH if
+// there is none.
+func (e *echProber) run(list []byte) *TLSECH {
+	ret := new(TLSECH)
+	if list != nil {
+		var err error
+		if ret.Configs, err = parseECHConfigList(list); err != nil {
+			ret.Error = err.Error()
+			return ret
+		}
+	}
+	config, suite := usableECHConfig(ret.Configs)
+	ret.Grease = config == nil
+	p, err := newECHProbe(config, suite, e.serverName)
+	if err != nil {
+		ret.Error = err.Error()
+		return ret
+	}
+	ret.Accepted, ret.RetryConfigs, err = e.offer(p)
+	if err != nil {
+		ret.Error = err.Error()
+	}
+	return ret
+}
+
+// httpsRecordName returns the name of the HTTPS record for the service on
+// the host and port (RFC 9460 section 9.1).
+func httpsRecordName(host, port string) string {
+	if port == "" || port == "443" {
+		return host
+	}
+	return "_" + port + "._https." + host
+}
+
+// probeECH runs --ech against the connection's target, with the ECHConfigs
+// given by --ech-config, or else those in the HTTPS record for the server
+// name.
+func (z *TLSConnection) probeECH() *TLSECH {
+	e := &echProber{redial: z.redial}
+	if z.config != nil {
+		e.serverName = z.config.ServerName
+	}
+	var list []byte
+	var source, lookupError string
+	switch {
+	case z.flags.ECHConfig != "":
+		var err error
+		if list, err = base64.StdEncoding.DecodeString(z.flags.ECHConfig); err != nil {
+			return &TLSECH{Error: fmt.Sprintf("invalid --ech-config: %v", err)}
+		}
+		source = "flag"
+	case e.serverName != "" && net.ParseIP(e.serverName) == nil:
+		var port string
+		if z.raw != nil {
+			_, port, _ = net.SplitHostPort(z.raw.RemoteAddr().String())
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), echLookupTimeout)
+		defer cancel()
+		var err error
+		if list, err = resolver.lookupECHConfigList(ctx, httpsRecordName(e.serverName, port)); err != nil {
+			lookupError = err.Error()
+		} else if list != nil {
+			source = "dns"
+		}
+	}
+	ret := e.run(list)
+	ret.ConfigSource, ret.LookupError = source, lookupError
+	return ret
+}
@@ -0,0 +1,235 @@
+package zgrab2
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"net"
+	"testing"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// testECHConfig returns an ECHConfig with the X25519 public key.
+func testECHConfig(id byte, public []byte) []byte {
+	return append(TLSUint16s(echVersion), TLSVector(2,
+		[]byte{id},
+		TLSUint16s(hpkeKEMX25519),
+		TLSVector(2, public),
+		TLSVector(2, TLSUint16s(hpkeKDFSHA256, hpkeAEADAES128GCM)),
+		[]byte{32},
+		TLSVector(1, []byte("public.example")),
+		TLSVector(2),
+	)...)
+}
+
+// echServer is a TLS 1.3 server that decrypts inner ClientHellos sent to its
+// config, and sends it as the retry config when it does not, if retry is
+// set. It replies with its ServerHello and EncryptedExtensions.
+type echServer struct {
+	config, private []byte
+	retry           bool
+}
+
+func clientExtension(msg []byte, typ uint16) *helloReader {
+	r := &helloReader{data: msg[4:], ok: true}
+	r.bytes(34)
+	r.vector(1)
+	r.vector(2)
+	r.vector(1)
+	return &helloReader{data: extensionBody(r.vector(2), typ), ok: true}
+}
+
+// decrypt returns the inner ClientHello in the outer one, or nil.
+func (s *echServer) decrypt(outer []byte) []byte {
+	if s.private == nil {
+		return nil
+	}
+	ech := clientExtension(outer, tlsExtensionECH)
+	if ech.uint8() != echClientHelloOuter {
+		return nil
+	}
+	ech.uint16()
+	aeadID := ech.uint16()
+	configID := ech.uint8()
+	enc := ech.vector(2).data
+	payload := ech.vector(2).data
+	if !ech.ok || configID != int(s.config[4]) {
+		return nil
+	}
+	aad := append([]byte(nil), outer[4:]...)
+	copy(aad[len(aad)-len(payload):], make([]byte, len(payload)))
+	dh, err := x25519(s.private, enc)
+	if err != nil {
+		return nil
+	}
+	public := (&helloReader{data: s.config[7:], ok: true}).vector(2).data
+	aead, nonce, err := hpkeKeySchedule(aeadID, hpkeSharedSecret(dh, enc, public), append([]byte("tls ech\x00"), s.config...))
+	if err != nil {
+		return nil
+	}
+	encoded, err := aead.Open(nil, nonce, payload, aad)
+	if err != nil {
+		return nil
+	}
+	// Restore the session ID and remove the padding.
+	r := &helloReader{data: encoded[35:], ok: true}
+	r.vector(2)
+	r.vector(1)
+	r.vector(2)
+	sessionID := (&helloReader{data: outer[38:], ok: true}).vector(1).data
+	return tlsHandshake(tlsClientHello, encoded[:34], TLSVector(1, sessionID), encoded[35:len(encoded)-len(r.data)])
+}
+
+func (s *echServer) serve(conn net.Conn) {
+	defer conn.Close()
+	buf := make([]byte, 4096)
+	n, _ := conn.Read(buf)
+	msgs := handshakeMessages(buf[:n])
+	if len(msgs) == 0 {
+		return
+	}
+	clientHello := msgs[0]
+	shares := clientExtension(clientHello, tlsExtensionKeyShare).vector(2)
+	shares.uint16()
+	clientShare := shares.vector(2).data
+	private, public, _ := x25519KeyPair()
+	shared, err := x25519(private, clientShare)
+	if err != nil {
+		return
+	}
+
+	inner := s.decrypt(clientHello)
+	random := make([]byte, 32)
+	if inner == nil {
+		copy(random, "random")
+	}
+	sessionID := (&helloReader{data: clientHello[38:], ok: true}).vector(1).data
+	serverHello := tlsHandshake(tlsServerHello,
+		TLSUint16s(0x0303),
+		random,
+		TLSVector(1, sessionID),
+		TLSUint16s(echCipherSuite),
+		[]byte{0},
+		TLSVector(2,
+			TLSExtension(tlsExtensionSupportedVersions, TLSUint16s(0x0304)),
+			TLSExtension(tlsExtensionKeyShare, TLSUint16s(echGroup), TLSVector(2, public)),
+		),
+	)
+	var extensions [][]byte
+	if inner != nil {
+		secret := hkdf.Extract(sha256.New, inner[6:38], nil)
+		copy(serverHello[30:38], TLS13ExpandLabel(secret, "ech accept confirmation", TLSTranscriptHash(inner, serverHello), 8))
+		clientHello = inner
+	} else if s.retry {
+		extensions = append(extensions, TLSExtension(tlsExtensionECH, TLSVector(2, s.config)))
+	}
+	aead, iv, _ := tls13ServerHandshakeKeys(shared, clientHello, serverHello)
+	plaintext := append(tlsHandshake(tlsEncryptedExtensions, TLSVector(2, extensions...)), tlsRecordHandshake)
+	header := append([]byte{tlsRecordApplicationData, 3, 3}, TLSUint16s(uint16(len(plaintext)+aead.Overhead()))...)
+	conn.Write(bytes.Join([][]byte{
+		tlsRecord(tlsRecordHandshake, serverHello),
+		tlsRecord(tlsRecordChangeCipherSpec, []byte{1}),
+		header,
+		aead.Seal(nil, iv, plaintext, header),
+	}, nil))
+}
+
+func (s *echServer) dial() (net.Conn, error) {
+	client, server := net.Pipe()
+	go s.serve(server)
+	return client, nil
+}
+
+func TestECH(t *testing.T) {
+	private, public, _ := x25519KeyPair()
+	_, stalePublic, _ := x25519KeyPair()
+	config := testECHConfig(7, public)
+	stale := testECHConfig(8, stalePublic)
+	// A config of an older draft, which is skipped.
+	draft := append(TLSUint16s(0xfe0a), TLSVector(2, []byte("draft"))...)
+	for _, test := range []struct {
+		name     string
+		server   *echServer
+		list     []byte
+		accepted bool
+		grease   bool
+		retry    bool
+	}{
+		{
+			name:     "accepted",
+			server:   &echServer{config: config, private: private, retry: true},
+			list:     TLSVector(2, draft, config),
+			accepted: true,
+		},
+		{
+			name:   "stale config",
+			server: &echServer{config: config, private: private, retry: true},
+			list:   TLSVector(2, stale),
+			retry:  true,
+		},
+		{
+			name:   "GREASE",
+			server: &echServer{config: config, private: private, retry: true},
+			grease: true,
+			retry:  true,
+		},
+		{
+			name:   "GREASE without ECH",
+			server: &echServer{},
+			grease: true,
+		},
+	} {
+		e := &echProber{serverName: "secret.example", redial: test.server.dial}
+		result := e.run(test.list)
+		if result.Error != "" {
+			t.Errorf("%s: error %s", test.name, result.Error)
+			continue
+		}
+		if result.Accepted != test.accepted || result.Grease != test.grease {
+			t.Errorf("%s: got accepted %v, grease %v", test.name, result.Accepted, result.Grease)
+		}
+		if !test.retry {
+			if len(result.RetryConfigs) != 0 {
+				t.Errorf("%s: unexpected retry configs", test.name)
+			}
+			continue
+		}
+		if len(result.RetryConfigs) != 1 || result.RetryConfigs[0].ConfigID != 7 || !bytes.Equal(result.RetryConfigs[0].PublicKey, public) || result.RetryConfigs[0].PublicName != "public.example" {
+			t.Errorf("%s: wrong retry configs %+v", test.name, result.RetryConfigs)
+		}
+	}
+}
+
+func TestHTTPSRecordECH(t *testing.T) {
+	list := TLSVector(2, testECHConfig(1, make([]byte, 32)))
+	query, id, err := dnsQuery("example.com", dnsTypeHTTPS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The answers name the question's name with a compression pointer.
+	question := query[12 : len(query)-11]
+	response := bytes.Join([][]byte{
+		TLSUint16s(id, 0x8180, 1, 2, 0, 0),
+		question,
+		[]byte{0xc0, 12}, TLSUint16s(dnsTypeHTTPS, 1, 0, 300),
+		TLSVector(2, TLSUint16s(0), []byte{3}, []byte("cdn"), []byte{0}),
+		[]byte{0xc0, 12}, TLSUint16s(dnsTypeHTTPS, 1, 0, 300),
+		TLSVector(2, TLSUint16s(1), []byte{0}, TLSUint16s(1), TLSVector(2, []byte{2}, []byte("h2")), TLSUint16s(dnsParamECH), TLSVector(2, list)),
+	}, nil)
+	answers, err := dnsAnswers(response, id, dnsTypeHTTPS)
+	if err != nil || len(answers) != 2 {
+		t.Fatalf("got %d answers, error %v", len(answers), err)
+	}
+	if priority, configs := httpsRecordECH(answers[0]); priority != 0 || configs != nil {
+		t.Errorf("AliasMode record parsed as %d %x", priority, configs)
+	}
+	if priority, configs := httpsRecordECH(answers[1]); priority != 1 || !bytes.Equal(configs, list) {
+		t.Errorf("ServiceMode record parsed as %d %x", priority, configs)
+	}
+	if _, err := dnsAnswers(response, id+1, dnsTypeHTTPS); err == nil {
+		t.Error("response to another query accepted")
+	}
+	if name := httpsRecordName("example.com", "8443"); name != "_8443._https.example.com" {
+		t.Errorf("wrong record name %s", name)
+	}
+}
@@ -0,0 +1,472 @@
+package zgrab2
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"net"
+	"sort"
+
+	"github.com/zmap/zcrypto/tls"
+)
+
+// TLSEnumeration is what the server accepts, found by offering each protocol
+// version, cipher suite, group and signature algorithm in turn, with
+// --tls-enumerate.
+type TLSEnumeration struct {
+	// Versions are the protocol versions the server accepts, oldest first.
+	Versions []*TLSVersionSupport `json:"versions,omitempty"`
+
+	// Groups are the key exchange groups the server accepts, in the order
+	// it chose them (its preference, if it has one), from TLS 1.3
+	// HelloRetryRequests or, without TLS 1.3, TLS 1.2 ECDHE key exchanges.
+	Groups []string `json:"groups,omitempty"`
+
+	// SignatureAlgorithms are the algorithms the server accepts for signing
+	// TLS 1.2 ECDHE and DHE key exchanges, in the order it chose them.
+	SignatureAlgorithms []string `json:"signature_algorithms,omitempty"`
+
+	// Error is the reason the enumeration stopped early, if it did.
+	Error string `json:"error,omitempty"`
+}
+
+// TLSVersionSupport lists the cipher suites accepted with a protocol
+// version.
+type TLSVersionSupport struct {
+	// Version names the version, e.g. "TLSv1.2".
+	Version string `json:"version"`
+
+	// CipherSuites are the suites accepted with it, in the order the server
+	// chose them (its preference, if it has one).
+	CipherSuites []tls.CipherSuite `json:"cipher_suites"`
+}
+
+// Extensions sent in enumeration ClientHellos.
+const (
+	tlsExtensionSupportedGroups = 0x000a
+	tlsExtensionKeyShare        = 0x0033
+)
+
+// tlsServerKeyExchange and tlsServerHelloDone are the TLS 1.2 handshake
+// messages enumeration reads up to.
+const (
+	tlsServerKeyExchange = 12
+	tlsServerHelloDone   = 14
+)
+
+// tlsEnumerationVersions are the versions enumerated. SSL 2.0's ClientHello
+// has a different format, and is not tried.
+var tlsEnumerationVersions = []uint16{0x0300, 0x0301, 0x0302, 0x0303, 0x0304}
+
+var tlsVersionNames = map[uint16]string{
+	0x0300: "SSLv3",
+	0x0301: "TLSv1.0",
+	0x0302: "TLSv1.1",
+	0x0303: "TLSv1.2",
+	0x0304: "TLSv1.3",
+}
+
+// tlsECDHECiphers are the cipher suites before TLS 1.3 with signed ECDHE key
+// exchanges, from which the group and signature algorithm are read.
+var tlsECDHECiphers = []uint16{
+	0xc02b, 0xc02c, 0xc02f, 0xc030, 0xcca8, 0xcca9, 0xc0ac, 0xc0ad, 0xc0ae, 0xc0af,
+	0xc009, 0xc00a, 0xc013, 0xc014, 0xc023, 0xc024, 0xc027, 0xc028, 0xc072, 0xc073,
+	0xc076, 0xc077, 0xc05c, 0xc05d, 0xc060, 0xc061, 0xc007, 0xc011, 0xc008, 0xc012,
+	0xc006, 0xc010,
+}
+
+// tlsDHECiphers are the cipher suites with signed finite field DHE key
+// exchanges.
+var tlsDHECiphers = []uint16{
+	0x009e, 0x009f, 0xccaa, 0x00a2, 0x00a3, 0x0033, 0x0039, 0x0067, 0x006b, 0x0032,
+	0x0038, 0x0040, 0x006a, 0x0045, 0x0088, 0x00be, 0x00c4, 0x0044, 0x0087, 0x0016,
+	0x0013, 0x0015, 0x0012, 0x0014, 0x0011,
+}
+
+// tlsOtherCiphers are the remaining cipher suites before TLS 1.3: static
+// RSA, anonymous, NULL and export suites.
+var tlsOtherCiphers = []uint16{
+	0x009c, 0x009d, 0xc09c, 0xc09d, 0xc0a0, 0xc0a1, 0x002f, 0x0035, 0x003c, 0x003d,
+	0x0041, 0x0084, 0x00ba, 0x00c0, 0x0096, 0x000a, 0x0005, 0x0004, 0x0009, 0x0003,
+	0x0006, 0x0008, 0x0001, 0x0002, 0x003b, 0x0018, 0x001b, 0x0034, 0x003a, 0x006c,
+	0x006d, 0x00a6, 0x00a7, 0xc018, 0xc019, 0xc017, 0xc016, 0xc015,
+}
+
+// tls13Ciphers are the TLS 1.3 cipher suites.
+var tls13Ciphers = []uint16{0x1301, 0x1302, 0x1303, 0x1304, 0x1305}
+
+var tlsGroupNames = map[uint16]string{
+	0x0015: "secp224r1",
+	0x0016: "secp256k1",
+	0x0017: "secp256r1",
+	0x0018: "secp384r1",
+	0x0019: "secp521r1",
+	0x001a: "brainpoolP256r1",
+	0x001b: "brainpoolP384r1",
+	0x001c: "brainpoolP512r1",
+	0x001d: "x25519",
+	0x001e: "x448",
+	0x0100: "ffdhe2048",
+	0x0101: "ffdhe3072",
+	0x0102: "ffdhe4096",
+	0x0103: "ffdhe6144",
+	0x0104: "ffdhe8192",
+	0x0200: "MLKEM512",
+	0x0201: "MLKEM768",
+	0x0202: "MLKEM1024",
+	0x11eb: "SecP256r1MLKEM768",
+	0x11ec: "X25519MLKEM768",
+	0x11ed: "SecP384r1MLKEM1024",
+	0x6399: "X25519Kyber768Draft00",
+}
+
+var tlsSignatureAlgorithmNames = map[uint16]string{
+	0x0201: "rsa_pkcs1_sha1",
+	0x0203: "ecdsa_sha1",
+	0x0401: "rsa_pkcs1_sha256",
+	0x0403: "ecdsa_secp256r1_sha256",
+	0x0501: "rsa_pkcs1_sha384",
+	0x0503: "ecdsa_secp384r1_sha384",
+	0x0601: "rsa_pkcs1_sha512",
+	0x0603: "ecdsa_secp521r1_sha512",
+	0x0804: "rsa_pss_rsae_sha256",
+	0x0805: "rsa_pss_rsae_sha384",
+	0x0806: "rsa_pss_rsae_sha512",
+	0x0807: "ed25519",
+	0x0808: "ed448",
+	0x0809: "rsa_pss_pss_sha256",
+	0x080a: "rsa_pss_pss_sha384",
+	0x080b: "rsa_pss_pss_sha512",
+}
+
+// tlsValueNames returns the names of the values, or their hex values if
+// they have none.
+func tlsValueNames(values []uint16, names map[uint16]string) []string {
+	var ret []string
+	for _, v := range values {
+		if name, ok := names[v]; ok {
+			ret = append(ret, name)
+		} else {
+			ret = append(ret, fmt.Sprintf("0x%04x", v))
+		}
+	}
+	return ret
+}
+
+// sortedKeys returns the keys of the map, in order.
+func sortedKeys(m map[uint16]string) []uint16 {
+	var ret []uint16
+	for k := range m {
+		ret = append(ret, k)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i] < ret[j] })
+	return ret
+}
+
+// tlsProbe is a ClientHello sent in enumeration.
+type tlsProbe struct {
+	// version is the highest version offered; TLS 1.3 is offered alone.
+	version uint16
+	ciphers []uint16
+	groups  []uint16
+	sigAlgs []uint16
+
+	// keyShares are the encoded KeyShareEntries of a TLS 1.3 ClientHello.
+	keyShares []byte
+
+	// keyExchange is set to read the TLS 1.2 ServerKeyExchange, as well as
+	// the ServerHello.
+	keyExchange bool
+
+	// renegotiationInfo is the renegotiated connection's client
+	// verify_data, sent in the renegotiation_info extension; it is empty in
+	// an initial handshake.
+	renegotiationInfo []byte
+}
+
+// tlsProbeResult is what the server chose in answer to a tlsProbe.
+type tlsProbeResult struct {
+	version uint16
+	cipher  uint16
+	// group and sigAlg are 0 if they could not be read.
+	group  uint16
+	sigAlg uint16
+
+	// helloRetry is set if the server sent a HelloRetryRequest, and
+	// keyShareLength is the length of its key share if it did not.
+	helloRetry     bool
+	keyShareLength int
+}
+
+// clientHello returns the probe's ClientHello, naming the server if
+// serverName is set. SSLv3 ClientHellos have no extensions, and only TLS 1.2
+// and above offer signature algorithms. Unless the probe has key shares, a
+// TLS 1.3 ClientHello sends none, so that the server replies with a
+// HelloRetryRequest naming its group, which needs no key exchange to read.
+func (p *tlsProbe) clientHello(serverName string) []byte {
+	random, sessionID := make([]byte, 32), make([]byte, 32)
+	rand.Read(random)
+	rand.Read(sessionID)
+	version := p.version
+	if version > 0x0303 {
+		version = 0x0303
+	}
+	var extensions [][]byte
+	if p.version > 0x0300 {
+		if serverName != "" {
+			extensions = append(extensions, TLSExtension(tlsExtensionServerName, TLSVector(2, []byte{0}, TLSVector(2, []byte(serverName)))))
+		}
+		extensions = append(extensions,
+			TLSExtension(tlsExtensionSupportedGroups, TLSVector(2, TLSUint16s(p.groups...))),
+			// ec_point_formats: uncompressed.
+			TLSExtension(0x000b, []byte{1, 0}),
+			TLSExtension(tlsExtensionRenegotiationInfo, TLSVector(1, p.renegotiationInfo)),
+		)
+	}
+	if p.version >= 0x0303 {
+		extensions = append(extensions, TLSExtension(tlsExtensionSignatureAlgorithms, TLSVector(2, TLSUint16s(p.sigAlgs...))))
+	}
+	if p.version >= 0x0304 {
+		extensions = append(extensions,
+			TLSExtension(tlsExtensionSupportedVersions, TLSVector(1, TLSUint16s(p.version))),
+			TLSExtension(tlsExtensionKeyShare, TLSVector(2, p.keyShares)),
+		)
+	}
+	body := [][]byte{
+		TLSUint16s(version),
+		random,
+		TLSVector(1, sessionID),
+		TLSVector(2, TLSUint16s(p.ciphers...)),
+		TLSVector(1, []byte{0}),
+	}
+	if len(extensions) > 0 {
+		body = append(body, TLSVector(2, extensions...))
+	}
+	return append([]byte{tlsClientHello}, TLSVector(3, bytes.Join(body, nil))...)
+}
+
+// parseServerKeyExchange returns the group and signature algorithm of a
+// TLS 1.2 ServerKeyExchange for the cipher suite, or 0 for those it does not
+// have.
+func parseServerKeyExchange(msg []byte, cipher uint16) (group, sigAlg uint16) {
+	r := &helloReader{data: msg[4:], ok: true}
+	switch {
+	case containsUint16(tlsECDHECiphers, cipher):
+		// Only named curves are supported.
+		if r.uint8() != 3 {
+			return 0, 0
+		}
+		group = r.uint16()
+		r.vector(1)
+	case containsUint16(tlsDHECiphers, cipher):
+		r.vector(2)
+		r.vector(2)
+		r.vector(2)
+	default:
+		return 0, 0
+	}
+	sigAlg = r.uint16()
+	if !r.ok {
+		return 0, 0
+	}
+	return group, sigAlg
+}
+
+func containsUint16(values []uint16, v uint16) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
+
+// tlsEnumerator sends probes to a target.
+type tlsEnumerator struct {
+	serverName string
+	redial     func() (net.Conn, error)
+}
+
+// probe connects to the target and sends the probe's ClientHello, returning
+// what the server chose, or nil if it refused the handshake. The error is
+// only set if the target could not be reached.
+func (e *tlsEnumerator) probe(p *tlsProbe) (*tlsProbeResult, error) {
+	conn, err := e.redial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	recordVersion := byte(1)
+	if p.version == 0x0300 {
+		recordVersion = 0
+	}
+	record := append([]byte{tlsRecordHandshake, 3, recordVersion}, TLSVector(2, p.clientHello(e.serverName))...)
+	if _, err := conn.Write(record); err != nil {
+		return nil, err
+	}
+	// Servers refuse a handshake with an alert, or by closing the
+	// connection.
+	msgs, err := readHandshake(conn, func(msgs [][]byte) bool {
+		if firstHello(msgs, tlsServerHello) == nil {
+			return false
+		}
+		if !p.keyExchange {
+			return true
+		}
+		for _, msg := range msgs {
+			if msg[0] == tlsServerKeyExchange || msg[0] == tlsServerHelloDone {
+				return true
+			}
+		}
+		return false
+	})
+	if err != nil {
+		return nil, nil
+	}
+	msg := firstHello(msgs, tlsServerHello)
+	hello := parseHello(msg)
+	if hello == nil || len(hello.ciphers) == 0 {
+		return nil, nil
+	}
+	ret := &tlsProbeResult{version: hello.version, cipher: hello.ciphers[0], helloRetry: isHelloRetryRequest(msg), keyShareLength: len(hello.keyShare)}
+	if len(hello.versions) > 0 {
+		ret.version = hello.versions[0]
+	}
+	if len(hello.groups) > 0 {
+		ret.group = hello.groups[0]
+	}
+	for _, msg := range msgs {
+		if msg[0] == tlsServerKeyExchange {
+			ret.group, ret.sigAlg = parseServerKeyExchange(msg, ret.cipher)
+		}
+	}
+	return ret, nil
+}
+
+// accepted offers the values with probes returned by newProbe, removing each
+// the server chooses (as returned by chosen), until it refuses the rest or
+// chooses one not offered. It returns the values chosen, in order.
+func (e *tlsEnumerator) accepted(values []uint16, newProbe func(offered []uint16) *tlsProbe, chosen func(*tlsProbeResult) uint16) ([]uint16, error) {
+	var ret []uint16
+	offered := append([]uint16(nil), values...)
+	for len(offered) > 0 {
+		result, err := e.probe(newProbe(offered))
+		if err != nil || result == nil {
+			return ret, err
+		}
+		value := chosen(result)
+		var remaining []uint16
+		for _, v := range offered {
+			if v != value {
+				remaining = append(remaining, v)
+			}
+		}
+		if len(remaining) == len(offered) {
+			break
+		}
+		ret = append(ret, value)
+		offered = remaining
+	}
+	return ret, nil
+}
+
+// enumerate finds the versions and cipher suites the server accepts, then
+// its groups and signature algorithms.
+func (e *tlsEnumerator) enumerate() *TLSEnumeration {
+	ret := new(TLSEnumeration)
+	allGroups := sortedKeys(tlsGroupNames)
+	allSigAlgs := sortedKeys(tlsSignatureAlgorithmNames)
+	allCiphers := append(append(append([]uint16(nil), tlsECDHECiphers...), tlsDHECiphers...), tlsOtherCiphers...)
+	var tls12Signed []uint16
+	tls13 := false
+	for _, version := range tlsEnumerationVersions {
+		ciphers := allCiphers
+		if version == 0x0304 {
+			ciphers = tls13Ciphers
+		}
+		accepted, err := e.accepted(ciphers, func(offered []uint16) *tlsProbe {
+			return &tlsProbe{version: version, ciphers: offered, groups: allGroups, sigAlgs: allSigAlgs}
+		}, func(result *tlsProbeResult) uint16 {
+			if result.version != version {
+				return 0
+			}
+			return result.cipher
+		})
+		if err != nil {
+			ret.Error = err.Error()
+			return ret
+		}
+		if len(accepted) == 0 {
+			continue
+		}
+		support := &TLSVersionSupport{Version: tlsVersionNames[version]}
+		for _, cipher := range accepted {
+			support.CipherSuites = append(support.CipherSuites, tls.CipherSuite(cipher))
+		}
+		ret.Versions = append(ret.Versions, support)
+		switch version {
+		case 0x0303:
+			for _, cipher := range accepted {
+				if containsUint16(tlsECDHECiphers, cipher) || containsUint16(tlsDHECiphers, cipher) {
+					tls12Signed = append(tls12Signed, cipher)
+				}
+			}
+		case 0x0304:
+			tls13 = true
+		}
+	}
+
+	var groups []uint16
+	var err error
+	if tls13 {
+		groups, err = e.accepted(allGroups, func(offered []uint16) *tlsProbe {
+			return &tlsProbe{version: 0x0304, ciphers: tls13Ciphers, groups: offered, sigAlgs: allSigAlgs}
+		}, func(result *tlsProbeResult) uint16 {
+			return result.group
+		})
+	} else if ecdhe := intersectUint16s(tls12Signed, tlsECDHECiphers); len(ecdhe) > 0 {
+		groups, err = e.accepted(allGroups, func(offered []uint16) *tlsProbe {
+			return &tlsProbe{version: 0x0303, ciphers: ecdhe, groups: offered, sigAlgs: allSigAlgs, keyExchange: true}
+		}, func(result *tlsProbeResult) uint16 {
+			return result.group
+		})
+	}
+	ret.Groups = tlsValueNames(groups, tlsGroupNames)
+	if err != nil {
+		ret.Error = err.Error()
+		return ret
+	}
+
+	if len(tls12Signed) > 0 {
+		sigAlgs, err := e.accepted(allSigAlgs, func(offered []uint16) *tlsProbe {
+			return &tlsProbe{version: 0x0303, ciphers: tls12Signed, groups: allGroups, sigAlgs: offered, keyExchange: true}
+		}, func(result *tlsProbeResult) uint16 {
+			return result.sigAlg
+		})
+		ret.SignatureAlgorithms = tlsValueNames(sigAlgs, tlsSignatureAlgorithmNames)
+		if err != nil {
+			ret.Error = err.Error()
+		}
+	}
+	return ret
+}
+
+// intersectUint16s returns the values in a that are also in b.
+func intersectUint16s(a, b []uint16) []uint16 {
+	var ret []uint16
+	for _, v := range a {
+		if containsUint16(b, v) {
+			ret = append(ret, v)
+		}
+	}
+	return ret
+}
+
+// enumerate runs --tls-enumerate against the connection's target.
+func (z *TLSConnection) enumerate() *TLSEnumeration {
+	e := &tlsEnumerator{redial: z.redial}
+	if z.config != nil {
+		e.serverName = z.config.ServerName
+	}
+	return e.enumerate()
+}
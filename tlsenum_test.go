@@ -0,0 +1,181 @@
+package zgrab2
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/zmap/zcrypto/tls"
+)
+
+// enumerationServer answers ClientHellos with the first of its versions,
+// cipher suites, groups and signature algorithms that the client offers, in
+// its order of preference.
+type enumerationServer struct {
+	versions []uint16
+	ciphers  []uint16
+	groups   []uint16
+	sigAlgs  []uint16
+}
+
+func firstOffered(preferred, offered []uint16) uint16 {
+	for _, v := range preferred {
+		if containsUint16(offered, v) {
+			return v
+		}
+	}
+	return 0
+}
+
+func (s *enumerationServer) serve(conn net.Conn) {
+	defer conn.Close()
+	buf := make([]byte, 4096)
+	n, _ := conn.Read(buf)
+	msgs := handshakeMessages(buf[:n])
+	if len(msgs) == 0 {
+		return
+	}
+	hello := parseHello(msgs[0])
+	alert := tlsRecord(tlsRecordAlert, []byte{2, 40})
+	maxVersion := hello.version
+	if len(hello.versions) > 0 {
+		maxVersion = hello.versions[0]
+	}
+	var version uint16
+	for _, v := range s.versions {
+		if v <= maxVersion {
+			version = v
+		}
+	}
+	cipher := firstOffered(s.ciphers, hello.ciphers)
+	if version == 0 || cipher == 0 || (version == 0x0304) != (cipher>>8 == 0x13) {
+		conn.Write(alert)
+		return
+	}
+	group := firstOffered(s.groups, hello.groups)
+	if version == 0x0304 {
+		if group == 0 {
+			conn.Write(alert)
+			return
+		}
+		conn.Write(tlsRecord(tlsRecordHandshake, tlsHandshake(tlsServerHello,
+			TLSUint16s(0x0303),
+			helloRetryRequestRandom[:],
+			TLSVector(1, hello.sessionID),
+			TLSUint16s(cipher),
+			[]byte{0},
+			TLSVector(2,
+				TLSExtension(tlsExtensionSupportedVersions, TLSUint16s(0x0304)),
+				TLSExtension(tlsExtensionKeyShare, TLSUint16s(group)),
+			),
+		)))
+		return
+	}
+	msgs = [][]byte{tlsHandshake(tlsServerHello,
+		TLSUint16s(version),
+		make([]byte, 32),
+		TLSVector(1),
+		TLSUint16s(cipher),
+		[]byte{0},
+	)}
+	if containsUint16(tlsECDHECiphers, cipher) {
+		// Before TLS 1.2, the signature algorithm is implied.
+		var sigAlg []byte
+		if version >= 0x0303 {
+			if v := firstOffered(s.sigAlgs, hello.sigAlgs); v != 0 {
+				sigAlg = TLSUint16s(v)
+			}
+		}
+		if group == 0 || (version >= 0x0303 && sigAlg == nil) {
+			conn.Write(alert)
+			return
+		}
+		msgs = append(msgs, tlsHandshake(tlsServerKeyExchange,
+			[]byte{3}, TLSUint16s(group), TLSVector(1, []byte{4, 1, 2}),
+			sigAlg, TLSVector(2, []byte("signature")),
+		))
+	}
+	msgs = append(msgs, tlsHandshake(tlsServerHelloDone))
+	var data []byte
+	for _, msg := range msgs {
+		data = append(data, msg...)
+	}
+	conn.Write(tlsRecord(tlsRecordHandshake, data))
+}
+
+func (s *enumerationServer) dial() (net.Conn, error) {
+	client, server := net.Pipe()
+	go s.serve(server)
+	return client, nil
+}
+
+func TestTLSEnumeration(t *testing.T) {
+	for _, test := range []struct {
+		server   *enumerationServer
+		expected *TLSEnumeration
+	}{
+		{
+			server: &enumerationServer{
+				versions: []uint16{0x0301, 0x0303, 0x0304},
+				ciphers:  []uint16{0x1302, 0xc030, 0x1301, 0xc02f, 0x002f},
+				groups:   []uint16{0x0017, 0x001d},
+				sigAlgs:  []uint16{0x0804, 0x0401},
+			},
+			expected: &TLSEnumeration{
+				Versions: []*TLSVersionSupport{
+					{Version: "TLSv1.0", CipherSuites: []tls.CipherSuite{0xc030, 0xc02f, 0x002f}},
+					{Version: "TLSv1.2", CipherSuites: []tls.CipherSuite{0xc030, 0xc02f, 0x002f}},
+					{Version: "TLSv1.3", CipherSuites: []tls.CipherSuite{0x1302, 0x1301}},
+				},
+				Groups:              []string{"secp256r1", "x25519"},
+				SignatureAlgorithms: []string{"rsa_pss_rsae_sha256", "rsa_pkcs1_sha256"},
+			},
+		},
+		{
+			// Without TLS 1.3, the groups come from ECDHE key exchanges.
+			server: &enumerationServer{
+				versions: []uint16{0x0303},
+				ciphers:  []uint16{0xc02b, 0x009c},
+				groups:   []uint16{0x001d, 0x0018},
+				sigAlgs:  []uint16{0x0403},
+			},
+			expected: &TLSEnumeration{
+				Versions: []*TLSVersionSupport{
+					{Version: "TLSv1.2", CipherSuites: []tls.CipherSuite{0xc02b, 0x009c}},
+				},
+				Groups:              []string{"x25519", "secp384r1"},
+				SignatureAlgorithms: []string{"ecdsa_secp256r1_sha256"},
+			},
+		},
+		{
+			// A server not speaking TLS refuses everything.
+			server:   &enumerationServer{},
+			expected: &TLSEnumeration{},
+		},
+	} {
+		e := &tlsEnumerator{serverName: "example.com", redial: test.server.dial}
+		result := e.enumerate()
+		if !reflect.DeepEqual(result, test.expected) {
+			t.Errorf("wrong enumeration %+v, expected %+v", result, test.expected)
+			for i, v := range result.Versions {
+				t.Logf("version %d: %+v", i, v)
+			}
+		}
+	}
+}
+
+func TestParseServerKeyExchange(t *testing.T) {
+	dhe := tlsHandshake(tlsServerKeyExchange,
+		TLSVector(2, make([]byte, 0x300)), TLSVector(2, []byte{2}), TLSVector(2, make([]byte, 0x300)),
+		TLSUint16s(0x0601), TLSVector(2, []byte("signature")),
+	)
+	if group, sigAlg := parseServerKeyExchange(dhe, 0x009e); group != 0 || sigAlg != 0x0601 {
+		t.Errorf("wrong DHE key exchange %04x %04x", group, sigAlg)
+	}
+	if group, sigAlg := parseServerKeyExchange(dhe[:20], 0x009e); group != 0 || sigAlg != 0 {
+		t.Errorf("truncated key exchange parsed as %04x %04x", group, sigAlg)
+	}
+	if group, sigAlg := parseServerKeyExchange(dhe, 0x002f); group != 0 || sigAlg != 0 {
+		t.Errorf("RSA key exchange parsed as %04x %04x", group, sigAlg)
+	}
+}
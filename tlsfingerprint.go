@@ -0,0 +1,446 @@
+package zgrab2
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// TLSFingerprints are fingerprints of a TLS handshake, for joining results
+// against threat intelligence feeds without re-parsing raw handshakes.
+type TLSFingerprints struct {
+	// JA3S is the MD5 hash of JA3SString, the server's JA3S fingerprint:
+	// its ServerHello's version, cipher suite and extensions.
+	JA3S       string `json:"ja3s,omitempty"`
+	JA3SString string `json:"ja3s_string,omitempty"`
+
+	// JA4S is the server's JA4S fingerprint.
+	JA4S string `json:"ja4s,omitempty"`
+
+	// JA4 is the JA4 fingerprint of the ClientHello that was sent, which
+	// servers and middleboxes fingerprinting clients saw.
+	JA4 string `json:"ja4,omitempty"`
+}
+
+// helloRecordLimit is the most bytes recorded from the start of each
+// direction of a TLS connection, enough to hold any hello message.
+const helloRecordLimit = 32 * 1024
+
+// helloRecorder is a net.Conn that records the start of the data sent and
+// received, until stopped, so that the hello messages can be fingerprinted.
+type helloRecorder struct {
+	net.Conn
+
+	mutex    sync.Mutex
+	stopped  bool
+	sent     []byte
+	received []byte
+}
+
+func (c *helloRecorder) record(buf *[]byte, b []byte) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.stopped {
+		return
+	}
+	if room := helloRecordLimit - len(*buf); room < len(b) {
+		b = b[:room]
+	}
+	*buf = append(*buf, b...)
+}
+
+func (c *helloRecorder) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.record(&c.received, b[:n])
+	return n, err
+}
+
+func (c *helloRecorder) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.record(&c.sent, b[:n])
+	return n, err
+}
+
+// stop stops recording, and returns the plaintext handshake messages sent
+// and received.
+func (c *helloRecorder) stop() (sent, received [][]byte) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	sent, received = handshakeMessages(c.sent), handshakeMessages(c.received)
+	c.stopped, c.sent, c.received = true, nil, nil
+	return sent, received
+}
+
+// handshakeFingerprints returns the fingerprints of the hellos in the
+// handshake messages sent and received, or nil if there was no ServerHello.
+func handshakeFingerprints(sent, received [][]byte) *TLSFingerprints {
+	server := parseHello(firstHello(received, tlsServerHello))
+	if server == nil {
+		return nil
+	}
+	ret := &TLSFingerprints{JA4S: server.ja4s()}
+	ret.JA3SString = server.ja3s()
+	sum := md5.Sum([]byte(ret.JA3SString))
+	ret.JA3S = hex.EncodeToString(sum[:])
+	if client := parseHello(firstHello(sent, tlsClientHello)); client != nil {
+		ret.JA4 = client.ja4()
+	}
+	return ret
+}
+
+// TLS record and handshake message types, and extensions, used in
+// fingerprints.
+const (
+	tlsRecordChangeCipherSpec = 20
+	tlsRecordHandshake        = 22
+
+	tlsClientHello = 1
+	tlsServerHello = 2
+
+	tlsExtensionServerName          = 0x0000
+	tlsExtensionSignatureAlgorithms = 0x000d
+	tlsExtensionALPN                = 0x0010
+	tlsExtensionSupportedVersions   = 0x002b
+)
+
+// helloRetryRequestRandom is the random of a ServerHello that is a TLS 1.3
+// HelloRetryRequest.
+var helloRetryRequestRandom = sha256.Sum256([]byte("HelloRetryRequest"))
+
+func isHelloRetryRequest(msg []byte) bool {
+	return msg[0] == tlsServerHello && len(msg) >= 38 && bytes.Equal(msg[6:38], helloRetryRequestRandom[:])
+}
+
+// handshakeMessages returns the handshake messages in the plaintext records
+// at the start of data, up to the ChangeCipherSpec after which they are
+// encrypted.
+func handshakeMessages(data []byte) [][]byte {
+	var stream []byte
+	var msgs [][]byte
+	for len(data) >= 5 {
+		length := int(binary.BigEndian.Uint16(data[3:5]))
+		if len(data) < 5+length {
+			break
+		}
+		switch data[0] {
+		case tlsRecordHandshake:
+			stream = append(stream, data[5:5+length]...)
+		case tlsRecordChangeCipherSpec:
+			// Servers send a ChangeCipherSpec after a HelloRetryRequest
+			// for compatibility with middleboxes; the handshake continues
+			// in plaintext.
+			if len(msgs) == 0 || !isHelloRetryRequest(msgs[len(msgs)-1]) {
+				return msgs
+			}
+		default:
+			return msgs
+		}
+		data = data[5+length:]
+		for len(stream) >= 4 {
+			n := int(stream[1])<<16 | int(stream[2])<<8 | int(stream[3])
+			if len(stream) < 4+n {
+				break
+			}
+			msgs = append(msgs, stream[:4+n])
+			stream = stream[4+n:]
+		}
+	}
+	return msgs
+}
+
+// firstHello returns the first of the handshake messages with the given
+// type, or nil. A ServerHello that is a HelloRetryRequest is only returned if
+// no other ServerHello follows it.
+func firstHello(msgs [][]byte, msgType byte) []byte {
+	var retry []byte
+	for _, msg := range msgs {
+		if msg[0] != msgType {
+			continue
+		}
+		if isHelloRetryRequest(msg) {
+			retry = msg
+			continue
+		}
+		return msg
+	}
+	return retry
+}
+
+// tlsHello holds the parts of a ClientHello or ServerHello that are
+// inspected: for fingerprints, session resumption and enumeration.
+type tlsHello struct {
+	client    bool
+	version   uint16
+	sessionID []byte
+	// ciphers holds the offered cipher suites, or the one chosen.
+	ciphers    []uint16
+	extensions []uint16
+	// versions holds the supported_versions offered, or the one chosen.
+	versions   []uint16
+	alpn       string
+	sigAlgs    []uint16
+	serverName bool
+	// groups holds the supported groups offered, or the one chosen for the
+	// key share.
+	groups []uint16
+	// keyShare is the server's key share, which a HelloRetryRequest does
+	// not have.
+	keyShare []byte
+}
+
+// helloReader reads the fields of a hello message.
+type helloReader struct {
+	data []byte
+	ok   bool
+}
+
+func (r *helloReader) bytes(n int) []byte {
+	if !r.ok || len(r.data) < n {
+		r.ok = false
+		return nil
+	}
+	ret := r.data[:n]
+	r.data = r.data[n:]
+	return ret
+}
+
+func (r *helloReader) uint8() int {
+	if b := r.bytes(1); b != nil {
+		return int(b[0])
+	}
+	return 0
+}
+
+func (r *helloReader) uint16() uint16 {
+	if b := r.bytes(2); b != nil {
+		return binary.BigEndian.Uint16(b)
+	}
+	return 0
+}
+
+// vector returns a vector with a length prefix of the given size.
+func (r *helloReader) vector(prefix int) *helloReader {
+	n := r.uint8()
+	if prefix == 2 {
+		n = n<<8 | r.uint8()
+	}
+	return &helloReader{data: r.bytes(n), ok: r.ok}
+}
+
+func (r *helloReader) uint16s() []uint16 {
+	var ret []uint16
+	for r.ok && len(r.data) >= 2 {
+		ret = append(ret, r.uint16())
+	}
+	return ret
+}
+
+// parseHello parses a ClientHello or ServerHello message, returning nil if
+// it is malformed.
+func parseHello(msg []byte) *tlsHello {
+	if len(msg) < 4 {
+		return nil
+	}
+	ret := &tlsHello{client: msg[0] == tlsClientHello}
+	r := &helloReader{data: msg[4:], ok: true}
+	ret.version = r.uint16()
+	r.bytes(32)
+	ret.sessionID = r.vector(1).data
+	if ret.client {
+		ret.ciphers = r.vector(2).uint16s()
+		r.vector(1)
+	} else {
+		ret.ciphers = []uint16{r.uint16()}
+		r.uint8()
+	}
+	if !r.ok {
+		return nil
+	}
+	if len(r.data) == 0 {
+		return ret
+	}
+	extensions := r.vector(2)
+	for extensions.ok && len(extensions.data) >= 4 {
+		typ := extensions.uint16()
+		body := extensions.vector(2)
+		ret.extensions = append(ret.extensions, typ)
+		switch typ {
+		case tlsExtensionServerName:
+			ret.serverName = true
+		case tlsExtensionSignatureAlgorithms:
+			ret.sigAlgs = body.vector(2).uint16s()
+		case tlsExtensionALPN:
+			if protocols := body.vector(2); protocols.ok {
+				ret.alpn = string(protocols.vector(1).data)
+			}
+		case tlsExtensionSupportedGroups:
+			if ret.client {
+				ret.groups = body.vector(2).uint16s()
+			}
+		case tlsExtensionKeyShare:
+			// The first field of both a ServerHello's key share and a
+			// HelloRetryRequest's is the group.
+			if !ret.client {
+				ret.groups = []uint16{body.uint16()}
+				ret.keyShare = body.vector(2).data
+			}
+		case tlsExtensionSupportedVersions:
+			if ret.client {
+				ret.versions = body.vector(1).uint16s()
+			} else {
+				ret.versions = []uint16{body.uint16()}
+			}
+		}
+	}
+	if !extensions.ok {
+		return nil
+	}
+	return ret
+}
+
+// isGREASE returns true for the reserved GREASE values (RFC 8701), which are
+// left out of JA4 fingerprints.
+func isGREASE(v uint16) bool {
+	return v&0x0f0f == 0x0a0a && v>>8 == v&0xff
+}
+
+// withoutGREASE returns the values that are not GREASE values.
+func withoutGREASE(values []uint16) []uint16 {
+	ret := make([]uint16, 0, len(values))
+	for _, v := range values {
+		if !isGREASE(v) {
+			ret = append(ret, v)
+		}
+	}
+	return ret
+}
+
+// ja3s returns the JA3S string: the ServerHello's version, cipher suite and
+// extensions, in decimal.
+func (h *tlsHello) ja3s() string {
+	extensions := make([]string, len(h.extensions))
+	for i, ext := range h.extensions {
+		extensions[i] = strconv.Itoa(int(ext))
+	}
+	return fmt.Sprintf("%d,%d,%s", h.version, h.ciphers[0], strings.Join(extensions, "-"))
+}
+
+// ja4Version returns the JA4 code of the highest version offered or chosen.
+func (h *tlsHello) ja4Version() string {
+	version := h.version
+	if versions := withoutGREASE(h.versions); len(versions) > 0 {
+		version = versions[0]
+		for _, v := range versions {
+			if v > version {
+				version = v
+			}
+		}
+	}
+	switch version {
+	case 0x0304:
+		return "13"
+	case 0x0303:
+		return "12"
+	case 0x0302:
+		return "11"
+	case 0x0301:
+		return "10"
+	case 0x0300:
+		return "s3"
+	case 0x0002:
+		return "s2"
+	}
+	return "00"
+}
+
+// ja4ALPN returns the first and last characters of the (first) ALPN
+// protocol, or of its hex encoding if they are not alphanumeric.
+func (h *tlsHello) ja4ALPN() string {
+	if h.alpn == "" {
+		return "00"
+	}
+	first, last := h.alpn[0], h.alpn[len(h.alpn)-1]
+	if !isAlphanumeric(first) || !isAlphanumeric(last) {
+		encoded := hex.EncodeToString([]byte(h.alpn))
+		return encoded[:1] + encoded[len(encoded)-1:]
+	}
+	return string([]byte{first, last})
+}
+
+func isAlphanumeric(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z'
+}
+
+// ja4Count returns a count as two digits, capped at 99.
+func ja4Count(n int) string {
+	if n > 99 {
+		n = 99
+	}
+	return fmt.Sprintf("%02d", n)
+}
+
+// ja4Hash returns the first 12 hex digits of the SHA-256 hash of s, or
+// zeroes if s is empty.
+func ja4Hash(s string) string {
+	if s == "" {
+		return "000000000000"
+	}
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+// ja4List returns the values as comma-separated four-digit hex.
+func ja4List(values []uint16) string {
+	hexValues := make([]string, len(values))
+	for i, v := range values {
+		hexValues[i] = fmt.Sprintf("%04x", v)
+	}
+	return strings.Join(hexValues, ",")
+}
+
+// ja4 returns the JA4 fingerprint of a ClientHello.
+func (h *tlsHello) ja4() string {
+	sni := "i"
+	if h.serverName {
+		sni = "d"
+	}
+	ciphers := withoutGREASE(h.ciphers)
+	extensions := withoutGREASE(h.extensions)
+	a := "t" + h.ja4Version() + sni + ja4Count(len(ciphers)) + ja4Count(len(extensions)) + h.ja4ALPN()
+
+	sorted := append([]uint16(nil), ciphers...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	b := ja4Hash(ja4List(sorted))
+
+	// The server name and ALPN extensions are left out of the hash, as they
+	// depend on the server being contacted.
+	sorted = sorted[:0]
+	for _, ext := range extensions {
+		if ext != tlsExtensionServerName && ext != tlsExtensionALPN {
+			sorted = append(sorted, ext)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	c := ""
+	if len(sorted) > 0 {
+		c = ja4List(sorted)
+		if sigAlgs := withoutGREASE(h.sigAlgs); len(sigAlgs) > 0 {
+			c += "_" + ja4List(sigAlgs)
+		}
+	}
+	return a + "_" + b + "_" + ja4Hash(c)
+}
+
+// ja4s returns the JA4S fingerprint of a ServerHello.
+func (h *tlsHello) ja4s() string {
+	a := "t" + h.ja4Version() + ja4Count(len(h.extensions)) + h.ja4ALPN()
+	return a + "_" + fmt.Sprintf("%04x", h.ciphers[0]) + "_" + ja4Hash(ja4List(h.extensions))
+}
@@ -0,0 +1,101 @@
+package zgrab2
+
+import "testing"
+
+func tlsHandshake(typ byte, body ...[]byte) []byte {
+	return append([]byte{typ}, TLSVector(3, body...)...)
+}
+
+func tlsRecord(typ byte, data []byte) []byte {
+	return append([]byte{typ, 3, 3}, TLSVector(2, data)...)
+}
+
+func testClientHello() []byte {
+	return tlsHandshake(tlsClientHello,
+		TLSUint16s(0x0303),
+		make([]byte, 32),
+		TLSVector(1),
+		TLSVector(2, TLSUint16s(0x0a0a, 0x1301, 0xc02f, 0x1302)),
+		TLSVector(1, []byte{0}),
+		TLSVector(2,
+			TLSExtension(0x0a0a),
+			TLSExtension(tlsExtensionServerName, TLSVector(2, []byte{0}, TLSVector(2, []byte("example.com")))),
+			TLSExtension(tlsExtensionALPN, TLSVector(2, TLSVector(1, []byte("h2")), TLSVector(1, []byte("http/1.1")))),
+			TLSExtension(tlsExtensionSignatureAlgorithms, TLSVector(2, TLSUint16s(0x0403, 0x0804, 0x0401))),
+			TLSExtension(tlsExtensionSupportedVersions, TLSVector(1, TLSUint16s(0x3a3a, 0x0304, 0x0303))),
+			TLSExtension(0x000a, TLSVector(2, TLSUint16s(0x001d))),
+		),
+	)
+}
+
+func testServerHello(random []byte) []byte {
+	return tlsHandshake(tlsServerHello,
+		TLSUint16s(0x0303),
+		random,
+		TLSVector(1),
+		TLSUint16s(0x1301),
+		[]byte{0},
+		TLSVector(2,
+			TLSExtension(tlsExtensionSupportedVersions, TLSUint16s(0x0304)),
+			TLSExtension(0x0033, []byte{0, 0x1d, 0, 0}),
+			TLSExtension(tlsExtensionALPN, TLSVector(2, TLSVector(1, []byte("h2")))),
+		),
+	)
+}
+
+func TestTLSFingerprints(t *testing.T) {
+	// The server sends a HelloRetryRequest first, and splits the ServerHello
+	// over two records.
+	serverHello := testServerHello(make([]byte, 32))
+	received := tlsRecord(tlsRecordHandshake, testServerHello(helloRetryRequestRandom[:]))
+	retryOnly := len(received)
+	received = append(received, tlsRecord(tlsRecordChangeCipherSpec, []byte{1})...)
+	received = append(received, tlsRecord(tlsRecordHandshake, serverHello[:20])...)
+	received = append(received, tlsRecord(tlsRecordHandshake, serverHello[20:])...)
+	received = append(received, tlsRecord(23, []byte("encrypted"))...)
+
+	recorder := &helloRecorder{
+		sent:     tlsRecord(tlsRecordHandshake, testClientHello()),
+		received: received,
+	}
+	fingerprints := handshakeFingerprints(recorder.stop())
+	expected := TLSFingerprints{
+		JA3S:       "b5d161dc269619705eba4f0e4a32116c",
+		JA3SString: "771,4865,43-51-16",
+		JA4S:       "t1303h2_1301_19fd10492780",
+		JA4:        "t13d0305h2_40b44b994229_beb9f91c6f80",
+	}
+	if fingerprints == nil || *fingerprints != expected {
+		t.Errorf("wrong fingerprints %+v", fingerprints)
+	}
+	if !recorder.stopped || recorder.received != nil {
+		t.Error("recording not stopped")
+	}
+	recorder.record(&recorder.sent, []byte("more"))
+	if recorder.sent != nil {
+		t.Error("recorded after stopping")
+	}
+
+	// Only a HelloRetryRequest is fingerprinted if the handshake ends there.
+	retry := parseHello(firstHello(handshakeMessages(received[:retryOnly]), tlsServerHello))
+	if retry == nil || retry.ja3s() != expected.JA3SString {
+		t.Errorf("HelloRetryRequest not used")
+	}
+	if handshakeFingerprints(nil, handshakeMessages([]byte("HTTP/1.1 400 Bad Request\r\n"))) != nil {
+		t.Error("fingerprinted a non-TLS response")
+	}
+}
+
+func TestJA4ALPN(t *testing.T) {
+	for alpn, expected := range map[string]string{
+		"":         "00",
+		"h2":       "h2",
+		"http/1.1": "h1",
+		"c":        "cc",
+		"\xab\xcd": "ad",
+	} {
+		if got := (&tlsHello{alpn: alpn}).ja4ALPN(); got != expected {
+			t.Errorf("%q: got %s, expected %s", alpn, got, expected)
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package zgrab2
+
+import (
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// TLSKeyExchange is the key exchange group the server selected from those
+// offered in a TLS 1.3 ClientHello, with --tls-key-exchange. The ClientHello
+// is sent on a separate probe connection, with throwaway key shares: the
+// groups are not offered in the scan's own handshake.
+type TLSKeyExchange struct {
+	// Offered are the groups offered, with a key share for each, in order
+	// of preference.
+	Offered []string `json:"offered"`
+
+	// Group is the group the server selected.
+	Group string `json:"group,omitempty"`
+
+	// PostQuantum is true if the group is a post-quantum (ML-KEM or Kyber)
+	// group or hybrid.
+	PostQuantum bool `json:"post_quantum"`
+
+	// HelloRetryRequest is true if the server asked for a key share for
+	// the group in a HelloRetryRequest, rather than using one offered.
+	HelloRetryRequest bool `json:"hello_retry_request,omitempty"`
+
+	// ServerShareLength is the length of the server's key share, e.g. 1120
+	// bytes (an ML-KEM-768 ciphertext and an X25519 key) for X25519MLKEM768.
+	ServerShareLength int `json:"server_share_length,omitempty"`
+
+	// ServerShareMalformed is true if the server's key share is not the
+	// length the group's specification gives, such as a hybrid reply
+	// missing one of its components.
+	ServerShareMalformed bool `json:"server_share_malformed,omitempty"`
+
+	// Error is the reason the handshake failed, if it did.
+	Error string `json:"error,omitempty"`
+}
+
+// tlsPostQuantumGroups are the groups using ML-KEM or Kyber, alone or in a
+// hybrid with an elliptic curve.
+var tlsPostQuantumGroups = []uint16{0x0200, 0x0201, 0x0202, 0x11eb, 0x11ec, 0x11ed, 0x6399}
+
+// tlsServerShareLengths are the lengths of the servers' key shares for the
+// groups tlsKeyShare can offer: a public key for the curves, and an ML-KEM
+// ciphertext in place of the encapsulation key.
+var tlsServerShareLengths = map[uint16]int{
+	0x0017: 65,
+	0x0018: 97,
+	0x0019: 133,
+	0x001d: 32,
+	0x0200: 768,
+	0x0201: 1088,
+	0x0202: 1568,
+	0x11eb: 65 + 1088,
+	0x11ec: 1088 + 32,
+	0x11ed: 97 + 1568,
+	0x6399: 32 + 1088,
+}
+
+// mlkemQ is the ML-KEM modulus.
+const mlkemQ = 3329
+
+// mlkemEncapsulationKey returns a well-formed ML-KEM encapsulation key (FIPS
+// 203) of rank k: random coefficients below the modulus, which is all
+// servers check, followed by a random seed. No decapsulation key exists for
+// it; the server's key share is only measured, never used.
+func mlkemEncapsulationKey(k int) ([]byte, error) {
+	coefficients := make([]uint16, 256*k)
+	buf := make([]byte, 2)
+	for i := range coefficients {
+		for {
+			if _, err := rand.Read(buf); err != nil {
+				return nil, err
+			}
+			if c := (uint16(buf[0]) | uint16(buf[1])<<8) & 0x0fff; c < mlkemQ {
+				coefficients[i] = c
+				break
+			}
+		}
+	}
+	// Pairs of 12-bit coefficients are packed into 3 bytes.
+	ret := make([]byte, 0, 384*k+32)
+	for i := 0; i < len(coefficients); i += 2 {
+		a, b := coefficients[i], coefficients[i+1]
+		ret = append(ret, byte(a), byte(a>>8|b<<4), byte(b>>4))
+	}
+	seed := make([]byte, 32)
+	if _, err := rand.Read(seed); err != nil {
+		return nil, err
+	}
+	return append(ret, seed...), nil
+}
+
+// ecdhePublicKey returns a new uncompressed public key on the curve.
+func ecdhePublicKey(curve elliptic.Curve) ([]byte, error) {
+	_, x, y, err := elliptic.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return elliptic.Marshal(curve, x, y), nil
+}
+
+// tlsKeyShare returns a key share for the group. Hybrid shares concatenate
+// their components in the order their specifications give.
+func tlsKeyShare(group uint16) ([]byte, error) {
+	x25519 := func() ([]byte, error) {
+		_, public, err := x25519KeyPair()
+		return public, err
+	}
+	concat := func(parts ...func() ([]byte, error)) ([]byte, error) {
+		var ret []byte
+		for _, part := range parts {
+			b, err := part()
+			if err != nil {
+				return nil, err
+			}
+			ret = append(ret, b...)
+		}
+		return ret, nil
+	}
+	curve := func(c elliptic.Curve) func() ([]byte, error) {
+		return func() ([]byte, error) { return ecdhePublicKey(c) }
+	}
+	mlkem := func(k int) func() ([]byte, error) {
+		return func() ([]byte, error) { return mlkemEncapsulationKey(k) }
+	}
+	switch group {
+	case 0x0017:
+		return ecdhePublicKey(elliptic.P256())
+	case 0x0018:
+		return ecdhePublicKey(elliptic.P384())
+	case 0x0019:
+		return ecdhePublicKey(elliptic.P521())
+	case 0x001d:
+		return x25519()
+	case 0x0200:
+		return mlkemEncapsulationKey(2)
+	case 0x0201:
+		return mlkemEncapsulationKey(3)
+	case 0x0202:
+		return mlkemEncapsulationKey(4)
+	case 0x11eb:
+		return concat(curve(elliptic.P256()), mlkem(3))
+	case 0x11ec:
+		return concat(mlkem(3), x25519)
+	case 0x11ed:
+		return concat(curve(elliptic.P384()), mlkem(4))
+	case 0x6399:
+		// Kyber768's public key has the same format as ML-KEM-768's.
+		return concat(x25519, mlkem(3))
+	}
+	return nil, fmt.Errorf("cannot make a key share for group %s", tlsValueNames([]uint16{group}, tlsGroupNames)[0])
+}
+
+// parseTLSGroups parses a comma-separated list of groups, by name (as in
+// tlsGroupNames, ignoring case) or number.
+func parseTLSGroups(s string) ([]uint16, error) {
+	var ret []uint16
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		found := false
+		for group, groupName := range tlsGroupNames {
+			if strings.EqualFold(name, groupName) {
+				ret, found = append(ret, group), true
+				break
+			}
+		}
+		if found {
+			continue
+		}
+		group, err := strconv.ParseUint(name, 0, 16)
+		if err != nil {
+			return nil, fmt.Errorf("unknown group %q", name)
+		}
+		ret = append(ret, uint16(group))
+	}
+	if len(ret) == 0 {
+		return nil, errors.New("no groups given")
+	}
+	return ret, nil
+}
+
+// keyExchange offers the groups, with a key share for each, in a TLS 1.3
+// ClientHello, and reports the one the server selects and the length of its
+// key share. Only the ServerHello is read.
+func (e *tlsEnumerator) keyExchange(groups []uint16) *TLSKeyExchange {
+	ret := &TLSKeyExchange{Offered: tlsValueNames(groups, tlsGroupNames)}
+	var shares []byte
+	for _, group := range groups {
+		share, err := tlsKeyShare(group)
+		if err != nil {
+			ret.Error = err.Error()
+			return ret
+		}
+		shares = append(shares, TLSUint16s(group)...)
+		shares = append(shares, TLSVector(2, share)...)
+	}
+	result, err := e.probe(&tlsProbe{
+		version:   0x0304,
+		ciphers:   tls13Ciphers,
+		groups:    groups,
+		sigAlgs:   sortedKeys(tlsSignatureAlgorithmNames),
+		keyShares: shares,
+	})
+	switch {
+	case err != nil:
+		ret.Error = err.Error()
+	case result == nil:
+		ret.Error = "server refused the handshake"
+	case result.version != 0x0304:
+		ret.Error = "server did not negotiate TLS 1.3"
+	case result.group == 0:
+		ret.Error = "server sent no key share"
+	default:
+		ret.Group = tlsValueNames([]uint16{result.group}, tlsGroupNames)[0]
+		ret.PostQuantum = containsUint16(tlsPostQuantumGroups, result.group)
+		ret.HelloRetryRequest = result.helloRetry
+		ret.ServerShareLength = result.keyShareLength
+		if expected, ok := tlsServerShareLengths[result.group]; ok && !result.helloRetry {
+			ret.ServerShareMalformed = result.keyShareLength != expected
+		}
+	}
+	return ret
+}
+
+// keyExchange runs --tls-key-exchange against the connection's target.
+func (z *TLSConnection) keyExchange() *TLSKeyExchange {
+	e := &tlsEnumerator{redial: z.redial}
+	if z.config != nil {
+		e.serverName = z.config.ServerName
+	}
+	groups, err := parseTLSGroups(z.flags.Groups)
+	if err != nil {
+		return &TLSKeyExchange{Error: err.Error()}
+	}
+	return e.keyExchange(groups)
+}
@@ -0,0 +1,140 @@
+package zgrab2
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+// keyExchangeServer answers TLS 1.3 ClientHellos with the first of its
+// groups that the client offers, and a key share as long as the client's,
+// or as long as the group's server shares are if wellFormed is set.
+type keyExchangeServer struct {
+	groups     []uint16
+	wellFormed bool
+}
+
+func (s *keyExchangeServer) serve(conn net.Conn) {
+	defer conn.Close()
+	buf := make([]byte, 16384)
+	var data []byte
+	var msgs [][]byte
+	for len(msgs) == 0 {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return
+		}
+		data = append(data, buf[:n]...)
+		msgs = handshakeMessages(data)
+	}
+	hello := parseHello(msgs[0])
+	group := firstOffered(s.groups, hello.groups)
+	if group == 0 {
+		conn.Write(tlsRecord(tlsRecordAlert, []byte{2, 40}))
+		return
+	}
+	keyShare := TLSUint16s(group)
+	shares := clientExtension(msgs[0], tlsExtensionKeyShare).vector(2)
+	for shares.ok && len(shares.data) > 0 {
+		if shares.uint16() == group {
+			share := shares.vector(2).data
+			if s.wellFormed {
+				share = make([]byte, tlsServerShareLengths[group])
+			}
+			keyShare = append(keyShare, TLSVector(2, share)...)
+			break
+		}
+		shares.vector(2)
+	}
+	conn.Write(tlsRecord(tlsRecordHandshake, tlsHandshake(tlsServerHello,
+		TLSUint16s(0x0303),
+		make([]byte, 32),
+		TLSVector(1, hello.sessionID),
+		TLSUint16s(0x1301),
+		[]byte{0},
+		TLSVector(2,
+			TLSExtension(tlsExtensionSupportedVersions, TLSUint16s(0x0304)),
+			TLSExtension(tlsExtensionKeyShare, keyShare),
+		),
+	)))
+}
+
+func (s *keyExchangeServer) dial() (net.Conn, error) {
+	client, server := net.Pipe()
+	go s.serve(server)
+	return client, nil
+}
+
+func TestTLSKeyExchange(t *testing.T) {
+	for _, test := range []struct {
+		groups     string
+		server     []uint16
+		wellFormed bool
+		expected   *TLSKeyExchange
+	}{
+		{
+			groups:     "X25519MLKEM768,x25519",
+			server:     []uint16{0x11ec, 0x001d},
+			wellFormed: true,
+			expected:   &TLSKeyExchange{Offered: []string{"X25519MLKEM768", "x25519"}, Group: "X25519MLKEM768", PostQuantum: true, ServerShareLength: 1120},
+		},
+		{
+			// An encapsulation key sent back in place of a ciphertext.
+			groups:   "X25519MLKEM768,x25519",
+			server:   []uint16{0x11ec, 0x001d},
+			expected: &TLSKeyExchange{Offered: []string{"X25519MLKEM768", "x25519"}, Group: "X25519MLKEM768", PostQuantum: true, ServerShareLength: 1216, ServerShareMalformed: true},
+		},
+		{
+			groups:   "x25519mlkem768, 0x1d",
+			server:   []uint16{0x0017, 0x001d},
+			expected: &TLSKeyExchange{Offered: []string{"X25519MLKEM768", "x25519"}, Group: "x25519", ServerShareLength: 32},
+		},
+		{
+			groups:   "SecP256r1MLKEM768,secp384r1,MLKEM1024",
+			server:   []uint16{0x0202},
+			expected: &TLSKeyExchange{Offered: []string{"SecP256r1MLKEM768", "secp384r1", "MLKEM1024"}, Group: "MLKEM1024", PostQuantum: true, ServerShareLength: 1568},
+		},
+		{
+			groups:   "X25519MLKEM768",
+			server:   []uint16{0x001d},
+			expected: &TLSKeyExchange{Offered: []string{"X25519MLKEM768"}, Error: "server refused the handshake"},
+		},
+		{
+			groups:   "ffdhe2048",
+			expected: &TLSKeyExchange{Offered: []string{"ffdhe2048"}, Error: "cannot make a key share for group ffdhe2048"},
+		},
+	} {
+		groups, err := parseTLSGroups(test.groups)
+		if err != nil {
+			t.Errorf("%s: %v", test.groups, err)
+			continue
+		}
+		server := &keyExchangeServer{groups: test.server, wellFormed: test.wellFormed}
+		e := &tlsEnumerator{serverName: "example.com", redial: server.dial}
+		if result := e.keyExchange(groups); !reflect.DeepEqual(result, test.expected) {
+			t.Errorf("%s: got %+v, expected %+v", test.groups, result, test.expected)
+		}
+	}
+	if _, err := parseTLSGroups("x25519,bogus"); err == nil {
+		t.Error("unknown group accepted")
+	}
+}
+
+func TestMLKEMEncapsulationKey(t *testing.T) {
+	for _, k := range []int{2, 3, 4} {
+		key, err := mlkemEncapsulationKey(k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(key) != 384*k+32 {
+			t.Errorf("rank %d key is %d bytes", k, len(key))
+		}
+		for i := 0; i < 384*k; i += 3 {
+			a := uint16(key[i]) | uint16(key[i+1]&0x0f)<<8
+			b := uint16(key[i+1]>>4) | uint16(key[i+2])<<4
+			if a >= mlkemQ || b >= mlkemQ {
+				t.Fatalf("rank %d key has coefficient %d or %d out of range", k, a, b)
+			}
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package zgrab2
+
+import (
+	"os"
+	"sync"
+)
+
+// keyLogWriter appends lines in the NSS key log format (as browsers write to
+// SSLKEYLOGFILE) to a file shared by every connection, one line at a time.
+type keyLogWriter struct {
+	mutex sync.Mutex
+	file  *os.File
+}
+
+func (w *keyLogWriter) Write(line []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.file.Write(line)
+}
+
+var keyLogWriters = struct {
+	sync.Mutex
+	writers map[string]*keyLogWriter
+}{writers: make(map[string]*keyLogWriter)}
+
+// openKeyLogFile returns the writer for the key log file, opening it for
+// appending once. It stays open until zgrab2 exits.
+func openKeyLogFile(path string) (*keyLogWriter, error) {
+	keyLogWriters.Lock()
+	defer keyLogWriters.Unlock()
+	if w, ok := keyLogWriters.writers[path]; ok {
+		return w, nil
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	w := &keyLogWriter{file: file}
+	keyLogWriters.writers[path] = w
+	return w, nil
+}
@@ -0,0 +1,61 @@
+package zgrab2
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestKeyLogFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zgrab2-keylog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "keys.log")
+
+	flags := &TLSFlags{KeyLogFile: path}
+	config, err := flags.GetTLSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := flags.GetTLSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.KeyLogWriter == nil || config.KeyLogWriter != again.KeyLogWriter {
+		t.Fatalf("key log writers %v and %v are not the same", config.KeyLogWriter, again.KeyLogWriter)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 32; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			fmt.Fprintf(config.KeyLogWriter, "CLIENT_RANDOM %064x %096x\n", i, i)
+		}(i)
+	}
+	wg.Wait()
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(contents), "\n"), "\n")
+	if len(lines) != 32 {
+		t.Fatalf("got %d lines, expected 32", len(lines))
+	}
+	for _, line := range lines {
+		if fields := strings.Fields(line); len(fields) != 3 || len(fields[1]) != 64 || len(fields[2]) != 96 {
+			t.Errorf("garbled line %q", line)
+		}
+	}
+
+	flags.KeyLogFile = filepath.Join(dir, "missing", "keys.log")
+	if _, err := flags.GetTLSConfig(); err == nil {
+		t.Error("opening a key log file in a missing directory succeeded")
+	}
+}
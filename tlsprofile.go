@@ -0,0 +1,369 @@
+package zgrab2
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	mathrand "math/rand"
+	"sort"
+	"strings"
+)
+
+// tlsClientProfile is the ClientHello of a popular TLS client, sent in place
+// of zcrypto's own with --tls-client-profile, since many servers and CDNs
+// answer differently depending on the client's fingerprint.
+//
+// zcrypto cannot negotiate TLS 1.3, so the supported_versions extension
+// offers TLS 1.2 and below only, and the JA3 and JA4 fingerprints of a
+// profile differ from the client's; everything else (cipher suite order,
+// extensions and their order, GREASE, groups, signature algorithms and ALPN)
+// follows the client.
+type tlsClientProfile struct {
+	ciphers    []uint16
+	extensions []helloExtension
+
+	// shuffle is true for clients that randomize the order of their
+	// extensions (other than GREASE) in every ClientHello.
+	shuffle bool
+}
+
+// tlsClientProfiles are the profiles accepted by --tls-client-profile.
+var tlsClientProfiles = map[string]*tlsClientProfile{
+	"chrome":  chromeProfile,
+	"firefox": firefoxProfile,
+	"safari":  safariProfile,
+	// iOS apps and Safari on iOS use the same TLS stack as Safari on macOS.
+	"ios":    safariProfile,
+	"golang": golangProfile,
+}
+
+// tlsClientProfileNames returns the names accepted by --tls-client-profile.
+func tlsClientProfileNames() []string {
+	var names []string
+	for name := range tlsClientProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// GREASE slots: clients that send GREASE (RFC 8701) pick a random value for
+// each place it appears, using the same value for the supported groups and
+// key shares.
+const (
+	greaseCipher = iota
+	greaseFirstExtension
+	greaseLastExtension
+	greaseGroup
+	greaseVersion
+	greaseSlots
+)
+
+// helloBuilder holds the per-connection values of a ClientHello.
+type helloBuilder struct {
+	serverName string
+	alpn       []string
+	grease     [greaseSlots]uint16
+}
+
+// helloExtension returns an extension of a ClientHello, or nil to leave it
+// out.
+type helloExtension func(h *helloBuilder) []byte
+
+// TLSVector returns data with a big-endian length prefix of the given size.
+// It and the other TLS encoding helpers are shared with modules that build
+// their own handshakes, such as http's QUIC client.
+func TLSVector(prefix int, data ...[]byte) []byte {
+	body := bytes.Join(data, nil)
+	ret := make([]byte, prefix, prefix+len(body))
+	for i := 0; i < prefix; i++ {
+		ret[i] = byte(len(body) >> uint(8*(prefix-1-i)))
+	}
+	return append(ret, body...)
+}
+
+// TLSUint16s returns the values in big-endian order.
+func TLSUint16s(values ...uint16) []byte {
+	ret := make([]byte, 2*len(values))
+	for i, v := range values {
+		binary.BigEndian.PutUint16(ret[2*i:], v)
+	}
+	return ret
+}
+
+// TLSExtension returns an extension with the given type and body.
+func TLSExtension(typ uint16, body ...[]byte) []byte {
+	return append(TLSUint16s(typ), TLSVector(2, body...)...)
+}
+
+// staticExtension is an extension with a fixed body.
+func staticExtension(typ uint16, body []byte) helloExtension {
+	return func(*helloBuilder) []byte {
+		return TLSExtension(typ, body)
+	}
+}
+
+// greaseExtension is a GREASE extension with the given body.
+func greaseExtension(slot int, body []byte) helloExtension {
+	return func(h *helloBuilder) []byte {
+		return TLSExtension(h.grease[slot], body)
+	}
+}
+
+// serverNameExtension names the server, if there is a name to send.
+func serverNameExtension(h *helloBuilder) []byte {
+	if h.serverName == "" {
+		return nil
+	}
+	return TLSExtension(tlsExtensionServerName, TLSVector(2, []byte{0}, TLSVector(2, []byte(h.serverName))))
+}
+
+// alpnExtension offers the application protocols, if there are any.
+func alpnExtension(h *helloBuilder) []byte {
+	if len(h.alpn) == 0 {
+		return nil
+	}
+	var protocols [][]byte
+	for _, protocol := range h.alpn {
+		protocols = append(protocols, TLSVector(1, []byte(protocol)))
+	}
+	return TLSExtension(tlsExtensionALPN, TLSVector(2, protocols...))
+}
+
+// groupsExtension offers the supported groups, after a GREASE group if
+// grease is set.
+func groupsExtension(grease bool, groups ...uint16) helloExtension {
+	return func(h *helloBuilder) []byte {
+		offered := groups
+		if grease {
+			offered = append([]uint16{h.grease[greaseGroup]}, groups...)
+		}
+		return TLSExtension(0x000a, TLSVector(2, TLSUint16s(offered...)))
+	}
+}
+
+// keyShareExtension sends random key shares for the groups, after a GREASE
+// share if grease is set. They are never used, as TLS 1.3 is not offered.
+func keyShareExtension(grease bool, groups ...uint16) helloExtension {
+	return func(h *helloBuilder) []byte {
+		var shares [][]byte
+		if grease {
+			shares = append(shares, TLSUint16s(h.grease[greaseGroup]), TLSVector(2, []byte{0}))
+		}
+		for _, group := range groups {
+			var key []byte
+			switch group {
+			case 0x001d:
+				key = make([]byte, 32)
+			case 0x0017:
+				key = make([]byte, 65)
+			}
+			rand.Read(key)
+			if group == 0x0017 {
+				key[0] = 4
+			}
+			shares = append(shares, TLSUint16s(group), TLSVector(2, key))
+		}
+		return TLSExtension(0x0033, TLSVector(2, shares...))
+	}
+}
+
+// versionsExtension offers TLS 1.2 and the given older versions, after a
+// GREASE version if grease is set.
+func versionsExtension(grease bool, older ...uint16) helloExtension {
+	return func(h *helloBuilder) []byte {
+		versions := append([]uint16{0x0303}, older...)
+		if grease {
+			versions = append([]uint16{h.grease[greaseVersion]}, versions...)
+		}
+		return TLSExtension(tlsExtensionSupportedVersions, TLSVector(1, TLSUint16s(versions...)))
+	}
+}
+
+// signatureAlgorithmsExtension offers the signature algorithms.
+func signatureAlgorithmsExtension(algorithms ...uint16) helloExtension {
+	return staticExtension(tlsExtensionSignatureAlgorithms, TLSVector(2, TLSUint16s(algorithms...)))
+}
+
+// Extensions with the same body in every profile.
+var (
+	extendedMasterSecretExtension = staticExtension(0x0017, nil)
+	renegotiationInfoExtension    = staticExtension(0xff01, []byte{0})
+	pointFormatsExtension         = staticExtension(0x000b, []byte{1, 0})
+	sessionTicketExtension        = staticExtension(0x0023, nil)
+	statusRequestExtension        = staticExtension(0x0005, []byte{1, 0, 0, 0, 0})
+	sctExtension                  = staticExtension(0x0012, nil)
+	pskModesExtension             = staticExtension(0x002d, []byte{1, 1})
+)
+
+// chromeProfile is the ClientHello of Chrome 120.
+var chromeProfile = &tlsClientProfile{
+	ciphers: []uint16{0x0a0a, 0x1301, 0x1302, 0x1303, 0xc02b, 0xc02f, 0xc02c, 0xc030, 0xcca9, 0xcca8, 0xc013, 0xc014, 0x009c, 0x009d, 0x002f, 0x0035},
+	extensions: []helloExtension{
+		greaseExtension(greaseFirstExtension, nil),
+		serverNameExtension,
+		extendedMasterSecretExtension,
+		renegotiationInfoExtension,
+		groupsExtension(true, 0x001d, 0x0017, 0x0018),
+		pointFormatsExtension,
+		sessionTicketExtension,
+		alpnExtension,
+		statusRequestExtension,
+		signatureAlgorithmsExtension(0x0403, 0x0804, 0x0401, 0x0503, 0x0805, 0x0501, 0x0806, 0x0601),
+		sctExtension,
+		keyShareExtension(true, 0x001d),
+		pskModesExtension,
+		versionsExtension(true),
+		// compress_certificate: brotli.
+		staticExtension(0x001b, []byte{2, 0, 2}),
+		// application_settings: h2.
+		staticExtension(0x4469, TLSVector(2, TLSVector(1, []byte("h2")))),
+		greaseExtension(greaseLastExtension, []byte{0}),
+	},
+	shuffle: true,
+}
+
+// firefoxProfile is the ClientHello of Firefox 120.
+var firefoxProfile = &tlsClientProfile{
+	ciphers: []uint16{0x1301, 0x1303, 0x1302, 0xc02b, 0xc02f, 0xcca9, 0xcca8, 0xc02c, 0xc030, 0xc00a, 0xc009, 0xc013, 0xc014, 0x009c, 0x009d, 0x002f, 0x0035},
+	extensions: []helloExtension{
+		serverNameExtension,
+		extendedMasterSecretExtension,
+		renegotiationInfoExtension,
+		groupsExtension(false, 0x001d, 0x0017, 0x0018, 0x0019, 0x0100, 0x0101),
+		pointFormatsExtension,
+		sessionTicketExtension,
+		alpnExtension,
+		statusRequestExtension,
+		// delegated_credentials.
+		staticExtension(0x0022, TLSVector(2, TLSUint16s(0x0403, 0x0503, 0x0603, 0x0203))),
+		keyShareExtension(false, 0x001d, 0x0017),
+		versionsExtension(false),
+		signatureAlgorithmsExtension(0x0403, 0x0503, 0x0603, 0x0804, 0x0805, 0x0806, 0x0401, 0x0501, 0x0601, 0x0203, 0x0201),
+		pskModesExtension,
+		// record_size_limit: 16385.
+		staticExtension(0x001c, TLSUint16s(0x4001)),
+	},
+}
+
+// safariProfile is the ClientHello of Safari 17.
+var safariProfile = &tlsClientProfile{
+	ciphers: []uint16{0x0a0a, 0x1301, 0x1302, 0x1303, 0xc02c, 0xc02b, 0xcca9, 0xc030, 0xc02f, 0xcca8, 0xc00a, 0xc009, 0xc014, 0xc013, 0x009d, 0x009c, 0x0035, 0x002f, 0xc008, 0xc012, 0x000a},
+	extensions: []helloExtension{
+		greaseExtension(greaseFirstExtension, nil),
+		serverNameExtension,
+		extendedMasterSecretExtension,
+		renegotiationInfoExtension,
+		groupsExtension(true, 0x001d, 0x0017, 0x0018, 0x0019),
+		pointFormatsExtension,
+		alpnExtension,
+		statusRequestExtension,
+		signatureAlgorithmsExtension(0x0403, 0x0804, 0x0401, 0x0503, 0x0203, 0x0805, 0x0805, 0x0501, 0x0806, 0x0601, 0x0201),
+		sctExtension,
+		keyShareExtension(true, 0x001d),
+		pskModesExtension,
+		versionsExtension(true, 0x0302, 0x0301),
+		// compress_certificate: zlib.
+		staticExtension(0x001b, []byte{2, 0, 1}),
+		greaseExtension(greaseLastExtension, []byte{0}),
+	},
+}
+
+// golangProfile is the ClientHello of Go 1.21's crypto/tls, with its
+// default configuration.
+var golangProfile = &tlsClientProfile{
+	ciphers: []uint16{0xc02b, 0xc02f, 0xc02c, 0xc030, 0xcca9, 0xcca8, 0xc009, 0xc013, 0xc00a, 0xc014, 0x009c, 0x009d, 0x002f, 0x0035, 0xc012, 0x000a, 0x1301, 0x1302, 0x1303},
+	extensions: []helloExtension{
+		serverNameExtension,
+		statusRequestExtension,
+		groupsExtension(false, 0x001d, 0x0017, 0x0018, 0x0019),
+		pointFormatsExtension,
+		sessionTicketExtension,
+		signatureAlgorithmsExtension(0x0804, 0x0403, 0x0807, 0x0805, 0x0806, 0x0401, 0x0501, 0x0601, 0x0503, 0x0603, 0x0201, 0x0203),
+		// signature_algorithms_cert.
+		staticExtension(0x0032, TLSVector(2, TLSUint16s(0x0804, 0x0403, 0x0807, 0x0805, 0x0806, 0x0401, 0x0501, 0x0601, 0x0503, 0x0603, 0x0201, 0x0203))),
+		renegotiationInfoExtension,
+		extendedMasterSecretExtension,
+		alpnExtension,
+		sctExtension,
+		versionsExtension(false, 0x0302, 0x0301),
+		keyShareExtension(false, 0x001d),
+		pskModesExtension,
+	},
+}
+
+// randomGREASE returns a random GREASE value other than those in used.
+func randomGREASE(used []uint16) uint16 {
+	for {
+		n := uint16(mathrand.Intn(16))
+		value := n<<12 | 0x0a00 | n<<4 | 0x0a
+		unique := true
+		for _, v := range used {
+			unique = unique && v != value
+		}
+		if unique {
+			return value
+		}
+	}
+}
+
+// clientHello returns a ClientHello handshake message for the server name
+// and application protocols, with the given random (or a new one, if nil).
+func (p *tlsClientProfile) clientHello(serverName string, alpn []string, random []byte) ([]byte, error) {
+	if random == nil {
+		random = make([]byte, 32)
+		rand.Read(random)
+	} else if len(random) != 32 {
+		return nil, fmt.Errorf("client random must be 32 bytes, not %d", len(random))
+	}
+	h := &helloBuilder{serverName: serverName, alpn: alpn}
+	// The first and last GREASE extensions must differ, as servers reject
+	// repeated extensions.
+	for i := range h.grease {
+		h.grease[i] = randomGREASE(h.grease[:i])
+	}
+
+	ciphers := append([]uint16(nil), p.ciphers...)
+	for i, cipher := range ciphers {
+		if isGREASE(cipher) {
+			ciphers[i] = h.grease[greaseCipher]
+		}
+	}
+	extensions := append([]helloExtension(nil), p.extensions...)
+	if p.shuffle {
+		// GREASE extensions keep their places at the ends.
+		middle := extensions[1 : len(extensions)-1]
+		for i := len(middle) - 1; i > 0; i-- {
+			j := mathrand.Intn(i + 1)
+			middle[i], middle[j] = middle[j], middle[i]
+		}
+	}
+	var marshaled [][]byte
+	for _, extension := range extensions {
+		if data := extension(h); data != nil {
+			marshaled = append(marshaled, data)
+		}
+	}
+
+	sessionID := make([]byte, 32)
+	rand.Read(sessionID)
+	body := bytes.Join([][]byte{
+		TLSUint16s(0x0303),
+		random,
+		TLSVector(1, sessionID),
+		TLSVector(2, TLSUint16s(ciphers...)),
+		TLSVector(1, []byte{0}),
+		TLSVector(2, marshaled...),
+	}, nil)
+	return append([]byte{tlsClientHello}, TLSVector(3, body)...), nil
+}
+
+// getTLSClientProfile returns the profile with the given name.
+func getTLSClientProfile(name string) (*tlsClientProfile, error) {
+	profile, ok := tlsClientProfiles[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("unknown TLS client profile %q (must be one of %s)", name, strings.Join(tlsClientProfileNames(), ", "))
+	}
+	return profile, nil
+}
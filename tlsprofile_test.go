@@ -0,0 +1,58 @@
+package zgrab2
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestTLSClientProfiles(t *testing.T) {
+	for name, expected := range map[string]string{
+		"chrome":  "t12d1515h2",
+		"firefox": "t12d1714h2",
+		"safari":  "t12d2013h2",
+		"golang":  "t12d1914h2",
+	} {
+		profile, err := getTLSClientProfile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg, err := profile.clientHello("example.com", []string{"h2", "http/1.1"}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hello := parseHello(msg)
+		if hello == nil {
+			t.Fatalf("%s: unparseable ClientHello", name)
+		}
+		ja4 := hello.ja4()
+		if ja4[:10] != expected {
+			t.Errorf("%s: got JA4 %s, expected %s_...", name, ja4, expected)
+		}
+		if len(hello.ciphers) != len(profile.ciphers) {
+			t.Errorf("%s: wrong ciphers %x", name, hello.ciphers)
+		}
+
+		// The random parts change, but not the fingerprint, even for clients
+		// that shuffle their extensions.
+		again, _ := profile.clientHello("example.com", []string{"h2", "http/1.1"}, nil)
+		if bytes.Equal(msg, again) || parseHello(again).ja4() != ja4 {
+			t.Errorf("%s: ClientHellos do not vary as they should", name)
+		}
+	}
+
+	random := bytes.Repeat([]byte{7}, 32)
+	msg, _ := chromeProfile.clientHello("", nil, random)
+	hello := parseHello(msg)
+	if hello.serverName || hello.alpn != "" || !bytes.Equal(msg[6:38], random) {
+		t.Errorf("wrong ClientHello without a name or protocols: %+v", hello)
+	}
+	if first, last := hello.extensions[0], hello.extensions[len(hello.extensions)-1]; !isGREASE(first) || !isGREASE(last) || first == last {
+		t.Errorf("wrong GREASE extensions %04x and %04x", first, last)
+	}
+	if _, err := chromeProfile.clientHello("", nil, []byte{1}); err == nil {
+		t.Error("expected an error for a short client random")
+	}
+	if _, err := getTLSClientProfile("netscape"); err == nil {
+		t.Error("expected an error for an unknown profile")
+	}
+}
@@ -0,0 +1,246 @@
+package zgrab2
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+)
+
+// TLSResumption is the result of trying to resume a TLS session, with
+// --resumption.
+type TLSResumption struct {
+	// Method is how resumption was attempted: "session_ticket" or
+	// "session_id", or "none" if the server offered no session to resume.
+	// It is "unsupported_tls13" if the server offered none but accepts
+	// TLS 1.3, whose tickets cannot be tried, so that whether it resumes
+	// sessions is unknown.
+	Method string `json:"method"`
+
+	// Resumed is true if the server accepted the session, abbreviating the
+	// second handshake.
+	Resumed bool `json:"resumed"`
+
+	// TicketLifetimeHint is how long the server said the ticket could be
+	// kept, in seconds (0 meaning unspecified), if it issued one.
+	TicketLifetimeHint *uint32 `json:"ticket_lifetime_hint,omitempty"`
+
+	// TicketLength is the length of the session ticket, in bytes.
+	TicketLength int `json:"ticket_length,omitempty"`
+
+	// Error is the reason the second handshake failed, if it did.
+	Error string `json:"error,omitempty"`
+}
+
+// TLS record and handshake message types and extensions used in resumption.
+const (
+	tlsRecordAlert = 21
+
+	tlsNewSessionTicket = 4
+
+	tlsExtensionSessionTicket = 0x0023
+)
+
+// sessionTicket returns the lifetime hint and ticket of the first
+// NewSessionTicket message in msgs, or a nil ticket if there is none.
+func sessionTicket(msgs [][]byte) (uint32, []byte) {
+	for _, msg := range msgs {
+		if msg[0] != tlsNewSessionTicket {
+			continue
+		}
+		r := &helloReader{data: msg[4:], ok: true}
+		lifetime := r.bytes(4)
+		ticket := r.vector(2).data
+		if r.ok && len(ticket) > 0 {
+			return binary.BigEndian.Uint32(lifetime), ticket
+		}
+	}
+	return 0, nil
+}
+
+// resumptionHello returns the ClientHello with a new random and the given
+// session ID and, if it is not nil, session ticket.
+func resumptionHello(hello, sessionID, ticket []byte) ([]byte, error) {
+	if len(hello) < 4 || hello[0] != tlsClientHello {
+		return nil, errors.New("no ClientHello to resend")
+	}
+	r := &helloReader{data: hello[4:], ok: true}
+	version := r.bytes(2)
+	r.bytes(32)
+	r.vector(1)
+	start := len(hello) - len(r.data)
+	r.vector(2)
+	r.vector(1)
+	// The ciphers and compression methods are kept as they are.
+	middle := hello[start : len(hello)-len(r.data)]
+	extensions := r.vector(2)
+	var marshaled [][]byte
+	sentTicket := false
+	for extensions.ok && len(extensions.data) >= 4 {
+		typ := extensions.uint16()
+		body := extensions.vector(2).data
+		if typ == tlsExtensionSessionTicket && ticket != nil {
+			body, sentTicket = ticket, true
+		}
+		marshaled = append(marshaled, TLSExtension(typ, body))
+	}
+	if !r.ok || !extensions.ok {
+		return nil, errors.New("unparseable ClientHello")
+	}
+	if ticket != nil && !sentTicket {
+		marshaled = append(marshaled, TLSExtension(tlsExtensionSessionTicket, ticket))
+	}
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return nil, err
+	}
+	body := bytes.Join([][]byte{
+		version,
+		random,
+		TLSVector(1, sessionID),
+		middle,
+		TLSVector(2, marshaled...),
+	}, nil)
+	return append([]byte{tlsClientHello}, TLSVector(3, body)...), nil
+}
+
+// tlsAlertError is the error for a handshake the server refused with an
+// alert.
+type tlsAlertError byte
+
+func (e tlsAlertError) Error() string {
+	return fmt.Sprintf("server sent alert %d", byte(e))
+}
+
+// readHandshake reads records from conn until the handshake messages in
+// them satisfy done, and returns the messages.
+func readHandshake(conn net.Conn, done func(msgs [][]byte) bool) ([][]byte, error) {
+	var data []byte
+	buf := make([]byte, 4096)
+	for len(data) < helloRecordLimit {
+		n, err := conn.Read(buf)
+		data = append(data, buf[:n]...)
+		if msgs := handshakeMessages(data); done(msgs) {
+			return msgs, nil
+		}
+		if alert, ok := recordAlert(data); ok {
+			return nil, tlsAlertError(alert)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return nil, errors.New("handshake too long")
+}
+
+// recordAlert returns the description of the first alert among the records
+// at the start of data, if there is one.
+func recordAlert(data []byte) (byte, bool) {
+	for len(data) >= 5 {
+		length := int(binary.BigEndian.Uint16(data[3:5]))
+		if len(data) < 5+length {
+			break
+		}
+		if data[0] == tlsRecordAlert && length >= 2 {
+			return data[6], true
+		}
+		data = data[5+length:]
+	}
+	return 0, false
+}
+
+// readServerHello reads records from conn until they hold a ServerHello,
+// which it returns.
+func readServerHello(conn net.Conn) (*tlsHello, error) {
+	msgs, err := readHandshake(conn, func(msgs [][]byte) bool {
+		msg := firstHello(msgs, tlsServerHello)
+		return msg != nil && !isHelloRetryRequest(msg)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if hello := parseHello(firstHello(msgs, tlsServerHello)); hello != nil {
+		return hello, nil
+	}
+	return nil, errors.New("unparseable ServerHello")
+}
+
+// resume connects to the target again and offers the session from the
+// handshake, preferring a session ticket to a session ID, and reports
+// whether the server resumed it. Only the server's reply to the ClientHello
+// is needed: a server resuming a session echoes the session ID offered
+// (RFC 5246, RFC 5077), where a new session gets a new one. zcrypto does not
+// negotiate TLS 1.3, so its tickets (which are sent encrypted, after the
+// handshake) are not attempted; a server that offers no TLS 1.2 session is
+// probed for TLS 1.3 so that the gap is reported.
+func (z *TLSConnection) resume() *TLSResumption {
+	ret := &TLSResumption{Method: "none"}
+	serverHello := parseHello(firstHello(z.received, tlsServerHello))
+	if serverHello == nil {
+		return nil
+	}
+	lifetime, ticket := sessionTicket(z.received)
+	sessionID := serverHello.sessionID
+	switch {
+	case ticket != nil:
+		ret.Method = "session_ticket"
+		ret.TicketLifetimeHint = &lifetime
+		ret.TicketLength = len(ticket)
+		// The server echoes a session ID sent with a ticket it accepts.
+		sessionID = make([]byte, 32)
+		if _, err := rand.Read(sessionID); err != nil {
+			ret.Error = err.Error()
+			return ret
+		}
+	case len(sessionID) > 0:
+		ret.Method = "session_id"
+	default:
+		if z.redial != nil && z.acceptsTLS13() {
+			ret.Method = "unsupported_tls13"
+		}
+		return ret
+	}
+
+	hello, err := resumptionHello(firstHello(z.sent, tlsClientHello), sessionID, ticket)
+	if err != nil {
+		ret.Error = err.Error()
+		return ret
+	}
+	conn, err := z.redial()
+	if err != nil {
+		ret.Error = err.Error()
+		return ret
+	}
+	defer conn.Close()
+	// The record version is TLS 1.0, as in zcrypto's first ClientHello.
+	record := append([]byte{tlsRecordHandshake, 3, 1}, TLSVector(2, hello)...)
+	if _, err := conn.Write(record); err != nil {
+		ret.Error = err.Error()
+		return ret
+	}
+	resumed, err := readServerHello(conn)
+	if err != nil {
+		ret.Error = err.Error()
+		return ret
+	}
+	ret.Resumed = bytes.Equal(resumed.sessionID, sessionID)
+	return ret
+}
+
+// acceptsTLS13 connects to the target again and reports whether the server
+// selects TLS 1.3.
+func (z *TLSConnection) acceptsTLS13() bool {
+	e := &tlsEnumerator{redial: z.redial}
+	if z.config != nil {
+		e.serverName = z.config.ServerName
+	}
+	result, _ := e.probe(&tlsProbe{
+		version: 0x0304,
+		ciphers: tls13Ciphers,
+		groups:  sortedKeys(tlsGroupNames),
+		sigAlgs: sortedKeys(tlsSignatureAlgorithmNames),
+	})
+	return result != nil && result.version == 0x0304
+}
@@ -0,0 +1,124 @@
+package zgrab2
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+// resumingServer answers the ClientHello read from conn with a ServerHello
+// echoing its session ID if resume is true, or with a new one otherwise.
+func resumingServer(conn net.Conn, resume bool, offered chan<- *tlsHello) {
+	defer conn.Close()
+	buf := make([]byte, 4096)
+	n, _ := conn.Read(buf)
+	msgs := handshakeMessages(buf[:n])
+	if len(msgs) == 0 {
+		offered <- nil
+		return
+	}
+	hello := parseHello(msgs[0])
+	offered <- hello
+	sessionID := bytes.Repeat([]byte{9}, 32)
+	if resume {
+		sessionID = hello.sessionID
+	}
+	conn.Write(tlsRecord(tlsRecordHandshake, tlsHandshake(tlsServerHello,
+		TLSUint16s(0x0303),
+		make([]byte, 32),
+		TLSVector(1, sessionID),
+		TLSUint16s(0xc02f),
+		[]byte{0},
+	)))
+}
+
+func TestTLSResumption(t *testing.T) {
+	sessionID := bytes.Repeat([]byte{1}, 32)
+	serverHello := tlsHandshake(tlsServerHello,
+		TLSUint16s(0x0303),
+		make([]byte, 32),
+		TLSVector(1, sessionID),
+		TLSUint16s(0xc02f),
+		[]byte{0},
+		TLSVector(2, TLSExtension(tlsExtensionSessionTicket)),
+	)
+	ticket := tlsHandshake(tlsNewSessionTicket, []byte{0, 0, 0x1c, 0x20}, TLSVector(2, []byte("ticket")))
+
+	for _, test := range []struct {
+		received [][]byte
+		resume   bool
+		method   string
+	}{
+		{[][]byte{serverHello}, true, "session_id"},
+		{[][]byte{serverHello}, false, "session_id"},
+		{[][]byte{serverHello, ticket}, true, "session_ticket"},
+	} {
+		offered := make(chan *tlsHello, 1)
+		conn := &TLSConnection{
+			sent:     [][]byte{testClientHello()},
+			received: test.received,
+			redial: func() (net.Conn, error) {
+				client, server := net.Pipe()
+				go resumingServer(server, test.resume, offered)
+				return client, nil
+			},
+		}
+		result := conn.resume()
+		if result.Method != test.method || result.Resumed != test.resume || result.Error != "" {
+			t.Errorf("wrong result %+v, expected %s resumed %v", result, test.method, test.resume)
+		}
+		hello := <-offered
+		if hello == nil || !hello.client || len(hello.ciphers) != 4 {
+			t.Fatalf("wrong ClientHello %+v", hello)
+		}
+		if test.method == "session_id" && !bytes.Equal(hello.sessionID, sessionID) {
+			t.Errorf("offered session ID %x, expected %x", hello.sessionID, sessionID)
+		}
+		if test.method == "session_ticket" {
+			if result.TicketLifetimeHint == nil || *result.TicketLifetimeHint != 7200 || result.TicketLength != 6 {
+				t.Errorf("wrong ticket parameters %+v", result)
+			}
+			if len(hello.sessionID) != 32 || bytes.Equal(hello.sessionID, sessionID) {
+				t.Errorf("offered session ID %x with a ticket", hello.sessionID)
+			}
+		}
+	}
+
+	for _, test := range []struct {
+		versions []uint16
+		method   string
+	}{
+		{[]uint16{0x0303}, "none"},
+		{[]uint16{0x0303, 0x0304}, "unsupported_tls13"},
+	} {
+		server := &enumerationServer{versions: test.versions, ciphers: []uint16{0x1301, 0xc02f}, groups: []uint16{0x001d}}
+		conn := &TLSConnection{received: [][]byte{testServerHello(make([]byte, 32))}, redial: server.dial}
+		if result := conn.resume(); result.Method != test.method || result.Resumed {
+			t.Errorf("wrong result without a session %+v, expected %s", result, test.method)
+		}
+	}
+}
+
+func TestResumptionHello(t *testing.T) {
+	sessionID := []byte{1, 2, 3}
+	msg, err := resumptionHello(testClientHello(), sessionID, []byte("ticket"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	original, hello := parseHello(testClientHello()), parseHello(msg)
+	if hello == nil || !bytes.Equal(hello.sessionID, sessionID) {
+		t.Fatalf("wrong ClientHello %+v", hello)
+	}
+	if len(hello.extensions) != len(original.extensions)+1 || hello.extensions[len(hello.extensions)-1] != tlsExtensionSessionTicket {
+		t.Errorf("session ticket extension not added: %x", hello.extensions)
+	}
+	if len(hello.ciphers) != len(original.ciphers) || hello.alpn != original.alpn {
+		t.Errorf("ClientHello changed: %+v", hello)
+	}
+	if !bytes.Contains(msg, TLSExtension(tlsExtensionSessionTicket, []byte("ticket"))) {
+		t.Error("session ticket not sent")
+	}
+	if _, err := resumptionHello(testServerHello(make([]byte, 32)), nil, nil); err == nil {
+		t.Error("expected an error for a ServerHello")
+	}
+}
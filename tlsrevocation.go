@@ -0,0 +1,647 @@
+package zgrab2
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TLSRevocation is the revocation status of the server's certificate, from
+// its OCSP responders and CRL distribution points, with --check-revocation.
+type TLSRevocation struct {
+	// Status is revoked if any responder or CRL says the certificate is
+	// revoked, good if any says it is not, and unknown otherwise.
+	Status string `json:"status"`
+
+	// IssuerSource is where the issuer's certificate, needed to ask the
+	// OCSP responders and to verify the answers, was found: chain if the
+	// server sent it, or aia if it was fetched from the certificate's CA
+	// Issuers URL.
+	IssuerSource string `json:"issuer_source,omitempty"`
+
+	// OCSP are the answers of the certificate's OCSP responders.
+	OCSP []*RevocationCheck `json:"ocsp,omitempty"`
+
+	// CRL are the answers of the certificate's CRL distribution points.
+	CRL []*RevocationCheck `json:"crl,omitempty"`
+
+	// Error is the reason the check was incomplete, if it was.
+	Error string `json:"error,omitempty"`
+}
+
+// RevocationCheck is the answer of an OCSP responder or CRL distribution
+// point.
+type RevocationCheck struct {
+	URL string `json:"url"`
+
+	// Status is good, revoked or unknown.
+	Status string `json:"status,omitempty"`
+
+	// RevokedAt and RevocationReason say when and why the certificate was
+	// revoked, if it was.
+	RevokedAt        *time.Time `json:"revoked_at,omitempty"`
+	RevocationReason string     `json:"revocation_reason,omitempty"`
+
+	// ThisUpdate and NextUpdate are when the answer was made, and when the
+	// next will be.
+	ThisUpdate *time.Time `json:"this_update,omitempty"`
+	NextUpdate *time.Time `json:"next_update,omitempty"`
+
+	// Verified is true if the answer is signed by the issuer, or by an OCSP
+	// responder certificate it issued.
+	Verified bool `json:"verified"`
+
+	// Size is the length of the OCSP response or CRL, in bytes.
+	Size int `json:"size,omitempty"`
+
+	// Latency is the time in microseconds taken to fetch the answer. For
+	// Cached answers, it is the time taken when it was first fetched.
+	Latency int64 `json:"latency_us"`
+
+	// Cached is true if the answer was fetched for an earlier target.
+	Cached bool `json:"cached,omitempty"`
+
+	Error string `json:"error,omitempty"`
+}
+
+const (
+	revocationGood    = "good"
+	revocationRevoked = "revoked"
+	revocationUnknown = "unknown"
+)
+
+// revocationMaxSize bounds the size of the OCSP responses, CRLs and issuer
+// certificates fetched.
+const revocationMaxSize = 64 << 20
+
+// revocationReasons are the names of the CRLReason codes (RFC 5280).
+var revocationReasons = []string{
+	"unspecified",
+	"key_compromise",
+	"ca_compromise",
+	"affiliation_changed",
+	"superseded",
+	"cessation_of_operation",
+	"certificate_hold",
+	"",
+	"remove_from_crl",
+	"privilege_withdrawn",
+	"aa_compromise",
+}
+
+func revocationReason(reason int) string {
+	if reason >= 0 && reason < len(revocationReasons) && revocationReasons[reason] != "" {
+		return revocationReasons[reason]
+	}
+	return strconv.Itoa(reason)
+}
+
+var (
+	oidSHA1      = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidOCSPBasic = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 1}
+	oidCRLReason = asn1.ObjectIdentifier{2, 5, 29, 21}
+)
+
+// signatureAlgorithms maps the signature algorithms OCSP responses are
+// signed with to crypto/x509's.
+var signatureAlgorithms = []struct {
+	oid       asn1.ObjectIdentifier
+	algorithm x509.SignatureAlgorithm
+}{
+	{asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 5}, x509.SHA1WithRSA},
+	{asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 11}, x509.SHA256WithRSA},
+	{asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 12}, x509.SHA384WithRSA},
+	{asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 13}, x509.SHA512WithRSA},
+	{asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 1}, x509.ECDSAWithSHA1},
+	{asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}, x509.ECDSAWithSHA256},
+	{asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 3}, x509.ECDSAWithSHA384},
+	{asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 4}, x509.ECDSAWithSHA512},
+}
+
+// The OCSP messages (RFC 6960).
+type ocspCertID struct {
+	HashAlgorithm pkix.AlgorithmIdentifier
+	NameHash      []byte
+	KeyHash       []byte
+	SerialNumber  *big.Int
+}
+
+type ocspRequest struct {
+	TBSRequest struct {
+		Version     int `asn1:"explicit,tag:0,default:0,optional"`
+		RequestList []struct {
+			CertID ocspCertID
+		}
+	}
+}
+
+type ocspResponse struct {
+	Status        asn1.Enumerated
+	ResponseBytes struct {
+		ResponseType asn1.ObjectIdentifier
+		Response     []byte
+	} `asn1:"explicit,tag:0,optional"`
+}
+
+type ocspBasicResponse struct {
+	TBSResponseData    ocspResponseData
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          asn1.BitString
+	Certificates       []asn1.RawValue `asn1:"explicit,tag:0,optional"`
+}
+
+type ocspResponseData struct {
+	Raw                asn1.RawContent
+	Version            int `asn1:"explicit,tag:0,default:0,optional"`
+	ResponderID        asn1.RawValue
+	ProducedAt         time.Time `asn1:"generalized"`
+	Responses          []ocspSingleResponse
+	ResponseExtensions []pkix.Extension `asn1:"explicit,tag:1,optional"`
+}
+
+type ocspSingleResponse struct {
+	CertID ocspCertID
+	// CertStatus is good [0], revoked [1] (an ocspRevokedInfo) or unknown
+	// [2].
+	CertStatus       asn1.RawValue
+	ThisUpdate       time.Time        `asn1:"generalized"`
+	NextUpdate       time.Time        `asn1:"generalized,explicit,tag:0,optional"`
+	SingleExtensions []pkix.Extension `asn1:"explicit,tag:1,optional"`
+}
+
+type ocspRevokedInfo struct {
+	RevocationTime time.Time       `asn1:"generalized"`
+	Reason         asn1.Enumerated `asn1:"explicit,tag:0,optional"`
+}
+
+// ocspResponseStatuses are the names of the OCSPResponseStatus codes.
+var ocspResponseStatuses = map[asn1.Enumerated]string{
+	1: "malformedRequest",
+	2: "internalError",
+	3: "tryLater",
+	5: "sigRequired",
+	6: "unauthorized",
+}
+
+// ocspCertIDFor returns the CertID of the certificate, using SHA-1 as
+// responders must support.
+func ocspCertIDFor(cert, issuer *x509.Certificate) (*ocspCertID, error) {
+	var spki struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(issuer.RawSubjectPublicKeyInfo, &spki); err != nil {
+		return nil, err
+	}
+	nameHash := sha1.Sum(issuer.RawSubject)
+	keyHash := sha1.Sum(spki.PublicKey.RightAlign())
+	return &ocspCertID{
+		HashAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidSHA1, Parameters: asn1.NullRawValue},
+		NameHash:      nameHash[:],
+		KeyHash:       keyHash[:],
+		SerialNumber:  cert.SerialNumber,
+	}, nil
+}
+
+// verifyOCSPSignature returns whether the response is signed by the issuer,
+// or by a certificate in it with the OCSP signing usage that the issuer
+// signed.
+func verifyOCSPSignature(basic *ocspBasicResponse, issuer *x509.Certificate) bool {
+	algorithm := x509.UnknownSignatureAlgorithm
+	for _, a := range signatureAlgorithms {
+		if a.oid.Equal(basic.SignatureAlgorithm.Algorithm) {
+			algorithm = a.algorithm
+		}
+	}
+	if algorithm == x509.UnknownSignatureAlgorithm {
+		return false
+	}
+	signed, signature := basic.TBSResponseData.Raw, basic.Signature.RightAlign()
+	if issuer.CheckSignature(algorithm, signed, signature) == nil {
+		return true
+	}
+	for _, raw := range basic.Certificates {
+		responder, err := x509.ParseCertificate(raw.FullBytes)
+		if err != nil || responder.CheckSignatureFrom(issuer) != nil {
+			continue
+		}
+		for _, usage := range responder.ExtKeyUsage {
+			if usage == x509.ExtKeyUsageOCSPSigning && responder.CheckSignature(algorithm, signed, signature) == nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// parseOCSPResponse returns the answer in the response about the
+// certificate with the CertID.
+func parseOCSPResponse(der []byte, id *ocspCertID, issuer *x509.Certificate) (*RevocationCheck, error) {
+	var response ocspResponse
+	if _, err := asn1.Unmarshal(der, &response); err != nil {
+		return nil, fmt.Errorf("invalid OCSP response: %v", err)
+	}
+	if response.Status != 0 {
+		if name, ok := ocspResponseStatuses[response.Status]; ok {
+			return nil, fmt.Errorf("responder replied %s", name)
+		}
+		return nil, fmt.Errorf("responder replied with status %d", response.Status)
+	}
+	if !response.ResponseBytes.ResponseType.Equal(oidOCSPBasic) {
+		return nil, fmt.Errorf("unsupported OCSP response type %v", response.ResponseBytes.ResponseType)
+	}
+	var basic ocspBasicResponse
+	if _, err := asn1.Unmarshal(response.ResponseBytes.Response, &basic); err != nil {
+		return nil, fmt.Errorf("invalid OCSP response: %v", err)
+	}
+	for _, single := range basic.TBSResponseData.Responses {
+		if single.CertID.SerialNumber == nil || single.CertID.SerialNumber.Cmp(id.SerialNumber) != 0 || !bytes.Equal(single.CertID.KeyHash, id.KeyHash) {
+			continue
+		}
+		ret := &RevocationCheck{ThisUpdate: &single.ThisUpdate, Size: len(der)}
+		if !single.NextUpdate.IsZero() {
+			ret.NextUpdate = &single.NextUpdate
+		}
+		switch single.CertStatus.Tag {
+		case 0:
+			ret.Status = revocationGood
+		case 1:
+			var revoked ocspRevokedInfo
+			if _, err := asn1.UnmarshalWithParams(single.CertStatus.FullBytes, &revoked, "tag:1"); err != nil {
+				return nil, fmt.Errorf("invalid OCSP revocation: %v", err)
+			}
+			ret.Status, ret.RevokedAt = revocationRevoked, &revoked.RevocationTime
+			ret.RevocationReason = revocationReason(int(revoked.Reason))
+		default:
+			ret.Status = revocationUnknown
+		}
+		ret.Verified = verifyOCSPSignature(&basic, issuer)
+		return ret, nil
+	}
+	return nil, errors.New("OCSP response does not cover the certificate")
+}
+
+// crlEntries is a parsed CRL.
+type crlEntries struct {
+	// revoked maps serial numbers to the CRL's entries for them.
+	revoked    map[string]*pkix.RevokedCertificate
+	thisUpdate time.Time
+	nextUpdate time.Time
+	verified   bool
+	size       int
+}
+
+func parseCRL(der []byte, issuer *x509.Certificate) (*crlEntries, error) {
+	list, err := x509.ParseCRL(der)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CRL: %v", err)
+	}
+	ret := &crlEntries{
+		revoked:    make(map[string]*pkix.RevokedCertificate),
+		thisUpdate: list.TBSCertList.ThisUpdate,
+		nextUpdate: list.TBSCertList.NextUpdate,
+		size:       len(der),
+	}
+	for i := range list.TBSCertList.RevokedCertificates {
+		entry := &list.TBSCertList.RevokedCertificates[i]
+		ret.revoked[entry.SerialNumber.String()] = entry
+	}
+	ret.verified = issuer != nil && issuer.CheckCRLSignature(list) == nil
+	return ret, nil
+}
+
+// check returns the CRL's answer about the certificate.
+func (c *crlEntries) check(cert *x509.Certificate) *RevocationCheck {
+	ret := &RevocationCheck{Status: revocationGood, ThisUpdate: &c.thisUpdate, Verified: c.verified, Size: c.size}
+	if !c.nextUpdate.IsZero() {
+		ret.NextUpdate = &c.nextUpdate
+	}
+	entry, ok := c.revoked[cert.SerialNumber.String()]
+	if !ok {
+		return ret
+	}
+	ret.Status, ret.RevokedAt = revocationRevoked, &entry.RevocationTime
+	for _, extension := range entry.Extensions {
+		var reason asn1.Enumerated
+		if extension.Id.Equal(oidCRLReason) {
+			if _, err := asn1.Unmarshal(extension.Value, &reason); err == nil {
+				ret.RevocationReason = revocationReason(int(reason))
+			}
+		}
+	}
+	return ret
+}
+
+// revocationCacheEntry is a fetched (or fetching) OCSP answer, CRL or
+// issuer certificate.
+type revocationCacheEntry struct {
+	// done is closed once the fetch is done.
+	done    chan struct{}
+	value   interface{}
+	latency time.Duration
+	err     error
+	expires time.Time
+}
+
+// revocationCache caches fetches for --check-revocation across targets, so
+// that each CRL (often megabytes, and shared by many certificates) is only
+// downloaded once until it is updated. Concurrent fetches of the same URL
+// wait for the first.
+type revocationCache struct {
+	ttl, errorTTL time.Duration
+	maxEntries    int
+
+	mutex   sync.Mutex
+	entries map[string]*revocationCacheEntry
+}
+
+var revocations = &revocationCache{
+	ttl:        time.Hour,
+	errorTTL:   5 * time.Minute,
+	maxEntries: 4096,
+	entries:    make(map[string]*revocationCacheEntry),
+}
+
+// get returns the value cached for the key, calling fetch to get it if
+// there is none. fetch returns the value and when it expires, if before
+// the cache's TTL.
+func (c *revocationCache) get(key string, fetch func() (interface{}, time.Time, error)) (value interface{}, latency time.Duration, cached bool, err error) {
+	now := time.Now()
+	c.mutex.Lock()
+	entry, ok := c.entries[key]
+	if ok && !entry.expires.IsZero() && !now.Before(entry.expires) {
+		ok = false
+	}
+	if ok {
+		c.mutex.Unlock()
+		<-entry.done
+		return entry.value, entry.latency, true, entry.err
+	}
+	entry = &revocationCacheEntry{done: make(chan struct{})}
+	c.evict(now)
+	c.entries[key] = entry
+	c.mutex.Unlock()
+
+	var expires time.Time
+	entry.value, expires, entry.err = fetch()
+	entry.latency = time.Since(now)
+	ttl := c.ttl
+	if entry.err != nil {
+		ttl = c.errorTTL
+	}
+	c.mutex.Lock()
+	entry.expires = time.Now().Add(ttl)
+	if !expires.IsZero() && expires.Before(entry.expires) {
+		entry.expires = expires
+	}
+	c.mutex.Unlock()
+	close(entry.done)
+	return entry.value, entry.latency, false, entry.err
+}
+
+// evict makes room for an entry, removing expired entries, then arbitrary
+// finished ones. The caller holds the mutex.
+func (c *revocationCache) evict(now time.Time) {
+	if len(c.entries) < c.maxEntries {
+		return
+	}
+	for key, entry := range c.entries {
+		if !entry.expires.IsZero() && !now.Before(entry.expires) {
+			delete(c.entries, key)
+		}
+	}
+	for key, entry := range c.entries {
+		if len(c.entries) < c.maxEntries {
+			break
+		}
+		if !entry.expires.IsZero() {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// revocationTransport fetches OCSP responses, CRLs and issuer certificates,
+// connecting as the framework does, so blocklists apply.
+var revocationTransport = &http.Transport{
+	DialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+		conn, _, err := dialHappyEyeballs(ctx, &net.Dialer{}, network, address)
+		return conn, err
+	},
+	MaxIdleConnsPerHost: 4,
+}
+
+// revocationChecker checks certificates for --check-revocation.
+type revocationChecker struct {
+	client *http.Client
+	cache  *revocationCache
+}
+
+// fetch makes the HTTP request, returning the body of a 200 response.
+func (c *revocationChecker) fetch(method, url, contentType string, body []byte) ([]byte, error) {
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		return nil, fmt.Errorf("unsupported URL %s", url)
+	}
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	request, err := http.NewRequest(method, url, reader)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		request.Header.Set("Content-Type", contentType)
+	}
+	response, err := c.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("server replied %s", response.Status)
+	}
+	ret, err := ioutil.ReadAll(io.LimitReader(response.Body, revocationMaxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(ret) > revocationMaxSize {
+		return nil, fmt.Errorf("response larger than %d bytes", revocationMaxSize)
+	}
+	return ret, nil
+}
+
+// chainIssuer returns the certificate in the chain that issued the
+// certificate, or nil.
+func chainIssuer(cert *x509.Certificate, chain [][]byte) *x509.Certificate {
+	for _, raw := range chain {
+		candidate, err := x509.ParseCertificate(raw)
+		if err == nil && bytes.Equal(candidate.RawSubject, cert.RawIssuer) && cert.CheckSignatureFrom(candidate) == nil {
+			return candidate
+		}
+	}
+	return nil
+}
+
+// issuer returns the certificate of the certificate's issuer, from the
+// chain or its CA Issuers URLs, and where it was found.
+func (c *revocationChecker) issuer(cert *x509.Certificate, chain [][]byte) (*x509.Certificate, string, error) {
+	if issuer := chainIssuer(cert, chain); issuer != nil {
+		return issuer, "chain", nil
+	}
+	err := errors.New("issuer certificate not sent, and no CA Issuers URL")
+	for _, url := range cert.IssuingCertificateURL {
+		var value interface{}
+		value, _, _, err = c.cache.get("issuer "+url, func() (interface{}, time.Time, error) {
+			der, err := c.fetch("GET", url, "", nil)
+			if err != nil {
+				return nil, time.Time{}, err
+			}
+			if block, _ := pem.Decode(der); block != nil {
+				der = block.Bytes
+			}
+			issuer, err := x509.ParseCertificate(der)
+			return issuer, time.Time{}, err
+		})
+		if err != nil {
+			continue
+		}
+		issuer := value.(*x509.Certificate)
+		if err = cert.CheckSignatureFrom(issuer); err == nil {
+			return issuer, "aia", nil
+		}
+	}
+	return nil, "", fmt.Errorf("could not get issuer certificate: %v", err)
+}
+
+// ocsp asks the OCSP responder about the certificate.
+func (c *revocationChecker) ocsp(url string, cert, issuer *x509.Certificate) *RevocationCheck {
+	id, err := ocspCertIDFor(cert, issuer)
+	if err != nil {
+		return &RevocationCheck{URL: url, Error: err.Error()}
+	}
+	key := fmt.Sprintf("ocsp %s %x %s", url, id.KeyHash, id.SerialNumber)
+	value, latency, cached, err := c.cache.get(key, func() (interface{}, time.Time, error) {
+		var request ocspRequest
+		request.TBSRequest.RequestList = append(request.TBSRequest.RequestList, struct{ CertID ocspCertID }{*id})
+		der, err := asn1.Marshal(request)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		if der, err = c.fetch("POST", url, "application/ocsp-request", der); err != nil {
+			return nil, time.Time{}, err
+		}
+		check, err := parseOCSPResponse(der, id, issuer)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		var expires time.Time
+		if check.NextUpdate != nil {
+			expires = *check.NextUpdate
+		}
+		return check, expires, nil
+	})
+	ret := &RevocationCheck{}
+	if err != nil {
+		ret.Error = err.Error()
+	} else {
+		*ret = *value.(*RevocationCheck)
+	}
+	ret.URL, ret.Latency, ret.Cached = url, microseconds(latency), cached
+	return ret
+}
+
+// crl looks the certificate up in the CRL.
+func (c *revocationChecker) crl(url string, cert, issuer *x509.Certificate) *RevocationCheck {
+	key := "crl " + url
+	if issuer != nil {
+		key += fmt.Sprintf(" %x", sha1.Sum(issuer.RawSubjectPublicKeyInfo))
+	}
+	value, latency, cached, err := c.cache.get(key, func() (interface{}, time.Time, error) {
+		der, err := c.fetch("GET", url, "", nil)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		entries, err := parseCRL(der, issuer)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		return entries, entries.nextUpdate, nil
+	})
+	ret := &RevocationCheck{}
+	if err == nil {
+		ret = value.(*crlEntries).check(cert)
+	} else {
+		ret.Error = err.Error()
+	}
+	ret.URL, ret.Latency, ret.Cached = url, microseconds(latency), cached
+	return ret
+}
+
+// check checks whether the certificate is revoked, with each of its OCSP
+// responders and CRL distribution points. chain is the rest of the
+// certificates the server sent.
+func (c *revocationChecker) check(cert *x509.Certificate, chain [][]byte) *TLSRevocation {
+	ret := &TLSRevocation{Status: revocationUnknown}
+	if len(cert.OCSPServer) == 0 && len(cert.CRLDistributionPoints) == 0 {
+		ret.Error = "certificate names no OCSP responder or CRL distribution point"
+		return ret
+	}
+	issuer, source, err := c.issuer(cert, chain)
+	if err != nil {
+		ret.Error = err.Error()
+	}
+	ret.IssuerSource = source
+	for _, url := range cert.OCSPServer {
+		if issuer == nil {
+			ret.OCSP = append(ret.OCSP, &RevocationCheck{URL: url, Error: "issuer certificate needed"})
+			continue
+		}
+		ret.OCSP = append(ret.OCSP, c.ocsp(url, cert, issuer))
+	}
+	for _, url := range cert.CRLDistributionPoints {
+		ret.CRL = append(ret.CRL, c.crl(url, cert, issuer))
+	}
+	for _, check := range append(ret.OCSP, ret.CRL...) {
+		switch {
+		case check.Status == revocationRevoked:
+			ret.Status = revocationRevoked
+		case check.Status == revocationGood && ret.Status == revocationUnknown:
+			ret.Status = revocationGood
+		}
+	}
+	return ret
+}
+
+// checkRevocation runs --check-revocation on the certificate the server
+// sent.
+func (z *TLSConnection) checkRevocation() *TLSRevocation {
+	certificates := z.GetLog().HandshakeLog.ServerCertificates
+	cert, err := x509.ParseCertificate(certificates.Certificate.Raw)
+	if err != nil {
+		return &TLSRevocation{Status: revocationUnknown, Error: fmt.Sprintf("could not parse certificate: %v", err)}
+	}
+	var chain [][]byte
+	for _, c := range certificates.Chain {
+		chain = append(chain, c.Raw)
+	}
+	c := &revocationChecker{
+		client: &http.Client{Transport: revocationTransport, Timeout: z.flags.RevocationTimeout},
+		cache:  revocations,
+	}
+	return c.check(cert, chain)
+}
@@ -0,0 +1,213 @@
+package zgrab2
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// revocationCA is a CA with an OCSP responder, CRL and CA Issuers URL,
+// served over HTTP.
+type revocationCA struct {
+	key     *ecdsa.PrivateKey
+	cert    *x509.Certificate
+	revoked *big.Int
+	server  *httptest.Server
+	fetches int32
+}
+
+func newRevocationCA(t *testing.T) *revocationCA {
+	ca := &revocationCA{revoked: big.NewInt(3)}
+	var err error
+	if ca.key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}
+	raw, err := x509.CreateCertificate(rand.Reader, template, template, &ca.key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ca.cert, err = x509.ParseCertificate(raw); err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ca.crt", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&ca.fetches, 1)
+		w.Write(ca.cert.Raw)
+	})
+	mux.HandleFunc("/crl", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&ca.fetches, 1)
+		reason, _ := asn1.Marshal(asn1.Enumerated(1))
+		crl, err := ca.cert.CreateCRL(rand.Reader, ca.key, []pkix.RevokedCertificate{{
+			SerialNumber:   ca.revoked,
+			RevocationTime: time.Now().Add(-time.Minute).UTC(),
+			Extensions:     []pkix.Extension{{Id: oidCRLReason, Value: reason}},
+		}}, time.Now(), time.Now().Add(time.Hour))
+		if err != nil {
+			t.Error(err)
+		}
+		w.Write(crl)
+	})
+	mux.HandleFunc("/ocsp", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&ca.fetches, 1)
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Write(ca.ocspResponse(t, body))
+	})
+	ca.server = httptest.NewServer(mux)
+	return ca
+}
+
+// ocspResponse answers the OCSP request.
+func (ca *revocationCA) ocspResponse(t *testing.T, der []byte) []byte {
+	var request ocspRequest
+	if _, err := asn1.Unmarshal(der, &request); err != nil || len(request.TBSRequest.RequestList) != 1 {
+		t.Errorf("invalid OCSP request: %v", err)
+		return []byte{0x30, 3, 0x0a, 1, 1}
+	}
+	id := request.TBSRequest.RequestList[0].CertID
+	status := asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0}
+	if id.SerialNumber.Cmp(ca.revoked) == 0 {
+		info, _ := asn1.Marshal(ocspRevokedInfo{RevocationTime: time.Now().Add(-time.Minute).UTC(), Reason: 1})
+		var sequence asn1.RawValue
+		asn1.Unmarshal(info, &sequence)
+		status = asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 1, IsCompound: true, Bytes: sequence.Bytes}
+	}
+	keyHash, _ := asn1.Marshal(id.KeyHash)
+	data := ocspResponseData{
+		ResponderID: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 2, IsCompound: true, Bytes: keyHash},
+		ProducedAt:  time.Now().UTC().Truncate(time.Second),
+		Responses: []ocspSingleResponse{{
+			CertID:     id,
+			CertStatus: status,
+			ThisUpdate: time.Now().UTC().Truncate(time.Second),
+			NextUpdate: time.Now().Add(time.Hour).UTC().Truncate(time.Second),
+		}},
+	}
+	tbs, err := asn1.Marshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256(tbs)
+	r, s, err := ecdsa.Sign(rand.Reader, ca.key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature, _ := asn1.Marshal(struct{ R, S *big.Int }{r, s})
+	data.Raw = tbs
+	basic, err := asn1.Marshal(ocspBasicResponse{
+		TBSResponseData:    data,
+		SignatureAlgorithm: pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}},
+		Signature:          asn1.BitString{Bytes: signature, BitLength: 8 * len(signature)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var response ocspResponse
+	response.ResponseBytes.ResponseType = oidOCSPBasic
+	response.ResponseBytes.Response = basic
+	ret, _ := asn1.Marshal(response)
+	return ret
+}
+
+// issue returns a certificate with the serial number, naming the CA's
+// responder, CRL and CA Issuers URL.
+func (ca *revocationCA) issue(t *testing.T, serial int64) *x509.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: "example.com"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		OCSPServer:            []string{ca.server.URL + "/ocsp"},
+		CRLDistributionPoints: []string{ca.server.URL + "/crl"},
+		IssuingCertificateURL: []string{ca.server.URL + "/ca.crt"},
+	}
+	raw, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestRevocation(t *testing.T) {
+	ca := newRevocationCA(t)
+	defer ca.server.Close()
+	c := &revocationChecker{
+		client: &http.Client{Timeout: time.Second},
+		cache:  &revocationCache{ttl: time.Hour, errorTTL: time.Hour, maxEntries: 16, entries: make(map[string]*revocationCacheEntry)},
+	}
+
+	good := c.check(ca.issue(t, 2), [][]byte{ca.cert.Raw})
+	if good.Status != revocationGood || good.IssuerSource != "chain" || good.Error != "" {
+		t.Errorf("wrong result %+v", good)
+	}
+	for _, check := range append(good.OCSP, good.CRL...) {
+		if check.Status != revocationGood || !check.Verified || check.Cached || check.Error != "" || check.NextUpdate == nil {
+			t.Errorf("wrong check %+v", check)
+		}
+	}
+	if fetches := atomic.LoadInt32(&ca.fetches); fetches != 2 {
+		t.Errorf("%d fetches, expected 2", fetches)
+	}
+
+	// The issuer is fetched, and the CRL is cached.
+	revoked := c.check(ca.issue(t, 3), nil)
+	if revoked.Status != revocationRevoked || revoked.IssuerSource != "aia" || len(revoked.OCSP) != 1 || len(revoked.CRL) != 1 {
+		t.Fatalf("wrong result %+v", revoked)
+	}
+	for _, check := range append(revoked.OCSP, revoked.CRL...) {
+		if check.Status != revocationRevoked || check.RevocationReason != "key_compromise" || check.RevokedAt == nil || !check.Verified {
+			t.Errorf("wrong check %+v", check)
+		}
+	}
+	if revoked.OCSP[0].Cached || !revoked.CRL[0].Cached {
+		t.Error("CRL not cached")
+	}
+	if fetches := atomic.LoadInt32(&ca.fetches); fetches != 4 {
+		t.Errorf("%d fetches, expected 4", fetches)
+	}
+
+	// Answers signed by another CA are not verified.
+	other := newRevocationCA(t)
+	defer other.server.Close()
+	forged := other.issue(t, 2)
+	forged.OCSPServer = []string{ca.server.URL + "/ocsp"}
+	forged.CRLDistributionPoints = []string{ca.server.URL + "/crl"}
+	result := c.check(forged, [][]byte{other.cert.Raw})
+	for _, check := range append(result.OCSP, result.CRL...) {
+		if check.Verified {
+			t.Errorf("forged answer verified: %+v", check)
+		}
+	}
+
+	none := c.check(&x509.Certificate{SerialNumber: big.NewInt(4)}, nil)
+	if none.Status != revocationUnknown || none.Error == "" {
+		t.Errorf("wrong result %+v", none)
+	}
+}
@@ -0,0 +1,409 @@
+package zgrab2
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"sync"
+	"time"
+)
+
+// SCT is a Signed Certificate Timestamp (RFC 6962) for the server's
+// certificate, with --check-scts.
+type SCT struct {
+	// Source is where the SCT was found: tls_extension, ocsp_staple or
+	// certificate.
+	Source string `json:"source"`
+
+	Version int    `json:"version"`
+	LogID   []byte `json:"log_id,omitempty"`
+
+	// LogDescription, LogOperator and LogState describe the log, if it is
+	// in the --ct-log-list.
+	LogDescription string `json:"log_description,omitempty"`
+	LogOperator    string `json:"log_operator,omitempty"`
+	LogState       string `json:"log_state,omitempty"`
+
+	Timestamp          *time.Time `json:"timestamp,omitempty"`
+	Extensions         []byte     `json:"extensions,omitempty"`
+	SignatureAlgorithm string     `json:"signature_algorithm,omitempty"`
+
+	// Valid is true if the SCT is signed by its log, and its timestamp is
+	// not in the future.
+	Valid bool `json:"valid"`
+
+	// Error is the reason the SCT is not valid, or could not be parsed.
+	Error string `json:"error,omitempty"`
+}
+
+// Where SCTs are found.
+const (
+	sctSourceTLSExtension = "tls_extension"
+	sctSourceOCSPStaple   = "ocsp_staple"
+	sctSourceCertificate  = "certificate"
+)
+
+const (
+	tlsExtensionSCT      = 0x0012
+	tlsCertificateStatus = 22
+
+	ctX509Entry    = 0
+	ctPrecertEntry = 1
+)
+
+var (
+	oidEmbeddedSCTList = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 2}
+	oidOCSPSCTList     = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 5}
+)
+
+// ctLog is a Certificate Transparency log from a --ct-log-list.
+type ctLog struct {
+	description, operator, state string
+	key                          crypto.PublicKey
+}
+
+// ctLogList maps log IDs (the SHA-256 digest of their keys) to logs.
+type ctLogList map[[sha256.Size]byte]*ctLog
+
+// ctLogListJSON is the format of the log lists published by Chrome and
+// Apple (log_list.json, version 3).
+type ctLogListJSON struct {
+	Operators []struct {
+		Name  string      `json:"name"`
+		Logs  []ctLogJSON `json:"logs"`
+		Tiled []ctLogJSON `json:"tiled_logs"`
+	} `json:"operators"`
+}
+
+type ctLogJSON struct {
+	Description string                     `json:"description"`
+	Key         string                     `json:"key"`
+	State       map[string]json.RawMessage `json:"state"`
+}
+
+// parseCTLogList parses a log list in the format of ctLogListJSON.
+func parseCTLogList(data []byte) (ctLogList, error) {
+	var list ctLogListJSON
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, err
+	}
+	ret := make(ctLogList)
+	for _, operator := range list.Operators {
+		for _, log := range append(operator.Logs, operator.Tiled...) {
+			der, err := base64.StdEncoding.DecodeString(log.Key)
+			if err != nil {
+				return nil, fmt.Errorf("invalid key for log %q: %v", log.Description, err)
+			}
+			key, err := x509.ParsePKIXPublicKey(der)
+			if err != nil {
+				return nil, fmt.Errorf("invalid key for log %q: %v", log.Description, err)
+			}
+			entry := &ctLog{description: log.Description, operator: operator.Name, key: key}
+			// The state is an object with one member, named for it.
+			for state := range log.State {
+				entry.state = state
+			}
+			ret[sha256.Sum256(der)] = entry
+		}
+	}
+	return ret, nil
+}
+
+var ctLogLists = struct {
+	sync.Mutex
+	lists map[string]ctLogList
+}{lists: make(map[string]ctLogList)}
+
+// loadCTLogList returns the log list in the file, reading it once.
+func loadCTLogList(path string) (ctLogList, error) {
+	ctLogLists.Lock()
+	defer ctLogLists.Unlock()
+	if list, ok := ctLogLists.lists[path]; ok {
+		return list, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	list, err := parseCTLogList(data)
+	if err != nil {
+		return nil, err
+	}
+	ctLogLists.lists[path] = list
+	return list, nil
+}
+
+// sctChecker parses and verifies the SCTs for a certificate.
+type sctChecker struct {
+	logs ctLogList
+	cert *x509.Certificate
+	// issuer is the certificate's issuer, needed to verify embedded SCTs,
+	// or nil.
+	issuer *x509.Certificate
+	now    time.Time
+}
+
+// signatureAlgorithmName names a TLS SignatureAndHashAlgorithm.
+func signatureAlgorithmName(hash, signature int) string {
+	hashes := map[int]string{1: "md5", 2: "sha1", 3: "sha224", 4: "sha256", 5: "sha384", 6: "sha512"}
+	signatures := map[int]string{1: "rsa", 2: "dsa", 3: "ecdsa"}
+	h, ok := hashes[hash]
+	if !ok {
+		h = fmt.Sprintf("hash%d", hash)
+	}
+	s, ok := signatures[signature]
+	if !ok {
+		s = fmt.Sprintf("signature%d", signature)
+	}
+	return s + "_" + h
+}
+
+// entry returns the signed part of an SCT's log entry for the certificate:
+// the certificate itself for SCTs delivered with it, or, for embedded SCTs,
+// the precertificate the log saw.
+func (c *sctChecker) entry(source string) ([]byte, error) {
+	if source != sctSourceCertificate {
+		return append(TLSUint16s(ctX509Entry), TLSVector(3, c.cert.Raw)...), nil
+	}
+	if c.issuer == nil {
+		return nil, errors.New("issuer certificate needed")
+	}
+	tbs, err := precertTBS(c.cert.RawTBSCertificate)
+	if err != nil {
+		return nil, err
+	}
+	issuerKeyHash := sha256.Sum256(c.issuer.RawSubjectPublicKeyInfo)
+	return bytes.Join([][]byte{TLSUint16s(ctPrecertEntry), issuerKeyHash[:], TLSVector(3, tbs)}, nil), nil
+}
+
+// parseList parses and verifies a SignedCertificateTimestampList.
+func (c *sctChecker) parseList(source string, list []byte) []*SCT {
+	var ret []*SCT
+	r := (&helloReader{data: list, ok: true}).vector(2)
+	for r.ok && len(r.data) > 0 {
+		sct := r.vector(2)
+		if !sct.ok {
+			break
+		}
+		ret = append(ret, c.parse(source, sct.data))
+	}
+	if !r.ok || len(ret) == 0 {
+		ret = append(ret, &SCT{Source: source, Error: "invalid SCT list"})
+	}
+	return ret
+}
+
+// parse parses and verifies a serialized SCT.
+func (c *sctChecker) parse(source string, data []byte) *SCT {
+	ret := &SCT{Source: source}
+	r := &helloReader{data: data, ok: true}
+	ret.Version = r.uint8()
+	if ret.Version != 0 {
+		ret.Error = fmt.Sprintf("unsupported SCT version %d", ret.Version)
+		return ret
+	}
+	ret.LogID = r.bytes(sha256.Size)
+	timestamp := r.bytes(8)
+	ret.Extensions = r.vector(2).data
+	hash, signature := r.uint8(), r.uint8()
+	sig := r.vector(2).data
+	if !r.ok || len(r.data) != 0 {
+		ret.Error = "invalid SCT"
+		return ret
+	}
+	ms := binary.BigEndian.Uint64(timestamp)
+	t := time.Unix(int64(ms/1000), int64(ms%1000)*int64(time.Millisecond)).UTC()
+	ret.Timestamp = &t
+	ret.SignatureAlgorithm = signatureAlgorithmName(hash, signature)
+
+	var id [sha256.Size]byte
+	copy(id[:], ret.LogID)
+	log, ok := c.logs[id]
+	if !ok {
+		ret.Error = "unknown log"
+		return ret
+	}
+	ret.LogDescription, ret.LogOperator, ret.LogState = log.description, log.operator, log.state
+	entry, err := c.entry(source)
+	if err != nil {
+		ret.Error = err.Error()
+		return ret
+	}
+	signed := bytes.Join([][]byte{{0, 0}, timestamp, entry, TLSVector(2, ret.Extensions)}, nil)
+	if err := verifySCTSignature(log.key, hash, signed, sig); err != nil {
+		ret.Error = err.Error()
+		return ret
+	}
+	if t.After(c.now) {
+		ret.Error = "timestamp in the future"
+		return ret
+	}
+	ret.Valid = true
+	return ret
+}
+
+// verifySCTSignature checks a digitally-signed struct's signature. Logs
+// sign with ECDSA or RSA, and SHA-256.
+func verifySCTSignature(key crypto.PublicKey, hash int, signed, signature []byte) error {
+	if hash != 4 {
+		return errors.New("unsupported signature algorithm")
+	}
+	digest := sha256.Sum256(signed)
+	switch key := key.(type) {
+	case *ecdsa.PublicKey:
+		var sig struct{ R, S *big.Int }
+		if _, err := asn1.Unmarshal(signature, &sig); err != nil || !ecdsa.Verify(key, digest[:], sig.R, sig.S) {
+			return errors.New("invalid signature")
+		}
+	case *rsa.PublicKey:
+		if rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) != nil {
+			return errors.New("invalid signature")
+		}
+	default:
+		return errors.New("unsupported log key")
+	}
+	return nil
+}
+
+// precertTBS returns the TBSCertificate without its SCT list extension, as
+// it was in the precertificate the log signed.
+func precertTBS(tbs []byte) ([]byte, error) {
+	var certificate asn1.RawValue
+	if rest, err := asn1.Unmarshal(tbs, &certificate); err != nil || len(rest) != 0 {
+		return nil, errors.New("invalid TBSCertificate")
+	}
+	var fields []byte
+	for data := certificate.Bytes; len(data) > 0; {
+		var field asn1.RawValue
+		var err error
+		if data, err = asn1.Unmarshal(data, &field); err != nil {
+			return nil, errors.New("invalid TBSCertificate")
+		}
+		if field.Class != asn1.ClassContextSpecific || field.Tag != 3 {
+			fields = append(fields, field.FullBytes...)
+			continue
+		}
+		// [3] EXPLICIT Extensions
+		var extensions asn1.RawValue
+		if _, err := asn1.Unmarshal(field.Bytes, &extensions); err != nil {
+			return nil, errors.New("invalid extensions")
+		}
+		var kept []byte
+		for rest := extensions.Bytes; len(rest) > 0; {
+			var extension asn1.RawValue
+			if rest, err = asn1.Unmarshal(rest, &extension); err != nil {
+				return nil, errors.New("invalid extensions")
+			}
+			var parsed pkix.Extension
+			if _, err := asn1.Unmarshal(extension.FullBytes, &parsed); err != nil {
+				return nil, errors.New("invalid extensions")
+			}
+			if !parsed.Id.Equal(oidEmbeddedSCTList) {
+				kept = append(kept, extension.FullBytes...)
+			}
+		}
+		inner, err := asn1.Marshal(asn1.RawValue{Tag: asn1.TagSequence, IsCompound: true, Bytes: kept})
+		if err != nil {
+			return nil, err
+		}
+		outer, err := asn1.Marshal(asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 3, IsCompound: true, Bytes: inner})
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, outer...)
+	}
+	return asn1.Marshal(asn1.RawValue{Tag: asn1.TagSequence, IsCompound: true, Bytes: fields})
+}
+
+// stapledSCTList returns the SCT list in the OCSP response's answer about
+// the certificate, or nil.
+func stapledSCTList(der []byte, cert *x509.Certificate) []byte {
+	var response ocspResponse
+	var basic ocspBasicResponse
+	if _, err := asn1.Unmarshal(der, &response); err != nil || response.Status != 0 {
+		return nil
+	}
+	if _, err := asn1.Unmarshal(response.ResponseBytes.Response, &basic); err != nil {
+		return nil
+	}
+	for _, single := range basic.TBSResponseData.Responses {
+		if single.CertID.SerialNumber == nil || single.CertID.SerialNumber.Cmp(cert.SerialNumber) != 0 {
+			continue
+		}
+		for _, extension := range single.SingleExtensions {
+			var list []byte
+			if extension.Id.Equal(oidOCSPSCTList) {
+				if _, err := asn1.Unmarshal(extension.Value, &list); err == nil {
+					return list
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// check returns the SCTs in the ServerHello's extension, the stapled OCSP
+// response in the handshake messages received, and the certificate.
+func (c *sctChecker) check(received [][]byte) []*SCT {
+	var ret []*SCT
+	if msg := firstHello(received, tlsServerHello); msg != nil {
+		r := &helloReader{data: msg[4:], ok: true}
+		r.bytes(34)
+		r.vector(1)
+		r.bytes(3)
+		if list := extensionBody(r.vector(2), tlsExtensionSCT); list != nil {
+			ret = append(ret, c.parseList(sctSourceTLSExtension, list)...)
+		}
+	}
+	for _, msg := range received {
+		// A CertificateStatus holds the status type (1, OCSP) and the
+		// response, with a 24-bit length.
+		if msg[0] == tlsCertificateStatus && len(msg) > 8 && msg[4] == 1 {
+			if list := stapledSCTList(msg[8:], c.cert); list != nil {
+				ret = append(ret, c.parseList(sctSourceOCSPStaple, list)...)
+			}
+		}
+	}
+	for _, extension := range c.cert.Extensions {
+		var list []byte
+		if extension.Id.Equal(oidEmbeddedSCTList) {
+			if _, err := asn1.Unmarshal(extension.Value, &list); err != nil {
+				ret = append(ret, &SCT{Source: sctSourceCertificate, Error: "invalid SCT list"})
+				continue
+			}
+			ret = append(ret, c.parseList(sctSourceCertificate, list)...)
+		}
+	}
+	return ret
+}
+
+// checkSCTs runs --check-scts on the handshake.
+func (z *TLSConnection) checkSCTs() []*SCT {
+	certificates := z.GetLog().HandshakeLog.ServerCertificates
+	cert, err := x509.ParseCertificate(certificates.Certificate.Raw)
+	if err != nil {
+		return []*SCT{{Error: fmt.Sprintf("could not parse certificate: %v", err)}}
+	}
+	var chain [][]byte
+	for _, c := range certificates.Chain {
+		chain = append(chain, c.Raw)
+	}
+	c := &sctChecker{cert: cert, issuer: chainIssuer(cert, chain), now: time.Now()}
+	if z.flags.CTLogList != "" {
+		// The list was loaded when the flags were checked.
+		c.logs, _ = loadCTLogList(z.flags.CTLogList)
+	}
+	return c.check(z.received)
+}
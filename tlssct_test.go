@@ -0,0 +1,160 @@
+package zgrab2
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"math/big"
+	"testing"
+	"time"
+)
+
+// testSCT returns an SCT for the log entry, signed by the log's key.
+func testSCT(t *testing.T, log *ecdsa.PrivateKey, timestamp time.Time, entry []byte) []byte {
+	ms := make([]byte, 8)
+	binary.BigEndian.PutUint64(ms, uint64(timestamp.UnixNano()/int64(time.Millisecond)))
+	digest := sha256.Sum256(bytes.Join([][]byte{{0, 0}, ms, entry, TLSVector(2)}, nil))
+	r, s, err := ecdsa.Sign(rand.Reader, log, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature, _ := asn1.Marshal(struct{ R, S *big.Int }{r, s})
+	spki, err := x509.MarshalPKIXPublicKey(&log.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := sha256.Sum256(spki)
+	return TLSVector(2, []byte{0}, id[:], ms, TLSVector(2), []byte{4, 3}, TLSVector(2, signature))
+}
+
+func testKey(t *testing.T) *ecdsa.PrivateKey {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func testCertificate(t *testing.T, template, parent *x509.Certificate, key, parentKey *ecdsa.PrivateKey) *x509.Certificate {
+	raw, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestSCTs(t *testing.T) {
+	logKey, otherLogKey := testKey(t), testKey(t)
+	spki, _ := x509.MarshalPKIXPublicKey(&logKey.PublicKey)
+	logs, err := parseCTLogList([]byte(`{"operators": [{"name": "Test", "logs": [{"description": "Test log", "key": "` + base64.StdEncoding.EncodeToString(spki) + `", "state": {"usable": {"timestamp": "2025-01-01T00:00:00Z"}}}]}]}`))
+	if err != nil || len(logs) != 1 {
+		t.Fatalf("could not parse log list: %v", err)
+	}
+
+	caKey, key := testKey(t), testKey(t)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	ca := testCertificate(t, caTemplate, caTemplate, caKey, caKey)
+
+	// The log signs the precertificate, whose TBSCertificate is the
+	// certificate's without the SCT list.
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"example.com"},
+	}
+	precert := testCertificate(t, template, ca, key, caKey)
+	issuerKeyHash := sha256.Sum256(ca.RawSubjectPublicKeyInfo)
+	embedded := testSCT(t, logKey, time.Now(), bytes.Join([][]byte{TLSUint16s(ctPrecertEntry), issuerKeyHash[:], TLSVector(3, precert.RawTBSCertificate)}, nil))
+	list, _ := asn1.Marshal(TLSVector(2, embedded))
+	template.ExtraExtensions = []pkix.Extension{{Id: oidEmbeddedSCTList, Value: list}}
+	cert := testCertificate(t, template, ca, key, caKey)
+	if tbs, err := precertTBS(cert.RawTBSCertificate); err != nil || !bytes.Equal(tbs, precert.RawTBSCertificate) {
+		t.Fatalf("precertificate TBSCertificate not recovered: %v", err)
+	}
+
+	entry := append(TLSUint16s(ctX509Entry), TLSVector(3, cert.Raw)...)
+	serverHello := tlsHandshake(tlsServerHello,
+		TLSUint16s(0x0303),
+		make([]byte, 32),
+		TLSVector(1),
+		TLSUint16s(0xc02f),
+		[]byte{0},
+		TLSVector(2, TLSExtension(tlsExtensionSCT, TLSVector(2,
+			testSCT(t, logKey, time.Now(), entry),
+			testSCT(t, logKey, time.Now().Add(time.Hour), entry),
+		))),
+	)
+	stapled, _ := asn1.Marshal(TLSVector(2, testSCT(t, otherLogKey, time.Now(), entry)))
+	data, _ := asn1.Marshal(ocspResponseData{
+		ResponderID: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 1, IsCompound: true, Bytes: ca.RawSubject},
+		ProducedAt:  time.Now().UTC().Truncate(time.Second),
+		Responses: []ocspSingleResponse{{
+			CertID:           ocspCertID{HashAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidSHA1}, SerialNumber: cert.SerialNumber},
+			CertStatus:       asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0},
+			ThisUpdate:       time.Now().UTC().Truncate(time.Second),
+			SingleExtensions: []pkix.Extension{{Id: oidOCSPSCTList, Value: stapled}},
+		}},
+	})
+	var basic ocspBasicResponse
+	basic.TBSResponseData.Raw = data
+	basic.SignatureAlgorithm.Algorithm = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+	var response ocspResponse
+	response.ResponseBytes.ResponseType = oidOCSPBasic
+	if response.ResponseBytes.Response, err = asn1.Marshal(basic); err != nil {
+		t.Fatal(err)
+	}
+	der, _ := asn1.Marshal(response)
+	certificateStatus := tlsHandshake(tlsCertificateStatus, []byte{1}, TLSVector(3, der))
+
+	c := &sctChecker{logs: logs, cert: cert, issuer: ca, now: time.Now()}
+	scts := c.check([][]byte{serverHello, certificateStatus})
+	expected := []struct {
+		source string
+		error  string
+	}{
+		{sctSourceTLSExtension, ""},
+		{sctSourceTLSExtension, "timestamp in the future"},
+		{sctSourceOCSPStaple, "unknown log"},
+		{sctSourceCertificate, ""},
+	}
+	if len(scts) != len(expected) {
+		t.Fatalf("got %d SCTs, expected %d", len(scts), len(expected))
+	}
+	for i, sct := range scts {
+		if sct.Source != expected[i].source || sct.Error != expected[i].error || sct.Valid != (expected[i].error == "") {
+			t.Errorf("SCT %d: got %+v, expected %+v", i, sct, expected[i])
+		}
+	}
+	if sct := scts[0]; sct.LogDescription != "Test log" || sct.LogOperator != "Test" || sct.LogState != "usable" || sct.SignatureAlgorithm != "ecdsa_sha256" {
+		t.Errorf("wrong log details %+v", sct)
+	}
+
+	c.issuer = nil
+	if scts := c.check(nil); len(scts) != 1 || scts[0].Error != "issuer certificate needed" {
+		t.Errorf("embedded SCT without issuer: %+v", scts)
+	}
+	c.cert = precert
+	if scts := c.check([][]byte{tlsHandshake(tlsCertificateStatus, []byte{1}, TLSVector(3, der))}); len(scts) != 1 || scts[0].Valid {
+		t.Errorf("SCT for another certificate: %+v", scts)
+	}
+}
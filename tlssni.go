@@ -0,0 +1,135 @@
+package zgrab2
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/zmap/zcrypto/tls"
+)
+
+// TLSServerName is the result of a handshake sending one of the
+// --tls-server-names, with --tls-server-names or --tls-server-names-file.
+type TLSServerName struct {
+	// ServerName is the name sent in the SNI extension.
+	ServerName string `json:"server_name"`
+
+	// ServerCertificates are the certificates the server sent.
+	ServerCertificates *tls.Certificates `json:"server_certificates,omitempty"`
+
+	// SameCertificate is true if the server's certificate is the one it
+	// sent in the scan's own handshake.
+	SameCertificate bool `json:"same_certificate"`
+
+	// Error is the reason the handshake failed, if it did.
+	Error string `json:"error,omitempty"`
+}
+
+var serverNameFiles = struct {
+	sync.Mutex
+	names map[string][]string
+}{names: make(map[string][]string)}
+
+// loadServerNames returns the names in the file, one per line, ignoring
+// blank lines and comments starting with #, reading it once.
+func loadServerNames(path string) ([]string, error) {
+	serverNameFiles.Lock()
+	defer serverNameFiles.Unlock()
+	if names, ok := serverNameFiles.names[path]; ok {
+		return names, nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var names []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		names = append(names, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	serverNameFiles.names[path] = names
+	return names, nil
+}
+
+// getServerNames returns the --tls-server-names followed by the names in
+// --tls-server-names-file, without duplicates.
+func (t *TLSFlags) getServerNames() ([]string, error) {
+	var names []string
+	if t.ServerNames != "" {
+		names = append(names, getCSV(t.ServerNames)...)
+	}
+	if t.ServerNamesFile != "" {
+		fromFile, err := loadServerNames(t.ServerNamesFile)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, fromFile...)
+	}
+	var ret []string
+	seen := make(map[string]bool)
+	for _, name := range names {
+		key := strings.ToLower(name)
+		if name == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		ret = append(ret, name)
+	}
+	return ret, nil
+}
+
+// probeServerNames connects to the target again for each of the
+// connection's server names, and performs a handshake sending it in the SNI
+// extension, with the same configuration otherwise.
+func (z *TLSConnection) probeServerNames() []*TLSServerName {
+	var leaf []byte
+	if log := z.GetLog().HandshakeLog; log != nil && log.ServerCertificates != nil {
+		leaf = log.ServerCertificates.Certificate.Raw
+	}
+	ret := make([]*TLSServerName, 0, len(z.serverNames))
+	for _, name := range z.serverNames {
+		result := z.handshakeWithServerName(name)
+		if certificates := result.ServerCertificates; certificates != nil && len(leaf) > 0 {
+			result.SameCertificate = bytes.Equal(certificates.Certificate.Raw, leaf)
+		}
+		ret = append(ret, result)
+	}
+	return ret
+}
+
+// handshakeWithServerName performs a handshake sending name in the SNI
+// extension over a new connection.
+func (z *TLSConnection) handshakeWithServerName(name string) *TLSServerName {
+	ret := &TLSServerName{ServerName: name}
+	flags := *z.flags
+	flags.ServerName = name
+	config, err := flags.GetTLSConfig()
+	if err != nil {
+		ret.Error = err.Error()
+		return ret
+	}
+	conn, err := z.redial()
+	if err != nil {
+		ret.Error = err.Error()
+		return ret
+	}
+	defer conn.Close()
+	client := tls.Client(conn, config)
+	if err := client.Handshake(); err != nil {
+		ret.Error = err.Error()
+	}
+	if log := client.GetHandshakeLog(); log != nil {
+		ret.ServerCertificates = log.ServerCertificates
+	}
+	return ret
+}
@@ -0,0 +1,33 @@
+package zgrab2
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestGetServerNames(t *testing.T) {
+	file, err := ioutil.TempFile("", "zgrab2-server-names")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString("# CDN names\nstatic.example.com\n\n  WWW.example.com \ncdn.example.net\n")
+	file.Close()
+
+	flags := &TLSFlags{ServerNames: "www.example.com, mail.example.com", ServerNamesFile: file.Name()}
+	names, err := flags.getServerNames()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"www.example.com", "mail.example.com", "static.example.com", "cdn.example.net"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("got %v, expected %v", names, expected)
+	}
+
+	flags.ServerNamesFile = file.Name() + ".missing"
+	if _, err := flags.getServerNames(); err == nil {
+		t.Error("reading a missing file succeeded")
+	}
+}
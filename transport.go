@@ -0,0 +1,94 @@
+package zgrab2
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Transports that a MultiTransportScanner may support.
+const (
+	TransportTCP = "tcp"
+	TransportUDP = "udp"
+)
+
+// TransportFlags contains the options used by modules that can scan over
+// either TCP or UDP. Modules set the default for --transports when they
+// register their command, e.g.
+//
+//	cmd.FindOptionByLongName("transports").Default = []string{"udp,tcp"}
+type TransportFlags struct {
+	Transports string `long:"transports" description:"Comma-separated transports (tcp, udp) to try in order of preference, until one gets a response"`
+}
+
+// ParseTransports parses a comma-separated list of transports.
+func ParseTransports(s string) ([]string, error) {
+	var ret []string
+	for _, transport := range strings.Split(s, ",") {
+		transport = strings.ToLower(strings.TrimSpace(transport))
+		switch transport {
+		case "":
+		case TransportTCP, TransportUDP:
+			ret = append(ret, transport)
+		default:
+			return nil, fmt.Errorf("unknown transport %q", transport)
+		}
+	}
+	if len(ret) == 0 {
+		return nil, fmt.Errorf("no transports given")
+	}
+	return ret, nil
+}
+
+// MultiTransportScanner is implemented by scanners that can scan over more
+// than one transport. The framework calls Scan with the target's Transport
+// set to each of the transports returned by GetTransports in turn, until the
+// scan gets a response from the target; the transport used is recorded in the
+// scan response.
+type MultiTransportScanner interface {
+	Scanner
+
+	// GetTransports returns the transports to try, in order of preference.
+	GetTransports() []string
+}
+
+// OpenTransport connects to the ScanTarget over its Transport: UDP (as with
+// OpenUDP) if it is TransportUDP, and TCP (as with Open) otherwise.
+func (target *ScanTarget) OpenTransport(flags *BaseFlags, udp *UDPFlags) (net.Conn, error) {
+	if target.Transport == TransportUDP {
+		return target.OpenUDP(flags, udp)
+	}
+	return target.Open(flags)
+}
+
+// shouldTryNextTransport returns true if a scan with the given status did not
+// get a response from the target, so that another transport may do better.
+func shouldTryNextTransport(status ScanStatus) bool {
+	switch status {
+	case SCAN_CONNECTION_REFUSED, SCAN_CONNECTION_TIMEOUT, SCAN_CONNECTION_CLOSED, SCAN_IO_TIMEOUT:
+		return true
+	}
+	return false
+}
+
+// scanTransports runs the scan, trying each of a MultiTransportScanner's
+// transports in turn unless the target already specifies one, and returns
+// the transport that produced the result along with the result.
+func scanTransports(s Scanner, target ScanTarget) (transport string, status ScanStatus, res interface{}, err error) {
+	var transports []string
+	if multi, ok := s.(MultiTransportScanner); ok && target.Transport == "" {
+		transports = multi.GetTransports()
+	}
+	if len(transports) == 0 {
+		status, res, err = safeScan(s, target)
+		return target.Transport, status, res, err
+	}
+	for _, transport = range transports {
+		target.Transport = transport
+		status, res, err = safeScan(s, target)
+		if !shouldTryNextTransport(status) {
+			break
+		}
+	}
+	return transport, status, res, err
+}
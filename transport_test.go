@@ -0,0 +1,53 @@
+package zgrab2
+
+import (
+	"net"
+	"testing"
+)
+
+// transportScanner is an echoScanner that only gets a response over TCP.
+type transportScanner struct {
+	echoScanner
+	tried []string
+}
+
+func (s *transportScanner) GetTransports() []string {
+	return []string{TransportUDP, TransportTCP}
+}
+
+func (s *transportScanner) Scan(t ScanTarget) (ScanStatus, interface{}, error) {
+	s.tried = append(s.tried, t.Transport)
+	if t.Transport != TransportTCP {
+		return SCAN_IO_TIMEOUT, nil, ErrTargetTimeout
+	}
+	return SCAN_SUCCESS, t.Transport, nil
+}
+
+func TestScanTransports(t *testing.T) {
+	s := &transportScanner{echoScanner: echoScanner{name: "dns"}}
+	_, resp := RunScanner(s, nil, ScanTarget{IP: net.ParseIP("127.0.0.1")})
+	if resp.Status != SCAN_SUCCESS || resp.Transport != TransportTCP {
+		t.Errorf("expected success over tcp, got %s over %q", resp.Status, resp.Transport)
+	}
+	if len(s.tried) != 2 || s.tried[0] != TransportUDP {
+		t.Errorf("expected udp then tcp to be tried, got %v", s.tried)
+	}
+
+	s.tried = nil
+	_, resp = RunScanner(s, nil, ScanTarget{IP: net.ParseIP("127.0.0.1"), Transport: TransportUDP})
+	if resp.Status != SCAN_IO_TIMEOUT || resp.Transport != TransportUDP || len(s.tried) != 1 {
+		t.Errorf("expected a single udp attempt, got %s over %q (tried %v)", resp.Status, resp.Transport, s.tried)
+	}
+}
+
+func TestParseTransports(t *testing.T) {
+	transports, err := ParseTransports("UDP, tcp")
+	if err != nil || len(transports) != 2 || transports[0] != TransportUDP || transports[1] != TransportTCP {
+		t.Errorf("unexpected result %v (%v)", transports, err)
+	}
+	for _, bad := range []string{"", "sctp"} {
+		if _, err := ParseTransports(bad); err == nil {
+			t.Errorf("expected error parsing %q", bad)
+		}
+	}
+}
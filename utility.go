@@ -42,6 +42,25 @@ func AddCommand(command string, shortDescription string, longDescription string,
 	cmd.FindOptionByLongName("port").Default = []string{strconv.FormatUint(uint64(port), 10)}
 	cmd.FindOptionByLongName("name").Default = []string{command}
 	modules[command] = m
+	moduleDefaultPorts[command] = uint(port)
+	return cmd, nil
+}
+
+// AddCommandWithPorts adds a module that has several default ports. The first
+// is the default for --port; with --all-default-ports, the framework runs the
+// module's scanner on each of the ports (or those given with --default-ports)
+// for targets that do not specify a port. The scanner must implement
+// MultiPortScanner.
+func AddCommandWithPorts(command string, shortDescription string, longDescription string, ports []DefaultPort, m ScanModule) (*flags.Command, error) {
+	if len(ports) == 0 {
+		return nil, errors.New("no default ports given")
+	}
+	cmd, err := AddCommand(command, shortDescription, longDescription, int(ports[0].Port), m)
+	if err != nil {
+		return nil, err
+	}
+	cmd.FindOptionByLongName("default-ports").Default = []string{formatDefaultPorts(ports)}
+	moduleDefaultPortLists[command] = ports
 	return cmd, nil
 }
 
@@ -0,0 +1,190 @@
+package zgrab2
+
+import (
+	"bytes"
+	"math"
+	"regexp"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// watchdogShedAfter is the number of consecutive over-limit checks after which
+// the watchdog starts shedding load by reducing the number of senders.
+const watchdogShedAfter = 3
+
+// watchdogShedCooldown is the number of checks the watchdog waits after
+// reducing the number of senders before reducing it again, so that the effect
+// of the last reduction can show.
+const watchdogShedCooldown = 3
+
+// watchdogRecoverLoad is the fraction of the limits that usage must drop
+// below before the watchdog restores intake, to avoid oscillating around the
+// threshold.
+const watchdogRecoverLoad = 0.9
+
+// defaultWatchdogInterval is used if no watchdog interval was configured.
+const defaultWatchdogInterval = 5 * time.Second
+
+// watchdogStackBufferSize is the maximum number of bytes of goroutine stacks
+// sampled when attributing resource usage to modules.
+const watchdogStackBufferSize = 1024 * 1024
+
+// modulePackagePattern extracts the package name from stack frames belonging
+// to zgrab2 modules.
+var modulePackagePattern = regexp.MustCompile(`github\.com/zmap/zgrab2/modules/([a-zA-Z0-9_]+)`)
+
+// watchdog monitors heap size and goroutine count. When either exceeds its
+// limit, it throttles intake, logs the modules holding the most goroutines
+// and, if the condition persists, reduces the number of senders in proportion
+// to the overshoot, every watchdogShedCooldown checks. Once usage drops back
+// below the limits, intake and the sender count last asked for by the
+// operator are restored.
+type watchdog struct {
+	maxHeap       uint64
+	maxGoroutines int
+	interval      time.Duration
+	senders       *senderPool
+	overCount     int
+
+	// lastShed is the overCount at which the senders were last reduced, or 0.
+	lastShed int
+
+	// usage returns the current heap size and goroutine count; readUsage is
+	// used if it is nil.
+	usage func() (uint64, int)
+}
+
+// newWatchdog returns a watchdog configured from the command line, or nil if
+// no limits were given.
+func newWatchdog(senders *senderPool) *watchdog {
+	if config.WatchdogMaxHeap <= 0 && config.WatchdogMaxGoroutines <= 0 {
+		return nil
+	}
+	interval := config.WatchdogInterval
+	if interval <= 0 {
+		interval = defaultWatchdogInterval
+	}
+	return &watchdog{
+		maxHeap:       uint64(config.WatchdogMaxHeap) * 1024 * 1024,
+		maxGoroutines: config.WatchdogMaxGoroutines,
+		interval:      interval,
+		senders:       senders,
+	}
+}
+
+// run checks resource usage every interval until done is closed.
+func (w *watchdog) run(done <-chan struct{}) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			w.check()
+		}
+	}
+}
+
+// readUsage returns the current heap size and goroutine count.
+func readUsage() (uint64, int) {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	return stats.HeapAlloc, runtime.NumGoroutine()
+}
+
+// load returns the larger of the ratios of the current heap size and
+// goroutine count to their limits, along with the heap size and goroutine
+// count themselves.
+func (w *watchdog) load() (float64, uint64, int) {
+	usage := w.usage
+	if usage == nil {
+		usage = readUsage
+	}
+	heap, goroutines := usage()
+	var load float64
+	if w.maxHeap > 0 {
+		load = math.Max(load, float64(heap)/float64(w.maxHeap))
+	}
+	if w.maxGoroutines > 0 {
+		load = math.Max(load, float64(goroutines)/float64(w.maxGoroutines))
+	}
+	return load, heap, goroutines
+}
+
+// shedSenders returns the number of senders to reduce n to when usage is at
+// load times the limits: in proportion to the overshoot, but by at least one
+// sender and at most half of them.
+func shedSenders(n int, load float64) int {
+	ret := int(float64(n) / load)
+	if ret >= n {
+		ret = n - 1
+	}
+	if ret < n/2 {
+		ret = n / 2
+	}
+	return ret
+}
+
+// check performs a single watchdog pass.
+func (w *watchdog) check() {
+	load, heap, goroutines := w.load()
+	if load > 1 {
+		w.overCount++
+		if w.overCount == 1 {
+			log.Warnf("watchdog: limits exceeded (heap=%dMB, goroutines=%d); throttling intake", heap/(1024*1024), goroutines)
+			log.Warnf("watchdog: goroutines per module: %v", sampleModuleGoroutines())
+			progress.setThrottled(true)
+			debug.FreeOSMemory()
+		} else if w.overCount >= watchdogShedAfter && (w.lastShed == 0 || w.overCount-w.lastShed >= watchdogShedCooldown) {
+			if n := w.senders.size(); n > 1 {
+				shed := shedSenders(n, load)
+				log.Warnf("watchdog: limits still exceeded by %.0f%% after %d checks; reducing senders from %d to %d", (load-1)*100, w.overCount, n, shed)
+				w.senders.resize(shed)
+				w.lastShed = w.overCount
+			}
+		}
+		return
+	}
+	if w.overCount == 0 || load > watchdogRecoverLoad {
+		return
+	}
+	log.Infof("watchdog: usage back under limits (heap=%dMB, goroutines=%d); resuming", heap/(1024*1024), goroutines)
+	w.overCount = 0
+	w.lastShed = 0
+	if n := w.senders.requestedSize(); w.senders.size() != n {
+		w.senders.resize(n)
+	}
+	progress.setThrottled(false)
+}
+
+// moduleGoroutines is the number of goroutines attributed to a module.
+type moduleGoroutines struct {
+	Module string
+	Count  int
+}
+
+// sampleModuleGoroutines takes a snapshot of all goroutine stacks and counts
+// the number of goroutines executing inside each module, in descending order.
+func sampleModuleGoroutines() []moduleGoroutines {
+	buf := make([]byte, watchdogStackBufferSize)
+	buf = buf[:runtime.Stack(buf, true)]
+	counts := make(map[string]int)
+	for _, stack := range bytes.Split(buf, []byte("\n\n")) {
+		if match := modulePackagePattern.FindSubmatch(stack); match != nil {
+			counts[string(match[1])]++
+		}
+	}
+	ret := make([]moduleGoroutines, 0, len(counts))
+	for module, count := range counts {
+		ret = append(ret, moduleGoroutines{Module: module, Count: count})
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Count > ret[j].Count
+	})
+	return ret
+}
@@ -0,0 +1,96 @@
+package zgrab2
+
+import "testing"
+
+func TestWatchdogRestoresRequestedSenders(t *testing.T) {
+	defer progress.setThrottled(false)
+	pool := &senderPool{run: func(int) {}}
+	if err := pool.request(8); err != nil {
+		t.Fatal(err)
+	}
+	w := &watchdog{maxGoroutines: 1 << 20, senders: pool}
+
+	// The watchdog sheds load, then the operator changes the sender count
+	// before usage drops back under the limits.
+	w.overCount = watchdogShedAfter
+	pool.resize(4)
+	if err := pool.request(6); err != nil {
+		t.Fatal(err)
+	}
+	pool.resize(3)
+	w.check()
+	if n := pool.size(); n != 6 {
+		t.Errorf("expected the operator's 6 senders to be restored, got %d", n)
+	}
+	if w.overCount != 0 {
+		t.Errorf("expected the watchdog to reset after recovering, got %d checks over", w.overCount)
+	}
+}
+
+func TestShedSenders(t *testing.T) {
+	for _, test := range []struct {
+		n        int
+		load     float64
+		expected int
+	}{
+		{16, 2, 8},
+		{16, 1.25, 12},
+		{10, 1.01, 9},
+		{10, 5, 5},
+		{2, 1.5, 1},
+	} {
+		if got := shedSenders(test.n, test.load); got != test.expected {
+			t.Errorf("%d senders at %.2f: expected %d, got %d", test.n, test.load, test.expected, got)
+		}
+	}
+}
+
+func TestWatchdogShedsAndRecovers(t *testing.T) {
+	defer progress.setThrottled(false)
+	pool := &senderPool{run: func(int) {}}
+	if err := pool.request(16); err != nil {
+		t.Fatal(err)
+	}
+	goroutines := 0
+	w := &watchdog{
+		maxGoroutines: 100,
+		senders:       pool,
+		usage:         func() (uint64, int) { return 0, goroutines },
+	}
+
+	// Each check's goroutine count, and the senders expected after it.
+	steps := []struct {
+		goroutines int
+		senders    int
+	}{
+		{200, 16}, // throttled
+		{200, 16},
+		{200, 8}, // shed in proportion, after watchdogShedAfter checks
+		{200, 8}, // cooling down
+		{200, 8},
+		{120, 6}, // shed again, less
+		{120, 6},
+		{95, 6}, // under the limits, but not comfortably
+		{120, 6},
+		{120, 5},
+	}
+	for i, step := range steps {
+		goroutines = step.goroutines
+		w.check()
+		if n := pool.size(); n != step.senders {
+			t.Errorf("check %d (%d goroutines): expected %d senders, got %d", i+1, step.goroutines, step.senders, n)
+		}
+		if !progress.snapshot().Throttled {
+			t.Errorf("check %d: expected intake to be throttled", i+1)
+		}
+	}
+
+	goroutines = 50
+	w.check()
+	if n := pool.size(); n != 16 {
+		t.Errorf("expected the 16 requested senders to be restored, got %d", n)
+	}
+	if progress.snapshot().Throttled || w.overCount != 0 || w.lastShed != 0 {
+		t.Errorf("expected the watchdog to reset, got throttled=%v after %d checks over", progress.snapshot().Throttled, w.overCount)
+	}
+}
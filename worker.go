@@ -0,0 +1,344 @@
+package zgrab2
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/zmap/zgrab2/lib/jobqueue"
+)
+
+// workerRetryDelay is how long a worker waits after failing to receive a job,
+// or push results, before trying again.
+const workerRetryDelay = 5 * time.Second
+
+// workerFileBatch is the most results written to the output file between
+// deleting the jobs they complete.
+const workerFileBatch = 1000
+
+// resultsQueued counts the non-empty results queued for output. A job's
+// results have all been written once as many results as were queued when it
+// completed have been.
+var resultsQueued uint64
+
+// workerJob tracks the targets of a job received by a worker.
+type workerJob struct {
+	*jobqueue.Job
+	w *worker
+
+	mutex sync.Mutex
+	// pending is the number of the job's targets dispatched but not yet
+	// completed.
+	pending int
+	// dispatched is set once all of the job's targets have been dispatched.
+	dispatched bool
+
+	// complete and marker are set, under the worker's mutex, once all of
+	// the job's targets have been completed: marker is the value of
+	// resultsQueued at the time.
+	complete bool
+	marker   uint64
+}
+
+// targetCompleted is the completion hook of each of the job's targets.
+func (j *workerJob) targetCompleted() {
+	j.mutex.Lock()
+	j.pending--
+	complete := j.dispatched && j.pending == 0
+	j.mutex.Unlock()
+	if complete {
+		j.w.completed(j)
+	}
+}
+
+// allDispatched records that all of the job's targets have been dispatched.
+func (j *workerJob) allDispatched() {
+	j.mutex.Lock()
+	j.dispatched = true
+	complete := j.pending == 0
+	j.mutex.Unlock()
+	if complete {
+		j.w.completed(j)
+	}
+}
+
+// worker runs the scan as a long-lived worker of a distributed fleet (see
+// --worker-queue): it takes batches of targets from a job queue, and pushes
+// results to a result queue or writes them to the output file. A job is
+// deleted from the queue once all of its results have been pushed or written,
+// and is kept hidden from other workers until then. When the worker is
+// interrupted, the jobs it has not completed are released to other workers
+// straight away, rather than once their visibility timeout expires.
+type worker struct {
+	source     jobqueue.Source
+	sink       jobqueue.Sink
+	visibility time.Duration
+	wait       time.Duration
+
+	mutex sync.Mutex
+	// jobs are the jobs received and not yet deleted.
+	jobs map[*workerJob]bool
+	// written is the number of results written.
+	written uint64
+}
+
+// jobWorker is the worker configured with --worker-queue, if any.
+var jobWorker *worker
+
+// newWorker returns a worker configured from the command line, or nil if no
+// --worker-queue was given.
+func newWorker() (*worker, error) {
+	if config.WorkerQueue == "" {
+		return nil, nil
+	}
+	if config.WorkerVisibilityTimeout < 3*time.Second {
+		return nil, fmt.Errorf("worker visibility timeout must be at least 3s, given %s", config.WorkerVisibilityTimeout)
+	}
+	if config.WorkerWait <= 0 {
+		return nil, fmt.Errorf("worker wait must be positive, given %s", config.WorkerWait)
+	}
+	source, err := jobqueue.OpenSource(config.WorkerQueue)
+	if err != nil {
+		return nil, err
+	}
+	ret := &worker{
+		source:     source,
+		visibility: config.WorkerVisibilityTimeout,
+		wait:       config.WorkerWait,
+		jobs:       make(map[*workerJob]bool),
+	}
+	if config.WorkerResults != "" {
+		if ret.sink, err = jobqueue.OpenSink(config.WorkerResults); err != nil {
+			return nil, err
+		}
+	}
+	return ret, nil
+}
+
+// inputTargets is an InputTargetsFunc that receives jobs until the scan is
+// interrupted, and sends their targets on. A job is only received once the
+// previous one's targets have all been taken for scanning, so that a busy
+// worker leaves jobs in the queue for idle ones.
+func (w *worker) inputTargets(ch chan<- ScanTarget) error {
+	for !interrupt.stopped() {
+		job, err := w.source.Receive(w.wait, w.visibility)
+		if err != nil {
+			log.Errorf("could not receive a job: %v", err)
+			select {
+			case <-time.After(workerRetryDelay):
+			case <-interrupt.stop:
+			}
+			continue
+		}
+		if job == nil {
+			continue
+		}
+		targets, err := parseJob(job)
+		if err != nil {
+			// The job would fail again for any worker, so drop it.
+			log.Errorf("dropping job %s: %v", job.ID, err)
+			if err := w.source.Delete(job); err != nil {
+				log.Errorf("could not delete job %s: %v", job.ID, err)
+			}
+			continue
+		}
+		log.Debugf("received job %s of %d targets", job.ID, len(targets))
+		j := &workerJob{Job: job, w: w}
+		w.mutex.Lock()
+		w.jobs[j] = true
+		w.mutex.Unlock()
+		for _, target := range targets {
+			j.mutex.Lock()
+			j.pending++
+			j.mutex.Unlock()
+			target.completed = j.targetCompleted
+			select {
+			case ch <- target:
+			case <-interrupt.stop:
+				return nil
+			}
+		}
+		j.allDispatched()
+	}
+	return nil
+}
+
+// parseJob returns the targets in a job's body.
+func parseJob(job *jobqueue.Job) ([]ScanTarget, error) {
+	ch := make(chan ScanTarget)
+	errc := make(chan error, 1)
+	go func() {
+		defer close(ch)
+		errc <- GetTargetsCSV(bytes.NewReader(job.Body), ch)
+	}()
+	var ret []ScanTarget
+	for target := range ch {
+		ret = append(ret, target)
+	}
+	return ret, <-errc
+}
+
+// extend extends the visibility timeout of the jobs held, three times per
+// timeout, until done is closed.
+func (w *worker) extend(done <-chan struct{}) {
+	ticker := time.NewTicker(w.visibility / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+		for _, j := range w.held() {
+			if err := w.source.Extend(j.Job, w.visibility); err != nil {
+				log.Warnf("could not extend job %s; it may be scanned again by another worker: %v", j.ID, err)
+			}
+		}
+	}
+}
+
+// held returns the jobs received and not yet deleted.
+func (w *worker) held() []*workerJob {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	ret := make([]*workerJob, 0, len(w.jobs))
+	for j := range w.jobs {
+		ret = append(ret, j)
+	}
+	return ret
+}
+
+// completed is called once all of a job's targets have been completed, and
+// their results queued for output.
+func (w *worker) completed(j *workerJob) {
+	w.mutex.Lock()
+	j.complete = true
+	j.marker = atomic.LoadUint64(&resultsQueued)
+	w.mutex.Unlock()
+	w.deleteWritten()
+}
+
+// deleteWritten deletes the completed jobs whose results have all been
+// written.
+func (w *worker) deleteWritten() {
+	var ready []*workerJob
+	w.mutex.Lock()
+	for j := range w.jobs {
+		if j.complete && j.marker <= w.written {
+			ready = append(ready, j)
+			delete(w.jobs, j)
+		}
+	}
+	w.mutex.Unlock()
+	for _, j := range ready {
+		if err := w.source.Delete(j.Job); err != nil {
+			log.Errorf("could not delete job %s; it may be scanned again by another worker: %v", j.ID, err)
+		} else {
+			log.Debugf("completed job %s", j.ID)
+		}
+	}
+}
+
+// outputResults is an OutputResultsFunc that pushes results to the result
+// queue, or writes them to the output file, in batches, deleting the jobs
+// they complete after each batch. Until the results end, it extends the
+// visibility timeout of the jobs held; then it releases the jobs that were not
+// completed.
+func (w *worker) outputResults(results <-chan []byte) error {
+	done := make(chan struct{})
+	defer close(done)
+	go w.extend(done)
+	out := bufio.NewWriter(config.outputFile)
+	maxBatch := workerFileBatch
+	if w.sink != nil {
+		maxBatch = w.sink.MaxBatch()
+	}
+	var batch [][]byte
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if w.sink != nil {
+			// Keep trying, as the results cannot be recovered other than by
+			// scanning the jobs again.
+			for {
+				err := w.sink.Push(batch)
+				if err == nil {
+					break
+				}
+				log.Errorf("could not push %d results: %v", len(batch), err)
+				time.Sleep(workerRetryDelay)
+			}
+		} else if err := out.Flush(); err != nil {
+			return err
+		}
+		for _, result := range batch {
+			ReleaseResult(result)
+		}
+		w.mutex.Lock()
+		w.written += uint64(len(batch))
+		w.mutex.Unlock()
+		batch = batch[:0]
+		w.deleteWritten()
+		return nil
+	}
+	for {
+		var result []byte
+		var ok bool
+		select {
+		case result, ok = <-results:
+		default:
+			// Nothing waiting: write what has been collected before
+			// blocking.
+			if err := flush(); err != nil {
+				return err
+			}
+			result, ok = <-results
+		}
+		if !ok {
+			break
+		}
+		if w.sink == nil {
+			if _, err := out.Write(result); err != nil {
+				return err
+			}
+			if err := out.WriteByte('\n'); err != nil {
+				return err
+			}
+		}
+		batch = append(batch, result)
+		if len(batch) >= maxBatch {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+	w.releaseHeld()
+	return nil
+}
+
+// releaseHeld returns the jobs that were not completed to the queue.
+func (w *worker) releaseHeld() {
+	for _, j := range w.held() {
+		if err := w.source.Release(j.Job); err != nil {
+			log.Errorf("could not release job %s: %v", j.ID, err)
+		} else {
+			log.Infof("released job %s to other workers", j.ID)
+		}
+	}
+}
+
+// complete calls the target's completion hook, if any, once it has been
+// scanned and its results queued for output, or it has been skipped.
+func (target *ScanTarget) complete() {
+	if target.completed != nil {
+		target.completed()
+	}
+}
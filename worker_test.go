@@ -0,0 +1,135 @@
+package zgrab2
+
+import (
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/zmap/zgrab2/lib/jobqueue"
+)
+
+// fakeJobQueue is a job queue and result queue held in memory.
+type fakeJobQueue struct {
+	mutex    sync.Mutex
+	jobs     []*jobqueue.Job
+	events   []string
+	results  []string
+	received chan struct{}
+}
+
+func (q *fakeJobQueue) record(event string, job *jobqueue.Job) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.events = append(q.events, event+" "+job.ID)
+}
+
+func (q *fakeJobQueue) Receive(wait, visibility time.Duration) (*jobqueue.Job, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if len(q.jobs) == 0 {
+		q.mutex.Unlock()
+		time.Sleep(time.Millisecond)
+		q.mutex.Lock()
+		return nil, nil
+	}
+	job := q.jobs[0]
+	q.jobs = q.jobs[1:]
+	return job, nil
+}
+
+func (q *fakeJobQueue) Extend(job *jobqueue.Job, visibility time.Duration) error {
+	return nil
+}
+
+func (q *fakeJobQueue) Delete(job *jobqueue.Job) error {
+	q.record("delete", job)
+	return nil
+}
+
+func (q *fakeJobQueue) Release(job *jobqueue.Job) error {
+	q.record("release", job)
+	return nil
+}
+
+func (q *fakeJobQueue) Push(results [][]byte) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	for _, result := range results {
+		q.results = append(q.results, string(result))
+		q.events = append(q.events, "push "+string(result))
+	}
+	return nil
+}
+
+func (q *fakeJobQueue) MaxBatch() int {
+	return 10
+}
+
+func (q *fakeJobQueue) snapshot() []string {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return append([]string(nil), q.events...)
+}
+
+func TestWorker(t *testing.T) {
+	defer func(old *interruptState) { interrupt = old }(interrupt)
+	interrupt = newInterruptState()
+	atomic.StoreUint64(&resultsQueued, 0)
+	q := &fakeJobQueue{jobs: []*jobqueue.Job{
+		{ID: "a", Body: []byte("10.0.0.1\n10.0.0.2\n")},
+		{ID: "bad", Body: []byte("10.0.0.3,\"unterminated\n")},
+		{ID: "empty", Body: []byte("# nothing\n")},
+		{ID: "b", Body: []byte("10.0.0.4\n")},
+	}}
+	w := &worker{source: q, sink: q, visibility: time.Minute, wait: time.Second, jobs: make(map[*workerJob]bool)}
+
+	targets := make(chan ScanTarget)
+	inputDone := make(chan error)
+	go func() {
+		inputDone <- w.inputTargets(targets)
+	}()
+	results := make(chan []byte)
+	outputDone := make(chan error)
+	go func() {
+		outputDone <- w.outputResults(results)
+	}()
+
+	// Job a completes, but b is interrupted before its target is scanned.
+	for i := 0; i < 2; i++ {
+		target := <-targets
+		atomic.AddUint64(&resultsQueued, 1)
+		results <- []byte(target.IP.String())
+		target.complete()
+	}
+	<-targets
+	interrupt.trigger(syscall.SIGTERM)
+	if err := <-inputDone; err != nil {
+		t.Fatal(err)
+	}
+	close(results)
+	if err := <-outputDone; err != nil {
+		t.Fatal(err)
+	}
+
+	events := q.snapshot()
+	expected := []string{"delete bad", "delete empty", "push 10.0.0.1", "push 10.0.0.2", "delete a", "release b"}
+	if len(events) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, events)
+	}
+	// The empty job may be deleted before or after a's results are pushed,
+	// but a must only be deleted after them.
+	index := make(map[string]int)
+	for i, event := range events {
+		index[event] = i + 1
+	}
+	for _, event := range expected {
+		if index[event] == 0 {
+			t.Errorf("missing %s in %v", event, events)
+		}
+	}
+	if index["delete a"] < index["push 10.0.0.2"] || index["release b"] != len(events) {
+		t.Errorf("wrong order %v", events)
+	}
+}
@@ -20,3 +20,12 @@ from . import smtp
 from . import ssh
 from . import telnet
 from . import ipp
+from . import probe
+from . import udp
+from . import windows
+from . import proxyprotocol
+from . import autodetect
+from . import acme
+from . import idp
+from . import dtls
+from . import starttls
@@ -0,0 +1,39 @@
+# zschema sub-schema for zgrab2's acme module
+# Registers zgrab2-acme globally, and acme with the main zgrab2 schema.
+from zschema.leaves import *
+from zschema.compounds import *
+import zschema.registry
+
+from . import zgrab2
+
+# modules/acme/scanner.go: Challenge
+acme_challenge = SubRecord({
+    "path": String(doc="The path requested."),
+    "status_code": Unsigned16BitInteger(doc="The HTTP status code of the response."),
+    "location": String(doc="The Location header of the response, if it is a redirect."),
+    "content_type": String(doc="The Content-Type header of the response."),
+    "body": String(doc="The start of the response body, up to --max-body-size bytes."),
+    "error": String(doc="The reason the request failed, if it did."),
+})
+
+acme_scan_response = SubRecord({
+    "result": SubRecord({
+        "tls_alpn": SubRecord({
+            "protocol": String(doc="The ALPN protocol the server selected, if any."),
+            "negotiated": Boolean(doc="True if the server selected acme-tls/1."),
+            "acme_identifier": Binary(doc="The key authorization digest in the certificate's acmeIdentifier extension."),
+            "names": ListOf(String(), doc="The DNS names in the certificate."),
+            "self_signed": Boolean(doc="True if the certificate is signed by its own key."),
+            "issues": ListOf(String(), doc="The ways the server's answer differs from what RFC 8737 requires of a challenge response."),
+            "tls": zgrab2.tls_log,
+            "error": String(doc="The reason the handshake failed, if it did."),
+        }, doc="The result of the TLS handshake offering only acme-tls/1."),
+        "challenges": ListOf(acme_challenge, doc="The responses to the http-01 challenge paths, the first being a made-up token."),
+        "key_authorization_reflected": Boolean(doc="True if the server answered the made-up token with a key authorization for it, as stateless responders do."),
+        "account_thumbprint": String(doc="The ACME account key thumbprint in the reflected key authorization."),
+    })
+}, extends=zgrab2.base_scan_response)
+
+zschema.registry.register_schema("zgrab2-acme", acme_scan_response)
+
+zgrab2.register_scan_response_type("acme", acme_scan_response)
@@ -0,0 +1,25 @@
+# zschema sub-schema for zgrab2's autodetect module
+# Registers zgrab2-autodetect globally, and autodetect with the main zgrab2 schema.
+from zschema.leaves import *
+from zschema.compounds import *
+import zschema.registry
+
+from . import zgrab2
+
+autodetect_scan_response = SubRecord({
+    "result": SubRecord({
+        "protocol": String(doc="The best guess at the protocol, named after the zgrab2 module that scans it."),
+        "probe": String(doc="The name of the probe that identified the protocol."),
+        "evidence": ListOf(SubRecord({
+            "probe": String(doc="The name of the probe."),
+            "response": Binary(doc="What the target sent back."),
+            "protocol": String(doc="The protocol the response identified, if any."),
+            "error": String(doc="Why the probe failed, if it did."),
+        }), doc="The probes sent, in order, with their responses."),
+        "followed": SubRecord({}, doc="The scan response of the module for the detected protocol, with --follow."),  # TODO FIXME: depends on the protocol
+    })
+}, extends=zgrab2.base_scan_response)
+
+zschema.registry.register_schema("zgrab2-autodetect", autodetect_scan_response)
+
+zgrab2.register_scan_response_type("autodetect", autodetect_scan_response)
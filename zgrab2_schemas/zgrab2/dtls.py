@@ -0,0 +1,15 @@
+# zschema sub-schema for zgrab2's dtls module
+# Registers zgrab2-dtls globally, and dtls with the main zgrab2 schema.
+from zschema.leaves import *
+from zschema.compounds import *
+import zschema.registry
+
+from . import zgrab2
+
+dtls_scan_response = SubRecord({
+    "result": zgrab2.tls_log,
+}, extends=zgrab2.base_scan_response)
+
+zschema.registry.register_schema("zgrab2-dtls", dtls_scan_response)
+
+zgrab2.register_scan_response_type("dtls", dtls_scan_response)
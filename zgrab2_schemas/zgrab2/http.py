@@ -146,6 +146,13 @@ http_request_full = SubRecord({
     "tls_log": zgrab2.tls_log
 })
 
+# lib/http/response.go: http.InterimResponse
+http_interim_response = SubRecord({
+    "status_line": String(),
+    "status_code": Unsigned32BitInteger(),
+    "headers": http_headers,
+})
+
 # lib/http/response.go: http.Response
 http_response_full = SubRecord({
     "status_line": String(),
@@ -159,10 +166,303 @@ http_response_full = SubRecord({
     "headers": http_headers,
     "body": String(),
     "body_sha256": Binary(),
+    "body_mmh3": Signed32BitInteger(doc="The MurmurHash3 of the body, as Shodan's http.html_hash."),
+    "body_file": String(doc="The file the body was written to instead, relative to --body-store-dir."),
+    "encoded_length": Signed64BitInteger(doc="The number of body bytes read as received, if the body was decoded from its Content-Encoding."),
+    "decoded_length": Signed64BitInteger(doc="The number of bytes the body decoded to, up to --max-size."),
+    "decode_error": String(doc="The error decoding the body, which is then recorded as received."),
     "content_length": Signed64BitInteger(),
     "transfer_encoding": ListOf(String()),
     "trailers": http_headers,
-    "request": http_request_full
+    "request": http_request_full,
+    "interim_responses": ListOf(http_interim_response, doc="The informational (1xx) responses, such as 103 Early Hints, received before this one."),
+})
+
+# modules/http/cookies.go: SetCookie
+http_set_cookie = SubRecord({
+    "name": String(),
+    "value": String(),
+    "domain": String(doc="The Domain attribute, empty for a host-only cookie."),
+    "path": String(),
+    "expires": String(doc="The Expires attribute, as given."),
+    "expires_time": DateTime(doc="The Expires attribute's time, if it could be parsed."),
+    "max_age": Signed32BitInteger(),
+    "secure": Boolean(),
+    "http_only": Boolean(),
+    "same_site": String(),
+    "partitioned": Boolean(),
+    "prefix": String(doc="__Secure- or __Host- if the name starts with one."),
+    "unparsed": ListOf(String(), doc="The attributes not recognized, or with invalid values."),
+    "raw": String(),
+})
+
+# modules/http/cookies.go: CookieExchange
+http_cookie_exchange = SubRecord({
+    "url": String(),
+    "sent": ListOf(SubRecord({
+        "name": String(),
+        "value": String(),
+    }), doc="The cookies sent with the request."),
+    "set": ListOf(http_set_cookie, doc="The Set-Cookie headers of the response, parsed."),
+})
+
+# modules/http/auth.go: Auth
+http_auth = SubRecord({
+    "scheme": String(doc="The --http-auth scheme used."),
+    "challenges": ListOf(SubRecord({
+        "scheme": String(),
+        # "params" maps the challenge's parameters, e.g. realm, by lowercase name, to their values.
+        "token": String(doc="The challenge's token68, e.g. a base64 NTLM challenge message."),
+    }), doc="The challenges in the 401 response's WWW-Authenticate headers."),
+    "ntlm": SubRecord({
+        "target_name": String(doc="The target name of the NTLM challenge."),
+        "netbios_computer_name": String(),
+        "netbios_domain_name": String(),
+        "dns_computer_name": String(),
+        "dns_domain_name": String(),
+        "dns_tree_name": String(),
+        "os_version": String(doc="The operating system version in the challenge, as MAJOR.MINOR.BUILD."),
+    }, doc="The names and version in the server's NTLM challenge, with ntlm or negotiate."),
+    "authenticated": Boolean(doc="True if the request with credentials was not answered with another 401."),
+    "response": http_response_full,
+    "error": String(),
+})
+
+# modules/http/html.go: HTMLMetadata
+http_html = SubRecord({
+    "title": String(doc="The page's <title>."),
+    "generator": String(doc="The content of <meta name=\"generator\">."),
+    "charset": String(doc="The page's character encoding, lowercased."),
+    "charset_source": Enum(values=["header", "meta"], doc="Where the charset was given: the Content-Type header, or a <meta> tag."),
+    "canonical": String(doc="The URL of <link rel=\"canonical\">, resolved against the page's URL."),
+}, doc="A summary of the final response's body, if it is an HTML page.")
+
+# modules/http/altsvc.go: AltSvc
+http_alt_svc = SubRecord({
+    "protocol": String(doc="The ALPN protocol ID of the alternative, e.g. h3 or h2."),
+    "host": String(doc="The alternative's host, if not the origin's."),
+    "port": Unsigned16BitInteger(),
+    "max_age": Signed64BitInteger(doc="The number of seconds the alternative may be used for, if given."),
+    "persist": Boolean(),
+    "retry": SubRecord({
+        "reachable": Boolean(doc="True if the alternative answered."),
+        "quic_versions": ListOf(String(), doc="The QUIC versions in the alternative's Version Negotiation packet, for HTTP/3."),
+        "negotiated_protocol": String(doc="The ALPN protocol the alternative selected, for alternatives over TLS."),
+        "error": String(),
+    }, doc="The result of connecting to the alternative, with --retry-alt-svc."),
+})
+
+# modules/http/encoding.go: Encodings
+http_encodings = SubRecord({
+    "probes": ListOf(SubRecord({
+        "accept_encoding": String(doc="The Accept-Encoding sent."),
+        "status_code": Unsigned32BitInteger(),
+        "content_encoding": String(doc="The Content-Encoding of the response, if any."),
+        "vary": String(doc="The Vary header of the response."),
+        "length": Unsigned32BitInteger(doc="The number of body bytes received, up to --max-size."),
+        "truncated": Boolean(doc="True if the body was longer than --max-size."),
+        "body_sha256": Binary(doc="The SHA-256 digest of the body as received."),
+        "decoded_sha256": Binary(doc="The SHA-256 digest of the decoded body, for identity, gzip, deflate, br and zstd."),
+        "error": String(),
+    })),
+    "used": ListOf(String(), doc="The content encodings the server used; identity for responses without one."),
+    "content_differs": Boolean(doc="True if the decoded bodies are not all the same."),
+}, doc="The responses to requests with different Accept-Encoding values, with --probe-encodings.")
+
+# modules/http/http2.go: HTTP2
+http_http2 = SubRecord({
+    "negotiation": String(doc="How HTTP/2 was offered: alpn, or upgrade for an h2c Upgrade header."),
+    "negotiated": Boolean(doc="True if the server agreed to speak HTTP/2."),
+    "negotiated_protocol": String(doc="The ALPN protocol the server selected."),
+    "upgrade_status_code": Unsigned32BitInteger(doc="The status of the response to the Upgrade request: 101 if the server switched to HTTP/2."),
+    "settings": ListOf(SubRecord({
+        "name": String(doc="The setting's name, e.g. MAX_CONCURRENT_STREAMS, if known."),
+        "id": Unsigned16BitInteger(),
+        "value": Unsigned32BitInteger(),
+    }), doc="The parameters of the server's first SETTINGS frame, in the order sent."),
+    "pushes": ListOf(SubRecord({
+        "stream_id": Unsigned32BitInteger(),
+        "method": String(),
+        "authority": String(),
+        "path": String(),
+    }), doc="The requests the server promised to push responses to."),
+    "header_block_size": Unsigned32BitInteger(doc="The size of the response's HPACK-compressed header block."),
+    "header_list_size": Unsigned32BitInteger(doc="The size of the response's decoded header list, as defined for SETTINGS_MAX_HEADER_LIST_SIZE."),
+    "response": http_response_full,
+    "goaway": SubRecord({
+        "last_stream_id": Unsigned32BitInteger(),
+        "error_code": String(),
+        "debug_data": String(),
+    }, doc="The GOAWAY frame the server sent, if any."),
+    "error": String(),
+}, doc="The response to the final URL over HTTP/2, with --http2.")
+
+# modules/http/http3.go: HTTP3
+http_http3 = SubRecord({
+    "address": String(doc="The UDP address the request was sent to."),
+    "source": String(doc="Where the address came from: alt_svc for an h3 alternative the final response advertised, or origin for the final URL's host and port."),
+    "quic_version": String(doc="The QUIC version the connection used."),
+    "supported_versions": ListOf(String(), doc="The QUIC versions in the server's Version Negotiation packet, if it did not accept version 1."),
+    "retry": Boolean(doc="True if the server sent a Retry packet."),
+    "negotiated_protocol": String(doc="The ALPN protocol the server selected."),
+    "transport_parameters": ListOf(SubRecord({
+        "name": String(doc="The parameter's name, e.g. initial_max_data, if known."),
+        "id": Signed64BitInteger(),
+        "value": Signed64BitInteger(doc="The value of an integer parameter."),
+        "raw": Binary(doc="The value of any other parameter."),
+    }), doc="The server's QUIC transport parameters, in the order sent."),
+    "settings": ListOf(SubRecord({
+        "name": String(doc="The setting's name, e.g. QPACK_MAX_TABLE_CAPACITY, if known."),
+        "id": Signed64BitInteger(),
+        "value": Signed64BitInteger(),
+    }), doc="The parameters of the server's SETTINGS frame, in the order sent."),
+    "response": http_response_full,
+    "connection_close": SubRecord({
+        "application": Boolean(doc="True if the connection was closed by HTTP/3 rather than by QUIC."),
+        "error_code": String(),
+        "reason": String(),
+    }, doc="The CONNECTION_CLOSE frame the server sent, if any."),
+    "error": String(),
+}, doc="The response to the final URL over HTTP/3, with --http3.")
+
+# modules/http/websocket.go: WebSocket
+http_websocket = SubRecord({
+    "url": String(),
+    "response": http_response_full,
+    "upgraded": Boolean(doc="True if the server switched to WebSocket, answering with 101 and the Sec-WebSocket-Accept matching the key sent."),
+    "subprotocol": String(doc="The subprotocol the server selected from --websocket-protocols."),
+    "extensions": ListOf(String(), doc="The extensions the server accepted."),
+    "frames": ListOf(SubRecord({
+        "opcode": String(doc="continuation, text, binary, close, ping, pong, or the hex value of a reserved opcode."),
+        "final": Boolean(),
+        "length": Signed64BitInteger(),
+        "text": String(doc="The payload of a text frame."),
+        "data": Binary(doc="The payload of any other frame."),
+        "close_code": Unsigned16BitInteger(),
+        "close_reason": String(),
+        "truncated": Boolean(doc="True if the payload was longer than --max-size."),
+    }), doc="The first frames the server sent, up to --websocket-frames."),
+    "error": String(),
+}, doc="The outcome of the WebSocket handshake, with --websocket.")
+
+# modules/http/technology.go: Technology
+http_technology = SubRecord({
+    "name": String(),
+    "version": String(),
+    "confidence": Unsigned8BitInteger(doc="The sum of the confidences of the rules that matched, up to 100."),
+    "categories": ListOf(Unsigned16BitInteger(), doc="The technology's category IDs in the --technologies-file."),
+    "cpe": String(),
+    "implied_by": String(doc="The technology whose detection implied this one, if it was not itself matched."),
+})
+
+# modules/http/favicon.go: Favicon
+http_favicon = SubRecord({
+    "url": String(doc="The icon's URL."),
+    "source": String(doc="Where the URL came from: html for the page's <link rel=\"icon\">, or default for /favicon.ico."),
+    "final_url": String(doc="The URL the icon was served from, if the request for it was redirected."),
+    "status_code": Signed32BitInteger(),
+    "content_type": String(),
+    "length": Unsigned32BitInteger(),
+    "truncated": Boolean(doc="True if the icon was longer than --max-size, in which case it is not hashed."),
+    "md5": String(doc="The hex MD5 digest of the icon."),
+    "mmh3": Signed32BitInteger(doc="The MurmurHash3 of the icon's base64 encoding, as Shodan's http.favicon.hash."),
+    "error": String(),
+}, doc="The site's icon, with --with-favicon.")
+
+# modules/http/files.go: FetchedFile
+http_fetched_file = SubRecord({
+    "path": String(doc="The path fetched: /robots.txt, /.well-known/security.txt, or a --fetch-path."),
+    "url": String(),
+    "final_url": String(doc="The URL the file was served from, if the request for it was redirected."),
+    "response": http_response_full,
+    "robots_txt": SubRecord({
+        "groups": ListOf(SubRecord({
+            "user_agents": ListOf(String()),
+            "allow": ListOf(String()),
+            "disallow": ListOf(String()),
+            "crawl_delay": String(),
+        }), doc="The groups of rules, in order."),
+        "sitemaps": ListOf(String(), doc="The URLs of the Sitemap lines."),
+    }, doc="The robots.txt, parsed, if it was served as text."),
+    "security_txt": SubRecord({
+        "contact": ListOf(String()),
+        "expires": String(),
+        "encryption": ListOf(String()),
+        "acknowledgments": ListOf(String()),
+        "preferred_languages": String(),
+        "canonical": ListOf(String()),
+        "policy": ListOf(String()),
+        "hiring": ListOf(String()),
+        "expired": Boolean(doc="True if the Expires time is in the past."),
+        "signed": Boolean(doc="True if the file is an OpenPGP cleartext signed message, whose signature is not verified."),
+    }, doc="The security.txt, parsed, if it was served as text."),
+    "error": String(),
+})
+
+# modules/http/redirect.go: RedirectHop
+http_redirect_hop = SubRecord({
+    "url": String(),
+    "status_code": Unsigned32BitInteger(),
+    "headers": http_headers,
+    "ip": String(doc="The IP address of the server the request was sent to."),
+    "port": Unsigned16BitInteger(doc="The port of the server the request was sent to."),
+    "location": String(doc="The URL the response redirects to, resolved against its URL."),
+    "cross_host": Boolean(doc="True if the redirect is to another host."),
+    "cross_scheme": Boolean(doc="True if the redirect is to another scheme."),
+    "cross_port": Boolean(doc="True if the redirect is to another port, the schemes' default ports filled in."),
+    "followed": Boolean(doc="True if the redirect was followed."),
+    "blocked": String(doc="Why the redirect was not followed: localhost, max-redirects, cross-host, cross-scheme or cross-port."),
+})
+
+# modules/http/sequence.go: SequenceResponse
+http_sequence_response = SubRecord({
+    "name": String(doc="The name of the request in the --sequence-file."),
+    "method": String(),
+    "url": String(),
+    "reused_connection": Boolean(doc="True if the request was sent on a connection already opened by an earlier request."),
+    "response": http_response_full,
+    "error": String(),
+})
+
+# modules/http/lite.go: LiteResult
+http_lite = SubRecord({
+    "url": String(doc="The URL of the final response."),
+    "status_code": Unsigned32BitInteger(),
+    "server": String(doc="The Server header."),
+    "content_type": String(doc="The Content-Type header."),
+    "content_length": Signed64BitInteger(doc="The Content-Length, or -1 if it is unknown."),
+    "location": String(doc="The Location header."),
+    "title": String(doc="The page's title, if it is in the part of the body read."),
+    "icon": String(doc="The URL of the icon the page links to, if the link is in the part of the body read."),
+    "body_length": Unsigned32BitInteger(doc="The number of body bytes read, up to --lite-body-size."),
+    "body_sha256": Binary(doc="The SHA-256 digest of the body bytes read."),
+    "tls": SubRecord({
+        "version": zcrypto.TLSVersion(),
+        "cipher_suite": zcrypto.CipherSuite(),
+        "certificate_sha256": Binary(doc="The SHA-256 fingerprint of the server's certificate."),
+    }, doc="The TLS handshake of the final response's connection, if it used TLS."),
+}, doc="A small summary of the final response, with --lite, in place of the other fields.")
+
+# modules/http/vhost.go: VHost
+http_vhost = SubRecord({
+    "host": String(doc="The name sent as the Host header and, unless --server-name or --no-sni is given, in the SNI extension."),
+    "url": String(),
+    "status_code": Unsigned32BitInteger(),
+    "server": String(doc="The Server header."),
+    "content_type": String(doc="The Content-Type header."),
+    "content_length": Signed64BitInteger(doc="The Content-Length, or -1 if it is unknown."),
+    "location": String(doc="The Location header; redirects are not followed."),
+    "title": String(doc="The page's title, if it is in the part of the body read."),
+    "icon": String(doc="The URL of the icon the page links to, if the link is in the part of the body read."),
+    "body_length": Unsigned32BitInteger(doc="The number of body bytes read."),
+    "body_sha256": Binary(doc="The SHA-256 digest of the body bytes read."),
+    "tls": SubRecord({
+        "version": zcrypto.TLSVersion(),
+        "cipher_suite": zcrypto.CipherSuite(),
+        "certificate_sha256": Binary(doc="The SHA-256 fingerprint of the server's certificate for the name."),
+    }),
+    "differs": Boolean(doc="True if the status or body differs from those of the response to the first request."),
+    "error": String(),
 })
 
 # modules/http.go: HTTPResults
@@ -172,9 +472,29 @@ http_scan_response = SubRecord({
         "connect_response": http_response,
         "response": http_response_full,
         "redirect_response_chain": ListOf(http_response_full),
+        "redirects": ListOf(http_redirect_hop, doc="The hops of the redirect chain, if the first response was a redirect, ending with the final response."),
+        "cookies": ListOf(http_cookie_exchange, doc="The cookies sent with each request and set by its response, in order, with --cookie-jar."),
+        "auth": http_auth,
+        "html": http_html,
+        "alt_svc": ListOf(http_alt_svc, doc="The alternative services advertised in the final response's Alt-Svc header."),
+        "encodings": http_encodings,
+        "http2": http_http2,
+        "http3": http_http3,
+        "websocket": http_websocket,
+        "technologies": ListOf(http_technology, doc="The technologies detected in the final response, with --technologies-file."),
+        "favicon": http_favicon,
+        "files": ListOf(http_fetched_file, doc="The files fetched from the final URL's origin, with --with-robots-txt, --with-security-txt and --fetch-path."),
+        "sequence": ListOf(http_sequence_response, doc="The responses to the requests in the --sequence-file, in order."),
+        "vhosts": ListOf(http_vhost, doc="The responses to the first request sent again for each of the --vhosts, in order."),
+        "lite": http_lite,
     })
 }, extends=zgrab2.base_scan_response)
 
 zschema.registry.register_schema("zgrab2-http", http_scan_response)
 
 zgrab2.register_scan_response_type("http", http_scan_response)
+
+# modules/http/scanner.go: defaultPorts; with --all-default-ports, results for
+# all but the first default port are recorded as http:<port>.
+for port in [8080, 8000, 443]:
+    zgrab2.register_scan_response_type("http:%d" % port, http_scan_response)
@@ -0,0 +1,99 @@
+# zschema sub-schema for zgrab2's idp module
+# Registers zgrab2-idp globally, and idp with the main zgrab2 schema.
+from zschema.leaves import *
+from zschema.compounds import *
+import zschema.registry
+
+from . import zgrab2
+
+# modules/idp/scanner.go: Fetch
+idp_fetch = SubRecord({
+    "path": String(doc="The path requested."),
+    "status_code": Unsigned16BitInteger(doc="The HTTP status code of the response."),
+    "location": String(doc="The Location header of the response, if it is a redirect."),
+    "content_type": String(doc="The Content-Type header of the response."),
+    "length": Unsigned32BitInteger(doc="The number of bytes of the body read."),
+    "truncated": Boolean(doc="True if the body was longer than --max-size."),
+    "error": String(doc="The reason the request failed, or its response could not be parsed, if it did."),
+})
+
+# modules/idp/scanner.go: OAuthMetadata
+idp_oauth_metadata = SubRecord({
+    "path": String(doc="The path the document was found at."),
+    "issuer": String(doc="The authorization server's issuer identifier."),
+    "authorization_endpoint": String(),
+    "token_endpoint": String(),
+    "userinfo_endpoint": String(),
+    "jwks_uri": String(doc="The URL of the server's JSON Web Key Set."),
+    "registration_endpoint": String(doc="The dynamic client registration endpoint, if registration is open."),
+    "introspection_endpoint": String(),
+    "revocation_endpoint": String(),
+    "end_session_endpoint": String(),
+    "device_authorization_endpoint": String(),
+    "scopes_supported": ListOf(String()),
+    "response_types_supported": ListOf(String()),
+    "response_modes_supported": ListOf(String()),
+    "grant_types_supported": ListOf(String()),
+    "subject_types_supported": ListOf(String()),
+    "code_challenge_methods_supported": ListOf(String(), doc="The PKCE code challenge methods supported."),
+    "token_endpoint_auth_methods_supported": ListOf(String()),
+    "token_endpoint_auth_signing_alg_values_supported": ListOf(String()),
+    "id_token_signing_alg_values_supported": ListOf(String()),
+    "id_token_encryption_alg_values_supported": ListOf(String()),
+    "userinfo_signing_alg_values_supported": ListOf(String()),
+    "request_object_signing_alg_values_supported": ListOf(String()),
+    "dpop_signing_alg_values_supported": ListOf(String()),
+    "claims_supported": ListOf(String()),
+}, doc="An OAuth 2.0 authorization server metadata or OpenID Connect discovery document; the fields are named as in the document.")
+
+# modules/idp/scanner.go: SAMLEndpoint
+idp_saml_endpoint = SubRecord({
+    "binding": String(doc="The SAML binding, e.g. urn:oasis:names:tc:SAML:2.0:bindings:HTTP-Redirect."),
+    "location": String(doc="The URL of the service."),
+})
+
+# modules/idp/scanner.go: SAMLRole
+idp_saml_role = SubRecord({
+    "protocol_support": String(doc="The protocolSupportEnumeration attribute."),
+    "want_authn_requests_signed": Boolean(doc="True if the identity provider wants authentication requests signed."),
+    "authn_requests_signed": Boolean(doc="True if the service provider signs authentication requests."),
+    "want_assertions_signed": Boolean(doc="True if the service provider wants assertions signed."),
+    "name_id_formats": ListOf(String()),
+    "single_sign_on_services": ListOf(idp_saml_endpoint),
+    "single_logout_services": ListOf(idp_saml_endpoint),
+    "assertion_consumer_services": ListOf(idp_saml_endpoint),
+    "keys": ListOf(SubRecord({
+        "use": String(doc="signing or encryption, or absent if the key is for both."),
+        "fingerprint_sha256": String(doc="The SHA-256 fingerprint of the certificate, in hex."),
+        "common_name": String(doc="The certificate's subject common name."),
+        "not_after": DateTime(doc="When the certificate expires."),
+        "signature_algorithm": String(doc="The algorithm the certificate is signed with."),
+        "error": String(doc="The reason the certificate could not be parsed, if it could not."),
+    })),
+    "encryption_methods": ListOf(String(), doc="The algorithms the encryption keys are for."),
+})
+
+idp_scan_response = SubRecord({
+    "result": SubRecord({
+        "fetches": ListOf(idp_fetch, doc="The responses to the requests for each path."),
+        "oauth": ListOf(idp_oauth_metadata, doc="The OAuth and OpenID Connect metadata documents found."),
+        "saml": ListOf(SubRecord({
+            "path": String(doc="The path the document was found at."),
+            "valid_until": String(doc="The document's validUntil attribute."),
+            "signature_algorithm": String(doc="The algorithm of the document's own signature, if it is signed."),
+            "digest_algorithm": String(doc="The digest algorithm of the document's own signature, if it is signed."),
+            "entities": ListOf(SubRecord({
+                "entity_id": String(),
+                "idp": idp_saml_role,
+                "sp": idp_saml_role,
+                "signing_methods": ListOf(String(), doc="The signing algorithms the entity supports, from its metadata extensions."),
+                "digest_methods": ListOf(String(), doc="The digest algorithms the entity supports, from its metadata extensions."),
+            }), doc="The entities the document describes."),
+        }), doc="The SAML metadata documents found."),
+        "tls": zgrab2.tls_log,
+    })
+}, extends=zgrab2.base_scan_response)
+
+zschema.registry.register_schema("zgrab2-idp", idp_scan_response)
+
+zgrab2.register_scan_response_type("idp", idp_scan_response)
@@ -36,14 +36,50 @@ prelogin_options = SubRecord({
     "unknown": ListOf(unknown_prelogin_option),
 })
 
+browser_instance = SubRecord({
+    "server_name": WhitespaceAnalyzedString(),
+    "instance_name": WhitespaceAnalyzedString(),
+    "is_clustered": Boolean(),
+    "version": WhitespaceAnalyzedString(),
+    "tcp_port": Unsigned16BitInteger(),
+    "named_pipe": String(),
+    "other": SubRecord({}, doc="Other key / value pairs advertised for the instance."),
+})
+
+browser_results = SubRecord({
+    "instances": ListOf(browser_instance),
+    "raw": String(),
+}, doc="The response from the SQL Server Browser service.")
+
+encrypt_mode_probe = SubRecord({
+    "client_mode": Enum(values=ENCRYPT_MODES),
+    "server_mode": Enum(values=ENCRYPT_MODES),
+    "error": String(),
+})
+
+mssql_handshake = {
+    "version": WhitespaceAnalyzedString(),
+    "instance_name": WhitespaceAnalyzedString(),
+    "prelogin_options": prelogin_options,
+    "encrypt_mode": Enum(values=ENCRYPT_MODES, doc="The negotiated ENCRYPT_MODE with the server."),
+    "tls": zgrab2.tls_log,
+}
+
+alternate_endpoint = SubRecord({
+    "instance_name": WhitespaceAnalyzedString(),
+    "port": Unsigned16BitInteger(),
+    "status": Enum(values=zgrab2.STATUS_VALUES),
+    "result": SubRecord(mssql_handshake),
+    "error": String(),
+})
+
 mssql_scan_response = SubRecord({
-    "result": SubRecord({
-        "version": WhitespaceAnalyzedString(),
-        "instance_name": WhitespaceAnalyzedString(),
-        "prelogin_options": prelogin_options,
-        "encrypt_mode": Enum(values=ENCRYPT_MODES, doc="The negotiated ENCRYPT_MODE with the server."),
-        "tls": zgrab2.tls_log,
-    })
+    "result": SubRecord(dict(mssql_handshake, **{
+        "encrypt_mode_probes": ListOf(encrypt_mode_probe, doc="The server's response to a PRELOGIN with each client encrypt mode."),
+        "browser": browser_results,
+        "browser_error": String(),
+        "alternate_endpoints": ListOf(alternate_endpoint, doc="Results of scanning the other TCP ports advertised by the Browser service."),
+    }))
 }, extends=zgrab2.base_scan_response)
 
 zschema.registry.register_schema("zgrab2-mssql", mssql_scan_response)
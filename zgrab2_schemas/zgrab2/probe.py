@@ -0,0 +1,28 @@
+# zschema sub-schema for zgrab2's probe module
+# Registers zgrab2-probe globally, and probe with the main zgrab2 schema.
+from zschema.leaves import *
+from zschema.compounds import *
+import zschema.registry
+
+from . import zgrab2
+
+probe_scan_response = SubRecord({
+    "result": SubRecord({
+        "service": String(doc="The service name from the matching match / softmatch line."),
+        "soft_match": Boolean(doc="True if the service was only identified by a softmatch line."),
+        "product": WhitespaceAnalyzedString(),
+        "version": WhitespaceAnalyzedString(),
+        "info": WhitespaceAnalyzedString(),
+        "hostname": String(),
+        "os": WhitespaceAnalyzedString(),
+        "device_type": String(),
+        "cpe": ListOf(String()),
+        "probe": String(doc="The name of the probe whose response matched."),
+        "response": Binary(doc="The response that matched, or the first response if none matched."),
+        "responses": SubRecord({}, doc="Map of probe name to the response received. Debug only."),  # TODO FIXME: unconstrained dict
+    })
+}, extends=zgrab2.base_scan_response)
+
+zschema.registry.register_schema("zgrab2-probe", probe_scan_response)
+
+zgrab2.register_scan_response_type("probe", probe_scan_response)
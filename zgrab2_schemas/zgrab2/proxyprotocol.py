@@ -0,0 +1,29 @@
+# zschema sub-schema for zgrab2's proxyprotocol module
+# Registers zgrab2-proxyprotocol globally, and proxyprotocol with the main zgrab2 schema.
+from zschema.leaves import *
+from zschema.compounds import *
+import zschema.registry
+
+from . import zgrab2
+
+# modules/proxyprotocol/scanner.go: Exchange
+proxyprotocol_exchange = SubRecord({
+    "response": Binary(doc="What the server sent back."),
+    "error": String(doc="Why the connection or exchange failed, if it did."),
+})
+
+proxyprotocol_scan_response = SubRecord({
+    "result": SubRecord({
+        "accepted": Boolean(doc="True if the server accepted a PROXY header."),
+        "required": Boolean(doc="True if the server rejected the payload without a PROXY header, but accepted it with one."),
+        "client_address_sensitive": Boolean(doc="True if the server responded differently when the PROXY header claimed the spoofed address."),
+        "spoofed_address_reflected": Boolean(doc="True if the response to the spoofed header contains the spoofed address."),
+        "baseline": proxyprotocol_exchange,
+        "proxied": proxyprotocol_exchange,
+        "spoofed": proxyprotocol_exchange,
+    })
+}, extends=zgrab2.base_scan_response)
+
+zschema.registry.register_schema("zgrab2-proxyprotocol", proxyprotocol_scan_response)
+
+zgrab2.register_scan_response_type("proxyprotocol", proxyprotocol_scan_response)
@@ -34,12 +34,44 @@ negotiate_log = SubRecord(extended(header_log, {
     'system_time': Unsigned32BitInteger(),
     'server_start_time': Unsigned32BitInteger(),
     'authentication_types': ListOf(String()),
+    'raw_response': Binary(),
+    'negotiate_contexts': SubRecord({
+        'preauth_integrity': SubRecord({
+            'hash_algorithms': ListOf(Unsigned16BitInteger()),
+            'salt': Binary(),
+        }),
+        'ciphers': ListOf(Unsigned16BitInteger()),
+        'compression': SubRecord({
+            'algorithms': ListOf(Unsigned16BitInteger()),
+            'flags': Unsigned32BitInteger(),
+        }),
+        'signing_algorithms': ListOf(Unsigned16BitInteger()),
+        'transport_capabilities': Unsigned32BitInteger(),
+        'contexts': ListOf(SubRecord({
+            'type': Unsigned16BitInteger(),
+            'data': Binary(),
+        })),
+        'error': String(),
+    }),
 }))
 
 session_setup_log = SubRecord(extended(header_log, {
     'setup_flags': Unsigned16BitInteger(),
     'target_name': String(),
     'negotiate_flags': Unsigned32BitInteger(),
+    'os_version': SubRecord({
+        'major_version': Unsigned8BitInteger(),
+        'minor_version': Unsigned8BitInteger(),
+        'build': Unsigned16BitInteger(),
+        'ntlm_revision': Unsigned8BitInteger(),
+    }),
+    'target_info': SubRecord({
+        'netbios_computer_name': String(),
+        'netbios_domain_name': String(),
+        'dns_computer_name': String(),
+        'dns_domain_name': String(),
+        'dns_tree_name': String(),
+    }),
 }))
 
 smb_scan_response = SubRecord({
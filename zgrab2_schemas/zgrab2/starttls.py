@@ -0,0 +1,21 @@
+# zschema sub-schema for zgrab2's starttls module
+# Registers zgrab2-starttls globally, and starttls with the main zgrab2 schema.
+from zschema.leaves import *
+from zschema.compounds import *
+import zschema.registry
+
+from . import zgrab2
+
+starttls_scan_response = SubRecord({
+    "result": SubRecord({
+        "protocol": String(doc="The protocol whose STARTTLS exchange was performed."),
+        "banner": String(doc="What the server sent on connecting, or in response to the stream header for XMPP."),
+        "capabilities": String(doc="The server's response to the command listing its capabilities (e.g. EHLO or CAPA), or the stream features for XMPP."),
+        "starttls": String(doc="The server's response to the STARTTLS command. For LDAP, the result code name and diagnostic message."),
+        "tls": zgrab2.tls_log,
+    })
+}, extends=zgrab2.base_scan_response)
+
+zschema.registry.register_schema("zgrab2-starttls", starttls_scan_response)
+
+zgrab2.register_scan_response_type("starttls", starttls_scan_response)
@@ -19,6 +19,12 @@ telnet_scan_response = SubRecord({
         "do": ListOf(telnet_option),
         "wont": ListOf(telnet_option),
         "dont": ListOf(telnet_option),
+        "terminal_type": String(),
+        "environment": ListOf(SubRecord({
+            "type": String(),
+            "name": String(),
+            "value": String(),
+        })),
     })
 }, extends=zgrab2.base_scan_response)
 
@@ -0,0 +1,21 @@
+# zschema sub-schema for zgrab2's udp module
+# Registers zgrab2-udp globally, and udp with the main zgrab2 schema.
+from zschema.leaves import *
+from zschema.compounds import *
+import zschema.registry
+
+from . import zgrab2
+
+udp_scan_response = SubRecord({
+    "result": SubRecord({
+        "payload": String(doc="The name of the built-in payload sent, with --payload."),
+        "attempts": Unsigned32BitInteger(doc="The number of times the payload was sent."),
+        "responses": ListOf(Binary(), doc="The replies received, in order."),
+        "matched": Boolean(doc="True if --pattern matched one of the replies."),
+        "submatches": ListOf(String(), doc="The pattern's capture groups in the first matching reply."),
+    })
+}, extends=zgrab2.base_scan_response)
+
+zschema.registry.register_schema("zgrab2-udp", udp_scan_response)
+
+zgrab2.register_scan_response_type("udp", udp_scan_response)
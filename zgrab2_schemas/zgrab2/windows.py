@@ -0,0 +1,30 @@
+# zschema sub-schema for zgrab2's windows module
+# Registers zgrab2-windows globally, and windows with the main zgrab2 schema.
+from zschema.leaves import *
+from zschema.compounds import *
+import zschema.registry
+
+from . import zgrab2
+from . import smb
+from . import mssql
+
+windows_scan_response = SubRecord({
+    "result": SubRecord({
+        "services": ListOf(String(), doc="The modules whose scans succeeded."),
+        "smbv1_support": Boolean(),
+        "smb_dialect": Unsigned16BitInteger(doc="The SMB2 dialect negotiated with the server."),
+        "smb_signing_required": Boolean(),
+        "ntlm_target_name": String(doc="The target name from the SMB NTLM challenge."),
+        "ntlm": zgrab2.ntlm_correlation,
+        "mssql_version": WhitespaceAnalyzedString(),
+        "mssql_instance_name": WhitespaceAnalyzedString(),
+        "modules": SubRecord({
+            "smb": smb.smb_scan_response,
+            "mssql": mssql.mssql_scan_response,
+        }, doc="The full scan response from each module that was run."),
+    })
+}, extends=zgrab2.base_scan_response)
+
+zschema.registry.register_schema("zgrab2-windows", windows_scan_response)
+
+zgrab2.register_scan_response_type("windows", windows_scan_response)
@@ -27,11 +27,44 @@ def FlagsSet(keys, **kwargs):
     }, **kwargs)
 
 
+# zgrab2/ntlm.go: NTLMCorrelation
+ntlm_correlation = SubRecord({
+    "target_name": String(doc="The target name of the NTLM challenge."),
+    "netbios_computer_name": String(),
+    "netbios_domain_name": String(),
+    "dns_computer_name": String(),
+    "dns_domain_name": String(),
+    "dns_tree_name": String(),
+    "os_version": String(doc="The operating system version in the challenge, as MAJOR.MINOR.BUILD."),
+    "sources": ListOf(String(), doc="The scans that gave NTLM info."),
+    "inconsistent": Boolean(doc="True if the services gave different values for any field, which suggests NAT or a load balancer."),
+    "conflicts": ListOf(SubRecord({
+        "field": String(doc="The field whose values differ."),
+        # "values" maps the name of each scan to its value.
+    }), doc="The fields whose values differ."),
+}, doc="The NTLM info from each of the target's services, merged.")
+
 # zgrab2/processing.go: Grab
 grab_result = Record({
     # TODO: ip may be required; see https://github.com/zmap/zgrab2/issues/104
     "ip": IPv4Address(required=False, doc="The IP address of the target."),
     "domain": String(required=False, doc="The domain name of the target, if available."),
+    # "metadata" is copied untouched from the input's METADATA field, and may
+    # be any JSON value, so it is not described here.
+    "location": SubRecord({
+        "country": String(doc="The ISO 3166-1 code of the country the address is located in, from --geoip-db."),
+        "asn": Unsigned32BitInteger(doc="The number of the autonomous system announcing the address, from --asn-db."),
+        "as_name": String(doc="The name of the organization owning the autonomous system."),
+    }, required=False, doc="Where the target's address is located, and the autonomous system announcing it."),
+    "lookup": SubRecord({
+        "domain": String(doc="The domain from the input record."),
+        "type": String(doc="The type of the record the target was found in: MX or SRV."),
+        "name": String(doc="The name queried."),
+        "priority": Unsigned16BitInteger(doc="The MX preference or SRV priority of the record."),
+        "weight": Unsigned16BitInteger(doc="The SRV weight of the record."),
+        "port": Unsigned16BitInteger(doc="The port given by the SRV record."),
+    }, required=False, doc="The DNS record the target was found in, with --lookup-domain."),
+    "ntlm": ntlm_correlation,
     "data": SubRecord(scan_response_types, doc="The scan data for this host."),
 })
 
@@ -45,6 +78,18 @@ STATUS_VALUES = [
   "protocol-error",
   "application-error",
   "unknown-error",
+  "internal-error",
+  "target-timeout",
+  "proxy-error",
+]
+
+REJECTION_VALUES = [
+  "encryption-required",
+  "encryption-unsupported",
+  "access-denied",
+  "too-many-connections",
+  "version-unsupported",
+  "unspecified",
 ]
 
 # zgrab2/module.go: ScanResponse
@@ -53,14 +98,195 @@ base_scan_response = SubRecord({
     "protocol": String(doc="The identifier of the protocol being scanned."),
     "timestamp": DateTime(doc="The time the scan was started."),
     "result": SubRecord({}, required=False),  # This is overridden by the protocols' implementations
-    "error": String(required=False, doc="If the status was not success, error may contain information about the failure.")
+    "error": String(required=False, doc="If the status was not success, error may contain information about the failure."),
+    "start_time": DateTime(required=False, doc="The time the scan started, to the nanosecond."),
+    "end_time": DateTime(required=False, doc="The time the scan ended, to the nanosecond."),
+    "sequence": Signed64BitInteger(required=False, doc="The number of the result in the order the scans of all modules ended, starting at 1."),
+    "rejection": Enum(values=REJECTION_VALUES, required=False, doc="If the status is application-error because the service refused the probe, the reason it did."),
+    "proxy_error": SubRecord({
+        "hop": Unsigned16BitInteger(doc="The index of the proxy that failed, from 1."),
+        "proxy": String(doc="The proxy's URL, without credentials."),
+        "stage": Enum(values=["connect", "auth", "request"], doc="What failed: connecting to the first proxy, authenticating, or the proxy connecting to the next hop."),
+        "next": String(doc="The address the proxy was asked to connect to."),
+        "reply": String(doc="The SOCKS reply or HTTP status with which the proxy refused the request."),
+    }, required=False, doc="The failure of a hop in the --proxy chain, if the scan failed because of one."),
+    "ics_safe": Boolean(doc="True if the scan was run with --ics-safe, so that each message the module sent was checked to be an identification request."),
+    "address_family": Enum(values=["ipv4", "ipv6"], required=False, doc="The address family of the connection made by the scan."),
+    "nat64_address": String(doc="The IPv6 address the scan connected to, if it embedded the target's IPv4 address in the --nat64-prefix."),
+    "port": Unsigned16BitInteger(required=False, doc="The port that was scanned."),
+    "transport": Enum(values=["tcp", "udp"], required=False, doc="The transport that produced the result, for modules that support more than one."),
+    "resolution": SubRecord({
+        "name": String(doc="The name that was resolved."),
+        "addresses": ListOf(String(), doc="The addresses the name resolved to."),
+        "resolver": String(doc="The nameserver that answered, or 'system' for the system resolver."),
+        "transport": Enum(values=["udp", "tcp", "tls"], doc="The transport used to query the nameserver."),
+        "cached": Boolean(doc="True if the result came from zgrab2's DNS cache."),
+    }, required=False, doc="How the target's name was resolved, if it was scanned by name."),
+    "timing": SubRecord({
+        "resolve_us": Unsigned32BitInteger(doc="Microseconds spent resolving the target's name."),
+        "connect_us": Unsigned32BitInteger(doc="Microseconds spent establishing TCP connections."),
+        "tls_handshake_us": Unsigned32BitInteger(doc="Microseconds spent in TLS handshakes."),
+        "application_us": Unsigned32BitInteger(doc="Microseconds spent in the module's own protocol exchange."),
+        "total_us": Unsigned32BitInteger(doc="Microseconds taken by the whole scan."),
+    }, required=False, doc="The time spent in each phase of the scan, summed over all of its connections."),
+    "amplification": SubRecord({
+        "request_bytes": Unsigned32BitInteger(doc="UDP payload bytes sent to the target."),
+        "request_packets": Unsigned32BitInteger(doc="Datagrams sent to the target."),
+        "response_bytes": Unsigned32BitInteger(doc="UDP payload bytes received from the target."),
+        "response_packets": Unsigned32BitInteger(doc="Datagrams received from the target."),
+        "ratio": Float(doc="response_bytes / request_bytes, the bandwidth amplification factor."),
+        "fragmented": Boolean(doc="True if a response datagram was too large for a 1500-byte IP packet."),
+    }, required=False, doc="The UDP traffic of the scan, for measuring reflection amplification."),
+    "product": SubRecord({
+        "vendor": String(doc="The product's vendor, using its CPE name where known."),
+        "name": String(doc="The product's name, using its CPE name where known."),
+        "version": String(doc="The product's version, as reported by the target."),
+        "cpe": String(doc="The CPE 2.3 formatted string naming the product, if the vendor is known."),
+        "cves": ListOf(String(), doc="The CVEs affecting the product's version, from the NVD snapshot given with --cve-file."),
+    }, required=False, doc="The software found by the scan, for modules that can identify it."),
+    "clock_skew": SubRecord({
+        "source": String(doc="The protocol field the target's time came from, e.g. http_date, smb_system_time, ntp_receive_timestamp or tls_server_random."),
+        "server_time": DateTime(doc="The time reported by the target."),
+        "skew_ms": Signed64BitInteger(doc="The target's clock minus the scanner's, in milliseconds."),
+        "uncertainty_ms": Unsigned32BitInteger(doc="The bound on the error in skew_ms, in milliseconds."),
+    }, required=False, doc="The skew of the target's clock, for modules whose protocol reports the time."),
     # TODO: error_component? domain?
 })
 
+# zgrab2/tlsech.go: ECHConfig
+ech_config = SubRecord({
+    "version": Unsigned16BitInteger(doc="The ECHConfig version; the other fields are only given for RFC 9849's, 0xfe0d."),
+    "config_id": Unsigned8BitInteger(doc="The identifier of the config."),
+    "kem_id": Unsigned16BitInteger(doc="The HPKE KEM of the public key."),
+    "public_key": Binary(doc="The public key to encrypt inner ClientHellos to."),
+    "cipher_suites": ListOf(SubRecord({
+        "kdf_id": Unsigned16BitInteger(doc="The HPKE KDF."),
+        "aead_id": Unsigned16BitInteger(doc="The HPKE AEAD."),
+    }), doc="The HPKE cipher suites the server accepts."),
+    "maximum_name_length": Unsigned8BitInteger(doc="The longest server name the config is for, for padding."),
+    "public_name": String(doc="The name of the client-facing server, sent in the outer ClientHello."),
+})
+
+# zgrab2/tlsrevocation.go: RevocationCheck
+revocation_check = SubRecord({
+    "url": String(doc="The URL of the OCSP responder or CRL."),
+    "status": String(doc="The certificate's status: good, revoked or unknown."),
+    "revoked_at": DateTime(doc="When the certificate was revoked, if it was."),
+    "revocation_reason": String(doc="Why the certificate was revoked, e.g. key_compromise, if a reason was given."),
+    "this_update": DateTime(doc="When the answer was made."),
+    "next_update": DateTime(doc="When the next answer will be made."),
+    "verified": Boolean(doc="True if the answer is signed by the certificate's issuer, or by an OCSP responder certificate it issued."),
+    "size": Unsigned32BitInteger(doc="The length of the OCSP response or CRL, in bytes."),
+    "latency_us": Unsigned32BitInteger(doc="The time taken to fetch the answer, in microseconds; for cached answers, when it was first fetched."),
+    "cached": Boolean(doc="True if the answer was fetched for an earlier target."),
+    "error": String(doc="The reason no answer was obtained, if none was."),
+})
+
+# zgrab2/tlssct.go: SCT
+sct = SubRecord({
+    "source": String(doc="Where the SCT was found: tls_extension, ocsp_staple or certificate."),
+    "version": Unsigned8BitInteger(doc="The SCT version; 0 is v1."),
+    "log_id": Binary(doc="The log's ID, the SHA-256 digest of its key."),
+    "log_description": String(doc="The log's description, if it is in the --ct-log-list."),
+    "log_operator": String(doc="The log's operator, if it is in the --ct-log-list."),
+    "log_state": String(doc="The log's state, e.g. usable or retired, if it is in the --ct-log-list."),
+    "timestamp": DateTime(doc="When the log promised to include the certificate."),
+    "extensions": Binary(doc="The SCT's extensions."),
+    "signature_algorithm": String(doc="The signature's algorithm, e.g. ecdsa_sha256."),
+    "valid": Boolean(doc="True if the SCT is signed by its log, and its timestamp is not in the future."),
+    "error": String(doc="The reason the SCT is not valid, if it is not."),
+})
+
 # zgrab2/tls.go: TLSLog
 tls_log = SubRecord({
     "handshake_log": zcrypto.TLSHandshake(doc="The TLS handshake log."),
-    "heartbleed_log": zcrypto.HeartbleedLog(doc="The heartbleed scan log, if heartbleed scanning was enabled; otherwise, absent.")
+    "heartbleed_log": zcrypto.HeartbleedLog(doc="The heartbleed scan log, if heartbleed scanning was enabled; otherwise, absent."),
+    "fingerprints": SubRecord({
+        "ja3s": String(doc="The MD5 hash of ja3s_string."),
+        "ja3s_string": String(doc="The server's JA3S string: the ServerHello's version, cipher suite and extensions."),
+        "ja4s": String(doc="The server's JA4S fingerprint."),
+        "ja4": String(doc="The JA4 fingerprint of the ClientHello sent."),
+    }, doc="Fingerprints of the handshake, absent if there was no ServerHello."),
+    "resumption": SubRecord({
+        "method": String(doc="How resumption was attempted: session_ticket, session_id, or none if the server offered no session."),
+        "resumed": Boolean(doc="True if the server resumed the session."),
+        "ticket_lifetime_hint": Unsigned32BitInteger(doc="The session ticket's lifetime hint, in seconds."),
+        "ticket_length": Unsigned32BitInteger(doc="The length of the session ticket, in bytes."),
+        "error": String(doc="The reason the second handshake failed, if it did."),
+    }, doc="The result of trying to resume the session, with --resumption."),
+    "enumeration": SubRecord({
+        "versions": ListOf(SubRecord({
+            "version": String(doc="The protocol version, e.g. TLSv1.2."),
+            "cipher_suites": ListOf(zcrypto.CipherSuite(), doc="The cipher suites accepted with the version, in the order the server chose them."),
+        }), doc="The protocol versions the server accepts, oldest first."),
+        "groups": ListOf(String(), doc="The key exchange groups the server accepts, in the order it chose them."),
+        "signature_algorithms": ListOf(String(), doc="The signature algorithms the server accepts for TLS 1.2 key exchanges, in the order it chose them."),
+        "error": String(doc="The reason the enumeration stopped early, if it did."),
+    }, doc="What the server accepts, with --tls-enumerate."),
+    "key_exchange": SubRecord({
+        "offered": ListOf(String(), doc="The groups offered, with a key share for each, in order of preference."),
+        "group": String(doc="The group the server selected, e.g. X25519MLKEM768."),
+        "post_quantum": Boolean(doc="True if the group is a post-quantum (ML-KEM or Kyber) group or hybrid."),
+        "hello_retry_request": Boolean(doc="True if the server asked for a key share for the group in a HelloRetryRequest."),
+        "server_share_length": Unsigned32BitInteger(doc="The length of the server's key share, in bytes."),
+        "error": String(doc="The reason the handshake failed, if it did."),
+    }, doc="The key exchange group the server selects, with --tls-key-exchange."),
+    "ech": SubRecord({
+        "config_source": String(doc="Where the ECHConfigs came from: dns for the server name's HTTPS record, or flag for --ech-config."),
+        "configs": ListOf(ech_config, doc="The ECHConfigs found."),
+        "lookup_error": String(doc="The reason the HTTPS record could not be looked up, if it could not."),
+        "grease": Boolean(doc="True if no usable ECHConfig was found, and GREASE ECH was sent instead."),
+        "accepted": Boolean(doc="True if the server accepted the inner ClientHello."),
+        "retry_configs": ListOf(ech_config, doc="The ECHConfigs the server sent for clients to retry with, when it did not accept ECH."),
+        "error": String(doc="The reason the handshake failed, if it did."),
+    }, doc="The result of offering Encrypted Client Hello, with --ech."),
+    "revocation": SubRecord({
+        "status": String(doc="revoked if any OCSP responder or CRL says the certificate is revoked, good if any says it is not, and unknown otherwise."),
+        "issuer_source": String(doc="Where the issuer's certificate was found: chain if the server sent it, or aia if it was fetched from the CA Issuers URL."),
+        "ocsp": ListOf(revocation_check, doc="The answers of the certificate's OCSP responders."),
+        "crl": ListOf(revocation_check, doc="The answers of the certificate's CRL distribution points."),
+        "error": String(doc="The reason the check was incomplete, if it was."),
+    }, doc="The revocation status of the server's certificate, with --check-revocation."),
+    "scts": ListOf(sct, doc="The Signed Certificate Timestamps for the server's certificate, with --check-scts."),
+    "client_certificate": SubRecord({
+        "requested": Boolean(doc="True if the server asked for a client certificate."),
+        "certificate_types": ListOf(String(), doc="The kinds of certificate the server asked for, e.g. rsa_sign or ecdsa_sign."),
+        "signature_algorithms": ListOf(String(), doc="The signature algorithms the server accepts for the client's CertificateVerify."),
+        "certificate_authorities": ListOf(String(), doc="The distinguished names, as in RFC 4514, of the CAs whose certificates the server accepts."),
+        "sent": Boolean(doc="True if the certificate given with --tls-client-cert was presented."),
+        "accepted": Boolean(doc="True if the certificate was presented and the server completed the handshake."),
+        "error": String(doc="The handshake error, if the certificate was presented and the handshake failed."),
+    }, doc="The server's request for a client certificate, if it made one or --tls-client-cert was presented."),
+    "tls_behavior": SubRecord({
+        "max_version": String(doc="The highest version the server accepts, up to TLSv1.3."),
+        "renegotiation": SubRecord({
+            "secure": Boolean(doc="True if the server supports secure renegotiation (RFC 5746)."),
+            "client_initiated": String(doc="How the server answered a ClientHello sent after a complete TLS 1.2 handshake: accepted, refused, closed or ignored."),
+            "alert": Unsigned8BitInteger(doc="The alert the server refused renegotiation with, usually 100 (no_renegotiation)."),
+            "error": String(doc="The reason the TLS 1.2 handshake to renegotiate failed, if it did."),
+        }, doc="How the server handles renegotiation, if it accepts TLS 1.2 or below."),
+        "fallback": SubRecord({
+            "version": String(doc="The highest version offered, below the server's highest."),
+            "rejected": Boolean(doc="True if the server refused the ClientHello with TLS_FALLBACK_SCSV with an inappropriate_fallback alert."),
+            "alert": Unsigned8BitInteger(doc="Any other alert the server refused with."),
+            "error": String(doc="The reason the probe failed, if it did."),
+        }, doc="How the server answers a downgraded ClientHello with TLS_FALLBACK_SCSV (RFC 7507)."),
+        "error": String(doc="The reason the probes could not be completed, if they could not."),
+    }, doc="How the server handles renegotiation and downgrades, with --tls-behavior."),
+    "server_names": ListOf(SubRecord({
+        "server_name": String(doc="The name sent in the SNI extension."),
+        "server_certificates": zcrypto.ServerCertificates(doc="The certificates the server sent."),
+        "same_certificate": Boolean(doc="True if the server's certificate is the one it sent in the scan's own handshake."),
+        "error": String(doc="The reason the handshake failed, if it did."),
+    }), doc="The results of handshakes sending each of --tls-server-names."),
+    "dtls": SubRecord({
+        "version": String(doc="The version the server selected, e.g. DTLSv1.2."),
+        "hello_verify_request": Boolean(doc="True if the server sent a DTLS 1.2 HelloVerifyRequest before its ServerHello."),
+        "hello_retry_request": Boolean(doc="True if the server sent a DTLS 1.3 HelloRetryRequest before its ServerHello."),
+        "cookie": Binary(doc="The cookie the server asked the client to return."),
+        "retransmissions": Unsigned32BitInteger(doc="The number of times a ClientHello was sent again because the server's flight did not arrive in time."),
+        "fragmented": Boolean(doc="True if the server split a handshake message across records."),
+    }, doc="The parts of the handshake particular to DTLS, for handshakes over UDP."),
 })
 
 